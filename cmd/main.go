@@ -8,10 +8,26 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/cgang/file-hub/pkg/backfill"
+	"github.com/cgang/file-hub/pkg/bandwidth"
+	"github.com/cgang/file-hub/pkg/classify"
 	"github.com/cgang/file-hub/pkg/config"
 	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/digest"
+	"github.com/cgang/file-hub/pkg/federation"
+	"github.com/cgang/file-hub/pkg/hooks"
+	"github.com/cgang/file-hub/pkg/links"
+	"github.com/cgang/file-hub/pkg/mail"
+	"github.com/cgang/file-hub/pkg/maint"
+	"github.com/cgang/file-hub/pkg/notify"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/search"
+	"github.com/cgang/file-hub/pkg/skeleton"
 	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/sync"
 	"github.com/cgang/file-hub/pkg/users"
+	"github.com/cgang/file-hub/pkg/watch"
+	"github.com/cgang/file-hub/pkg/watermark"
 	"github.com/cgang/file-hub/pkg/web"
 )
 
@@ -26,8 +42,26 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	db.Init(ctx, cfg.Database.URI)
+	db.SetQueryBudget(cfg.Database.QueryBudget)
 	stor.Init(ctx, cfg)
-	users.Init(ctx, cfg.Realm)
+	perm.Init(cfg)
+	sync.Init(cfg)
+	hooks.Start(ctx)
+	watch.Start(ctx)
+	search.Start(ctx, cfg)
+	classify.Start(ctx, cfg)
+	backfill.Start(ctx, cfg)
+	users.Init(ctx, cfg)
+	federation.Init(cfg)
+	links.Init(cfg)
+	watermark.Init(cfg)
+	mail.Init(cfg)
+	notify.Start(ctx, cfg)
+	bandwidth.Init(cfg)
+	bandwidth.Start(ctx)
+	digest.Init(cfg)
+	skeleton.Init(cfg)
+	maint.Start(ctx, cfg)
 
 	web.Start(ctx, cfg)
 
@@ -47,8 +81,8 @@ func main() {
 
 	web.Stop(ctx)
 
-	// Stop gRPC server
-	if cfg.Web.GRPCPort > 0 {
+	// Stop gRPC server, which also backs gRPC-Web
+	if cfg.Web.GRPCPort > 0 || cfg.Web.GRPCWeb {
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 		if err := web.StopGRPCServer(shutdownCtx); err != nil {
 			log.Printf("Error stopping gRPC server: %v", err)
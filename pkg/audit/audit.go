@@ -0,0 +1,53 @@
+// Package audit records security relevant events in the audit log.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// Record appends an entry to the audit log.
+// Failures are logged rather than returned so auditing never blocks the audited operation.
+func Record(ctx context.Context, entry *model.AuditEntry) {
+	if db.GetDB() == nil {
+		log.Printf("Audit log unavailable, dropping %s entry", entry.Action)
+		return
+	}
+
+	if err := db.CreateAuditEntry(ctx, entry); err != nil {
+		log.Printf("Failed to record audit entry %s: %s", entry.Action, err)
+	}
+}
+
+// List returns audit log entries, newest first
+func List(ctx context.Context, limit, offset int) ([]*model.AuditEntry, error) {
+	return db.ListAuditEntries(ctx, limit, offset)
+}
+
+// Operation is everything one request recorded: the changes it made to files and its audit log entries
+type Operation struct {
+	ID      string              `json:"operation_id"`
+	Changes []*model.ChangeLog  `json:"changes"`
+	Entries []*model.AuditEntry `json:"entries"`
+}
+
+// GetOperation returns what the request with the given operation ID recorded, or sql.ErrNoRows when it
+// recorded nothing
+func GetOperation(ctx context.Context, id string) (*Operation, error) {
+	changes, err := db.ListChangesByOperation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := db.ListAuditByOperation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 && len(entries) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &Operation{ID: id, Changes: changes, Entries: entries}, nil
+}
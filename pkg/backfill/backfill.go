@@ -0,0 +1,280 @@
+// Package backfill computes the checksum of files stored without one, such as those found by a repository
+// scan or written over WebDAV, so that sync clients can compare them. Files are read in the background at
+// a limited rate, and their content type is filled in on the way if missing. The content type of other
+// files recorded without one is asked from the storage backend, which lookups and listings never wait for.
+package backfill
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+)
+
+// batchSize is how many files are listed at once during a pass
+const batchSize = 100
+
+// settings holds the backfill settings, set by Start
+var settings config.BackfillConfig
+
+// Progress tells how far the backfill got since the server started
+type Progress struct {
+	Enabled  bool       `json:"enabled"`
+	Running  bool       `json:"running"`             // whether a pass is going on
+	Pending  int        `json:"pending"`             // files still without a checksum
+	Hashed   int64      `json:"hashed"`              // files given a checksum
+	Bytes    int64      `json:"bytes"`               // bytes read to hash them
+	Typed    int64      `json:"typed"`               // files given the content type sniffed from their content
+	Failed   int64      `json:"failed"`              // files that could not be read, retried on the next pass
+	LastPass *time.Time `json:"last_pass,omitempty"` // when the last pass ended
+}
+
+var (
+	progressLock sync.Mutex
+	progress     Progress
+)
+
+// Start hashes files without a checksum at the configured interval until ctx is done.
+// A zero interval disables the backfill.
+func Start(ctx context.Context, cfg *config.Config) {
+	settings = cfg.Backfill
+	if settings.Interval <= 0 {
+		log.Printf("Checksum backfill disabled")
+		return
+	}
+	progress.Enabled = true
+
+	go func() {
+		ticker := time.NewTicker(settings.Interval)
+		defer ticker.Stop()
+
+		for {
+			runPass(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Status returns the progress of the backfill with the number of files still to hash
+func Status(ctx context.Context) (*Progress, error) {
+	pending, err := db.CountUnchecksummed(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	progressLock.Lock()
+	defer progressLock.Unlock()
+
+	status := progress
+	status.Pending = pending
+	return &status, nil
+}
+
+// runPass hashes every file without a checksum once, then detects the content type of every file still
+// without one. Files failing are skipped until the next pass.
+func runPass(ctx context.Context) {
+	update(func(p *Progress) { p.Running = true })
+	defer update(func(p *Progress) {
+		now := time.Now()
+		p.Running, p.LastPass = false, &now
+	})
+
+	repos := map[int]*model.Repository{}
+	hashPass(ctx, repos)
+	typePass(ctx, repos)
+}
+
+// hashPass hashes every file without a checksum
+func hashPass(ctx context.Context, repos map[int]*model.Repository) {
+	afterID := 0
+	for ctx.Err() == nil {
+		files, err := db.ListUnchecksummed(ctx, afterID, batchSize)
+		if err != nil {
+			log.Printf("Failed to list files without checksum: %s", err)
+			return
+		}
+
+		for _, file := range files {
+			afterID = file.ID
+			if err := backfill(ctx, repos, file); err != nil {
+				log.Printf("Failed to compute checksum of %s: %s", file.Path, err)
+				update(func(p *Progress) { p.Failed++ })
+			}
+		}
+		if len(files) < batchSize {
+			return
+		}
+	}
+}
+
+// typePass records the content type sniffed from the content of every file without one
+func typePass(ctx context.Context, repos map[int]*model.Repository) {
+	afterID := 0
+	for ctx.Err() == nil {
+		files, err := db.ListUntyped(ctx, afterID, batchSize)
+		if err != nil {
+			log.Printf("Failed to list files without content type: %s", err)
+			return
+		}
+
+		for _, file := range files {
+			afterID = file.ID
+			if err := detectType(ctx, repos, file); err != nil {
+				log.Printf("Failed to detect content type of %s: %s", file.Path, err)
+				update(func(p *Progress) { p.Failed++ })
+			}
+		}
+		if len(files) < batchSize {
+			return
+		}
+	}
+}
+
+// detectType stores the content type sniffed from the start of a file
+func detectType(ctx context.Context, repos map[int]*model.Repository, file *model.FileObject) error {
+	repo, err := repository(ctx, repos, file.RepoID)
+	if err != nil {
+		return err
+	}
+
+	mimeType, err := stor.DetectContentType(ctx, repo, file)
+	if err != nil {
+		return err
+	}
+
+	updated, err := db.SetContentType(ctx, file.ID, mimeType)
+	if err != nil {
+		return err
+	}
+	if updated {
+		update(func(p *Progress) { p.Typed++ })
+	}
+	return nil
+}
+
+// repository returns a repository by ID, looking each up once per pass
+func repository(ctx context.Context, repos map[int]*model.Repository, id int) (*model.Repository, error) {
+	if repo, ok := repos[id]; ok {
+		return repo, nil
+	}
+
+	repo, err := db.GetRepositoryByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	repos[id] = repo
+	return repo, nil
+}
+
+// backfill reads a file to store its checksum, and its content type if it has none
+func backfill(ctx context.Context, repos map[int]*model.Repository, file *model.FileObject) error {
+	repo, err := repository(ctx, repos, file.RepoID)
+	if err != nil {
+		return err
+	}
+
+	reader, err := stor.OpenFile(ctx, &model.Resource{Repo: repo, Path: file.Path})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	head := &sniffer{}
+	n, err := io.Copy(io.MultiWriter(hash, head), newThrottle(ctx, reader, settings.RateLimit))
+	update(func(p *Progress) { p.Bytes += n })
+	if err != nil {
+		return err
+	}
+	if n != file.Size {
+		return nil // written meanwhile, with a checksum of its own or to be hashed on the next pass
+	}
+
+	mimeType, err := stor.ContentType(ctx, repo, file)
+	if err != nil {
+		return err
+	}
+	if file.MimeType == nil && mimeType == "application/octet-stream" {
+		mimeType = stor.SniffContentType(file.Name, head.data)
+	}
+
+	updated, err := db.SetChecksum(ctx, file.ID, n, hex.EncodeToString(hash.Sum(nil)), mimeType)
+	if err != nil {
+		return fmt.Errorf("failed to store checksum: %w", err)
+	}
+	if updated {
+		update(func(p *Progress) { p.Hashed++ })
+	}
+	return nil
+}
+
+func update(f func(*Progress)) {
+	progressLock.Lock()
+	defer progressLock.Unlock()
+	f(&progress)
+}
+
+// sniffer keeps the start of what is written to it, enough to detect the content type
+type sniffer struct {
+	data []byte
+}
+
+func (s *sniffer) Write(p []byte) (int, error) {
+	if rest := 512 - len(s.data); rest > 0 {
+		s.data = append(s.data, p[:min(rest, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// throttle limits the rate a reader is read at, waiting whenever reads got ahead of it
+type throttle struct {
+	ctx   context.Context
+	r     io.Reader
+	rate  int64
+	start time.Time
+	n     int64
+}
+
+// newThrottle returns r limited to rate bytes per second, or r itself if rate is not positive
+func newThrottle(ctx context.Context, r io.Reader, rate int64) io.Reader {
+	if rate <= 0 {
+		return r
+	}
+	return &throttle{ctx: ctx, r: r, rate: rate, start: time.Now()}
+}
+
+func (t *throttle) Read(p []byte) (int, error) {
+	// Reads stay within a tenth of a second's worth, so that waits stay short
+	if limit := max(t.rate/10, 1); int64(len(p)) > limit {
+		p = p[:limit]
+	}
+
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+
+	due := t.start.Add(time.Duration(float64(t.n) / float64(t.rate) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-t.ctx.Done():
+			return n, t.ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,41 @@
+package backfill
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottle(t *testing.T) {
+	r := strings.NewReader("data")
+	assert.Same(t, r, newThrottle(context.Background(), r, 0))
+
+	content := bytes.Repeat([]byte("x"), 300)
+	start := time.Now()
+	data, err := io.ReadAll(newThrottle(context.Background(), bytes.NewReader(content), 1000))
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+}
+
+func TestThrottleCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := io.ReadAll(newThrottle(ctx, bytes.NewReader(make([]byte, 100)), 10))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSniffer(t *testing.T) {
+	s := &sniffer{}
+	_, err := io.Copy(s, io.LimitReader(strings.NewReader(strings.Repeat("%PDF-1.7\n", 100)), 900))
+	require.NoError(t, err)
+	assert.Len(t, s.data, 512)
+	assert.True(t, strings.HasPrefix(string(s.data), "%PDF-1.7"))
+}
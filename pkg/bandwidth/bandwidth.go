@@ -0,0 +1,254 @@
+// Package bandwidth accounts the bytes each user uploads and downloads per calendar month, for usage
+// reports, and refuses file transfers to users over their monthly transfer cap.
+package bandwidth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// flushInterval is how often recorded transfers are added to the usage stored in the database
+const flushInterval = 10 * time.Second
+
+// accountTTL is how long the usage and cap of a user are cached for Check, so that caps set by an
+// administrator and transfers counted by other servers apply within it
+const accountTTL = time.Minute
+
+// MaxHistory is the most months of usage History returns
+const MaxHistory = 24
+
+var (
+	// ErrCapExceeded is returned for transfers of a user over their monthly cap
+	ErrCapExceeded = errors.New("monthly transfer cap exceeded")
+	// ErrInvalid is returned for a malformed cap or month
+	ErrInvalid = errors.New("invalid transfer usage request")
+)
+
+// defaultCap is bandwidth.monthly_cap, the cap of users without one of their own
+var defaultCap int64
+
+type usageKey struct {
+	userID int
+	month  string
+}
+
+type delta struct {
+	uploaded, downloaded int64
+}
+
+// account caches what Check needs to know of a user
+type account struct {
+	month    string
+	used     int64 // bytes transferred in month, as loaded plus recorded since
+	capBytes int64 // 0 for no cap
+	loaded   time.Time
+}
+
+var (
+	mu       sync.Mutex
+	pending  = make(map[usageKey]*delta) // transfers recorded since the last flush
+	accounts = make(map[int]*account)
+)
+
+// Init reads the default monthly cap
+func Init(cfg *config.Config) {
+	defaultCap = cfg.Bandwidth.MonthlyCap
+}
+
+// Start adds recorded transfers to the stored usage until ctx is done, and once more then
+func Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				flush(context.WithoutCancel(ctx))
+				return
+			case <-ticker.C:
+				flush(ctx)
+			}
+		}
+	}()
+}
+
+// Record counts bytes a user uploaded and downloaded at the given time. They are stored within
+// flushInterval, and count towards the cap of the user at once.
+func Record(userID int, uploaded, downloaded int64, at time.Time) {
+	if userID == 0 || uploaded+downloaded <= 0 {
+		return
+	}
+
+	month := model.TransferMonth(at)
+	mu.Lock()
+	defer mu.Unlock()
+
+	d := pending[usageKey{userID, month}]
+	if d == nil {
+		d = &delta{}
+		pending[usageKey{userID, month}] = d
+	}
+	d.uploaded += uploaded
+	d.downloaded += downloaded
+
+	if acct := accounts[userID]; acct != nil && acct.month == month {
+		acct.used += uploaded + downloaded
+	}
+}
+
+// flush adds the transfers recorded since the last flush to the stored usage, keeping those that
+// failed for the next one
+func flush(ctx context.Context) {
+	mu.Lock()
+	batch := pending
+	pending = make(map[usageKey]*delta)
+	mu.Unlock()
+
+	for key, d := range batch {
+		if err := db.AddTransferUsage(ctx, key.userID, key.month, d.uploaded, d.downloaded); err != nil {
+			log.Printf("Failed to record transfer usage of user %d: %s", key.userID, err)
+			mu.Lock()
+			if p := pending[key]; p != nil {
+				p.uploaded += d.uploaded
+				p.downloaded += d.downloaded
+			} else {
+				pending[key] = d
+			}
+			mu.Unlock()
+		}
+	}
+}
+
+// unflushed returns the transfers of a user in a month not stored yet
+func unflushed(userID int, month string) delta {
+	mu.Lock()
+	defer mu.Unlock()
+	if d := pending[usageKey{userID, month}]; d != nil {
+		return *d
+	}
+	return delta{}
+}
+
+// capOf returns the monthly cap of a user, 0 for none
+func capOf(quota *model.UserQuota) int64 {
+	if quota.TransferCap != nil {
+		return *quota.TransferCap
+	}
+	return defaultCap
+}
+
+// Check returns ErrCapExceeded once a user transferred as many bytes this month as their cap allows
+func Check(ctx context.Context, userID int) error {
+	if userID == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	month := model.TransferMonth(now)
+	mu.Lock()
+	acct := accounts[userID]
+	if acct == nil || acct.month != month || now.Sub(acct.loaded) >= accountTTL {
+		acct = nil
+	} else {
+		acct = &account{month: acct.month, used: acct.used, capBytes: acct.capBytes}
+	}
+	mu.Unlock()
+
+	if acct == nil {
+		usage, err := Usage(ctx, userID)
+		if err != nil {
+			return err
+		}
+		acct = &account{month: month, used: usage.Total(), capBytes: usage.CapBytes, loaded: now}
+		mu.Lock()
+		accounts[userID] = acct
+		mu.Unlock()
+	}
+
+	if acct.capBytes > 0 && acct.used >= acct.capBytes {
+		return fmt.Errorf("%w: %d of %d bytes transferred in %s", ErrCapExceeded, acct.used, acct.capBytes, month)
+	}
+	return nil
+}
+
+// ResetTime returns when the month of t ends, and with it the caps of users
+func ResetTime(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Report is the transfer usage of a user in the current month, with their cap
+type Report struct {
+	*model.TransferUsage
+	CapBytes       int64     `json:"cap_bytes"`       // 0 for no cap
+	RemainingBytes int64     `json:"remaining_bytes"` // -1 without a cap
+	ResetsAt       time.Time `json:"resets_at"`
+}
+
+// Usage returns the transfer usage of a user in the current month, transfers not stored yet included
+func Usage(ctx context.Context, userID int) (*Report, error) {
+	now := time.Now()
+	month := model.TransferMonth(now)
+	usage, err := db.GetTransferUsage(ctx, userID, month)
+	if err != nil {
+		return nil, err
+	}
+	d := unflushed(userID, month)
+	usage.UploadedBytes += d.uploaded
+	usage.DownloadedBytes += d.downloaded
+
+	quota, err := db.GetUserQuota(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{TransferUsage: usage, CapBytes: capOf(quota.UserQuota), RemainingBytes: -1, ResetsAt: ResetTime(now)}
+	if report.CapBytes > 0 {
+		report.RemainingBytes = max(report.CapBytes-usage.Total(), 0)
+	}
+	return report, nil
+}
+
+// History returns the stored transfer usage of a user in up to limit months, the latest first
+func History(ctx context.Context, userID int, limit int) ([]*model.TransferUsage, error) {
+	if limit <= 0 || limit > MaxHistory {
+		limit = MaxHistory
+	}
+	return db.ListUserTransferUsage(ctx, userID, limit)
+}
+
+// List returns the stored usage of the users who transferred the most in a month, YYYY-MM, the current
+// one when empty
+func List(ctx context.Context, month string, limit int) ([]*model.TransferUsage, error) {
+	if month == "" {
+		month = model.TransferMonth(time.Now())
+	} else if _, err := time.Parse("2006-01", month); err != nil {
+		return nil, fmt.Errorf("%w: month must be in the form YYYY-MM", ErrInvalid)
+	}
+	return db.ListTransferUsage(ctx, month, limit)
+}
+
+// SetCap gives a user a monthly transfer cap of their own in bytes, 0 for none, or nil to go back to
+// the configured one
+func SetCap(ctx context.Context, userID int, capBytes *int64) error {
+	if capBytes != nil && *capBytes < 0 {
+		return fmt.Errorf("%w: cap_bytes must not be negative", ErrInvalid)
+	}
+	if err := db.SetTransferCap(ctx, userID, capBytes); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	delete(accounts, userID)
+	mu.Unlock()
+	return nil
+}
@@ -0,0 +1,126 @@
+package bandwidth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reset forgets recorded transfers and cached accounts
+func reset() {
+	mu.Lock()
+	pending = make(map[usageKey]*delta)
+	accounts = make(map[int]*account)
+	mu.Unlock()
+}
+
+// cache makes Check see a user with the given usage and cap without the database
+func cache(userID int, used, capBytes int64) {
+	mu.Lock()
+	defer mu.Unlock()
+	accounts[userID] = &account{month: model.TransferMonth(time.Now()), used: used, capBytes: capBytes, loaded: time.Now()}
+}
+
+func TestRecord(t *testing.T) {
+	reset()
+	now := time.Now()
+	cache(1, 100, 0)
+
+	Record(1, 10, 20, now)
+	Record(1, 5, 0, now)
+	Record(0, 10, 10, now) // nobody pays for anonymous requests
+	Record(2, 0, 0, now)
+
+	assert.Equal(t, delta{uploaded: 15, downloaded: 20}, unflushed(1, model.TransferMonth(now)))
+	assert.Equal(t, delta{}, unflushed(2, model.TransferMonth(now)))
+	assert.Len(t, pending, 1)
+	assert.Equal(t, int64(135), accounts[1].used)
+
+	// Transfers of another month do not count towards this one
+	Record(1, 1000, 0, now.AddDate(0, -1, 0))
+	assert.Equal(t, int64(135), accounts[1].used)
+}
+
+func TestCheck(t *testing.T) {
+	reset()
+	cache(1, 99, 100)
+	cache(2, 1000, 0)
+
+	assert.NoError(t, Check(t.Context(), 0))
+	assert.NoError(t, Check(t.Context(), 1))
+	assert.NoError(t, Check(t.Context(), 2))
+
+	Record(1, 1, 0, time.Now())
+	assert.ErrorIs(t, Check(t.Context(), 1), ErrCapExceeded)
+}
+
+func TestCapOf(t *testing.T) {
+	saved := defaultCap
+	t.Cleanup(func() { defaultCap = saved })
+	defaultCap = 1 << 30
+
+	assert.Equal(t, int64(1<<30), capOf(&model.UserQuota{}))
+	none := int64(0)
+	assert.Zero(t, capOf(&model.UserQuota{TransferCap: &none}))
+}
+
+func TestResetTime(t *testing.T) {
+	assert.Equal(t, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), ResetTime(time.Date(2026, 12, 31, 23, 0, 0, 0, time.UTC)))
+	assert.Equal(t, "2026-12", model.TransferMonth(time.Date(2026, 12, 31, 23, 0, 0, 0, time.UTC)))
+}
+
+func TestInvalid(t *testing.T) {
+	_, err := List(t.Context(), "October", 10)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	negative := int64(-1)
+	assert.ErrorIs(t, SetCap(t.Context(), 1, &negative), ErrInvalid)
+}
+
+func TestMiddleware(t *testing.T) {
+	reset()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.ContextWithFallback = true
+	router.Use(Meter, func(c *gin.Context) {
+		c.Set("user", &model.User{ID: 1})
+	}, Limit)
+	router.POST("/upload", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, "stored %d", len(body))
+	})
+	router.GET("/link", func(c *gin.Context) {
+		ChargeTo(c, 2)
+		c.String(http.StatusOK, "shared")
+	})
+
+	cache(1, 0, 100)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("0123456789")))
+	require.Equal(t, http.StatusOK, w.Code)
+	month := model.TransferMonth(time.Now())
+	assert.Equal(t, delta{uploaded: 10, downloaded: 9}, unflushed(1, month))
+
+	// Someone else can be charged for a request
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/link", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, delta{downloaded: 6}, unflushed(2, month))
+
+	// Users over their cap are told when it resets
+	cache(1, 100, 100)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("more")))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, int64(10), unflushed(1, month).uploaded, "refused bodies are not read")
+}
@@ -0,0 +1,112 @@
+package bandwidth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/gin-gonic/gin"
+)
+
+// meter holds who pays for the bytes a request transfers: the user it is authenticated as, unless
+// someone else is charged, such as the owner of a share link used without an account
+type meter struct {
+	payer atomic.Int64
+}
+
+type meterKey struct{}
+
+// ChargeTo makes a user pay for the bytes of the request ctx belongs to
+func ChargeTo(ctx context.Context, userID int) {
+	if m, ok := ctx.Value(meterKey{}).(*meter); ok {
+		m.payer.Store(int64(userID))
+	}
+}
+
+// Metered reports whether ctx belongs to a request whose bytes Meter counts
+func Metered(ctx context.Context) bool {
+	_, ok := ctx.Value(meterKey{}).(*meter)
+	return ok
+}
+
+// countingBody counts the bytes of a request body read by handlers
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func contextUser(c *gin.Context) *model.User {
+	if v, ok := c.Get("user"); ok {
+		if user, ok := v.(*model.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// Meter counts the bytes of request bodies read and of responses written, and records them for
+// the user who pays for the request
+func Meter(c *gin.Context) {
+	m := &meter{}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), meterKey{}, m))
+	var body *countingBody
+	if c.Request.Body != nil && c.Request.Body != http.NoBody {
+		body = &countingBody{ReadCloser: c.Request.Body}
+		c.Request.Body = body
+	}
+
+	c.Next()
+
+	payer := int(m.payer.Load())
+	if payer == 0 {
+		if user := contextUser(c); user != nil {
+			payer = user.ID
+		}
+	}
+
+	var uploaded int64
+	if body != nil {
+		uploaded = body.n
+	}
+	Record(payer, uploaded, int64(max(c.Writer.Size(), 0)), time.Now())
+}
+
+// Limit refuses requests of users over their monthly transfer cap with 429 Too Many Requests, telling
+// when the cap resets
+func Limit(c *gin.Context) {
+	user := contextUser(c)
+	if user == nil {
+		c.Next()
+		return
+	}
+
+	if err := Check(c, user.ID); err != nil {
+		Refuse(c, err)
+		return
+	}
+	c.Next()
+}
+
+// Refuse aborts a request with the error of Check: 429 Too Many Requests with a Retry-After header
+// for a user over their cap, 500 otherwise
+func Refuse(c *gin.Context, err error) {
+	if !errors.Is(err, ErrCapExceeded) {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check transfer usage"})
+		return
+	}
+
+	now := time.Now()
+	c.Header("Retry-After", strconv.Itoa(int(ResetTime(now).Sub(now).Seconds())+1))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,196 @@
+// Package classify flags files holding sensitive content, such as payment card numbers or access keys.
+// Files are scanned in the background as they are written. Flags are stored with the file, and the admin
+// policy can refuse to share flagged files by public link and alert a webhook when a file is flagged.
+package classify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/audit"
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/hooks"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+)
+
+const (
+	// batchSize is how many files are classified per background pass
+	batchSize = 100
+	// maxInline is the most unclassified files classified while checking a share, more are refused until
+	// the background pass catches up
+	maxInline = 50
+)
+
+var (
+	// ErrBlocked is returned when the policy forbids sharing flagged content
+	ErrBlocked = errors.New("sharing of sensitive content is blocked by policy")
+	// ErrPending is returned when too much content is still waiting to be classified to decide on a share
+	ErrPending = errors.New("content is still being classified, try again later")
+)
+
+// policy holds the classifier settings, set by Start
+var policy config.ClassifyConfig
+
+// wake asks the background pass to look for new content now rather than at its next interval
+var wake = make(chan struct{}, 1)
+
+// Start classifies new content until ctx is done, if the classifier is enabled
+func Start(ctx context.Context, cfg *config.Config) {
+	policy = cfg.Classify
+	if !policy.Enabled || policy.Interval <= 0 {
+		log.Printf("Content classification disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(policy.Interval)
+		defer ticker.Stop()
+
+		for {
+			for classifyPending(ctx) {
+				// keep going while full batches were classified
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-wake:
+			}
+		}
+	}()
+}
+
+// Wake has content written since the last pass classified without waiting for the next one
+func Wake() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// classifyPending classifies one batch of new content, reporting whether the batch was full
+func classifyPending(ctx context.Context) bool {
+	files, err := db.ListUnclassified(ctx, 0, "", batchSize)
+	if err != nil {
+		log.Printf("Failed to list files to classify: %s", err)
+		return false
+	}
+
+	for _, file := range files {
+		repo, err := db.GetRepositoryByID(ctx, file.RepoID)
+		if err != nil {
+			log.Printf("Failed to get repository %d: %s", file.RepoID, err)
+			continue
+		}
+		if err := classify(ctx, repo, file); err != nil {
+			log.Printf("Failed to classify %s: %s", file.Path, err)
+		}
+	}
+	return len(files) == batchSize && ctx.Err() == nil
+}
+
+// classify scans a file and stores its flags, alerting when it gains a flag
+func classify(ctx context.Context, repo *model.Repository, file *model.FileObject) error {
+	var flags []string
+	if Scannable(file.ContentType()) {
+		reader, err := stor.OpenFile(ctx, &model.Resource{Repo: repo, Path: file.Path})
+		if err != nil {
+			return err
+		}
+		content, err := io.ReadAll(io.LimitReader(reader, policy.MaxScanBytes))
+		reader.Close()
+		if err != nil {
+			return err
+		}
+		flags = Detect(content)
+	}
+
+	if err := db.SetFileFlags(ctx, file.ID, flags, time.Now()); err != nil {
+		return err
+	}
+
+	added := slices.DeleteFunc(slices.Clone(flags), func(flag string) bool {
+		return slices.Contains(file.Flags, flag)
+	})
+	file.Flags = flags
+	if len(added) > 0 {
+		flagged(ctx, repo, file)
+	}
+	return nil
+}
+
+// Alert is the JSON body of the alert sent when a file is flagged
+type Alert struct {
+	Event     string    `json:"event"`
+	Repo      string    `json:"repo"`
+	Owner     string    `json:"owner,omitempty"`
+	Path      string    `json:"path"`
+	Flags     []string  `json:"flags"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// flagged records a newly flagged file in the audit log and alerts the policy webhook
+func flagged(ctx context.Context, repo *model.Repository, file *model.FileObject) {
+	audit.Record(ctx, &model.AuditEntry{
+		Action: model.AuditFileFlagged,
+		UserID: &repo.OwnerID,
+		Detail: fmt.Sprintf("%s:%s %v", repo.Name, file.Path, file.Flags),
+	})
+
+	if policy.AlertURL == "" {
+		return
+	}
+
+	alert := &Alert{
+		Event:     hooks.EventFlagged,
+		Repo:      repo.Name,
+		Path:      file.Path,
+		Flags:     file.Flags,
+		Timestamp: time.Now().UTC(),
+	}
+	if owner, err := db.GetUserByID(ctx, repo.OwnerID); err == nil {
+		alert.Owner = owner.Username
+	}
+
+	if _, err := hooks.Send(ctx, policy.AlertURL, policy.AlertSecret, alert.Event, alert); err != nil {
+		log.Printf("Failed to send alert for %s: %s", file.Path, err)
+	}
+}
+
+// CheckShare returns ErrBlocked if the policy forbids sharing a path of a repository by public link
+// because it, or a file under it, is flagged. Content not classified yet is classified first.
+func CheckShare(ctx context.Context, repo *model.Repository, path string) error {
+	if !policy.Enabled || len(policy.BlockExternal) == 0 {
+		return nil
+	}
+
+	pending, err := db.ListUnclassified(ctx, repo.ID, path, maxInline+1)
+	if err != nil {
+		return err
+	}
+	if len(pending) > maxInline {
+		return ErrPending
+	}
+	for _, file := range pending {
+		if err := classify(ctx, repo, file); err != nil {
+			return err
+		}
+	}
+
+	blocked, err := db.HasFlaggedFiles(ctx, repo.ID, path, policy.BlockExternal)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+	return nil
+}
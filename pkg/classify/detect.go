@@ -0,0 +1,113 @@
+package classify
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Flags set by the built-in classifiers
+const (
+	FlagCreditCard = "credit_card"
+	FlagNationalID = "national_id"
+	FlagAWSKey     = "aws_key"
+	FlagPrivateKey = "private_key"
+)
+
+// A Classifier looks for one kind of sensitive content
+type Classifier interface {
+	// Flag names what the classifier finds
+	Flag() string
+	// Match reports whether content holds what the classifier looks for
+	Match(content []byte) bool
+}
+
+// classifiers are consulted in order of registration
+var classifiers = []Classifier{
+	&pattern{flag: FlagCreditCard, re: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), valid: validCardNumber},
+	&pattern{flag: FlagNationalID, re: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), valid: validSSN},
+	&pattern{flag: FlagAWSKey, re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	&pattern{flag: FlagPrivateKey, re: regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )?PRIVATE KEY-----`)},
+}
+
+// Register adds a classifier, for example for the ID numbers of another country
+func Register(c Classifier) {
+	classifiers = append(classifiers, c)
+}
+
+// Detect returns the flags of every classifier matching the content, sorted
+func Detect(content []byte) []string {
+	var flags []string
+	for _, c := range classifiers {
+		if !slices.Contains(flags, c.Flag()) && c.Match(content) {
+			flags = append(flags, c.Flag())
+		}
+	}
+	slices.Sort(flags)
+	return flags
+}
+
+// Scannable reports whether files of a MIME type hold text the classifiers can read
+func Scannable(mimeType string) bool {
+	if strings.HasPrefix(mimeType, "text/") {
+		return true
+	}
+	switch mimeType {
+	case "application/json", "application/xml", "application/yaml", "application/x-yaml",
+		"application/javascript", "application/x-sh", "application/sql", "application/x-pem-file":
+		return true
+	default:
+		return false
+	}
+}
+
+// pattern is a classifier matching a regular expression, with an optional check of each match
+type pattern struct {
+	flag  string
+	re    *regexp.Regexp
+	valid func(match string) bool
+}
+
+func (p *pattern) Flag() string {
+	return p.flag
+}
+
+func (p *pattern) Match(content []byte) bool {
+	if p.valid == nil {
+		return p.re.Match(content)
+	}
+
+	for _, match := range p.re.FindAll(content, -1) {
+		if p.valid(string(match)) {
+			return true
+		}
+	}
+	return false
+}
+
+// validCardNumber reports whether digits, possibly grouped by spaces or dashes, form a payment card number
+// of a known network that passes the Luhn check
+func validCardNumber(match string) bool {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(match)
+	if len(digits) < 13 || len(digits) > 19 || !strings.ContainsAny(digits[:1], "23456") {
+		return false
+	}
+
+	sum := 0
+	for i := range digits {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// validSSN reports whether a dashed number is a possible US social security number
+func validSSN(match string) bool {
+	area, group, serial := match[:3], match[4:6], match[7:]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// AppPasswordModel represents an app password for database operations
+type AppPasswordModel struct {
+	bun.BaseModel `bun:"table:app_passwords"`
+	*model.AppPassword
+}
+
+func wrapAppPassword(mo *model.AppPassword) *AppPasswordModel {
+	return &AppPasswordModel{AppPassword: mo}
+}
+
+func unwrapAppPasswords(mos []*AppPasswordModel) []*model.AppPassword {
+	passwords := make([]*model.AppPassword, len(mos))
+	for i, mo := range mos {
+		passwords[i] = mo.AppPassword
+	}
+	return passwords
+}
+
+// CreateAppPassword stores a new app password
+func CreateAppPassword(ctx context.Context, password *model.AppPassword) error {
+	password.CreatedAt = time.Now()
+
+	_, err := db.NewInsert().Model(wrapAppPassword(password)).Returning("id").Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create app password: %w", err)
+	}
+	return nil
+}
+
+// ListAppPasswords returns the app passwords of a user, oldest first
+func ListAppPasswords(ctx context.Context, userID int) ([]*model.AppPassword, error) {
+	var mos []*AppPasswordModel
+	err := db.NewSelect().Model(&mos).
+		Where("user_id = ?", userID).
+		Order("id").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list app passwords: %w", err)
+	}
+	return unwrapAppPasswords(mos), nil
+}
+
+// TouchAppPassword records when an app password was last used
+func TouchAppPassword(ctx context.Context, id int, at time.Time) error {
+	_, err := db.NewUpdate().Model((*AppPasswordModel)(nil)).
+		Set("last_used = ?", at).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update app password: %w", err)
+	}
+	return nil
+}
+
+// DeleteAppPassword removes an app password of a user
+func DeleteAppPassword(ctx context.Context, userID, id int) error {
+	result, err := db.NewDelete().Model((*AppPasswordModel)(nil)).
+		Where("user_id = ? AND id = ?", userID, id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete app password: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("app password %d: %w", id, sql.ErrNoRows)
+	}
+	return nil
+}
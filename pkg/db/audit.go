@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// AuditEntryModel represents an audit log entry for database operations
+type AuditEntryModel struct {
+	bun.BaseModel `bun:"table:audit_log"`
+	*model.AuditEntry
+}
+
+func wrapAuditEntry(mo *model.AuditEntry) *AuditEntryModel {
+	return &AuditEntryModel{AuditEntry: mo}
+}
+
+func unwrapAuditEntries(mos []*AuditEntryModel) []*model.AuditEntry {
+	entries := make([]*model.AuditEntry, len(mos))
+	for i, mo := range mos {
+		entries[i] = mo.AuditEntry
+	}
+	return entries
+}
+
+// CreateAuditEntry appends an entry to the audit log
+func CreateAuditEntry(ctx context.Context, entry *model.AuditEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	op := CurrentOperation(ctx)
+	if entry.OperationID == "" && op != nil {
+		entry.OperationID = op.ID
+	}
+
+	_, err := db.NewInsert().Model(wrapAuditEntry(entry)).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create audit entry: %w", err)
+	}
+	if op != nil {
+		op.Audited.Add(1)
+	}
+	return nil
+}
+
+// ListAuditEntries returns audit log entries, newest first
+func ListAuditEntries(ctx context.Context, limit, offset int) ([]*model.AuditEntry, error) {
+	var mos []*AuditEntryModel
+	err := db.NewSelect().Model(&mos).
+		Order("id DESC").
+		Limit(limit).
+		Offset(offset).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	return unwrapAuditEntries(mos), nil
+}
+
+// ListAuditByOperation returns the audit log entries of an operation, oldest first
+func ListAuditByOperation(ctx context.Context, operationID string) ([]*model.AuditEntry, error) {
+	var mos []*AuditEntryModel
+	err := db.NewSelect().Model(&mos).
+		Where("operation_id = ?", operationID).
+		Order("id").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries of operation: %w", err)
+	}
+	return unwrapAuditEntries(mos), nil
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// TransferUsageModel represents the monthly transfer usage of a user for database operations
+type TransferUsageModel struct {
+	bun.BaseModel `bun:"table:transfer_usage,alias:tu"`
+	*model.TransferUsage
+}
+
+func unwrapTransferUsage(mos []*TransferUsageModel) []*model.TransferUsage {
+	usage := make([]*model.TransferUsage, len(mos))
+	for i, mo := range mos {
+		usage[i] = mo.TransferUsage
+	}
+	return usage
+}
+
+// AddTransferUsage adds bytes uploaded and downloaded to the usage of a user in a month
+func AddTransferUsage(ctx context.Context, userID int, month string, uploaded, downloaded int64) error {
+	mo := &TransferUsageModel{TransferUsage: &model.TransferUsage{
+		UserID:          userID,
+		Month:           month,
+		UploadedBytes:   uploaded,
+		DownloadedBytes: downloaded,
+		UpdatedAt:       time.Now(),
+	}}
+
+	_, err := db.NewInsert().Model(mo).
+		On("CONFLICT (user_id, month) DO UPDATE").
+		Set("uploaded_bytes = tu.uploaded_bytes + EXCLUDED.uploaded_bytes").
+		Set("downloaded_bytes = tu.downloaded_bytes + EXCLUDED.downloaded_bytes").
+		Set("updated_at = EXCLUDED.updated_at").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to add transfer usage: %w", err)
+	}
+	return nil
+}
+
+// GetTransferUsage returns the usage of a user in a month, zero when nothing was transferred
+func GetTransferUsage(ctx context.Context, userID int, month string) (*model.TransferUsage, error) {
+	mo := &TransferUsageModel{TransferUsage: &model.TransferUsage{}}
+	err := db.NewSelect().Model(mo).
+		Where("user_id = ? AND month = ?", userID, month).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &model.TransferUsage{UserID: userID, Month: month}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get transfer usage: %w", err)
+	}
+	return mo.TransferUsage, nil
+}
+
+// ListUserTransferUsage returns the usage of a user in up to limit months, the latest first
+func ListUserTransferUsage(ctx context.Context, userID int, limit int) ([]*model.TransferUsage, error) {
+	var mos []*TransferUsageModel
+	err := db.NewSelect().Model(&mos).
+		Where("user_id = ?", userID).
+		Order("month DESC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transfer usage: %w", err)
+	}
+	return unwrapTransferUsage(mos), nil
+}
+
+// ListTransferUsage returns the usage of up to limit users in a month, those who transferred the most first
+func ListTransferUsage(ctx context.Context, month string, limit int) ([]*model.TransferUsage, error) {
+	var mos []*TransferUsageModel
+	err := db.NewSelect().Model(&mos).
+		ColumnExpr("tu.*, u.username").
+		Join("JOIN users AS u ON u.id = tu.user_id").
+		Where("tu.month = ?", month).
+		OrderExpr("tu.uploaded_bytes + tu.downloaded_bytes DESC, tu.user_id").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transfer usage: %w", err)
+	}
+	return unwrapTransferUsage(mos), nil
+}
+
+// SetTransferCap sets the monthly transfer cap of a user in bytes, 0 for none, or nil for the configured default
+func SetTransferCap(ctx context.Context, userID int, capBytes *int64) error {
+	result, err := db.NewUpdate().
+		Model((*UserQuotaModel)(nil)).
+		Set("transfer_cap_bytes = ?", capBytes).
+		Set("updated_at = ?", time.Now()).
+		Where("user_id = ?", userID).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to set transfer cap: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+// Benchmarks of the queries behind the hot paths of clients: listing large directories, polling for
+// changes and recording chunks of concurrent uploads. Like the tests they need the test database;
+// target numbers are in docs/PERFORMANCE.md.
+
+// benchEntries is the size of the directory listed and of the change log polled
+const benchEntries = 10000
+
+// setupBenchRepo creates a user and a repository for a benchmark
+func setupBenchRepo(b *testing.B, ctx context.Context, name string) *model.Repository {
+	user := &model.User{Username: name, Email: name + "@example.com", HA1: "benchha1", IsActive: true}
+	require.NoError(b, CreateUser(ctx, user))
+
+	repo := &model.Repository{OwnerID: user.ID, Name: name, Root: "/storage/" + name}
+	require.NoError(b, CreateRepository(ctx, repo))
+	return repo
+}
+
+func BenchmarkStreamChildFiles(b *testing.B) {
+	cleanup := setupTestDB(b)
+	defer cleanup()
+
+	ctx := context.Background()
+	repo := setupBenchRepo(b, ctx, "bench-list")
+
+	now := time.Now()
+	dir := &model.FileObject{RepoID: repo.ID, OwnerID: repo.OwnerID, Name: "big", Path: "/big", IsDir: true, CreatedAt: now, UpdatedAt: now}
+	require.NoError(b, CreateFile(ctx, dir))
+
+	children := make([]*FileModel, benchEntries)
+	for i := range children {
+		name := fmt.Sprintf("file-%05d.txt", i)
+		children[i] = wrapFile(&model.FileObject{
+			RepoID: repo.ID, OwnerID: repo.OwnerID, ParentID: dir.ID, Name: name, Path: "/big/" + name,
+			Size: int64(i), ModTime: now, CreatedAt: now, UpdatedAt: now,
+		})
+	}
+	_, err := db.NewInsert().Model(&children).Exec(ctx)
+	require.NoError(b, err)
+
+	for _, limit := range []int{1000, 0} {
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := StreamChildFiles(ctx, dir.ID, model.ListOrder{}, 0, limit, func(*model.FileObject) error { return nil })
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+func BenchmarkListChangesAfter(b *testing.B) {
+	cleanup := setupTestDB(b)
+	defer cleanup()
+
+	ctx := context.Background()
+	repo := setupBenchRepo(b, ctx, "bench-changes")
+
+	now := time.Now()
+	changes := make([]*ChangeLogModel, benchEntries)
+	for i := range changes {
+		changes[i] = wrapChangeLog(&model.ChangeLog{
+			RepoID: repo.ID, UserID: repo.OwnerID, Operation: "create",
+			Path: fmt.Sprintf("/docs/file-%05d.txt", i), Version: fmt.Sprintf("v%05d", i), Timestamp: now,
+		})
+	}
+	_, err := db.NewInsert().Model(&changes).Exec(ctx)
+	require.NoError(b, err)
+
+	last, err := LastChangeID(ctx, repo.ID)
+	require.NoError(b, err)
+
+	// A client polling shortly after its previous sync, and one catching up from the start
+	for _, tc := range []struct {
+		name  string
+		after int
+	}{
+		{"recent", last - 10},
+		{"backlog", 0},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := ListChangesAfter(ctx, repo.ID, tc.after, model.ChangeFilter{}, 1000)
+				require.NoError(b, err)
+			}
+		})
+	}
+
+	b.Run("prefix", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := ListChangesAfter(ctx, repo.ID, last-100, model.ChangeFilter{PathPrefix: "/docs"}, 1000)
+			require.NoError(b, err)
+		}
+	})
+}
+
+func BenchmarkUploadChunks(b *testing.B) {
+	cleanup := setupTestDB(b)
+	defer cleanup()
+
+	ctx := context.Background()
+	repo := setupBenchRepo(b, ctx, "bench-upload")
+
+	var sessions atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		// Each goroutine is a client sending the chunks of its own upload
+		now := time.Now()
+		session := &model.UploadSession{
+			UploadID: fmt.Sprintf("bench-upload-%d", sessions.Add(1)), RepoID: repo.ID, UserID: repo.OwnerID,
+			Path: "/upload.bin", TotalSize: 1 << 40, ChunkSize: 1 << 20, TotalChunks: 1 << 20,
+			CreatedAt: now, ExpiresAt: now.Add(time.Hour), LastActivityAt: now, Status: model.UploadActive,
+		}
+		require.NoError(b, CreateUploadSession(ctx, session))
+
+		index := 0
+		for pb.Next() {
+			chunk := &model.UploadChunk{
+				UploadID: session.UploadID, ChunkIndex: index, Offset: int64(index) << 20, Size: 1 << 20, UploadedAt: time.Now(),
+			}
+			require.NoError(b, UpsertUploadChunk(ctx, chunk))
+			require.NoError(b, UpdateUploadedChunks(ctx, session.UploadID))
+			index++
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// BlobModel represents deduplicated content for database operations
+type BlobModel struct {
+	bun.BaseModel `bun:"table:blobs"`
+	*model.Blob
+}
+
+// AcquireBlob counts another reference to a blob, recording it if it is new
+func AcquireBlob(ctx context.Context, checksum string, size int64) error {
+	blob := &model.Blob{Checksum: checksum, Size: size, Refs: 1, CreatedAt: time.Now()}
+
+	_, err := db.NewInsert().Model(&BlobModel{Blob: blob}).
+		On("CONFLICT (checksum) DO UPDATE").
+		Set("refs = ?TableAlias.refs + 1").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire blob %s: %w", checksum, err)
+	}
+	return nil
+}
+
+// ReleaseBlob drops a reference to a blob, returning how many remain. The record of a blob without
+// references is removed; sql.ErrNoRows is returned for a blob without one.
+func ReleaseBlob(ctx context.Context, checksum string) (int, error) {
+	var refs int
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewUpdate().Model((*BlobModel)(nil)).
+			Set("refs = refs - 1").
+			Where("checksum = ? AND refs > 0", checksum).
+			Returning("refs").
+			Scan(ctx, &refs)
+		if err != nil {
+			return err
+		}
+
+		if refs == 0 {
+			_, err = tx.NewDelete().Model((*BlobModel)(nil)).
+				Where("checksum = ?", checksum).
+				Exec(ctx)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to release blob %s: %w", checksum, err)
+	}
+	return refs, nil
+}
+
+// GetBlob retrieves the record of a blob
+func GetBlob(ctx context.Context, checksum string) (*model.Blob, error) {
+	blob := &BlobModel{Blob: &model.Blob{}}
+	err := db.NewSelect().Model(blob).
+		Where("checksum = ?", checksum).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob %s: %w", checksum, err)
+	}
+	return blob.Blob, nil
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// ListUnclassified returns up to limit files whose content changed since it was last classified,
+// in a folder of a repository, or in all repositories when repoID is 0
+func ListUnclassified(ctx context.Context, repoID int, dir string, limit int) ([]*model.FileObject, error) {
+	var files []*FileModel
+	query := db.NewSelect().
+		Model(&files).
+		Where("NOT is_dir AND NOT deleted").
+		Where("(classified_at IS NULL OR classified_at < updated_at)")
+	if repoID != 0 {
+		query = query.Where("repo_id = ?", repoID)
+	}
+	if dir != "" {
+		query = query.Where("(path = ? OR path LIKE ?)", dir, dir+"/%")
+	}
+
+	if err := query.Order("updated_at").Limit(limit).Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list unclassified files: %w", err)
+	}
+
+	return unwrapFiles(files), nil
+}
+
+// SetFileFlags stores the result of classifying a file
+func SetFileFlags(ctx context.Context, id int, flags []string, at time.Time) error {
+	_, err := db.NewUpdate().
+		Model((*FileModel)(nil)).
+		Set("flags = ?", pgdialect.Array(flags)).
+		Set("classified_at = ?", at).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to set file flags: %w", err)
+	}
+	return nil
+}
+
+// HasFlaggedFiles reports whether a path of a repository, or a file under it, carries any of the flags.
+// A "*" among the flags matches every flag.
+func HasFlaggedFiles(ctx context.Context, repoID int, path string, flags []string) (bool, error) {
+	query := db.NewSelect().
+		Model((*FileModel)(nil)).
+		Where("repo_id = ? AND NOT deleted", repoID)
+	if path != "" {
+		query = query.Where("(path = ? OR path LIKE ?)", path, path+"/%")
+	}
+	if slices.Contains(flags, "*") {
+		query = query.Where("cardinality(flags) > 0")
+	} else {
+		query = query.Where("flags && ?", pgdialect.Array(flags))
+	}
+
+	exists, err := query.Exists(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to check flagged files: %w", err)
+	}
+	return exists, nil
+}
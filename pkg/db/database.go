@@ -19,6 +19,7 @@ func Init(ctx context.Context, dsn string) {
 	}
 
 	db = bun.NewDB(pgdb, pgdialect.New())
+	db.AddQueryHook(queryHook{})
 }
 
 func Close() error {
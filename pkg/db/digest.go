@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// FollowModel represents a followed folder for database operations
+type FollowModel struct {
+	bun.BaseModel `bun:"table:follows"`
+	*model.Follow
+}
+
+func wrapFollow(mo *model.Follow) *FollowModel {
+	return &FollowModel{Follow: mo}
+}
+
+func unwrapFollows(mos []*FollowModel) []*model.Follow {
+	follows := make([]*model.Follow, len(mos))
+	for i, mo := range mos {
+		follows[i] = mo.Follow
+	}
+	return follows
+}
+
+// CreateFollow makes a user follow a folder, doing nothing if they already do
+func CreateFollow(ctx context.Context, follow *model.Follow) error {
+	follow.CreatedAt = time.Now()
+
+	_, err := db.NewInsert().Model(wrapFollow(follow)).
+		On("CONFLICT (user_id, repo_id, path) DO NOTHING").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create follow: %w", err)
+	}
+	return nil
+}
+
+// ListFollows returns the folders a user follows
+func ListFollows(ctx context.Context, userID int) ([]*model.Follow, error) {
+	var mos []*FollowModel
+	err := db.NewSelect().Model(&mos).
+		Where("user_id = ?", userID).
+		Order("repo_id", "path").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list follows: %w", err)
+	}
+	return unwrapFollows(mos), nil
+}
+
+// DeleteFollow stops a user following a folder
+func DeleteFollow(ctx context.Context, userID, repoID int, path string) error {
+	result, err := db.NewDelete().Model((*FollowModel)(nil)).
+		Where("user_id = ? AND repo_id = ? AND path = ?", userID, repoID, path).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete follow: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("follow not found")
+	}
+	return nil
+}
+
+// ListDigestUsers returns the active users who chose to receive an activity digest
+func ListDigestUsers(ctx context.Context) ([]*model.User, error) {
+	var mos []*UserModel
+	err := db.NewSelect().Model(&mos).
+		Where("digest <> '' AND is_active").
+		Order("id").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list digest users: %w", err)
+	}
+
+	users := make([]*model.User, len(mos))
+	for i, mo := range mos {
+		users[i] = mo.User
+	}
+	return users, nil
+}
+
+// SetUserDigest changes how often a user receives an activity digest, starting its period at sentAt
+func SetUserDigest(ctx context.Context, userID int, schedule string, sentAt time.Time) error {
+	_, err := db.NewUpdate().Model((*UserModel)(nil)).
+		Set("digest = ?", schedule).
+		Set("digest_sent_at = ?", sentAt).
+		Set("updated_at = ?", time.Now()).
+		Where("id = ?", userID).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to set digest: %w", err)
+	}
+	return nil
+}
+
+// MarkDigestSent records the end of the period the last digest of a user covered
+func MarkDigestSent(ctx context.Context, userID int, sentAt time.Time) error {
+	_, err := db.NewUpdate().Model((*UserModel)(nil)).
+		Set("digest_sent_at = ?", sentAt).
+		Where("id = ?", userID).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to mark digest sent: %w", err)
+	}
+	return nil
+}
+
+// ListChangesBetween returns up to limit changes of a repository matching filter recorded in (since, until], oldest first
+func ListChangesBetween(ctx context.Context, repoID int, filter model.ChangeFilter, since, until time.Time, limit int) ([]*model.ChangeLog, error) {
+	var changes []*ChangeLogModel
+	query := db.NewSelect().
+		Model(&changes).
+		Where("repo_id = ? AND timestamp > ? AND timestamp <= ?", repoID, since, until)
+	err := filterChanges(query, filter).
+		Order("id ASC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changes since %s: %w", since.Format(time.RFC3339), err)
+	}
+
+	result := make([]*model.ChangeLog, len(changes))
+	for i, c := range changes {
+		result[i] = c.ChangeLog
+	}
+	return result, nil
+}
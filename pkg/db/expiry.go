@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// ExpiryRuleModel represents a folder expiry rule for database operations
+type ExpiryRuleModel struct {
+	bun.BaseModel `bun:"table:expiry_rules"`
+	*model.ExpiryRule
+}
+
+func wrapExpiryRule(mo *model.ExpiryRule) *ExpiryRuleModel {
+	return &ExpiryRuleModel{ExpiryRule: mo}
+}
+
+func unwrapExpiryRules(mos []*ExpiryRuleModel) []*model.ExpiryRule {
+	rules := make([]*model.ExpiryRule, len(mos))
+	for i, mo := range mos {
+		rules[i] = mo.ExpiryRule
+	}
+	return rules
+}
+
+// SetExpiryRule creates the expiry rule for a folder, or replaces its maximum age if one exists
+func SetExpiryRule(ctx context.Context, rule *model.ExpiryRule) error {
+	rule.CreatedAt = time.Now()
+
+	_, err := db.NewInsert().Model(wrapExpiryRule(rule)).
+		On("CONFLICT (repo_id, path) DO UPDATE").
+		Set("max_age_days = EXCLUDED.max_age_days").
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to set expiry rule: %w", err)
+	}
+	return nil
+}
+
+// ListExpiryRules returns the expiry rules of a repository, or of all repositories when repoID is 0
+func ListExpiryRules(ctx context.Context, repoID int) ([]*model.ExpiryRule, error) {
+	var mos []*ExpiryRuleModel
+	query := db.NewSelect().Model(&mos).Order("repo_id", "path")
+	if repoID != 0 {
+		query = query.Where("repo_id = ?", repoID)
+	}
+
+	if err := query.Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list expiry rules: %w", err)
+	}
+	return unwrapExpiryRules(mos), nil
+}
+
+// DeleteExpiryRule removes the expiry rule of a folder
+func DeleteExpiryRule(ctx context.Context, repoID int, path string) error {
+	result, err := db.NewDelete().Model((*ExpiryRuleModel)(nil)).
+		Where("repo_id = ? AND path = ?", repoID, path).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete expiry rule: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("expiry rule not found")
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// FederatedShareModel represents a share with a user of another server for database operations
+type FederatedShareModel struct {
+	bun.BaseModel `bun:"table:federated_shares"`
+	*model.FederatedShare
+}
+
+// RemoteShareModel represents a share of another server with a local user for database operations
+type RemoteShareModel struct {
+	bun.BaseModel `bun:"table:remote_shares"`
+	*model.RemoteShare
+}
+
+func wrapFederatedShare(mo *model.FederatedShare) *FederatedShareModel {
+	return &FederatedShareModel{FederatedShare: mo}
+}
+
+func wrapRemoteShare(mo *model.RemoteShare) *RemoteShareModel {
+	return &RemoteShareModel{RemoteShare: mo}
+}
+
+// checkAffected turns an update or delete that matched no row into sql.ErrNoRows
+func checkAffected(result sql.Result, what string) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", what, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// CreateFederatedShare stores a new share with a user of another server
+func CreateFederatedShare(ctx context.Context, share *model.FederatedShare) error {
+	share.CreatedAt = time.Now()
+
+	_, err := db.NewInsert().Model(wrapFederatedShare(share)).Returning("id").Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create federated share: %w", err)
+	}
+	return nil
+}
+
+// GetFederatedShare returns a federated share by its ID
+func GetFederatedShare(ctx context.Context, id int) (*model.FederatedShare, error) {
+	mo := wrapFederatedShare(&model.FederatedShare{})
+	if err := db.NewSelect().Model(mo).Where("id = ?", id).Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get federated share: %w", err)
+	}
+	return mo.FederatedShare, nil
+}
+
+// GetFederatedShareBySecret returns the federated share a remote server presents the secret of
+func GetFederatedShareBySecret(ctx context.Context, secret string) (*model.FederatedShare, error) {
+	mo := wrapFederatedShare(&model.FederatedShare{})
+	if err := db.NewSelect().Model(mo).Where("secret = ?", secret).Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get federated share: %w", err)
+	}
+	return mo.FederatedShare, nil
+}
+
+// ListFederatedShares returns the federated shares created by a user
+func ListFederatedShares(ctx context.Context, ownerID int) ([]*model.FederatedShare, error) {
+	var mos []*FederatedShareModel
+	if err := db.NewSelect().Model(&mos).Where("owner_id = ?", ownerID).Order("id").Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list federated shares: %w", err)
+	}
+
+	shares := make([]*model.FederatedShare, len(mos))
+	for i, mo := range mos {
+		shares[i] = mo.FederatedShare
+	}
+	return shares, nil
+}
+
+// SetFederatedShareStatus updates the status of a federated share
+func SetFederatedShareStatus(ctx context.Context, id int, status string) error {
+	result, err := db.NewUpdate().Model((*FederatedShareModel)(nil)).
+		Set("status = ?", status).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update federated share: %w", err)
+	}
+	return checkAffected(result, fmt.Sprintf("federated share %d", id))
+}
+
+// DeleteFederatedShare deletes a federated share by its ID
+func DeleteFederatedShare(ctx context.Context, id int) error {
+	result, err := db.NewDelete().Model((*FederatedShareModel)(nil)).Where("id = ?", id).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete federated share: %w", err)
+	}
+	return checkAffected(result, fmt.Sprintf("federated share %d", id))
+}
+
+// CreateRemoteShare stores a share offered to a local user by another server
+func CreateRemoteShare(ctx context.Context, share *model.RemoteShare) error {
+	share.CreatedAt = time.Now()
+
+	_, err := db.NewInsert().Model(wrapRemoteShare(share)).Returning("id").Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create remote share: %w", err)
+	}
+	return nil
+}
+
+// GetRemoteShare returns a remote share of a user by its ID
+func GetRemoteShare(ctx context.Context, userID, id int) (*model.RemoteShare, error) {
+	mo := wrapRemoteShare(&model.RemoteShare{})
+	if err := db.NewSelect().Model(mo).Where("id = ? AND user_id = ?", id, userID).Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get remote share: %w", err)
+	}
+	return mo.RemoteShare, nil
+}
+
+// GetRemoteShareByName returns the remote share of a user mounted under name
+func GetRemoteShareByName(ctx context.Context, userID int, name string) (*model.RemoteShare, error) {
+	mo := wrapRemoteShare(&model.RemoteShare{})
+	if err := db.NewSelect().Model(mo).Where("user_id = ? AND name = ?", userID, name).Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get remote share: %w", err)
+	}
+	return mo.RemoteShare, nil
+}
+
+// GetRemoteShareBySecret returns the remote share with the given ID on its server and secret
+func GetRemoteShareBySecret(ctx context.Context, remoteID, secret string) (*model.RemoteShare, error) {
+	mo := wrapRemoteShare(&model.RemoteShare{})
+	if err := db.NewSelect().Model(mo).Where("remote_id = ? AND secret = ?", remoteID, secret).Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get remote share: %w", err)
+	}
+	return mo.RemoteShare, nil
+}
+
+// ListRemoteShares returns the remote shares of a user
+func ListRemoteShares(ctx context.Context, userID int) ([]*model.RemoteShare, error) {
+	var mos []*RemoteShareModel
+	if err := db.NewSelect().Model(&mos).Where("user_id = ?", userID).Order("name").Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list remote shares: %w", err)
+	}
+
+	shares := make([]*model.RemoteShare, len(mos))
+	for i, mo := range mos {
+		shares[i] = mo.RemoteShare
+	}
+	return shares, nil
+}
+
+// SetRemoteShareStatus updates the status of a remote share
+func SetRemoteShareStatus(ctx context.Context, id int, status string) error {
+	result, err := db.NewUpdate().Model((*RemoteShareModel)(nil)).
+		Set("status = ?", status).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update remote share: %w", err)
+	}
+	return checkAffected(result, fmt.Sprintf("remote share %d", id))
+}
+
+// DeleteRemoteShare deletes a remote share by its ID
+func DeleteRemoteShare(ctx context.Context, id int) error {
+	result, err := db.NewDelete().Model((*RemoteShareModel)(nil)).Where("id = ?", id).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete remote share: %w", err)
+	}
+	return checkAffected(result, fmt.Sprintf("remote share %d", id))
+}
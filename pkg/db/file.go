@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/cgang/file-hub/pkg/model"
@@ -81,14 +82,40 @@ func GetFile(ctx context.Context, reposID int, path string) (*model.FileObject,
 	return file.FileObject, nil
 }
 
-func GetChildFiles(ctx context.Context, parentID int) ([]*model.FileObject, error) {
+// GetFilesByPaths retrieves the files of a repository at the given paths in one query.
+// Paths without a file are left out of the result.
+func GetFilesByPaths(ctx context.Context, repoID int, paths []string) ([]*model.FileObject, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
 	var files []*FileModel
 	err := db.NewSelect().
 		Model(&files).
-		Where("parent_id = ? AND deleted = ?", parentID, false).
-		Order("name ASC").
+		Where("repo_id = ? AND deleted = ?", repoID, false).
+		Where("path IN (?)", bun.In(paths)).
 		Scan(ctx)
 
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files: %w", err)
+	}
+
+	return unwrapFiles(files), nil
+}
+
+// GetChildFiles retrieves the children of a directory in name order
+func GetChildFiles(ctx context.Context, parentID int) ([]*model.FileObject, error) {
+	return ListChildFiles(ctx, parentID, model.ListOrder{})
+}
+
+// ListChildFiles retrieves the children of a directory in the given order
+func ListChildFiles(ctx context.Context, parentID int, order model.ListOrder) ([]*model.FileObject, error) {
+	var files []*FileModel
+	query := db.NewSelect().
+		Model(&files).
+		Where("parent_id = ? AND deleted = ?", parentID, false)
+	err := orderChildren(query, order).Scan(ctx)
+
 	if err != nil {
 		return nil, fmt.Errorf("failed to get child files: %w", err)
 	}
@@ -96,6 +123,88 @@ func GetChildFiles(ctx context.Context, parentID int) ([]*model.FileObject, erro
 	return unwrapFiles(files), nil
 }
 
+// StreamChildFiles calls visit for the children of a directory in the given order, reading them from a cursor
+// so that large directories are never held in memory. A zero limit streams all remaining children.
+func StreamChildFiles(ctx context.Context, parentID int, order model.ListOrder, offset, limit int, visit func(*model.FileObject) error) error {
+	query := db.NewSelect().
+		Model((*FileModel)(nil)).
+		Where("parent_id = ? AND deleted = ?", parentID, false)
+	query = orderChildren(query, order).Offset(offset)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	rows, err := query.Rows(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get child files: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		file := wrapFile(&model.FileObject{})
+		if err := db.ScanRow(ctx, rows, file); err != nil {
+			return fmt.Errorf("failed to read child file: %w", err)
+		}
+
+		if err := visit(file.FileObject); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// orderChildren orders the children of a directory. Entries sorting equal go by name, and by ID last,
+// so that pages of a listing never overlap.
+func orderChildren(query *bun.SelectQuery, order model.ListOrder) *bun.SelectQuery {
+	if order.DirsFirst {
+		query = query.OrderExpr("is_dir DESC")
+	}
+	switch order.Sort {
+	case model.SortNatural:
+		query = query.OrderExpr(`name COLLATE "natural"`)
+	case model.SortModTime:
+		query = query.OrderExpr("mod_time")
+	case model.SortSize:
+		query = query.OrderExpr("size")
+	}
+	return query.OrderExpr("name").OrderExpr("id")
+}
+
+// StreamRepoFiles calls visit for the regular files of a repository in byte order of their paths, reading
+// them from a cursor. With sinceVersion, only files changed after that version are visited.
+func StreamRepoFiles(ctx context.Context, repoID int, sinceVersion string, visit func(*model.FileObject) error) error {
+	query := db.NewSelect().
+		Model((*FileModel)(nil)).
+		Where("repo_id = ? AND deleted = ? AND NOT is_dir", repoID, false)
+	if sinceVersion != "" {
+		changed := db.NewSelect().
+			Model((*ChangeLogModel)(nil)).
+			Column("path").
+			Where("repo_id = ? AND version > ?", repoID, sinceVersion)
+		query = query.Where("path IN (?)", changed)
+	}
+
+	rows, err := query.OrderExpr(`path COLLATE "C"`).Rows(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get repository files: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		file := wrapFile(&model.FileObject{})
+		if err := db.ScanRow(ctx, rows, file); err != nil {
+			return fmt.Errorf("failed to read repository file: %w", err)
+		}
+
+		if err := visit(file.FileObject); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 // GetFilesByUser retrieves all files for a specific user
 func GetFilesByUser(ctx context.Context, userID int) ([]*FileModel, error) {
 	var files []*FileModel
@@ -139,6 +248,277 @@ func GetFilesByUserAndPathPrefix(ctx context.Context, userID int, pathPrefix str
 	return results, nil
 }
 
+// CountFilesUnder returns the number of files and directories at path and under it
+func CountFilesUnder(ctx context.Context, repoID int, path string) (int, error) {
+	count, err := db.NewSelect().
+		Model((*FileModel)(nil)).
+		Where("repo_id = ? AND (path = ? OR path LIKE ?) AND deleted = ?", repoID, path, escapeLike(strings.TrimSuffix(path, "/"))+"/%", false).
+		Count(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count files under %s: %w", path, err)
+	}
+	return count, nil
+}
+
+// ListFilesUnder returns the files and directories below path, in byte order of their paths
+// so that every directory comes before its content
+func ListFilesUnder(ctx context.Context, repoID int, path string) ([]*model.FileObject, error) {
+	var files []*FileModel
+	err := db.NewSelect().
+		Model(&files).
+		Where("repo_id = ? AND path LIKE ? AND deleted = ?", repoID, escapeLike(strings.TrimSuffix(path, "/"))+"/%", false).
+		OrderExpr(`path COLLATE "C"`).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files under %s: %w", path, err)
+	}
+	return unwrapFiles(files), nil
+}
+
+// GetFilesModifiedBefore retrieves up to limit regular files under a directory, the root if empty or "/",
+// last modified before the given time. Immutable files are left out, as they cannot be removed.
+func GetFilesModifiedBefore(ctx context.Context, repoID int, dir string, before time.Time, limit int) ([]*model.FileObject, error) {
+	var files []*FileModel
+	err := db.NewSelect().
+		Model(&files).
+		Where("repo_id = ? AND path LIKE ? AND is_dir = ? AND deleted = ? AND mod_time < ? AND NOT immutable",
+			repoID, escapeLike(strings.TrimSuffix(dir, "/"))+"/%", false, false, before).
+		Order("mod_time").
+		Limit(limit).
+		Scan(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files: %w", err)
+	}
+
+	return unwrapFiles(files), nil
+}
+
+// DuplicateContent identifies content stored in more than one file
+type DuplicateContent struct {
+	Checksum string `bun:"checksum"`
+	Size     int64  `bun:"size"`
+	Count    int    `bun:"count"`
+}
+
+// FindDuplicateContent returns up to limit checksums shared by several files of the repositories,
+// ordered by how many bytes removing the extra copies would reclaim
+func FindDuplicateContent(ctx context.Context, repoIDs []int, minSize int64, limit int) ([]*DuplicateContent, error) {
+	var dups []*DuplicateContent
+	err := db.NewSelect().
+		Model((*FileModel)(nil)).
+		ColumnExpr("checksum, size, count(*) AS count").
+		Where("repo_id IN (?) AND is_dir = ? AND deleted = ?", bun.In(repoIDs), false, false).
+		Where("checksum IS NOT NULL AND size >= ?", minSize).
+		GroupExpr("checksum, size").
+		Having("count(*) > 1").
+		OrderExpr("(count(*) - 1) * size DESC").
+		Limit(limit).
+		Scan(ctx, &dups)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to find duplicate files: %w", err)
+	}
+
+	return dups, nil
+}
+
+// GetFilesByChecksum retrieves the files of the repositories with one of the given checksums
+func GetFilesByChecksum(ctx context.Context, repoIDs []int, checksums []string) ([]*model.FileObject, error) {
+	var files []*FileModel
+	err := db.NewSelect().
+		Model(&files).
+		Where("repo_id IN (?) AND checksum IN (?) AND is_dir = ? AND deleted = ?", bun.In(repoIDs), bun.In(checksums), false, false).
+		Order("checksum", "repo_id", "path").
+		Scan(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files: %w", err)
+	}
+
+	return unwrapFiles(files), nil
+}
+
+// ListUnchecksummed returns up to limit files stored without a checksum, in ID order after afterID
+func ListUnchecksummed(ctx context.Context, afterID, limit int) ([]*model.FileObject, error) {
+	var files []*FileModel
+	err := db.NewSelect().
+		Model(&files).
+		Where("checksum IS NULL AND NOT is_dir AND NOT deleted").
+		Where("id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Scan(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files without checksum: %w", err)
+	}
+
+	return unwrapFiles(files), nil
+}
+
+// CountUnchecksummed counts the files stored without a checksum
+func CountUnchecksummed(ctx context.Context) (int, error) {
+	count, err := db.NewSelect().
+		Model((*FileModel)(nil)).
+		Where("checksum IS NULL AND NOT is_dir AND NOT deleted").
+		Count(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count files without checksum: %w", err)
+	}
+	return count, nil
+}
+
+// ListUntyped lists up to limit files recorded without a content type, in ID order after afterID
+func ListUntyped(ctx context.Context, afterID, limit int) ([]*model.FileObject, error) {
+	var files []*FileModel
+	err := db.NewSelect().
+		Model(&files).
+		Where("mime_type IS NULL AND NOT is_dir AND NOT deleted").
+		Where("id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Scan(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files without content type: %w", err)
+	}
+
+	return unwrapFiles(files), nil
+}
+
+// ListRepositoryFiles lists up to limit files of a repository, no directories, in ID order after afterID
+func ListRepositoryFiles(ctx context.Context, repoID, afterID, limit int) ([]*model.FileObject, error) {
+	var files []*FileModel
+	err := db.NewSelect().
+		Model(&files).
+		Where("repo_id = ? AND NOT is_dir AND NOT deleted", repoID).
+		Where("id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Scan(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files of repository: %w", err)
+	}
+
+	return unwrapFiles(files), nil
+}
+
+// CountRepositoryFiles counts the files of a repository, no directories
+func CountRepositoryFiles(ctx context.Context, repoID int) (int, error) {
+	count, err := db.NewSelect().
+		Model((*FileModel)(nil)).
+		Where("repo_id = ? AND NOT is_dir AND NOT deleted", repoID).
+		Count(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count files of repository: %w", err)
+	}
+	return count, nil
+}
+
+// UpdateContentType replaces the content type of a file
+func UpdateContentType(ctx context.Context, id int, mimeType string) error {
+	_, err := db.NewUpdate().
+		Model((*FileModel)(nil)).
+		Set("mime_type = ?", mimeType).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update content type: %w", err)
+	}
+	return nil
+}
+
+// SetContentType stores the content type detected for a file, unless it was given one meanwhile.
+// The result reports whether the file was updated.
+func SetContentType(ctx context.Context, id int, mimeType string) (bool, error) {
+	result, err := db.NewUpdate().
+		Model((*FileModel)(nil)).
+		Set("mime_type = ?", mimeType).
+		Where("id = ? AND mime_type IS NULL", id).
+		Exec(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to set content type: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
+
+// SetChecksum stores the checksum computed for a file of the given size, and its content type if it
+// had none. A file written meanwhile, which has another size or a checksum already, is left alone;
+// the result reports whether the file was updated.
+func SetChecksum(ctx context.Context, id int, size int64, checksum string, mimeType string) (bool, error) {
+	result, err := db.NewUpdate().
+		Model((*FileModel)(nil)).
+		Set("checksum = ?", checksum).
+		Set("mime_type = COALESCE(mime_type, ?)", mimeType).
+		Where("id = ? AND size = ? AND checksum IS NULL", id, size).
+		Exec(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to set checksum: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ListColdCandidates lists up to limit hashed files of a repository, in ID order after afterID, whose
+// content is still in the repository and was neither opened nor written since before
+func ListColdCandidates(ctx context.Context, repoID int, before time.Time, afterID, limit int) ([]*model.FileObject, error) {
+	var files []*FileModel
+	err := db.NewSelect().
+		Model(&files).
+		Where("repo_id = ? AND cold_root IS NULL AND NOT is_dir AND NOT deleted", repoID).
+		Where("checksum IS NOT NULL AND GREATEST(accessed_at, updated_at) < ?", before).
+		Where("id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Scan(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files for cold storage: %w", err)
+	}
+
+	return unwrapFiles(files), nil
+}
+
+// SetFileCold records the storage root the content of a file moved to, an empty root when it is back
+// in the repository
+func SetFileCold(ctx context.Context, id int, coldRoot string) error {
+	query := db.NewUpdate().Model((*FileModel)(nil)).Where("id = ?", id)
+	if coldRoot == "" {
+		query = query.Set("cold_root = NULL")
+	} else {
+		query = query.Set("cold_root = ?", coldRoot)
+	}
+
+	if _, err := query.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record storage of file: %w", err)
+	}
+	return nil
+}
+
+// TouchFile records that the content of a file was opened at the given time
+func TouchFile(ctx context.Context, id int, at time.Time) error {
+	_, err := db.NewUpdate().
+		Model((*FileModel)(nil)).
+		Set("accessed_at = ?", at).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to record access of file: %w", err)
+	}
+	return nil
+}
+
 // FileUpdate contains fields that can be updated for a file
 type FileUpdate struct {
 	MimeType  *string    `json:"mime_type,omitempty"`
@@ -147,34 +527,29 @@ type FileUpdate struct {
 	UpdatedAt *time.Time `json:"updated_at,omitempty"`
 }
 
-// UpdateFile updates a file in the database
+// UpdateFile sets the fields given in the update, leaving the others as they are in the database, so that
+// concurrent changes of other fields, such as a rename, are kept
 func UpdateFile(ctx context.Context, id int, update *FileUpdate) error {
-	file := newFile(id)
-
-	// Get the existing file first
-	err := db.NewSelect().Model(file).Where("id = ?", id).Scan(ctx)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("file not found")
-		}
-		return fmt.Errorf("failed to get file: %w", err)
+	updatedAt := time.Now()
+	if update.UpdatedAt != nil {
+		updatedAt = *update.UpdatedAt
 	}
 
-	// Update fields if they are provided
+	q := db.NewUpdate().Model((*FileModel)(nil)).
+		Set("updated_at = ?", updatedAt).
+		Where("id = ?", id)
+
 	if update.MimeType != nil {
-		file.MimeType = update.MimeType
+		q.Set("mime_type = ?", *update.MimeType)
 	}
 	if update.Size != nil {
-		file.Size = *update.Size
+		q.Set("size = ?", *update.Size)
 	}
 	if update.Checksum != nil {
-		file.Checksum = update.Checksum
+		q.Set("checksum = ?", *update.Checksum)
 	}
 
-	// Always update updated_at
-	file.UpdatedAt = time.Now()
-
-	result, err := db.NewUpdate().Model(file).Where("id = ?", id).Exec(ctx)
+	result, err := q.Exec(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to update file: %w", err)
 	}
@@ -225,13 +600,7 @@ func UpsertFile(ctx context.Context, file *model.FileObject) error {
 	}
 	file.UpdatedAt = now
 
-	// Use PostgreSQL 15+ MERGE command via bun's builder
-	_, err := db.NewInsert().Model(wrapFile(file)).
-		On("CONFLICT (repo_id, path) DO UPDATE").
-		Set("mod_time = ?", file.ModTime).
-		Set("size = ?", file.Size).
-		Set("updated_at = ?", now).
-		Exec(ctx)
+	_, err := onFileConflict(db.NewInsert().Model(wrapFile(file)), now).Exec(ctx)
 
 	if err != nil {
 		return fmt.Errorf("failed to upsert file: %w", err)
@@ -240,6 +609,164 @@ func UpsertFile(ctx context.Context, file *model.FileObject) error {
 	return nil
 }
 
+// UpsertFiles inserts or updates files with one statement, the way UpsertFile does, and sets their IDs
+// and the content types recorded for them
+func UpsertFiles(ctx context.Context, files []*model.FileObject) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	mos := make([]*FileModel, len(files))
+	for i, file := range files {
+		if file.RepoID == 0 || file.Path == "" {
+			return fmt.Errorf("repo_id and path are required for upsert")
+		}
+		if file.CreatedAt.IsZero() {
+			file.CreatedAt = now
+		}
+		file.UpdatedAt = now
+		mos[i] = wrapFile(file)
+	}
+
+	_, err := onFileConflict(db.NewInsert().Model(&mos), now).
+		Returning("id, mime_type").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to upsert %d files: %w", len(files), err)
+	}
+	return nil
+}
+
+// RenameFile moves the row of a file from oldPath to the path of renamed in one transaction. The row is
+// inserted at the new path unless a file is there already, as the unique index on (repo_id, path) decides,
+// and write is called to put the content in place before the old row is removed. It reports false, changing
+// nothing, when the new path is taken.
+func RenameFile(ctx context.Context, oldPath string, renamed *model.FileObject, write func() error) (bool, error) {
+	now := time.Now()
+	renamed.CreatedAt, renamed.UpdatedAt = now, now
+
+	taken := false
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		result, err := tx.NewInsert().Model(wrapFile(renamed)).
+			On("CONFLICT (repo_id, path) DO NOTHING").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to rename file: %w", err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rowsAffected == 0 {
+			taken = true
+			return nil
+		}
+
+		if err := write(); err != nil {
+			return err
+		}
+
+		_, err = tx.NewDelete().Model((*FileModel)(nil)).
+			Where("repo_id = ? AND path = ?", renamed.RepoID, oldPath).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete file: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return !taken, nil
+}
+
+// onFileConflict makes an insert of files update those already at their paths.
+// A re-upload replaces the content, so the checksum is only kept when the new row has none
+// and neither size nor mod_time changed. A soft deleted row at the same path is revived.
+func onFileConflict(query *bun.InsertQuery, now time.Time) *bun.InsertQuery {
+	return query.
+		On("CONFLICT (repo_id, path) DO UPDATE").
+		Set("mod_time = EXCLUDED.mod_time").
+		Set("size = EXCLUDED.size").
+		Set("checksum = CASE WHEN EXCLUDED.checksum IS NOT NULL THEN EXCLUDED.checksum"+
+			" WHEN ?TableAlias.size = EXCLUDED.size AND ?TableAlias.mod_time = EXCLUDED.mod_time THEN ?TableAlias.checksum END").
+		Set("mime_type = COALESCE(EXCLUDED.mime_type, ?TableAlias.mime_type)").
+		Set("owner_id = EXCLUDED.owner_id").
+		Set("parent_id = COALESCE(NULLIF(EXCLUDED.parent_id, 0), ?TableAlias.parent_id)").
+		Set("deleted = ?", false).
+		Set("updated_at = ?", now)
+}
+
+// UpdateModTime sets the modification time of a file
+func UpdateModTime(ctx context.Context, repoID int, path string, modTime time.Time) error {
+	_, err := db.NewUpdate().
+		Model((*FileModel)(nil)).
+		Set("mod_time = ?", modTime).
+		Set("updated_at = ?", time.Now()).
+		Where("repo_id = ? AND path = ? AND deleted = ?", repoID, path, false).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update modification time: %w", err)
+	}
+	return nil
+}
+
+// SetFileImmutable sets or clears the immutable flag of a file, returning sql.ErrNoRows if there is none
+func SetFileImmutable(ctx context.Context, repoID int, path string, immutable bool) error {
+	result, err := db.NewUpdate().
+		Model((*FileModel)(nil)).
+		Set("immutable = ?", immutable).
+		Set("updated_at = ?", time.Now()).
+		Where("repo_id = ? AND path = ? AND is_dir = ? AND deleted = ?", repoID, path, false, false).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update immutable flag: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// FindImmutableUnder returns the path of an immutable file below a directory, or "" if there is none
+func FindImmutableUnder(ctx context.Context, repoID int, path string) (string, error) {
+	var found []string
+	err := db.NewSelect().
+		Model((*FileModel)(nil)).
+		Column("path").
+		Where("repo_id = ? AND path LIKE ? AND immutable AND deleted = ?", repoID, escapeLike(strings.TrimSuffix(path, "/"))+"/%", false).
+		Limit(1).
+		Scan(ctx, &found)
+	if err != nil {
+		return "", fmt.Errorf("failed to look for immutable files under %s: %w", path, err)
+	}
+	if len(found) == 0 {
+		return "", nil
+	}
+	return found[0], nil
+}
+
+// BackfillParentIDs links files that were stored without a parent to the directory holding them,
+// returning how many were linked. Files whose directory has no row are left alone.
+func BackfillParentIDs(ctx context.Context) (int64, error) {
+	result, err := db.NewUpdate().
+		Model((*FileModel)(nil)).
+		ModelTableExpr("files AS f").
+		TableExpr("files AS p").
+		Set("parent_id = p.id").
+		Where("f.parent_id IS NULL OR f.parent_id = 0").
+		Where("f.path <> ''").
+		Where("p.repo_id = f.repo_id AND p.is_dir AND NOT p.deleted").
+		Where("p.path = regexp_replace(f.path, '/[^/]*$', '')").
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to backfill parent ids: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
 // DeleteFileByPath marks a file as deleted by path and user
 func DeleteFileByPath(ctx context.Context, repoID int, path string) error {
 	result, err := db.NewDelete().
@@ -262,9 +789,3 @@ func DeleteFileByPath(ctx context.Context, repoID int, path string) error {
 
 	return nil
 }
-
-// UpdateContentType updates content type of specified objects in database.
-func UpdateContentType(ctx context.Context, objects []*model.FileObject) error {
-	// TODO add batch implementation
-	return nil
-}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+// uniqueViolation is the SQLSTATE of an insert or update breaking a unique constraint
+const uniqueViolation = "23505"
+
+// ShareLinkModel represents a share link for database operations
+type ShareLinkModel struct {
+	bun.BaseModel `bun:"table:share_links"`
+	*model.ShareLink
+}
+
+func wrapShareLink(mo *model.ShareLink) *ShareLinkModel {
+	return &ShareLinkModel{ShareLink: mo}
+}
+
+func unwrapShareLinks(mos []*ShareLinkModel) []*model.ShareLink {
+	links := make([]*model.ShareLink, len(mos))
+	for i, mo := range mos {
+		links[i] = mo.ShareLink
+	}
+	return links
+}
+
+// CreateShareLink creates a new share link
+func CreateShareLink(ctx context.Context, link *model.ShareLink) error {
+	link.CreatedAt = time.Now()
+
+	_, err := db.NewInsert().Model(wrapShareLink(link)).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create share link: %w", err)
+	}
+	return nil
+}
+
+// GetShareLinkByToken retrieves a share link by its token
+func GetShareLinkByToken(ctx context.Context, token string) (*model.ShareLink, error) {
+	mo := wrapShareLink(&model.ShareLink{})
+	err := db.NewSelect().Model(mo).Where("token = ?", token).Scan(ctx)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("share link not found")
+		}
+		return nil, fmt.Errorf("failed to get share link: %w", err)
+	}
+	return mo.ShareLink, nil
+}
+
+// GetShareLinkBySlug retrieves a share link by its short link slug
+func GetShareLinkBySlug(ctx context.Context, slug string) (*model.ShareLink, error) {
+	mo := wrapShareLink(&model.ShareLink{})
+	err := db.NewSelect().Model(mo).Where("slug = ?", slug).Scan(ctx)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("share link not found")
+		}
+		return nil, fmt.Errorf("failed to get share link: %w", err)
+	}
+	return mo.ShareLink, nil
+}
+
+// SetShareLinkSlug gives a share link without one a short link slug, reporting false
+// if another link has that slug already
+func SetShareLinkSlug(ctx context.Context, id int, slug string) (bool, error) {
+	_, err := db.NewUpdate().Model((*ShareLinkModel)(nil)).
+		Set("slug = ?", slug).
+		Where("id = ? AND slug IS NULL", id).
+		Exec(ctx)
+	var pgErr pgdriver.Error
+	if errors.As(err, &pgErr) && pgErr.Field('C') == uniqueViolation {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to set share link slug: %w", err)
+	}
+	return true, nil
+}
+
+// GetShareLink retrieves a share link owned by the user
+func GetShareLink(ctx context.Context, ownerID, id int) (*model.ShareLink, error) {
+	mo := wrapShareLink(&model.ShareLink{})
+	err := db.NewSelect().Model(mo).Where("id = ? AND owner_id = ?", id, ownerID).Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get share link %d: %w", id, err)
+	}
+	return mo.ShareLink, nil
+}
+
+// PublishShareLink pins a snapshot link to a repository version and the files it shares then,
+// returning the files it shared before so that their stored content can be removed
+func PublishShareLink(ctx context.Context, link *model.ShareLink, files []*model.SnapshotFile) ([]*model.SnapshotFile, error) {
+	var previous []*SnapshotFileModel
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewUpdate().Model(wrapShareLink(link)).
+			Column("version", "published_at").
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to publish share link: %w", err)
+		}
+
+		_, err = tx.NewDelete().Model((*SnapshotFileModel)(nil)).
+			Where("link_id = ?", link.ID).
+			Returning("*").
+			Exec(ctx, &previous)
+		if err != nil {
+			return fmt.Errorf("failed to delete snapshot files: %w", err)
+		}
+
+		if len(files) == 0 {
+			return nil
+		}
+		mos := make([]*SnapshotFileModel, len(files))
+		for i, file := range files {
+			file.LinkID = link.ID
+			mos[i] = wrapSnapshotFile(file)
+		}
+		if _, err := tx.NewInsert().Model(&mos).Exec(ctx); err != nil {
+			return fmt.Errorf("failed to create snapshot files: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return unwrapSnapshotFiles(previous), nil
+}
+
+// ListShareLinks returns the unexpired share links created by a user
+func ListShareLinks(ctx context.Context, ownerID int) ([]*model.ShareLink, error) {
+	var mos []*ShareLinkModel
+	q := db.NewSelect().Model(&mos).
+		Where("owner_id = ?", ownerID).
+		Order("created_at DESC")
+	err := whereUnexpired(q, time.Now()).Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list share links: %w", err)
+	}
+	return unwrapShareLinks(mos), nil
+}
+
+// DeleteShareLink removes a share link owned by the user
+func DeleteShareLink(ctx context.Context, ownerID, id int) error {
+	result, err := db.NewDelete().Model((*ShareLinkModel)(nil)).
+		Where("id = ? AND owner_id = ?", id, ownerID).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete share link: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("share link not found")
+	}
+
+	return nil
+}
+
+// PurgeExpiredShareLinks removes the share links expired before now, returning them so their
+// owners can be told
+func PurgeExpiredShareLinks(ctx context.Context, now time.Time) ([]*model.ShareLink, error) {
+	var mos []*ShareLinkModel
+	_, err := db.NewDelete().Model((*ShareLinkModel)(nil)).
+		Where("expires_at <= ?", now).
+		Returning("*").
+		Exec(ctx, &mos)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge expired share links: %w", err)
+	}
+	return unwrapShareLinks(mos), nil
+}
+
+// ReserveShareLinkBytes adds size to the bytes used by a link, failing with
+// ok == false if that would exceed the link's limit
+func ReserveShareLinkBytes(ctx context.Context, id int, size int64) (bool, error) {
+	result, err := db.NewUpdate().Model((*ShareLinkModel)(nil)).
+		Set("used_bytes = used_bytes + ?", size).
+		Where("id = ?", id).
+		Where("max_bytes IS NULL OR used_bytes + ? <= max_bytes", size).
+		Exec(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve share link bytes: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// ReleaseShareLinkBytes returns previously reserved bytes to a link
+func ReleaseShareLinkBytes(ctx context.Context, id int, size int64) error {
+	_, err := db.NewUpdate().Model((*ShareLinkModel)(nil)).
+		Set("used_bytes = GREATEST(used_bytes - ?, 0)", size).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to release share link bytes: %w", err)
+	}
+	return nil
+}
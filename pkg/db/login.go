@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// LoginFailureModel represents failed login counters for database operations
+type LoginFailureModel struct {
+	bun.BaseModel `bun:"table:login_failures"`
+	*model.LoginFailure
+}
+
+func wrapLoginFailure(mo *model.LoginFailure) *LoginFailureModel {
+	return &LoginFailureModel{LoginFailure: mo}
+}
+
+func unwrapLoginFailures(mos []*LoginFailureModel) []*model.LoginFailure {
+	failures := make([]*model.LoginFailure, len(mos))
+	for i, mo := range mos {
+		failures[i] = mo.LoginFailure
+	}
+	return failures
+}
+
+// GetLoginFailure retrieves the failure counter for a subject, returning nil if there is none
+func GetLoginFailure(ctx context.Context, kind, subject string) (*model.LoginFailure, error) {
+	mo := wrapLoginFailure(&model.LoginFailure{})
+	err := db.NewSelect().Model(mo).
+		Where("kind = ? AND subject = ?", kind, subject).
+		Scan(ctx)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+	return mo.LoginFailure, nil
+}
+
+// RecordLoginFailure increments the failure counter for a subject.
+// Failures older than window are forgotten, and once the counter reaches threshold
+// the subject is locked for coolDown. A zero threshold never locks.
+func RecordLoginFailure(ctx context.Context, kind, subject string, window time.Duration, threshold int, coolDown time.Duration) (*model.LoginFailure, error) {
+	now := time.Now()
+	mo := wrapLoginFailure(&model.LoginFailure{
+		Kind:        kind,
+		Subject:     subject,
+		Failures:    1,
+		LastFailure: now,
+	})
+
+	_, err := db.NewInsert().Model(mo).
+		On("CONFLICT (kind, subject) DO UPDATE").
+		Set("failures = CASE WHEN ?TableAlias.last_failure < ? THEN 1 ELSE ?TableAlias.failures + 1 END", now.Add(-window)).
+		Set("last_failure = EXCLUDED.last_failure").
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login failure: %w", err)
+	}
+
+	if threshold > 0 && mo.Failures >= threshold && !mo.IsLocked(now) {
+		lockedUntil := now.Add(coolDown)
+		mo.LockedUntil = &lockedUntil
+		_, err = db.NewUpdate().Model(mo).Column("locked_until").WherePK().Exec(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock %s %s: %w", kind, subject, err)
+		}
+	}
+
+	return mo.LoginFailure, nil
+}
+
+// ClearLoginFailure removes the failure counter and any lock for a subject
+func ClearLoginFailure(ctx context.Context, kind, subject string) error {
+	_, err := db.NewDelete().Model((*LoginFailureModel)(nil)).
+		Where("kind = ? AND subject = ?", kind, subject).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to clear login failures: %w", err)
+	}
+	return nil
+}
+
+// ListLoginFailures returns all failure counters of the given kind
+func ListLoginFailures(ctx context.Context, kind string) ([]*model.LoginFailure, error) {
+	var mos []*LoginFailureModel
+	err := db.NewSelect().Model(&mos).
+		Where("kind = ?", kind).
+		Order("last_failure DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list login failures: %w", err)
+	}
+	return unwrapLoginFailures(mos), nil
+}
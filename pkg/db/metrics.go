@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/uptrace/bun"
+)
+
+var (
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "filehub_db_query_duration_seconds",
+		Help:    "Time taken by database queries, by operation such as SELECT.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 4, 10),
+	}, []string{"operation"})
+
+	queryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "filehub_db_query_errors_total",
+		Help: "Database queries that failed, by operation. Selects finding no rows are not counted.",
+	}, []string{"operation"})
+
+	requestQueries = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "filehub_db_queries_per_request",
+		Help:    "Database queries run by one request, by route or gRPC method.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"route"})
+
+	queryBudgetExceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "filehub_db_query_budget_exceeded_total",
+		Help: "Requests that ran more database queries than the query budget, by route or gRPC method.",
+	}, []string{"route"})
+)
+
+// queryBudget is how many queries one request may run before it is logged, 0 for no limit
+var queryBudget int
+
+// SetQueryBudget sets how many queries one request may run before it is logged as running too many,
+// which usually means it looks rows up one by one
+func SetQueryBudget(budget int) {
+	queryBudget = budget
+}
+
+// queryHook observes every query run
+type queryHook struct{}
+
+func (queryHook) BeforeQuery(ctx context.Context, event *bun.QueryEvent) context.Context {
+	return ctx
+}
+
+func (queryHook) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	operation := event.Operation()
+	queryDuration.WithLabelValues(operation).Observe(time.Since(event.StartTime).Seconds())
+	if event.Err != nil && !errors.Is(event.Err, sql.ErrNoRows) {
+		queryErrors.WithLabelValues(operation).Inc()
+	}
+
+	if counter, ok := ctx.Value(queryCounterKey{}).(*atomic.Int64); ok {
+		counter.Add(1)
+	}
+}
+
+type queryCounterKey struct{}
+
+// CountQueries returns a context counting the queries run with it, and a function to call once the request
+// it belongs to is done. That function records how many queries the request ran under the given route,
+// logging requests over the budget.
+func CountQueries(ctx context.Context, route string) (context.Context, func()) {
+	counter := new(atomic.Int64)
+	return context.WithValue(ctx, queryCounterKey{}, counter), func() {
+		count := int(counter.Load())
+		requestQueries.WithLabelValues(route).Observe(float64(count))
+		if queryBudget > 0 && count > queryBudget {
+			queryBudgetExceeded.WithLabelValues(route).Inc()
+			log.Printf("Request to %s ran %d database queries, over the budget of %d", route, count, queryBudget)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+func TestCountQueries(t *testing.T) {
+	saved := queryBudget
+	t.Cleanup(func() { queryBudget = saved })
+	queryBudget = 2
+
+	hook := queryHook{}
+	exceeded := queryBudgetExceeded.WithLabelValues("/test/queries")
+	failed := queryErrors.WithLabelValues("SELECT")
+	before, failedBefore := testutil.ToFloat64(exceeded), testutil.ToFloat64(failed)
+
+	run := func(ctx context.Context, n int, err error) {
+		for range n {
+			hook.AfterQuery(ctx, &bun.QueryEvent{Query: "SELECT 1", StartTime: time.Now(), Err: err})
+		}
+	}
+
+	// Requests within the budget are only recorded
+	ctx, done := CountQueries(context.Background(), "/test/queries")
+	run(ctx, 2, sql.ErrNoRows)
+	done()
+	assert.Equal(t, before, testutil.ToFloat64(exceeded))
+	assert.Equal(t, failedBefore, testutil.ToFloat64(failed), "finding no rows is no failure")
+
+	// those over it counted, and queries outside of requests neither
+	ctx, done = CountQueries(context.Background(), "/test/queries")
+	run(ctx, 3, errors.New("connection refused"))
+	run(context.Background(), 5, nil)
+	done()
+	assert.Equal(t, before+1, testutil.ToFloat64(exceeded))
+	assert.Equal(t, failedBefore+3, testutil.ToFloat64(failed))
+
+	// Without a budget nothing is over it
+	queryBudget = 0
+	ctx, done = CountQueries(context.Background(), "/test/queries")
+	run(ctx, 10, nil)
+	done()
+	assert.Equal(t, before+1, testutil.ToFloat64(exceeded))
+}
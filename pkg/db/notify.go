@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// NotificationModel represents a notification for database operations
+type NotificationModel struct {
+	bun.BaseModel `bun:"table:notifications"`
+	*model.Notification
+}
+
+func wrapNotification(mo *model.Notification) *NotificationModel {
+	return &NotificationModel{Notification: mo}
+}
+
+func unwrapNotifications(mos []*NotificationModel) []*model.Notification {
+	notes := make([]*model.Notification, len(mos))
+	for i, mo := range mos {
+		notes[i] = mo.Notification
+	}
+	return notes
+}
+
+// CreateNotification stores a notification for a user
+func CreateNotification(ctx context.Context, note *model.Notification) error {
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = time.Now()
+	}
+
+	_, err := db.NewInsert().Model(wrapNotification(note)).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create notification: %w", err)
+	}
+	return nil
+}
+
+// ListNotifications returns a user's notifications, newest first
+func ListNotifications(ctx context.Context, userID int, unreadOnly bool, limit int) ([]*model.Notification, error) {
+	var mos []*NotificationModel
+	q := db.NewSelect().Model(&mos).Where("user_id = ?", userID)
+	if unreadOnly {
+		q = q.Where("read = ?", false)
+	}
+
+	err := q.Order("id DESC").Limit(limit).Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list notifications: %w", err)
+	}
+	return unwrapNotifications(mos), nil
+}
+
+// MarkNotificationsRead marks the given notifications of a user as read
+func MarkNotificationsRead(ctx context.Context, userID int, ids []int64) error {
+	_, err := db.NewUpdate().Model((*NotificationModel)(nil)).
+		Set("read = ?", true).
+		Where("user_id = ?", userID).
+		Where("id IN (?)", bun.In(ids)).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to mark notifications read: %w", err)
+	}
+	return nil
+}
+
+// NotifyRouteModel represents a notification route for database operations
+type NotifyRouteModel struct {
+	bun.BaseModel `bun:"table:notify_routes"`
+	*model.NotifyRoute
+}
+
+func wrapNotifyRoute(mo *model.NotifyRoute) *NotifyRouteModel {
+	return &NotifyRouteModel{NotifyRoute: mo}
+}
+
+func unwrapNotifyRoutes(mos []*NotifyRouteModel) []*model.NotifyRoute {
+	routes := make([]*model.NotifyRoute, len(mos))
+	for i, mo := range mos {
+		routes[i] = mo.NotifyRoute
+	}
+	return routes
+}
+
+// CreateNotifyRoute stores a new notification route
+func CreateNotifyRoute(ctx context.Context, route *model.NotifyRoute) error {
+	route.CreatedAt = time.Now()
+
+	_, err := db.NewInsert().Model(wrapNotifyRoute(route)).Returning("id").Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create notification route: %w", err)
+	}
+	return nil
+}
+
+// ListNotifyRoutes returns the notification routes of a user, only the active ones if activeOnly is set
+func ListNotifyRoutes(ctx context.Context, userID int, activeOnly bool) ([]*model.NotifyRoute, error) {
+	var mos []*NotifyRouteModel
+	query := db.NewSelect().Model(&mos).Where("user_id = ?", userID)
+	if activeOnly {
+		query = query.Where("active = ?", true)
+	}
+
+	if err := query.Order("id").Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list notification routes: %w", err)
+	}
+	return unwrapNotifyRoutes(mos), nil
+}
+
+// SetNotifyRouteActive enables or disables a notification route of a user
+func SetNotifyRouteActive(ctx context.Context, userID, id int, active bool) error {
+	result, err := db.NewUpdate().Model((*NotifyRouteModel)(nil)).
+		Set("active = ?", active).
+		Where("user_id = ? AND id = ?", userID, id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update notification route: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("notification route %d: %w", id, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// RecordNotifyDelivery stores the outcome of the latest delivery through a notification route,
+// counting the deliveries failed in a row
+func RecordNotifyDelivery(ctx context.Context, id int, at time.Time, errMsg string) error {
+	query := db.NewUpdate().Model((*NotifyRouteModel)(nil)).
+		Set("last_delivery_at = ?", at).
+		Set("last_error = ?", errMsg).
+		Where("id = ?", id)
+	if errMsg == "" {
+		query = query.Set("failures = 0")
+	} else {
+		query = query.Set("failures = failures + 1")
+	}
+
+	if _, err := query.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record notification delivery: %w", err)
+	}
+	return nil
+}
+
+// DeleteNotifyRoute removes a notification route of a user
+func DeleteNotifyRoute(ctx context.Context, userID, id int) error {
+	result, err := db.NewDelete().Model((*NotifyRouteModel)(nil)).
+		Where("user_id = ? AND id = ?", userID, id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete notification route: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("notification route %d: %w", id, sql.ErrNoRows)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// Operation is a request, such as an API call, whose change log and audit log entries share an ID,
+// so that everything one request did can be found together
+type Operation struct {
+	ID      string
+	Changes atomic.Int32 // change log entries recorded
+	Audited atomic.Int32 // audit log entries recorded
+}
+
+type operationKey struct{}
+
+// StartOperation returns a context stamping the entries recorded with it with a new operation ID,
+// or ctx itself when it is part of an operation already. started tells which.
+func StartOperation(ctx context.Context) (_ context.Context, op *Operation, started bool) {
+	if op := CurrentOperation(ctx); op != nil {
+		return ctx, op, false
+	}
+	op = &Operation{ID: uuid.NewString()}
+	return context.WithValue(ctx, operationKey{}, op), op, true
+}
+
+// CurrentOperation returns the operation a context is part of, nil outside of one
+func CurrentOperation(ctx context.Context) *Operation {
+	op, _ := ctx.Value(operationKey{}).(*Operation)
+	return op
+}
+
+// OperationID returns the ID of the operation a context is part of, empty outside of one
+func OperationID(ctx context.Context) string {
+	if op := CurrentOperation(ctx); op != nil {
+		return op.ID
+	}
+	return ""
+}
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartOperation(t *testing.T) {
+	assert.Empty(t, OperationID(context.Background()))
+
+	ctx, op, started := StartOperation(context.Background())
+	assert.True(t, started)
+	assert.Len(t, op.ID, 36)
+	assert.Equal(t, op.ID, OperationID(ctx))
+
+	// Calls inside an operation are part of it
+	inner, same, started := StartOperation(ctx)
+	assert.False(t, started)
+	assert.Same(t, op, same)
+	assert.Equal(t, op.ID, OperationID(inner))
+
+	_, other, _ := StartOperation(context.Background())
+	assert.NotEqual(t, op.ID, other.ID)
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// OrganizeRuleModel represents an inbox organization rule for database operations
+type OrganizeRuleModel struct {
+	bun.BaseModel `bun:"table:organize_rules"`
+	*model.OrganizeRule
+}
+
+func wrapOrganizeRule(mo *model.OrganizeRule) *OrganizeRuleModel {
+	return &OrganizeRuleModel{OrganizeRule: mo}
+}
+
+func unwrapOrganizeRules(mos []*OrganizeRuleModel) []*model.OrganizeRule {
+	rules := make([]*model.OrganizeRule, len(mos))
+	for i, mo := range mos {
+		rules[i] = mo.OrganizeRule
+	}
+	return rules
+}
+
+// SetOrganizeRule creates the organization rule of an inbox folder, or replaces its settings if one exists
+func SetOrganizeRule(ctx context.Context, rule *model.OrganizeRule) error {
+	rule.CreatedAt = time.Now()
+
+	_, err := db.NewInsert().Model(wrapOrganizeRule(rule)).
+		On("CONFLICT (repo_id, path) DO UPDATE").
+		Set("target = EXCLUDED.target").
+		Set("layout = EXCLUDED.layout").
+		Set("rename = EXCLUDED.rename").
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to set organize rule: %w", err)
+	}
+	return nil
+}
+
+// ListOrganizeRules returns the organization rules of a repository, or of all repositories when repoID is 0
+func ListOrganizeRules(ctx context.Context, repoID int) ([]*model.OrganizeRule, error) {
+	var mos []*OrganizeRuleModel
+	query := db.NewSelect().Model(&mos).Order("repo_id", "path")
+	if repoID != 0 {
+		query = query.Where("repo_id = ?", repoID)
+	}
+
+	if err := query.Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list organize rules: %w", err)
+	}
+	return unwrapOrganizeRules(mos), nil
+}
+
+// DeleteOrganizeRule removes the organization rule of an inbox folder
+func DeleteOrganizeRule(ctx context.Context, repoID int, path string) error {
+	result, err := db.NewDelete().Model((*OrganizeRuleModel)(nil)).
+		Where("repo_id = ? AND path = ?", repoID, path).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete organize rule: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("organize rule not found")
+	}
+	return nil
+}
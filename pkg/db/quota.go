@@ -38,11 +38,12 @@ func GetUserQuota(ctx context.Context, userID int) (*UserQuotaModel, error) {
 	return quota, nil
 }
 
-// UpdateUserQuota updates the storage quota for a user
+// UpdateUserQuota updates the storage quota for a user, leaving the bytes used as they are
 func UpdateUserQuota(ctx context.Context, userID int, totalQuotaBytes int64) error {
-	quota := &model.UserQuota{UserID: userID, TotalQuotaBytes: totalQuotaBytes, UpdatedAt: time.Now()}
 	result, err := db.NewUpdate().
-		Model(wrapQuota(quota)).
+		Model((*UserQuotaModel)(nil)).
+		Set("total_quota_bytes = ?", totalQuotaBytes).
+		Set("updated_at = ?", time.Now()).
 		Where("user_id = ?", userID).
 		Exec(ctx)
 
@@ -91,3 +92,33 @@ func CheckUserQuota(ctx context.Context, userID int, fileSize int64) (bool, erro
 	// Check if adding the new file would exceed the quota
 	return (quota.UsedBytes + fileSize) <= quota.TotalQuotaBytes, nil
 }
+
+// GetUserStorageUsage sums the sizes of the files, trashed ones included, in the repositories owned by a user
+func GetUserStorageUsage(ctx context.Context, userID int) (int64, error) {
+	repos := db.NewSelect().
+		Model((*ReposModel)(nil)).
+		Column("id").
+		Where("owner_id = ?", userID)
+
+	var files int64
+	err := db.NewSelect().
+		Model((*FileModel)(nil)).
+		ColumnExpr("COALESCE(SUM(size), 0)").
+		Where("repo_id IN (?) AND is_dir = ? AND deleted = ?", repos, false, false).
+		Scan(ctx, &files)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum file sizes: %w", err)
+	}
+
+	var trashed int64
+	err = db.NewSelect().
+		Model((*TrashItemModel)(nil)).
+		ColumnExpr("COALESCE(SUM(size), 0)").
+		Where("repo_id IN (?)", repos).
+		Scan(ctx, &trashed)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum trash sizes: %w", err)
+	}
+
+	return files + trashed, nil
+}
@@ -2,6 +2,8 @@ package db
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/cgang/file-hub/pkg/model"
 	"github.com/uptrace/bun"
@@ -69,3 +71,239 @@ func GetRepositoryByNameAndOwner(ctx context.Context, name string, userID int) (
 	}
 	return mo.Repository, nil
 }
+
+// UpdateRepositoryNetwork replaces the network access lists of a repository
+func UpdateRepositoryNetwork(ctx context.Context, id int, allow, deny []string) error {
+	mo := newRepos(id)
+	mo.AllowNets = allow
+	mo.DenyNets = deny
+	mo.UpdatedAt = time.Now()
+
+	result, err := db.NewUpdate().Model(mo).
+		Column("allow_nets", "deny_nets", "updated_at").
+		WherePK().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update repository network: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("repository not found")
+	}
+
+	return nil
+}
+
+// UpdateRepositoryLifecycle sets the storage root files of a repository move to once nobody opened them
+// for afterDays, an empty root keeping them in the repository
+func UpdateRepositoryLifecycle(ctx context.Context, id int, coldRoot string, afterDays int) error {
+	mo := newRepos(id)
+	mo.ColdRoot = coldRoot
+	mo.ColdAfterDays = afterDays
+	mo.UpdatedAt = time.Now()
+
+	result, err := db.NewUpdate().Model(mo).
+		Column("cold_root", "cold_after_days", "updated_at").
+		WherePK().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update repository lifecycle: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("repository not found")
+	}
+
+	return nil
+}
+
+// ListLifecycleRepositories returns the repositories moving files nobody opens to cold storage
+func ListLifecycleRepositories(ctx context.Context) ([]*model.Repository, error) {
+	var mos []*ReposModel
+	err := db.NewSelect().Model(&mos).Where("cold_root IS NOT NULL AND cold_after_days > 0").Order("id").Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repositories with a lifecycle: %w", err)
+	}
+	return unwrapReposes(mos), nil
+}
+
+// TransferBatchSize is how many file rows a repository transfer rewrites at once
+const TransferBatchSize = 1000
+
+// TransferRepository makes a user the owner of a repository. Its shares and share links, and the rules and
+// webhooks the former owner set up for it, follow in one transaction; shares with the new owner are dropped
+// since owners have full access. File rows are rewritten afterwards in batches, so that the transfer of a large
+// repository doesn't hold locks for long; transferring to the same user again finishes an interrupted transfer.
+// It returns the number of file rows rewritten.
+func TransferRepository(ctx context.Context, repoID, fromID, toID int) (int, error) {
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewUpdate().Model((*ReposModel)(nil)).
+			Set("owner_id = ?", toID).
+			Set("updated_at = ?", time.Now()).
+			Where("id = ?", repoID).
+			Exec(ctx); err != nil {
+			return fmt.Errorf("failed to update repository owner: %w", err)
+		}
+
+		if _, err := tx.NewDelete().Model((*ShareModel)(nil)).
+			Where("repo_id = ? AND user_id = ?", repoID, toID).
+			Exec(ctx); err != nil {
+			return fmt.Errorf("failed to drop shares with the new owner: %w", err)
+		}
+		if _, err := tx.NewUpdate().Model((*ShareModel)(nil)).
+			Set("owner_id = ?", toID).
+			Where("repo_id = ?", repoID).
+			Exec(ctx); err != nil {
+			return fmt.Errorf("failed to update shares: %w", err)
+		}
+		if _, err := tx.NewUpdate().Model((*ShareLinkModel)(nil)).
+			Set("owner_id = ?", toID).
+			Where("repo_id = ? AND owner_id = ?", repoID, fromID).
+			Exec(ctx); err != nil {
+			return fmt.Errorf("failed to update share links: %w", err)
+		}
+
+		for _, m := range []any{(*ExpiryRuleModel)(nil), (*OrganizeRuleModel)(nil), (*WebhookModel)(nil)} {
+			if _, err := tx.NewUpdate().Model(m).
+				Set("created_by = ?", toID).
+				Where("repo_id = ? AND created_by = ?", repoID, fromID).
+				Exec(ctx); err != nil {
+				return fmt.Errorf("failed to update repository settings: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for {
+		batch := db.NewSelect().
+			Model((*FileModel)(nil)).
+			Column("id").
+			Where("repo_id = ? AND owner_id <> ?", repoID, toID).
+			Limit(TransferBatchSize)
+		result, err := db.NewUpdate().
+			Model((*FileModel)(nil)).
+			Set("owner_id = ?", toID).
+			Where("id IN (?)", batch).
+			Exec(ctx)
+		if err != nil {
+			return total, fmt.Errorf("failed to update file owners: %w", err)
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		total += int(n)
+		if n < TransferBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// GetRepositoryStorageUsage sums the sizes of the files of a repository, trashed ones included
+func GetRepositoryStorageUsage(ctx context.Context, repoID int) (int64, error) {
+	var files int64
+	err := db.NewSelect().
+		Model((*FileModel)(nil)).
+		ColumnExpr("COALESCE(SUM(size), 0)").
+		Where("repo_id = ? AND is_dir = ? AND deleted = ?", repoID, false, false).
+		Scan(ctx, &files)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum file sizes: %w", err)
+	}
+
+	var trashed int64
+	err = db.NewSelect().
+		Model((*TrashItemModel)(nil)).
+		ColumnExpr("COALESCE(SUM(size), 0)").
+		Where("repo_id = ?", repoID).
+		Scan(ctx, &trashed)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum trash sizes: %w", err)
+	}
+
+	return files + trashed, nil
+}
+
+// RepositoryAliasModel represents a former repository name for database operations
+type RepositoryAliasModel struct {
+	bun.BaseModel `bun:"table:repository_aliases"`
+	*model.RepositoryAlias
+}
+
+// renameRepository renames a repository within tx, keeping its former name as an alias until aliasUntil.
+// An alias equal to the new name is dropped, since the name now belongs to the repository itself.
+func renameRepository(ctx context.Context, tx bun.Tx, repo *model.Repository, name string, aliasUntil time.Time) error {
+	if _, err := tx.NewUpdate().Model((*ReposModel)(nil)).
+		Set("name = ?", name).
+		Set("updated_at = ?", time.Now()).
+		Where("id = ?", repo.ID).
+		Exec(ctx); err != nil {
+		return fmt.Errorf("failed to rename repository: %w", err)
+	}
+
+	if _, err := tx.NewDelete().Model((*RepositoryAliasModel)(nil)).
+		Where("name = ?", name).
+		Exec(ctx); err != nil {
+		return fmt.Errorf("failed to drop repository alias: %w", err)
+	}
+
+	alias := &RepositoryAliasModel{RepositoryAlias: &model.RepositoryAlias{
+		Name:      repo.Name,
+		RepoID:    repo.ID,
+		ExpiresAt: aliasUntil,
+	}}
+	if _, err := tx.NewInsert().Model(alias).
+		On("CONFLICT (name) DO UPDATE").
+		Set("repo_id = EXCLUDED.repo_id").
+		Set("expires_at = EXCLUDED.expires_at").
+		Exec(ctx); err != nil {
+		return fmt.Errorf("failed to create repository alias: %w", err)
+	}
+	return nil
+}
+
+// GetRepositoryByAlias retrieves a repository by a former name that hasn't expired at now
+func GetRepositoryByAlias(ctx context.Context, name string, now time.Time) (*model.Repository, error) {
+	mo := &ReposModel{}
+	err := db.NewSelect().Model(mo).
+		Where("id = (?)", db.NewSelect().
+			Model((*RepositoryAliasModel)(nil)).
+			Column("repo_id").
+			Where("name = ? AND expires_at > ?", name, now)).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mo.Repository, nil
+}
+
+// PurgeRepositoryAliases removes the aliases expired before now, returning how many were removed
+func PurgeRepositoryAliases(ctx context.Context, now time.Time) (int, error) {
+	result, err := db.NewDelete().Model((*RepositoryAliasModel)(nil)).
+		Where("expires_at <= ?", now).
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge repository aliases: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return int(n), nil
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// FileTextModel represents the extracted text of a file for database operations
+type FileTextModel struct {
+	bun.BaseModel `bun:"table:file_text"`
+	*model.FileText
+}
+
+// searchHitModel is a file matching a search with the excerpt of its text that matched
+type searchHitModel struct {
+	bun.BaseModel `bun:"table:files"`
+	*model.FileObject
+	Snippet string `bun:"snippet,scanonly"`
+}
+
+// SetRepositoryOCR enables or disables text extraction for a repository
+func SetRepositoryOCR(ctx context.Context, id int, enabled bool) error {
+	mo := newRepos(id)
+	mo.OCR = enabled
+	mo.UpdatedAt = time.Now()
+
+	result, err := db.NewUpdate().Model(mo).
+		Column("ocr", "updated_at").
+		WherePK().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update repository: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("repository not found")
+	}
+
+	return nil
+}
+
+// ListPendingText returns up to limit files of repositories with text extraction enabled whose text
+// was never extracted or is older than the file. Only images and PDF documents up to maxSize are returned.
+func ListPendingText(ctx context.Context, maxSize int64, limit int) ([]*model.FileObject, error) {
+	var files []*FileModel
+	err := db.NewSelect().
+		Model(&files).
+		ModelTableExpr("files AS f").
+		ColumnExpr("f.*").
+		Join("JOIN repositories AS r ON r.id = f.repo_id").
+		Where("r.ocr AND NOT f.is_dir AND NOT f.deleted").
+		Where("f.size <= ?", maxSize).
+		Where("(f.mime_type LIKE 'image/%' OR f.mime_type = 'application/pdf')").
+		Where("NOT EXISTS (SELECT 1 FROM file_text AS t WHERE t.file_id = f.id AND t.extracted_at >= f.updated_at)").
+		Order("f.updated_at").
+		Limit(limit).
+		Scan(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files pending extraction: %w", err)
+	}
+
+	return unwrapFiles(files), nil
+}
+
+// SaveFileText stores the text extracted from a file, replacing what was extracted before
+func SaveFileText(ctx context.Context, text *model.FileText) error {
+	_, err := db.NewInsert().Model(&FileTextModel{FileText: text}).
+		On("CONFLICT (file_id) DO UPDATE").
+		Set("content = EXCLUDED.content").
+		Set("error = EXCLUDED.error").
+		Set("extracted_at = EXCLUDED.extracted_at").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to save file text: %w", err)
+	}
+	return nil
+}
+
+// DeleteRepositoryText drops the text extracted from the files of a repository, so that it is extracted
+// again, returning how many files had text
+func DeleteRepositoryText(ctx context.Context, repoID int) (int, error) {
+	result, err := db.NewDelete().
+		Model((*FileTextModel)(nil)).
+		Where("repo_id = ?", repoID).
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete file text: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return int(n), nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// SearchFiles returns up to limit files of a repository whose name contains the query
+// or whose extracted text matches its words
+func SearchFiles(ctx context.Context, repoID int, query string, limit int) ([]*model.SearchHit, error) {
+	var mos []*searchHitModel
+	err := db.NewSelect().
+		Model(&mos).
+		ModelTableExpr("files AS f").
+		ColumnExpr("f.*").
+		ColumnExpr("COALESCE(ts_headline('simple', t.content, plainto_tsquery('simple', ?), 'MaxFragments=1'), '') AS snippet", query).
+		Join("LEFT JOIN file_text AS t ON t.file_id = f.id AND t.tsv @@ plainto_tsquery('simple', ?)", query).
+		Where("f.repo_id = ? AND NOT f.deleted", repoID).
+		Where("(f.name ILIKE ? OR t.file_id IS NOT NULL)", "%"+escapeLike(query)+"%").
+		Order("f.path").
+		Limit(limit).
+		Scan(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to search files: %w", err)
+	}
+
+	hits := make([]*model.SearchHit, len(mos))
+	for i, mo := range mos {
+		hits[i] = &model.SearchHit{File: mo.FileObject, Snippet: mo.Snippet}
+	}
+	return hits, nil
+}
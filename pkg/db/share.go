@@ -2,8 +2,10 @@ package db
 
 import (
 	"context"
+	"fmt"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/cgang/file-hub/pkg/model"
 	"github.com/uptrace/bun"
@@ -45,9 +47,17 @@ func GetShareByID(ctx context.Context, id int) (*model.Share, error) {
 	return mo.Share, nil
 }
 
+// whereUnexpired limits a query to shares or links that have not expired at now
+func whereUnexpired(q *bun.SelectQuery, now time.Time) *bun.SelectQuery {
+	return q.Where("expires_at IS NULL OR expires_at > ?", now)
+}
+
+// GetShareForObject returns the most specific unexpired share of the resource with the user,
+// nil if there is none
 func GetShareForObject(ctx context.Context, userID int, res *model.Resource) (*model.Share, error) {
 	var mos []*ShareModel
-	err := db.NewSelect().Model(&mos).Where("user_id = ? AND repo_id = ?", userID, res.Repo.ID).Scan(ctx)
+	q := db.NewSelect().Model(&mos).Where("user_id = ? AND repo_id = ?", userID, res.Repo.ID)
+	err := whereUnexpired(q, time.Now()).Scan(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -58,7 +68,7 @@ func GetShareForObject(ctx context.Context, userID int, res *model.Resource) (*m
 	})
 
 	for _, mo := range mos {
-		if strings.HasPrefix(res.Path, mo.Path) {
+		if mo.Path == "" || res.Path == mo.Path || strings.HasPrefix(res.Path, mo.Path+"/") {
 			return mo.Share, nil
 		}
 	}
@@ -66,9 +76,11 @@ func GetShareForObject(ctx context.Context, userID int, res *model.Resource) (*m
 	return nil, nil
 }
 
+// GetSharesByUserID returns the unexpired shares with a user
 func GetSharesByUserID(ctx context.Context, userID int) ([]*model.Share, error) {
 	var mos []*ShareModel
-	err := db.NewSelect().Model(&mos).Where("user_id = ?", userID).Scan(ctx)
+	q := db.NewSelect().Model(&mos).Where("user_id = ?", userID)
+	err := whereUnexpired(q, time.Now()).Scan(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -81,3 +93,16 @@ func DeleteShareByID(ctx context.Context, id int) error {
 	_, err := db.NewDelete().Model(mo).WherePK().Exec(ctx)
 	return err
 }
+
+// PurgeExpiredShares removes the shares expired before now, returning them so their owners can be told
+func PurgeExpiredShares(ctx context.Context, now time.Time) ([]*model.Share, error) {
+	var mos []*ShareModel
+	_, err := db.NewDelete().Model((*ShareModel)(nil)).
+		Where("expires_at <= ?", now).
+		Returning("*").
+		Exec(ctx, &mos)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge expired shares: %w", err)
+	}
+	return unwrapShares(mos), nil
+}
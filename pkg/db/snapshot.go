@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// SnapshotFileModel represents a file of a snapshot link for database operations
+type SnapshotFileModel struct {
+	bun.BaseModel `bun:"table:snapshot_files"`
+	*model.SnapshotFile
+}
+
+func wrapSnapshotFile(mo *model.SnapshotFile) *SnapshotFileModel {
+	return &SnapshotFileModel{SnapshotFile: mo}
+}
+
+func unwrapSnapshotFiles(mos []*SnapshotFileModel) []*model.SnapshotFile {
+	files := make([]*model.SnapshotFile, len(mos))
+	for i, mo := range mos {
+		files[i] = mo.SnapshotFile
+	}
+	return files
+}
+
+// GetSnapshotFile retrieves a file of a snapshot link by the path it was published under
+func GetSnapshotFile(ctx context.Context, linkID int, path string) (*model.SnapshotFile, error) {
+	mo := wrapSnapshotFile(&model.SnapshotFile{})
+	err := db.NewSelect().Model(mo).Where("link_id = ? AND path = ?", linkID, path).Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot file %s: %w", path, err)
+	}
+	return mo.SnapshotFile, nil
+}
+
+// ListSnapshotChildren returns the files a folder of a snapshot link held when published, in name order
+func ListSnapshotChildren(ctx context.Context, linkID int, parent string) ([]*model.SnapshotFile, error) {
+	var mos []*SnapshotFileModel
+	err := db.NewSelect().Model(&mos).
+		Where("link_id = ? AND parent = ? AND path <> parent", linkID, parent).
+		Order("path").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshot files: %w", err)
+	}
+	return unwrapSnapshotFiles(mos), nil
+}
+
+// ListSnapshotFiles returns the files of a snapshot link that have stored content
+func ListSnapshotFiles(ctx context.Context, linkID int) ([]*model.SnapshotFile, error) {
+	var mos []*SnapshotFileModel
+	err := db.NewSelect().Model(&mos).
+		Where("link_id = ? AND stored_name IS NOT NULL", linkID).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshot files: %w", err)
+	}
+	return unwrapSnapshotFiles(mos), nil
+}
+
+// ListExpiringSnapshotFiles returns the stored files of snapshot links expired before now, which
+// PurgeExpiredShareLinks removes along with their links
+func ListExpiringSnapshotFiles(ctx context.Context, now time.Time) ([]*model.SnapshotFile, error) {
+	var mos []*SnapshotFileModel
+	err := db.NewSelect().Model(&mos).
+		Where("stored_name IS NOT NULL").
+		Where("link_id IN (?)", db.NewSelect().Model((*ShareLinkModel)(nil)).Column("id").Where("expires_at <= ?", now)).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshot files: %w", err)
+	}
+	return unwrapSnapshotFiles(mos), nil
+}
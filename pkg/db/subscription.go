@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// SubscriptionModel represents a path subscription for database operations
+type SubscriptionModel struct {
+	bun.BaseModel `bun:"table:subscriptions"`
+	*model.Subscription
+}
+
+func wrapSubscription(mo *model.Subscription) *SubscriptionModel {
+	return &SubscriptionModel{Subscription: mo}
+}
+
+func unwrapSubscriptions(mos []*SubscriptionModel) []*model.Subscription {
+	subs := make([]*model.Subscription, len(mos))
+	for i, mo := range mos {
+		subs[i] = mo.Subscription
+	}
+	return subs
+}
+
+// SaveSubscription subscribes a user to a path, replacing the channels of an existing subscription to it
+func SaveSubscription(ctx context.Context, sub *model.Subscription) error {
+	sub.CreatedAt = time.Now()
+
+	_, err := db.NewInsert().Model(wrapSubscription(sub)).
+		On("CONFLICT (user_id, repo_id, path) DO UPDATE").
+		Set("channels = EXCLUDED.channels").
+		Set("webhook_url = EXCLUDED.webhook_url").
+		Set("secret = EXCLUDED.secret").
+		Returning("id, created_at").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to save subscription: %w", err)
+	}
+	return nil
+}
+
+// ListSubscriptions returns the subscriptions of a user
+func ListSubscriptions(ctx context.Context, userID int) ([]*model.Subscription, error) {
+	var mos []*SubscriptionModel
+	err := db.NewSelect().Model(&mos).
+		Where("user_id = ?", userID).
+		Order("repo_id", "path").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscriptions: %w", err)
+	}
+	return unwrapSubscriptions(mos), nil
+}
+
+// ListRepoSubscriptions returns the subscriptions to paths of a repository
+func ListRepoSubscriptions(ctx context.Context, repoID int) ([]*model.Subscription, error) {
+	var mos []*SubscriptionModel
+	err := db.NewSelect().Model(&mos).
+		Where("repo_id = ?", repoID).
+		Order("id").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscriptions of repository %d: %w", repoID, err)
+	}
+	return unwrapSubscriptions(mos), nil
+}
+
+// DeleteSubscription removes a subscription of a user
+func DeleteSubscription(ctx context.Context, userID, id int) error {
+	result, err := db.NewDelete().Model((*SubscriptionModel)(nil)).
+		Where("user_id = ? AND id = ?", userID, id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete subscription: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("subscription %d: %w", id, sql.ErrNoRows)
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -19,6 +21,11 @@ type RepositoryVersionModel struct {
 	*model.RepositoryVersion
 }
 
+type SyncClientModel struct {
+	bun.BaseModel `bun:"table:sync_clients"`
+	*model.SyncClient
+}
+
 type UploadSessionModel struct {
 	bun.BaseModel `bun:"table:upload_sessions"`
 	*model.UploadSession
@@ -67,24 +74,62 @@ func unwrapUploadChunks(ucs []*UploadChunkModel) []*model.UploadChunk {
 
 func RecordChange(ctx context.Context, change *model.ChangeLog) error {
 	change.Timestamp = time.Now()
+	op := CurrentOperation(ctx)
+	if change.OperationID == "" && op != nil {
+		change.OperationID = op.ID
+	}
 	_, err := db.NewInsert().Model(wrapChangeLog(change)).Exec(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to record change: %w", err)
 	}
+	if op != nil {
+		op.Changes.Add(1)
+	}
 	return nil
 }
 
+// GetCurrentVersion returns the version of a repository.
+// Repositories created before versions were initialized with them get the zero version on first read.
 func GetCurrentVersion(ctx context.Context, repoID int) (*model.RepositoryVersion, error) {
+	rv, err := getVersion(ctx, repoID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := InitVersion(ctx, repoID); err != nil {
+			return nil, err
+		}
+		rv, err = getVersion(ctx, repoID)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository version: %w", err)
+	}
+	return rv, nil
+}
+
+func getVersion(ctx context.Context, repoID int) (*model.RepositoryVersion, error) {
 	var rv RepositoryVersionModel
 	err := db.NewSelect().
 		Model(&rv).
 		Where("repo_id = ?", repoID).
 		Scan(ctx)
+	return rv.RepositoryVersion, err
+}
+
+// InitVersion creates the version row of a repository at the zero version, unless it has one already
+func InitVersion(ctx context.Context, repoID int) error {
+	_, err := db.NewInsert().
+		Model(wrapRepositoryVersion(&model.RepositoryVersion{
+			RepoID:         repoID,
+			CurrentVersion: model.ZeroVersion,
+			VersionVector:  "{}",
+			UpdatedAt:      time.Now(),
+		})).
+		On("CONFLICT (repo_id) DO NOTHING").
+		Exec(ctx)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to get repository version: %w", err)
+		return fmt.Errorf("failed to initialize repository version: %w", err)
 	}
-	return rv.RepositoryVersion, nil
+	return nil
 }
 
 func UpdateVersion(ctx context.Context, repoID int, newVersion string, versionVector string) error {
@@ -108,7 +153,127 @@ func UpdateVersion(ctx context.Context, repoID int, newVersion string, versionVe
 	return nil
 }
 
-func GetChangesSince(ctx context.Context, repoID int, sinceVersion string, limit int) ([]*model.ChangeLog, error) {
+// LastChangeID returns the ID of the latest change of a repository, 0 if it has none
+func LastChangeID(ctx context.Context, repoID int) (int, error) {
+	var id int
+	err := db.NewSelect().
+		Model((*ChangeLogModel)(nil)).
+		ColumnExpr("COALESCE(MAX(id), 0)").
+		Where("repo_id = ?", repoID).
+		Scan(ctx, &id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last change: %w", err)
+	}
+	return id, nil
+}
+
+// RecordSyncClient stores the version a device acknowledged for a repository, registering the device on
+// its first report
+func RecordSyncClient(ctx context.Context, client *model.SyncClient) error {
+	client.LastSeenAt = time.Now()
+	_, err := db.NewInsert().
+		Model(&SyncClientModel{SyncClient: client}).
+		On("CONFLICT (repo_id, user_id, device_id) DO UPDATE").
+		Set("acked_version = EXCLUDED.acked_version").
+		Set("last_seen_at = EXCLUDED.last_seen_at").
+		Returning("id").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to record sync client: %w", err)
+	}
+	return nil
+}
+
+// ListSyncClients returns the devices syncing a repository, or every repository when repoID is 0
+func ListSyncClients(ctx context.Context, repoID int) ([]*model.SyncClient, error) {
+	var mos []*SyncClientModel
+	query := db.NewSelect().Model(&mos).Order("repo_id", "user_id", "device_id")
+	if repoID != 0 {
+		query = query.Where("repo_id = ?", repoID)
+	}
+	if err := query.Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list sync clients: %w", err)
+	}
+
+	clients := make([]*model.SyncClient, len(mos))
+	for i, mo := range mos {
+		clients[i] = mo.SyncClient
+	}
+	return clients, nil
+}
+
+// PendingChanges returns how many changes of a repository were recorded after a version, and when the
+// oldest of them was, the zero time if there are none
+func PendingChanges(ctx context.Context, repoID int, sinceVersion string) (int, time.Time, error) {
+	var pending struct {
+		Count  int
+		Oldest sql.NullTime
+	}
+	err := db.NewSelect().
+		Model((*ChangeLogModel)(nil)).
+		ColumnExpr("COUNT(*) AS count, MIN(timestamp) AS oldest").
+		Where("repo_id = ? AND version > ?", repoID, sinceVersion).
+		Scan(ctx, &pending)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to count changes since version %s: %w", sinceVersion, err)
+	}
+	return pending.Count, pending.Oldest.Time, nil
+}
+
+// filterChanges limits a change log query to the changes matching filter. Moves and renames match the
+// path prefix by where they came from too.
+func filterChanges(query *bun.SelectQuery, filter model.ChangeFilter) *bun.SelectQuery {
+	if filter.PathPrefix != "" && filter.PathPrefix != "/" {
+		pattern := escapeLike(filter.PathPrefix) + "/%"
+		query = query.Where("(path = ? OR path LIKE ? OR (operation IN ('move', 'rename') AND (old_path = ? OR old_path LIKE ?)))",
+			filter.PathPrefix, pattern, filter.PathPrefix, pattern)
+	}
+	if len(filter.Operations) > 0 {
+		query = query.Where("operation IN (?)", bun.In(filter.Operations))
+	}
+	return query
+}
+
+// ListChangesAfter returns up to limit changes of a repository matching filter recorded after the given change, oldest first
+func ListChangesAfter(ctx context.Context, repoID, afterID int, filter model.ChangeFilter, limit int) ([]*model.ChangeLog, error) {
+	var changes []*ChangeLogModel
+	query := db.NewSelect().
+		Model(&changes).
+		Where("repo_id = ? AND id > ?", repoID, afterID)
+	err := filterChanges(query, filter).
+		Order("id ASC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changes after %d: %w", afterID, err)
+	}
+
+	result := make([]*model.ChangeLog, len(changes))
+	for i, c := range changes {
+		result[i] = c.ChangeLog
+	}
+	return result, nil
+}
+
+// ListChangesByOperation returns the change log entries of an operation, oldest first
+func ListChangesByOperation(ctx context.Context, operationID string) ([]*model.ChangeLog, error) {
+	var changes []*ChangeLogModel
+	err := db.NewSelect().Model(&changes).
+		Where("operation_id = ?", operationID).
+		Order("id").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changes of operation: %w", err)
+	}
+
+	result := make([]*model.ChangeLog, len(changes))
+	for i, change := range changes {
+		result[i] = change.ChangeLog
+	}
+	return result, nil
+}
+
+func GetChangesSince(ctx context.Context, repoID int, sinceVersion string, filter model.ChangeFilter, limit int) ([]*model.ChangeLog, error) {
 	var changes []*ChangeLogModel
 
 	query := db.NewSelect().
@@ -119,7 +284,7 @@ func GetChangesSince(ctx context.Context, repoID int, sinceVersion string, limit
 		query = query.Where("version > ?", sinceVersion)
 	}
 
-	query = query.
+	query = filterChanges(query, filter).
 		Order("id ASC").
 		Limit(limit)
 
@@ -169,24 +334,42 @@ func UpdateUploadSessionStatus(ctx context.Context, uploadID string, status stri
 	return nil
 }
 
-func IncrementUploadedChunks(ctx context.Context, uploadID string) error {
+// UpdateUploadedChunks sets the uploaded chunk count of a session from its recorded chunks and records
+// activity on it. Counting rather than incrementing keeps the count right when a chunk is sent twice,
+// or when chunks of the same upload arrive concurrently.
+func UpdateUploadedChunks(ctx context.Context, uploadID string) error {
+	count := db.NewSelect().
+		Model((*UploadChunkModel)(nil)).
+		ColumnExpr("COUNT(*)").
+		Where("upload_id = ?", uploadID)
+
 	_, err := db.NewUpdate().
 		Model((*UploadSessionModel)(nil)).
-		Set("chunks_uploaded = chunks_uploaded + 1").
+		Set("chunks_uploaded = (?)", count).
+		Set("last_activity_at = ?", time.Now()).
 		Where("upload_id = ?", uploadID).
 		Exec(ctx)
 
 	if err != nil {
-		return fmt.Errorf("failed to increment uploaded chunks: %w", err)
+		return fmt.Errorf("failed to update uploaded chunks: %w", err)
 	}
 	return nil
 }
 
-func CreateUploadChunk(ctx context.Context, chunk *model.UploadChunk) error {
+// UpsertUploadChunk records a received chunk. A chunk sent again, such as after a network retry,
+// replaces its earlier record instead of failing.
+func UpsertUploadChunk(ctx context.Context, chunk *model.UploadChunk) error {
 	chunk.UploadedAt = time.Now()
-	_, err := db.NewInsert().Model(wrapUploadChunk(chunk)).Exec(ctx)
+	_, err := db.NewInsert().
+		Model(wrapUploadChunk(chunk)).
+		On("CONFLICT (upload_id, chunk_index) DO UPDATE").
+		Set(`"offset" = EXCLUDED."offset"`).
+		Set("size = EXCLUDED.size").
+		Set("checksum = EXCLUDED.checksum").
+		Set("uploaded_at = EXCLUDED.uploaded_at").
+		Exec(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create upload chunk: %w", err)
+		return fmt.Errorf("failed to store upload chunk: %w", err)
 	}
 	return nil
 }
@@ -218,19 +401,55 @@ func GetUploadChunk(ctx context.Context, uploadID string, chunkIndex int) (*mode
 	return uc.UploadChunk, nil
 }
 
-func CleanupExpiredUploadSessions(ctx context.Context) error {
-	_, err := db.NewDelete().
+// ExtendUploadSession moves the expiry of an upload session and records activity on it
+func ExtendUploadSession(ctx context.Context, uploadID string, expiresAt, now time.Time) error {
+	_, err := db.NewUpdate().
+		Model((*UploadSessionModel)(nil)).
+		Set("expires_at = ?", expiresAt).
+		Set("last_activity_at = ?", now).
+		Where("upload_id = ?", uploadID).
+		Exec(ctx)
+
+	if err != nil {
+		return fmt.Errorf("failed to extend upload session %s: %w", uploadID, err)
+	}
+	return nil
+}
+
+// FailUploadSession marks an upload session that could not be stored as failed, keeping it until expiresAt
+// so that it can be finalized again
+func FailUploadSession(ctx context.Context, uploadID string, expiresAt time.Time) error {
+	_, err := db.NewUpdate().
 		Model((*UploadSessionModel)(nil)).
-		Where("expires_at < ?", time.Now()).
-		Where("status != ?", "completed").
+		Set("status = ?", model.UploadFailed).
+		Set("expires_at = ?", expiresAt).
+		Where("upload_id = ?", uploadID).
 		Exec(ctx)
 
 	if err != nil {
-		return fmt.Errorf("failed to cleanup expired upload sessions: %w", err)
+		return fmt.Errorf("failed to mark upload session %s failed: %w", uploadID, err)
 	}
 	return nil
 }
 
+// CleanupExpiredUploadSessions removes unfinished sessions that expired before now, or active ones
+// that saw no activity since idleSince. A zero idleSince skips the idle check.
+// It returns the upload IDs of the removed sessions.
+func CleanupExpiredUploadSessions(ctx context.Context, now, idleSince time.Time) ([]string, error) {
+	var uploadIDs []string
+	_, err := db.NewDelete().
+		Model((*UploadSessionModel)(nil)).
+		Where("expires_at < ? OR (status = ? AND last_activity_at < ?)", now, model.UploadActive, idleSince).
+		Where("status != ?", model.UploadCompleted).
+		Returning("upload_id").
+		Exec(ctx, &uploadIDs)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to cleanup expired upload sessions: %w", err)
+	}
+	return uploadIDs, nil
+}
+
 func DeleteUploadSession(ctx context.Context, uploadID string) error {
 	_, err := db.NewDelete().
 		Model((*UploadSessionModel)(nil)).
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// TrashItemModel represents a trashed file for database operations
+type TrashItemModel struct {
+	bun.BaseModel `bun:"table:trash"`
+	*model.TrashItem
+}
+
+func wrapTrashItem(mo *model.TrashItem) *TrashItemModel {
+	return &TrashItemModel{TrashItem: mo}
+}
+
+func unwrapTrashItems(mos []*TrashItemModel) []*model.TrashItem {
+	items := make([]*model.TrashItem, len(mos))
+	for i, mo := range mos {
+		items[i] = mo.TrashItem
+	}
+	return items
+}
+
+// CreateTrashItem records a file moved to the trash
+func CreateTrashItem(ctx context.Context, item *model.TrashItem) error {
+	item.DeletedAt = time.Now()
+
+	_, err := db.NewInsert().Model(wrapTrashItem(item)).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create trash item: %w", err)
+	}
+	return nil
+}
+
+// GetTrashItem retrieves a trashed file of a repository
+func GetTrashItem(ctx context.Context, repoID int, id int64) (*model.TrashItem, error) {
+	mo := wrapTrashItem(&model.TrashItem{})
+	err := db.NewSelect().Model(mo).Where("id = ? AND repo_id = ?", id, repoID).Scan(ctx)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("trash item not found")
+		}
+		return nil, fmt.Errorf("failed to get trash item: %w", err)
+	}
+	return mo.TrashItem, nil
+}
+
+// ListTrashItems returns the trashed files of a repository, most recently deleted first
+func ListTrashItems(ctx context.Context, repoID int) ([]*model.TrashItem, error) {
+	var mos []*TrashItemModel
+	err := db.NewSelect().Model(&mos).
+		Where("repo_id = ?", repoID).
+		Order("deleted_at DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trash items: %w", err)
+	}
+	return unwrapTrashItems(mos), nil
+}
+
+// ListTrashItemsBefore returns trashed files of all repositories deleted before the given time
+func ListTrashItemsBefore(ctx context.Context, before time.Time) ([]*model.TrashItem, error) {
+	var mos []*TrashItemModel
+	err := db.NewSelect().Model(&mos).
+		Where("deleted_at < ?", before).
+		Order("deleted_at").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trash items: %w", err)
+	}
+	return unwrapTrashItems(mos), nil
+}
+
+// DeleteTrashItem removes the record of a trashed file
+func DeleteTrashItem(ctx context.Context, id int64) error {
+	_, err := db.NewDelete().Model((*TrashItemModel)(nil)).Where("id = ?", id).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete trash item: %w", err)
+	}
+	return nil
+}
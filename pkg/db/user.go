@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -105,6 +106,41 @@ func GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
 	return user.User, nil
 }
 
+// ListUsers returns all users, including inactive ones, ordered by username
+func ListUsers(ctx context.Context) ([]*model.User, error) {
+	var mos []*UserModel
+	err := db.NewSelect().Model(&mos).Order("username").Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	users := make([]*model.User, len(mos))
+	for i, mo := range mos {
+		users[i] = mo.User
+	}
+	return users, nil
+}
+
+// GetUsersByIDs returns the users with the given IDs, including inactive ones, in one query.
+// IDs without a user are left out.
+func GetUsersByIDs(ctx context.Context, ids []int) ([]*model.User, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var mos []*UserModel
+	err := db.NewSelect().Model(&mos).Where("id IN (?)", bun.In(ids)).Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+
+	users := make([]*model.User, len(mos))
+	for i, mo := range mos {
+		users[i] = mo.User
+	}
+	return users, nil
+}
+
 func CountUsers(ctx context.Context) (int, error) {
 	count, err := db.NewSelect().Model((*UserModel)(nil)).Count(ctx)
 	if err != nil {
@@ -113,6 +149,9 @@ func CountUsers(ctx context.Context) (int, error) {
 	return count, nil
 }
 
+// ErrUserChanged is returned updating a user that was changed since the version the update was made from
+var ErrUserChanged = errors.New("user was changed since it was read")
+
 // UserUpdate contains fields that can be updated for a user
 type UserUpdate struct {
 	FirstName *string    `json:"first_name,omitempty"`
@@ -120,93 +159,188 @@ type UserUpdate struct {
 	LastLogin *time.Time `json:"last_login,omitempty"`
 	IsActive  *bool      `json:"is_active,omitempty"`
 	IsAdmin   *bool      `json:"is_admin,omitempty"`
+	Locale    *string    `json:"locale,omitempty"`
+	// TOTPSecret is never bound from requests; an empty secret turns one-time codes off
+	TOTPSecret *string `json:"-"`
+	// Version is the version of the user the update was made from, nil to update whatever is current
+	Version *int `json:"version,omitempty"`
 }
 
-// UpdateUser updates a user in the database
+// UpdateUser sets the fields given in the update, leaving the others as they are in the database, so that
+// concurrent updates of different fields are all kept. An update made from a version of the user that is no
+// longer current fails with ErrUserChanged.
 func UpdateUser(ctx context.Context, id int, update *UserUpdate) error {
-	user := newUserModel(id)
+	q := db.NewUpdate().Model((*UserModel)(nil)).
+		Set("version = version + 1").
+		Set("updated_at = ?", time.Now()).
+		Where("id = ?", id)
 
-	// Get the existing user first
-	err := db.NewSelect().Model(user).Where("id = ?", id).Scan(ctx)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("user not found")
-		}
-		return fmt.Errorf("failed to get user: %w", err)
-	}
-
-	// Update fields if they are provided
 	if update.FirstName != nil {
-		user.FirstName = update.FirstName
+		q.Set("first_name = ?", *update.FirstName)
 	}
 	if update.LastName != nil {
-		user.LastName = update.LastName
+		q.Set("last_name = ?", *update.LastName)
 	}
 	if update.LastLogin != nil {
-		user.LastLogin = update.LastLogin
+		q.Set("last_login = ?", *update.LastLogin)
 	}
 	if update.IsActive != nil {
-		user.IsActive = *update.IsActive
+		q.Set("is_active = ?", *update.IsActive)
 	}
 	if update.IsAdmin != nil {
-		user.IsAdmin = *update.IsAdmin
+		q.Set("is_admin = ?", *update.IsAdmin)
+	}
+	if update.Locale != nil {
+		q.Set("locale = ?", *update.Locale)
+	}
+	if update.TOTPSecret != nil {
+		q.Set("totp_secret = ?", *update.TOTPSecret)
+	}
+	if update.Version != nil {
+		q.Where("version = ?", *update.Version)
 	}
 
-	user.UpdatedAt = time.Now()
-
-	result, err := db.NewUpdate().Model(user).Where("id = ?", id).Exec(ctx)
+	result, err := q.Exec(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
+	return checkUserUpdated(ctx, id, result, update.Version != nil)
+}
 
+// checkUserUpdated tells apart a user that is missing from one changed since the expected version
+func checkUserUpdated(ctx context.Context, id int, result sql.Result, versioned bool) error {
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return fmt.Errorf("failed to get rows affected: %w", err)
 	}
-
-	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
+	if rowsAffected > 0 {
+		return nil
 	}
 
-	return nil
+	if versioned {
+		exists, err := db.NewSelect().Model((*UserModel)(nil)).Where("id = ?", id).Exists(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		if exists {
+			return ErrUserChanged
+		}
+	}
+	return fmt.Errorf("user %d not found: %w", id, sql.ErrNoRows)
 }
 
 // DeleteUser marks a user as inactive (soft delete)
 func DeleteUser(ctx context.Context, id int) error {
-	user := &model.User{ID: id, IsActive: false, UpdatedAt: time.Now()}
-	result, err := db.NewUpdate().Model(wrapUser(user)).Where("id = ?", id).Exec(ctx)
+	result, err := db.NewUpdate().Model((*UserModel)(nil)).
+		Set("is_active = ?", false).
+		Set("version = version + 1").
+		Set("updated_at = ?", time.Now()).
+		Where("id = ?", id).
+		Exec(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	}
-
-	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
-	}
-
-	return nil
+	return checkUserUpdated(ctx, id, result, false)
 }
 
 // UpdateUserHA1 updates a user's HA1 hash and realm
 func UpdateUserHA1(ctx context.Context, id int, ha1 string) error {
-	user := &model.User{ID: id, HA1: ha1, UpdatedAt: time.Now()}
-	result, err := db.NewUpdate().Model(wrapUser(user)).Where("id = ?", id).Exec(ctx)
+	result, err := db.NewUpdate().Model((*UserModel)(nil)).
+		Set("ha1_hash = ?", ha1).
+		Set("version = version + 1").
+		Set("updated_at = ?", time.Now()).
+		Where("id = ?", id).
+		Exec(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to update user HA1: %w", err)
 	}
+	return checkUserUpdated(ctx, id, result, false)
+}
 
-	rowsAffected, err := result.RowsAffected()
+// RenameUser changes the username of a user together with the HA1 hash, which is derived from it.
+// When home is set, that repository takes the new name in the same transaction, its former name
+// kept as an alias until aliasUntil. App passwords, whose hashes are derived from the username too, are deleted.
+func RenameUser(ctx context.Context, id int, username, ha1 string, home *model.Repository, aliasUntil time.Time) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		result, err := tx.NewUpdate().Model((*UserModel)(nil)).
+			Set("username = ?", username).
+			Set("ha1_hash = ?", ha1).
+			Set("version = version + 1").
+			Set("updated_at = ?", time.Now()).
+			Where("id = ?", id).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to rename user: %w", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+
+		if rowsAffected == 0 {
+			return fmt.Errorf("user not found")
+		}
+
+		_, err = tx.NewDelete().Model((*AppPasswordModel)(nil)).Where("user_id = ?", id).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete app passwords: %w", err)
+		}
+
+		if home == nil {
+			return nil
+		}
+		return renameRepository(ctx, tx, home, username, aliasUntil)
+	})
+}
+
+// GetUserPreferences returns the JSON object of settings a user's clients keep on the server
+func GetUserPreferences(ctx context.Context, id int) ([]byte, error) {
+	var prefs []byte
+	err := db.NewSelect().Model((*UserModel)(nil)).
+		Column("preferences").
+		Where("id = ?", id).
+		Scan(ctx, &prefs)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to get preferences: %w", err)
 	}
+	return prefs, nil
+}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
-	}
+// UpdateUserPreferences replaces the preferences of a user with what update makes of the current ones.
+// The row is locked meanwhile, so that concurrent updates of different settings are all kept.
+func UpdateUserPreferences(ctx context.Context, id int, update func([]byte) ([]byte, error)) ([]byte, error) {
+	var prefs []byte
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var current []byte
+		err := tx.NewSelect().Model((*UserModel)(nil)).
+			Column("preferences").
+			Where("id = ?", id).
+			For("UPDATE").
+			Scan(ctx, &current)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("user not found")
+			}
+			return fmt.Errorf("failed to get preferences: %w", err)
+		}
 
-	return nil
+		if prefs, err = update(current); err != nil {
+			return err
+		}
+
+		_, err = tx.NewUpdate().Model((*UserModel)(nil)).
+			Set("preferences = ?::jsonb", string(prefs)).
+			Set("updated_at = ?", time.Now()).
+			Where("id = ?", id).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to update preferences: %w", err)
+		}
+		return nil
+	})
+	return prefs, err
 }
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/uptrace/bun"
+)
+
+// WebhookModel represents a repository webhook for database operations
+type WebhookModel struct {
+	bun.BaseModel `bun:"table:webhooks"`
+	*model.Webhook
+}
+
+func wrapWebhook(mo *model.Webhook) *WebhookModel {
+	return &WebhookModel{Webhook: mo}
+}
+
+func unwrapWebhooks(mos []*WebhookModel) []*model.Webhook {
+	hooks := make([]*model.Webhook, len(mos))
+	for i, mo := range mos {
+		hooks[i] = mo.Webhook
+	}
+	return hooks
+}
+
+// CreateWebhook stores a new webhook
+func CreateWebhook(ctx context.Context, hook *model.Webhook) error {
+	hook.CreatedAt = time.Now()
+
+	_, err := db.NewInsert().Model(wrapWebhook(hook)).Returning("id").Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return nil
+}
+
+// ListWebhooks returns the webhooks of a repository, only the active ones if activeOnly is set
+func ListWebhooks(ctx context.Context, repoID int, activeOnly bool) ([]*model.Webhook, error) {
+	var mos []*WebhookModel
+	query := db.NewSelect().Model(&mos).Where("repo_id = ?", repoID)
+	if activeOnly {
+		query = query.Where("active = ?", true)
+	}
+
+	if err := query.Order("id").Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	return unwrapWebhooks(mos), nil
+}
+
+// SetWebhookActive enables or disables a webhook of a repository
+func SetWebhookActive(ctx context.Context, repoID, id int, active bool) error {
+	result, err := db.NewUpdate().Model((*WebhookModel)(nil)).
+		Set("active = ?", active).
+		Where("repo_id = ? AND id = ?", repoID, id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("webhook %d: %w", id, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// RecordWebhookDelivery stores the outcome of the latest delivery of a webhook
+func RecordWebhookDelivery(ctx context.Context, id int, at time.Time, status int, errMsg string) error {
+	_, err := db.NewUpdate().Model((*WebhookModel)(nil)).
+		Set("last_delivery_at = ?", at).
+		Set("last_status = ?", status).
+		Set("last_error = ?", errMsg).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// DeleteWebhook removes a webhook of a repository
+func DeleteWebhook(ctx context.Context, repoID, id int) error {
+	result, err := db.NewDelete().Model((*WebhookModel)(nil)).
+		Where("repo_id = ? AND id = ?", repoID, id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("webhook %d: %w", id, sql.ErrNoRows)
+	}
+	return nil
+}
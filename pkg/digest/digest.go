@@ -0,0 +1,256 @@
+// Package digest emails users a daily or weekly summary of the changes in the folders they follow,
+// and the paths they subscribed to with the digest channel, from repositories they own or that are shared with them. Digests are built from the change log by
+// the maintenance job, and leave out the changes users made themselves.
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/mail"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+)
+
+const (
+	// maxChanges is how many changes of a followed folder a digest looks at
+	maxChanges = 1000
+	// maxListed is how many changed paths of a followed folder a digest lists
+	maxListed = 20
+)
+
+var (
+	// ErrInvalid is returned for an unknown schedule or a folder that cannot be followed
+	ErrInvalid = errors.New("invalid digest request")
+	// ErrForbidden is returned following a folder the user has no access to
+	ErrForbidden = errors.New("folder is not accessible to the user")
+)
+
+var (
+	// sendHour is the hour of the day digests are sent at
+	sendHour = 7
+	// sendWeekday is the day weekly digests are sent on
+	sendWeekday = time.Monday
+)
+
+// Init sets when digests are sent
+func Init(cfg *config.Config) {
+	if cfg.Digest.Hour >= 0 && cfg.Digest.Hour < 24 {
+		sendHour = cfg.Digest.Hour
+	} else {
+		log.Printf("Invalid digest hour %d, sending at %d", cfg.Digest.Hour, sendHour)
+	}
+
+	if day, ok := parseWeekday(cfg.Digest.Weekday); ok {
+		sendWeekday = day
+	} else {
+		log.Printf("Invalid digest weekday %q, sending on %s", cfg.Digest.Weekday, sendWeekday)
+	}
+}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(name, day.String()) {
+			return day, true
+		}
+	}
+	return time.Sunday, false
+}
+
+// SetSchedule changes how often the user receives a digest: daily, weekly, or "" for never.
+// The first digest covers the changes from now on.
+func SetSchedule(ctx context.Context, user *model.User, schedule string, now time.Time) error {
+	switch schedule {
+	case "", model.DigestDaily, model.DigestWeekly:
+	default:
+		return fmt.Errorf("%w: unknown schedule %q", ErrInvalid, schedule)
+	}
+	return db.SetUserDigest(ctx, user.ID, schedule, now)
+}
+
+// Follow makes the user follow the changes under a folder of a repository they can view
+func Follow(ctx context.Context, user *model.User, repo *model.Repository, dir string) (*model.Follow, error) {
+	dir = model.CleanPath(dir)
+	res := &model.Resource{Repo: repo, Path: path.Clean("/" + dir)}
+	if err := perm.Evaluate(ctx, perm.User(user.ID), res, perm.View); err != nil {
+		return nil, ErrForbidden
+	}
+
+	target, err := db.GetFile(ctx, repo.ID, dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s not found", ErrInvalid, dir)
+	}
+	if !target.IsDir {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalid, dir)
+	}
+
+	follow := &model.Follow{UserID: user.ID, RepoID: repo.ID, Repo: repo.Name, Path: dir}
+	if err := db.CreateFollow(ctx, follow); err != nil {
+		return nil, err
+	}
+	return follow, nil
+}
+
+// Unfollow stops the user following a folder
+func Unfollow(ctx context.Context, user *model.User, repo *model.Repository, dir string) error {
+	return db.DeleteFollow(ctx, user.ID, repo.ID, model.CleanPath(dir))
+}
+
+// List returns the folders the user follows, with the names of their repositories
+func List(ctx context.Context, user *model.User) ([]*model.Follow, error) {
+	follows, err := db.ListFollows(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make(map[int]*model.Repository)
+	for _, follow := range follows {
+		if repo, err := getRepo(ctx, repos, follow.RepoID); err == nil {
+			follow.Repo = repo.Name
+		}
+	}
+	return follows, nil
+}
+
+func getRepo(ctx context.Context, repos map[int]*model.Repository, id int) (*model.Repository, error) {
+	if repo, ok := repos[id]; ok {
+		return repo, nil
+	}
+
+	repo, err := db.GetRepositoryByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	repos[id] = repo
+	return repo, nil
+}
+
+// lastSlot returns the latest time at or before now a digest of the schedule was due
+func lastSlot(schedule string, now time.Time) time.Time {
+	slot := time.Date(now.Year(), now.Month(), now.Day(), sendHour, 0, 0, 0, now.Location())
+	if slot.After(now) {
+		slot = slot.AddDate(0, 0, -1)
+	}
+	if schedule == model.DigestWeekly {
+		slot = slot.AddDate(0, 0, -((int(slot.Weekday()) - int(sendWeekday) + 7) % 7))
+	}
+	return slot
+}
+
+// periodStart returns when the period the next digest of the user covers started,
+// and whether that digest is due at now
+func periodStart(user *model.User, now time.Time) (time.Time, bool) {
+	if user.DigestSentAt == nil {
+		days := 1
+		if user.Digest == model.DigestWeekly {
+			days = 7
+		}
+		return now.AddDate(0, 0, -days), true
+	}
+	return *user.DigestSentAt, user.DigestSentAt.Before(lastSlot(user.Digest, now))
+}
+
+// Run sends the digests due at now, returning how many were sent. Nothing is sent without
+// a mail server; users whose digest failed are tried again on the next run.
+func Run(ctx context.Context, now time.Time) (int, error) {
+	if !mail.Enabled() {
+		return 0, nil
+	}
+
+	users, err := db.ListDigestUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	repos := make(map[int]*model.Repository)
+	for _, user := range users {
+		since, due := periodStart(user, now)
+		if !due {
+			continue
+		}
+
+		ok, err := send(ctx, repos, user, since, now)
+		if err != nil {
+			log.Printf("Failed to send digest to user %d: %s", user.ID, err)
+			continue
+		}
+		if ok {
+			sent++
+		}
+
+		if err := db.MarkDigestSent(ctx, user.ID, now); err != nil {
+			log.Printf("Failed to record digest of user %d: %s", user.ID, err)
+		}
+	}
+	return sent, nil
+}
+
+// watched returns the folders the user follows and the paths of their subscriptions with the digest channel
+func watched(ctx context.Context, user *model.User) ([]*model.Follow, error) {
+	follows, err := db.ListFollows(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	subs, err := db.ListSubscriptions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		followed := slices.ContainsFunc(follows, func(follow *model.Follow) bool {
+			return follow.RepoID == sub.RepoID && follow.Path == sub.Path
+		})
+		if sub.HasChannel(model.ChannelDigest) && !followed {
+			follows = append(follows, &model.Follow{UserID: user.ID, RepoID: sub.RepoID, Path: sub.Path})
+		}
+	}
+	return follows, nil
+}
+
+// send mails the user the changes in their followed folders and subscribed paths during (since, until],
+// reporting whether there were any to send
+func send(ctx context.Context, repos map[int]*model.Repository, user *model.User, since, until time.Time) (bool, error) {
+	follows, err := watched(ctx, user)
+	if err != nil {
+		return false, err
+	}
+
+	var sections []*section
+	for _, follow := range follows {
+		repo, err := getRepo(ctx, repos, follow.RepoID)
+		if err != nil {
+			return false, err
+		}
+
+		// Folders whose share expired are left out, but kept in case it is shared again
+		res := &model.Resource{Repo: repo, Path: path.Clean("/" + follow.Path)}
+		if perm.Evaluate(ctx, perm.User(user.ID), res, perm.View) != nil {
+			continue
+		}
+
+		filter := model.ChangeFilter{PathPrefix: follow.Path}
+		changes, err := db.ListChangesBetween(ctx, repo.ID, filter, since, until, maxChanges)
+		if err != nil {
+			return false, err
+		}
+
+		if s := summarize(repo.Name, follow.Path, user.ID, changes, len(changes) == maxChanges); s != nil {
+			sections = append(sections, s)
+		}
+	}
+	if len(sections) == 0 {
+		return false, nil
+	}
+
+	subject, body := render(user, sections, since)
+	return true, mail.Send(user.Email, subject, body)
+}
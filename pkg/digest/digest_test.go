@@ -0,0 +1,120 @@
+package digest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInit(t *testing.T) {
+	defer func() { sendHour, sendWeekday = 7, time.Monday }()
+
+	Init(&config.Config{Digest: config.DigestConfig{Hour: 18, Weekday: "Friday"}})
+	assert.Equal(t, 18, sendHour)
+	assert.Equal(t, time.Friday, sendWeekday)
+
+	// Invalid settings keep the previous ones
+	Init(&config.Config{Digest: config.DigestConfig{Hour: 24, Weekday: "someday"}})
+	assert.Equal(t, 18, sendHour)
+	assert.Equal(t, time.Friday, sendWeekday)
+}
+
+func TestLastSlot(t *testing.T) {
+	// Wednesday 2024-03-06
+	at := func(day, hour int) time.Time { return time.Date(2024, 3, day, hour, 30, 0, 0, time.UTC) }
+	slot := func(day int) time.Time { return time.Date(2024, 3, day, 7, 0, 0, 0, time.UTC) }
+
+	assert.Equal(t, slot(6), lastSlot(model.DigestDaily, at(6, 7)))
+	assert.Equal(t, slot(5), lastSlot(model.DigestDaily, at(6, 6)))
+	assert.Equal(t, slot(4), lastSlot(model.DigestWeekly, at(6, 7)))
+	assert.Equal(t, slot(4), lastSlot(model.DigestWeekly, at(4, 7)))
+	assert.Equal(t, slot(4).AddDate(0, 0, -7), lastSlot(model.DigestWeekly, at(4, 6)))
+}
+
+func TestPeriodStart(t *testing.T) {
+	now := time.Date(2024, 3, 6, 9, 0, 0, 0, time.UTC)
+
+	since, due := periodStart(&model.User{Digest: model.DigestWeekly}, now)
+	assert.True(t, due)
+	assert.Equal(t, now.AddDate(0, 0, -7), since)
+
+	sent := time.Date(2024, 3, 5, 7, 10, 0, 0, time.UTC)
+	since, due = periodStart(&model.User{Digest: model.DigestDaily, DigestSentAt: &sent}, now)
+	assert.True(t, due)
+	assert.Equal(t, sent, since)
+
+	sent = time.Date(2024, 3, 6, 7, 10, 0, 0, time.UTC)
+	_, due = periodStart(&model.User{Digest: model.DigestDaily, DigestSentAt: &sent}, now)
+	assert.False(t, due)
+
+	sent = time.Date(2024, 3, 4, 8, 0, 0, 0, time.UTC)
+	_, due = periodStart(&model.User{Digest: model.DigestWeekly, DigestSentAt: &sent}, now)
+	assert.False(t, due)
+}
+
+func TestSummarize(t *testing.T) {
+	old := "/docs/draft.md"
+	changes := []*model.ChangeLog{
+		{Operation: "create", Path: "/docs/a.md", UserID: 2},
+		{Operation: "modify", Path: "/docs/b.md", UserID: 2},
+		{Operation: "move", Path: "/docs/final.md", OldPath: &old, UserID: 3},
+		{Operation: "delete", Path: "/docs/c.md", UserID: 2},
+		{Operation: "copy", Path: "/docs/d.md", UserID: 2},
+		{Operation: "modify", Path: "/docs/mine.md", UserID: 1},
+	}
+
+	s := summarize("alice", "/docs", 1, changes, false)
+	require.NotNil(t, s)
+	assert.Equal(t, 2, s.Created)
+	assert.Equal(t, 1, s.Modified)
+	assert.Equal(t, 1, s.Moved)
+	assert.Equal(t, 1, s.Deleted)
+	assert.Equal(t, []string{
+		"+ /docs/a.md",
+		"~ /docs/b.md",
+		"> /docs/draft.md -> /docs/final.md",
+		"- /docs/c.md",
+		"+ /docs/d.md",
+	}, s.Lines)
+
+	// Only the user's own changes
+	assert.Nil(t, summarize("alice", "/docs", 1, changes[5:], false))
+}
+
+func TestSummarizeListsFirstChanges(t *testing.T) {
+	var changes []*model.ChangeLog
+	for range maxListed + 5 {
+		changes = append(changes, &model.ChangeLog{Operation: "create", Path: "/f", UserID: 2})
+	}
+
+	s := summarize("alice", "", 1, changes, true)
+	require.NotNil(t, s)
+	assert.Equal(t, maxListed+5, s.Created)
+	assert.Len(t, s.Lines, maxListed)
+	assert.True(t, s.Truncated)
+}
+
+func TestRender(t *testing.T) {
+	user := &model.User{Username: "bob", Digest: model.DigestWeekly}
+	since := time.Date(2024, 3, 4, 7, 0, 0, 0, time.UTC)
+	sections := []*section{
+		{Repo: "alice", Path: "", Created: 22, Lines: make([]string, maxListed)},
+		{Repo: "team", Path: "/specs", Deleted: 1, Lines: []string{"- /specs/old.md"}},
+	}
+
+	subject, body := render(user, sections, since)
+	assert.Equal(t, "Your weekly activity digest", subject)
+	assert.True(t, strings.HasPrefix(body, "Hello bob, here is what changed in the folders you follow since 2024-03-04 07:00.\n"))
+	assert.Contains(t, body, "\n/ in alice\n22 new, 0 modified, 0 moved, 0 deleted\n")
+	assert.Contains(t, body, "  and 2 more\n")
+	assert.Contains(t, body, "\n/specs in team\n0 new, 0 modified, 0 moved, 1 deleted\n  - /specs/old.md\n")
+
+	user.Locale = "zh"
+	subject, _ = render(user, sections, since)
+	assert.Equal(t, "您的每周动态摘要", subject)
+}
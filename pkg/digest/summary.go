@@ -0,0 +1,98 @@
+package digest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/i18n"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// section summarizes the changes in one followed folder
+type section struct {
+	Repo      string
+	Path      string // followed folder, "" for the whole repository
+	Created   int    // files and folders created or copied in
+	Modified  int
+	Moved     int
+	Deleted   int
+	Lines     []string // the first changes, one per line
+	Truncated bool     // whether there were more changes than were looked at
+}
+
+func (s *section) total() int {
+	return s.Created + s.Modified + s.Moved + s.Deleted
+}
+
+// summarize counts the changes in a followed folder made by others than the user,
+// returning nil if there are none
+func summarize(repo, dir string, userID int, changes []*model.ChangeLog, truncated bool) *section {
+	s := &section{Repo: repo, Path: dir, Truncated: truncated}
+	for _, change := range changes {
+		if change.UserID == userID {
+			continue
+		}
+
+		var line string
+		switch change.Operation {
+		case "create", "copy":
+			s.Created++
+			line = "+ " + change.Path
+		case "modify":
+			s.Modified++
+			line = "~ " + change.Path
+		case "move", "rename":
+			s.Moved++
+			line = "> " + change.Path
+			if change.OldPath != nil {
+				line = "> " + *change.OldPath + " -> " + change.Path
+			}
+		case "delete":
+			s.Deleted++
+			line = "- " + change.Path
+		default:
+			continue
+		}
+
+		if len(s.Lines) < maxListed {
+			s.Lines = append(s.Lines, line)
+		}
+	}
+
+	if s.total() == 0 {
+		return nil
+	}
+	return s
+}
+
+// render returns the subject and body of a digest in the user's locale
+func render(user *model.User, sections []*section, since time.Time) (string, string) {
+	locale := user.Locale
+
+	var body strings.Builder
+	fmt.Fprintln(&body, i18n.Sprintf(locale, "digest.intro", user.Username, since.Format("2006-01-02 15:04")))
+	for _, s := range sections {
+		dir := s.Path
+		if dir == "" {
+			dir = "/"
+		}
+
+		fmt.Fprintln(&body)
+		fmt.Fprintln(&body, i18n.Sprintf(locale, "digest.folder", dir, s.Repo))
+		fmt.Fprintln(&body, i18n.Sprintf(locale, "digest.counts", s.Created, s.Modified, s.Moved, s.Deleted))
+		for _, line := range s.Lines {
+			fmt.Fprintln(&body, "  "+line)
+		}
+		if more := s.total() - len(s.Lines); more > 0 {
+			fmt.Fprintln(&body, "  "+i18n.Sprintf(locale, "digest.more", more))
+		}
+		if s.Truncated {
+			fmt.Fprintln(&body, i18n.Sprintf(locale, "digest.truncated", maxChanges))
+		}
+	}
+	fmt.Fprintln(&body)
+	fmt.Fprint(&body, i18n.Sprintf(locale, "digest.footer"))
+
+	return i18n.Sprintf(locale, "digest.subject."+user.Digest), body.String()
+}
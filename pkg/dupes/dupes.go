@@ -0,0 +1,194 @@
+// Package dupes finds files with identical content and removes or deduplicates the extra copies.
+package dupes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/sync"
+)
+
+// MaxGroups is the most duplicate groups reported at once
+const MaxGroups = 1000
+
+// Actions applied to duplicate copies
+const (
+	ActionDelete = "delete" // move the copies to the trash
+	ActionLink   = "link"   // replace the copies with dedup references to the kept file
+)
+
+// ErrInvalid is returned when a resolve request is malformed
+var ErrInvalid = errors.New("invalid request")
+
+// File is a copy of duplicated content
+type File struct {
+	Repo string `json:"repo"`
+	Path string `json:"path"`
+}
+
+// Group lists the files sharing one content
+type Group struct {
+	Checksum    string  `json:"checksum"`
+	Size        int64   `json:"size"`
+	Files       []*File `json:"files"`
+	Reclaimable int64   `json:"reclaimable"` // bytes freed by keeping a single copy
+}
+
+// Report lists duplicated content, largest savings first
+type Report struct {
+	Groups      []*Group `json:"groups"`
+	Reclaimable int64    `json:"reclaimable"`
+}
+
+// ResolveRequest keeps one copy of duplicated content and deletes or links the others
+type ResolveRequest struct {
+	Action string  `json:"action"`
+	Keep   *File   `json:"keep"`
+	Copies []*File `json:"copies"`
+}
+
+// Result reports the outcome for one copy
+type Result struct {
+	File
+	Error string `json:"error,omitempty"`
+}
+
+// Find reports duplicated content of files at least minSize bytes long in the repositories
+func Find(ctx context.Context, repos []*model.Repository, minSize int64) (*Report, error) {
+	report := &Report{Groups: []*Group{}}
+	if len(repos) == 0 {
+		return report, nil
+	}
+
+	names := make(map[int]string, len(repos))
+	ids := make([]int, len(repos))
+	for i, repo := range repos {
+		names[repo.ID] = repo.Name
+		ids[i] = repo.ID
+	}
+
+	dups, err := db.FindDuplicateContent(ctx, ids, minSize, MaxGroups)
+	if err != nil {
+		return nil, err
+	}
+	if len(dups) == 0 {
+		return report, nil
+	}
+
+	checksums := make([]string, len(dups))
+	for i, dup := range dups {
+		checksums[i] = dup.Checksum
+	}
+
+	files, err := db.GetFilesByChecksum(ctx, ids, checksums)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildReport(dups, files, names), nil
+}
+
+// buildReport groups files by content in the order of dups
+func buildReport(dups []*db.DuplicateContent, files []*model.FileObject, names map[int]string) *Report {
+	report := &Report{Groups: []*Group{}}
+
+	type content struct {
+		checksum string
+		size     int64
+	}
+
+	groups := make(map[content]*Group, len(dups))
+	for _, dup := range dups {
+		group := &Group{Checksum: dup.Checksum, Size: dup.Size}
+		groups[content{dup.Checksum, dup.Size}] = group
+		report.Groups = append(report.Groups, group)
+	}
+
+	for _, file := range files {
+		if group, ok := groups[content{*file.Checksum, file.Size}]; ok {
+			group.Files = append(group.Files, &File{Repo: names[file.RepoID], Path: file.Path})
+		}
+	}
+
+	for _, group := range report.Groups {
+		group.Reclaimable = int64(len(group.Files)-1) * group.Size
+		report.Reclaimable += group.Reclaimable
+	}
+	return report
+}
+
+// Resolve applies the action to each copy, verifying it still has the kept file's content.
+// Copies are handled independently, and failures are reported per copy.
+func Resolve(ctx context.Context, user *model.User, repos []*model.Repository, req *ResolveRequest) ([]*Result, error) {
+	if req.Action != ActionDelete && req.Action != ActionLink {
+		return nil, fmt.Errorf("%w: unsupported action %q", ErrInvalid, req.Action)
+	}
+	if req.Keep == nil || len(req.Copies) == 0 {
+		return nil, fmt.Errorf("%w: keep and copies are required", ErrInvalid)
+	}
+
+	keepRes, keep, err := resolveFile(ctx, repos, req.Keep)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	if keep.Checksum == nil {
+		return nil, fmt.Errorf("%w: %s has no checksum", ErrInvalid, keep.Path)
+	}
+
+	results := make([]*Result, len(req.Copies))
+	for i, target := range req.Copies {
+		results[i] = &Result{File: *target}
+		if err := resolveCopy(ctx, user, repos, req.Action, keepRes, keep, target); err != nil {
+			results[i].Error = err.Error()
+		}
+	}
+	return results, nil
+}
+
+func resolveCopy(ctx context.Context, user *model.User, repos []*model.Repository, action string, keepRes *model.Resource, keep *model.FileObject, target *File) error {
+	res, file, err := resolveFile(ctx, repos, target)
+	if err != nil {
+		return err
+	}
+
+	if file.ID == keep.ID {
+		return errors.New("cannot remove the kept file")
+	}
+	if file.Checksum == nil || *file.Checksum != *keep.Checksum || file.Size != keep.Size {
+		return errors.New("content differs from the kept file")
+	}
+
+	if action == ActionLink {
+		return stor.LinkFile(ctx, keepRes, res)
+	}
+
+	if _, err := stor.TrashFile(ctx, res, &user.ID, model.TrashReasonDeleted); err != nil {
+		return err
+	}
+
+	if err := sync.RecordChange(ctx, res.Repo.ID, "delete", res.Path, user.ID); err != nil {
+		log.Printf("Failed to record deletion of %s: %s", res.Path, err)
+	}
+	return nil
+}
+
+// resolveFile finds a file in one of the repositories
+func resolveFile(ctx context.Context, repos []*model.Repository, f *File) (*model.Resource, *model.FileObject, error) {
+	for _, repo := range repos {
+		if repo.Name != f.Repo {
+			continue
+		}
+
+		file, err := db.GetFile(ctx, repo.ID, f.Path)
+		if err != nil || file.IsDir {
+			return nil, nil, fmt.Errorf("%s:%s not found", f.Repo, f.Path)
+		}
+		return &model.Resource{Repo: repo, Path: file.Path}, file, nil
+	}
+	return nil, nil, fmt.Errorf("repository %s not found", f.Repo)
+}
@@ -0,0 +1,51 @@
+package dupes
+
+import (
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildReport(t *testing.T) {
+	sum := func(s string) *string { return &s }
+
+	dups := []*db.DuplicateContent{
+		{Checksum: "aaa", Size: 100, Count: 3},
+		{Checksum: "bbb", Size: 10, Count: 2},
+	}
+	files := []*model.FileObject{
+		{RepoID: 1, Path: "/a1", Checksum: sum("aaa"), Size: 100},
+		{RepoID: 1, Path: "/a2", Checksum: sum("aaa"), Size: 100},
+		{RepoID: 2, Path: "/a3", Checksum: sum("aaa"), Size: 100},
+		{RepoID: 2, Path: "/b1", Checksum: sum("bbb"), Size: 10},
+		{RepoID: 2, Path: "/b2", Checksum: sum("bbb"), Size: 10},
+		{RepoID: 2, Path: "/c1", Checksum: sum("bbb"), Size: 11}, // same checksum, different size
+	}
+
+	report := buildReport(dups, files, map[int]string{1: "alice", 2: "shared"})
+	require.Len(t, report.Groups, 2)
+
+	assert.Equal(t, "aaa", report.Groups[0].Checksum)
+	assert.Equal(t, []*File{{"alice", "/a1"}, {"alice", "/a2"}, {"shared", "/a3"}}, report.Groups[0].Files)
+	assert.Equal(t, int64(200), report.Groups[0].Reclaimable)
+
+	assert.Len(t, report.Groups[1].Files, 2)
+	assert.Equal(t, int64(10), report.Groups[1].Reclaimable)
+	assert.Equal(t, int64(210), report.Reclaimable)
+}
+
+func TestResolveValidation(t *testing.T) {
+	user := &model.User{ID: 1}
+
+	_, err := Resolve(t.Context(), user, nil, &ResolveRequest{Action: "merge"})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, err = Resolve(t.Context(), user, nil, &ResolveRequest{Action: ActionDelete, Keep: &File{"r", "/a"}})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, err = Resolve(t.Context(), user, nil, &ResolveRequest{Action: ActionLink, Keep: &File{"r", "/a"}, Copies: []*File{{"r", "/b"}}})
+	assert.ErrorIs(t, err, ErrInvalid)
+}
@@ -0,0 +1,62 @@
+package egress
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPublic(t *testing.T) {
+	for _, addr := range []string{"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946", "8.8.8.8"} {
+		assert.True(t, IsPublic(netip.MustParseAddr(addr)), addr)
+	}
+	for _, addr := range []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "100.64.0.1",
+		"224.0.0.1", "255.255.255.255", "::1", "::", "fe80::1", "fc00::1", "::ffff:127.0.0.1", "::ffff:169.254.169.254",
+	} {
+		assert.False(t, IsPublic(netip.MustParseAddr(addr)), addr)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	assert.NoError(t, CheckURL("https://ci.example.com/hook"))
+	assert.NoError(t, CheckURL("http://93.184.216.34:8080/hook"))
+
+	for _, rawURL := range []string{
+		"http://127.0.0.1/", "http://localhost:8080/", "http://LOCALHOST./", "http://api.localhost/",
+		"http://169.254.169.254/latest/meta-data/", "http://[::1]/", "http://10.0.0.1/", "http://[::ffff:10.0.0.1]/",
+	} {
+		assert.ErrorIs(t, CheckURL(rawURL), ErrNotPublic, rawURL)
+	}
+	assert.Error(t, CheckURL("ftp://example.com/"))
+	assert.Error(t, CheckURL("/relative"))
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// httptest listens on loopback, which the client refuses to connect to whatever the URL names
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	_, err = Client(time.Second).Do(req)
+	assert.ErrorIs(t, err, ErrNotPublic)
+}
+
+func TestNoRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	client := Client(time.Second)
+	client.Transport = http.DefaultTransport // loopback is allowed here, to reach the test server
+	_, err := client.Get(server.URL)
+	assert.ErrorIs(t, err, ErrRedirect)
+}
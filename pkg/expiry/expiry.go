@@ -0,0 +1,181 @@
+// Package expiry deletes files from folders with an automatic expiry rule.
+// Expired files are moved to the trash, where they can still be restored until it is purged.
+package expiry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/audit"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/sync"
+)
+
+// MaxAgeDays is the longest maximum age a rule accepts
+const MaxAgeDays = 36500
+
+// batchSize is how many expired files are fetched at a time
+var batchSize = 500
+
+var (
+	// ErrInvalid is returned when a rule is malformed
+	ErrInvalid = errors.New("invalid expiry rule")
+	// ErrForbidden is returned changing the expiry rules of a repository the user may not manage
+	ErrForbidden = errors.New("managing expiry rules needs the manage permission on the repository")
+)
+
+// Set creates or updates the expiry rule of a folder
+func Set(ctx context.Context, user *model.User, repo *model.Repository, dir string, maxAgeDays int) (*model.ExpiryRule, error) {
+	if perm.Evaluate(ctx, perm.User(user.ID), &model.Resource{Repo: repo}, perm.Manage) != nil {
+		return nil, ErrForbidden
+	}
+	if maxAgeDays <= 0 || maxAgeDays > MaxAgeDays {
+		return nil, fmt.Errorf("%w: max_age_days must be between 1 and %d", ErrInvalid, MaxAgeDays)
+	}
+
+	dir = model.CleanPath(dir)
+	target, err := db.GetFile(ctx, repo.ID, dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s not found", ErrInvalid, dir)
+	}
+	if !target.IsDir {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalid, dir)
+	}
+
+	rule := &model.ExpiryRule{
+		RepoID:     repo.ID,
+		Path:       dir,
+		MaxAgeDays: maxAgeDays,
+		CreatedBy:  user.ID,
+	}
+	if err := db.SetExpiryRule(ctx, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// List returns the expiry rules of a repository
+func List(ctx context.Context, repo *model.Repository) ([]*model.ExpiryRule, error) {
+	return db.ListExpiryRules(ctx, repo.ID)
+}
+
+// Delete removes the expiry rule of a folder
+func Delete(ctx context.Context, user *model.User, repo *model.Repository, dir string) error {
+	if perm.Evaluate(ctx, perm.User(user.ID), &model.Resource{Repo: repo}, perm.Manage) != nil {
+		return ErrForbidden
+	}
+	return db.DeleteExpiryRule(ctx, repo.ID, model.CleanPath(dir))
+}
+
+// Annotate sets the expiry time of listed files covered by a rule of their repository
+func Annotate(ctx context.Context, repo *model.Repository, files []*model.FileObject) error {
+	rules, err := db.ListExpiryRules(ctx, repo.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		file.ExpiresAt = model.EarliestExpiry(rules, file)
+	}
+	return nil
+}
+
+// Upcoming returns up to limit files of a repository that expire before the given time, soonest first
+func Upcoming(ctx context.Context, repo *model.Repository, before time.Time, limit int) ([]*model.FileObject, error) {
+	rules, err := db.ListExpiryRules(ctx, repo.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int]bool)
+	var files []*model.FileObject
+	for _, rule := range rules {
+		candidates, err := db.GetFilesModifiedBefore(ctx, repo.ID, rule.Path, rule.Cutoff(before), limit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range candidates {
+			if !seen[file.ID] {
+				seen[file.ID] = true
+				files = append(files, file)
+			}
+		}
+	}
+
+	for _, file := range files {
+		file.ExpiresAt = model.EarliestExpiry(rules, file)
+	}
+	slices.SortFunc(files, func(a, b *model.FileObject) int {
+		return a.ExpiresAt.Compare(*b.ExpiresAt)
+	})
+
+	return files[:min(limit, len(files))], nil
+}
+
+// Run moves every file that expired at now to the trash, returning how many were moved. A rule that fails
+// is logged and left for the next run, so that it does not hold up the rules after it.
+func Run(ctx context.Context, now time.Time) (int, error) {
+	rules, err := db.ListExpiryRules(ctx, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, rule := range rules {
+		n, err := apply(ctx, rule, now)
+		expired += n
+		if err != nil {
+			log.Printf("Failed to apply expiry rule %d: %s", rule.ID, err)
+		}
+	}
+	return expired, nil
+}
+
+// apply trashes the files expired under a single rule
+func apply(ctx context.Context, rule *model.ExpiryRule, now time.Time) (int, error) {
+	repo, err := db.GetRepositoryByID(ctx, rule.RepoID)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for {
+		files, err := db.GetFilesModifiedBefore(ctx, repo.ID, rule.Path, rule.Cutoff(now), batchSize)
+		if err != nil {
+			return expired, err
+		}
+
+		for _, file := range files {
+			res := &model.Resource{Repo: repo, Path: file.Path}
+			if _, err := stor.TrashFile(ctx, res, nil, model.TrashReasonExpired); err != nil {
+				return expired, err
+			}
+			expired++
+
+			if err := sync.RecordChange(ctx, repo.ID, "delete", file.Path, repo.OwnerID); err != nil {
+				log.Printf("Failed to record expiry of %s: %s", file.Path, err)
+			}
+		}
+
+		if len(files) < batchSize {
+			break
+		}
+	}
+
+	if expired > 0 {
+		audit.Record(ctx, &model.AuditEntry{
+			Action: model.AuditFilesExpired,
+			UserID: &repo.OwnerID,
+			Detail: fmt.Sprintf("%d files in %s:%s", expired, repo.Name, rule.Path),
+		})
+	}
+	return expired, nil
+}
@@ -0,0 +1,716 @@
+// Package federation shares folders with users of other file-hub servers, in the manner of Open Cloud Mesh.
+// The sharing server offers a share to the recipient's server, which tells it when its user accepts and
+// from then on reads the folder on the user's behalf with the share's secret.
+package federation
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/classify"
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/notify"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/stor"
+)
+
+const (
+	// ProtocolName names the access protocol offered with shares: reads under FilesPath with the secret as bearer token
+	ProtocolName = "filehub"
+	// APIVersion is the version of the Open Cloud Mesh API the endpoints follow
+	APIVersion = "1.0-proposal1"
+
+	// Paths of the server-to-server endpoints below a server's base URL
+	EndPoint          = "/ocm"
+	SharesPath        = EndPoint + "/shares"
+	NotificationsPath = EndPoint + "/notifications"
+	FilesPath         = EndPoint + "/files"
+
+	// requestTimeout bounds offers and notifications sent to other servers
+	requestTimeout = 10 * time.Second
+	// dialTimeout bounds connecting to another server, and proxyHeaderTimeout its answer to a read
+	dialTimeout        = 5 * time.Second
+	proxyHeaderTimeout = 30 * time.Second
+)
+
+// Notification types exchanged between servers
+const (
+	ShareAccepted = "SHARE_ACCEPTED"
+	ShareDeclined = "SHARE_DECLINED"
+	ShareUnshared = "SHARE_UNSHARED"
+)
+
+var (
+	// ErrDisabled is returned while federation is not enabled
+	ErrDisabled = errors.New("federation is not enabled")
+	// ErrInvalid is returned for malformed federated share requests
+	ErrInvalid = errors.New("invalid federated share request")
+	// ErrNotFound is returned for unknown federated shares, or ones with another user
+	ErrNotFound = errors.New("federated share not found")
+	// ErrForbidden is returned when a share does not permit the operation, such as access before it is accepted
+	ErrForbidden = errors.New("operation not permitted by federated share")
+	// ErrUntrusted is returned for servers not trusted for federation
+	ErrUntrusted = errors.New("server not trusted for federation")
+	// ErrRemote is returned when the other server could not be reached or refused a request
+	ErrRemote = errors.New("remote server request failed")
+)
+
+var (
+	enabled bool
+	self    *url.URL   // base URL of this server
+	trusted []*url.URL // base URLs of the servers shares are exchanged with
+
+	client = &http.Client{Timeout: requestTimeout}
+	// proxyClient reads remote content, which may take longer than requestTimeout to stream,
+	// so only connecting and the response headers are bounded
+	proxyClient = &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: dialTimeout}).DialContext,
+		TLSHandshakeTimeout:   dialTimeout,
+		ResponseHeaderTimeout: proxyHeaderTimeout,
+		IdleConnTimeout:       time.Minute,
+	}}
+)
+
+// Init sets up federation from the configuration. It stays disabled without a valid base URL.
+func Init(cfg *config.Config) {
+	enabled, self, trusted = false, nil, nil
+	if !cfg.Federation.Enabled {
+		return
+	}
+
+	base, err := parseServer(cfg.Federation.BaseURL)
+	if err != nil {
+		log.Printf("Federation disabled, invalid base URL %q: %s", cfg.Federation.BaseURL, err)
+		return
+	}
+
+	for _, server := range cfg.Federation.TrustedServers {
+		u, err := parseServer(server)
+		if err != nil {
+			log.Printf("Ignoring invalid trusted server %q: %s", server, err)
+			continue
+		}
+		trusted = append(trusted, u)
+	}
+	enabled, self = true, base
+}
+
+// Enabled reports whether shares are exchanged with other servers
+func Enabled() bool {
+	return enabled
+}
+
+// parseServer parses the base URL of a server, without a trailing slash
+func parseServer(server string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(strings.TrimSpace(server), "/"))
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("not an http(s) base URL")
+	}
+	return u, nil
+}
+
+// ParseAddress splits a federated address, user@host, into the user name and the server host
+func ParseAddress(addr string) (string, string, error) {
+	i := strings.LastIndex(addr, "@")
+	if i <= 0 || i == len(addr)-1 {
+		return "", "", fmt.Errorf("%w: %q is not user@host", ErrInvalid, addr)
+	}
+	return addr[:i], strings.ToLower(addr[i+1:]), nil
+}
+
+// Address returns the federated address of a local user
+func Address(username string) string {
+	return username + "@" + strings.ToLower(self.Host)
+}
+
+// trustedServer returns the base URL of the trusted server at host
+func trustedServer(host string) (string, bool) {
+	for _, u := range trusted {
+		if strings.EqualFold(u.Host, host) {
+			return u.String(), true
+		}
+	}
+	return "", false
+}
+
+// Provider describes the federation endpoints of this server to others
+type Provider struct {
+	Enabled       bool           `json:"enabled"`
+	APIVersion    string         `json:"apiVersion"`
+	EndPoint      string         `json:"endPoint"`
+	Provider      string         `json:"provider"`
+	ResourceTypes []ResourceType `json:"resourceTypes"`
+}
+
+// ResourceType is a kind of resource that can be shared, with the protocols to access it
+type ResourceType struct {
+	Name       string            `json:"name"`
+	ShareTypes []string          `json:"shareTypes"`
+	Protocols  map[string]string `json:"protocols"`
+}
+
+// Discover describes this server's federation endpoints
+func Discover() *Provider {
+	if !enabled {
+		return &Provider{Enabled: false, APIVersion: APIVersion, Provider: "file-hub"}
+	}
+	return &Provider{
+		Enabled:    true,
+		APIVersion: APIVersion,
+		EndPoint:   self.String() + EndPoint,
+		Provider:   "file-hub",
+		ResourceTypes: []ResourceType{{
+			Name:       "folder",
+			ShareTypes: []string{"user"},
+			Protocols:  map[string]string{ProtocolName: FilesPath},
+		}},
+	}
+}
+
+// Offer is sent to the recipient's server when a folder is shared with one of its users
+type Offer struct {
+	ShareWith    string   `json:"shareWith"` // recipient as user@host
+	Name         string   `json:"name"`      // name of the shared folder
+	ProviderID   string   `json:"providerId"`
+	Owner        string   `json:"owner"`
+	Sender       string   `json:"sender"`
+	ResourceType string   `json:"resourceType"`
+	ShareType    string   `json:"shareType"`
+	Protocol     Protocol `json:"protocol"`
+}
+
+// Protocol tells how the recipient's server accesses a shared folder
+type Protocol struct {
+	Name    string          `json:"name"`
+	Options ProtocolOptions `json:"options"`
+}
+
+type ProtocolOptions struct {
+	SharedSecret string `json:"sharedSecret"`
+}
+
+// Notification tells the other server of a share what happened to it
+type Notification struct {
+	NotificationType string              `json:"notificationType"`
+	ResourceType     string              `json:"resourceType"`
+	ProviderID       string              `json:"providerId"`
+	Notification     NotificationDetails `json:"notification"`
+}
+
+type NotificationDetails struct {
+	SharedSecret string `json:"sharedSecret"`
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// post sends a request body as JSON to another server
+func post(ctx context.Context, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FileHub-Federation")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrRemote, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%w: %s answered %s", ErrRemote, url, resp.Status)
+	}
+	return nil
+}
+
+// ShareRequest describes a folder to share with a user of another server
+type ShareRequest struct {
+	Repo      string `json:"repo,omitempty"` // defaults to the user's home repository
+	Path      string `json:"path"`
+	ShareWith string `json:"share_with"` // recipient as user@host
+}
+
+// Share offers a folder the user owns to a user of a trusted server. Only the owner of the repository
+// may, as perm.Manage is not granted by shares. The share stays pending until the recipient accepts it.
+func Share(ctx context.Context, user *model.User, req *ShareRequest) (*model.FederatedShare, error) {
+	if !enabled {
+		return nil, ErrDisabled
+	}
+
+	_, host, err := ParseAddress(req.ShareWith)
+	if err != nil {
+		return nil, err
+	}
+	server, ok := trustedServer(host)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUntrusted, host)
+	}
+
+	var repo *model.Repository
+	if req.Repo == "" {
+		repo, err = stor.GetHomeRepo(ctx, user)
+	} else {
+		repo, err = stor.GetRepository(ctx, req.Repo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: repository not found", ErrInvalid)
+	}
+
+	res := &model.Resource{Repo: repo, Path: model.CleanPath(req.Path)}
+	if err := perm.Evaluate(ctx, perm.User(user.ID), res, perm.Manage); err != nil {
+		return nil, ErrForbidden
+	}
+
+	target, err := db.GetFile(ctx, repo.ID, res.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s not found", ErrInvalid, res.Path)
+	}
+	if !target.IsDir {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalid, res.Path)
+	}
+	if err := classify.CheckShare(ctx, repo, res.Path); err != nil {
+		return nil, err
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+
+	share := &model.FederatedShare{
+		RepoID:    repo.ID,
+		OwnerID:   user.ID,
+		Path:      res.Path,
+		ShareWith: req.ShareWith,
+		Server:    server,
+		Secret:    secret,
+		Status:    model.FederationPending,
+	}
+	if err := db.CreateFederatedShare(ctx, share); err != nil {
+		return nil, err
+	}
+
+	name := path.Base(res.Path)
+	if res.Path == "" {
+		name = repo.Name
+	}
+	offer := &Offer{
+		ShareWith:    req.ShareWith,
+		Name:         name,
+		ProviderID:   strconv.Itoa(share.ID),
+		Owner:        Address(user.Username),
+		Sender:       Address(user.Username),
+		ResourceType: "folder",
+		ShareType:    "user",
+		Protocol:     Protocol{Name: ProtocolName, Options: ProtocolOptions{SharedSecret: secret}},
+	}
+	if err := post(ctx, server+SharesPath, offer); err != nil {
+		if err := db.DeleteFederatedShare(ctx, share.ID); err != nil {
+			log.Printf("Failed to remove federated share %d not delivered: %s", share.ID, err)
+		}
+		return nil, err
+	}
+	return share, nil
+}
+
+// List returns the folders the user shared with users of other servers
+func List(ctx context.Context, user *model.User) ([]*model.FederatedShare, error) {
+	return db.ListFederatedShares(ctx, user.ID)
+}
+
+// Unshare ends a federated share of a folder the user manages and tells the recipient's server
+func Unshare(ctx context.Context, user *model.User, id int) error {
+	share, err := db.GetFederatedShare(ctx, id)
+	if err != nil {
+		return ErrNotFound
+	}
+	repo, err := db.GetRepositoryByID(ctx, share.RepoID)
+	if err != nil {
+		return ErrNotFound
+	}
+	if perm.Evaluate(ctx, perm.User(user.ID), &model.Resource{Repo: repo, Path: share.Path}, perm.Manage) != nil {
+		return ErrNotFound
+	}
+	if err := db.DeleteFederatedShare(ctx, id); err != nil {
+		return err
+	}
+
+	// The secret stops working either way, the notification only tidies up the other side
+	if enabled {
+		if err := notifyServer(ctx, share.Server, ShareUnshared, share.ID, share.Secret); err != nil {
+			log.Printf("Failed to tell %s federated share %d ended: %s", share.Server, share.ID, err)
+		}
+	}
+	return nil
+}
+
+func notifyServer(ctx context.Context, server, notificationType string, providerID any, secret string) error {
+	return post(ctx, server+NotificationsPath, &Notification{
+		NotificationType: notificationType,
+		ResourceType:     "folder",
+		ProviderID:       fmt.Sprint(providerID),
+		Notification:     NotificationDetails{SharedSecret: secret},
+	})
+}
+
+// Receive stores a share offered by a trusted server to a local user, who is told about it. Anyone can
+// post an offer naming any owner, so it is first confirmed with the server of the owner, which must
+// describe the same share for its secret. Offers to unknown users are answered like any other, without
+// storing anything, so that they do not tell which users exist.
+func Receive(ctx context.Context, offer *Offer) (*model.RemoteShare, error) {
+	if !enabled {
+		return nil, ErrDisabled
+	}
+	if offer.Protocol.Name != ProtocolName || offer.Protocol.Options.SharedSecret == "" || offer.ProviderID == "" {
+		return nil, fmt.Errorf("%w: unsupported protocol", ErrInvalid)
+	}
+	if offer.ResourceType != "folder" {
+		return nil, fmt.Errorf("%w: unsupported resource type %q", ErrInvalid, offer.ResourceType)
+	}
+
+	_, ownerHost, err := ParseAddress(offer.Owner)
+	if err != nil {
+		return nil, err
+	}
+	server, ok := trustedServer(ownerHost)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUntrusted, ownerHost)
+	}
+
+	username, host, err := ParseAddress(offer.ShareWith)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(host, self.Host) {
+		return nil, fmt.Errorf("%w: %s is not a user of this server", ErrInvalid, offer.ShareWith)
+	}
+	if err := confirmOffer(ctx, server, offer); err != nil {
+		return nil, err
+	}
+
+	user, err := db.GetUserByUsername(ctx, username)
+	if err != nil {
+		log.Printf("Ignoring federated share from %s to %s: %s", offer.Owner, username, err)
+		return &model.RemoteShare{Name: mountName(nil, offer.Name), Owner: offer.Owner, Server: server}, nil
+	}
+
+	existing, err := db.ListRemoteShares(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	share := &model.RemoteShare{
+		UserID:   user.ID,
+		Name:     mountName(existing, offer.Name),
+		Owner:    offer.Owner,
+		Server:   server,
+		RemoteID: offer.ProviderID,
+		Secret:   offer.Protocol.Options.SharedSecret,
+		Status:   model.FederationPending,
+	}
+	if err := db.CreateRemoteShare(ctx, share); err != nil {
+		return nil, err
+	}
+
+	notify.Send(ctx, user.ID, model.NotifyFederatedShare, "notify.federated_share", share.Owner, share.Name)
+	return share, nil
+}
+
+// confirmOffer asks the server of an offer's owner to describe the share, with its secret as bearer
+// token, and fails unless it is the same share: the same owner offering it to the same user
+func confirmOffer(ctx context.Context, server string, offer *Offer) error {
+	target := server + SharesPath + "/" + url.PathEscape(offer.ProviderID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+offer.Protocol.Options.SharedSecret)
+	req.Header.Set("User-Agent", "FileHub-Federation")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrRemote, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s does not confirm share %s, answered %s", ErrUntrusted, server, offer.ProviderID, resp.Status)
+	}
+	var confirmed Offer
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&confirmed); err != nil {
+		return fmt.Errorf("%w: invalid share description from %s: %s", ErrRemote, server, err)
+	}
+	if confirmed.ProviderID != offer.ProviderID || !strings.EqualFold(confirmed.Owner, offer.Owner) ||
+		!strings.EqualFold(confirmed.ShareWith, offer.ShareWith) {
+		return fmt.Errorf("%w: %s describes share %s differently", ErrUntrusted, server, offer.ProviderID)
+	}
+	return nil
+}
+
+// Describe returns the offer of a share this server made, for the recipient's server to confirm it.
+// The secret proves the asking server received the offer; it is left out of the description.
+func Describe(ctx context.Context, providerID, secret string) (*Offer, error) {
+	if !enabled {
+		return nil, ErrDisabled
+	}
+	if secret == "" {
+		return nil, ErrNotFound
+	}
+
+	share, err := db.GetFederatedShareBySecret(ctx, secret)
+	if err != nil || strconv.Itoa(share.ID) != providerID {
+		return nil, ErrNotFound
+	}
+	owner, err := db.GetUserByID(ctx, share.OwnerID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	return &Offer{
+		ShareWith:    share.ShareWith,
+		ProviderID:   providerID,
+		Owner:        Address(owner.Username),
+		Sender:       Address(owner.Username),
+		ResourceType: "folder",
+		ShareType:    "user",
+		Protocol:     Protocol{Name: ProtocolName},
+	}, nil
+}
+
+// mountName returns a name for a share under /federated that none of the existing shares has,
+// appending " (n)" when needed
+func mountName(existing []*model.RemoteShare, name string) string {
+	name = strings.TrimSpace(strings.NewReplacer("/", "_", `\`, "_").Replace(name))
+	if name == "" || name == "." || name == ".." {
+		name = "shared"
+	}
+
+	taken := map[string]bool{}
+	for _, share := range existing {
+		taken[share.Name] = true
+	}
+
+	candidate := name
+	for n := 2; taken[candidate]; n++ {
+		candidate = fmt.Sprintf("%s (%d)", name, n)
+	}
+	return candidate
+}
+
+// Incoming returns the shares offered to the user by other servers
+func Incoming(ctx context.Context, user *model.User) ([]*model.RemoteShare, error) {
+	return db.ListRemoteShares(ctx, user.ID)
+}
+
+// Accept accepts a share offered to the user, telling its server, and mounts it under /federated
+func Accept(ctx context.Context, user *model.User, id int) (*model.RemoteShare, error) {
+	if !enabled {
+		return nil, ErrDisabled
+	}
+
+	share, err := db.GetRemoteShare(ctx, user.ID, id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if share.Status == model.FederationAccepted {
+		return share, nil
+	}
+
+	if err := notifyServer(ctx, share.Server, ShareAccepted, share.RemoteID, share.Secret); err != nil {
+		return nil, err
+	}
+	if err := db.SetRemoteShareStatus(ctx, share.ID, model.FederationAccepted); err != nil {
+		return nil, err
+	}
+
+	share.Status = model.FederationAccepted
+	return share, nil
+}
+
+// Decline declines a share offered to the user, or leaves one accepted before, telling its server
+func Decline(ctx context.Context, user *model.User, id int) error {
+	share, err := db.GetRemoteShare(ctx, user.ID, id)
+	if err != nil {
+		return ErrNotFound
+	}
+	if err := db.DeleteRemoteShare(ctx, share.ID); err != nil {
+		return err
+	}
+
+	if enabled {
+		if err := notifyServer(ctx, share.Server, ShareDeclined, share.RemoteID, share.Secret); err != nil {
+			log.Printf("Failed to tell %s remote share %s was declined: %s", share.Server, share.RemoteID, err)
+		}
+	}
+	return nil
+}
+
+// Notify handles a notification from another server about one of the shares exchanged with it.
+// The share's secret proves the notification comes from the server it was exchanged with.
+func Notify(ctx context.Context, n *Notification) error {
+	if !enabled {
+		return ErrDisabled
+	}
+
+	secret := n.Notification.SharedSecret
+	switch n.NotificationType {
+	case ShareAccepted, ShareDeclined:
+		share, err := db.GetFederatedShareBySecret(ctx, secret)
+		if err != nil || strconv.Itoa(share.ID) != n.ProviderID {
+			return ErrNotFound
+		}
+
+		repoName := ""
+		if repo, err := db.GetRepositoryByID(ctx, share.RepoID); err == nil {
+			repoName = repo.Name
+		}
+
+		if n.NotificationType == ShareDeclined {
+			if err := db.DeleteFederatedShare(ctx, share.ID); err != nil {
+				return err
+			}
+			notify.Send(ctx, share.OwnerID, model.NotifyFederatedReply, "notify.federated_declined",
+				share.ShareWith, displayPath(share.Path), repoName)
+			return nil
+		}
+
+		if share.Status == model.FederationAccepted {
+			return nil
+		}
+		if err := db.SetFederatedShareStatus(ctx, share.ID, model.FederationAccepted); err != nil {
+			return err
+		}
+		notify.Send(ctx, share.OwnerID, model.NotifyFederatedReply, "notify.federated_accepted",
+			share.ShareWith, displayPath(share.Path), repoName)
+		return nil
+
+	case ShareUnshared:
+		share, err := db.GetRemoteShareBySecret(ctx, n.ProviderID, secret)
+		if err != nil {
+			return ErrNotFound
+		}
+		return db.DeleteRemoteShare(ctx, share.ID)
+
+	default:
+		return fmt.Errorf("%w: unsupported notification %q", ErrInvalid, n.NotificationType)
+	}
+}
+
+// displayPath returns a repository path as shown to users
+func displayPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return p
+}
+
+// Resolve returns the accepted share another server presents the secret of, and the resource
+// at a path relative to its folder
+func Resolve(ctx context.Context, secret, rel string) (*model.FederatedShare, *model.Resource, error) {
+	if !enabled {
+		return nil, nil, ErrDisabled
+	}
+	if secret == "" {
+		return nil, nil, ErrNotFound
+	}
+
+	share, err := db.GetFederatedShareBySecret(ctx, secret)
+	if err != nil {
+		return nil, nil, ErrNotFound
+	}
+	if share.Status != model.FederationAccepted {
+		return nil, nil, ErrForbidden
+	}
+
+	repo, err := db.GetRepositoryByID(ctx, share.RepoID)
+	if err != nil {
+		return nil, nil, ErrNotFound
+	}
+	return share, &model.Resource{Repo: repo, Path: model.CleanPath(share.Path + path.Clean("/"+rel))}, nil
+}
+
+// Mounts returns the accepted shares of the user, mounted under /federated by their names
+func Mounts(ctx context.Context, user *model.User) ([]*model.RemoteShare, error) {
+	shares, err := db.ListRemoteShares(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var mounts []*model.RemoteShare
+	for _, share := range shares {
+		if share.Status == model.FederationAccepted {
+			mounts = append(mounts, share)
+		}
+	}
+	return mounts, nil
+}
+
+// proxiedHeaders are the request headers passed on when reading remote content
+var proxiedHeaders = []string{"Range", "If-None-Match", "If-Modified-Since", "If-Range"}
+
+// Open reads a path of a share mounted by the user from its server, returning the response to relay.
+// Directories are answered with a listing, files with their content.
+func Open(ctx context.Context, user *model.User, name, rel string, header http.Header) (*http.Response, error) {
+	if !enabled {
+		return nil, ErrDisabled
+	}
+
+	share, err := db.GetRemoteShareByName(ctx, user.ID, name)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if share.Status != model.FederationAccepted {
+		return nil, ErrForbidden
+	}
+
+	target := share.Server + FilesPath + (&url.URL{Path: path.Clean("/" + rel)}).EscapedPath()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+share.Secret)
+	req.Header.Set("User-Agent", "FileHub-Federation")
+	for _, key := range proxiedHeaders {
+		if value := header.Get(key); value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	resp, err := proxyClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRemote, err)
+	}
+	return resp, nil
+}
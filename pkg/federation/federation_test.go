@@ -0,0 +1,233 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setup enables federation for a test, as files.example.com trusting the given servers
+func setup(t *testing.T, servers ...string) {
+	cfg := &config.Config{Federation: config.FederationConfig{
+		Enabled:        true,
+		BaseURL:        "https://files.example.com/",
+		TrustedServers: servers,
+	}}
+	Init(cfg)
+	t.Cleanup(func() { Init(&config.Config{}) })
+}
+
+func TestInit(t *testing.T) {
+	Init(&config.Config{Federation: config.FederationConfig{Enabled: true, BaseURL: "not a url"}})
+	assert.False(t, Enabled())
+
+	setup(t, "https://files.example.org", "ftp://files.example.net", "http://LOCALHOST:8080/")
+	assert.True(t, Enabled())
+	assert.Equal(t, "alice@files.example.com", Address("alice"))
+
+	server, ok := trustedServer("files.example.org")
+	assert.True(t, ok)
+	assert.Equal(t, "https://files.example.org", server)
+
+	server, ok = trustedServer("localhost:8080")
+	assert.True(t, ok)
+	assert.Equal(t, "http://LOCALHOST:8080", server)
+
+	_, ok = trustedServer("files.example.net")
+	assert.False(t, ok)
+}
+
+func TestParseAddress(t *testing.T) {
+	user, host, err := ParseAddress("bob@Files.Example.org")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", user)
+	assert.Equal(t, "files.example.org", host)
+
+	user, _, err = ParseAddress("bob@home@files.example.org")
+	require.NoError(t, err)
+	assert.Equal(t, "bob@home", user)
+
+	for _, addr := range []string{"bob", "@files.example.org", "bob@", ""} {
+		_, _, err := ParseAddress(addr)
+		assert.ErrorIs(t, err, ErrInvalid, addr)
+	}
+}
+
+func TestMountName(t *testing.T) {
+	existing := []*model.RemoteShare{{Name: "Photos"}, {Name: "Photos (2)"}}
+	assert.Equal(t, "Photos (3)", mountName(existing, "Photos"))
+	assert.Equal(t, "Docs", mountName(existing, "Docs"))
+	assert.Equal(t, "a_b", mountName(nil, "a/b"))
+	assert.Equal(t, "shared", mountName(nil, ".."))
+	assert.Equal(t, "shared", mountName(nil, " "))
+}
+
+func TestDisabled(t *testing.T) {
+	Init(&config.Config{})
+	ctx := context.Background()
+	user := &model.User{ID: 1, Username: "alice"}
+
+	_, err := Share(ctx, user, &ShareRequest{Path: "/docs", ShareWith: "bob@files.example.org"})
+	assert.ErrorIs(t, err, ErrDisabled)
+	_, err = Receive(ctx, &Offer{})
+	assert.ErrorIs(t, err, ErrDisabled)
+	assert.ErrorIs(t, Notify(ctx, &Notification{}), ErrDisabled)
+	_, _, err = Resolve(ctx, "secret", "/")
+	assert.ErrorIs(t, err, ErrDisabled)
+	_, err = Open(ctx, user, "docs", "/", http.Header{})
+	assert.ErrorIs(t, err, ErrDisabled)
+
+	assert.False(t, Discover().Enabled)
+}
+
+func TestDiscover(t *testing.T) {
+	setup(t)
+
+	provider := Discover()
+	assert.True(t, provider.Enabled)
+	assert.Equal(t, "https://files.example.com/ocm", provider.EndPoint)
+	require.Len(t, provider.ResourceTypes, 1)
+	assert.Equal(t, FilesPath, provider.ResourceTypes[0].Protocols[ProtocolName])
+}
+
+func TestShareUntrusted(t *testing.T) {
+	setup(t, "https://files.example.org")
+	user := &model.User{ID: 1, Username: "alice"}
+
+	_, err := Share(context.Background(), user, &ShareRequest{Path: "/docs", ShareWith: "bob@files.example.net"})
+	assert.ErrorIs(t, err, ErrUntrusted)
+
+	_, err = Share(context.Background(), user, &ShareRequest{Path: "/docs", ShareWith: "bob"})
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestReceiveValidation(t *testing.T) {
+	setup(t, "https://files.example.org")
+	valid := func() *Offer {
+		return &Offer{
+			ShareWith:    "bob@files.example.com",
+			Name:         "docs",
+			ProviderID:   "7",
+			Owner:        "alice@files.example.org",
+			ResourceType: "folder",
+			Protocol:     Protocol{Name: ProtocolName, Options: ProtocolOptions{SharedSecret: "s3cret"}},
+		}
+	}
+
+	offer := valid()
+	offer.Protocol.Name = "webdav"
+	_, err := Receive(context.Background(), offer)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	offer = valid()
+	offer.Protocol.Options.SharedSecret = ""
+	_, err = Receive(context.Background(), offer)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	offer = valid()
+	offer.ResourceType = "file"
+	_, err = Receive(context.Background(), offer)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	offer = valid()
+	offer.Owner = "mallory@files.example.net"
+	_, err = Receive(context.Background(), offer)
+	assert.ErrorIs(t, err, ErrUntrusted)
+
+	offer = valid()
+	offer.ShareWith = "bob@files.example.org"
+	_, err = Receive(context.Background(), offer)
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestNotifyValidation(t *testing.T) {
+	setup(t)
+	err := Notify(context.Background(), &Notification{NotificationType: "SHARE_RESHARED"})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, _, err = Resolve(context.Background(), "", "/")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestPost(t *testing.T) {
+	var received Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, NotificationsPath, r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	require.NoError(t, notifyServer(context.Background(), server.URL, ShareAccepted, "7", "s3cret"))
+	assert.Equal(t, ShareAccepted, received.NotificationType)
+	assert.Equal(t, "7", received.ProviderID)
+	assert.Equal(t, "s3cret", received.Notification.SharedSecret)
+
+	refusing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer refusing.Close()
+
+	err := notifyServer(context.Background(), refusing.URL, ShareAccepted, 7, "s3cret")
+	assert.ErrorIs(t, err, ErrRemote)
+}
+
+func TestConfirmOffer(t *testing.T) {
+	offer := &Offer{
+		ShareWith:  "bob@files.example.com",
+		ProviderID: "7",
+		Owner:      "alice@files.example.org",
+		Protocol:   Protocol{Name: ProtocolName, Options: ProtocolOptions{SharedSecret: "s3cret"}},
+	}
+	describe := func(owner string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != SharesPath+"/7" || r.Header.Get("Authorization") != "Bearer s3cret" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(&Offer{ShareWith: "BOB@files.example.com", ProviderID: "7", Owner: owner})
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	server := describe("alice@files.example.org")
+	assert.NoError(t, confirmOffer(context.Background(), server.URL, offer))
+
+	// A forged offer carries a secret the owner's server does not know
+	forged := *offer
+	forged.Protocol.Options.SharedSecret = "guessed"
+	assert.ErrorIs(t, confirmOffer(context.Background(), server.URL, &forged), ErrUntrusted)
+
+	// The secret of a real share does not vouch for an offer naming another owner
+	server = describe("carol@files.example.org")
+	assert.ErrorIs(t, confirmOffer(context.Background(), server.URL, offer), ErrUntrusted)
+}
+
+func TestReceiveUnconfirmed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	setup(t, server.URL)
+
+	// Forged offers are refused before the recipient is looked up, whoever it is
+	_, err := Receive(context.Background(), &Offer{
+		ShareWith:    "bob@files.example.com",
+		Name:         "docs",
+		ProviderID:   "7",
+		Owner:        "alice@" + strings.TrimPrefix(server.URL, "http://"),
+		ResourceType: "folder",
+		Protocol:     Protocol{Name: ProtocolName, Options: ProtocolOptions{SharedSecret: "s3cret"}},
+	})
+	assert.ErrorIs(t, err, ErrUntrusted)
+}
@@ -0,0 +1,334 @@
+// Package hooks posts repository changes to webhooks, for example to run CI when files change.
+// Changes are queued as they are recorded and delivered in the background, so a slow or failing
+// receiver never holds up the change itself. Each repository has its own queue, so a slow receiver
+// only delays the changes of its repository. Webhooks only reach public addresses, see egress.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/egress"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/google/uuid"
+)
+
+const (
+	// SignatureHeader carries the hex encoded HMAC-SHA256 of the payload, keyed with the webhook secret
+	SignatureHeader = "X-FileHub-Signature-256"
+	// EventHeader names the kind of event delivered
+	EventHeader = "X-FileHub-Event"
+	// DeliveryHeader carries a unique ID of each delivery
+	DeliveryHeader = "X-FileHub-Delivery"
+
+	// EventChange is the event delivered for a recorded change
+	EventChange = "change"
+	// EventFlagged is the event of the classifier alert, sent when sensitive content is found in a file
+	EventFlagged = "file_flagged"
+
+	// MaxWebhooks is the most webhooks a repository may have
+	MaxWebhooks = 20
+
+	// queueSize is how many changes of a repository may wait for delivery before new ones are dropped
+	queueSize = 100
+	// deliveryWorkers is how many repositories have changes delivered at once
+	deliveryWorkers = 8
+	// deliveryTimeout bounds each delivery
+	deliveryTimeout = 10 * time.Second
+)
+
+// Operations are the change log operations a webhook can be limited to
+var Operations = []string{"create", "modify", "delete", "move", "rename", "copy"}
+
+var (
+	// ErrInvalid is returned when a webhook is malformed
+	ErrInvalid = errors.New("invalid webhook")
+	// ErrForbidden is returned when the user may not manage the repository, which webhooks need
+	ErrForbidden = errors.New("managing webhooks needs the manage permission on the repository")
+)
+
+var (
+	mu         sync.Mutex
+	baseCtx    context.Context               // context of deliveries, nil until Start is called
+	queues     map[int]chan *model.ChangeLog // changes waiting for delivery, by repository
+	deliveries chan struct{}                 // a slot for each delivering repository
+)
+
+var client = egress.Client(deliveryTimeout)
+
+// Start delivers queued changes until ctx is done
+func Start(ctx context.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+	baseCtx = ctx
+	queues = make(map[int]chan *model.ChangeLog)
+	deliveries = make(chan struct{}, deliveryWorkers)
+}
+
+// Fire queues a recorded change for delivery to the webhooks of its repository, in the order changes
+// are recorded. Changes are dropped, with a log message, when the queue of the repository is full.
+func Fire(change *model.ChangeLog) {
+	mu.Lock()
+	defer mu.Unlock()
+	if baseCtx == nil {
+		return
+	}
+
+	queue, ok := queues[change.RepoID]
+	if !ok {
+		queue = make(chan *model.ChangeLog, queueSize)
+		queues[change.RepoID] = queue
+		go drain(baseCtx, change.RepoID, queue)
+	}
+
+	select {
+	case queue <- change:
+	default:
+		log.Printf("Webhook queue of repository %d full, dropping %s of %s", change.RepoID, change.Operation, change.Path)
+	}
+}
+
+// drain delivers the changes of a repository until its queue is empty, which it then removes
+func drain(ctx context.Context, repoID int, queue chan *model.ChangeLog) {
+	for {
+		mu.Lock()
+		if len(queue) == 0 || ctx.Err() != nil {
+			delete(queues, repoID)
+			mu.Unlock()
+			return
+		}
+		mu.Unlock()
+
+		change := <-queue // only drain receives, so the change is there
+		select {
+		case deliveries <- struct{}{}:
+		case <-ctx.Done():
+			continue
+		}
+		deliverChange(ctx, change)
+		<-deliveries
+	}
+}
+
+// Request describes a webhook to create
+type Request struct {
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret,omitempty"` // generated when empty
+	Paths      []string `json:"paths,omitempty"`
+	Operations []string `json:"operations,omitempty"`
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (req *Request) validate() error {
+	if err := egress.CheckURL(req.URL); err != nil {
+		return fmt.Errorf("%w: url must be an absolute http or https URL of a public host: %s", ErrInvalid, err)
+	}
+
+	for _, pattern := range req.Paths {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: bad path glob %q", ErrInvalid, pattern)
+		}
+	}
+
+	for _, op := range req.Operations {
+		if !slices.Contains(Operations, op) {
+			return fmt.Errorf("%w: unknown operation %q", ErrInvalid, op)
+		}
+	}
+	return nil
+}
+
+// Create adds a webhook to a repository. The returned webhook carries the secret, which is not shown again.
+func Create(ctx context.Context, user *model.User, repo *model.Repository, req *Request) (*model.Webhook, error) {
+	if perm.Evaluate(ctx, perm.User(user.ID), &model.Resource{Repo: repo}, perm.Manage) != nil {
+		return nil, ErrForbidden
+	}
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	existing, err := db.ListWebhooks(ctx, repo.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= MaxWebhooks {
+		return nil, fmt.Errorf("%w: a repository can have at most %d webhooks", ErrInvalid, MaxWebhooks)
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		if secret, err = generateSecret(); err != nil {
+			return nil, fmt.Errorf("failed to generate secret: %w", err)
+		}
+	}
+
+	hook := &model.Webhook{
+		RepoID:     repo.ID,
+		URL:        req.URL,
+		Secret:     secret,
+		Paths:      req.Paths,
+		Operations: req.Operations,
+		Active:     true,
+		CreatedBy:  user.ID,
+	}
+	if err := db.CreateWebhook(ctx, hook); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// List returns the webhooks of a repository
+func List(ctx context.Context, user *model.User, repo *model.Repository) ([]*model.Webhook, error) {
+	if perm.Evaluate(ctx, perm.User(user.ID), &model.Resource{Repo: repo}, perm.Manage) != nil {
+		return nil, ErrForbidden
+	}
+	return db.ListWebhooks(ctx, repo.ID, false)
+}
+
+// SetActive pauses or resumes the deliveries of a webhook
+func SetActive(ctx context.Context, user *model.User, repo *model.Repository, id int, active bool) error {
+	if perm.Evaluate(ctx, perm.User(user.ID), &model.Resource{Repo: repo}, perm.Manage) != nil {
+		return ErrForbidden
+	}
+	return db.SetWebhookActive(ctx, repo.ID, id, active)
+}
+
+// Delete removes a webhook from a repository
+func Delete(ctx context.Context, user *model.User, repo *model.Repository, id int) error {
+	if perm.Evaluate(ctx, perm.User(user.ID), &model.Resource{Repo: repo}, perm.Manage) != nil {
+		return ErrForbidden
+	}
+	return db.DeleteWebhook(ctx, repo.ID, id)
+}
+
+// Actor is the user who made a change
+type Actor struct {
+	ID       int    `json:"id"`
+	Username string `json:"username,omitempty"`
+}
+
+// Payload is the JSON body of a change delivery
+type Payload struct {
+	Event     string    `json:"event"`
+	WebhookID int       `json:"webhook_id"`
+	Repo      string    `json:"repo"`
+	Operation string    `json:"operation"`
+	Paths     []string  `json:"paths"` // the changed path, preceded by the old path of a move
+	Version   string    `json:"version"`
+	Actor     Actor     `json:"actor"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// changedPaths returns the paths touched by a change
+func changedPaths(change *model.ChangeLog) []string {
+	if change.OldPath != nil {
+		return []string{*change.OldPath, change.Path}
+	}
+	return []string{change.Path}
+}
+
+// Sign returns the signature header value of a payload
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func deliverChange(ctx context.Context, change *model.ChangeLog) {
+	webhooks, err := db.ListWebhooks(ctx, change.RepoID, true)
+	if err != nil {
+		log.Printf("Failed to list webhooks of repository %d: %s", change.RepoID, err)
+		return
+	}
+
+	paths := changedPaths(change)
+	var matched []*model.Webhook
+	for _, hook := range webhooks {
+		if hook.Matches(change.Operation, paths...) {
+			matched = append(matched, hook)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	payload := Payload{
+		Event:     EventChange,
+		Operation: change.Operation,
+		Paths:     paths,
+		Version:   change.Version,
+		Actor:     Actor{ID: change.UserID},
+		Timestamp: change.Timestamp,
+	}
+	if repo, err := db.GetRepositoryByID(ctx, change.RepoID); err == nil {
+		payload.Repo = repo.Name
+	}
+	if user, err := db.GetUserByID(ctx, change.UserID); err == nil {
+		payload.Actor.Username = user.Username
+	}
+
+	for _, hook := range matched {
+		payload.WebhookID = hook.ID
+		status, err := Send(ctx, hook.URL, hook.Secret, payload.Event, &payload)
+
+		errMsg := ""
+		if err != nil {
+			errMsg = err.Error()
+			log.Printf("Failed to deliver webhook %d: %s", hook.ID, err)
+		}
+		if err := db.RecordWebhookDelivery(ctx, hook.ID, time.Now(), status, errMsg); err != nil {
+			log.Printf("Failed to record delivery of webhook %d: %s", hook.ID, err)
+		}
+	}
+}
+
+// Send posts an event as JSON to a URL, signed with the secret, returning the response status
+func Send(ctx context.Context, url, secret, event string, payload any) (int, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FileHub-Webhook")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(DeliveryHeader, uuid.New().String())
+	req.Header.Set(SignatureHeader, Sign(secret, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,104 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/egress"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	valid := &Request{URL: "https://ci.example.com/hook", Paths: []string{"/src/**"}, Operations: []string{"create", "move"}}
+	assert.NoError(t, valid.validate())
+
+	for _, req := range []*Request{
+		{URL: "ftp://ci.example.com/hook"},
+		{URL: "/relative"},
+		{URL: "https://ci.example.com", Paths: []string{"/src/["}},
+		{URL: "https://ci.example.com", Operations: []string{"truncate"}},
+		{URL: "http://169.254.169.254/latest/meta-data/"},
+		{URL: "http://localhost:8080/hook"},
+		{URL: "http://[::1]/hook"},
+		{URL: "http://192.168.1.10/hook"},
+	} {
+		assert.ErrorIs(t, req.validate(), ErrInvalid, req)
+	}
+}
+
+func TestChangedPaths(t *testing.T) {
+	assert.Equal(t, []string{"/a.txt"}, changedPaths(&model.ChangeLog{Path: "/a.txt"}))
+
+	old := "/old.txt"
+	assert.Equal(t, []string{"/old.txt", "/new.txt"}, changedPaths(&model.ChangeLog{Path: "/new.txt", OldPath: &old}))
+}
+
+// allowLoopback lets deliveries reach test servers on loopback for the rest of a test
+func allowLoopback(t *testing.T) {
+	saved := client
+	client = &http.Client{Timeout: deliveryTimeout}
+	t.Cleanup(func() { client = saved })
+}
+
+func TestSend(t *testing.T) {
+	allowLoopback(t)
+	var received Payload
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+		assert.Equal(t, Sign("s3cret", body), signature)
+		assert.Equal(t, EventChange, r.Header.Get(EventHeader))
+		assert.NotEmpty(t, r.Header.Get(DeliveryHeader))
+		require.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer server.Close()
+
+	hook := &model.Webhook{ID: 3, URL: server.URL, Secret: "s3cret"}
+	payload := &Payload{
+		Event:     EventChange,
+		WebhookID: hook.ID,
+		Repo:      "docs",
+		Operation: "create",
+		Paths:     []string{"/src/main.go"},
+		Version:   "v1-0",
+		Actor:     Actor{ID: 1, Username: "alice"},
+		Timestamp: time.Unix(1700000000, 0).UTC(),
+	}
+
+	status, err := Send(context.Background(), hook.URL, hook.Secret, EventChange, payload)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, *payload, received)
+	assert.Regexp(t, "^sha256=[0-9a-f]{64}$", signature)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	status, err = Send(context.Background(), failing.URL, "", EventChange, payload)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadGateway, status)
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("delivered to a loopback address")
+	}))
+	defer server.Close()
+
+	_, err := Send(context.Background(), server.URL, "", EventChange, &Payload{})
+	assert.ErrorIs(t, err, egress.ErrNotPublic)
+}
+
+func TestFireWithoutStart(t *testing.T) {
+	assert.NotPanics(t, func() { Fire(&model.ChangeLog{Path: "/a.txt"}) })
+}
@@ -0,0 +1,107 @@
+// Package i18n translates user-facing messages.
+// Catalogs are JSON files in locales/, one per language, mapping message keys to
+// fmt format strings. Indexed verbs such as %[2]s let translations reorder arguments.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the locale used when no supported locale is requested
+const Default = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalogs maps a locale to its messages
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		log.Panicf("Failed to read message catalogs: %s", err)
+	}
+
+	catalogs := make(map[string]map[string]string)
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			log.Panicf("Failed to read message catalog %s: %s", entry.Name(), err)
+		}
+
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			log.Panicf("Invalid message catalog %s: %s", entry.Name(), err)
+		}
+		catalogs[strings.TrimSuffix(entry.Name(), ".json")] = messages
+	}
+	return catalogs
+}
+
+// Supported returns the locales that have a catalog, sorted
+func Supported() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Match returns the supported locale for a language tag such as "zh-CN", or "" if there is none
+func Match(tag string) string {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if _, ok := catalogs[tag]; ok {
+		return tag
+	}
+
+	base, _, _ := strings.Cut(tag, "-")
+	if _, ok := catalogs[base]; ok {
+		return base
+	}
+	return ""
+}
+
+// Negotiate picks the best supported locale for an Accept-Language header
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := Default, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		if locale := Match(tag); locale != "" && q > bestQ {
+			best, bestQ = locale, q
+		}
+	}
+	return best
+}
+
+// Has reports whether the default catalog has a message for the key
+func Has(key string) bool {
+	_, ok := catalogs[Default][key]
+	return ok
+}
+
+// Sprintf formats the message of a key in the given locale.
+// Messages missing from the locale fall back to the default locale, then to the key itself.
+func Sprintf(locale, key string, args ...any) string {
+	format, ok := catalogs[Match(locale)][key]
+	if !ok {
+		if format, ok = catalogs[Default][key]; !ok {
+			return key
+		}
+	}
+	return fmt.Sprintf(format, args...)
+}
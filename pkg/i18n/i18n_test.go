@@ -0,0 +1,32 @@
+package i18n
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	assert.Equal(t, "en", Negotiate(""))
+	assert.Equal(t, "zh", Negotiate("zh-CN,zh;q=0.9,en;q=0.8"))
+	assert.Equal(t, "en", Negotiate("fr-FR, en;q=0.5, zh;q=0.3"))
+	assert.Equal(t, "zh", Negotiate("fr, zh_TW;q=0.4"))
+	assert.Equal(t, "en", Negotiate("fr, de;q=0.8"))
+}
+
+func TestSprintf(t *testing.T) {
+	assert.Equal(t, "a.txt (3 bytes) was dropped into /in", Sprintf("en", "notify.file_dropped", "a.txt", 3, "/in"))
+	assert.Equal(t, "a.txt（3 字节）已上传到 /in", Sprintf("zh-Hans", "notify.file_dropped", "a.txt", 3, "/in"))
+
+	// Unknown locales use the default, unknown keys are returned as is
+	assert.Equal(t, "a.txt (3 bytes) was dropped into /in", Sprintf("fr", "notify.file_dropped", "a.txt", 3, "/in"))
+	assert.Equal(t, "no.such.key", Sprintf("zh", "no.such.key"))
+}
+
+func TestCatalogsComplete(t *testing.T) {
+	for _, locale := range Supported() {
+		for key := range catalogs[Default] {
+			assert.Contains(t, catalogs[locale], key, "%s is missing %s", locale, key)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Package lifecycle moves the files of repositories with a lifecycle policy to cold storage once nobody
+// opened them for a number of days. Their records point at the cold copy, and the storage layer fetches
+// them back into the repository when they are opened again.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/audit"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+)
+
+// MaxAfterDays is the longest idle time a policy accepts
+const MaxAfterDays = 36500
+
+// batchSize is how many candidate files are fetched at a time
+const batchSize = 500
+
+// ErrInvalid is returned when a policy is malformed
+var ErrInvalid = errors.New("invalid lifecycle policy")
+
+// Set sets the lifecycle policy of a repository, moving files not opened for afterDays to coldRoot, which
+// must be listed in storage.cold_roots. An empty coldRoot removes the policy; files already moved stay in
+// cold storage until opened.
+func Set(ctx context.Context, repo *model.Repository, coldRoot string, afterDays int) error {
+	if coldRoot == "" {
+		afterDays = 0
+	} else {
+		if !stor.IsColdRoot(coldRoot) {
+			return fmt.Errorf("%w: %s is not listed in storage.cold_roots", ErrInvalid, coldRoot)
+		}
+		if coldRoot == repo.Root {
+			return fmt.Errorf("%w: cold_root is the root of the repository", ErrInvalid)
+		}
+		if afterDays <= 0 || afterDays > MaxAfterDays {
+			return fmt.Errorf("%w: after_days must be between 1 and %d", ErrInvalid, MaxAfterDays)
+		}
+	}
+
+	if err := db.UpdateRepositoryLifecycle(ctx, repo.ID, coldRoot, afterDays); err != nil {
+		return err
+	}
+	repo.ColdRoot, repo.ColdAfterDays = coldRoot, afterDays
+	return nil
+}
+
+// Run moves every file idle at now under the policy of its repository to cold storage, returning how
+// many were moved
+func Run(ctx context.Context, now time.Time) (int, error) {
+	repos, err := db.ListLifecycleRepositories(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, repo := range repos {
+		n, err := apply(ctx, repo, now)
+		moved += n
+		if err != nil {
+			return moved, fmt.Errorf("failed to apply lifecycle policy of %s: %w", repo.Name, err)
+		}
+	}
+	return moved, nil
+}
+
+// apply moves the idle files of a single repository
+func apply(ctx context.Context, repo *model.Repository, now time.Time) (int, error) {
+	cutoff := now.AddDate(0, 0, -repo.ColdAfterDays)
+
+	moved, afterID := 0, 0
+	for {
+		files, err := db.ListColdCandidates(ctx, repo.ID, cutoff, afterID, batchSize)
+		if err != nil {
+			return moved, err
+		}
+
+		for _, file := range files {
+			afterID = file.ID
+			ok, err := stor.MoveToCold(ctx, repo, file, cutoff)
+			if err != nil {
+				return moved, fmt.Errorf("failed to move %s: %w", file.Path, err)
+			}
+			if ok {
+				moved++
+			}
+		}
+
+		if len(files) < batchSize {
+			break
+		}
+	}
+
+	if moved > 0 {
+		audit.Record(ctx, &model.AuditEntry{
+			Action: model.AuditFilesArchived,
+			UserID: &repo.OwnerID,
+			Detail: fmt.Sprintf("%d files in %s to %s", moved, repo.Name, repo.ColdRoot),
+		})
+	}
+	return moved, nil
+}
@@ -0,0 +1,31 @@
+package lifecycle
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetInvalid(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Storage.ColdRoots = []string{"s3://archive/files"}
+	stor.Init(context.Background(), cfg)
+
+	repo := &model.Repository{ID: 1, Name: "alice", Root: "/srv/files"}
+	for _, tc := range []struct {
+		root string
+		days int
+	}{
+		{"s3://other/files", 30},
+		{"/srv/files", 30},
+		{"s3://archive/files", 0},
+		{"s3://archive/files", MaxAfterDays + 1},
+	} {
+		assert.ErrorIs(t, Set(context.Background(), repo, tc.root, tc.days), ErrInvalid, tc.root)
+	}
+	assert.Empty(t, repo.ColdRoot)
+}
@@ -0,0 +1,119 @@
+package links
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AccessTTL is how long an access token issued for a link stays valid
+const AccessTTL = time.Hour
+
+var (
+	// ErrPasswordRequired is returned when a link that needs an access token is used without a valid one
+	ErrPasswordRequired = errors.New("access token required")
+	// ErrWrongPassword is returned when the password of a protected link does not match
+	ErrWrongPassword = errors.New("wrong password")
+	// ErrEmailRequired is returned when a view-only link is authorized without a valid email
+	ErrEmailRequired = errors.New("viewer email required")
+
+	// accessKey signs access tokens; tokens do not survive a restart
+	accessKey = newAccessKey()
+)
+
+func newAccessKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("failed to generate access key: %s", err))
+	}
+	return key
+}
+
+func signAccess(link *model.ShareLink, expires int64, viewer string) string {
+	mac := hmac.New(sha256.New, accessKey)
+	fmt.Fprintf(mac, "%s:%d:%s", link.Token, expires, viewer)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NeedsAccess reports whether requests through the link must carry an access token
+func NeedsAccess(link *model.ShareLink) bool {
+	return link.IsProtected() || link.Mode == model.LinkModeView
+}
+
+// Authorize verifies the password of a protected link and issues an access token.
+// View-only links also require the viewer's email, which the token carries for watermarking. The email
+// is whatever the viewer gives and is not verified, so it deters passing renderings on but proves nothing.
+func Authorize(link *model.ShareLink, password, email string, now time.Time) (string, time.Time, error) {
+	if link.IsProtected() {
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			return "", time.Time{}, ErrWrongPassword
+		}
+	}
+
+	viewer := ""
+	if link.Mode == model.LinkModeView {
+		addr, err := mail.ParseAddress(email)
+		if err != nil {
+			return "", time.Time{}, ErrEmailRequired
+		}
+		viewer = addr.Address
+	}
+
+	expires := now.Add(AccessTTL)
+	token := strings.Join([]string{
+		strconv.FormatInt(expires.Unix(), 10),
+		base64.RawURLEncoding.EncodeToString([]byte(viewer)),
+		signAccess(link, expires.Unix(), viewer),
+	}, ".")
+	return token, expires, nil
+}
+
+// CheckAccess verifies the access token presented for a link and returns the viewer it was issued to.
+// Links that need no token accept any request.
+func CheckAccess(link *model.ShareLink, access string, now time.Time) (string, error) {
+	if !NeedsAccess(link) {
+		return "", nil
+	}
+
+	parts := strings.Split(access, ".")
+	if len(parts) != 3 {
+		return "", ErrPasswordRequired
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return "", ErrPasswordRequired
+	}
+
+	viewer, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrPasswordRequired
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(signAccess(link, expires, string(viewer)))) {
+		return "", ErrPasswordRequired
+	}
+	return string(viewer), nil
+}
+
+// Resolve maps a path relative to the link onto its repository path,
+// confining it to the shared item
+func Resolve(link *model.ShareLink, rel string) string {
+	rel = path.Clean("/" + rel)
+	if rel == "/" {
+		return link.Path
+	}
+	return link.Path + rel
+}
@@ -0,0 +1,324 @@
+// Package links manages token based share links, such as upload-only file drops.
+package links
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/classify"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/notify"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/sync"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrNotFound is returned for unknown, revoked or expired links
+	ErrNotFound = errors.New("link not found or expired")
+	// ErrInvalid is returned for malformed link requests
+	ErrInvalid = errors.New("invalid link request")
+	// ErrForbidden is returned when the link does not permit the operation
+	ErrForbidden = errors.New("operation not permitted by link")
+	// ErrTooLarge is returned when an upload exceeds the link's size limit
+	ErrTooLarge = errors.New("upload exceeds the link's size limit")
+	// ErrTypeNotAllowed is returned when an upload's file type is not accepted by the link
+	ErrTypeNotAllowed = errors.New("file type not allowed")
+)
+
+// CreateRequest describes a new share link
+type CreateRequest struct {
+	Repo         string     `json:"repo,omitempty"` // defaults to the user's home repository
+	Path         string     `json:"path"`
+	Mode         string     `json:"mode"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxBytes     *int64     `json:"max_bytes,omitempty"`
+	AllowedTypes []string   `json:"allowed_types,omitempty"`
+	Password     string     `json:"password,omitempty"`
+	Short        bool       `json:"short,omitempty"` // also give the link a short slug
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Create creates a share link for a path the user can write to
+func Create(ctx context.Context, user *model.User, req *CreateRequest) (*model.ShareLink, error) {
+	action := perm.Read
+	switch req.Mode {
+	case model.LinkModeUpload:
+		action = perm.Write
+	case model.LinkModeRead, model.LinkModeSnapshot:
+	case model.LinkModeView:
+		action = perm.View
+	default:
+		return nil, fmt.Errorf("%w: unsupported mode %q", ErrInvalid, req.Mode)
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expiry must be in the future", ErrInvalid)
+	}
+	if req.MaxBytes != nil && *req.MaxBytes <= 0 {
+		return nil, fmt.Errorf("%w: max_bytes must be positive", ErrInvalid)
+	}
+
+	var repo *model.Repository
+	var err error
+	if req.Repo == "" {
+		repo, err = stor.GetHomeRepo(ctx, user)
+	} else {
+		repo, err = stor.GetRepository(ctx, req.Repo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: repository not found", ErrInvalid)
+	}
+
+	res := &model.Resource{Repo: repo, Path: model.CleanPath(req.Path)}
+	if err := perm.Evaluate(ctx, perm.User(user.ID), res, action); err != nil {
+		return nil, ErrForbidden
+	}
+
+	target, err := db.GetFile(ctx, repo.ID, res.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s not found", ErrInvalid, res.Path)
+	}
+	if req.Mode == model.LinkModeUpload && !target.IsDir {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalid, res.Path)
+	}
+	if req.Mode != model.LinkModeUpload {
+		if err := classify.CheckShare(ctx, repo, res.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	link := &model.ShareLink{
+		Token:        token,
+		RepoID:       repo.ID,
+		OwnerID:      user.ID,
+		Path:         res.Path,
+		Mode:         req.Mode,
+		ExpiresAt:    req.ExpiresAt,
+		MaxBytes:     req.MaxBytes,
+		AllowedTypes: req.AllowedTypes,
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		link.PasswordHash = string(hash)
+	}
+	if err := db.CreateShareLink(ctx, link); err != nil {
+		return nil, err
+	}
+	if link.Mode == model.LinkModeSnapshot {
+		if err := publish(ctx, repo, link, time.Now()); err != nil {
+			if err := db.DeleteShareLink(ctx, user.ID, link.ID); err != nil {
+				log.Printf("Failed to delete unpublished snapshot link %d: %s", link.ID, err)
+			}
+			return nil, err
+		}
+	}
+	if req.Short {
+		if err := assignSlug(ctx, link); err != nil {
+			return nil, err
+		}
+	}
+
+	return link, nil
+}
+
+// Get returns a link that is still valid
+func Get(ctx context.Context, token string) (*model.ShareLink, error) {
+	link, err := db.GetShareLinkByToken(ctx, token)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	if link.IsExpired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return link, nil
+}
+
+// GetOwned returns a link created by the user that is still valid
+func GetOwned(ctx context.Context, user *model.User, id int) (*model.ShareLink, error) {
+	link, err := db.GetShareLink(ctx, user.ID, id)
+	if err != nil || link.IsExpired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return link, nil
+}
+
+// List returns the links created by a user
+func List(ctx context.Context, user *model.User) ([]*model.ShareLink, error) {
+	return db.ListShareLinks(ctx, user.ID)
+}
+
+// Revoke deletes a link created by the user, and the content a snapshot link kept
+func Revoke(ctx context.Context, user *model.User, id int) error {
+	link, err := db.GetShareLink(ctx, user.ID, id)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	var files []*model.SnapshotFile
+	if link.Mode == model.LinkModeSnapshot {
+		if files, err = db.ListSnapshotFiles(ctx, link.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := db.DeleteShareLink(ctx, user.ID, id); err != nil {
+		return ErrNotFound
+	}
+	removeSnapshot(ctx, link.RepoID, files)
+	return nil
+}
+
+// PurgeExpired removes the links expired before now and tells their owners,
+// returning how many were removed
+func PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	files, err := db.ListExpiringSnapshotFiles(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	links, err := db.PurgeExpiredShareLinks(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	snapshots := make(map[int][]*model.SnapshotFile)
+	for _, file := range files {
+		snapshots[file.LinkID] = append(snapshots[file.LinkID], file)
+	}
+
+	for _, link := range links {
+		removeSnapshot(ctx, link.RepoID, snapshots[link.ID])
+		repoName := ""
+		if repo, err := db.GetRepositoryByID(ctx, link.RepoID); err == nil {
+			repoName = repo.Name
+		}
+		notify.Send(ctx, link.OwnerID, model.NotifyLinkExpired, "notify.link_expired",
+			link.Mode, displayPath(link.Path), repoName)
+	}
+	return len(links), nil
+}
+
+// TypeAllowed reports whether a file name is accepted by the link.
+// Allowed types are extensions (".pdf"), MIME types ("application/pdf") or MIME wildcards ("image/*").
+func TypeAllowed(link *model.ShareLink, name string) bool {
+	if len(link.AllowedTypes) == 0 {
+		return true
+	}
+
+	ext := strings.ToLower(path.Ext(name))
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+
+	for _, allowed := range link.AllowedTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		switch {
+		case strings.HasPrefix(allowed, "."):
+			if allowed == ext {
+				return true
+			}
+		case strings.HasSuffix(allowed, "/*"):
+			if mediaType != "" && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+				return true
+			}
+		case allowed == mediaType:
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeName strips any directory components from a client supplied file name
+func sanitizeName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// Drop stores a file uploaded through an upload link and notifies the link owner.
+// It returns the repository path the file was stored at.
+func Drop(ctx context.Context, link *model.ShareLink, name string, size int64, data io.Reader) (string, error) {
+	if link.Mode != model.LinkModeUpload {
+		return "", ErrForbidden
+	}
+
+	name = sanitizeName(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: missing file name", ErrInvalid)
+	}
+	if !TypeAllowed(link, name) {
+		return "", ErrTypeNotAllowed
+	}
+
+	ok, err := db.ReserveShareLinkBytes(ctx, link.ID, size)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrTooLarge
+	}
+
+	stored, err := store(ctx, link, name, data)
+	if err != nil {
+		if releaseErr := db.ReleaseShareLinkBytes(ctx, link.ID, size); releaseErr != nil {
+			log.Printf("Failed to release %d bytes of link %d: %s", size, link.ID, releaseErr)
+		}
+		return "", err
+	}
+
+	notify.Send(ctx, link.OwnerID, model.NotifyFileDropped, "notify.file_dropped",
+		path.Base(stored), size, displayPath(link.Path))
+	return stored, nil
+}
+
+func store(ctx context.Context, link *model.ShareLink, name string, data io.Reader) (string, error) {
+	repo, err := db.GetRepositoryByID(ctx, link.RepoID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get repository: %w", err)
+	}
+
+	// Drops never replace a file, those of the same name are numbered instead
+	return sync.WriteFree(ctx, repo.ID, link.Path+"/"+name, func(p string) error {
+		if _, err := stor.PutFile(ctx, &model.Resource{Repo: repo, Path: p}, data); err != nil {
+			return fmt.Errorf("failed to store file: %w", err)
+		}
+		return nil
+	})
+}
+
+func displayPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return p
+}
@@ -0,0 +1,241 @@
+package links
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTypeAllowed(t *testing.T) {
+	link := &model.ShareLink{AllowedTypes: []string{".PDF", "image/*", "text/csv"}}
+
+	tests := []struct {
+		name    string
+		allowed bool
+	}{
+		{"report.pdf", true},
+		{"scan.Pdf", true},
+		{"photo.jpg", true},
+		{"diagram.png", true},
+		{"data.csv", true},
+		{"notes.txt", false},
+		{"archive.zip", false},
+		{"no-extension", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, TypeAllowed(link, tt.name))
+		})
+	}
+
+	t.Run("No restriction", func(t *testing.T) {
+		assert.True(t, TypeAllowed(&model.ShareLink{}, "anything.bin"))
+	})
+}
+
+func TestSanitizeName(t *testing.T) {
+	assert.Equal(t, "file.txt", sanitizeName("file.txt"))
+	assert.Equal(t, "file.txt", sanitizeName("../../etc/file.txt"))
+	assert.Equal(t, "file.txt", sanitizeName(`C:\Users\me\file.txt`))
+	assert.Equal(t, "", sanitizeName(".."))
+	assert.Equal(t, "", sanitizeName("dir/"))
+}
+
+func TestGenerateToken(t *testing.T) {
+	a, err := generateToken()
+	require.NoError(t, err)
+	b, err := generateToken()
+	require.NoError(t, err)
+
+	assert.Len(t, a, 32)
+	assert.NotEqual(t, a, b)
+}
+
+func TestGenerateSlug(t *testing.T) {
+	seen := map[string]bool{}
+	for range 100 {
+		slug, err := generateSlug(7)
+		require.NoError(t, err)
+		assert.Len(t, slug, 7)
+		for _, r := range slug {
+			assert.Contains(t, slugAlphabet, string(r))
+		}
+		seen[slug] = true
+	}
+	assert.Len(t, seen, 100)
+}
+
+func TestLinkURLs(t *testing.T) {
+	defer Init(&config.Config{})
+
+	link := &model.ShareLink{Token: "tok"}
+	r := httptest.NewRequest(http.MethodGet, "/api/links", nil)
+	r.Host = "files.example.com"
+
+	Init(&config.Config{Web: config.WebConfig{BasePath: "/hub/"}})
+	assert.Equal(t, "http://files.example.com/hub/s/tok", URL(r, link))
+	assert.Empty(t, ShortURL(r, link))
+
+	link.Slug = "abc2345"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	assert.Equal(t, "https://files.example.com/hub/l/abc2345", ShortURL(r, link))
+
+	Init(&config.Config{Links: config.LinksConfig{PublicURL: "https://hub.example.com/", ShortURL: "https://fh.example/l/"}})
+	assert.Equal(t, "https://hub.example.com/s/tok", URL(r, link))
+	assert.Equal(t, "https://fh.example/l/abc2345", ShortURL(r, link))
+	assert.Equal(t, 7, settings.SlugLength)
+}
+
+func TestAuthorizeAndCheckAccess(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	link := &model.ShareLink{Token: "token-a", PasswordHash: string(hash)}
+	now := time.Now()
+
+	t.Run("Unprotected link needs no token", func(t *testing.T) {
+		_, err := CheckAccess(&model.ShareLink{Token: "open"}, "", now)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Wrong password", func(t *testing.T) {
+		_, _, err := Authorize(link, "guess", "", now)
+		assert.ErrorIs(t, err, ErrWrongPassword)
+	})
+
+	t.Run("Issued token grants access until expiry", func(t *testing.T) {
+		access, expires, err := Authorize(link, "secret", "", now)
+		require.NoError(t, err)
+		assert.Equal(t, now.Add(AccessTTL), expires)
+
+		_, err = CheckAccess(link, access, now)
+		assert.NoError(t, err)
+		_, err = CheckAccess(link, access, now.Add(AccessTTL+time.Second))
+		assert.ErrorIs(t, err, ErrPasswordRequired)
+	})
+
+	t.Run("Token is bound to its link", func(t *testing.T) {
+		access, _, err := Authorize(link, "secret", "", now)
+		require.NoError(t, err)
+
+		other := &model.ShareLink{Token: "token-b", PasswordHash: string(hash)}
+		_, err = CheckAccess(other, access, now)
+		assert.ErrorIs(t, err, ErrPasswordRequired)
+	})
+
+	t.Run("Malformed tokens", func(t *testing.T) {
+		for _, access := range []string{"", "abc", "123.", "x.deadbeef", "123.!!.deadbeef"} {
+			_, err := CheckAccess(link, access, now)
+			assert.ErrorIs(t, err, ErrPasswordRequired, access)
+		}
+	})
+}
+
+func TestLockoutSubjects(t *testing.T) {
+	old := lockoutPolicy
+	defer func() { lockoutPolicy = old }()
+	lockoutPolicy = config.LockoutConfig{MaxAddrFailures: 20, MaxLinkFailures: 10}
+
+	subjects := lockoutSubjects(&model.ShareLink{ID: 42}, "203.0.113.7")
+	assert.Equal(t, []lockoutSubject{
+		{model.LoginSubjectLink, "42", 10},
+		{model.LoginSubjectLinkAddr, "203.0.113.7", 20},
+	}, subjects)
+}
+
+func TestUnlockWithoutLockout(t *testing.T) {
+	old := lockoutPolicy
+	defer func() { lockoutPolicy = old }()
+	lockoutPolicy = config.LockoutConfig{}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	link := &model.ShareLink{ID: 1, Token: "token-a", PasswordHash: string(hash)}
+
+	_, _, err = Unlock(context.Background(), link, "guess", "", "203.0.113.7", time.Now())
+	assert.ErrorIs(t, err, ErrWrongPassword)
+	_, _, err = Unlock(context.Background(), link, "secret", "", "203.0.113.7", time.Now())
+	assert.NoError(t, err)
+}
+
+func TestViewLinkAccess(t *testing.T) {
+	link := &model.ShareLink{Token: "token-v", Mode: model.LinkModeView}
+	now := time.Now()
+
+	t.Run("Token required without password", func(t *testing.T) {
+		_, err := CheckAccess(link, "", now)
+		assert.ErrorIs(t, err, ErrPasswordRequired)
+	})
+
+	t.Run("Email required", func(t *testing.T) {
+		for _, email := range []string{"", "not an email"} {
+			_, _, err := Authorize(link, "", email, now)
+			assert.ErrorIs(t, err, ErrEmailRequired, email)
+		}
+	})
+
+	t.Run("Token carries the viewer", func(t *testing.T) {
+		access, _, err := Authorize(link, "", "Bob <bob@example.com>", now)
+		require.NoError(t, err)
+
+		viewer, err := CheckAccess(link, access, now)
+		require.NoError(t, err)
+		assert.Equal(t, "bob@example.com", viewer)
+	})
+
+	t.Run("Viewer cannot be swapped", func(t *testing.T) {
+		access, _, err := Authorize(link, "", "bob@example.com", now)
+		require.NoError(t, err)
+
+		parts := strings.Split(access, ".")
+		parts[1] = base64.RawURLEncoding.EncodeToString([]byte("eve@example.com"))
+		_, err = CheckAccess(link, strings.Join(parts, "."), now)
+		assert.ErrorIs(t, err, ErrPasswordRequired)
+	})
+}
+
+func TestResolve(t *testing.T) {
+	link := &model.ShareLink{Path: "/shared"}
+
+	assert.Equal(t, "/shared", Resolve(link, ""))
+	assert.Equal(t, "/shared", Resolve(link, "/"))
+	assert.Equal(t, "/shared/a/b.txt", Resolve(link, "a/b.txt"))
+	assert.Equal(t, "/shared/etc", Resolve(link, "../../etc"))
+
+	root := &model.ShareLink{Path: ""}
+	assert.Equal(t, "", Resolve(root, "/"))
+	assert.Equal(t, "/docs", Resolve(root, "docs"))
+}
+
+func TestParentOf(t *testing.T) {
+	assert.Equal(t, "", parentOf(""))
+	assert.Equal(t, "", parentOf("/docs"))
+	assert.Equal(t, "/docs", parentOf("/docs/a.txt"))
+	assert.Equal(t, "/docs/sub", parentOf("/docs/sub/b.txt"))
+}
+
+func TestSnapshotObject(t *testing.T) {
+	link := &model.ShareLink{RepoID: 3, OwnerID: 7}
+	modTime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	file := &model.SnapshotFile{Path: "/docs/a.txt", Parent: "/docs", StoredName: "/.snapshots/1-1/0", Size: 5, ModTime: modTime}
+
+	obj := SnapshotObject(link, file)
+	assert.Equal(t, "a.txt", obj.Name)
+	assert.Equal(t, "/docs/a.txt", obj.Path)
+	assert.Equal(t, 3, obj.RepoID)
+	assert.Equal(t, int64(5), obj.Size)
+	assert.Equal(t, modTime, obj.ModTime)
+
+	assert.Equal(t, "", SnapshotObject(link, &model.SnapshotFile{Path: "", IsDir: true}).Name)
+}
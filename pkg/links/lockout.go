@@ -0,0 +1,92 @@
+package links
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// ErrTooManyAttempts is returned when too many wrong passwords were given for a link, or from an address
+var ErrTooManyAttempts = errors.New("too many wrong passwords, try again later")
+
+// lockoutPolicy limits wrong passwords the way failed logins are, set by Init
+var lockoutPolicy config.LockoutConfig
+
+// lockoutSubject is a counter of wrong passwords, locked once threshold is reached
+type lockoutSubject struct {
+	kind, subject string
+	threshold     int
+}
+
+// lockoutSubjects returns the counters a password attempt on a link counts against
+func lockoutSubjects(link *model.ShareLink, remoteAddr string) []lockoutSubject {
+	return []lockoutSubject{
+		{model.LoginSubjectLink, strconv.Itoa(link.ID), lockoutPolicy.MaxLinkFailures},
+		{model.LoginSubjectLinkAddr, remoteAddr, lockoutPolicy.MaxAddrFailures},
+	}
+}
+
+// checkAttempts returns ErrTooManyAttempts if the link or the source address is locked
+func checkAttempts(ctx context.Context, link *model.ShareLink, remoteAddr string) error {
+	now := time.Now()
+	for _, s := range lockoutSubjects(link, remoteAddr) {
+		if s.threshold <= 0 || s.subject == "" {
+			continue
+		}
+
+		failure, err := db.GetLoginFailure(ctx, s.kind, s.subject)
+		if err != nil {
+			log.Printf("Failed to check lockout for %s %s: %s", s.kind, s.subject, err)
+			continue
+		}
+		if failure != nil && failure.IsLocked(now) {
+			return ErrTooManyAttempts
+		}
+	}
+	return nil
+}
+
+// recordWrongPassword counts a wrong password against the link and the source address, locking either
+// once its threshold is reached
+func recordWrongPassword(ctx context.Context, link *model.ShareLink, remoteAddr string) {
+	for _, s := range lockoutSubjects(link, remoteAddr) {
+		if s.threshold <= 0 || s.subject == "" {
+			continue
+		}
+
+		_, err := db.RecordLoginFailure(ctx, s.kind, s.subject, lockoutPolicy.Window, s.threshold, lockoutPolicy.CoolDown)
+		if err != nil {
+			log.Printf("Failed to record wrong password for %s %s: %s", s.kind, s.subject, err)
+		}
+	}
+}
+
+// Unlock verifies the password of a protected link from a source address and issues an access token like
+// Authorize, refusing with ErrTooManyAttempts while the link or the address is locked out. A right password
+// clears the counter of the link; that of the address is kept so that one known link cannot mask guessing
+// against others.
+func Unlock(ctx context.Context, link *model.ShareLink, password, email, remoteAddr string, now time.Time) (string, time.Time, error) {
+	if !link.IsProtected() {
+		return Authorize(link, password, email, now)
+	}
+
+	if err := checkAttempts(ctx, link, remoteAddr); err != nil {
+		return "", time.Time{}, err
+	}
+
+	access, expires, err := Authorize(link, password, email, now)
+	if errors.Is(err, ErrWrongPassword) {
+		recordWrongPassword(ctx, link, remoteAddr)
+	} else if err == nil && lockoutPolicy.MaxLinkFailures > 0 {
+		if err := db.ClearLoginFailure(ctx, model.LoginSubjectLink, strconv.Itoa(link.ID)); err != nil {
+			log.Printf("Failed to reset wrong passwords for link %d: %s", link.ID, err)
+		}
+	}
+	return access, expires, err
+}
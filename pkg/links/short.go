@@ -0,0 +1,136 @@
+package links
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// slugAlphabet holds the characters of short link slugs, leaving out those easily mistaken for
+// one another such as 0 and O or 1 and l
+const slugAlphabet = "23456789abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// maxSlugAttempts is how many slugs are tried before giving up; every few collisions the slugs get longer
+const maxSlugAttempts = 10
+
+// ErrNoSlug is returned when no free slug was found for a short link
+var ErrNoSlug = errors.New("no free short link slug found")
+
+var (
+	// settings holds the URLs links are given out with, set by Init
+	settings = config.LinksConfig{SlugLength: 7}
+	// basePath is the prefix a reverse proxy serves the application under, "" if none
+	basePath = ""
+)
+
+// Init sets the URLs links are given out with
+func Init(cfg *config.Config) {
+	settings = cfg.Links
+	if settings.SlugLength <= 0 {
+		settings.SlugLength = 7
+	}
+	basePath = strings.TrimSuffix(path.Join("/", cfg.Web.BasePath), "/")
+	lockoutPolicy = cfg.Security.Lockout
+}
+
+// generateSlug returns a random slug of n characters
+func generateSlug(n int) (string, error) {
+	// Bytes past the last whole multiple of the alphabet are dropped, so that all characters are as likely
+	limit := 256 - 256%len(slugAlphabet)
+	slug := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(slug) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(slug) < n {
+				slug = append(slug, slugAlphabet[int(b)%len(slugAlphabet)])
+			}
+		}
+	}
+	return string(slug), nil
+}
+
+// Shorten gives a link of the user a short slug, unless it has one already
+func Shorten(ctx context.Context, user *model.User, id int) (*model.ShareLink, error) {
+	link, err := GetOwned(ctx, user, id)
+	if err != nil {
+		return nil, err
+	}
+	if link.Slug != "" {
+		return link, nil
+	}
+
+	if err := assignSlug(ctx, link); err != nil {
+		return nil, err
+	}
+	// Reloaded, as a concurrent request may have given the link a slug first
+	return db.GetShareLink(ctx, user.ID, id)
+}
+
+// assignSlug stores a new slug for a link without one, trying longer ones after collisions
+func assignSlug(ctx context.Context, link *model.ShareLink) error {
+	for attempt := range maxSlugAttempts {
+		slug, err := generateSlug(settings.SlugLength + attempt/3)
+		if err != nil {
+			return err
+		}
+
+		ok, err := db.SetShareLinkSlug(ctx, link.ID, slug)
+		if err != nil {
+			return err
+		}
+		if ok {
+			link.Slug = slug
+			return nil
+		}
+	}
+	return ErrNoSlug
+}
+
+// GetBySlug returns the link with the short slug, if still valid
+func GetBySlug(ctx context.Context, slug string) (*model.ShareLink, error) {
+	link, err := db.GetShareLinkBySlug(ctx, slug)
+	if err != nil || link.IsExpired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return link, nil
+}
+
+// URL returns the absolute URL of a link, under the configured public URL or else
+// the address the request was sent to
+func URL(r *http.Request, link *model.ShareLink) string {
+	return origin(r) + "/s/" + link.Token
+}
+
+// ShortURL returns the absolute short URL of a link, "" if it has no slug
+func ShortURL(r *http.Request, link *model.ShareLink) string {
+	if link.Slug == "" {
+		return ""
+	}
+	if settings.ShortURL != "" {
+		return strings.TrimSuffix(settings.ShortURL, "/") + "/" + link.Slug
+	}
+	return origin(r) + "/l/" + link.Slug
+}
+
+func origin(r *http.Request) string {
+	if settings.PublicURL != "" {
+		return strings.TrimSuffix(settings.PublicURL, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + basePath
+}
@@ -0,0 +1,172 @@
+package links
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/stor"
+)
+
+// MaxSnapshotFiles is the most files and folders a snapshot link can share, as publishing copies all of them
+const MaxSnapshotFiles = 10000
+
+// ErrSnapshotGone is returned for a snapshot link without published content, such as one published
+// before the content was kept; it is available again once re-published.
+var ErrSnapshotGone = errors.New("snapshot content not available, the link must be re-published")
+
+// parentOf returns the path of the folder holding a repository path, in the stored form
+func parentOf(p string) string {
+	dir := path.Dir(p)
+	if dir == "/" || dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// publish copies the shared item of a snapshot link with everything below it to the repository's snapshot
+// folder and pins the link to the copy, replacing the content published before
+func publish(ctx context.Context, repo *model.Repository, link *model.ShareLink, now time.Time) error {
+	version, err := db.GetCurrentVersion(ctx, repo.ID)
+	if err != nil {
+		return err
+	}
+
+	root, err := db.GetFile(ctx, repo.ID, link.Path)
+	if err != nil {
+		return fmt.Errorf("%w: %s not found", ErrInvalid, link.Path)
+	}
+
+	// Each publication gets a folder of its own, so the previous one is served until this one is complete
+	folder := path.Join(stor.SnapshotDir, fmt.Sprintf("%d-%d", link.ID, now.UnixNano()))
+	var files []*model.SnapshotFile
+	err = stor.WalkTree(ctx, root, false, func(file *model.FileObject) error {
+		if len(files) >= MaxSnapshotFiles {
+			return fmt.Errorf("%w: snapshot links share at most %d files", ErrInvalid, MaxSnapshotFiles)
+		}
+
+		entry := &model.SnapshotFile{
+			Path:     file.Path,
+			Parent:   parentOf(file.Path),
+			IsDir:    file.IsDir,
+			Size:     file.Size,
+			MimeType: file.MimeType,
+			ModTime:  file.ModTime,
+		}
+		if !file.IsDir {
+			entry.StoredName = path.Join(folder, strconv.Itoa(len(files)))
+			if err := stor.CopyToSnapshot(ctx, repo, file.Path, entry.StoredName); err != nil {
+				return err
+			}
+		}
+		files = append(files, entry)
+		return nil
+	})
+	if err != nil {
+		stor.DeleteSnapshotFiles(ctx, repo, files)
+		return err
+	}
+
+	link.Version = version.CurrentVersion
+	link.PublishedAt = &now
+	previous, err := db.PublishShareLink(ctx, link, files)
+	if err != nil {
+		stor.DeleteSnapshotFiles(ctx, repo, files)
+		return err
+	}
+	stor.DeleteSnapshotFiles(ctx, repo, previous)
+	return nil
+}
+
+// removeSnapshot removes the stored content of a snapshot link once the link is gone
+func removeSnapshot(ctx context.Context, repoID int, files []*model.SnapshotFile) {
+	if len(files) == 0 {
+		return
+	}
+
+	repo, err := db.GetRepositoryByID(ctx, repoID)
+	if err != nil {
+		log.Printf("Failed to remove snapshot files of repository %d: %s", repoID, err)
+		return
+	}
+	stor.DeleteSnapshotFiles(ctx, repo, files)
+}
+
+// Publish moves a snapshot link of the user to the current content of its folder
+func Publish(ctx context.Context, user *model.User, id int) (*model.ShareLink, error) {
+	link, err := GetOwned(ctx, user, id)
+	if err != nil {
+		return nil, err
+	}
+	if link.Mode != model.LinkModeSnapshot {
+		return nil, fmt.Errorf("%w: only snapshot links can be published", ErrInvalid)
+	}
+
+	repo, err := db.GetRepositoryByID(ctx, link.RepoID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	res := &model.Resource{Repo: repo, Path: link.Path}
+	if err := perm.Evaluate(ctx, perm.User(user.ID), res, perm.Read); err != nil {
+		return nil, ErrForbidden
+	}
+
+	if err := publish(ctx, repo, link, time.Now()); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// GetSnapshotFile returns the file or folder a snapshot link shared under a path when it was published.
+// Files are read from the resource of their StoredName.
+func GetSnapshotFile(ctx context.Context, link *model.ShareLink, pub string) (*model.SnapshotFile, error) {
+	if link.PublishedAt == nil {
+		return nil, ErrSnapshotGone
+	}
+
+	file, err := db.GetSnapshotFile(ctx, link.ID, pub)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return file, nil
+}
+
+// ListSnapshotDir returns the files and folders a folder of a snapshot link held when it was published
+func ListSnapshotDir(ctx context.Context, link *model.ShareLink, dir string) ([]*model.FileObject, error) {
+	children, err := db.ListSnapshotChildren(ctx, link.ID, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*model.FileObject, len(children))
+	for i, child := range children {
+		items[i] = SnapshotObject(link, child)
+	}
+	return items, nil
+}
+
+// SnapshotObject returns the entry of a file of a snapshot link as it was published, for listings
+func SnapshotObject(link *model.ShareLink, file *model.SnapshotFile) *model.FileObject {
+	name := path.Base(file.Path)
+	if file.Path == "" {
+		name = ""
+	}
+
+	return &model.FileObject{
+		RepoID:   link.RepoID,
+		OwnerID:  link.OwnerID,
+		Name:     name,
+		Path:     file.Path,
+		MimeType: file.MimeType,
+		Size:     file.Size,
+		ModTime:  file.ModTime,
+		IsDir:    file.IsDir,
+	}
+}
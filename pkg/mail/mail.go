@@ -0,0 +1,89 @@
+// Package mail sends plain text email through the configured SMTP server.
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+)
+
+// ErrDisabled is returned sending mail without a configured SMTP server
+var ErrDisabled = errors.New("mail is not configured")
+
+// settings holds the SMTP server, set by Init
+var settings config.MailConfig
+
+// Init sets the SMTP server mail is sent through
+func Init(cfg *config.Config) {
+	settings = cfg.Mail
+}
+
+// Enabled reports whether an SMTP server is configured
+func Enabled() bool {
+	return settings.Host != ""
+}
+
+// Send mails a plain text message to a single recipient. The connection is upgraded with STARTTLS
+// when the server offers it, and credentials are only sent over an encrypted connection.
+func Send(to, subject, body string) error {
+	if !Enabled() {
+		return ErrDisabled
+	}
+
+	from, err := netmail.ParseAddress(settings.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address %q: %w", settings.From, err)
+	}
+	rcpt, err := netmail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address %q: %w", to, err)
+	}
+
+	msg, err := compose(from, rcpt, subject, body, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if settings.Username != "" {
+		auth = smtp.PlainAuth("", settings.Username, settings.Password, settings.Host)
+	}
+
+	addr := settings.Host + ":" + strconv.Itoa(settings.Port)
+	if err := smtp.SendMail(addr, auth, from.Address, []string{rcpt.Address}, msg); err != nil {
+		return fmt.Errorf("failed to send mail to %s: %w", rcpt.Address, err)
+	}
+	return nil
+}
+
+// compose builds a message with UTF-8 headers and a quoted-printable body
+func compose(from, to *netmail.Address, subject, body string, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+
+	w := quotedprintable.NewWriter(&buf)
+	body = strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")
+	if _, err := w.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
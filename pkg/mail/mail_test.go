@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompose(t *testing.T) {
+	from := &netmail.Address{Name: "File Hub", Address: "filehub@example.com"}
+	to := &netmail.Address{Address: "alice@example.com"}
+	date := time.Date(2024, 3, 4, 7, 0, 0, 0, time.UTC)
+
+	msg, err := compose(from, to, "Aktivität in photos", "Zeile eins\nZeile zwei", date)
+	require.NoError(t, err)
+
+	parsed, err := netmail.ReadMessage(strings.NewReader(string(msg)))
+	require.NoError(t, err)
+	assert.Equal(t, `"File Hub" <filehub@example.com>`, parsed.Header.Get("From"))
+	assert.Equal(t, "<alice@example.com>", parsed.Header.Get("To"))
+	assert.Equal(t, "Mon, 04 Mar 2024 07:00:00 +0000", parsed.Header.Get("Date"))
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Aktivität in photos", subject)
+
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	require.NoError(t, err)
+	assert.Equal(t, "Zeile eins\r\nZeile zwei", string(body))
+}
+
+func TestSendDisabled(t *testing.T) {
+	settings.Host = ""
+	assert.False(t, Enabled())
+	assert.ErrorIs(t, Send("alice@example.com", "subject", "body"), ErrDisabled)
+}
@@ -0,0 +1,110 @@
+// Package maint runs periodic maintenance: folder expiry rules, inbox organization, storage lifecycle
+// policies, trash purging, expired share removal, stale upload cleanup and activity digests.
+package maint
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/digest"
+	"github.com/cgang/file-hub/pkg/expiry"
+	"github.com/cgang/file-hub/pkg/lifecycle"
+	"github.com/cgang/file-hub/pkg/links"
+	"github.com/cgang/file-hub/pkg/organize"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/sync"
+)
+
+// Start runs the maintenance job at the configured interval until ctx is done.
+// A zero interval disables the job.
+func Start(ctx context.Context, cfg *config.Config) {
+	go migrate(ctx)
+
+	interval := cfg.Maintenance.Interval
+	if interval <= 0 {
+		log.Printf("Maintenance job disabled")
+		return
+	}
+
+	retention := cfg.Maintenance.TrashRetention
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				Run(ctx, now, retention)
+			}
+		}
+	}()
+}
+
+// Run performs one maintenance pass. Failed tasks are logged and retried on the next pass.
+func Run(ctx context.Context, now time.Time, trashRetention time.Duration) {
+	if n, err := expiry.Run(ctx, now); err != nil {
+		log.Printf("Failed to apply expiry rules: %s", err)
+	} else if n > 0 {
+		log.Printf("Moved %d expired files to trash", n)
+	}
+
+	if n, err := organize.Run(ctx, now); err != nil {
+		log.Printf("Failed to apply organize rules: %s", err)
+	} else if n > 0 {
+		log.Printf("Organized %d files", n)
+	}
+
+	if n, err := lifecycle.Run(ctx, now); err != nil {
+		log.Printf("Failed to apply lifecycle policies: %s", err)
+	} else if n > 0 {
+		log.Printf("Moved %d files to cold storage", n)
+	}
+
+	if trashRetention > 0 {
+		if n, err := stor.PurgeTrash(ctx, now.Add(-trashRetention)); err != nil {
+			log.Printf("Failed to purge trash: %s", err)
+		} else if n > 0 {
+			log.Printf("Purged %d files from trash", n)
+		}
+	}
+
+	if n, err := stor.PurgeExpiredShares(ctx, now); err != nil {
+		log.Printf("Failed to purge expired shares: %s", err)
+	} else if n > 0 {
+		log.Printf("Removed %d expired shares", n)
+	}
+
+	if n, err := links.PurgeExpired(ctx, now); err != nil {
+		log.Printf("Failed to purge expired share links: %s", err)
+	} else if n > 0 {
+		log.Printf("Removed %d expired share links", n)
+	}
+
+	if err := sync.CleanupExpiredUploads(ctx, now); err != nil {
+		log.Printf("Failed to clean up upload sessions: %s", err)
+	}
+
+	if _, err := db.PurgeRepositoryAliases(ctx, now); err != nil {
+		log.Printf("Failed to purge repository aliases: %s", err)
+	}
+
+	if n, err := digest.Run(ctx, now); err != nil {
+		log.Printf("Failed to send activity digests: %s", err)
+	} else if n > 0 {
+		log.Printf("Sent %d activity digests", n)
+	}
+}
+
+// migrate repairs rows written by older versions, once at startup
+func migrate(ctx context.Context) {
+	if n, err := db.BackfillParentIDs(ctx); err != nil {
+		log.Printf("Failed to backfill parent directories: %s", err)
+	} else if n > 0 {
+		log.Printf("Linked %d files to their parent directories", n)
+	}
+}
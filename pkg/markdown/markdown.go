@@ -0,0 +1,542 @@
+// Package markdown converts the common subset of Markdown found in README files to HTML:
+// headings, paragraphs, lists, block quotes, code, tables, rules, links, images and emphasis.
+// HTML in the source is escaped rather than passed through. Link URLs are not checked, so output
+// shown in a browser still goes through the sanitizer. Quotes, lists, links and emphasis nested more
+// than maxDepth deep are left as text, so that no input can exhaust the stack.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxDepth is how deep blocks, and separately inline elements, are nested at most
+const maxDepth = 32
+
+var (
+	headingRe   = regexp.MustCompile(`^(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	ruleRe      = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	bulletRe    = regexp.MustCompile(`^ {0,3}([-*+])[ \t]+`)
+	orderedRe   = regexp.MustCompile(`^ {0,3}(\d{1,9})[.)][ \t]+`)
+	fenceRe     = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})[ \t]*([^`\\s]*)")
+	quoteRe     = regexp.MustCompile(`^ {0,3}> ?`)
+	tableSepRe  = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	autolinkRe  = regexp.MustCompile(`^<((?:https?|mailto):[^<>\s]+)>`)
+	linkTitleRe = regexp.MustCompile(`^(\S+)(?:\s+"([^"]*)")?$`)
+)
+
+// ToHTML converts Markdown to HTML
+func ToHTML(src string) string {
+	src = strings.ReplaceAll(strings.ReplaceAll(src, "\r\n", "\n"), "\t", "    ")
+	var b strings.Builder
+	blocks(&b, strings.Split(src, "\n"), 0)
+	return b.String()
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// indent returns the number of leading spaces of a line
+func indent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// startsBlock reports whether a line interrupts a paragraph
+func startsBlock(line string) bool {
+	return headingRe.MatchString(strings.TrimLeft(line, " ")) && indent(line) < 4 ||
+		ruleRe.MatchString(line) || fenceRe.MatchString(line) || quoteRe.MatchString(line) ||
+		bulletRe.MatchString(line) || orderedRe.MatchString(line)
+}
+
+// blocks writes the HTML of a sequence of lines nested depth deep
+func blocks(b *strings.Builder, lines []string, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " ")
+
+		switch {
+		case isBlank(line):
+			i++
+
+		case indent(line) >= 4:
+			i = codeBlock(b, lines, i)
+
+		case fenceRe.MatchString(line):
+			i = fencedBlock(b, lines, i)
+
+		case headingRe.MatchString(trimmed):
+			m := headingRe.FindStringSubmatch(trimmed)
+			level := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + level + ">" + inline(m[2]) + "</h" + level + ">\n")
+			i++
+
+		case ruleRe.MatchString(line):
+			b.WriteString("<hr>\n")
+			i++
+
+		case depth < maxDepth && quoteRe.MatchString(line):
+			i = quoteBlock(b, lines, i, depth)
+
+		case depth < maxDepth && (bulletRe.MatchString(line) || orderedRe.MatchString(line)):
+			i = listBlock(b, lines, i, depth)
+
+		case i+1 < len(lines) && strings.Contains(line, "|") && tableSepRe.MatchString(lines[i+1]) &&
+			strings.Contains(lines[i+1], "-"):
+			i = tableBlock(b, lines, i)
+
+		default:
+			i = paragraph(b, lines, i)
+		}
+	}
+}
+
+func codeBlock(b *strings.Builder, lines []string, i int) int {
+	var code []string
+	for ; i < len(lines) && (indent(lines[i]) >= 4 || isBlank(lines[i])); i++ {
+		code = append(code, strings.TrimPrefix(lines[i], "    "))
+	}
+	for len(code) > 0 && isBlank(code[len(code)-1]) {
+		code = code[:len(code)-1]
+	}
+	b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "\n</code></pre>\n")
+	return i
+}
+
+func fencedBlock(b *strings.Builder, lines []string, i int) int {
+	m := fenceRe.FindStringSubmatch(lines[i])
+	fence, lang := m[1], m[2]
+	i++
+
+	var code []string
+	for ; i < len(lines); i++ {
+		if trimmed := strings.TrimSpace(lines[i]); strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			i++
+			break
+		}
+		code = append(code, lines[i])
+	}
+
+	b.WriteString("<pre><code")
+	if lang != "" {
+		b.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+	}
+	b.WriteString(">" + html.EscapeString(strings.Join(code, "\n")))
+	if len(code) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("</code></pre>\n")
+	return i
+}
+
+func quoteBlock(b *strings.Builder, lines []string, i, depth int) int {
+	var quoted []string
+	for ; i < len(lines) && !isBlank(lines[i]); i++ {
+		line := lines[i]
+		if loc := quoteRe.FindStringIndex(line); loc != nil {
+			line = line[loc[1]:]
+		} else if startsBlock(line) {
+			break
+		}
+		quoted = append(quoted, line) // lazy continuation of the quoted paragraph otherwise
+	}
+
+	b.WriteString("<blockquote>\n")
+	blocks(b, quoted, depth+1)
+	b.WriteString("</blockquote>\n")
+	return i
+}
+
+// listMarker returns the marker of a list item line, the offset of its content and whether it is ordered
+func listMarker(line string) (string, int, bool) {
+	if m := bulletRe.FindStringSubmatchIndex(line); m != nil {
+		return line[m[2]:m[3]], m[1], false
+	}
+	if m := orderedRe.FindStringSubmatchIndex(line); m != nil {
+		return line[m[2]:m[3]], m[1], true
+	}
+	return "", 0, false
+}
+
+func listBlock(b *strings.Builder, lines []string, i, depth int) int {
+	marker, _, ordered := listMarker(lines[i])
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+		if start, _ := strconv.Atoi(marker); start != 1 {
+			b.WriteString(`<ol start="` + strconv.Itoa(start) + `">` + "\n")
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+
+	var items [][]string
+	loose := false
+	for i < len(lines) {
+		m, offset, o := listMarker(lines[i])
+		if m == "" || o != ordered || (!ordered && m != marker) {
+			break
+		}
+
+		// The item holds its first line and the lines indented past its marker, or continuing its paragraph
+		item := []string{lines[i][offset:]}
+		i++
+		for i < len(lines) {
+			line := lines[i]
+			if isBlank(line) {
+				if i+1 < len(lines) && indent(lines[i+1]) >= offset && !isBlank(lines[i+1]) {
+					loose = true
+					item = append(item, "")
+					i++
+					continue
+				}
+				break
+			}
+			if indent(line) >= offset {
+				item = append(item, line[offset:])
+			} else if startsBlock(line) {
+				break
+			} else {
+				item = append(item, line)
+			}
+			i++
+		}
+
+		items = append(items, item)
+
+		// A blank line between items makes the list loose and does not end it
+		if i+1 < len(lines) && isBlank(lines[i]) {
+			if next, _, o := listMarker(lines[i+1]); next != "" && o == ordered && (ordered || next == marker) {
+				loose = true
+				i++
+			}
+		}
+	}
+
+	for _, item := range items {
+		b.WriteString("<li>")
+		listItem(b, item, loose, depth+1)
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// listItem writes the content of a list item. The text of tight items is not wrapped in a paragraph.
+func listItem(b *strings.Builder, item []string, loose bool, depth int) {
+	if loose {
+		b.WriteString("\n")
+		blocks(b, item, depth)
+		return
+	}
+
+	var text []string
+	for len(item) > 0 && !startsBlock(item[0]) && !isBlank(item[0]) {
+		text = append(text, strings.TrimSpace(item[0]))
+		item = item[1:]
+	}
+	b.WriteString(inline(strings.Join(text, "\n")))
+	if len(item) > 0 {
+		b.WriteString("\n")
+		blocks(b, item, depth)
+	}
+}
+
+// splitRow splits a table row into its cells
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '|':
+			cells = append(cells, strings.TrimSpace(line[start:i]))
+			start = i + 1
+		}
+	}
+	return append(cells, strings.TrimSpace(line[start:]))
+}
+
+func tableBlock(b *strings.Builder, lines []string, i int) int {
+	header := splitRow(lines[i])
+	aligns := make([]string, len(header))
+	for j, sep := range splitRow(lines[i+1]) {
+		if j >= len(aligns) {
+			break
+		}
+		switch left, right := strings.HasPrefix(sep, ":"), strings.HasSuffix(sep, ":"); {
+		case left && right:
+			aligns[j] = "center"
+		case right:
+			aligns[j] = "right"
+		case left:
+			aligns[j] = "left"
+		}
+	}
+
+	row := func(cells []string, tag string) {
+		b.WriteString("<tr>")
+		for j := range header {
+			b.WriteString("<" + tag)
+			if aligns[j] != "" {
+				b.WriteString(` style="text-align:` + aligns[j] + `"`)
+			}
+			b.WriteString(">")
+			if j < len(cells) {
+				b.WriteString(inline(strings.ReplaceAll(cells[j], `\|`, "|")))
+			}
+			b.WriteString("</" + tag + ">")
+		}
+		b.WriteString("</tr>\n")
+	}
+
+	b.WriteString("<table>\n<thead>\n")
+	row(header, "th")
+	b.WriteString("</thead>\n")
+
+	i += 2
+	if i < len(lines) && !isBlank(lines[i]) && strings.Contains(lines[i], "|") {
+		b.WriteString("<tbody>\n")
+		for ; i < len(lines) && !isBlank(lines[i]) && strings.Contains(lines[i], "|"); i++ {
+			row(splitRow(lines[i]), "td")
+		}
+		b.WriteString("</tbody>\n")
+	}
+	b.WriteString("</table>\n")
+	return i
+}
+
+func paragraph(b *strings.Builder, lines []string, i int) int {
+	text := []string{lines[i]}
+	for i++; i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]); i++ {
+		text = append(text, lines[i])
+	}
+
+	// A paragraph underlined with = or - is a heading
+	if last := strings.TrimSpace(text[len(text)-1]); len(text) > 1 && strings.Trim(last, "=") == "" {
+		b.WriteString("<h1>" + inline(strings.TrimSpace(strings.Join(text[:len(text)-1], "\n"))) + "</h1>\n")
+		return i
+	}
+	if i < len(lines) && !isBlank(lines[i]) && strings.Trim(strings.TrimSpace(lines[i]), "-") == "" {
+		b.WriteString("<h2>" + inline(strings.TrimSpace(strings.Join(text, "\n"))) + "</h2>\n")
+		return i + 1
+	}
+
+	for j := range text {
+		text[j] = strings.TrimLeft(text[j], " ")
+	}
+	b.WriteString("<p>" + inline(strings.Join(text, "\n")) + "</p>\n")
+	return i
+}
+
+// inline converts the inline elements of text to HTML
+func inline(text string) string {
+	return inlineAt(text, 0)
+}
+
+// inlineAt converts text nested depth deep in links and emphasis to HTML
+func inlineAt(text string, depth int) string {
+	var b strings.Builder
+	unclosed := make(map[string]bool) // delimiters with no closer left, not to look for again
+	for i := 0; i < len(text); {
+		c := text[i]
+		rest := text[i:]
+
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.ContainsRune("\\`*_{}[]()#+-.!|~<>\"'\n", rune(text[i+1])):
+			if text[i+1] == '\n' {
+				b.WriteString("<br>\n")
+			} else {
+				b.WriteString(html.EscapeString(text[i+1 : i+2]))
+			}
+			i += 2
+			continue
+
+		case c == '`':
+			if n, ok := codeSpan(&b, rest); ok {
+				i += n
+				continue
+			}
+
+		case c == '!' && strings.HasPrefix(rest, "!["):
+			if label, dest, title, n, ok := link(rest[1:]); ok {
+				b.WriteString(`<img src="` + html.EscapeString(dest) + `" alt="` + html.EscapeString(plain(label)) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				b.WriteString(">")
+				i += n + 1
+				continue
+			}
+
+		case c == '[' && depth < maxDepth:
+			if label, dest, title, n, ok := link(rest); ok {
+				b.WriteString(`<a href="` + html.EscapeString(dest) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				b.WriteString(">" + inlineAt(label, depth+1) + "</a>")
+				i += n
+				continue
+			}
+
+		case c == '<':
+			if m := autolinkRe.FindStringSubmatch(rest); m != nil {
+				url := html.EscapeString(m[1])
+				b.WriteString(`<a href="` + url + `">` + html.EscapeString(strings.TrimPrefix(m[1], "mailto:")) + "</a>")
+				i += len(m[0])
+				continue
+			}
+
+		case (c == '*' || c == '_' || c == '~') && depth < maxDepth:
+			if n, ok := emphasis(&b, text, i, depth, unclosed); ok {
+				i += n
+				continue
+			}
+
+		case c == '\n':
+			if strings.HasSuffix(b.String(), "  ") {
+				trimmed := strings.TrimRight(b.String(), " ")
+				b.Reset()
+				b.WriteString(trimmed + "<br>")
+			}
+		}
+
+		b.WriteString(html.EscapeString(text[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// codeSpan writes the code span starting text, returning its length
+func codeSpan(b *strings.Builder, text string) (int, bool) {
+	ticks := len(text) - len(strings.TrimLeft(text, "`"))
+	end := strings.Index(text[ticks:], text[:ticks])
+	for end >= 0 && ticks+end+ticks < len(text) && text[ticks+end+ticks] == '`' {
+		// a longer run of backticks does not close the span
+		next := strings.Index(text[ticks+end+ticks:], text[:ticks])
+		if next < 0 {
+			return 0, false
+		}
+		end += ticks + next
+	}
+	if end < 0 {
+		return 0, false
+	}
+
+	code := strings.ReplaceAll(text[ticks:ticks+end], "\n", " ")
+	if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+		code = code[1 : len(code)-1]
+	}
+	b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+	return ticks + end + ticks, true
+}
+
+// link parses a [label](destination "title") link starting text, returning its length
+func link(text string) (label, dest, title string, n int, ok bool) {
+	depth := 0
+	close := -1
+	for i := 0; i < len(text) && close < 0; i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				close = i
+			}
+		}
+	}
+	if close < 0 || close+1 >= len(text) || text[close+1] != '(' {
+		return "", "", "", 0, false
+	}
+
+	end := strings.IndexByte(text[close+2:], ')')
+	if end < 0 {
+		return "", "", "", 0, false
+	}
+	target := strings.TrimSpace(text[close+2 : close+2+end])
+	if strings.HasPrefix(target, "<") {
+		if gt := strings.IndexByte(target, '>'); gt > 0 {
+			target = target[1:gt] + target[gt+1:]
+		}
+	}
+	m := linkTitleRe.FindStringSubmatch(target)
+	if m == nil && target != "" {
+		return "", "", "", 0, false
+	}
+	if m != nil {
+		dest, title = m[1], m[2]
+	}
+	return text[1:close], dest, title, close + 2 + end + 1, true
+}
+
+// emphasis writes the emphasized span starting at text[i], returning its length. Delimiters found
+// not to be closed are added to unclosed, which keeps text full of openers from taking quadratic time.
+func emphasis(b *strings.Builder, text string, i, depth int, unclosed map[string]bool) (int, bool) {
+	c := text[i]
+	run := len(text[i:]) - len(strings.TrimLeft(text[i:], string(c)))
+	if c == '~' && run != 2 {
+		return 0, false
+	}
+	if c == '_' && i > 0 && isWordChar(text[i-1]) {
+		return 0, false // intraword underscores, as in snake_case names
+	}
+
+	n := min(run, 2)
+	delim := text[i : i+n]
+	start := i + n
+	if start >= len(text) || text[start] == ' ' || text[start] == '\n' || unclosed[delim] {
+		return 0, false
+	}
+
+	for j := start + 1; j+n <= len(text); j++ {
+		if text[j] == '\\' {
+			j++ // escaped characters don't close
+			continue
+		}
+		if text[j:j+n] != delim || text[j-1] == ' ' || text[j-1] == '\n' {
+			continue
+		}
+		if j+n < len(text) && text[j+n] == c && n == 1 {
+			j++ // part of a strong delimiter
+			continue
+		}
+		if c == '_' && j+n < len(text) && isWordChar(text[j+n]) {
+			continue
+		}
+
+		tag := "em"
+		switch {
+		case c == '~':
+			tag = "del"
+		case n == 2:
+			tag = "strong"
+		}
+		b.WriteString("<" + tag + ">" + inlineAt(text[start:j], depth+1) + "</" + tag + ">")
+		return j + n - i, true
+	}
+	unclosed[delim] = true
+	return 0, false
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// plain strips inline markup for use as image alternative text
+func plain(text string) string {
+	return strings.NewReplacer("*", "", "_", "", "`", "", "[", "", "]", "").Replace(text)
+}
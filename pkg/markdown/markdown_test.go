@@ -0,0 +1,60 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlocks(t *testing.T) {
+	src := "# Title #\n\nSome *text* and __more__.\nNext line  \nbroken\n\nSub\n---\n\n" +
+		"> quoted\n> text\n\n```go\nfunc <T>()\n```\n\n    indented\n\n***\n"
+
+	assert.Equal(t, "<h1>Title</h1>\n"+
+		"<p>Some <em>text</em> and <strong>more</strong>.\nNext line<br>\nbroken</p>\n"+
+		"<h2>Sub</h2>\n"+
+		"<blockquote>\n<p>quoted\ntext</p>\n</blockquote>\n"+
+		"<pre><code class=\"language-go\">func &lt;T&gt;()\n</code></pre>\n"+
+		"<pre><code>indented\n</code></pre>\n"+
+		"<hr>\n", ToHTML(src))
+}
+
+func TestLists(t *testing.T) {
+	assert.Equal(t, "<ul>\n<li>one\n<ul>\n<li>nested</li>\n</ul>\n</li>\n<li>two</li>\n</ul>\n"+
+		"<ol start=\"3\">\n<li>three</li>\n</ol>\n", ToHTML("- one\n  - nested\n- two\n\n3. three\n"))
+
+	assert.Equal(t, "<ul>\n<li>\n<p>a</p>\n</li>\n<li>\n<p>b</p>\n</li>\n</ul>\n", ToHTML("* a\n\n* b\n"))
+}
+
+func TestTable(t *testing.T) {
+	assert.Equal(t, "<table>\n<thead>\n<tr><th>Name</th><th style=\"text-align:right\">Size</th></tr>\n</thead>\n"+
+		"<tbody>\n<tr><td><code>a|b</code></td><td style=\"text-align:right\">1</td></tr>\n</tbody>\n</table>\n",
+		ToHTML("| Name | Size |\n|------|-----:|\n| `a\\|b` | 1 |\n"))
+}
+
+func TestInline(t *testing.T) {
+	assert.Equal(t, `<p><a href="docs/a.md" title="A">the <em>docs</em></a> <img src="logo.png" alt="logo"> `+
+		`<a href="https://example.com">https://example.com</a> <del>old</del> snake_case_name \*</p>`+"\n",
+		ToHTML(`[the *docs*](docs/a.md "A") ![logo](logo.png) <https://example.com> ~~old~~ snake_case_name \\\*`))
+}
+
+func TestEscapesHTML(t *testing.T) {
+	assert.Equal(t, "<p>&lt;script&gt;alert(1)&lt;/script&gt; a &amp; b</p>\n", ToHTML("<script>alert(1)</script> a & b"))
+}
+
+func TestDeepNesting(t *testing.T) {
+	// Nesting past maxDepth is left as text rather than recursed into
+	html := ToHTML(strings.Repeat(">", 1000000) + " x")
+	assert.Equal(t, maxDepth, strings.Count(html, "<blockquote>"))
+	assert.True(t, strings.HasSuffix(html, "&gt; x</p>\n"+strings.Repeat("</blockquote>\n", maxDepth)))
+
+	html = ToHTML(strings.Repeat("- ", 100000) + "x")
+	assert.Equal(t, maxDepth, strings.Count(html, "<ul>"))
+
+	html = ToHTML(strings.Repeat("[", 100000) + "x" + strings.Repeat("](u)", 100000))
+	assert.Equal(t, maxDepth, strings.Count(html, "<a "))
+
+	html = ToHTML(strings.Repeat("*a ", 100000) + strings.Repeat(" a*", 100000))
+	assert.LessOrEqual(t, strings.Count(html, "<em>"), maxDepth)
+}
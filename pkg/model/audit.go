@@ -0,0 +1,40 @@
+package model
+
+import "time"
+
+// Audit log actions
+const (
+	AuditLoginFailed        = "login_failed"
+	AuditAccountLocked      = "account_locked"
+	AuditAccountUnlocked    = "account_unlocked"
+	AuditAddressLocked      = "address_locked"
+	AuditAddressUnlocked    = "address_unlocked"
+	AuditAccessDenied       = "access_denied"
+	AuditNetworkUpdated     = "network_updated"
+	AuditFilesExpired       = "files_expired"
+	AuditFilesArchived      = "files_archived"
+	AuditFileFlagged        = "file_flagged"
+	AuditRepoTransferred    = "repo_transferred"
+	AuditUserRenamed        = "user_renamed"
+	AuditUserUpdated        = "user_updated"
+	AuditTOTPEnabled        = "totp_enabled"
+	AuditTOTPDisabled       = "totp_disabled"
+	AuditAppPasswordAdded   = "app_password_created"
+	AuditAppPasswordRevoked = "app_password_revoked"
+	AuditTemplateApplied    = "template_applied"
+	AuditLifecycleSet       = "lifecycle_set"
+	AuditRepoReindexed      = "repo_reindexed"
+)
+
+// AuditEntry records a security relevant event
+type AuditEntry struct {
+	ID          int64     `json:"id" bun:"id,pk,autoincrement"`
+	Action      string    `json:"action" bun:"action,notnull"`
+	UserID      *int      `json:"user_id,omitempty" bun:"user_id"`
+	Username    string    `json:"username,omitempty" bun:"username"`
+	ActorID     *int      `json:"actor_id,omitempty" bun:"actor_id"` // user performing the action, if different
+	RemoteAddr  string    `json:"remote_addr,omitempty" bun:"remote_addr"`
+	Detail      string    `json:"detail,omitempty" bun:"detail"`
+	OperationID string    `json:"operation_id,omitempty" bun:"operation_id,nullzero"` // request it was recorded in, shared with its changes
+	CreatedAt   time.Time `json:"created_at" bun:"created_at,notnull"`
+}
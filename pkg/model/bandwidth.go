@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// TransferUsage counts the bytes a user uploaded and downloaded in a calendar month
+type TransferUsage struct {
+	UserID          int       `json:"user_id" bun:"user_id,pk"`
+	Username        string    `json:"username,omitempty" bun:"username,scanonly"`
+	Month           string    `json:"month" bun:"month,pk"` // YYYY-MM, in UTC
+	UploadedBytes   int64     `json:"uploaded_bytes" bun:"uploaded_bytes,notnull"`
+	DownloadedBytes int64     `json:"downloaded_bytes" bun:"downloaded_bytes,notnull"`
+	UpdatedAt       time.Time `json:"updated_at" bun:"updated_at,notnull"`
+}
+
+// Total returns the bytes transferred either way, which monthly caps apply to
+func (u *TransferUsage) Total() int64 {
+	return u.UploadedBytes + u.DownloadedBytes
+}
+
+// TransferMonth returns the calendar month a transfer at t is counted in
+func TransferMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
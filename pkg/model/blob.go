@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// A Blob is content stored once, by its SHA-256, for all the files holding it in a dedup storage root
+type Blob struct {
+	Checksum  string    `json:"checksum" bun:"checksum,pk"` // hex SHA-256 of the content, naming the blob
+	Size      int64     `json:"size" bun:"size,notnull"`
+	Refs      int       `json:"refs" bun:"refs,notnull"` // stored paths holding the content, removed at 0
+	CreatedAt time.Time `json:"created_at" bun:"created_at,notnull"`
+}
@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// Digest schedules, empty for no digest
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// A Follow subscribes a user to the changes under a folder of a repository they own or that is shared
+// with them, summarized in their activity digest
+type Follow struct {
+	ID        int       `json:"id" bun:"id,pk,autoincrement"`
+	UserID    int       `json:"-" bun:"user_id,notnull"`
+	RepoID    int       `json:"repo_id" bun:"repo_id,notnull"`
+	Repo      string    `json:"repo" bun:"-"`
+	Path      string    `json:"path" bun:"path,notnull"` // folder followed, "" for the whole repository
+	CreatedAt time.Time `json:"created_at" bun:"created_at,notnull"`
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// An ExpiryRule deletes files under a folder once they have not been modified for MaxAgeDays.
+// Expired files are moved to the trash rather than removed outright.
+type ExpiryRule struct {
+	ID         int       `json:"id" bun:"id,pk,autoincrement"`
+	RepoID     int       `json:"repo_id" bun:"repo_id,notnull"`
+	Path       string    `json:"path" bun:"path,notnull"` // folder the rule applies to, "" for the whole repository
+	MaxAgeDays int       `json:"max_age_days" bun:"max_age_days,notnull"`
+	CreatedBy  int       `json:"created_by" bun:"created_by,notnull"`
+	CreatedAt  time.Time `json:"created_at" bun:"created_at,notnull"`
+}
+
+// Covers reports whether a path lies inside the rule's folder
+func (r *ExpiryRule) Covers(path string) bool {
+	return r.Path == "" || path == r.Path || strings.HasPrefix(path, r.Path+"/")
+}
+
+// ExpiresAt returns when a file modified at modTime expires under the rule
+func (r *ExpiryRule) ExpiresAt(modTime time.Time) time.Time {
+	return modTime.AddDate(0, 0, r.MaxAgeDays)
+}
+
+// Cutoff returns the modification time before which files have expired at now
+func (r *ExpiryRule) Cutoff(now time.Time) time.Time {
+	return now.AddDate(0, 0, -r.MaxAgeDays)
+}
+
+// EarliestExpiry returns when a file expires under the most aggressive of the rules covering it,
+// or nil when no rule covers it
+func EarliestExpiry(rules []*ExpiryRule, file *FileObject) *time.Time {
+	if file.IsDir {
+		return nil
+	}
+
+	var earliest *time.Time
+	for _, rule := range rules {
+		if !rule.Covers(file.Path) {
+			continue
+		}
+		if at := rule.ExpiresAt(file.ModTime); earliest == nil || at.Before(*earliest) {
+			earliest = &at
+		}
+	}
+	return earliest
+}
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// Federated share states
+const (
+	FederationPending  = "pending"  // offered, not yet accepted by the recipient
+	FederationAccepted = "accepted" // accepted, the recipient's server may access the folder
+)
+
+// A FederatedShare is a folder shared with a user of another file-hub server.
+// That server presents the secret to access the folder on behalf of its user.
+type FederatedShare struct {
+	ID        int       `json:"id" bun:"id,pk,autoincrement"`
+	RepoID    int       `json:"repo_id" bun:"repo_id,notnull"`
+	OwnerID   int       `json:"owner_id" bun:"owner_id,notnull"`
+	Path      string    `json:"path" bun:"path,notnull"`
+	ShareWith string    `json:"share_with" bun:"share_with,notnull"` // recipient as user@host
+	Server    string    `json:"server" bun:"server,notnull"`         // base URL of the recipient's server
+	Secret    string    `json:"-" bun:"secret,notnull"`
+	Status    string    `json:"status" bun:"status,notnull"`
+	CreatedAt time.Time `json:"created_at" bun:"created_at,notnull"`
+}
+
+// A RemoteShare is a folder of another file-hub server shared with a local user.
+// Once accepted it is mounted under /federated by its name.
+type RemoteShare struct {
+	ID        int       `json:"id" bun:"id,pk,autoincrement"`
+	UserID    int       `json:"user_id" bun:"user_id,notnull"`
+	Name      string    `json:"name" bun:"name,notnull"`   // unique among the user's remote shares
+	Owner     string    `json:"owner" bun:"owner,notnull"` // sharing user as user@host
+	Server    string    `json:"server" bun:"server,notnull"`
+	RemoteID  string    `json:"remote_id" bun:"remote_id,notnull"` // ID of the share on its server
+	Secret    string    `json:"-" bun:"secret,notnull"`
+	Status    string    `json:"status" bun:"status,notnull"`
+	CreatedAt time.Time `json:"created_at" bun:"created_at,notnull"`
+}
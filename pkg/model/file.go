@@ -1,46 +1,93 @@
 package model
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // A Repository represents a file repository owned by a user.
 // Each user may own multiple repositories.
 // A repository with user name as its name is considered the user's home repository.
 // The home repository is created upon user registration.
 type Repository struct {
-	ID        int       `json:"id" bun:"id,pk,autoincrement"`
-	OwnerID   int       `json:"owner_id" bun:"owner_id,notnull"`
-	Name      string    `json:"name" bun:"name,notnull"`
-	Root      string    `json:"root" bun:"root,notnull"`
-	CreatedAt time.Time `json:"created_at" bun:"created_at,notnull"`
-	UpdatedAt time.Time `json:"updated_at" bun:"updated_at,notnull"`
+	ID            int       `json:"id" bun:"id,pk,autoincrement"`
+	OwnerID       int       `json:"owner_id" bun:"owner_id,notnull"`
+	Name          string    `json:"name" bun:"name,notnull"`
+	Root          string    `json:"root" bun:"root,notnull"`
+	AllowNets     []string  `json:"allow_nets,omitempty" bun:"allow_nets,array"`              // CIDRs allowed to access, empty allows all
+	DenyNets      []string  `json:"deny_nets,omitempty" bun:"deny_nets,array"`                // CIDRs denied access
+	OCR           bool      `json:"ocr" bun:"ocr,notnull"`                                    // extract the text of images and PDFs for search
+	ColdRoot      string    `json:"cold_root,omitempty" bun:"cold_root,nullzero"`             // storage root files nobody opened for a while move to
+	ColdAfterDays int       `json:"cold_after_days,omitempty" bun:"cold_after_days,nullzero"` // days without access before files move to ColdRoot
+	CreatedAt     time.Time `json:"created_at" bun:"created_at,notnull"`
+	UpdatedAt     time.Time `json:"updated_at" bun:"updated_at,notnull"`
+}
+
+// A RepositoryAlias is a former name of a renamed repository, which keeps resolving to it until it expires
+type RepositoryAlias struct {
+	Name      string    `json:"name" bun:"name,pk"`
+	RepoID    int       `json:"repo_id" bun:"repo_id,notnull"`
+	ExpiresAt time.Time `json:"expires_at" bun:"expires_at,notnull"`
 }
 
 // A Share represents a shared access to a repository for a specific user.
 // It contains the necessary information to identify the share and the associated user.
 type Share struct {
-	ID      int    `json:"id" bun:"id,pk,autoincrement"`
-	RepoID  int    `json:"repo_id" bun:"repo_id,notnull"`
-	OwnerID int    `json:"owner_id" bun:"owner_id,notnull"`
-	UserID  int    `json:"user_id" bun:"user_id,notnull"`
-	Path    string `json:"path" bun:"path,notnull"`
+	ID       int    `json:"id" bun:"id,pk,autoincrement"`
+	RepoID   int    `json:"repo_id" bun:"repo_id,notnull"`
+	OwnerID  int    `json:"owner_id" bun:"owner_id,notnull"`
+	UserID   int    `json:"user_id" bun:"user_id,notnull"`
+	Path     string `json:"path" bun:"path,notnull"`
+	ViewOnly bool   `json:"view_only" bun:"view_only,notnull"` // only watermarked views, no original content
+	Writable bool   `json:"writable" bun:"writable,notnull"`   // files below may be created, changed and removed too
+	// ExpiresAt is when the share stops granting access, nil for never
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bun:"expires_at"`
+}
+
+// IsExpired reports whether the share has expired at the given time
+func (s *Share) IsExpired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
 }
 
 // FileObject represents a file stored in a repository.
 // It contains metadata about the file such as its path, size, and MIME type.
 type FileObject struct {
-	ID        int       `json:"id" bun:"id,pk,autoincrement"`
-	ParentID  int       `json:"parent_id,omitempty" bun:"parent_id"`
-	OwnerID   int       `json:"owner_id" bun:"owner_id,notnull"`
-	RepoID    int       `json:"repo_id" bun:"repo_id,notnull"`
-	Name      string    `json:"name" bun:"name,notnull"`
-	Path      string    `json:"path" bun:"path,notnull"`
-	MimeType  *string   `json:"mime_type,omitempty" bun:"mime_type"`
-	Size      int64     `json:"size" bun:"size,notnull"`
-	ModTime   time.Time `json:"mod_time" bun:"mod_time"`
-	Checksum  *string   `json:"checksum,omitempty" bun:"checksum"`
-	CreatedAt time.Time `json:"created_at" bun:"created_at,notnull"`
-	UpdatedAt time.Time `json:"updated_at" bun:"updated_at,notnull"`
-	IsDir     bool      `json:"is_dir" bun:"is_dir"`
+	ID           int        `json:"id" bun:"id,pk,autoincrement"`
+	ParentID     int        `json:"parent_id,omitempty" bun:"parent_id"`
+	OwnerID      int        `json:"owner_id" bun:"owner_id,notnull"`
+	RepoID       int        `json:"repo_id" bun:"repo_id,notnull"`
+	Name         string     `json:"name" bun:"name,notnull"`
+	Path         string     `json:"path" bun:"path,notnull"`
+	MimeType     *string    `json:"mime_type,omitempty" bun:"mime_type"`
+	Size         int64      `json:"size" bun:"size,notnull"`
+	ModTime      time.Time  `json:"mod_time" bun:"mod_time"`
+	Checksum     *string    `json:"checksum,omitempty" bun:"checksum"`
+	CreatedAt    time.Time  `json:"created_at" bun:"created_at,notnull"`
+	UpdatedAt    time.Time  `json:"updated_at" bun:"updated_at,notnull"`
+	IsDir        bool       `json:"is_dir" bun:"is_dir"`
+	Flags        []string   `json:"flags,omitempty" bun:"flags,array"` // sensitive content found by the classifier
+	ClassifiedAt *time.Time `json:"-" bun:"classified_at"`
+	Immutable    bool       `json:"immutable,omitempty" bun:"immutable,notnull"` // pinned: not overwritten, moved or deleted until cleared
+	ColdRoot     string     `json:"-" bun:"cold_root,nullzero"`                  // storage root holding the content once moved to cold storage
+	AccessedAt   *time.Time `json:"-" bun:"accessed_at"`                         // when the content was last opened, to the day
+	ExpiresAt    *time.Time `json:"expires_at,omitempty" bun:"-"`                // set by listings when an expiry rule covers the file
+}
+
+// LastAccess returns when the content of the file was last opened, or written if later
+func (o *FileObject) LastAccess() time.Time {
+	if o.AccessedAt != nil && o.AccessedAt.After(o.UpdatedAt) {
+		return *o.AccessedAt
+	}
+	return o.UpdatedAt
+}
+
+// ETag returns the entity tag of the content of a file: its checksum once known, which stays the same for
+// as long as the content does, otherwise one made of its modification time and size
+func (o *FileObject) ETag() string {
+	if o.Checksum != nil && *o.Checksum != "" {
+		return `"` + *o.Checksum + `"`
+	}
+	return fmt.Sprintf(`"%x-%x"`, o.ModTime.Unix(), o.Size)
 }
 
 func (o *FileObject) ContentType() string {
@@ -54,3 +101,27 @@ func (o *FileObject) ContentType() string {
 		return "application/octet-stream"
 	}
 }
+
+// Sort orders of directory listings
+const (
+	SortName    = "name"     // by name, in the collation of the database
+	SortNatural = "natural"  // by name, numbers by their value: file2 before file10
+	SortModTime = "mod_time" // oldest first
+	SortSize    = "size"     // smallest first
+)
+
+// ListOrder is the order directory entries are listed in. Entries sorting equal are ordered by name.
+// The zero value lists by name.
+type ListOrder struct {
+	Sort      string
+	DirsFirst bool // directories before files
+}
+
+// ValidSort reports whether sort names a sort order
+func ValidSort(sort string) bool {
+	switch sort {
+	case SortName, SortNatural, SortModTime, SortSize:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package model
+
+import "time"
+
+// Share link modes
+const (
+	LinkModeUpload   = "upload"   // file drop: upload only, no listing or download
+	LinkModeRead     = "read"     // browse and download
+	LinkModeView     = "view"     // browse and watermarked views, no download
+	LinkModeSnapshot = "snapshot" // browse and download the shared item as it was when published
+)
+
+// A ShareLink grants access to a repository path to anyone holding its token.
+type ShareLink struct {
+	ID           int        `json:"id" bun:"id,pk,autoincrement"`
+	Token        string     `json:"token" bun:"token,unique,notnull"`
+	RepoID       int        `json:"repo_id" bun:"repo_id,notnull"`
+	OwnerID      int        `json:"owner_id" bun:"owner_id,notnull"`
+	Path         string     `json:"path" bun:"path,notnull"`
+	Mode         string     `json:"mode" bun:"mode,notnull"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty" bun:"expires_at"`
+	MaxBytes     *int64     `json:"max_bytes,omitempty" bun:"max_bytes"` // total upload limit, nil for unlimited
+	UsedBytes    int64      `json:"used_bytes" bun:"used_bytes,notnull"`
+	AllowedTypes []string   `json:"allowed_types,omitempty" bun:"allowed_types,array"` // MIME types or extensions, empty allows all
+	PasswordHash string     `json:"-" bun:"password_hash,nullzero"`                    // bcrypt hash, empty when not protected
+	Version      string     `json:"version,omitempty" bun:"version,nullzero"`          // repository version a snapshot is pinned to
+	PublishedAt  *time.Time `json:"published_at,omitempty" bun:"published_at"`         // when a snapshot was last published
+	Slug         string     `json:"slug,omitempty" bun:"slug,nullzero"`                // short link redirecting to the link, empty without one
+	CreatedAt    time.Time  `json:"created_at" bun:"created_at,notnull"`
+}
+
+// A SnapshotFile is a file or folder shared by a snapshot link as it was when the link was published.
+// The content of files is copied to the repository's snapshot folder, so later changes do not reach it.
+type SnapshotFile struct {
+	ID         int64     `json:"-" bun:"id,pk,autoincrement"`
+	LinkID     int       `json:"-" bun:"link_id,notnull"`
+	Path       string    `json:"path" bun:"path,notnull"`      // path in the repository when published
+	Parent     string    `json:"-" bun:"parent,notnull"`       // path of the folder holding it when published
+	StoredName string    `json:"-" bun:"stored_name,nullzero"` // storage name inside the snapshot folder, empty for folders
+	IsDir      bool      `json:"is_dir" bun:"is_dir,notnull"`
+	Size       int64     `json:"size" bun:"size,notnull"`
+	MimeType   *string   `json:"mime_type,omitempty" bun:"mime_type"`
+	ModTime    time.Time `json:"mod_time" bun:"mod_time,notnull"`
+}
+
+// IsExpired reports whether the link has expired at the given time
+func (l *ShareLink) IsExpired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+// IsProtected reports whether the link requires a password
+func (l *ShareLink) IsProtected() bool {
+	return l.PasswordHash != ""
+}
+
+// RemainingBytes returns how many bytes can still be uploaded, or -1 if unlimited
+func (l *ShareLink) RemainingBytes() int64 {
+	if l.MaxBytes == nil {
+		return -1
+	}
+	return max(*l.MaxBytes-l.UsedBytes, 0)
+}
@@ -180,6 +180,17 @@ func TestRepositoryModel(t *testing.T) {
 }
 
 func TestShareModel(t *testing.T) {
+	t.Run("IsExpired", func(t *testing.T) {
+		now := time.Now()
+		past := now.Add(-time.Minute)
+		future := now.Add(time.Minute)
+
+		assert.False(t, (&Share{}).IsExpired(now))
+		assert.True(t, (&Share{ExpiresAt: &past}).IsExpired(now))
+		assert.True(t, (&Share{ExpiresAt: &now}).IsExpired(now))
+		assert.False(t, (&Share{ExpiresAt: &future}).IsExpired(now))
+	})
+
 	t.Run("Share JSON serialization", func(t *testing.T) {
 		share := &Share{
 			ID:      1,
@@ -396,6 +407,14 @@ func TestResourceModel(t *testing.T) {
 	})
 }
 
+func TestCleanPath(t *testing.T) {
+	assert.Equal(t, "", CleanPath(""))
+	assert.Equal(t, "", CleanPath("/"))
+	assert.Equal(t, "/inbox", CleanPath("inbox/"))
+	assert.Equal(t, "/inbox", CleanPath("/../inbox"))
+	assert.Equal(t, "/docs/a.txt", CleanPath("/docs/../docs/a.txt"))
+}
+
 func TestChangeLogModel(t *testing.T) {
 	t.Run("ChangeLog JSON serialization", func(t *testing.T) {
 		now := time.Now()
@@ -581,6 +600,30 @@ func TestUploadSessionModel(t *testing.T) {
 		assert.True(t, now.After(session.ExpiresAt))
 		assert.True(t, session.ExpiresAt.Before(now))
 	})
+
+	t.Run("UploadSession chunk length", func(t *testing.T) {
+		session := &UploadSession{TotalSize: 2500, ChunkSize: 1000, TotalChunks: 3}
+
+		assert.Equal(t, int64(1000), session.ChunkLength(0))
+		assert.Equal(t, int64(500), session.ChunkLength(2))
+		assert.Equal(t, int64(-1), session.ChunkLength(3))
+		assert.Equal(t, int64(-1), session.ChunkLength(-1))
+	})
+
+	t.Run("UploadSession deadline", func(t *testing.T) {
+		now := time.Now()
+		session := &UploadSession{
+			ExpiresAt:      now.Add(24 * time.Hour),
+			LastActivityAt: now,
+		}
+
+		assert.Equal(t, now.Add(time.Hour), session.Deadline(time.Hour))
+		assert.Equal(t, session.ExpiresAt, session.Deadline(48*time.Hour))
+		assert.Equal(t, session.ExpiresAt, session.Deadline(0))
+
+		session.Status = UploadFailed
+		assert.Equal(t, session.ExpiresAt, session.Deadline(time.Hour))
+	})
 }
 
 func TestUploadChunkModel(t *testing.T) {
@@ -1037,3 +1080,167 @@ func stringRepeat(s string, count int) string {
 	}
 	return result
 }
+
+func TestShareLinkModel(t *testing.T) {
+	t.Run("IsExpired", func(t *testing.T) {
+		now := time.Now()
+		past := now.Add(-time.Minute)
+		future := now.Add(time.Minute)
+
+		assert.False(t, (&ShareLink{}).IsExpired(now))
+		assert.True(t, (&ShareLink{ExpiresAt: &past}).IsExpired(now))
+		assert.True(t, (&ShareLink{ExpiresAt: &now}).IsExpired(now))
+		assert.False(t, (&ShareLink{ExpiresAt: &future}).IsExpired(now))
+	})
+
+	t.Run("RemainingBytes", func(t *testing.T) {
+		limit := int64(100)
+
+		assert.Equal(t, int64(-1), (&ShareLink{UsedBytes: 50}).RemainingBytes())
+		assert.Equal(t, int64(60), (&ShareLink{MaxBytes: &limit, UsedBytes: 40}).RemainingBytes())
+		assert.Equal(t, int64(0), (&ShareLink{MaxBytes: &limit, UsedBytes: 120}).RemainingBytes())
+	})
+}
+
+func TestExpiryRuleModel(t *testing.T) {
+	t.Run("Covers", func(t *testing.T) {
+		rule := &ExpiryRule{Path: "/tmp-shared"}
+
+		assert.True(t, rule.Covers("/tmp-shared"))
+		assert.True(t, rule.Covers("/tmp-shared/a/b.txt"))
+		assert.False(t, rule.Covers("/tmp-shared-old/b.txt"))
+		assert.False(t, rule.Covers("/docs/b.txt"))
+		assert.True(t, (&ExpiryRule{Path: ""}).Covers("/docs/b.txt"))
+	})
+
+	t.Run("EarliestExpiry", func(t *testing.T) {
+		modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		rules := []*ExpiryRule{
+			{Path: "", MaxAgeDays: 90},
+			{Path: "/tmp-shared", MaxAgeDays: 30},
+		}
+
+		file := &FileObject{Path: "/tmp-shared/a.txt", ModTime: modTime}
+		assert.Equal(t, modTime.AddDate(0, 0, 30), *EarliestExpiry(rules, file))
+
+		other := &FileObject{Path: "/docs/a.txt", ModTime: modTime}
+		assert.Equal(t, modTime.AddDate(0, 0, 90), *EarliestExpiry(rules, other))
+
+		assert.Nil(t, EarliestExpiry(rules[1:], other))
+		assert.Nil(t, EarliestExpiry(rules, &FileObject{Path: "/tmp-shared/dir", IsDir: true}))
+	})
+
+	t.Run("Cutoff", func(t *testing.T) {
+		now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+		rule := &ExpiryRule{MaxAgeDays: 30}
+
+		assert.Equal(t, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), rule.Cutoff(now))
+		assert.Equal(t, now, rule.ExpiresAt(rule.Cutoff(now)))
+	})
+}
+
+func TestWebhookModel(t *testing.T) {
+	t.Run("MatchGlob", func(t *testing.T) {
+		assert.True(t, MatchGlob("/src/*.go", "/src/main.go"))
+		assert.False(t, MatchGlob("/src/*.go", "/src/pkg/main.go"))
+		assert.True(t, MatchGlob("/src/**", "/src/pkg/main.go"))
+		assert.True(t, MatchGlob("/src/**", "/src"))
+		assert.True(t, MatchGlob("**/*.md", "/docs/guide/README.md"))
+		assert.True(t, MatchGlob("/docs/**/*.md", "/docs/README.md"))
+		assert.False(t, MatchGlob("/docs/**/*.md", "/src/README.md"))
+	})
+
+	t.Run("Matches", func(t *testing.T) {
+		hook := &Webhook{Paths: []string{"/src/**"}, Operations: []string{"create", "move"}}
+		assert.True(t, hook.Matches("create", "/src/main.go"))
+		assert.False(t, hook.Matches("delete", "/src/main.go"))
+		assert.False(t, hook.Matches("create", "/docs/a.md"))
+		assert.True(t, hook.Matches("move", "/src/a.go", "/archive/a.go"), "either path of a move matches")
+
+		assert.True(t, (&Webhook{}).Matches("delete", "/anything"))
+	})
+}
+
+func TestSubscriptionModel(t *testing.T) {
+	t.Run("Covers", func(t *testing.T) {
+		folder := &Subscription{Path: "/docs"}
+		assert.True(t, folder.Covers("/docs"))
+		assert.True(t, folder.Covers("/docs/guide/a.md"))
+		assert.False(t, folder.Covers("/docs-old/a.md"))
+		assert.True(t, folder.Covers("/archive/a.md", "/docs/a.md"), "either path of a move is covered")
+
+		file := &Subscription{Path: "/report.pdf"}
+		assert.True(t, file.Covers("/report.pdf"))
+		assert.False(t, file.Covers("/report.pdf.tmp"))
+
+		assert.True(t, (&Subscription{}).Covers("/anything"))
+	})
+
+	t.Run("HasChannel", func(t *testing.T) {
+		sub := &Subscription{Channels: []string{ChannelDigest, ChannelWebSocket}}
+		assert.True(t, sub.HasChannel(ChannelWebSocket))
+		assert.False(t, sub.HasChannel(ChannelWebhook))
+	})
+}
+
+func TestNotifyRouteModel(t *testing.T) {
+	route := &NotifyRoute{Kinds: []string{NotifyFileDropped, NotifyShareExpired}}
+	assert.True(t, route.Matches(NotifyFileDropped))
+	assert.False(t, route.Matches(NotifyLinkExpired))
+
+	assert.True(t, (&NotifyRoute{}).Matches(NotifyFederatedShare))
+}
+
+func TestFileLastAccess(t *testing.T) {
+	updated := time.Date(2024, 7, 9, 0, 0, 0, 0, time.UTC)
+	file := &FileObject{UpdatedAt: updated}
+	assert.Equal(t, updated, file.LastAccess())
+
+	accessed := updated.AddDate(0, 1, 0)
+	file.AccessedAt = &accessed
+	assert.Equal(t, accessed, file.LastAccess())
+
+	file.UpdatedAt = accessed.AddDate(0, 1, 0)
+	assert.Equal(t, file.UpdatedAt, file.LastAccess())
+}
+
+func TestTransferUsageModel(t *testing.T) {
+	usage := &TransferUsage{UploadedBytes: 10, DownloadedBytes: 32}
+	assert.Equal(t, int64(42), usage.Total())
+
+	// Months are counted in UTC
+	assert.Equal(t, "2026-11", TransferMonth(time.Date(2026, 10, 31, 20, 0, 0, 0, time.FixedZone("EST", -5*3600))))
+}
+
+func TestOrganizeRuleModel(t *testing.T) {
+	taken := time.Date(2024, 7, 9, 14, 5, 30, 0, time.UTC)
+
+	t.Run("Destination", func(t *testing.T) {
+		rule := &OrganizeRule{Path: "/Inbox", Target: "/Photos"}
+		assert.Equal(t, "/Photos/2024/07/IMG_1.jpg", rule.Destination("IMG_1.jpg", taken))
+
+		rule = &OrganizeRule{Target: "", Layout: "{year}/{year}-{month}-{day}", Rename: "{hour}{minute}{second} {name}{ext}"}
+		assert.Equal(t, "/2024/2024-07-09/140530 IMG_1.jpg", rule.Destination("IMG_1.jpg", taken))
+	})
+
+	t.Run("CheckOrganizePattern", func(t *testing.T) {
+		assert.NoError(t, CheckOrganizePattern("{year}/{month}"))
+		assert.NoError(t, CheckOrganizePattern(""))
+		assert.Error(t, CheckOrganizePattern("{year}/{week}"))
+		assert.Error(t, CheckOrganizePattern("{year"))
+	})
+}
+
+func TestNewChangeAuthor(t *testing.T) {
+	first, last, blank := "Ada", "Lovelace", " "
+
+	author := NewChangeAuthor(&User{ID: 3, Username: "ada", FirstName: &first, LastName: &last})
+	assert.Equal(t, &ChangeAuthor{ID: 3, Username: "ada", DisplayName: "Ada Lovelace"}, author)
+
+	author = NewChangeAuthor(&User{ID: 4, Username: "bob", LastName: &blank})
+	assert.Equal(t, &ChangeAuthor{ID: 4, Username: "bob"}, author)
+
+	data, err := json.Marshal(&ChangeLog{UserID: 4, User: author})
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"user":{"id":4,"username":"bob"}`)
+}
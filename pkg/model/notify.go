@@ -0,0 +1,57 @@
+package model
+
+import (
+	"slices"
+	"time"
+)
+
+// Notification kinds
+const (
+	NotifyFileDropped  = "file_dropped"
+	NotifyShareExpired = "share_expired"
+	NotifyLinkExpired  = "link_expired"
+
+	NotifyFederatedShare = "federated_share" // a user of another server shared a folder
+	NotifyFederatedReply = "federated_reply" // a user of another server accepted or declined a share
+)
+
+// NotifyKinds are the notification kinds a route can be limited to
+var NotifyKinds = []string{NotifyFileDropped, NotifyShareExpired, NotifyLinkExpired, NotifyFederatedShare, NotifyFederatedReply}
+
+// Built-in transports notifications are routed through
+const (
+	TransportEmail    = "email"    // mailed to an address
+	TransportSlack    = "slack"    // posted to a Slack incoming webhook URL
+	TransportMatrix   = "matrix"   // sent to a Matrix room by the server's account
+	TransportTelegram = "telegram" // sent to a Telegram chat by the server's bot
+)
+
+// Notification is a message delivered to a user
+type Notification struct {
+	ID        int64     `json:"id" bun:"id,pk,autoincrement"`
+	UserID    int       `json:"user_id" bun:"user_id,notnull"`
+	Kind      string    `json:"kind" bun:"kind,notnull"`
+	Message   string    `json:"message" bun:"message,notnull"`
+	Read      bool      `json:"read" bun:"read,notnull"`
+	CreatedAt time.Time `json:"created_at" bun:"created_at,notnull"`
+}
+
+// A NotifyRoute delivers the notifications of a user, of the chosen kinds, through a transport to a target,
+// besides listing them in the application
+type NotifyRoute struct {
+	ID             int        `json:"id" bun:"id,pk,autoincrement"`
+	UserID         int        `json:"-" bun:"user_id,notnull"`
+	Transport      string     `json:"transport" bun:"transport,notnull"`
+	Target         string     `json:"target" bun:"target,notnull"`       // address, webhook URL, room or chat ID the transport delivers to
+	Kinds          []string   `json:"kinds,omitempty" bun:"kinds,array"` // notification kinds, empty routes all
+	Active         bool       `json:"active" bun:"active,notnull"`       // inactive routes are kept but not delivered
+	CreatedAt      time.Time  `json:"created_at" bun:"created_at,notnull"`
+	LastDeliveryAt *time.Time `json:"last_delivery_at,omitempty" bun:"last_delivery_at"`
+	LastError      string     `json:"last_error,omitempty" bun:"last_error,nullzero"` // why the last delivery failed
+	Failures       int        `json:"failures" bun:"failures,notnull"`                // deliveries failed in a row
+}
+
+// Matches reports whether notifications of a kind are delivered through the route
+func (r *NotifyRoute) Matches(kind string) bool {
+	return len(r.Kinds) == 0 || slices.Contains(r.Kinds, kind)
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultOrganizeLayout files photos and videos into Year/Month folders
+const DefaultOrganizeLayout = "{year}/{month}"
+
+// An OrganizeRule files the photos and videos dropped into an inbox folder, moving them into folders
+// named after the date they were taken and optionally renaming them.
+type OrganizeRule struct {
+	ID        int       `json:"id" bun:"id,pk,autoincrement"`
+	RepoID    int       `json:"repo_id" bun:"repo_id,notnull"`
+	Path      string    `json:"path" bun:"path,notnull"`                // inbox folder watched by the rule
+	Target    string    `json:"target" bun:"target,notnull"`            // folder the layout is created under, "" for the repository root
+	Layout    string    `json:"layout" bun:"layout,notnull"`            // folder pattern, such as "{year}/{month}"
+	Rename    string    `json:"rename,omitempty" bun:"rename,nullzero"` // file name pattern, empty keeps the name
+	CreatedBy int       `json:"created_by" bun:"created_by,notnull"`
+	CreatedAt time.Time `json:"created_at" bun:"created_at,notnull"`
+}
+
+// organizeFields are the placeholders accepted in layouts and rename patterns
+var organizeFields = []string{"{year}", "{month}", "{day}", "{hour}", "{minute}", "{second}", "{name}", "{ext}"}
+
+// CheckOrganizePattern reports an error if a pattern has an unknown placeholder
+func CheckOrganizePattern(pattern string) error {
+	rest := pattern
+	for _, field := range organizeFields {
+		rest = strings.ReplaceAll(rest, field, "")
+	}
+	if strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("unknown placeholder in %q", pattern)
+	}
+	return nil
+}
+
+// expandOrganizePattern fills the placeholders of a pattern for a file taken at the given time
+func expandOrganizePattern(pattern, name string, taken time.Time) string {
+	ext := path.Ext(name)
+	return strings.NewReplacer(
+		"{year}", taken.Format("2006"),
+		"{month}", taken.Format("01"),
+		"{day}", taken.Format("02"),
+		"{hour}", taken.Format("15"),
+		"{minute}", taken.Format("04"),
+		"{second}", taken.Format("05"),
+		"{name}", strings.TrimSuffix(name, ext),
+		"{ext}", ext,
+	).Replace(pattern)
+}
+
+// Destination returns where a file named name, taken at the given time, is filed by the rule
+func (r *OrganizeRule) Destination(name string, taken time.Time) string {
+	layout := r.Layout
+	if layout == "" {
+		layout = DefaultOrganizeLayout
+	}
+	if r.Rename != "" {
+		name = expandOrganizePattern(r.Rename, name, taken)
+	}
+	return path.Join("/", r.Target, expandOrganizePattern(layout, name, taken), name)
+}
@@ -1,5 +1,7 @@
 package model
 
+import "path"
+
 // Resource represents a file or directory within a repository
 type Resource struct {
 	Repo *Repository
@@ -9,3 +11,12 @@ type Resource struct {
 func (r *Resource) String() string {
 	return r.Repo.Name + r.Path
 }
+
+// CleanPath normalizes a repository path to the stored form ("" for the root)
+func CleanPath(p string) string {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return ""
+	}
+	return p
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// FileText is the text extracted from a file, such as the OCR of a scanned page, for full text search
+type FileText struct {
+	FileID      int       `json:"file_id" bun:"file_id,pk"`
+	RepoID      int       `json:"repo_id" bun:"repo_id,notnull"`
+	Content     string    `json:"content" bun:"content,notnull"`
+	Error       string    `json:"error,omitempty" bun:"error,nullzero"` // why extraction failed, the file is not retried until it changes
+	ExtractedAt time.Time `json:"extracted_at" bun:"extracted_at,notnull"`
+}
+
+// A SearchHit is a file matching a search, by name or by its extracted text
+type SearchHit struct {
+	File    *FileObject `json:"file"`
+	Snippet string      `json:"snippet,omitempty"` // matching excerpt of the extracted text
+}
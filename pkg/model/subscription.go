@@ -0,0 +1,49 @@
+package model
+
+import (
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Channels a subscription delivers changes through
+const (
+	ChannelDigest    = "digest"    // summarized in the activity digest
+	ChannelWebhook   = "webhook"   // posted to the subscriber's URL as they happen
+	ChannelWebSocket = "websocket" // pushed to the subscriber's open event streams as they happen
+)
+
+// Channels are the channels a subscription can choose
+var Channels = []string{ChannelDigest, ChannelWebhook, ChannelWebSocket}
+
+// A Subscription tells a user of the changes to a file or folder of a repository they own or that is
+// shared with them, through the channels they chose
+type Subscription struct {
+	ID         int       `json:"id" bun:"id,pk,autoincrement"`
+	UserID     int       `json:"-" bun:"user_id,notnull"`
+	RepoID     int       `json:"repo_id" bun:"repo_id,notnull"`
+	Repo       string    `json:"repo" bun:"-"`
+	Path       string    `json:"path" bun:"path,notnull"` // file or folder watched, "" for the whole repository
+	Channels   []string  `json:"channels" bun:"channels,array"`
+	WebhookURL string    `json:"webhook_url,omitempty" bun:"webhook_url,nullzero"`
+	Secret     string    `json:"-" bun:"secret,nullzero"` // HMAC-SHA256 key of the webhook signature header
+	CreatedAt  time.Time `json:"created_at" bun:"created_at,notnull"`
+}
+
+// HasChannel reports whether the subscription delivers through a channel
+func (s *Subscription) HasChannel(channel string) bool {
+	return slices.Contains(s.Channels, channel)
+}
+
+// Covers reports whether a change of any of the paths is to the watched file or below the watched folder
+func (s *Subscription) Covers(paths ...string) bool {
+	watched := path.Clean("/" + s.Path)
+	for _, p := range paths {
+		p = path.Clean("/" + p)
+		if watched == "/" || p == watched || strings.HasPrefix(p, watched+"/") {
+			return true
+		}
+	}
+	return false
+}
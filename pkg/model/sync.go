@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type ChangeLog struct {
 	ID        int       `bun:"id,pk,autoincrement"`
@@ -11,8 +14,40 @@ type ChangeLog struct {
 	UserID    int       `bun:"user_id,notnull"`
 	Version   string    `bun:"version,notnull"`
 	Timestamp time.Time `bun:"timestamp,notnull"`
+	// OperationID is shared by the change log and audit log entries of the request that made the change
+	OperationID string `bun:"operation_id,nullzero" json:"operation_id,omitempty"`
+	// User is who made the change, filled in only when asked for
+	User *ChangeAuthor `bun:"-" json:"user,omitempty"`
 }
 
+// ChangeAuthor names the user who made a change
+type ChangeAuthor struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"` // first and last name, when given
+}
+
+// NewChangeAuthor returns the author details of a user
+func NewChangeAuthor(user *User) *ChangeAuthor {
+	var names []string
+	for _, name := range []*string{user.FirstName, user.LastName} {
+		if name != nil && strings.TrimSpace(*name) != "" {
+			names = append(names, strings.TrimSpace(*name))
+		}
+	}
+	return &ChangeAuthor{ID: user.ID, Username: user.Username, DisplayName: strings.Join(names, " ")}
+}
+
+// ChangeFilter limits a change listing to a folder and some operations, the zero value matches all changes
+type ChangeFilter struct {
+	PathPrefix string   // folder whose changes are listed, moves out of it included
+	Operations []string // operations listed, empty matches all
+}
+
+// ZeroVersion is the version of a repository without any recorded change.
+// It sorts before every version generated for a change.
+const ZeroVersion = "v0-0"
+
 type RepositoryVersion struct {
 	ID             int       `bun:"id,pk,autoincrement"`
 	RepoID         int       `bun:"repo_id,unique,notnull"`
@@ -21,18 +56,63 @@ type RepositoryVersion struct {
 	UpdatedAt      time.Time `bun:"updated_at,notnull"`
 }
 
+// SyncClient is a device syncing a repository for a user, with the last version it acknowledged having
+type SyncClient struct {
+	ID           int       `bun:"id,pk,autoincrement" json:"-"`
+	RepoID       int       `bun:"repo_id,notnull" json:"repo_id"`
+	UserID       int       `bun:"user_id,notnull" json:"user_id"`
+	DeviceID     string    `bun:"device_id,notnull" json:"device_id"`
+	AckedVersion string    `bun:"acked_version,notnull" json:"acked_version"`
+	LastSeenAt   time.Time `bun:"last_seen_at,notnull" json:"last_seen_at"`
+}
+
+// Upload session states
+const (
+	UploadActive    = "active"    // receiving chunks
+	UploadCompleted = "completed" // stored
+	UploadCancelled = "cancelled"
+	UploadFailed    = "failed" // could not be stored, its chunks are kept for a retry
+)
+
 type UploadSession struct {
-	ID             int       `bun:"id,pk,autoincrement"`
-	UploadID       string    `bun:"upload_id,unique,notnull"`
-	RepoID         int       `bun:"repo_id,notnull"`
-	Path           string    `bun:"path,notnull"`
-	TotalSize      int64     `bun:"total_size,notnull"`
-	UserID         int       `bun:"user_id,notnull"`
-	ChunksUploaded int       `bun:"chunks_uploaded,default:0"`
-	TotalChunks    int       `bun:"total_chunks,notnull"`
-	CreatedAt      time.Time `bun:"created_at,notnull"`
-	ExpiresAt      time.Time `bun:"expires_at,notnull"`
-	Status         string    `bun:"status,default:'active'"`
+	ID             int        `bun:"id,pk,autoincrement"`
+	UploadID       string     `bun:"upload_id,unique,notnull"`
+	RepoID         int        `bun:"repo_id,notnull"`
+	Path           string     `bun:"path,notnull"`
+	TotalSize      int64      `bun:"total_size,notnull"`
+	UserID         int        `bun:"user_id,notnull"`
+	ChunksUploaded int        `bun:"chunks_uploaded,default:0"`
+	TotalChunks    int        `bun:"total_chunks,notnull"`
+	ChunkSize      int64      `bun:"chunk_size,notnull"` // size of every chunk but the last
+	ModTime        *time.Time `bun:"mod_time"`           // modification time given by the client, nil for server time
+	CreatedAt      time.Time  `bun:"created_at,notnull"`
+	ExpiresAt      time.Time  `bun:"expires_at,notnull"`
+	LastActivityAt time.Time  `bun:"last_activity_at,notnull"` // last chunk or keepalive
+	Status         string     `bun:"status,default:'active'"`
+}
+
+// ChunkLength returns the size chunk index must have: the chunk size, or the rest of the file
+// for the last chunk. It returns -1 for an index outside the upload.
+func (s *UploadSession) ChunkLength(index int) int64 {
+	if index < 0 || index >= s.TotalChunks {
+		return -1
+	}
+	if index == s.TotalChunks-1 {
+		return s.TotalSize - int64(index)*s.ChunkSize
+	}
+	return s.ChunkSize
+}
+
+// Deadline returns when the session expires: at ExpiresAt, or earlier once it has been idle
+// for the given time. A zero idle time disables the idle check, which failed sessions waiting
+// for a retry are exempt from.
+func (s *UploadSession) Deadline(idle time.Duration) time.Time {
+	if idle > 0 && s.Status != UploadFailed {
+		if idleAt := s.LastActivityAt.Add(idle); idleAt.Before(s.ExpiresAt) {
+			return idleAt
+		}
+	}
+	return s.ExpiresAt
 }
 
 type UploadChunk struct {
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// Reasons a file was moved to the trash
+const (
+	TrashReasonDeleted = "deleted"
+	TrashReasonExpired = "expired"
+)
+
+// A TrashItem is a deleted file kept in its repository's trash until it is restored or purged.
+type TrashItem struct {
+	ID         int64     `json:"id" bun:"id,pk,autoincrement"`
+	RepoID     int       `json:"repo_id" bun:"repo_id,notnull"`
+	Path       string    `json:"path" bun:"path,notnull"`     // original path
+	StoredName string    `json:"-" bun:"stored_name,notnull"` // storage name inside the trash folder
+	Size       int64     `json:"size" bun:"size,notnull"`
+	DeletedBy  *int      `json:"deleted_by,omitempty" bun:"deleted_by"` // nil when removed by the server
+	Reason     string    `json:"reason" bun:"reason,notnull"`
+	DeletedAt  time.Time `json:"deleted_at" bun:"deleted_at,notnull"`
+}
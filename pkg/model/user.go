@@ -10,11 +10,36 @@ type User struct {
 	HA1       string     `json:"-" bun:"ha1_hash,notnull"` // Don't expose HA1 hash in JSON
 	FirstName *string    `json:"first_name,omitempty" bun:"first_name"`
 	LastName  *string    `json:"last_name,omitempty" bun:"last_name"`
+	Locale    string     `json:"locale,omitempty" bun:"locale,notnull"` // preferred language of messages, empty for the default
 	CreatedAt time.Time  `json:"created_at" bun:"created_at,notnull"`
 	UpdatedAt time.Time  `json:"updated_at" bun:"updated_at,notnull"`
 	LastLogin *time.Time `json:"last_login,omitempty" bun:"last_login"`
 	IsActive  bool       `json:"is_active" bun:"is_active,notnull"`
 	IsAdmin   bool       `json:"is_admin" bun:"is_admin,notnull"`
+	// TOTPSecret is the base32 secret of the user's authenticator app, empty without two-factor login
+	TOTPSecret string `json:"-" bun:"totp_secret,notnull"`
+	// Digest is how often the user is emailed the activity of followed folders, empty for never
+	Digest       string     `json:"digest,omitempty" bun:"digest,notnull"`
+	DigestSentAt *time.Time `json:"-" bun:"digest_sent_at"` // end of the period the last digest covered
+	// Version counts the changes of the user, for updates to fail rather than undo a change made since they read it
+	Version int `json:"version" bun:"version,notnull"`
+}
+
+// HasTOTP reports whether logging in needs a one-time code besides the password
+func (u *User) HasTOTP() bool {
+	return u.TOTPSecret != ""
+}
+
+// AppPassword is a password the server generated for one client of a user, such as a WebDAV mount or sync
+// client. Clients authenticating with Basic or Digest credentials use these instead of the account password
+// once the user enabled two-factor login, since they cannot ask for one-time codes.
+type AppPassword struct {
+	ID        int        `json:"id" bun:"id,pk,autoincrement"`
+	UserID    int        `json:"-" bun:"user_id,notnull"`
+	Name      string     `json:"name" bun:"name,notnull"`
+	HA1       string     `json:"-" bun:"ha1_hash,notnull"` // HA1 of the username and the password, like the user's
+	CreatedAt time.Time  `json:"created_at" bun:"created_at,notnull"`
+	LastUsed  *time.Time `json:"last_used,omitempty" bun:"last_used"`
 }
 
 type UserQuota struct {
@@ -22,5 +47,29 @@ type UserQuota struct {
 	UserID          int       `json:"user_id" bun:"user_id,unique,notnull"`
 	TotalQuotaBytes int64     `json:"total_quota_bytes" bun:"total_quota_bytes,notnull"`
 	UsedBytes       int64     `json:"used_bytes" bun:"used_bytes,notnull"`
+	TransferCap     *int64    `json:"transfer_cap_bytes,omitempty" bun:"transfer_cap_bytes"` // monthly transfer cap, 0 for none, nil for the configured one
 	UpdatedAt       time.Time `json:"updated_at" bun:"updated_at,notnull"`
 }
+
+// Kinds of subjects tracked for failed logins, and for wrong passwords of share links
+const (
+	LoginSubjectUser     = "user"
+	LoginSubjectAddr     = "addr"
+	LoginSubjectLink     = "link"      // share link, by ID
+	LoginSubjectLinkAddr = "link_addr" // source address of share link passwords
+)
+
+// LoginFailure tracks recent failed logins for a username or a source address
+type LoginFailure struct {
+	ID          int        `json:"-" bun:"id,pk,autoincrement"`
+	Kind        string     `json:"kind" bun:"kind,notnull"`
+	Subject     string     `json:"subject" bun:"subject,notnull"`
+	Failures    int        `json:"failures" bun:"failures,notnull"`
+	LastFailure time.Time  `json:"last_failure" bun:"last_failure,notnull"`
+	LockedUntil *time.Time `json:"locked_until,omitempty" bun:"locked_until"`
+}
+
+// IsLocked reports whether the subject is still locked at the given time
+func (f *LoginFailure) IsLocked(now time.Time) bool {
+	return f.LockedUntil != nil && now.Before(*f.LockedUntil)
+}
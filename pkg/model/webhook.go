@@ -0,0 +1,72 @@
+package model
+
+import (
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A Webhook posts the changes of a repository to a URL, for example to trigger a CI build.
+// Deliveries are signed with the secret so that the receiver can check where they come from.
+type Webhook struct {
+	ID             int        `json:"id" bun:"id,pk,autoincrement"`
+	RepoID         int        `json:"repo_id" bun:"repo_id,notnull"`
+	URL            string     `json:"url" bun:"url,notnull"`
+	Secret         string     `json:"-" bun:"secret,notnull"`                      // HMAC-SHA256 key of the signature header
+	Paths          []string   `json:"paths,omitempty" bun:"paths,array"`           // path globs, empty matches all paths
+	Operations     []string   `json:"operations,omitempty" bun:"operations,array"` // change log operations, empty matches all
+	Active         bool       `json:"active" bun:"active,notnull"`                 // inactive webhooks are kept but not delivered
+	CreatedBy      int        `json:"created_by" bun:"created_by,notnull"`
+	CreatedAt      time.Time  `json:"created_at" bun:"created_at,notnull"`
+	LastDeliveryAt *time.Time `json:"last_delivery_at,omitempty" bun:"last_delivery_at"`
+	LastStatus     int        `json:"last_status,omitempty" bun:"last_status,nullzero"` // HTTP status of the last delivery
+	LastError      string     `json:"last_error,omitempty" bun:"last_error,nullzero"`   // why the last delivery failed
+}
+
+// Matches reports whether a change with the operation on any of the paths triggers the webhook
+func (h *Webhook) Matches(operation string, paths ...string) bool {
+	if len(h.Operations) > 0 && !slices.Contains(h.Operations, operation) {
+		return false
+	}
+	if len(h.Paths) == 0 {
+		return true
+	}
+
+	for _, p := range paths {
+		for _, pattern := range h.Paths {
+			if MatchGlob(pattern, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatchGlob reports whether a repository path matches a glob. Globs follow path.Match within a segment,
+// and a "**" segment matches any number of segments, so "/src/**" matches everything under /src.
+func MatchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(strings.Trim(name, "/"), "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,60 @@
+package netacl
+
+import (
+	"net/http"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/gin-gonic/gin"
+)
+
+func sendDenied(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error": "Access from " + c.ClientIP() + " is not allowed",
+	})
+}
+
+func contextUsername(c *gin.Context) string {
+	if v, ok := c.Get("user"); ok {
+		if user, ok := v.(*model.User); ok {
+			return user.Username
+		}
+	}
+	return ""
+}
+
+// Filter rejects requests from addresses outside the global access list
+func Filter(c *gin.Context) {
+	if err := CheckGlobal(c, c.ClientIP(), ""); err != nil {
+		sendDenied(c)
+		return
+	}
+
+	c.Next()
+}
+
+// RepoFilter rejects requests to a repository from addresses outside its access list.
+// The repository is taken from the "repo" path parameter or query parameter.
+func RepoFilter(c *gin.Context) {
+	name := c.Param("repo")
+	if name == "" {
+		name = c.Query("repo")
+	}
+	if name == "" {
+		c.Next()
+		return
+	}
+
+	repo, err := stor.GetRepository(c, name)
+	if err != nil {
+		c.Next() // let the handler report the missing repository
+		return
+	}
+
+	if err := CheckRepo(c, repo, c.ClientIP(), contextUsername(c)); err != nil {
+		sendDenied(c)
+		return
+	}
+
+	c.Next()
+}
@@ -0,0 +1,149 @@
+// Package netacl enforces network allow and deny lists, globally and per repository.
+package netacl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+
+	"github.com/cgang/file-hub/pkg/audit"
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// ErrDenied is returned when the client address is not allowed
+var ErrDenied = errors.New("access from this network is not allowed")
+
+var (
+	global *List
+)
+
+// List is a set of allowed and denied network prefixes.
+// Deny entries take precedence; when allow entries exist, the address must match one of them.
+type List struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// Parse builds a List from CIDR prefixes or single addresses
+func Parse(allow, deny []string) (*List, error) {
+	l := &List{}
+	var err error
+	if l.allow, err = parsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	if l.deny, err = parsePrefixes(deny); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func parsePrefixes(specs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if !strings.Contains(spec, "/") {
+			addr, err := netip.ParseAddr(spec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", spec, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", spec, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// IsEmpty reports whether the list places no restriction
+func (l *List) IsEmpty() bool {
+	return l == nil || (len(l.allow) == 0 && len(l.deny) == 0)
+}
+
+// Allows reports whether the address passes the list
+func (l *List) Allows(addr string) bool {
+	if l.IsEmpty() {
+		return true
+	}
+
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+
+	for _, p := range l.deny {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(l.allow) == 0 {
+		return true
+	}
+
+	for _, p := range l.allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Init loads the global access list from configuration
+func Init(cfg *config.Config) error {
+	l, err := Parse(cfg.Security.Network.Allow, cfg.Security.Network.Deny)
+	if err != nil {
+		return err
+	}
+
+	global = l
+	return nil
+}
+
+// CheckGlobal verifies the address against the global access list
+func CheckGlobal(ctx context.Context, addr, username string) error {
+	if !global.Allows(addr) {
+		return deny(ctx, addr, username, "global network policy")
+	}
+	return nil
+}
+
+// CheckRepo verifies the address against the global list and the repository's own list
+func CheckRepo(ctx context.Context, repo *model.Repository, addr, username string) error {
+	if err := CheckGlobal(ctx, addr, username); err != nil {
+		return err
+	}
+
+	l, err := Parse(repo.AllowNets, repo.DenyNets)
+	if err != nil {
+		// Fail closed on a corrupt stored list rather than exposing the repository
+		log.Printf("Invalid network list for repository %s: %s", repo.Name, err)
+		return deny(ctx, addr, username, "repository "+repo.Name)
+	}
+
+	if !l.Allows(addr) {
+		return deny(ctx, addr, username, "repository "+repo.Name)
+	}
+	return nil
+}
+
+func deny(ctx context.Context, addr, username, detail string) error {
+	log.Printf("Denied access from %s to %s", addr, detail)
+	audit.Record(ctx, &model.AuditEntry{
+		Action:     model.AuditAccessDenied,
+		Username:   username,
+		RemoteAddr: addr,
+		Detail:     detail,
+	})
+	return ErrDenied
+}
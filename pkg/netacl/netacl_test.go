@@ -0,0 +1,143 @@
+package netacl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("Valid prefixes and addresses", func(t *testing.T) {
+		l, err := Parse([]string{"10.0.0.0/8", " 192.168.1.5 ", "2001:db8::/32"}, []string{"10.1.0.0/16"})
+		require.NoError(t, err)
+		assert.Len(t, l.allow, 3)
+		assert.Len(t, l.deny, 1)
+	})
+
+	t.Run("Invalid prefix", func(t *testing.T) {
+		_, err := Parse([]string{"10.0.0.0/33"}, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid address", func(t *testing.T) {
+		_, err := Parse(nil, []string{"office"})
+		assert.Error(t, err)
+	})
+}
+
+func TestAllows(t *testing.T) {
+	l, err := Parse([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.1.0.0/16"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"10.2.3.4", true},
+		{"10.1.2.3", false}, // deny takes precedence
+		{"192.168.1.1", false},
+		{"::ffff:10.2.3.4", true}, // IPv4-mapped IPv6
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"not-an-ip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, l.Allows(tt.addr))
+		})
+	}
+
+	t.Run("Empty list allows everything", func(t *testing.T) {
+		var empty *List
+		assert.True(t, empty.Allows("192.168.1.1"))
+
+		empty, err = Parse(nil, nil)
+		require.NoError(t, err)
+		assert.True(t, empty.Allows("not-an-ip"))
+	})
+
+	t.Run("Deny only list", func(t *testing.T) {
+		denyOnly, err := Parse(nil, []string{"203.0.113.0/24"})
+		require.NoError(t, err)
+		assert.False(t, denyOnly.Allows("203.0.113.9"))
+		assert.True(t, denyOnly.Allows("198.51.100.1"))
+	})
+}
+
+func TestCheckRepo(t *testing.T) {
+	original := global
+	defer func() { global = original }()
+	global = nil
+
+	repo := &model.Repository{Name: "secret", AllowNets: []string{"10.0.0.0/8"}}
+	assert.NoError(t, CheckRepo(t.Context(), repo, "10.0.0.1", "user"))
+	assert.ErrorIs(t, CheckRepo(t.Context(), repo, "192.168.0.1", "user"), ErrDenied)
+
+	t.Run("Corrupt list fails closed", func(t *testing.T) {
+		bad := &model.Repository{Name: "bad", DenyNets: []string{"bogus"}}
+		assert.ErrorIs(t, CheckRepo(t.Context(), bad, "10.0.0.1", "user"), ErrDenied)
+	})
+
+	t.Run("Global list applies to repositories", func(t *testing.T) {
+		require.NoError(t, Init(&config.Config{Security: config.SecurityConfig{
+			Network: config.NetworkConfig{Deny: []string{"10.0.0.1"}},
+		}}))
+		assert.ErrorIs(t, CheckRepo(t.Context(), repo, "10.0.0.1", "user"), ErrDenied)
+		assert.NoError(t, CheckRepo(t.Context(), repo, "10.0.0.2", "user"))
+	})
+}
+
+func TestFilter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	original := global
+	defer func() { global = original }()
+	require.NoError(t, Init(&config.Config{Security: config.SecurityConfig{
+		Network: config.NetworkConfig{Allow: []string{"10.0.0.0/8"}},
+	}}))
+
+	newRouter := func(trustedProxies []string) *gin.Engine {
+		router := gin.New()
+		require.NoError(t, router.SetTrustedProxies(trustedProxies))
+		router.Use(Filter)
+		router.GET("/", func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		return router
+	}
+
+	tests := []struct {
+		name       string
+		trusted    []string
+		remoteAddr string
+		forwarded  string
+		code       int
+	}{
+		{"Allowed address", nil, "10.0.0.1:1234", "", http.StatusOK},
+		{"Denied address", nil, "192.168.0.1:1234", "", http.StatusForbidden},
+		{"Forwarded header from untrusted peer is ignored", nil, "192.168.0.1:1234", "10.0.0.1", http.StatusForbidden},
+		{"Forwarded header from trusted proxy", []string{"192.168.0.1"}, "192.168.0.1:1234", "10.0.0.1", http.StatusOK},
+		{"Forwarded denied address from trusted proxy", []string{"10.0.0.1"}, "10.0.0.1:1234", "192.168.0.2", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			w := httptest.NewRecorder()
+			newRouter(tt.trusted).ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cgang/file-hub/pkg/egress"
+	"github.com/google/uuid"
+)
+
+// defaultTelegramAPI is the URL of the Telegram Bot API
+const defaultTelegramAPI = "https://api.telegram.org"
+
+// maxErrorBody is how much of a failed response is kept in the error of the delivery
+const maxErrorBody = 512
+
+// postJSON sends a payload as JSON through a client, failing on anything but a 2xx response. Errors leave
+// out the URL, which may carry a token, as they are shown to the owner of the route.
+func postJSON(ctx context.Context, client *http.Client, method, target string, header http.Header, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid URL")
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FileHub-Notify")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("receiver answered %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// slackTransport posts notifications to Slack incoming webhook URLs, which also suits the
+// Slack-compatible webhooks of Mattermost or Rocket.Chat. The URLs are the users' own, so only public
+// addresses are reached.
+type slackTransport struct{}
+
+func (slackTransport) Validate(target string) error {
+	if err := egress.CheckURL(target); err != nil {
+		return fmt.Errorf("%w: target must be an absolute http or https webhook URL of a public host: %s", ErrInvalid, err)
+	}
+	return nil
+}
+
+func (slackTransport) Deliver(ctx context.Context, target string, msg *Message) error {
+	return postJSON(ctx, publicClient, http.MethodPost, target, nil, map[string]string{"text": msg.Text})
+}
+
+// matrixTransport sends notifications as notices to Matrix rooms the server's account has joined
+type matrixTransport struct {
+	homeserver string
+	token      string
+}
+
+func (*matrixTransport) Validate(target string) error {
+	if !strings.HasPrefix(target, "!") || !strings.Contains(target, ":") {
+		return fmt.Errorf("%w: target must be a Matrix room ID such as !abc:example.com", ErrInvalid)
+	}
+	return nil
+}
+
+func (t *matrixTransport) Deliver(ctx context.Context, target string, msg *Message) error {
+	endpoint := strings.TrimRight(t.homeserver, "/") + "/_matrix/client/v3/rooms/" + url.PathEscape(target) +
+		"/send/m.room.message/" + uuid.NewString()
+	header := http.Header{"Authorization": {"Bearer " + t.token}}
+	return postJSON(ctx, client, http.MethodPut, endpoint, header, map[string]string{"msgtype": "m.notice", "body": msg.Text})
+}
+
+// telegramTransport sends notifications to Telegram chats through the server's bot
+type telegramTransport struct {
+	apiURL string
+	token  string
+}
+
+func (*telegramTransport) Validate(target string) error {
+	if _, err := strconv.ParseInt(target, 10, 64); err == nil {
+		return nil
+	}
+	if len(target) > 1 && strings.HasPrefix(target, "@") {
+		return nil
+	}
+	return fmt.Errorf("%w: target must be a Telegram chat ID or @channel", ErrInvalid)
+}
+
+func (t *telegramTransport) Deliver(ctx context.Context, target string, msg *Message) error {
+	endpoint := strings.TrimRight(t.apiURL, "/") + "/bot" + t.token + "/sendMessage"
+	return postJSON(ctx, client, http.MethodPost, endpoint, nil, map[string]string{"chat_id": target, "text": msg.Text})
+}
@@ -0,0 +1,212 @@
+// Package notify delivers notifications to users. Notifications are listed in the application and
+// delivered in the background through the routes users set up, such as to email, Slack, Matrix or Telegram,
+// so that a slow or failing transport never holds up the triggering operation.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/egress"
+	"github.com/cgang/file-hub/pkg/i18n"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+const (
+	// MaxRoutes is the most notification routes a user may have
+	MaxRoutes = 20
+
+	// queueSize is how many notifications may wait for delivery before new ones are dropped
+	queueSize = 1000
+	// deliveryTimeout bounds each delivery
+	deliveryTimeout = 10 * time.Second
+)
+
+// ErrInvalid is returned when a notification route is malformed
+var ErrInvalid = errors.New("invalid notification route")
+
+// delivery is a notification waiting to be delivered through the routes of its user
+type delivery struct {
+	note *model.Notification
+	msg  *Message
+}
+
+// queue holds notifications waiting for delivery, nil until Start is called
+var queue chan *delivery
+
+// client posts to the services the configuration names, publicClient to the URLs users give
+var (
+	client       = &http.Client{Timeout: deliveryTimeout}
+	publicClient = egress.Client(deliveryTimeout)
+)
+
+// Start registers the built-in transports the configuration allows and delivers queued notifications
+// until ctx is done
+func Start(ctx context.Context, cfg *config.Config) {
+	registerBuiltins(cfg)
+
+	queue = make(chan *delivery, queueSize)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case d := <-queue:
+				deliver(ctx, d)
+			}
+		}
+	}()
+}
+
+// Send delivers a notification to a user, with the message of the key translated to the user's locale.
+// Failures are logged rather than returned so a notification never fails the triggering operation.
+func Send(ctx context.Context, userID int, kind, key string, args ...any) {
+	locale := i18n.Default
+	if user, err := db.GetUserByID(ctx, userID); err == nil {
+		locale = user.Locale
+	}
+
+	note := &model.Notification{
+		UserID:  userID,
+		Kind:    kind,
+		Message: i18n.Sprintf(locale, key, args...),
+	}
+
+	if err := db.CreateNotification(ctx, note); err != nil {
+		log.Printf("Failed to notify user %d of %s: %s", userID, kind, err)
+	}
+
+	route(&delivery{note: note, msg: &Message{Kind: kind, Subject: i18n.Sprintf(locale, "notify.subject"), Text: note.Message}})
+}
+
+// route queues a notification for delivery through the routes of its user.
+// Notifications are dropped, with a log message, when the queue is full.
+func route(d *delivery) {
+	if queue == nil {
+		return
+	}
+
+	select {
+	case queue <- d:
+	default:
+		log.Printf("Notification queue full, dropping %s for user %d", d.note.Kind, d.note.UserID)
+	}
+}
+
+func deliver(ctx context.Context, d *delivery) {
+	routes, err := db.ListNotifyRoutes(ctx, d.note.UserID, true)
+	if err != nil {
+		log.Printf("Failed to list notification routes of user %d: %s", d.note.UserID, err)
+		return
+	}
+
+	for _, r := range routes {
+		if !r.Matches(d.note.Kind) {
+			continue
+		}
+
+		errMsg := ""
+		if err := deliverRoute(ctx, r, d.msg); err != nil {
+			errMsg = err.Error()
+			log.Printf("Failed to deliver notification route %d: %s", r.ID, err)
+		}
+		if err := db.RecordNotifyDelivery(ctx, r.ID, time.Now(), errMsg); err != nil {
+			log.Printf("Failed to record delivery of notification route %d: %s", r.ID, err)
+		}
+	}
+}
+
+// deliverRoute sends a message through a route, failing when its transport is no longer configured
+func deliverRoute(ctx context.Context, r *model.NotifyRoute, msg *Message) error {
+	t, ok := getTransport(r.Transport)
+	if !ok {
+		return fmt.Errorf("transport %s is not available", r.Transport)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	return t.Deliver(ctx, r.Target, msg)
+}
+
+// List returns a user's notifications, newest first
+func List(ctx context.Context, userID int, unreadOnly bool, limit int) ([]*model.Notification, error) {
+	return db.ListNotifications(ctx, userID, unreadOnly, limit)
+}
+
+// MarkRead marks notifications of a user as read
+func MarkRead(ctx context.Context, userID int, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return db.MarkNotificationsRead(ctx, userID, ids)
+}
+
+// RouteRequest describes a notification route to create
+type RouteRequest struct {
+	Transport string   `json:"transport"`
+	Target    string   `json:"target"`
+	Kinds     []string `json:"kinds,omitempty"`
+}
+
+func (req *RouteRequest) validate() error {
+	t, ok := getTransport(req.Transport)
+	if !ok {
+		return fmt.Errorf("%w: unknown transport %q, available are %v", ErrInvalid, req.Transport, Transports())
+	}
+
+	for _, kind := range req.Kinds {
+		if !slices.Contains(model.NotifyKinds, kind) {
+			return fmt.Errorf("%w: unknown notification kind %q", ErrInvalid, kind)
+		}
+	}
+	return t.Validate(req.Target)
+}
+
+// CreateRoute adds a route delivering the user's notifications of the requested kinds through a transport
+func CreateRoute(ctx context.Context, user *model.User, req *RouteRequest) (*model.NotifyRoute, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	existing, err := db.ListNotifyRoutes(ctx, user.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= MaxRoutes {
+		return nil, fmt.Errorf("%w: a user can have at most %d notification routes", ErrInvalid, MaxRoutes)
+	}
+
+	r := &model.NotifyRoute{
+		UserID:    user.ID,
+		Transport: req.Transport,
+		Target:    req.Target,
+		Kinds:     req.Kinds,
+		Active:    true,
+	}
+	if err := db.CreateNotifyRoute(ctx, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListRoutes returns the notification routes of a user, with the outcome of their latest delivery
+func ListRoutes(ctx context.Context, user *model.User) ([]*model.NotifyRoute, error) {
+	return db.ListNotifyRoutes(ctx, user.ID, false)
+}
+
+// SetRouteActive pauses or resumes the deliveries through a route of the user
+func SetRouteActive(ctx context.Context, user *model.User, id int, active bool) error {
+	return db.SetNotifyRouteActive(ctx, user.ID, id, active)
+}
+
+// DeleteRoute removes a notification route of the user
+func DeleteRoute(ctx context.Context, user *model.User, id int) error {
+	return db.DeleteNotifyRoute(ctx, user.ID, id)
+}
@@ -0,0 +1,142 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/egress"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a chat service recording the last request it received
+type recorder struct {
+	method, path, auth string
+	body               map[string]string
+}
+
+func newRecorder(t *testing.T, status int) (*recorder, *httptest.Server) {
+	rec := &recorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec.method, rec.path, rec.auth = r.Method, r.URL.EscapedPath(), r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &rec.body))
+		w.WriteHeader(status)
+		w.Write([]byte(`{"ok":false,"description":"Bad Request: chat not found"}`))
+	}))
+	t.Cleanup(server.Close)
+	return rec, server
+}
+
+func TestTransports(t *testing.T) {
+	ctx := context.Background()
+	msg := &Message{Kind: model.NotifyFileDropped, Subject: "File Hub notification", Text: "a.txt was dropped into /in"}
+
+	t.Run("Slack", func(t *testing.T) {
+		rec, server := newRecorder(t, http.StatusOK)
+		assert.ErrorIs(t, slackTransport{}.Deliver(ctx, server.URL+"/services/T0/B0/x", msg), egress.ErrNotPublic)
+
+		// Test servers listen on loopback, which webhook URLs of users may not reach
+		saved := publicClient
+		publicClient = client
+		t.Cleanup(func() { publicClient = saved })
+		require.NoError(t, slackTransport{}.Deliver(ctx, server.URL+"/services/T0/B0/x", msg))
+		assert.Equal(t, http.MethodPost, rec.method)
+		assert.Equal(t, "/services/T0/B0/x", rec.path)
+		assert.Equal(t, map[string]string{"text": msg.Text}, rec.body)
+	})
+
+	t.Run("Matrix", func(t *testing.T) {
+		rec, server := newRecorder(t, http.StatusOK)
+		transport := &matrixTransport{homeserver: server.URL + "/", token: "syt_token"}
+		require.NoError(t, transport.Deliver(ctx, "!room:example.com", msg))
+		assert.Equal(t, http.MethodPut, rec.method)
+		assert.Regexp(t, "^/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/[0-9a-f-]{36}$", rec.path)
+		assert.Equal(t, "Bearer syt_token", rec.auth)
+		assert.Equal(t, map[string]string{"msgtype": "m.notice", "body": msg.Text}, rec.body)
+	})
+
+	t.Run("Telegram", func(t *testing.T) {
+		rec, server := newRecorder(t, http.StatusOK)
+		transport := &telegramTransport{apiURL: server.URL, token: "123456:ABC"}
+		require.NoError(t, transport.Deliver(ctx, "-100200300", msg))
+		assert.Equal(t, "/bot123456:ABC/sendMessage", rec.path)
+		assert.Equal(t, map[string]string{"chat_id": "-100200300", "text": msg.Text}, rec.body)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		_, server := newRecorder(t, http.StatusBadRequest)
+		transport := &telegramTransport{apiURL: server.URL, token: "123456:ABC"}
+		err := transport.Deliver(ctx, "42", msg)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "chat not found")
+
+		// Errors are shown to the owner of the route, and must not give away the bot token
+		transport.apiURL = "http://127.0.0.1:1"
+		err = transport.Deliver(ctx, "42", msg)
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "ABC")
+	})
+}
+
+func TestValidateTargets(t *testing.T) {
+	valid := map[Transport][]string{
+		emailTransport{}:     {"alice@example.com", "Alice <alice@example.com>"},
+		slackTransport{}:     {"https://hooks.slack.com/services/T0/B0/x"},
+		&matrixTransport{}:   {"!abc:example.com"},
+		&telegramTransport{}: {"42", "-100200300", "@channel"},
+	}
+	invalid := map[Transport][]string{
+		emailTransport{}:     {"", "alice"},
+		slackTransport{}:     {"", "hooks.slack.com/services", "ftp://example.com", "http://10.0.0.5/hook"},
+		&matrixTransport{}:   {"", "#alias:example.com", "!abc"},
+		&telegramTransport{}: {"", "@", "alice"},
+	}
+
+	for transport, targets := range valid {
+		for _, target := range targets {
+			assert.NoError(t, transport.Validate(target), target)
+		}
+	}
+	for transport, targets := range invalid {
+		for _, target := range targets {
+			assert.ErrorIs(t, transport.Validate(target), ErrInvalid, target)
+		}
+	}
+}
+
+func TestRegisterBuiltins(t *testing.T) {
+	saved := transports
+	t.Cleanup(func() { transports = saved })
+
+	transports = map[string]Transport{}
+	registerBuiltins(&config.Config{})
+	assert.Equal(t, []string{model.TransportSlack}, Transports(), "mail, Matrix and Telegram need configuring")
+
+	cfg := &config.Config{}
+	cfg.Notify.Matrix = config.MatrixConfig{Homeserver: "https://matrix.example.com", AccessToken: "syt_token"}
+	cfg.Notify.Telegram.BotToken = "123456:ABC"
+	registerBuiltins(cfg)
+	assert.Equal(t, []string{model.TransportMatrix, model.TransportSlack, model.TransportTelegram}, Transports())
+
+	req := &RouteRequest{Transport: model.TransportTelegram, Target: "42", Kinds: []string{model.NotifyFileDropped}}
+	assert.NoError(t, req.validate())
+
+	for _, req := range []*RouteRequest{
+		{Transport: model.TransportEmail, Target: "alice@example.com"},
+		{Transport: model.TransportSlack, Target: "https://hooks.slack.com/x", Kinds: []string{"file_deleted"}},
+		{Transport: model.TransportMatrix, Target: "#alias:example.com"},
+	} {
+		assert.ErrorIs(t, req.validate(), ErrInvalid, req)
+	}
+}
+
+func TestRouteWithoutStart(t *testing.T) {
+	assert.NotPanics(t, func() { route(&delivery{note: &model.Notification{Kind: model.NotifyFileDropped}}) })
+}
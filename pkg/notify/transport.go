@@ -0,0 +1,94 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	netmail "net/mail"
+	"slices"
+	"sync"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/mail"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// Message is a notification as a transport delivers it
+type Message struct {
+	Kind    string
+	Subject string // short title in the user's locale, such as the subject of a mail
+	Text    string
+}
+
+// A Transport delivers notifications outside the application, such as to a chat service.
+// Routes name a transport and a target, whose form is up to the transport.
+type Transport interface {
+	// Validate checks a target before a route to it is created
+	Validate(target string) error
+	// Deliver sends a message to a target
+	Deliver(ctx context.Context, target string, msg *Message) error
+}
+
+var (
+	transportsMu sync.RWMutex
+	transports   = map[string]Transport{}
+)
+
+// Register makes a transport available to routes under a name, replacing any registered before
+func Register(name string, t Transport) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[name] = t
+}
+
+// Transports returns the names of the available transports, sorted
+func Transports() []string {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func getTransport(name string) (Transport, bool) {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+	t, ok := transports[name]
+	return t, ok
+}
+
+// registerBuiltins registers the built-in transports the configuration allows: Slack always, email
+// with a mail server, and Matrix and Telegram with an account to send from
+func registerBuiltins(cfg *config.Config) {
+	Register(model.TransportSlack, slackTransport{})
+	if mail.Enabled() {
+		Register(model.TransportEmail, emailTransport{})
+	}
+	if m := cfg.Notify.Matrix; m.Homeserver != "" && m.AccessToken != "" {
+		Register(model.TransportMatrix, &matrixTransport{homeserver: m.Homeserver, token: m.AccessToken})
+	}
+	if tg := cfg.Notify.Telegram; tg.BotToken != "" {
+		apiURL := tg.APIURL
+		if apiURL == "" {
+			apiURL = defaultTelegramAPI
+		}
+		Register(model.TransportTelegram, &telegramTransport{apiURL: apiURL, token: tg.BotToken})
+	}
+}
+
+// emailTransport mails notifications through the configured mail server
+type emailTransport struct{}
+
+func (emailTransport) Validate(target string) error {
+	if _, err := netmail.ParseAddress(target); err != nil {
+		return fmt.Errorf("%w: invalid email address %q", ErrInvalid, target)
+	}
+	return nil
+}
+
+func (emailTransport) Deliver(_ context.Context, target string, msg *Message) error {
+	return mail.Send(target, msg.Subject, msg.Text)
+}
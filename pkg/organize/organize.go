@@ -0,0 +1,219 @@
+// Package organize files the photos and videos dropped into inbox folders.
+// Each rule moves the media of its inbox into folders named after the date it was taken, such as 2024/07,
+// and can rename it by pattern. Rules run on every maintenance pass, so new uploads are filed shortly after
+// they arrive.
+package organize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/sync"
+)
+
+const (
+	// batchSize is the most files filed per inbox and pass
+	batchSize = 500
+	// settleTime is how long a file stays untouched in the inbox before it is filed,
+	// so that clients still writing it are not disturbed
+	settleTime = time.Minute
+)
+
+var (
+	// ErrInvalid is returned when a rule is malformed
+	ErrInvalid = errors.New("invalid organize rule")
+	// ErrForbidden is returned for an inbox rule of a repository the user has no manage permission on
+	ErrForbidden = errors.New("managing organize rules needs the manage permission on the repository")
+)
+
+// Request describes the rule of an inbox folder
+type Request struct {
+	Path   string `json:"path"`
+	Target string `json:"target"` // defaults to the inbox folder
+	Layout string `json:"layout"` // defaults to model.DefaultOrganizeLayout
+	Rename string `json:"rename"`
+}
+
+func (req *Request) validate() error {
+	if err := model.CheckOrganizePattern(req.Layout); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	if err := model.CheckOrganizePattern(req.Rename); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	if strings.Contains(req.Rename, "/") {
+		return fmt.Errorf("%w: rename pattern cannot contain folders", ErrInvalid)
+	}
+	for _, segment := range strings.Split(req.Layout, "/") {
+		if segment == ".." {
+			return fmt.Errorf("%w: layout cannot leave the target folder", ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// Set creates or updates the organize rule of an inbox folder
+func Set(ctx context.Context, user *model.User, repo *model.Repository, req *Request) (*model.OrganizeRule, error) {
+	if perm.Evaluate(ctx, perm.User(user.ID), &model.Resource{Repo: repo}, perm.Manage) != nil {
+		return nil, ErrForbidden
+	}
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	inbox := model.CleanPath(req.Path)
+	dir, err := db.GetFile(ctx, repo.ID, inbox)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s not found", ErrInvalid, inbox)
+	}
+	if !dir.IsDir {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalid, inbox)
+	}
+
+	target := inbox
+	if req.Target != "" {
+		target = model.CleanPath(req.Target)
+	}
+	layout := req.Layout
+	if layout == "" {
+		layout = model.DefaultOrganizeLayout
+	}
+
+	rule := &model.OrganizeRule{
+		RepoID:    repo.ID,
+		Path:      inbox,
+		Target:    target,
+		Layout:    strings.Trim(layout, "/"),
+		Rename:    req.Rename,
+		CreatedBy: user.ID,
+	}
+	if err := db.SetOrganizeRule(ctx, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// List returns the organize rules of a repository
+func List(ctx context.Context, repo *model.Repository) ([]*model.OrganizeRule, error) {
+	return db.ListOrganizeRules(ctx, repo.ID)
+}
+
+// Delete removes the organize rule of an inbox folder
+func Delete(ctx context.Context, user *model.User, repo *model.Repository, dir string) error {
+	if perm.Evaluate(ctx, perm.User(user.ID), &model.Resource{Repo: repo}, perm.Manage) != nil {
+		return ErrForbidden
+	}
+	return db.DeleteOrganizeRule(ctx, repo.ID, model.CleanPath(dir))
+}
+
+// Run files the photos and videos waiting in every inbox, returning how many were moved.
+// A file that cannot be filed is logged and left in its inbox.
+func Run(ctx context.Context, now time.Time) (int, error) {
+	rules, err := db.ListOrganizeRules(ctx, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	filed := 0
+	for _, rule := range rules {
+		n, err := apply(ctx, rule, now)
+		filed += n
+		if err != nil {
+			return filed, fmt.Errorf("failed to apply organize rule %d: %w", rule.ID, err)
+		}
+	}
+	return filed, nil
+}
+
+// apply files the media waiting in the inbox of a single rule
+func apply(ctx context.Context, rule *model.OrganizeRule, now time.Time) (int, error) {
+	repo, err := db.GetRepositoryByID(ctx, rule.RepoID)
+	if err != nil {
+		return 0, err
+	}
+
+	inbox, err := db.GetFile(ctx, repo.ID, rule.Path)
+	if stor.IsNotFound(err) {
+		return 0, nil // the inbox was removed, nothing to file until it is back
+	} else if err != nil {
+		return 0, err
+	}
+
+	files, err := db.GetChildFiles(ctx, inbox.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	filed := 0
+	for _, file := range files {
+		if filed >= batchSize {
+			break
+		}
+		if file.IsDir || file.Immutable || !IsMedia(file.ContentType()) || now.Sub(file.UpdatedAt) < settleTime {
+			continue
+		}
+
+		if err := fileMedia(ctx, repo, rule, file); err != nil {
+			log.Printf("Failed to organize %s: %s", file.Path, err)
+			continue
+		}
+		filed++
+	}
+	return filed, nil
+}
+
+// takenAt returns when a file was taken, falling back to its modification time without metadata
+func takenAt(ctx context.Context, res *model.Resource, file *model.FileObject) time.Time {
+	reader, err := stor.OpenFile(ctx, res)
+	if err != nil {
+		return file.ModTime
+	}
+	defer reader.Close()
+
+	if t, err := TakenAt(reader, file.ContentType()); err == nil {
+		return t
+	}
+	return file.ModTime
+}
+
+// fileMedia moves a file from the inbox to its dated folder
+func fileMedia(ctx context.Context, repo *model.Repository, rule *model.OrganizeRule, file *model.FileObject) error {
+	res := &model.Resource{Repo: repo, Path: file.Path}
+	dest := rule.Destination(file.Name, takenAt(ctx, res, file))
+	if dest == file.Path {
+		return nil
+	}
+	dest = uniquePath(ctx, repo.ID, path.Dir(dest), path.Base(dest))
+
+	if _, err := sync.ResolveParent(ctx, repo, dest, true, repo.OwnerID); err != nil {
+		return err
+	}
+	if err := stor.MoveFile(ctx, res, &model.Resource{Repo: repo, Path: dest}); err != nil {
+		return err
+	}
+	return sync.RecordMove(ctx, repo.ID, file.Path, dest, repo.OwnerID)
+}
+
+// uniquePath returns a path in dir for name that does not exist yet,
+// appending " (n)" before the extension when needed
+func uniquePath(ctx context.Context, repoID int, dir, name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	candidate := path.Join(dir, name)
+	for n := 1; ; n++ {
+		if _, err := db.GetFile(ctx, repoID, candidate); err != nil {
+			return candidate
+		}
+		candidate = path.Join(dir, fmt.Sprintf("%s (%d)%s", base, n, ext))
+	}
+}
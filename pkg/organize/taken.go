@@ -0,0 +1,223 @@
+package organize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// maxExifScan is how much of a JPEG is searched for its EXIF block
+	maxExifScan = 256 << 10
+	// maxBoxSkip is how much of a video is read past, when it cannot seek, to find its movie header
+	maxBoxSkip = 64 << 20
+)
+
+// exifTimeLayout is the format of EXIF dates. They carry no zone and are kept as wall clock times.
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// quickTimeEpoch is the origin of MP4 and QuickTime timestamps
+var quickTimeEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var errNoDate = errors.New("no capture date found")
+
+// IsMedia reports whether files of a MIME type are photos or videos the rules apply to
+func IsMedia(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "image/") || strings.HasPrefix(mimeType, "video/")
+}
+
+// TakenAt reads when a photo or video was taken from its metadata:
+// the EXIF DateTimeOriginal of JPEG images and the movie header of MP4 and QuickTime videos.
+func TakenAt(r io.Reader, mimeType string) (time.Time, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return jpegTakenAt(r)
+	case "video/mp4", "video/quicktime":
+		return movieTakenAt(r)
+	default:
+		return time.Time{}, errNoDate
+	}
+}
+
+// jpegTakenAt returns the capture date of the EXIF block of a JPEG image
+func jpegTakenAt(r io.Reader) (time.Time, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxExifScan))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return time.Time{}, errNoDate
+	}
+
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 { // image data follows, no more metadata
+			break
+		}
+
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) {
+			break
+		}
+		if segment := data[pos+4 : end]; marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifTakenAt(segment[6:])
+		}
+		pos = end
+	}
+	return time.Time{}, errNoDate
+}
+
+// EXIF tags read from the TIFF structure
+const (
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+	tagDateDigitized    = 0x9004
+)
+
+// exifTakenAt returns the capture date of a TIFF structure, preferring the original date
+// over the digitized date and the date the file was last written
+func exifTakenAt(tiff []byte) (time.Time, error) {
+	if len(tiff) < 8 {
+		return time.Time{}, errNoDate
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, errNoDate
+	}
+
+	tags := make(map[uint16]string)
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:]), tags)
+	if offset, ok := ifd0[tagExifIFD]; ok {
+		readIFD(tiff, order, offset, tags)
+	}
+
+	for _, tag := range []uint16{tagDateTimeOriginal, tagDateDigitized, tagDateTime} {
+		if t, err := time.Parse(exifTimeLayout, tags[tag]); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errNoDate
+}
+
+// readIFD collects the date tags of an image file directory into dates and returns its LONG values by tag
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32, dates map[uint16]string) map[uint16]uint32 {
+	longs := make(map[uint16]uint32)
+	if int64(offset)+2 > int64(len(tiff)) {
+		return longs
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := int(offset) + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+
+		tag := order.Uint16(tiff[entry:])
+		typ := order.Uint16(tiff[entry+2:])
+		n := order.Uint32(tiff[entry+4:])
+		value := order.Uint32(tiff[entry+8:])
+
+		switch {
+		case typ == 4 && n == 1: // LONG
+			longs[tag] = value
+		case typ == 2 && (tag == tagDateTime || tag == tagDateTimeOriginal || tag == tagDateDigitized): // ASCII
+			if n > 4 && int64(value)+int64(n) <= int64(len(tiff)) {
+				dates[tag] = strings.TrimRight(string(tiff[value:value+n]), "\x00 ")
+			}
+		}
+	}
+	return longs
+}
+
+// movieTakenAt returns the creation time of the movie header of an MP4 or QuickTime video
+func movieTakenAt(r io.Reader) (time.Time, error) {
+	moov, err := findBox(r, "moov", maxBoxSkip)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	mvhd, err := findBox(moov, "mvhd", maxExifScan)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(mvhd, header); err != nil {
+		return time.Time{}, errNoDate
+	}
+
+	var secs uint64
+	if header[0] == 1 { // version 1 has 64 bit times
+		secs = binary.BigEndian.Uint64(header[4:])
+	} else {
+		secs = uint64(binary.BigEndian.Uint32(header[4:]))
+	}
+	if secs == 0 {
+		return time.Time{}, errNoDate
+	}
+	return quickTimeEpoch.Add(time.Duration(secs) * time.Second), nil
+}
+
+// findBox advances r to the content of the first box of the given type at the current level
+// and returns a reader limited to that content. At most maxSkip bytes of other boxes are read
+// past unless r can seek.
+func findBox(r io.Reader, typ string, maxSkip int64) (io.Reader, error) {
+	var skipped int64
+	header := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return nil, errNoDate
+		}
+
+		size := int64(binary.BigEndian.Uint32(header))
+		headerLen := int64(8)
+		if size == 1 { // 64 bit size follows the type
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return nil, errNoDate
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerLen = 16
+		}
+
+		if string(header[4:8]) == typ {
+			if size == 0 { // box extends to the end of the file
+				return r, nil
+			}
+			return io.LimitReader(r, size-headerLen), nil
+		}
+		if size < headerLen {
+			return nil, errNoDate
+		}
+
+		if err := skip(r, size-headerLen, maxSkip-skipped); err != nil {
+			return nil, err
+		}
+		skipped += size - headerLen
+	}
+}
+
+// skip reads past n bytes of r, seeking when possible and failing if more than limit would have to be read
+func skip(r io.Reader, n, limit int64) error {
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekCurrent)
+		return err
+	}
+	if n > limit {
+		return errNoDate
+	}
+	if _, err := io.CopyN(io.Discard, r, n); err != nil {
+		return errNoDate
+	}
+	return nil
+}
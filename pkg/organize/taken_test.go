@@ -0,0 +1,98 @@
+package organize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jpegWithExif builds a JPEG header holding an EXIF block with a DateTimeOriginal tag
+func jpegWithExif(order binary.ByteOrder, date string) []byte {
+	var tiff bytes.Buffer
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(&tiff, order, uint16(42))
+	binary.Write(&tiff, order, uint32(8)) // IFD0
+
+	// IFD0 at 8: one entry pointing at the EXIF IFD at 26
+	binary.Write(&tiff, order, uint16(1))
+	binary.Write(&tiff, order, []uint16{tagExifIFD, 4})
+	binary.Write(&tiff, order, []uint32{1, 26})
+	binary.Write(&tiff, order, uint32(0))
+
+	// EXIF IFD at 26: DateTimeOriginal stored at 44
+	binary.Write(&tiff, order, uint16(1))
+	binary.Write(&tiff, order, []uint16{tagDateTimeOriginal, 2})
+	binary.Write(&tiff, order, []uint32{uint32(len(date) + 1), 44})
+	binary.Write(&tiff, order, uint32(0))
+	tiff.WriteString(date + "\x00")
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x04, 0x00, 0x00}) // SOI and an empty APP0
+	buf.Write([]byte{0xFF, 0xE1})
+	binary.Write(&buf, binary.BigEndian, uint16(len(segment)+2))
+	buf.Write(segment)
+	buf.Write([]byte{0xFF, 0xDA, 0x00, 0x02})
+	return buf.Bytes()
+}
+
+func box(typ string, content ...[]byte) []byte {
+	body := bytes.Join(content, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(body)+8))
+	return append(append(b, typ...), body...)
+}
+
+func TestJPEGTakenAt(t *testing.T) {
+	want := time.Date(2023, 12, 24, 18, 30, 0, 0, time.UTC)
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		taken, err := TakenAt(bytes.NewReader(jpegWithExif(order, "2023:12:24 18:30:00")), "image/jpeg")
+		require.NoError(t, err)
+		assert.Equal(t, want, taken)
+	}
+
+	_, err := TakenAt(bytes.NewReader([]byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02}), "image/jpeg")
+	assert.Error(t, err)
+
+	_, err = TakenAt(bytes.NewReader(jpegWithExif(binary.BigEndian, "0000:00:00 00:00:00")), "image/jpeg")
+	assert.Error(t, err)
+}
+
+func TestMovieTakenAt(t *testing.T) {
+	want := time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+	secs := uint32(want.Sub(quickTimeEpoch) / time.Second)
+
+	mvhd := binary.BigEndian.AppendUint32(make([]byte, 4), secs) // version 0, flags, creation time
+	mvhd = append(mvhd, make([]byte, 8)...)
+	movie := bytes.Join([][]byte{
+		box("ftyp", []byte("isom")),
+		box("mdat", make([]byte, 1024)),
+		box("moov", box("mvhd", mvhd)),
+	}, nil)
+
+	taken, err := TakenAt(bytes.NewReader(movie), "video/mp4")
+	require.NoError(t, err)
+	assert.Equal(t, want, taken)
+
+	// Readers that cannot seek are read through
+	taken, err = TakenAt(bytes.NewBuffer(movie), "video/quicktime")
+	require.NoError(t, err)
+	assert.Equal(t, want, taken)
+
+	_, err = TakenAt(bytes.NewReader(box("ftyp", []byte("isom"))), "video/mp4")
+	assert.Error(t, err)
+}
+
+func TestIsMedia(t *testing.T) {
+	assert.True(t, IsMedia("image/heic"))
+	assert.True(t, IsMedia("video/mp4"))
+	assert.False(t, IsMedia("application/pdf"))
+}
@@ -0,0 +1,159 @@
+// Package perm decides what users may do with the files of repositories. Every check of WebDAV, the REST
+// API and gRPC goes through Evaluate, or IsOwner for what only owners get, such as direct download links.
+// Evaluate weighs, in order:
+//
+//   - ownership: the owner of a repository may do anything with its files, and is the only one managing it
+//   - shares: a share of a folder or file lets its user view and read what is under it, or only view it
+//     when it is view-only, until it expires. A writable share also lets them write and remove what is
+//     below the shared folder, but not the folder itself.
+//   - read-only mode: no one may change files while the server is read-only
+//   - locks: an immutable file may be read but not overwritten, moved or deleted, even by its owner
+//
+// Users are granted access one by one, so there are no groups or access lists to weigh beyond shares.
+package perm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+)
+
+// Action is what a principal asks to do with a resource
+type Action int
+
+const (
+	Unknown Action = iota
+	Read           // download the original content
+	Write          // create or overwrite
+	Delete         // remove, or move away
+	View           // list and view rendered content, without access to the original
+	Manage         // change the settings of a repository, such as its rules and webhooks
+)
+
+var actionNames = [...]string{"unknown", "read", "write", "delete", "view", "manage"}
+
+func (a Action) String() string {
+	if a < 0 || int(a) >= len(actionNames) {
+		return actionNames[Unknown]
+	}
+	return actionNames[a]
+}
+
+// changes reports whether an action changes files, which read-only mode and locks refuse
+func (a Action) changes() bool {
+	return a == Write || a == Delete
+}
+
+// Principal is who asks for an action
+type Principal struct {
+	UserID int
+}
+
+// User returns the principal of a user
+func User(userID int) Principal {
+	return Principal{UserID: userID}
+}
+
+// IsOwner reports whether the principal owns a repository. No user owns anything.
+func IsOwner(p Principal, repo *model.Repository) bool {
+	return p.UserID != 0 && p.UserID == repo.OwnerID
+}
+
+var (
+	// ErrDenied is returned when nothing grants the principal the action
+	ErrDenied = errors.New("permission denied")
+	// ErrViewOnly is returned when a view-only share is used for anything but viewing
+	ErrViewOnly = errors.New("shared for viewing only")
+	// ErrReadOnly is returned changing files while the server is read-only
+	ErrReadOnly = errors.New("server is read-only")
+)
+
+// readOnly is set when no file may be changed
+var readOnly bool
+
+// Init sets up read-only mode from the configuration
+func Init(cfg *config.Config) {
+	readOnly = cfg.Storage.ReadOnly
+}
+
+// ReadOnly reports whether the server is read-only
+func ReadOnly() bool {
+	return readOnly
+}
+
+// getShare and getFile look up shares and locks, replaced by tests
+var (
+	getShare = db.GetShareForObject
+	getFile  = db.GetFile
+)
+
+// Evaluate returns nil if the principal may take the action on the resource. Otherwise it returns
+// ErrDenied, ErrViewOnly or ErrReadOnly, an error wrapping stor.ErrImmutable for a locked file, or the
+// error looking those up.
+func Evaluate(ctx context.Context, p Principal, res *model.Resource, action Action) error {
+	if err := grant(ctx, p, res, action); err != nil {
+		return err
+	}
+
+	if !action.changes() {
+		return nil
+	}
+	if readOnly {
+		return ErrReadOnly
+	}
+	return checkLock(ctx, res)
+}
+
+// grant checks that ownership or a share allows the action
+func grant(ctx context.Context, p Principal, res *model.Resource, action Action) error {
+	if action == Unknown {
+		return ErrDenied
+	}
+	if IsOwner(p, res.Repo) {
+		return nil // Owner has all permissions
+	}
+	if action == Manage {
+		return ErrDenied
+	}
+
+	share, err := getShare(ctx, p.UserID, res)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case share == nil:
+		return ErrDenied
+	case action == View:
+		return nil // View permission granted by any share
+	case share.ViewOnly:
+		return ErrViewOnly
+	case action == Read:
+		return nil
+	case share.Writable && action.changes() && (action == Write || res.Path != share.Path):
+		return nil // The shared item stays where its owner put it
+	default:
+		return ErrDenied // Other shares do not allow changes
+	}
+}
+
+// checkLock returns an error wrapping stor.ErrImmutable if the resource is an immutable file.
+// Nothing being there yet is no lock.
+func checkLock(ctx context.Context, res *model.Resource) error {
+	file, err := getFile(ctx, res.Repo.ID, res.Path)
+	if stor.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if file.Immutable {
+		return fmt.Errorf("%w: %s", stor.ErrImmutable, file.Path)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package perm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	owner  = 1
+	guest  = 2
+	viewer = 3
+	other  = 4
+	editor = 5
+)
+
+// fakeLookups answers share and lock lookups for a repository owned by owner, shared with guest,
+// for viewing only with viewer, and writable with editor. The file /locked.txt is immutable.
+func fakeLookups(t *testing.T) {
+	origShare, origFile := getShare, getFile
+	t.Cleanup(func() {
+		getShare, getFile = origShare, origFile
+		readOnly = false
+	})
+
+	shares := map[int]*model.Share{
+		guest:  {UserID: guest, Path: "/docs"},
+		viewer: {UserID: viewer, Path: "/docs", ViewOnly: true},
+		editor: {UserID: editor, Path: "/docs", Writable: true},
+	}
+	getShare = func(ctx context.Context, userID int, res *model.Resource) (*model.Share, error) {
+		return shares[userID], nil
+	}
+	getFile = func(ctx context.Context, repoID int, path string) (*model.FileObject, error) {
+		if path == "/locked.txt" {
+			return &model.FileObject{Path: path, Immutable: true}, nil
+		}
+		return nil, sql.ErrNoRows
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	fakeLookups(t)
+	repo := &model.Repository{ID: 10, OwnerID: owner}
+	actions := []Action{Read, Write, Delete, View, Manage}
+
+	tests := []struct {
+		name     string
+		user     int
+		path     string
+		readOnly bool
+		want     []error // by action, in the order of actions
+	}{
+		{"owner", owner, "/docs/a.txt", false, []error{nil, nil, nil, nil, nil}},
+		{"owner read-only", owner, "/docs/a.txt", true, []error{nil, ErrReadOnly, ErrReadOnly, nil, nil}},
+		{"owner locked", owner, "/locked.txt", false, []error{nil, stor.ErrImmutable, stor.ErrImmutable, nil, nil}},
+		{"owner locked read-only", owner, "/locked.txt", true, []error{nil, ErrReadOnly, ErrReadOnly, nil, nil}},
+		{"share", guest, "/docs/a.txt", false, []error{nil, ErrDenied, ErrDenied, nil, ErrDenied}},
+		{"share read-only", guest, "/docs/a.txt", true, []error{nil, ErrDenied, ErrDenied, nil, ErrDenied}},
+		{"writable share", editor, "/docs/a.txt", false, []error{nil, nil, nil, nil, ErrDenied}},
+		{"writable share folder", editor, "/docs", false, []error{nil, nil, ErrDenied, nil, ErrDenied}},
+		{"writable share read-only", editor, "/docs/a.txt", true, []error{nil, ErrReadOnly, ErrReadOnly, nil, ErrDenied}},
+		{"view-only share", viewer, "/docs/a.txt", false, []error{ErrViewOnly, ErrViewOnly, ErrViewOnly, nil, ErrDenied}},
+		{"not shared", other, "/docs/a.txt", false, []error{ErrDenied, ErrDenied, ErrDenied, ErrDenied, ErrDenied}},
+		{"not shared locked", other, "/locked.txt", false, []error{ErrDenied, ErrDenied, ErrDenied, ErrDenied, ErrDenied}},
+		{"anonymous", 0, "/docs/a.txt", false, []error{ErrDenied, ErrDenied, ErrDenied, ErrDenied, ErrDenied}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readOnly = tt.readOnly
+			res := &model.Resource{Repo: repo, Path: tt.path}
+			for i, action := range actions {
+				err := Evaluate(context.Background(), User(tt.user), res, action)
+				if tt.want[i] == nil {
+					assert.NoError(t, err, action.String())
+				} else {
+					assert.ErrorIs(t, err, tt.want[i], action.String())
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateUnknownAction(t *testing.T) {
+	fakeLookups(t)
+	res := &model.Resource{Repo: &model.Repository{OwnerID: owner}, Path: "/docs"}
+	assert.ErrorIs(t, Evaluate(context.Background(), User(owner), res, Unknown), ErrDenied)
+	assert.Equal(t, "unknown", Action(42).String())
+}
+
+func TestIsOwner(t *testing.T) {
+	assert.True(t, IsOwner(User(owner), &model.Repository{OwnerID: owner}))
+	assert.False(t, IsOwner(User(guest), &model.Repository{OwnerID: owner}))
+	assert.False(t, IsOwner(User(0), &model.Repository{}))
+}
+
+func TestEvaluateLookupFailure(t *testing.T) {
+	fakeLookups(t)
+	failure := errors.New("database is down")
+	getShare = func(ctx context.Context, userID int, res *model.Resource) (*model.Share, error) {
+		return nil, failure
+	}
+
+	res := &model.Resource{Repo: &model.Repository{OwnerID: owner}, Path: "/docs"}
+	assert.ErrorIs(t, Evaluate(context.Background(), User(guest), res, Read), failure)
+	// Owners need no share
+	assert.NoError(t, Evaluate(context.Background(), User(owner), res, Read))
+}
@@ -0,0 +1,220 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// "HELLO WORLD" as version 1 with medium error correction
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	ecc := rsRemainder(data, rsDivisor(10))
+	assert.Equal(t, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}, ecc)
+}
+
+func TestFormatBits(t *testing.T) {
+	expected := []string{
+		"101010000010010", "101000100100101", "101111001111100", "101101101001011",
+		"100010111111001", "100000011001110", "100111110010111", "100101010100000",
+	}
+	for mask, bits := range expected {
+		c := newCode(1)
+		c.drawFormatBits(mask)
+		assert.Equal(t, bits, readFormatBits(c), "mask %d", mask)
+	}
+}
+
+func TestVersionBits(t *testing.T) {
+	c := newCode(7)
+	c.drawVersionBits()
+
+	var bits strings.Builder
+	for i := 17; i >= 0; i-- {
+		bits.WriteString(fmt.Sprint(btoi(c.modules[i/3][c.Size-11+i%3])))
+	}
+	assert.Equal(t, "000111110010010100", bits.String())
+}
+
+func TestCapacity(t *testing.T) {
+	// Longest text in bytes each version holds at medium error correction
+	for version, capacity := range map[int]int{1: 14, 2: 26, 3: 42, 4: 62, 5: 84, 10: 213, 20: 666, 40: 2331} {
+		c, err := Encode(strings.Repeat("a", capacity))
+		require.NoError(t, err)
+		assert.Equal(t, version, c.version)
+
+		c, err = Encode(strings.Repeat("a", capacity+1))
+		if version < 40 {
+			require.NoError(t, err)
+			assert.Equal(t, version+1, c.version)
+		} else {
+			assert.ErrorIs(t, err, ErrTooLong)
+		}
+	}
+}
+
+func TestDataModules(t *testing.T) {
+	for version := 1; version <= 40; version++ {
+		c := newCode(version)
+		c.drawFunctionPatterns()
+
+		free := 0
+		for y := range c.Size {
+			for x := range c.Size {
+				if !c.function[y][x] {
+					free++
+				}
+			}
+		}
+		assert.Equal(t, rawModules(version), free, "version %d", version)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, text := range []string{
+		"https://files.example.com/s/abc",
+		"https://files.example.com/s/" + strings.Repeat("x", 32),
+		strings.Repeat("日本語のテキスト", 40),
+	} {
+		c, err := Encode(text)
+		require.NoError(t, err)
+		assert.Equal(t, text, decode(t, c))
+	}
+}
+
+func TestPNG(t *testing.T) {
+	c, err := Encode("https://files.example.com/s/abc")
+	require.NoError(t, err)
+
+	data, err := c.PNG(4)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, (c.Size+8)*4, img.Bounds().Dx())
+
+	// The quiet zone is light, the corner of the top left finder dark
+	r, _, _, _ := img.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0xffff), r)
+	r, _, _, _ = img.At(16, 16).RGBA()
+	assert.Zero(t, r)
+}
+
+// readFormatBits returns both copies of the format bits, most significant first, which must be equal
+func readFormatBits(c *Code) string {
+	var first, second [15]int
+	for i := 0; i <= 5; i++ {
+		first[i] = btoi(c.modules[i][8])
+	}
+	first[6], first[7], first[8] = btoi(c.modules[7][8]), btoi(c.modules[8][8]), btoi(c.modules[8][7])
+	for i := 9; i < 15; i++ {
+		first[i] = btoi(c.modules[8][14-i])
+	}
+	for i := range 8 {
+		second[i] = btoi(c.modules[8][c.Size-1-i])
+	}
+	for i := 8; i < 15; i++ {
+		second[i] = btoi(c.modules[c.Size-15+i][8])
+	}
+	if first != second {
+		return "mismatch"
+	}
+
+	var bits strings.Builder
+	for i := 14; i >= 0; i-- {
+		bits.WriteString(fmt.Sprint(first[i]))
+	}
+	return bits.String()
+}
+
+// decode reads the text of a code the way a reader would, checking the error correction codewords
+func decode(t *testing.T, c *Code) string {
+	format := readFormatBits(c)
+	var bits int
+	_, err := fmt.Sscanf(format, "%b", &bits)
+	require.NoError(t, err, format)
+	bits ^= 0x5412
+	require.Equal(t, formatECC, bits>>13)
+	mask := bits >> 10 & 7
+
+	c.applyMask(mask)
+	defer c.applyMask(mask)
+
+	raw := make([]byte, rawModules(c.version)/8)
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := range c.Size {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = c.Size - 1 - vert
+			}
+			for j := range 2 {
+				if x := right - j; !c.function[y][x] && i < len(raw)*8 {
+					raw[i/8] |= byte(btoi(c.modules[y][x])) << (7 - i%8)
+					i++
+				}
+			}
+		}
+	}
+
+	// Deinterleave the blocks and check their error correction codewords
+	numBlocks, eccLen := eccBlocks[c.version], eccPerBlock[c.version]
+	numShort := numBlocks - len(raw)%numBlocks
+	shortData := len(raw)/numBlocks - eccLen
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= shortData; i++ {
+		for j := range blocks {
+			if i < shortData || j >= numShort {
+				blocks[j] = append(blocks[j], raw[k])
+				k++
+			}
+		}
+	}
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, block...)
+	}
+	eccs := make([][]byte, numBlocks)
+	for range eccLen {
+		for j := range eccs {
+			eccs[j] = append(eccs[j], raw[k])
+			k++
+		}
+	}
+	divisor := rsDivisor(eccLen)
+	for j, block := range blocks {
+		require.Equal(t, rsRemainder(block, divisor), eccs[j], "block %d", j)
+	}
+
+	// Byte mode segment
+	read := func(pos, n int) int {
+		v := 0
+		for i := pos; i < pos+n; i++ {
+			v = v<<1 | int(data[i/8]>>(7-i%8)&1)
+		}
+		return v
+	}
+	require.Equal(t, 0x4, read(0, 4))
+	n := read(4, countBits(c.version))
+	text := make([]byte, n)
+	for i := range text {
+		text[i] = byte(read(4+countBits(c.version)+8*i, 8))
+	}
+	return string(text)
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
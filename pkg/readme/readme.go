@@ -0,0 +1,137 @@
+// Package readme finds the description of a folder, a README or index Markdown file in it,
+// and renders it as HTML safe to show inside the application.
+package readme
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/markdown"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/sanitize"
+	"github.com/cgang/file-hub/pkg/stor"
+)
+
+// MaxSize is the largest readme rendered
+const MaxSize = 1024 * 1024
+
+// Names are the file names taken as the readme of a folder, by preference
+var Names = []string{"README.md", "Readme.md", "readme.md", "README.markdown", "index.md"}
+
+var (
+	// ErrNotFound is returned for a folder without a readme
+	ErrNotFound = errors.New("folder has no readme")
+	// ErrTooLarge is returned for a readme larger than MaxSize
+	ErrTooLarge = errors.New("readme too large to render")
+)
+
+// Info describes the readme of a folder
+type Info struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	ETag string `json:"etag,omitempty"` // SHA-256 of the content
+}
+
+// Rendered is a readme with its HTML
+type Rendered struct {
+	Info
+	HTML string `json:"html"`
+}
+
+// find returns the readme of a folder
+func find(ctx context.Context, repo *model.Repository, dir string) (*model.FileObject, error) {
+	paths := make([]string, len(Names))
+	for i, name := range Names {
+		paths[i] = path.Join("/", dir, name)
+	}
+
+	files, err := db.GetFilesByPaths(ctx, repo.ID, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range paths {
+		for _, file := range files {
+			if file.Path == p && !file.IsDir {
+				return file, nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// read returns the content of a readme
+func read(ctx context.Context, repo *model.Repository, file *model.FileObject) ([]byte, error) {
+	if file.Size > MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	reader, err := stor.OpenFile(ctx, &model.Resource{Repo: repo, Path: file.Path})
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(io.LimitReader(reader, MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > MaxSize {
+		return nil, ErrTooLarge
+	}
+	return content, nil
+}
+
+func hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Describe returns the readme of a folder for listings, nil if it has none.
+// Files stored without a checksum are read to compute one.
+func Describe(ctx context.Context, repo *model.Repository, dir string) (*Info, error) {
+	file, err := find(ctx, repo, dir)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	info := &Info{Name: file.Name, Path: file.Path}
+	if file.Checksum != nil {
+		info.ETag = *file.Checksum
+	} else if content, err := read(ctx, repo, file); err == nil {
+		info.ETag = hash(content)
+	}
+	return info, nil
+}
+
+// Render returns the readme of a folder as sanitized HTML. Links and images go through rewrite,
+// so that relative ones can be pointed at where the files are served.
+func Render(ctx context.Context, repo *model.Repository, dir string, rewrite sanitize.Rewrite) (*Rendered, error) {
+	file, err := find(ctx, repo, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := read(ctx, repo, file)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := sanitize.HTML(&buf, bytes.NewReader([]byte(markdown.ToHTML(string(content)))), rewrite); err != nil {
+		return nil, err
+	}
+
+	return &Rendered{
+		Info: Info{Name: file.Name, Path: file.Path, ETag: hash(content)},
+		HTML: buf.String(),
+	}, nil
+}
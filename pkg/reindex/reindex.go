@@ -0,0 +1,109 @@
+// Package reindex rebuilds what the server derives from the files of a repository, such as after a bulk
+// import straight into its storage left the search index and content types behind.
+package reindex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	gosync "sync"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/search"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/sync"
+)
+
+// Operation names reindex jobs
+const Operation = "reindex"
+
+// batchSize is how many files are fetched at a time
+const batchSize = 500
+
+// ErrRunning is returned when a reindex of the repository is still going on
+var ErrRunning = errors.New("repository is being reindexed already")
+
+var (
+	mu      gosync.Mutex
+	running = make(map[int]bool) // repositories being reindexed, by ID
+)
+
+// Start reindexes a repository as a job of the user asking, whose progress counts the files done:
+// the storage is scanned for files added behind the server's back, the content type of every file is
+// sniffed again from its content, and the text extracted for search is dropped for extraction to redo,
+// in repositories it is enabled for. Thumbnails and previews are rendered from the content on request,
+// so they catch up by themselves.
+func Start(ctx context.Context, repo *model.Repository, userID int) (*sync.Job, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if running[repo.ID] {
+		return nil, fmt.Errorf("%w: %s", ErrRunning, repo.Name)
+	}
+	running[repo.ID] = true
+
+	return sync.StartJob(ctx, Operation, "/", userID, func(ctx context.Context, progress func(done, total int)) error {
+		defer func() {
+			mu.Lock()
+			delete(running, repo.ID)
+			mu.Unlock()
+		}()
+		return run(ctx, repo, progress)
+	}), nil
+}
+
+func run(ctx context.Context, repo *model.Repository, progress func(done, total int)) error {
+	if err := stor.ScanFiles(ctx, repo); err != nil {
+		return fmt.Errorf("failed to scan storage: %w", err)
+	}
+
+	total, err := db.CountRepositoryFiles(ctx, repo.ID)
+	if err != nil {
+		return err
+	}
+	progress(0, total)
+
+	done, afterID := 0, 0
+	for {
+		files, err := db.ListRepositoryFiles(ctx, repo.ID, afterID, batchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			afterID = file.ID
+			if err := retype(ctx, repo, file); err != nil {
+				log.Printf("Failed to detect content type of %s: %s", file.Path, err)
+			}
+			done++
+			progress(done, max(done, total)) // files may be added meanwhile
+		}
+		if len(files) < batchSize {
+			break
+		}
+	}
+
+	dropped, err := db.DeleteRepositoryText(ctx, repo.ID)
+	if err != nil {
+		return err
+	}
+	if dropped > 0 || repo.OCR {
+		search.Wake()
+	}
+
+	log.Printf("Reindexed %d files of repository %s, dropped text of %d", done, repo.Name, dropped)
+	return nil
+}
+
+// retype stores the content type sniffed from a file when it differs from the one recorded
+func retype(ctx context.Context, repo *model.Repository, file *model.FileObject) error {
+	mimeType, err := stor.DetectContentType(ctx, repo, file)
+	if err != nil {
+		return err
+	}
+	if file.MimeType != nil && *file.MimeType == mimeType {
+		return nil
+	}
+	return db.UpdateContentType(ctx, file.ID, mimeType)
+}
@@ -0,0 +1,236 @@
+// Package sanitize makes user supplied HTML and SVG documents safe to show in a browser.
+// Scripts, event handlers, embedded frames and script URLs are removed, and links are passed
+// through a rewrite function so that they can be pointed at safe endpoints.
+package sanitize
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// MaxSourceBytes is the largest document sanitized
+const MaxSourceBytes = 8 << 20
+
+// Rewrite maps a link found in a document to the URL it is served with, or "" to drop it
+type Rewrite func(link string) string
+
+// Supported reports whether documents of a MIME type can be sanitized
+func Supported(mimeType string) bool {
+	switch mimeType {
+	case "text/html", "application/xhtml+xml", "image/svg+xml":
+		return true
+	default:
+		return false
+	}
+}
+
+// Active reports whether a browser may run scripts found in content of a MIME type
+func Active(mimeType string) bool {
+	return Supported(mimeType) || mimeType == "text/xml" || mimeType == "application/xml"
+}
+
+// Document writes the sanitized form of an HTML or SVG document
+func Document(w io.Writer, r io.Reader, mimeType string, rewrite Rewrite) error {
+	r = io.LimitReader(r, MaxSourceBytes)
+	if mimeType == "image/svg+xml" {
+		return SVG(w, r, rewrite)
+	}
+	return HTML(w, r, rewrite)
+}
+
+// droppedElements are removed along with their content
+var droppedElements = map[string]bool{
+	"script": true, "iframe": true, "frame": true, "frameset": true, "object": true, "embed": true,
+	"applet": true, "base": true, "link": true, "meta": true, "noscript": true, "foreignobject": true,
+	"template": true, "portal": true,
+}
+
+// linkAttrs hold URLs, which are checked and rewritten
+var linkAttrs = map[string]bool{
+	"href": true, "src": true, "action": true, "formaction": true, "poster": true, "background": true,
+	"data": true, "cite": true, "longdesc": true, "xlink:href": true,
+}
+
+// animatedAttrs hold values SVG animations give to other attributes
+var animatedAttrs = map[string]bool{"to": true, "from": true, "by": true, "values": true}
+
+// unsafeURL reports whether a link runs code when followed
+func unsafeURL(link string) bool {
+	scheme, _, found := strings.Cut(strings.ToLower(strings.Join(strings.Fields(link), "")), ":")
+	if !found || strings.ContainsAny(scheme, "/?#") {
+		return false // relative link
+	}
+	return scheme == "javascript" || scheme == "vbscript" || scheme == "data" && !strings.HasPrefix(strings.ToLower(link), "data:image/")
+}
+
+// cleanAttrs removes event handlers and unsafe links from attributes and rewrites the others
+func cleanAttrs(attrs []html.Attribute, rewrite Rewrite) []html.Attribute {
+	kept := attrs[:0]
+	for _, attr := range attrs {
+		key := strings.ToLower(attr.Key)
+		if attr.Namespace != "" {
+			key = strings.ToLower(attr.Namespace) + ":" + key
+		}
+
+		switch {
+		case strings.HasPrefix(key, "on") || key == "srcset" || key == "http-equiv":
+			continue
+		case key == "style" && strings.Contains(strings.ToLower(attr.Val), "url("):
+			continue // stylesheets could fetch tracking or script URLs
+		case animatedAttrs[key] && unsafeURL(attr.Val):
+			continue // SVG animations can set a link to a script URL
+		case linkAttrs[key]:
+			if unsafeURL(attr.Val) {
+				continue
+			}
+			if rewrite != nil {
+				if attr.Val = rewrite(attr.Val); attr.Val == "" {
+					continue
+				}
+			}
+		}
+		kept = append(kept, attr)
+	}
+	return kept
+}
+
+// HTML writes the sanitized form of an HTML document. Links leaving the document open in a new context.
+func HTML(w io.Writer, r io.Reader, rewrite Rewrite) error {
+	z := html.NewTokenizer(r)
+	skip := "" // element whose content is being dropped
+	depth := 0
+	inStyle := false
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if err := z.Err(); !errors.Is(err, io.EOF) {
+				return err
+			}
+			return nil
+		}
+
+		tok := z.Token()
+		if skip != "" {
+			switch {
+			case tt == html.StartTagToken && tok.Data == skip:
+				depth++
+			case tt == html.EndTagToken && tok.Data == skip:
+				if depth--; depth == 0 {
+					skip = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.CommentToken, html.DoctypeToken:
+			if tt == html.DoctypeToken {
+				io.WriteString(w, "<!DOCTYPE html>")
+			}
+			continue
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedElements[tok.Data] {
+				if tt == html.StartTagToken {
+					skip, depth = tok.Data, 1
+				}
+				continue
+			}
+			inStyle = tok.Data == "style" && tt == html.StartTagToken
+			tok.Attr = cleanAttrs(tok.Attr, rewrite)
+			if tok.Data == "a" || tok.Data == "form" {
+				tok.Attr = slices.DeleteFunc(tok.Attr, func(attr html.Attribute) bool {
+					return attr.Key == "target" || attr.Key == "rel"
+				})
+				tok.Attr = append(tok.Attr, html.Attribute{Key: "target", Val: "_blank"}, html.Attribute{Key: "rel", Val: "noopener noreferrer"})
+			}
+		case html.EndTagToken:
+			inStyle = false
+			if droppedElements[tok.Data] {
+				continue
+			}
+		case html.TextToken:
+			if inStyle { // raw text, escaping would change the stylesheet
+				io.WriteString(w, tok.Data)
+				continue
+			}
+		}
+
+		if _, err := io.WriteString(w, tok.String()); err != nil {
+			return err
+		}
+	}
+}
+
+// SVG writes the sanitized form of an SVG image
+func SVG(w io.Writer, r io.Reader, rewrite Rewrite) error {
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	skip := 0 // nesting of dropped elements
+	for {
+		tok, err := d.RawToken()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to parse SVG: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if skip > 0 || droppedElements[strings.ToLower(t.Name.Local)] {
+				skip++
+				continue
+			}
+			fmt.Fprintf(w, "<%s", xmlName(t.Name))
+			for _, attr := range cleanAttrs(xmlAttrs(t.Attr), rewrite) {
+				fmt.Fprintf(w, ` %s="`, attrName(attr))
+				xml.EscapeText(w, []byte(attr.Val))
+				io.WriteString(w, `"`)
+			}
+			io.WriteString(w, ">")
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			fmt.Fprintf(w, "</%s>", xmlName(t.Name))
+		case xml.CharData:
+			if skip == 0 {
+				xml.EscapeText(w, t)
+			}
+		case xml.ProcInst:
+			if t.Target == "xml" && skip == 0 {
+				fmt.Fprintf(w, "<?xml %s?>", t.Inst)
+			}
+		}
+		// Comments and directives, such as DOCTYPEs declaring entities, are dropped
+	}
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// xmlAttrs converts SVG attributes for cleanAttrs, keeping their prefix as namespace
+func xmlAttrs(attrs []xml.Attr) []html.Attribute {
+	converted := make([]html.Attribute, len(attrs))
+	for i, attr := range attrs {
+		converted[i] = html.Attribute{Namespace: attr.Name.Space, Key: attr.Name.Local, Val: attr.Value}
+	}
+	return converted
+}
+
+func attrName(attr html.Attribute) string {
+	if attr.Namespace != "" {
+		return attr.Namespace + ":" + attr.Key
+	}
+	return attr.Key
+}
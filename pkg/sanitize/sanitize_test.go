@@ -0,0 +1,74 @@
+package sanitize
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTML(t *testing.T) {
+	src := `<!doctype html><html><head><script>alert(1)</script><style>p > a { color: red }</style></head>` +
+		`<body onload="steal()"><p onclick="x()">Hi <a href="javascript:alert(1)">bad</a> <a href="/docs/a.html" target="_top">root</a>` +
+		` <a href="b.html#top">relative</a></p><iframe src="https://evil.example"><p>inside</p></iframe><!-- note --></body></html>`
+
+	var out strings.Builder
+	require.NoError(t, HTML(&out, strings.NewReader(src), func(link string) string {
+		if strings.HasPrefix(link, "/") {
+			return "/preview" + link
+		}
+		return link
+	}))
+	got := out.String()
+
+	assert.True(t, strings.HasPrefix(got, "<!DOCTYPE html>"))
+	assert.NotContains(t, got, "script")
+	assert.NotContains(t, got, "onload")
+	assert.NotContains(t, got, "onclick")
+	assert.NotContains(t, got, "javascript:")
+	assert.NotContains(t, got, "iframe")
+	assert.NotContains(t, got, "inside")
+	assert.NotContains(t, got, "note")
+	assert.Contains(t, got, "p > a { color: red }")
+	assert.Contains(t, got, `<a href="/preview/docs/a.html" target="_blank" rel="noopener noreferrer">root</a>`)
+	assert.Contains(t, got, `<a href="b.html#top" target="_blank" rel="noopener noreferrer">relative</a>`)
+	assert.Contains(t, got, "<p>Hi ")
+}
+
+func TestSVG(t *testing.T) {
+	src := `<?xml version="1.0"?><!DOCTYPE svg [<!ENTITY x "y">]>` +
+		`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 10 10" onload="alert(1)">` +
+		`<script>alert(2)</script><a xlink:href="javascript:alert(3)"><rect width="10" height="10"/></a>` +
+		`<set attributeName="href" to="javascript:alert(4)"/><foreignObject><body>html</body></foreignObject>` +
+		`<text>1 &lt; 2</text></svg>`
+
+	var out strings.Builder
+	require.NoError(t, SVG(&out, strings.NewReader(src), nil))
+	got := out.String()
+
+	assert.True(t, strings.HasPrefix(got, `<?xml version="1.0"?><svg`))
+	assert.Contains(t, got, `viewBox="0 0 10 10"`)
+	assert.Contains(t, got, `xmlns:xlink="http://www.w3.org/1999/xlink"`)
+	assert.Contains(t, got, `<rect width="10" height="10"></rect>`)
+	assert.Contains(t, got, "<text>1 &lt; 2</text>")
+	assert.NotContains(t, got, "alert")
+	assert.NotContains(t, got, "ENTITY")
+	assert.NotContains(t, got, "foreignObject")
+	assert.NotContains(t, got, "html")
+}
+
+func TestUnsafeURL(t *testing.T) {
+	for link, unsafe := range map[string]bool{
+		"javascript:alert(1)":     true,
+		" JaVa\tScRiPt:alert(1)":  true,
+		"vbscript:msgbox":         true,
+		"data:text/html,<b>":      true,
+		"data:image/png;base64,A": false,
+		"https://example.com/a:b": false,
+		"docs/a:b.html":           false,
+		"?q=javascript:x":         false,
+	} {
+		assert.Equal(t, unsafe, unsafeURL(link), link)
+	}
+}
@@ -0,0 +1,201 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+)
+
+// MaxTextBytes is the most extracted text kept per file
+const MaxTextBytes = 1 << 20
+
+// maxErrorBytes is how much of a failed command's error output is kept
+const maxErrorBytes = 1024
+
+// ocrConfig holds the extraction settings, set by Start
+var ocrConfig config.OCRConfig
+
+// wake asks the extraction loop to look for new files now rather than at its next interval
+var wake = make(chan struct{}, 1)
+
+// Start extracts the text of files in repositories that enable it until ctx is done.
+// A zero concurrency disables extraction.
+func Start(ctx context.Context, cfg *config.Config) {
+	ocrConfig = cfg.OCR
+	if ocrConfig.Concurrency <= 0 || ocrConfig.Interval <= 0 {
+		log.Printf("Text extraction disabled")
+		return
+	}
+	ocrConfig.RepoConcurrency = max(ocrConfig.RepoConcurrency, 1)
+
+	go func() {
+		ticker := time.NewTicker(ocrConfig.Interval)
+		defer ticker.Stop()
+
+		for {
+			for extractPending(ctx) {
+				// keep going while full batches were extracted
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-wake:
+			}
+		}
+	}()
+}
+
+// Wake has files written since the last extraction pass picked up without waiting for the next one
+func Wake() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// commandFor returns the command extracting the text of a MIME type, nil if there is none
+func commandFor(mimeType string) []string {
+	var command []string
+	switch {
+	case mimeType == "application/pdf":
+		command = ocrConfig.PDFCommand
+	case strings.HasPrefix(mimeType, "image/"):
+		command = ocrConfig.ImageCommand
+	}
+	if len(command) == 0 {
+		return nil
+	}
+	return command
+}
+
+// extractPending runs one batch of extractions, bounded overall and per repository.
+// It reports whether the batch was full, in which case more files may be waiting.
+func extractPending(ctx context.Context) bool {
+	batch := ocrConfig.Concurrency * 8
+	files, err := db.ListPendingText(ctx, ocrConfig.MaxSourceBytes, batch)
+	if err != nil {
+		log.Printf("Failed to list files for text extraction: %s", err)
+		return false
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sem := make(chan struct{}, ocrConfig.Concurrency)
+	running := make(map[int]int) // extractions running by repository
+	done := sync.NewCond(&mu)
+
+	for _, file := range files {
+		mu.Lock()
+		for running[file.RepoID] >= ocrConfig.RepoConcurrency {
+			done.Wait()
+		}
+		running[file.RepoID]++
+		mu.Unlock()
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			extractFile(ctx, file)
+
+			<-sem
+			mu.Lock()
+			running[file.RepoID]--
+			done.Broadcast()
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+	return len(files) == batch && ctx.Err() == nil
+}
+
+// extractFile extracts and stores the text of a file. Failures are stored too, so that the file
+// is only retried once it changes.
+func extractFile(ctx context.Context, file *model.FileObject) {
+	repo, err := db.GetRepositoryByID(ctx, file.RepoID)
+	if err != nil {
+		log.Printf("Failed to get repository %d: %s", file.RepoID, err)
+		return
+	}
+
+	text := &model.FileText{FileID: file.ID, RepoID: file.RepoID, ExtractedAt: time.Now()}
+	if text.Content, err = extract(ctx, &model.Resource{Repo: repo, Path: file.Path}, file.ContentType()); ctx.Err() != nil {
+		return // interrupted by shutdown, retried on the next start
+	} else if err != nil {
+		text.Error = err.Error()
+		log.Printf("Failed to extract text of %s: %s", file.Path, err)
+	}
+
+	if err := db.SaveFileText(ctx, text); err != nil {
+		log.Printf("Failed to store text of %s: %s", file.Path, err)
+	}
+}
+
+// extract pipes the content of a file to the extraction command of its MIME type and returns the text it writes
+func extract(ctx context.Context, res *model.Resource, mimeType string) (string, error) {
+	command := commandFor(mimeType)
+	if command == nil {
+		return "", fmt.Errorf("no extraction command for %s", mimeType)
+	}
+
+	reader, err := stor.OpenFile(ctx, res)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	return run(ctx, command, reader)
+}
+
+// run runs an extraction command on input, bounded by the configured timeout
+func run(ctx context.Context, command []string, input io.Reader) (string, error) {
+	if ocrConfig.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ocrConfig.Timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr limitedBuffer
+	stdout.limit, stderr.limit = MaxTextBytes, maxErrorBytes
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = input
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = time.Second // don't wait on children of a killed command holding its output open
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s failed: %w: %s", command[0], err, msg)
+		}
+		return "", fmt.Errorf("%s failed: %w", command[0], err)
+	}
+
+	return strings.TrimSpace(strings.ToValidUTF8(stdout.String(), "")), nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,57 @@
+package search
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandFor(t *testing.T) {
+	ocrConfig = config.OCRConfig{
+		ImageCommand: []string{"tesseract", "stdin", "stdout"},
+		PDFCommand:   []string{"pdftotext", "-", "-"},
+	}
+
+	assert.Equal(t, "tesseract", commandFor("image/png")[0])
+	assert.Equal(t, "pdftotext", commandFor("application/pdf")[0])
+	assert.Nil(t, commandFor("text/plain"))
+
+	ocrConfig.PDFCommand = nil
+	assert.Nil(t, commandFor("application/pdf"))
+}
+
+func TestRun(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell available")
+	}
+	ocrConfig = config.OCRConfig{Timeout: 5 * time.Second}
+	ctx := context.Background()
+
+	text, err := run(ctx, []string{"sh", "-c", "tr a-z A-Z"}, strings.NewReader("  scanned invoice\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "SCANNED INVOICE", text)
+
+	_, err = run(ctx, []string{"sh", "-c", "echo unreadable image >&2; exit 1"}, strings.NewReader(""))
+	assert.ErrorContains(t, err, "unreadable image")
+
+	ocrConfig.Timeout = 10 * time.Millisecond
+	_, err = run(ctx, []string{"sh", "-c", "exec sleep 5"}, strings.NewReader(""))
+	assert.Error(t, err)
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	buf := limitedBuffer{limit: 5}
+	n, err := buf.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	n, _ = buf.Write([]byte("defgh"))
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "abcde", buf.String())
+}
@@ -0,0 +1,55 @@
+// Package search finds the files of a repository by name and by their text.
+// Repositories can opt in to text extraction, which runs OCR on images and reads the text of PDF documents
+// in the background, so that scans can be found by what they say.
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+)
+
+// MaxResults is the most hits a search returns
+const MaxResults = 200
+
+var (
+	// ErrInvalid is returned for an empty query
+	ErrInvalid = errors.New("invalid search")
+	// ErrForbidden is returned switching text extraction without the manage permission on the repository
+	ErrForbidden = errors.New("changing text extraction needs the manage permission on the repository")
+)
+
+// Search returns up to limit files of a repository whose name contains the query or whose extracted text
+// contains all of its words
+func Search(ctx context.Context, repo *model.Repository, query string, limit int) ([]*model.SearchHit, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("%w: empty query", ErrInvalid)
+	}
+	if limit <= 0 || limit > MaxResults {
+		limit = MaxResults
+	}
+	return db.SearchFiles(ctx, repo.ID, query, limit)
+}
+
+// SetOCR enables or disables text extraction for a repository.
+// Enabling it extracts the text of the files already stored too.
+func SetOCR(ctx context.Context, user *model.User, repo *model.Repository, enabled bool) error {
+	if perm.Evaluate(ctx, perm.User(user.ID), &model.Resource{Repo: repo}, perm.Manage) != nil {
+		return ErrForbidden
+	}
+	if err := db.SetRepositoryOCR(ctx, repo.ID, enabled); err != nil {
+		return err
+	}
+
+	repo.OCR = enabled
+	if enabled {
+		Wake()
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Package skeleton fills home repositories with the folders and files of a template repository
+// chosen by the administrators, such as Documents/, Photos/ and a welcome document. New users
+// get them when their home repository is created, and applying the template again to existing
+// users adds what they are missing without touching anything already there.
+package skeleton
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/sync"
+)
+
+// ErrNoTemplate is returned applying the template when none is configured or it does not exist
+var ErrNoTemplate = errors.New("no template repository configured")
+
+// templateRepo is the name of the template repository, set by Init
+var templateRepo string
+
+// Init sets the template repository
+func Init(cfg *config.Config) {
+	templateRepo = cfg.Provision.TemplateRepo
+}
+
+// Result counts what applying the template did to a repository
+type Result struct {
+	Repo    string `json:"repo"`
+	Created int    `json:"created"` // folders and files added
+	Skipped int    `json:"skipped"` // items already there, left as they are
+	Failed  int    `json:"failed"`
+}
+
+// Provision creates the home repository of a new user and fills it from the template, if any.
+// Failing to apply the template is logged, the user can still use their empty repository.
+func Provision(ctx context.Context, user *model.User, rootDir string) error {
+	if err := stor.CreateHomeRepo(ctx, user, rootDir); err != nil {
+		return err
+	}
+	if templateRepo == "" {
+		return nil
+	}
+
+	if _, err := ApplyUser(ctx, user); err != nil {
+		log.Printf("Failed to apply template to home repository of %s: %s", user.Username, err)
+	}
+	return nil
+}
+
+// ApplyUser applies the template to the home repository of a user
+func ApplyUser(ctx context.Context, user *model.User) (*Result, error) {
+	home, err := stor.GetHomeRepo(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home repository of %s: %w", user.Username, err)
+	}
+	return Apply(ctx, home)
+}
+
+// Apply copies the folders and files of the template missing from a repository. Items failing are
+// logged and counted, and those below a folder that failed fail too.
+func Apply(ctx context.Context, repo *model.Repository) (*Result, error) {
+	if templateRepo == "" {
+		return nil, ErrNoTemplate
+	}
+	tmpl, err := stor.GetRepository(ctx, templateRepo)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s not found", ErrNoTemplate, templateRepo)
+	}
+
+	result := &Result{Repo: repo.Name}
+	if tmpl.ID == repo.ID {
+		return result, nil // the template itself
+	}
+
+	root, err := db.GetFile(ctx, tmpl.ID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	err = stor.WalkTree(ctx, root, false, func(item *model.FileObject) error {
+		if item.Path == "" {
+			return nil
+		}
+
+		if _, err := db.GetFile(ctx, repo.ID, item.Path); err == nil {
+			result.Skipped++
+			return nil
+		} else if !stor.IsNotFound(err) {
+			return err
+		}
+
+		if err := copyItem(ctx, tmpl, item, repo); err != nil {
+			log.Printf("Failed to copy template item %s to %s: %s", item.Path, repo.Name, err)
+			result.Failed++
+			return nil
+		}
+		result.Created++
+
+		if err := sync.RecordChange(ctx, repo.ID, "create", item.Path, repo.OwnerID); err != nil {
+			log.Printf("Failed to record creation of %s in %s: %s", item.Path, repo.Name, err)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// copyItem creates a folder of the template in repo, or copies the content of a file
+func copyItem(ctx context.Context, tmpl *model.Repository, item *model.FileObject, repo *model.Repository) error {
+	dest := &model.Resource{Repo: repo, Path: item.Path}
+	if item.IsDir {
+		_, err := stor.CreateDir(ctx, dest, nil)
+		return err
+	}
+
+	reader, err := stor.OpenFile(ctx, &model.Resource{Repo: tmpl, Path: item.Path})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if _, err := stor.ResolveParent(ctx, repo, item.Path, nil); err != nil {
+		return err
+	}
+	_, err = stor.PutFile(ctx, dest, reader)
+	return err
+}
+
+// ApplyAll applies the template to the home repositories of all active users
+func ApplyAll(ctx context.Context) ([]*Result, error) {
+	users, err := db.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*Result
+	for _, user := range users {
+		if !user.IsActive {
+			continue
+		}
+
+		result, err := ApplyUser(ctx, user)
+		if errors.Is(err, ErrNoTemplate) {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("Failed to apply template for %s: %s", user.Username, err)
+			result = &Result{Repo: user.Username, Failed: 1}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,17 @@
+package skeleton
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyWithoutTemplate(t *testing.T) {
+	Init(&config.Config{})
+
+	_, err := Apply(context.Background(), &model.Repository{ID: 1, Name: "alice"})
+	assert.ErrorIs(t, err, ErrNoTemplate)
+}
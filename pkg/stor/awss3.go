@@ -1,21 +1,40 @@
 package stor
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
 	"path"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/cgang/file-hub/pkg/config"
 )
 
+const (
+	defaultS3PartSize = 16 << 20
+	minS3PartSize     = 5 << 20 // smallest part S3 accepts but for the last one
+)
+
 var (
 	s3Client *s3.Client // Shared S3 client instance
+	// s3PartSize is the size of the parts larger files are uploaded in. S3 takes at most 10000 parts,
+	// so it bounds the size of files too.
+	s3PartSize = defaultS3PartSize
 )
 
 func newS3Client(cfg *config.S3Config) *s3.Client {
@@ -30,6 +49,65 @@ func newS3Client(cfg *config.S3Config) *s3.Client {
 
 type s3Storage struct {
 	bucket string
+	object s3ObjectOptions
+}
+
+// s3ObjectOptions are how the objects of a repository are stored
+type s3ObjectOptions struct {
+	sse          types.ServerSideEncryption
+	kmsKeyID     string
+	storageClass types.StorageClass
+}
+
+// s3Defaults are the object options of repositories whose root does not set them
+var s3Defaults s3ObjectOptions
+
+// newS3ObjectOptions returns object options after checking them
+func newS3ObjectOptions(sse, kmsKeyID, storageClass string) (s3ObjectOptions, error) {
+	opts := s3ObjectOptions{
+		sse:          types.ServerSideEncryption(sse),
+		kmsKeyID:     kmsKeyID,
+		storageClass: types.StorageClass(storageClass),
+	}
+	if sse != "" && !slices.Contains(opts.sse.Values(), opts.sse) {
+		return opts, fmt.Errorf("unknown server-side encryption %q", sse)
+	}
+	if kmsKeyID != "" && opts.sse != types.ServerSideEncryptionAwsKms {
+		return opts, fmt.Errorf("KMS key given without %s encryption", types.ServerSideEncryptionAwsKms)
+	}
+	if storageClass != "" && !slices.Contains(opts.storageClass.Values(), opts.storageClass) {
+		return opts, fmt.Errorf("unknown storage class %q", storageClass)
+	}
+	return opts, nil
+}
+
+// newS3Storage returns the storage of a repository rooted at s3://bucket, whose query may set the
+// sse, sse_kms_key_id and storage_class of its objects instead of the configured defaults
+func newS3Storage(u *url.URL) (Storage, error) {
+	query := u.Query()
+	option := func(name, value string) string {
+		if query.Has(name) {
+			return query.Get(name)
+		}
+		return value
+	}
+
+	opts, err := newS3ObjectOptions(
+		option("sse", string(s3Defaults.sse)),
+		option("sse_kms_key_id", s3Defaults.kmsKeyID),
+		option("storage_class", string(s3Defaults.storageClass)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Storage{bucket: u.Host, object: opts}, nil
+}
+
+func (o s3ObjectOptions) kmsKeyIDPtr() *string {
+	if o.kmsKeyID == "" {
+		return nil
+	}
+	return aws.String(o.kmsKeyID)
 }
 
 func hashPrefix(s string) string {
@@ -45,31 +123,111 @@ func (s *s3Storage) getS3Key(repo, name string) string {
 	return path.Join(prefix, repo, name)
 }
 
+// PutFile uploads a file in a single request if it fits in a part, or else through a multipart upload,
+// which is aborted if any part fails so that no parts are left behind
 func (s *s3Storage) PutFile(ctx context.Context, repo, name string, data io.Reader) (*FileMeta, error) {
 	key := s.getS3Key(repo, name)
+	s3Handles.drop(key)
+
+	buf := make([]byte, s3PartSize)
+	n, err := io.ReadFull(data, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:               aws.String(s.bucket),
+			Key:                  aws.String(key),
+			Body:                 bytes.NewReader(buf[:n]),
+			ServerSideEncryption: s.object.sse,
+			SSEKMSKeyId:          s.object.kmsKeyIDPtr(),
+			StorageClass:         s.object.storageClass,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s.uploadedMeta(name, int64(n)), nil
+	} else if err != nil {
+		return nil, err
+	}
 
-	input := &s3.PutObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
-		Body:   data,
+	upload, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(s.bucket),
+		Key:                  aws.String(key),
+		ServerSideEncryption: s.object.sse,
+		SSEKMSKeyId:          s.object.kmsKeyIDPtr(),
+		StorageClass:         s.object.storageClass,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	output, err := s3Client.PutObject(ctx, input)
+	size, err := s.uploadParts(ctx, key, upload.UploadId, data, buf)
 	if err != nil {
+		// The parts are kept, and billed, until the upload is aborted, even when the request is gone
+		_, abortErr := s3Client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      aws.String(key),
+			UploadId: upload.UploadId,
+		})
+		if abortErr != nil {
+			log.Printf("Failed to abort upload of %s: %s", key, abortErr)
+		}
 		return nil, err
 	}
+	return s.uploadedMeta(name, size), nil
+}
+
+// uploadParts uploads the content of a multipart upload, the first part of which is in buf already, and
+// completes it. It returns the size of the content.
+func (s *s3Storage) uploadParts(ctx context.Context, key string, uploadID *string, data io.Reader, buf []byte) (int64, error) {
+	var parts []types.CompletedPart
+	var size int64
+	n := len(buf)
+	for n > 0 {
+		number := aws.Int32(int32(len(parts) + 1))
+		output, err := s3Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(s.bucket),
+			Key:        aws.String(key),
+			UploadId:   uploadID,
+			PartNumber: number,
+			Body:       bytes.NewReader(buf[:n]),
+		})
+		if err != nil {
+			return 0, err
+		}
+		parts = append(parts, types.CompletedPart{ETag: output.ETag, PartNumber: number})
+		size += int64(n)
+
+		// Only the last part may be short, and it may be empty when the content fills the parts
+		if n < len(buf) {
+			break
+		}
+		n, err = io.ReadFull(data, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+	}
 
+	_, err := s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	return size, err
+}
+
+func (s *s3Storage) uploadedMeta(name string, size int64) *FileMeta {
 	return &FileMeta{
 		Name:    path.Base(name),
 		Path:    name,
-		Size:    aws.ToInt64(output.Size),
+		Size:    size,
 		ModTime: time.Now(), // TODO get last modified time
-	}, nil
+	}
 }
 
 // DeleteFile deletes a file or directory from S3
 func (s *s3Storage) DeleteFile(ctx context.Context, repo, name string) error {
 	key := s.getS3Key(repo, name)
+	s3Handles.drop(key)
 
 	_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
@@ -82,11 +240,18 @@ func (s *s3Storage) DeleteFile(ctx context.Context, repo, name string) error {
 	return nil
 }
 
-// OpenFile opens a file for reading
+// OpenFile opens a file for reading. The reader can seek, so that files are served in ranges,
+// and reuses reads of the object left open by earlier readers.
 func (s *s3Storage) OpenFile(ctx context.Context, repo, name string) (io.ReadCloser, error) {
 	key := s.getS3Key(repo, name)
+	obj := &s3Object{ctx: ctx, bucket: s.bucket, key: key}
+	if size, etag, ok := s3Handles.stat(key); ok {
+		obj.size, obj.etag = size, etag
+		return obj, nil
+	}
 
-	output, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+	// Reads outlive the request opening them when they are pooled
+	output, err := s3Client.GetObject(context.WithoutCancel(ctx), &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
 	})
@@ -94,17 +259,48 @@ func (s *s3Storage) OpenFile(ctx context.Context, repo, name string) (io.ReadClo
 		return nil, err
 	}
 
+	obj.size, obj.etag = aws.ToInt64(output.ContentLength), aws.ToString(output.ETag)
+	obj.handle = &s3Handle{key: key, etag: obj.etag, size: obj.size, body: output.Body}
+	return obj, nil
+}
+
+// OpenFileRange reads a range of an object with one ranged request, leaving the reads of OpenFile alone
+func (s *s3Storage) OpenFileRange(ctx context.Context, repo, name string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	rng := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		rng += strconv.FormatInt(offset+length-1, 10)
+	}
+	output, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.getS3Key(repo, name)),
+		Range:  aws.String(rng),
+	})
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+		return io.NopCloser(bytes.NewReader(nil)), nil // starting at or past the end
+	} else if err != nil {
+		return nil, err
+	}
 	return output.Body, nil
 }
 
 func (s *s3Storage) CopyFile(ctx context.Context, repo, srcName, destName string) (*FileMeta, error) {
 	srcKey := s.getS3Key(repo, srcName)
 	destKey := s.getS3Key(repo, destName)
+	s3Handles.drop(destKey)
 
+	// Copies take the options of the repository rather than those of the source object
 	_, err := s3Client.CopyObject(ctx, &s3.CopyObjectInput{
-		Bucket:     aws.String(s.bucket),
-		CopySource: aws.String(path.Join(s.bucket, srcKey)),
-		Key:        aws.String(destKey),
+		Bucket:               aws.String(s.bucket),
+		CopySource:           aws.String(path.Join(s.bucket, srcKey)),
+		Key:                  aws.String(destKey),
+		ServerSideEncryption: s.object.sse,
+		SSEKMSKeyId:          s.object.kmsKeyIDPtr(),
+		StorageClass:         s.object.storageClass,
 	})
 	if err != nil {
 		return nil, err
@@ -123,6 +319,61 @@ func (s *s3Storage) CopyFile(ctx context.Context, repo, srcName, destName string
 	}, nil
 }
 
+// PresignFile returns a request reading or writing the object of a file. Writes carry the object options
+// of the repository in signed headers.
+func (s *s3Storage) PresignFile(ctx context.Context, method, repo, name string, expires time.Duration) (*PresignedRequest, error) {
+	key := s.getS3Key(repo, name)
+	client := s3.NewPresignClient(s3Client, s3.WithPresignExpires(expires))
+
+	var req *v4.PresignedHTTPRequest
+	var err error
+	switch method {
+	case http.MethodGet:
+		req, err = client.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		})
+	case http.MethodPut:
+		req, err = client.PresignPutObject(ctx, &s3.PutObjectInput{
+			Bucket:               aws.String(s.bucket),
+			Key:                  aws.String(key),
+			ServerSideEncryption: s.object.sse,
+			SSEKMSKeyId:          s.object.kmsKeyIDPtr(),
+			StorageClass:         s.object.storageClass,
+		})
+	default:
+		return nil, fmt.Errorf("cannot presign %s requests", method)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := req.SignedHeader.Clone()
+	header.Del("Host") // set by every client from the URL
+	return &PresignedRequest{Method: req.Method, URL: req.URL, Header: header, ExpiresAt: time.Now().Add(expires)}, nil
+}
+
+// StatFile returns the meta of the object of a file, dropping reads of an earlier version left open
+func (s *s3Storage) StatFile(ctx context.Context, repo, name string) (*FileMeta, error) {
+	key := s.getS3Key(repo, name)
+	s3Handles.drop(key)
+
+	output, err := s.headObject(ctx, key)
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return nil, fmt.Errorf("%w: %s", fs.ErrNotExist, key)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &FileMeta{
+		Name:    path.Base(name),
+		Path:    name,
+		Size:    aws.ToInt64(output.ContentLength),
+		ModTime: aws.ToTime(output.LastModified),
+	}, nil
+}
+
 func (s *s3Storage) headObject(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
 	input := &s3.HeadObjectInput{
 		Bucket: aws.String(s.bucket),
@@ -170,6 +421,11 @@ func (s *s3Storage) Scan(ctx context.Context, repo string, visit func(*FileMeta)
 	}
 }
 
+// Capabilities reports that objects are read by range and copied within the bucket
+func (s *s3Storage) Capabilities() Capabilities {
+	return Capabilities{RangeReads: true, ServerSideCopy: true}
+}
+
 func (s *s3Storage) GetContentType(ctx context.Context, repo, name string) (string, error) {
 	key := s.getS3Key(repo, name)
 	input := &s3.HeadObjectInput{
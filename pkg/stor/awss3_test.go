@@ -0,0 +1,298 @@
+package stor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 serves the object and multipart upload operations the storage uses from memory
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	options  map[string]string         // encryption, KMS key and storage class asked for each object or upload
+	uploads  map[string]map[int][]byte // parts of each upload in progress
+	aborted  int
+	failPart int // number of a part to refuse, 0 for none
+}
+
+func newFakeS3(t *testing.T, partSize int) *fakeS3 {
+	f := &fakeS3{objects: map[string][]byte{}, options: map[string]string{}, uploads: map[string]map[int][]byte{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	saved, savedSize := s3Client, s3PartSize
+	s3Client = s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+	})
+	s3PartSize = partSize
+	t.Cleanup(func() { s3Client, s3PartSize = saved, savedSize })
+	return f
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := r.URL.Path
+	query := r.URL.Query()
+	id := query.Get("uploadId")
+	options := strings.Join([]string{
+		r.Header.Get("X-Amz-Server-Side-Encryption"),
+		r.Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"),
+		r.Header.Get("X-Amz-Storage-Class"),
+	}, "|")
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id = fmt.Sprint(len(f.uploads) + f.aborted + 1)
+		f.uploads[id] = map[int][]byte{}
+		f.options[id] = options
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && id != "":
+		var number int
+		fmt.Sscan(query.Get("partNumber"), &number)
+		if number == f.failPart || f.uploads[id] == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "<Error><Code>InvalidPart</Code></Error>")
+			return
+		}
+		f.uploads[id][number], _ = io.ReadAll(r.Body)
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, number))
+	case r.Method == http.MethodPost && id != "":
+		var numbers []int
+		for number := range f.uploads[id] {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		var content []byte
+		for _, number := range numbers {
+			content = append(content, f.uploads[id][number]...)
+		}
+		f.objects[key], f.options[key] = content, f.options[id]
+		delete(f.uploads, id)
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && id != "":
+		delete(f.uploads, id)
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.objects[key], f.options[key] = f.objects["/"+r.Header.Get("X-Amz-Copy-Source")], options
+		fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+	case r.Method == http.MethodPut:
+		f.objects[key], _ = io.ReadAll(r.Body)
+		f.options[key] = options
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		content, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if rng := r.Header.Get("Range"); rng != "" {
+			var start, end int
+			if n, _ := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); n < 2 || end >= len(content) {
+				end = len(content) - 1
+			}
+			if start >= len(content) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				fmt.Fprint(w, "<Error><Code>InvalidRange</Code></Error>")
+				return
+			}
+			content = content[start : end+1]
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		if r.Method == http.MethodGet {
+			w.Write(content)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) objectOptions(s *s3Storage, repo, name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.options["/"+s.bucket+"/"+s.getS3Key(repo, name)]
+}
+
+func (f *fakeS3) object(s *s3Storage, repo, name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return string(f.objects["/"+s.bucket+"/"+s.getS3Key(repo, name)])
+}
+
+func TestS3PutFile(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeS3(t, 4)
+	s := &s3Storage{bucket: "files"}
+
+	for _, content := range []string{"", "abc", "abcd", "abcdefghij", "abcdefgh"} {
+		meta, err := s.PutFile(ctx, "alice", "/file.txt", strings.NewReader(content))
+		require.NoError(t, err, content)
+		assert.Equal(t, int64(len(content)), meta.Size)
+		assert.Equal(t, content, f.object(s, "alice", "/file.txt"))
+	}
+	assert.Empty(t, f.uploads)
+}
+
+func TestS3PutFileAborted(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeS3(t, 4)
+	s := &s3Storage{bucket: "files"}
+
+	// A part refused aborts the upload
+	f.failPart = 2
+	_, err := s.PutFile(ctx, "alice", "/file.txt", strings.NewReader("abcdefghij"))
+	assert.Error(t, err)
+	assert.Equal(t, 1, f.aborted)
+	assert.Empty(t, f.uploads)
+
+	// as does content failing to arrive
+	f.failPart = 0
+	failing := io.MultiReader(strings.NewReader("abcdefgh"), &failingReader{})
+	_, err = s.PutFile(ctx, "alice", "/file.txt", failing)
+	assert.ErrorContains(t, err, "connection reset")
+	assert.Equal(t, 2, f.aborted)
+	assert.Empty(t, f.uploads)
+	assert.Empty(t, f.object(s, "alice", "/file.txt"))
+}
+
+// failingReader fails like a client going away
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("connection reset")
+}
+
+func TestS3ObjectOptions(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeS3(t, 4)
+	saved := s3Defaults
+	t.Cleanup(func() { s3Defaults = saved })
+	s3Defaults = s3ObjectOptions{sse: "AES256", storageClass: "STANDARD_IA"}
+
+	// Repositories take the defaults, unless their root sets options of its own
+	storage, err := newS3Storage(&url.URL{Scheme: "s3", Host: "files"})
+	require.NoError(t, err)
+	s := storage.(*s3Storage)
+	_, err = s.PutFile(ctx, "alice", "/small.txt", strings.NewReader("abc"))
+	require.NoError(t, err)
+	assert.Equal(t, "AES256||STANDARD_IA", f.objectOptions(s, "alice", "/small.txt"))
+
+	root, err := url.Parse("s3://files?sse=aws:kms&sse_kms_key_id=key-1&storage_class=GLACIER_IR")
+	require.NoError(t, err)
+	storage, err = newS3Storage(root)
+	require.NoError(t, err)
+	s = storage.(*s3Storage)
+	_, err = s.PutFile(ctx, "bob", "/large.txt", strings.NewReader("abcdefghij"))
+	require.NoError(t, err)
+	assert.Equal(t, "aws:kms|key-1|GLACIER_IR", f.objectOptions(s, "bob", "/large.txt"))
+
+	_, err = s.CopyFile(ctx, "bob", "/large.txt", "/copy.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "aws:kms|key-1|GLACIER_IR", f.objectOptions(s, "bob", "/copy.txt"))
+	assert.Equal(t, "abcdefghij", f.object(s, "bob", "/copy.txt"))
+
+	// An empty option in the root turns the default off
+	root, err = url.Parse("s3://files?sse=")
+	require.NoError(t, err)
+	storage, err = newS3Storage(root)
+	require.NoError(t, err)
+	assert.Equal(t, s3ObjectOptions{storageClass: "STANDARD_IA"}, storage.(*s3Storage).object)
+}
+
+func TestNewS3ObjectOptions(t *testing.T) {
+	_, err := newS3ObjectOptions("aws:kms", "key-1", "STANDARD_IA")
+	assert.NoError(t, err)
+	_, err = newS3ObjectOptions("", "", "")
+	assert.NoError(t, err)
+
+	_, err = newS3ObjectOptions("rot13", "", "")
+	assert.ErrorContains(t, err, "server-side encryption")
+	_, err = newS3ObjectOptions("AES256", "key-1", "")
+	assert.ErrorContains(t, err, "KMS key")
+	_, err = newS3ObjectOptions("", "", "COLD")
+	assert.ErrorContains(t, err, "storage class")
+
+	root, err := url.Parse("s3://files?storage_class=COLD")
+	require.NoError(t, err)
+	_, err = newS3Storage(root)
+	assert.Error(t, err)
+}
+
+func TestS3Presign(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeS3(t, 4)
+	s := &s3Storage{bucket: "files", object: s3ObjectOptions{sse: "aws:kms", kmsKeyID: "key-1"}}
+
+	// A presigned write carries the object options in headers it must be sent with
+	put, err := s.PresignFile(ctx, http.MethodPut, "alice", "/file.txt", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodPut, put.Method)
+	assert.Contains(t, put.URL, "X-Amz-Expires=60")
+	assert.Equal(t, "aws:kms", put.Header.Get("X-Amz-Server-Side-Encryption"))
+	assert.Empty(t, put.Header.Get("Host"))
+
+	_, err = s.StatFile(ctx, "alice", "/file.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	req, err := http.NewRequest(put.Method, put.URL, strings.NewReader("hello"))
+	require.NoError(t, err)
+	req.Header = put.Header
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "hello", f.object(s, "alice", "/file.txt"))
+	assert.Equal(t, "aws:kms|key-1|", f.objectOptions(s, "alice", "/file.txt"))
+
+	meta, err := s.StatFile(ctx, "alice", "/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), meta.Size)
+	assert.Equal(t, "/file.txt", meta.Path)
+	assert.False(t, meta.ModTime.IsZero())
+
+	get, err := s.PresignFile(ctx, http.MethodGet, "alice", "/file.txt", time.Minute)
+	require.NoError(t, err)
+	resp, err = http.Get(get.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+
+	_, err = s.PresignFile(ctx, http.MethodDelete, "alice", "/file.txt", time.Minute)
+	assert.Error(t, err)
+}
+
+func TestPresignUnsupported(t *testing.T) {
+	ctx := context.Background()
+
+	// Backends without URLs of their own, and content the server encrypts, cannot be presigned
+	for _, storage := range []Storage{
+		&instrumented{&fsStorage{rootDir: t.TempDir()}, "fs"},
+		&instrumented{newTestEncrypted(t, 1), "fs"},
+	} {
+		_, err := storage.(Presigner).PresignFile(ctx, http.MethodGet, "alice", "/file.txt", time.Minute)
+		assert.ErrorIs(t, err, ErrPresignUnsupported)
+		_, err = storage.(Presigner).StatFile(ctx, "alice", "/file.txt")
+		assert.ErrorIs(t, err, ErrPresignUnsupported)
+	}
+}
@@ -0,0 +1,512 @@
+package stor
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+)
+
+const (
+	azureAPIVersion   = "2021-08-06"
+	azureCopyInterval = 500 * time.Millisecond // how often a pending copy is checked
+)
+
+// azureBlockSize is the size of the blocks larger files are uploaded in, a variable for tests
+var azureBlockSize = 8 << 20
+
+var (
+	azClient *azureClient // Shared Azure Blob Storage client instance
+)
+
+// azureClient sends requests to the Blob service of an Azure storage account, signed with its shared key.
+// Only the few operations the storage needs are implemented, on top of the REST API.
+type azureClient struct {
+	account  string
+	key      []byte
+	endpoint string // URL of the Blob service, without trailing slash
+	http     *http.Client
+}
+
+func newAzureClient(cfg *config.AzureConfig) (*azureClient, error) {
+	key, err := base64.StdEncoding.DecodeString(cfg.AccountKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid account key: %w", err)
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", cfg.Account)
+	}
+
+	return &azureClient{
+		account:  cfg.Account,
+		key:      key,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		http:     &http.Client{},
+	}, nil
+}
+
+// azureError is an error answered by the Blob service
+type azureError struct {
+	StatusCode int
+	Code       string // error code of the service, such as BlobNotFound
+}
+
+func (e *azureError) Error() string {
+	return fmt.Sprintf("azure blob storage: %d %s", e.StatusCode, e.Code)
+}
+
+// Is makes missing blobs match fs.ErrNotExist, as missing files do
+func (e *azureError) Is(target error) bool {
+	return target == fs.ErrNotExist && e.StatusCode == http.StatusNotFound
+}
+
+// blobURL returns the URL of a blob, or of the container if name is empty
+func (c *azureClient) blobURL(container, name string) string {
+	u := &url.URL{Path: "/" + container}
+	if name != "" {
+		u.Path += "/" + name
+	}
+	return c.endpoint + u.EscapedPath()
+}
+
+// do sends a request about a blob, returning an azureError for any status but a successful one.
+// body is read length bytes, and may be nil.
+func (c *azureClient) do(ctx context.Context, method, container, name string, query url.Values, header http.Header, body io.Reader, length int64) (*http.Response, error) {
+	target := c.blobURL(container, name)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.ContentLength = length
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	req.Header.Set("Authorization", "SharedKey "+c.account+":"+c.sign(req))
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		return nil, &azureError{StatusCode: resp.StatusCode, Code: resp.Header.Get("x-ms-error-code")}
+	}
+	return resp, nil
+}
+
+// sign returns the Shared Key signature of a request
+func (c *azureClient) sign(req *http.Request) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(c.stringToSign(req)))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// stringToSign returns what the Shared Key signature of a request covers
+func (c *azureClient) stringToSign(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(req.Method + "\n")
+	for _, name := range []string{"Content-Encoding", "Content-Language"} {
+		b.WriteString(req.Header.Get(name) + "\n")
+	}
+	if req.ContentLength > 0 {
+		b.WriteString(strconv.FormatInt(req.ContentLength, 10))
+	}
+	b.WriteString("\n")
+	for _, name := range []string{"Content-MD5", "Content-Type", "Date", "If-Modified-Since", "If-Match", "If-None-Match", "If-Unmodified-Since", "Range"} {
+		b.WriteString(req.Header.Get(name) + "\n")
+	}
+
+	var names []string
+	for name := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		b.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	b.WriteString("/" + c.account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		values := slices.Sorted(slices.Values(query[key]))
+		b.WriteString("\n" + strings.ToLower(key) + ":" + strings.Join(values, ","))
+	}
+	return b.String()
+}
+
+// getAzureClient returns the shared client, or an error if Azure Blob Storage is not configured
+func getAzureClient() (*azureClient, error) {
+	if azClient == nil {
+		return nil, errors.New("azure blob storage not configured")
+	}
+	return azClient, nil
+}
+
+// azureStorage implements Storage with the block blobs of an Azure storage container,
+// named after the repository and file path below an optional prefix
+type azureStorage struct {
+	container string
+	prefix    string
+}
+
+func newAzureStorage(u *url.URL) *azureStorage {
+	return &azureStorage{container: u.Host, prefix: strings.Trim(u.Path, "/")}
+}
+
+// blobName converts a file path to the name of its blob
+func (s *azureStorage) blobName(repo, name string) string {
+	return strings.TrimPrefix(path.Join(s.prefix, repo, path.Clean("/"+name)), "/")
+}
+
+// PutFile uploads a file as a single blob if it fits in a block, or else block by block
+func (s *azureStorage) PutFile(ctx context.Context, repo, name string, data io.Reader) (*FileMeta, error) {
+	client, err := getAzureClient()
+	if err != nil {
+		return nil, err
+	}
+
+	blob := s.blobName(repo, name)
+	header := http.Header{}
+	header.Set("x-ms-blob-content-type", getContentType(path.Ext(name)))
+
+	buf := make([]byte, azureBlockSize)
+	n, err := io.ReadFull(data, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		header.Set("x-ms-blob-type", "BlockBlob")
+		resp, err := client.do(ctx, http.MethodPut, s.container, blob, nil, header, bytes.NewReader(buf[:n]), int64(n))
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return azureFileMeta(name, int64(n), resp.Header), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	var size int64
+	for n > 0 {
+		id := base64.StdEncoding.EncodeToString(fmt.Appendf(nil, "%010d", len(ids)))
+		query := url.Values{"comp": {"block"}, "blockid": {id}}
+		resp, err := client.do(ctx, http.MethodPut, s.container, blob, query, nil, bytes.NewReader(buf[:n]), int64(n))
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		ids, size = append(ids, id), size+int64(n)
+
+		n, err = io.ReadFull(data, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+	}
+
+	var list bytes.Buffer
+	list.WriteString(xml.Header + "<BlockList>")
+	for _, id := range ids {
+		list.WriteString("<Latest>" + id + "</Latest>")
+	}
+	list.WriteString("</BlockList>")
+
+	resp, err := client.do(ctx, http.MethodPut, s.container, blob, url.Values{"comp": {"blocklist"}}, header, &list, int64(list.Len()))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return azureFileMeta(name, size, resp.Header), nil
+}
+
+// azureFileMeta describes a blob written, taking its modification time from the response
+func azureFileMeta(name string, size int64, header http.Header) *FileMeta {
+	modTime, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		modTime = time.Now()
+	}
+	meta := newFileMeta(name, modTime)
+	meta.Size = size
+	return meta
+}
+
+// DeleteFile deletes the blob of a file, succeeding if there is none
+func (s *azureStorage) DeleteFile(ctx context.Context, repo, name string) error {
+	client, err := getAzureClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.do(ctx, http.MethodDelete, s.container, s.blobName(repo, name), nil, nil, nil, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// properties returns the headers describing a blob
+func (s *azureStorage) properties(ctx context.Context, client *azureClient, blob string) (http.Header, error) {
+	resp, err := client.do(ctx, http.MethodHead, s.container, blob, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp.Header, nil
+}
+
+// OpenFile opens a file for reading. The reader can seek, so that files are served in ranges.
+func (s *azureStorage) OpenFile(ctx context.Context, repo, name string) (io.ReadCloser, error) {
+	client, err := getAzureClient()
+	if err != nil {
+		return nil, err
+	}
+
+	blob := s.blobName(repo, name)
+	props, err := s.properties(ctx, client, blob)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := strconv.ParseInt(props.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid size of blob %s: %w", blob, err)
+	}
+	return &azureBlob{ctx: ctx, client: client, container: s.container, name: blob, size: size, etag: props.Get("ETag")}, nil
+}
+
+// OpenFileRange opens a range of a blob, read from its offset on with a ranged request
+func (s *azureStorage) OpenFileRange(ctx context.Context, repo, name string, offset, length int64) (io.ReadCloser, error) {
+	blob, err := s.OpenFile(ctx, repo, name)
+	if err != nil {
+		return nil, err
+	}
+	return openRange(blob, offset, length)
+}
+
+// CopyFile copies a blob on the service side, waiting for the copy to complete
+func (s *azureStorage) CopyFile(ctx context.Context, repo, srcName, destName string) (*FileMeta, error) {
+	client, err := getAzureClient()
+	if err != nil {
+		return nil, err
+	}
+
+	dest := s.blobName(repo, destName)
+	header := http.Header{}
+	header.Set("x-ms-copy-source", client.blobURL(s.container, s.blobName(repo, srcName)))
+	resp, err := client.do(ctx, http.MethodPut, s.container, dest, nil, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	props := resp.Header
+	for {
+		switch status := props.Get("x-ms-copy-status"); status {
+		case "success":
+			if props, err = s.properties(ctx, client, dest); err != nil {
+				return nil, err
+			}
+			size, _ := strconv.ParseInt(props.Get("Content-Length"), 10, 64)
+			return azureFileMeta(destName, size, props), nil
+		case "pending":
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(azureCopyInterval):
+			}
+			if props, err = s.properties(ctx, client, dest); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("copy of %s to %s ended as %q: %s", srcName, destName, status, props.Get("x-ms-copy-status-description"))
+		}
+	}
+}
+
+// azureBlobList is the part of a List Blobs response read
+type azureBlobList struct {
+	Blobs []struct {
+		Name       string `xml:"Name"`
+		Properties struct {
+			LastModified  string `xml:"Last-Modified"`
+			ContentLength int64  `xml:"Content-Length"`
+		} `xml:"Properties"`
+	} `xml:"Blobs>Blob"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+// Scan lists the blobs of a repository. Folders are implied by the names of blobs, each visited once
+// before the first blob in it.
+func (s *azureStorage) Scan(ctx context.Context, repo string, visit func(*FileMeta) error) error {
+	client, err := getAzureClient()
+	if err != nil {
+		return err
+	}
+
+	prefix := s.blobName(repo, "") + "/"
+	seen := map[string]bool{}
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+	for {
+		resp, err := client.do(ctx, http.MethodGet, s.container, "", query, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		var list azureBlobList
+		err = xml.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("invalid blob list: %w", err)
+		}
+
+		for _, blob := range list.Blobs {
+			modTime, err := http.ParseTime(blob.Properties.LastModified)
+			if err != nil {
+				modTime = time.Now()
+			}
+
+			name := "/" + strings.TrimPrefix(blob.Name, prefix)
+			var dirs []string
+			for dir := path.Dir(name); dir != "/" && !seen[dir]; dir = path.Dir(dir) {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+			for i := len(dirs) - 1; i >= 0; i-- {
+				if err := visit(newDirMeta(dirs[i], modTime)); err != nil {
+					return err
+				}
+			}
+
+			meta := newFileMeta(name, modTime)
+			meta.Size = blob.Properties.ContentLength
+			if err := visit(meta); err != nil {
+				return err
+			}
+		}
+
+		if list.NextMarker == "" {
+			return nil
+		}
+		query.Set("marker", list.NextMarker)
+	}
+}
+
+// Capabilities reports that blobs are read by range and copied within the storage account
+func (s *azureStorage) Capabilities() Capabilities {
+	return Capabilities{RangeReads: true, ServerSideCopy: true}
+}
+
+func (s *azureStorage) GetContentType(ctx context.Context, repo, name string) (string, error) {
+	client, err := getAzureClient()
+	if err != nil {
+		return "", err
+	}
+
+	props, err := s.properties(ctx, client, s.blobName(repo, name))
+	if err != nil {
+		return "", err
+	}
+	return props.Get("Content-Type"), nil
+}
+
+// azureBlob reads a blob from its offset on, sending a new ranged request after a seek.
+// Reads fail if the blob changes meanwhile.
+type azureBlob struct {
+	ctx       context.Context
+	client    *azureClient
+	container string
+	name      string
+	size      int64
+	etag      string
+	offset    int64
+	body      io.ReadCloser // response read from offset, nil before the first read and after a seek
+}
+
+func (b *azureBlob) Read(p []byte) (int, error) {
+	if b.offset >= b.size {
+		return 0, io.EOF
+	}
+
+	if b.body == nil {
+		header := http.Header{}
+		header.Set("x-ms-range", fmt.Sprintf("bytes=%d-", b.offset))
+		if b.etag != "" {
+			header.Set("If-Match", b.etag)
+		}
+		resp, err := b.client.do(b.ctx, http.MethodGet, b.container, b.name, nil, header, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+		b.body = resp.Body
+	}
+
+	n, err := b.body.Read(p)
+	b.offset += int64(n)
+	if err == io.EOF && b.offset < b.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (b *azureBlob) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += b.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if offset != b.offset && b.body != nil {
+		b.body.Close()
+		b.body = nil
+	}
+	b.offset = offset
+	return offset, nil
+}
+
+func (b *azureBlob) Close() error {
+	if b.body != nil {
+		b.body.Close()
+		b.body = nil
+	}
+	return nil
+}
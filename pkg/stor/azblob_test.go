@@ -0,0 +1,301 @@
+package stor
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAzure serves the Blob service operations the storage uses from memory,
+// checking that requests are signed with the account key
+type fakeAzure struct {
+	t      *testing.T
+	signer *azureClient
+	mu     sync.Mutex
+	blobs  map[string][]byte
+	types  map[string]string
+	blocks map[string][]byte
+	etags  map[string]int
+}
+
+func newFakeAzure(t *testing.T) (*fakeAzure, *httptest.Server) {
+	key := base64.StdEncoding.EncodeToString([]byte("secret key"))
+	f := &fakeAzure{
+		t:      t,
+		blobs:  map[string][]byte{},
+		types:  map[string]string{},
+		blocks: map[string][]byte{},
+		etags:  map[string]int{},
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	client, err := newAzureClient(&config.AzureConfig{Account: "acct", AccountKey: key, Endpoint: server.URL})
+	require.NoError(t, err)
+	f.signer = client
+
+	saved := azClient
+	azClient = client
+	t.Cleanup(func() { azClient = saved })
+	return f, server
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "SharedKey acct:"+f.signer.sign(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// Blob names are kept with the container
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && query.Get("comp") == "list":
+		f.list(w, name, query)
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		data, _ := io.ReadAll(r.Body)
+		f.blocks[name+"#"+query.Get("blockid")] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		require.NoError(f.t, xml.NewDecoder(r.Body).Decode(&list))
+		var data []byte
+		for _, id := range list.Latest {
+			data = append(data, f.blocks[name+"#"+id]...)
+		}
+		f.store(w, name, data, r.Header.Get("x-ms-blob-content-type"))
+	case r.Method == http.MethodPut && r.Header.Get("x-ms-copy-source") != "":
+		source, _ := url.Parse(r.Header.Get("x-ms-copy-source"))
+		src := strings.TrimPrefix(source.Path, "/")
+		data, ok := f.blobs[src]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "CannotVerifyCopySource")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("x-ms-copy-status", "success")
+		f.store(w, name, slices.Clone(data), f.types[src])
+	case r.Method == http.MethodPut:
+		assert.Equal(f.t, "BlockBlob", r.Header.Get("x-ms-blob-type"))
+		data, _ := io.ReadAll(r.Body)
+		f.store(w, name, data, r.Header.Get("x-ms-blob-content-type"))
+	case r.Method == http.MethodDelete:
+		if _, ok := f.blobs[name]; !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		data, ok := f.blobs[name]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		etag := fmt.Sprintf(`"v%d"`, f.etags[name])
+		if match := r.Header.Get("If-Match"); match != "" && match != etag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		start := 0
+		if rng := r.Header.Get("x-ms-range"); rng != "" {
+			start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", f.types[name])
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)-start))
+		w.Header().Set("Last-Modified", "Mon, 12 Oct 2026 08:00:00 GMT")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(data[start:])
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (f *fakeAzure) store(w http.ResponseWriter, name string, data []byte, contentType string) {
+	f.blobs[name], f.types[name] = data, contentType
+	f.etags[name]++
+	w.Header().Set("Last-Modified", "Mon, 12 Oct 2026 08:00:00 GMT")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// list answers two blobs per page
+func (f *fakeAzure) list(w http.ResponseWriter, container string, query url.Values) {
+	prefix := container + "/" + query.Get("prefix")
+	var names []string
+	for name := range f.blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, strings.TrimPrefix(name, container+"/"))
+		}
+	}
+	slices.Sort(names)
+
+	start, _ := strconv.Atoi(query.Get("marker"))
+	end := min(start+2, len(names))
+	var b strings.Builder
+	b.WriteString("<EnumerationResults><Blobs>")
+	for _, name := range names[start:end] {
+		fmt.Fprintf(&b, "<Blob><Name>%s</Name><Properties><Last-Modified>Mon, 12 Oct 2026 08:00:00 GMT</Last-Modified>"+
+			"<Content-Length>%d</Content-Length></Properties></Blob>", name, len(f.blobs[container+"/"+name]))
+	}
+	b.WriteString("</Blobs><NextMarker>")
+	if end < len(names) {
+		b.WriteString(strconv.Itoa(end))
+	}
+	b.WriteString("</NextMarker></EnumerationResults>")
+	w.Write([]byte(b.String()))
+}
+
+func TestAzureStringToSign(t *testing.T) {
+	client := &azureClient{account: "acct", endpoint: "https://acct.blob.core.windows.net"}
+
+	req, err := http.NewRequest(http.MethodPut, client.blobURL("files", "home/a b.txt")+"?comp=block&blockid=MDA%3D", strings.NewReader("hello"))
+	require.NoError(t, err)
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	req.Header.Set("x-ms-date", "Mon, 12 Oct 2026 08:00:00 GMT")
+	req.Header.Set("If-Match", `"v1"`)
+
+	expected := "PUT\n\n\n5\n\n\n\n\n\"v1\"\n\n\n\n" +
+		"x-ms-date:Mon, 12 Oct 2026 08:00:00 GMT\nx-ms-version:" + azureAPIVersion + "\n" +
+		"/acct/files/home/a%20b.txt\nblockid:MDA=\ncomp:block"
+	assert.Equal(t, expected, client.stringToSign(req))
+}
+
+func TestAzureBlobName(t *testing.T) {
+	assert.Equal(t, "alice/docs/a.txt", newAzureStorage(&url.URL{Host: "files"}).blobName("alice", "/docs/a.txt"))
+	assert.Equal(t, "alice", newAzureStorage(&url.URL{Host: "files"}).blobName("alice", ""))
+	assert.Equal(t, "hub/alice/a.txt", newAzureStorage(&url.URL{Host: "files", Path: "/hub/"}).blobName("alice", "a.txt"))
+}
+
+func TestAzureStorage(t *testing.T) {
+	fake, _ := newFakeAzure(t)
+	ctx := context.Background()
+	storage := newAzureStorage(&url.URL{Host: "files", Path: "/hub"})
+
+	t.Run("Put small file", func(t *testing.T) {
+		meta, err := storage.PutFile(ctx, "alice", "/docs/a.txt", strings.NewReader("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), meta.Size)
+		assert.Equal(t, "a.txt", meta.Name)
+		assert.Equal(t, []byte("hello"), fake.blobs["files/hub/alice/docs/a.txt"])
+
+		ct, err := storage.GetContentType(ctx, "alice", "/docs/a.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain", ct)
+	})
+
+	t.Run("Put file in blocks", func(t *testing.T) {
+		saved := azureBlockSize
+		azureBlockSize = 4
+		defer func() { azureBlockSize = saved }()
+
+		content := "0123456789"
+		meta, err := storage.PutFile(ctx, "alice", "/big.bin", strings.NewReader(content))
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(content)), meta.Size)
+		assert.Equal(t, []byte(content), fake.blobs["files/hub/alice/big.bin"])
+	})
+
+	t.Run("Open and seek", func(t *testing.T) {
+		reader, err := storage.OpenFile(ctx, "alice", "/big.bin")
+		require.NoError(t, err)
+		defer reader.Close()
+
+		buf := make([]byte, 3)
+		_, err = io.ReadFull(reader, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "012", string(buf))
+
+		seeker := reader.(io.Seeker)
+		_, err = seeker.Seek(-2, io.SeekEnd)
+		require.NoError(t, err)
+		rest, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "89", string(rest))
+	})
+
+	t.Run("Read fails after the blob changed", func(t *testing.T) {
+		reader, err := storage.OpenFile(ctx, "alice", "/docs/a.txt")
+		require.NoError(t, err)
+		defer reader.Close()
+
+		_, err = storage.PutFile(ctx, "alice", "/docs/a.txt", strings.NewReader("changed"))
+		require.NoError(t, err)
+		_, err = io.ReadAll(reader)
+		assert.Error(t, err)
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		meta, err := storage.CopyFile(ctx, "alice", "/docs/a.txt", "/docs/b.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len("changed")), meta.Size)
+		assert.Equal(t, "b.txt", meta.Name)
+		assert.Equal(t, []byte("changed"), fake.blobs["files/hub/alice/docs/b.txt"])
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		_, err := storage.PutFile(ctx, "alice", "/docs/deep/c.txt", bytes.NewReader(nil))
+		require.NoError(t, err)
+		_, err = storage.PutFile(ctx, "bob", "/other.txt", strings.NewReader("x"))
+		require.NoError(t, err)
+
+		var visited []string
+		err = storage.Scan(ctx, "alice", func(meta *FileMeta) error {
+			visited = append(visited, fmt.Sprintf("%s:%t:%d", meta.Path, meta.IsDir, meta.Size))
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"/big.bin:false:10",
+			"/docs:true:0",
+			"/docs/a.txt:false:7",
+			"/docs/b.txt:false:7",
+			"/docs/deep:true:0",
+			"/docs/deep/c.txt:false:0",
+		}, visited)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, storage.DeleteFile(ctx, "alice", "/docs/b.txt"))
+		assert.NotContains(t, fake.blobs, "files/hub/alice/docs/b.txt")
+		assert.NoError(t, storage.DeleteFile(ctx, "alice", "/docs/b.txt"))
+
+		_, err := storage.OpenFile(ctx, "alice", "/docs/b.txt")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+}
+
+func TestAzureNotConfigured(t *testing.T) {
+	saved := azClient
+	azClient = nil
+	defer func() { azClient = saved }()
+
+	_, err := newAzureStorage(&url.URL{Host: "files"}).OpenFile(context.Background(), "alice", "a.txt")
+	assert.Error(t, err)
+}
@@ -0,0 +1,135 @@
+package stor
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// A BackendFactory creates the storage of a repository from the URL of its root
+type BackendFactory func(root *url.URL) (Storage, error)
+
+// backend is a registered storage backend, named in metrics by label
+type backend struct {
+	label   string
+	factory BackendFactory
+}
+
+var (
+	backendsMu sync.RWMutex
+	// backends maps the schemes of repository roots to their storage backends
+	backends = map[string]*backend{
+		"s3":    {"s3", newS3Storage},
+		"azure": {"azure", func(u *url.URL) (Storage, error) { return newAzureStorage(u), nil }},
+		"gcs":   {"gcs", func(u *url.URL) (Storage, error) { return newGCSStorage(u), nil }},
+		"file":  {"fs", newFSStorage},
+		"":      {"fs", newFSStorage},
+	}
+)
+
+func newFSStorage(u *url.URL) (Storage, error) {
+	if dedup {
+		return newDedupStorage(u.Path), nil
+	}
+	return &fsStorage{u.Path}, nil
+}
+
+// RegisterBackend adds a storage backend for repository roots with the given URL scheme, for example
+// from the init function of a module providing it. It panics if the scheme is taken or factory is nil.
+func RegisterBackend(scheme string, factory BackendFactory) {
+	if factory == nil {
+		panic("stor: RegisterBackend factory is nil")
+	}
+
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, dup := backends[scheme]; dup {
+		panic("stor: RegisterBackend called twice for scheme " + scheme)
+	}
+	backends[scheme] = &backend{label: scheme, factory: factory}
+}
+
+// getStorage returns the appropriate Storage implementation based on the repository's Root URL,
+// instrumented for metrics, and aware of files moved to cold storage when any cold storage is configured
+func getStorage(repo *model.Repository) (Storage, error) {
+	storage, err := openStorage(repo)
+	if err != nil || len(coldRoots) == 0 {
+		return storage, err
+	}
+	return &tieredStorage{storage, repo}, nil
+}
+
+// openStorage returns the storage of a repository root, instrumented for metrics
+func openStorage(repo *model.Repository) (Storage, error) {
+	u, err := url.Parse(repo.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	backendsMu.RLock()
+	b, ok := backends[u.Scheme]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, errors.New("unsupported storage scheme: " + u.Scheme)
+	}
+
+	storage, err := b.factory(u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s storage: %w", u.Scheme, err)
+	}
+	if encryption != nil {
+		storage = &encryptedStorage{storage, encryption}
+	}
+	return &instrumented{storage, b.label}, nil
+}
+
+// Capabilities describes what a storage backend supports beyond the Storage interface
+type Capabilities struct {
+	RangeReads     bool `json:"range_reads"`      // OpenFile returns an io.ReadSeekCloser
+	ServerSideCopy bool `json:"server_side_copy"` // CopyFile copies without reading the content through the server
+	Links          bool `json:"links"`            // implements Linker
+	ModTimes       bool `json:"mod_times"`        // implements ModTimeSetter
+	RenameRepo     bool `json:"rename_repo"`      // implements Renamer
+}
+
+// CapabilityReporter is implemented by storage backends to declare the capabilities that cannot be told
+// from the interfaces they implement. Backends without it are assumed to have none of those.
+type CapabilityReporter interface {
+	// Capabilities returns RangeReads and ServerSideCopy, the others are filled in from the interfaces
+	Capabilities() Capabilities
+}
+
+// capabilitiesOf returns the capabilities of a storage backend
+func capabilitiesOf(storage Storage) Capabilities {
+	// The wrappers implement every optional interface
+	if tiered, ok := storage.(*tieredStorage); ok {
+		storage = tiered.Storage
+	}
+	if inst, ok := storage.(*instrumented); ok {
+		storage = inst.Storage
+	}
+	if enc, ok := storage.(*encryptedStorage); ok {
+		storage = enc.Storage
+	}
+
+	var caps Capabilities
+	if reporter, ok := storage.(CapabilityReporter); ok {
+		caps = reporter.Capabilities()
+	}
+	_, caps.Links = storage.(Linker)
+	_, caps.ModTimes = storage.(ModTimeSetter)
+	_, caps.RenameRepo = storage.(Renamer)
+	return caps
+}
+
+// GetCapabilities returns the capabilities of the storage backend of a repository
+func GetCapabilities(repo *model.Repository) (Capabilities, error) {
+	storage, err := getStorage(repo)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	return capabilitiesOf(storage), nil
+}
@@ -0,0 +1,62 @@
+package stor
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStorage is a backend registered by tests, which only needs to be told apart
+type memStorage struct {
+	Storage
+	host string
+}
+
+func TestRegisterBackend(t *testing.T) {
+	RegisterBackend("mem", func(u *url.URL) (Storage, error) { return &memStorage{host: u.Host}, nil })
+	t.Cleanup(func() { delete(backends, "mem") })
+
+	storage, err := getStorage(&model.Repository{Root: "mem://cache/files"})
+	require.NoError(t, err)
+	inst, ok := storage.(*instrumented)
+	require.True(t, ok)
+	assert.Equal(t, "mem", inst.backend)
+	assert.Equal(t, &memStorage{host: "cache"}, inst.Storage)
+
+	assert.Panics(t, func() { RegisterBackend("mem", newFSStorage) })
+	assert.Panics(t, func() { RegisterBackend("s3", newFSStorage) })
+	assert.Panics(t, func() { RegisterBackend("nil", nil) })
+
+	_, err = getStorage(&model.Repository{Root: "ftp://server/path"})
+	assert.ErrorContains(t, err, "unsupported storage scheme: ftp")
+}
+
+func TestBuiltinBackends(t *testing.T) {
+	for root, label := range map[string]string{
+		"/tmp/files":          "fs",
+		"file:///tmp/files":   "fs",
+		"s3://bucket/files":   "s3",
+		"azure://files/repos": "azure",
+		"gcs://bucket/files":  "gcs",
+	} {
+		storage, err := getStorage(&model.Repository{Root: root})
+		require.NoError(t, err, root)
+		assert.Equal(t, label, storage.(*instrumented).backend, root)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	caps, err := GetCapabilities(&model.Repository{Root: "/tmp/files"})
+	require.NoError(t, err)
+	assert.Equal(t, Capabilities{RangeReads: true, Links: true, ModTimes: true, RenameRepo: true}, caps)
+
+	caps, err = GetCapabilities(&model.Repository{Root: "s3://bucket/files"})
+	require.NoError(t, err)
+	assert.Equal(t, Capabilities{RangeReads: true, ServerSideCopy: true}, caps)
+
+	// Backends without a report have only the capabilities of the interfaces they implement
+	assert.Equal(t, Capabilities{}, capabilitiesOf(&memStorage{}))
+}
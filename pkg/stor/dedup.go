@@ -0,0 +1,353 @@
+package stor
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cgang/file-hub/pkg/db"
+)
+
+const (
+	// BlobDir is the folder of a dedup storage root holding the content of its files, named by SHA-256
+	BlobDir = ".blobs"
+
+	// pointerMagic starts the files standing in the tree of a repository for the blob holding their content
+	pointerMagic = "filehub-blob sha256:"
+	// maxPointerSize is the most bytes read looking for a pointer
+	maxPointerSize = 128
+)
+
+// dedup is set when files of local storage roots are stored as blobs
+var dedup bool
+
+var (
+	// blobsMu orders counting the references to blobs with adding and removing their files, so that
+	// a blob is never removed while it is being referenced again
+	blobsMu sync.Mutex
+	// pointersMu makes replacing or removing a pointer and reading the one it replaces a single step,
+	// so that each reference is released once
+	pointersMu sync.Mutex
+)
+
+// dedupStorage stores the content of files once, as blobs named by the SHA-256 of the content and shared
+// by all the repositories of its root. The tree of a repository holds small pointer files naming the blob
+// of each file; blobs count their references in the database and are removed with the last pointer.
+// Plain files found in the tree, stored before dedup was enabled, are read as they are.
+//
+// Pointers keep the tree the record of what a repository holds, as for every other backend: the Storage
+// interface stays keyed by path, and ScanFiles can rebuild the file rows from the tree alone. Scan and
+// OpenFile resolve pointers, so nothing reading through the storage ever sees one as content.
+type dedupStorage struct {
+	fsStorage
+	acquire func(ctx context.Context, checksum string, size int64) error
+	release func(ctx context.Context, checksum string) (int, error)
+}
+
+func newDedupStorage(rootDir string) *dedupStorage {
+	return &dedupStorage{fsStorage: fsStorage{rootDir}, acquire: db.AcquireBlob, release: db.ReleaseBlob}
+}
+
+// blobPath returns where the blob of some content is stored
+func (s *dedupStorage) blobPath(checksum string) string {
+	return path.Join(s.rootDir, BlobDir, checksum[:2], checksum)
+}
+
+// pointer names the blob holding the content of a file
+type pointer struct {
+	checksum string
+	size     int64
+}
+
+func (p *pointer) String() string {
+	return fmt.Sprintf("%s%s %d\n", pointerMagic, p.checksum, p.size)
+}
+
+// readPointer returns the pointer stored at fullPath, or nil for a directory or a plain file
+func readPointer(fullPath string) (*pointer, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if st, err := file.Stat(); err != nil {
+		return nil, err
+	} else if st.IsDir() || st.Size() > maxPointerSize {
+		return nil, nil
+	}
+
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	rest, ok := strings.CutPrefix(line, pointerMagic)
+	if !ok {
+		return nil, nil
+	}
+	checksum, size, _ := strings.Cut(strings.TrimSuffix(rest, "\n"), " ")
+	if sum, err := hex.DecodeString(checksum); err != nil || len(sum) != sha256.Size {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return nil, nil
+	}
+	return &pointer{checksum: checksum, size: n}, nil
+}
+
+// writePointer stores a pointer at fullPath, returning the one it replaced if any
+func (s *dedupStorage) writePointer(ctx context.Context, fullPath string, p *pointer) (*pointer, error) {
+	if err := os.MkdirAll(path.Dir(fullPath), 0755); err != nil {
+		return nil, err
+	}
+
+	pointersMu.Lock()
+	defer pointersMu.Unlock()
+	old, err := readPointer(fullPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if _, err := s.putFile(ctx, fullPath, strings.NewReader(p.String())); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// storeBlob writes content to the blob store, returning its pointer with a reference acquired
+func (s *dedupStorage) storeBlob(ctx context.Context, data io.Reader) (*pointer, error) {
+	dir := path.Join(s.rootDir, BlobDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), data)
+	if err == nil {
+		err = file.Chmod(0444)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pointer{checksum: hex.EncodeToString(hash.Sum(nil)), size: size}
+	blobsMu.Lock()
+	defer blobsMu.Unlock()
+	if err := s.acquire(ctx, p.checksum, p.size); err != nil {
+		return nil, err
+	}
+
+	// Content stored already is kept, the upload is removed with the temporary file
+	blob := s.blobPath(p.checksum)
+	if _, err := os.Stat(blob); errors.Is(err, fs.ErrNotExist) {
+		if err = os.MkdirAll(path.Dir(blob), 0755); err == nil {
+			err = os.Rename(file.Name(), blob)
+		}
+		if err != nil {
+			s.dropBlob(ctx, p)
+			return nil, err
+		}
+		syncDir(path.Dir(blob))
+	}
+	return p, nil
+}
+
+// acquireBlob counts another reference to a stored blob
+func (s *dedupStorage) acquireBlob(ctx context.Context, p *pointer) error {
+	blobsMu.Lock()
+	defer blobsMu.Unlock()
+	return s.acquire(ctx, p.checksum, p.size)
+}
+
+// releaseBlob drops a reference to a blob, removing it with the last one. Failures leave the blob behind,
+// which wastes space but loses nothing, so they are only logged.
+func (s *dedupStorage) releaseBlob(ctx context.Context, p *pointer) {
+	if p == nil {
+		return
+	}
+
+	blobsMu.Lock()
+	defer blobsMu.Unlock()
+	s.dropBlob(ctx, p)
+}
+
+// dropBlob releases a blob with blobsMu held
+func (s *dedupStorage) dropBlob(ctx context.Context, p *pointer) {
+	refs, err := s.release(context.WithoutCancel(ctx), p.checksum)
+	if err != nil {
+		log.Printf("Failed to release blob %s: %s", p.checksum, err)
+		return
+	}
+	if refs == 0 {
+		if err := os.Remove(s.blobPath(p.checksum)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to remove blob %s: %s", p.checksum, err)
+		}
+	}
+}
+
+// link makes fullPath point to the blob of p, which has a reference acquired for it
+func (s *dedupStorage) link(ctx context.Context, name, fullPath string, p *pointer) (*FileMeta, error) {
+	old, err := s.writePointer(ctx, fullPath, p)
+	if err != nil {
+		s.releaseBlob(ctx, p)
+		return nil, err
+	}
+	s.releaseBlob(ctx, old)
+
+	st, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileMeta{
+		Name:     path.Base(name),
+		Path:     name,
+		Size:     p.size,
+		ModTime:  st.ModTime(),
+		Checksum: p.checksum,
+	}, nil
+}
+
+func (s *dedupStorage) PutFile(ctx context.Context, repo, name string, data io.Reader) (*FileMeta, error) {
+	p, err := s.storeBlob(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.link(ctx, name, s.getFullPath(repo, name), p)
+}
+
+func (s *dedupStorage) OpenFile(ctx context.Context, repo, name string) (io.ReadCloser, error) {
+	fullPath := s.getFullPath(repo, name)
+	p, err := readPointer(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return os.Open(fullPath)
+	}
+	return os.Open(s.blobPath(p.checksum))
+}
+
+func (s *dedupStorage) OpenFileRange(ctx context.Context, repo, name string, offset, length int64) (io.ReadCloser, error) {
+	file, err := s.OpenFile(ctx, repo, name)
+	if err != nil {
+		return nil, err
+	}
+	return openRange(file, offset, length)
+}
+
+func (s *dedupStorage) DeleteFile(ctx context.Context, repo, name string) error {
+	p, err := s.removePointer(s.getFullPath(repo, name))
+	if err != nil {
+		return err
+	}
+	s.releaseBlob(ctx, p)
+	return nil
+}
+
+// removePointer removes the pointer, or plain file or empty directory, at fullPath, returning the pointer
+func (s *dedupStorage) removePointer(fullPath string) (*pointer, error) {
+	pointersMu.Lock()
+	defer pointersMu.Unlock()
+	p, err := readPointer(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	return p, os.Remove(fullPath)
+}
+
+// CopyFile copies a file by pointing to the blob of the original. Plain files are stored as blobs on the way.
+func (s *dedupStorage) CopyFile(ctx context.Context, repo, srcName, destName string) (*FileMeta, error) {
+	return s.copyFile(ctx, repo, srcName, repo, destName)
+}
+
+// LinkFile makes destName point to the blob of srcName, like a copy
+func (s *dedupStorage) LinkFile(ctx context.Context, srcRepo, srcName, destRepo, destName string) error {
+	_, err := s.copyFile(ctx, srcRepo, srcName, destRepo, destName)
+	return err
+}
+
+func (s *dedupStorage) copyFile(ctx context.Context, srcRepo, srcName, destRepo, destName string) (*FileMeta, error) {
+	srcPath := s.getFullPath(srcRepo, srcName)
+	p, err := readPointer(srcPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if p != nil {
+		err = s.acquireBlob(ctx, p)
+	} else {
+		p, err = s.storePlain(ctx, srcPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.link(ctx, destName, s.getFullPath(destRepo, destName), p)
+}
+
+// storePlain stores the content of a plain file as a blob
+func (s *dedupStorage) storePlain(ctx context.Context, fullPath string) (*pointer, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return s.storeBlob(ctx, file)
+}
+
+// Scan reports files with the size and checksum of their blobs. Pointers to a blob that is gone are left
+// out and logged, rather than reported as files holding the pointer itself.
+func (s *dedupStorage) Scan(ctx context.Context, repo string, visit func(*FileMeta) error) error {
+	return s.fsStorage.Scan(ctx, repo, func(meta *FileMeta) error {
+		if !meta.IsDir {
+			p, err := readPointer(filepath.Join(s.getFullPath(repo, ""), meta.Path))
+			if err != nil {
+				return err
+			}
+			if p != nil {
+				if _, err := os.Stat(s.blobPath(p.checksum)); errors.Is(err, fs.ErrNotExist) {
+					log.Printf("Skipping %s:%s, its blob %s is missing", repo, meta.Path, p.checksum)
+					return nil
+				} else if err != nil {
+					return err
+				}
+				meta.Size, meta.Checksum = p.size, p.checksum
+			}
+		}
+		return visit(meta)
+	})
+}
+
+// Capabilities reports that blobs are opened seekable, and files copied without copying their content
+func (s *dedupStorage) Capabilities() Capabilities {
+	return Capabilities{RangeReads: true, ServerSideCopy: true}
+}
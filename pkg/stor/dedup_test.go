@@ -0,0 +1,172 @@
+package stor
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDedup returns a dedup storage counting blob references in memory
+func newTestDedup(t *testing.T) (*dedupStorage, map[string]int) {
+	refs := map[string]int{}
+	s := newDedupStorage(t.TempDir())
+	s.acquire = func(ctx context.Context, checksum string, size int64) error {
+		refs[checksum]++
+		return nil
+	}
+	s.release = func(ctx context.Context, checksum string) (int, error) {
+		if refs[checksum] == 0 {
+			return 0, sql.ErrNoRows
+		}
+		refs[checksum]--
+		if refs[checksum] == 0 {
+			delete(refs, checksum)
+		}
+		return refs[checksum], nil
+	}
+	return s, refs
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func readAll(t *testing.T, s Storage, repo, name string) string {
+	reader, err := s.OpenFile(context.Background(), repo, name)
+	require.NoError(t, err)
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestDedupStorage(t *testing.T) {
+	ctx := context.Background()
+	s, refs := newTestDedup(t)
+	report, other := sha256Hex("quarterly report"), sha256Hex("draft")
+
+	meta, err := s.PutFile(ctx, "alice", "/report.pdf", strings.NewReader("quarterly report"))
+	require.NoError(t, err)
+	assert.Equal(t, report, meta.Checksum)
+	assert.Equal(t, int64(16), meta.Size)
+
+	// The same content uploaded elsewhere is stored once
+	_, err = s.PutFile(ctx, "bob", "/inbox/report.pdf", strings.NewReader("quarterly report"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{report: 2}, refs)
+	assert.Equal(t, "quarterly report", readAll(t, s, "bob", "/inbox/report.pdf"))
+	entries, err := os.ReadDir(path.Join(s.rootDir, BlobDir, report[:2]))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// Copies point to the same blob
+	meta, err = s.CopyFile(ctx, "alice", "/report.pdf", "/copy.pdf")
+	require.NoError(t, err)
+	assert.Equal(t, report, meta.Checksum)
+	assert.Equal(t, 3, refs[report])
+
+	// Overwriting releases the content replaced
+	_, err = s.PutFile(ctx, "alice", "/copy.pdf", strings.NewReader("draft"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{report: 2, other: 1}, refs)
+
+	require.NoError(t, s.DeleteFile(ctx, "alice", "/report.pdf"))
+	require.NoError(t, s.DeleteFile(ctx, "bob", "/inbox/report.pdf"))
+	assert.Equal(t, map[string]int{other: 1}, refs)
+	assert.NoFileExists(t, s.blobPath(report))
+	assert.FileExists(t, s.blobPath(other))
+
+	// Empty directories are removed like any other storage
+	require.NoError(t, s.DeleteFile(ctx, "bob", "/inbox"))
+	assert.Error(t, s.DeleteFile(ctx, "bob", "/missing.txt"))
+}
+
+func TestDedupPlainFiles(t *testing.T) {
+	ctx := context.Background()
+	s, refs := newTestDedup(t)
+
+	// A file stored before dedup was enabled is read as it is, and stored as a blob once copied
+	plain := s.getFullPath("alice", "/old.txt")
+	require.NoError(t, os.MkdirAll(path.Dir(plain), 0755))
+	require.NoError(t, os.WriteFile(plain, []byte("legacy"), 0644))
+	assert.Equal(t, "legacy", readAll(t, s, "alice", "/old.txt"))
+
+	meta, err := s.CopyFile(ctx, "alice", "/old.txt", "/new.txt")
+	require.NoError(t, err)
+	assert.Equal(t, sha256Hex("legacy"), meta.Checksum)
+	assert.Equal(t, map[string]int{sha256Hex("legacy"): 1}, refs)
+	assert.Equal(t, "legacy", readAll(t, s, "alice", "/new.txt"))
+
+	// Deleting the plain file releases nothing
+	require.NoError(t, s.DeleteFile(ctx, "alice", "/old.txt"))
+	assert.Equal(t, map[string]int{sha256Hex("legacy"): 1}, refs)
+}
+
+func TestDedupScan(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestDedup(t)
+	_, err := s.PutFile(ctx, "alice", "/docs/a.txt", strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	var files []*FileMeta
+	require.NoError(t, s.Scan(ctx, "alice", func(meta *FileMeta) error {
+		if !meta.IsDir {
+			files = append(files, meta)
+		}
+		return nil
+	}))
+	require.Len(t, files, 1)
+	assert.Equal(t, "/docs/a.txt", files[0].Path)
+	assert.Equal(t, int64(5), files[0].Size)
+	assert.Equal(t, sha256Hex("hello"), files[0].Checksum)
+
+	// A pointer whose blob is gone is not reported as a file holding the pointer
+	require.NoError(t, os.Remove(s.blobPath(sha256Hex("hello"))))
+	files = nil
+	require.NoError(t, s.Scan(ctx, "alice", func(meta *FileMeta) error {
+		if !meta.IsDir {
+			files = append(files, meta)
+		}
+		return nil
+	}))
+	assert.Empty(t, files)
+}
+
+func TestReadPointer(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		name := path.Join(dir, sha256Hex(content))
+		require.NoError(t, os.WriteFile(name, []byte(content), 0644))
+		return name
+	}
+
+	sum := sha256Hex("x")
+	p, err := readPointer(write(pointerMagic + sum + " 1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, &pointer{checksum: sum, size: 1}, p)
+
+	for _, content := range []string{
+		"plain text",
+		pointerMagic + "abc 1\n",
+		pointerMagic + sum + " -1\n",
+		pointerMagic + sum + " 1\n" + strings.Repeat("x", maxPointerSize),
+	} {
+		p, err := readPointer(write(content))
+		require.NoError(t, err)
+		assert.Nil(t, p, content)
+	}
+
+	p, err = readPointer(dir)
+	require.NoError(t, err)
+	assert.Nil(t, p)
+}
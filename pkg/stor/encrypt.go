@@ -0,0 +1,454 @@
+package stor
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+// Layout of encrypted files: a header holding the key of the file wrapped by a master key, then the
+// content in segments sealed with AES-GCM under that key. Each segment is numbered in its nonce and the
+// last one is marked, so that segments cannot be reordered, and a truncated file does not pass for whole.
+const (
+	encMagic          = "FHE1"
+	fingerprintSize   = 4 // first bytes of the SHA-256 of the master key a file key is wrapped by
+	fileKeySize       = 32
+	gcmNonceSize      = 12
+	gcmTagSize        = 16
+	encHeaderSize     = len(encMagic) + fingerprintSize + gcmNonceSize + fileKeySize + gcmTagSize
+	segmentSize       = 64 << 10
+	sealedSegmentSize = segmentSize + gcmTagSize
+)
+
+var (
+	// ErrUnknownKey is returned opening a file encrypted with a master key that is no longer configured
+	ErrUnknownKey = errors.New("file is encrypted with an unknown master key")
+	// ErrCorrupt is returned reading an encrypted file that was damaged or tampered with
+	ErrCorrupt = errors.New("encrypted file is corrupt")
+)
+
+// encryption holds the master keys when files are encrypted at rest, nil otherwise
+var encryption *keyring
+
+// masterKey is a configured master key, told apart in file headers by its fingerprint
+type masterKey struct {
+	fingerprint []byte
+	aead        cipher.AEAD
+}
+
+// keyring holds the master keys, the first of them wrapping the keys of new files
+type keyring struct {
+	keys []*masterKey
+}
+
+func newKeyring(keys [][]byte) (*keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no master keys")
+	}
+
+	ring := &keyring{}
+	for _, key := range keys {
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		ring.keys = append(ring.keys, &masterKey{fingerprint: sum[:fingerprintSize], aead: aead})
+	}
+	return ring, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newFileKey returns a new random file key and the header storing it wrapped by the current master key
+func (k *keyring) newFileKey() (cipher.AEAD, []byte, error) {
+	key := make([]byte, fileKeySize)
+	nonce := make([]byte, gcmNonceSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	current := k.keys[0]
+	header := make([]byte, 0, encHeaderSize)
+	header = append(header, encMagic...)
+	header = append(header, current.fingerprint...)
+	header = append(header, nonce...)
+	header = current.aead.Seal(header, nonce, key, header[:len(encMagic)+fingerprintSize])
+
+	aead, err := newGCM(key)
+	return aead, header, err
+}
+
+// openFileKey unwraps the file key stored in a header
+func (k *keyring) openFileKey(header []byte) (cipher.AEAD, error) {
+	fingerprint := header[len(encMagic) : len(encMagic)+fingerprintSize]
+	for _, master := range k.keys {
+		if !bytes.Equal(master.fingerprint, fingerprint) {
+			continue
+		}
+
+		nonceEnd := len(encMagic) + fingerprintSize + gcmNonceSize
+		key, err := master.aead.Open(nil, header[nonceEnd-gcmNonceSize:nonceEnd], header[nonceEnd:], header[:len(encMagic)+fingerprintSize])
+		if err != nil {
+			return nil, ErrCorrupt
+		}
+		return newGCM(key)
+	}
+	return nil, ErrUnknownKey
+}
+
+// segmentNonce returns the nonce of a segment. File keys encrypt a single file, so counting is enough.
+func segmentNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, gcmNonceSize)
+	binary.BigEndian.PutUint64(nonce, index)
+	if last {
+		nonce[gcmNonceSize-1] = 1
+	}
+	return nonce
+}
+
+// plainSize returns the size of the content of an encrypted file of the given size, -1 if none could be
+func plainSize(size int64) int64 {
+	body := size - int64(encHeaderSize)
+	if body < gcmTagSize {
+		return -1
+	}
+	segments := (body + sealedSegmentSize - 1) / sealedSegmentSize
+	return body - segments*gcmTagSize
+}
+
+// encryptReader reads a file as it is stored encrypted, keeping the size and SHA-256 of its content
+type encryptReader struct {
+	src   io.Reader
+	aead  cipher.AEAD
+	hash  hash.Hash
+	size  int64
+	out   []byte // encrypted bytes not read yet
+	next  []byte // content read ahead to tell whether the current segment is the last
+	index uint64
+	done  bool
+}
+
+func newEncryptReader(src io.Reader, ring *keyring) (*encryptReader, error) {
+	aead, header, err := ring.newFileKey()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &encryptReader{src: src, aead: aead, hash: sha256.New(), out: header}
+	if r.next, err = r.readSegment(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *encryptReader) readSegment() ([]byte, error) {
+	buf := make([]byte, segmentSize, sealedSegmentSize) // sealed in place
+	n, err := io.ReadFull(r.src, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	}
+	r.hash.Write(buf[:n])
+	r.size += int64(n)
+	return buf[:n], err
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		segment := r.next
+		r.next = nil
+		if len(segment) == segmentSize {
+			var err error
+			if r.next, err = r.readSegment(); err != nil {
+				return 0, err
+			}
+		}
+		last := len(r.next) == 0
+		r.out = r.aead.Seal(segment[:0], segmentNonce(r.index, last), segment, nil)
+		r.index++
+		r.done = last
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// checksum returns the hex SHA-256 of the content read so far
+func (r *encryptReader) checksum() string {
+	return hex.EncodeToString(r.hash.Sum(nil))
+}
+
+// decryptReader reads the content of an encrypted file
+type decryptReader struct {
+	src   io.ReadCloser
+	aead  cipher.AEAD
+	plain []byte // content of the current segment not read yet
+	index uint64 // of the next segment
+	done  bool
+	pos   int64
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.readSegment(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *decryptReader) readSegment() error {
+	buf := make([]byte, sealedSegmentSize)
+	n, err := io.ReadFull(r.src, buf)
+	if errors.Is(err, io.EOF) {
+		return ErrCorrupt // the last segment is missing
+	} else if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	// Only the last segment may be short, but a full one may be the last too. A failed Open may
+	// overwrite its destination, so the segment is not opened in place.
+	last := n < sealedSegmentSize
+	plain, err := r.aead.Open(nil, segmentNonce(r.index, last), buf[:n], nil)
+	if err != nil && !last {
+		last = true
+		plain, err = r.aead.Open(nil, segmentNonce(r.index, last), buf[:n], nil)
+	}
+	if err != nil {
+		return ErrCorrupt
+	}
+
+	r.plain = plain
+	r.index++
+	r.done = last
+	return nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.src.Close()
+}
+
+// seekableDecryptReader seeks within the content of an encrypted file stored seekable, decrypting the
+// segment it lands in
+type seekableDecryptReader struct {
+	*decryptReader
+	seeker io.Seeker
+}
+
+func (r *seekableDecryptReader) Seek(offset int64, whence int) (int64, error) {
+	seeker := r.seeker
+	stored, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	size := plainSize(stored)
+	if size < 0 {
+		return 0, ErrCorrupt
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	r.plain, r.pos = nil, offset
+	if offset >= size {
+		r.done = true
+		return offset, nil
+	}
+
+	r.index = uint64(offset / segmentSize)
+	if _, err := seeker.Seek(int64(encHeaderSize)+int64(r.index)*sealedSegmentSize, io.SeekStart); err != nil {
+		return 0, err
+	}
+	r.done = false
+	if err := r.readSegment(); err != nil {
+		return 0, err
+	}
+	r.plain = r.plain[offset%segmentSize:]
+	return offset, nil
+}
+
+// encryptedStorage encrypts the content of files before handing them to the backend it wraps, each file
+// under a key of its own wrapped by the master key. Files stored before encryption was enabled are read
+// as they are, and encrypted when next written.
+type encryptedStorage struct {
+	Storage
+	keys *keyring
+}
+
+// PutFile stores the content encrypted, reporting the size and checksum of the content
+func (s *encryptedStorage) PutFile(ctx context.Context, repo, name string, data io.Reader) (*FileMeta, error) {
+	enc, err := newEncryptReader(data, s.keys)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := s.Storage.PutFile(ctx, repo, name, enc)
+	if err != nil {
+		return nil, err
+	}
+	meta.Size, meta.Checksum = enc.size, enc.checksum()
+	return meta, nil
+}
+
+// OpenFile returns the decrypted content of a file, which can be seeked when the stored file can be
+func (s *encryptedStorage) OpenFile(ctx context.Context, repo, name string) (io.ReadCloser, error) {
+	reader, err := s.Storage.OpenFile(ctx, repo, name)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, encHeaderSize)
+	n, err := io.ReadFull(reader, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		reader.Close()
+		return nil, err
+	}
+	if n < encHeaderSize || string(header[:len(encMagic)]) != encMagic {
+		return unreadHeader(reader, header[:n])
+	}
+
+	aead, err := s.keys.openFileKey(header)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("%w: %s/%s", err, repo, name)
+	}
+	dec := &decryptReader{src: reader, aead: aead}
+	if seeker, ok := reader.(io.Seeker); ok {
+		return &seekableDecryptReader{decryptReader: dec, seeker: seeker}, nil
+	}
+	return dec, nil
+}
+
+// OpenFileRange decrypts the segments the range is in, seeking to the first of them when the stored file can
+func (s *encryptedStorage) OpenFileRange(ctx context.Context, repo, name string, offset, length int64) (io.ReadCloser, error) {
+	reader, err := s.OpenFile(ctx, repo, name)
+	if err != nil {
+		return nil, err
+	}
+	return openRange(reader, offset, length)
+}
+
+// unreadHeader returns a plain file as if the bytes read looking for a header were not
+func unreadHeader(reader io.ReadCloser, head []byte) (io.ReadCloser, error) {
+	if seeker, ok := reader.(io.ReadSeekCloser); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			reader.Close()
+			return nil, err
+		}
+		return seeker, nil
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), reader), reader}, nil
+}
+
+// plainMeta fills in the size of the content of a stored file, reading its header. The checksum
+// the backend knows is that of the encrypted file, so it is left out.
+func (s *encryptedStorage) plainMeta(ctx context.Context, repo string, meta *FileMeta) error {
+	reader, err := s.Storage.OpenFile(ctx, repo, meta.Path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	magic := make([]byte, len(encMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != encMagic {
+		return nil // a plain file
+	}
+	if size := plainSize(meta.Size); size >= 0 {
+		meta.Size, meta.Checksum = size, ""
+	}
+	return nil
+}
+
+// CopyFile copies the encrypted file, which carries its key along
+func (s *encryptedStorage) CopyFile(ctx context.Context, repo, srcName, destName string) (*FileMeta, error) {
+	meta, err := s.Storage.CopyFile(ctx, repo, srcName, destName)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Path == "" {
+		meta.Path = destName
+	}
+	return meta, s.plainMeta(ctx, repo, meta)
+}
+
+// Scan reports files with the size of their content, reading the header of each
+func (s *encryptedStorage) Scan(ctx context.Context, repo string, visit func(*FileMeta) error) error {
+	return s.Storage.Scan(ctx, repo, func(meta *FileMeta) error {
+		if !meta.IsDir {
+			if err := s.plainMeta(ctx, repo, meta); err != nil {
+				return err
+			}
+		}
+		return visit(meta)
+	})
+}
+
+// LinkFile shares the encrypted content, which carries its key along
+func (s *encryptedStorage) LinkFile(ctx context.Context, srcRepo, srcName, destRepo, destName string) error {
+	linker, ok := s.Storage.(Linker)
+	if !ok {
+		return ErrLinkUnsupported
+	}
+	return linker.LinkFile(ctx, srcRepo, srcName, destRepo, destName)
+}
+
+func (s *encryptedStorage) SetModTime(ctx context.Context, repo, name string, modTime time.Time) error {
+	setter, ok := s.Storage.(ModTimeSetter)
+	if !ok {
+		return errModTimeUnsupported
+	}
+	return setter.SetModTime(ctx, repo, name, modTime)
+}
+
+func (s *encryptedStorage) RenameRepo(ctx context.Context, repo, name string) error {
+	renamer, ok := s.Storage.(Renamer)
+	if !ok {
+		return ErrRenameUnsupported
+	}
+	return renamer.RenameRepo(ctx, repo, name)
+}
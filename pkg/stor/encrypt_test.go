@@ -0,0 +1,187 @@
+package stor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestEncrypted returns an encrypted storage over the filesystem, with master keys made of one
+// repeated byte each
+func newTestEncrypted(t *testing.T, keys ...byte) *encryptedStorage {
+	ring, err := newKeyring(masterKeys(keys...))
+	require.NoError(t, err)
+	return &encryptedStorage{Storage: &fsStorage{rootDir: t.TempDir()}, keys: ring}
+}
+
+func masterKeys(keys ...byte) [][]byte {
+	var out [][]byte
+	for _, key := range keys {
+		out = append(out, bytes.Repeat([]byte{key}, 32))
+	}
+	return out
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	ctx := context.Background()
+	s := newTestEncrypted(t, 1)
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3 * segmentSize} {
+		content := strings.Repeat("x", size)
+		meta, err := s.PutFile(ctx, "alice", "/file.bin", strings.NewReader(content))
+		require.NoError(t, err, size)
+		assert.Equal(t, int64(size), meta.Size)
+		assert.Equal(t, sha256Hex(content), meta.Checksum)
+		assert.Equal(t, content, readAll(t, s, "alice", "/file.bin"), size)
+
+		// The content is stored encrypted
+		stored, err := os.ReadFile(s.Storage.(*fsStorage).getFullPath("alice", "/file.bin"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(size), plainSize(int64(len(stored))))
+		if size >= 16 {
+			assert.NotContains(t, string(stored), strings.Repeat("x", 16))
+		}
+	}
+}
+
+func TestEncryptedSeek(t *testing.T) {
+	ctx := context.Background()
+	s := newTestEncrypted(t, 1)
+	content := make([]byte, 2*segmentSize+100)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	_, err := s.PutFile(ctx, "alice", "/file.bin", bytes.NewReader(content))
+	require.NoError(t, err)
+
+	reader, err := s.OpenFile(ctx, "alice", "/file.bin")
+	require.NoError(t, err)
+	defer reader.Close()
+	seeker := reader.(io.ReadSeeker)
+
+	for _, offset := range []int64{segmentSize + 10, 5, segmentSize, int64(len(content)) - 1} {
+		pos, err := seeker.Seek(offset, io.SeekStart)
+		require.NoError(t, err)
+		assert.Equal(t, offset, pos)
+		end := min(offset+200, int64(len(content)))
+		buf := make([]byte, end-offset)
+		_, err = io.ReadFull(seeker, buf)
+		require.NoError(t, err)
+		assert.Equal(t, content[offset:end], buf, offset)
+	}
+
+	size, err := seeker.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	n, err := seeker.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestEncryptedKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	s := newTestEncrypted(t, 1)
+	_, err := s.PutFile(ctx, "alice", "/old.txt", strings.NewReader("old"))
+	require.NoError(t, err)
+
+	// A new key encrypts new files, the old one still decrypts files it encrypted
+	s.keys, err = newKeyring(masterKeys(2, 1))
+	require.NoError(t, err)
+	_, err = s.PutFile(ctx, "alice", "/new.txt", strings.NewReader("new"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", readAll(t, s, "alice", "/old.txt"))
+	assert.Equal(t, "new", readAll(t, s, "alice", "/new.txt"))
+
+	// Files encrypted with a key removed cannot be read
+	s.keys, err = newKeyring(masterKeys(2))
+	require.NoError(t, err)
+	_, err = s.OpenFile(ctx, "alice", "/old.txt")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, "new", readAll(t, s, "alice", "/new.txt"))
+}
+
+func TestEncryptedCorrupt(t *testing.T) {
+	ctx := context.Background()
+	s := newTestEncrypted(t, 1)
+	fullPath := s.Storage.(*fsStorage).getFullPath("alice", "/file.bin")
+	content := strings.Repeat("x", 2*segmentSize)
+
+	for name, damage := range map[string]func([]byte) []byte{
+		"flipped": func(stored []byte) []byte {
+			stored[encHeaderSize+10] ^= 1
+			return stored
+		},
+		"truncated": func(stored []byte) []byte {
+			return stored[:encHeaderSize+sealedSegmentSize]
+		},
+		"header": func(stored []byte) []byte {
+			stored[len(encMagic)+fingerprintSize] ^= 1
+			return stored
+		},
+	} {
+		_, err := s.PutFile(ctx, "alice", "/file.bin", strings.NewReader(content))
+		require.NoError(t, err)
+		stored, err := os.ReadFile(fullPath)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(fullPath, damage(stored), 0644))
+
+		reader, err := s.OpenFile(ctx, "alice", "/file.bin")
+		if err == nil {
+			_, err = io.ReadAll(reader)
+			reader.Close()
+		}
+		assert.ErrorIs(t, err, ErrCorrupt, name)
+	}
+}
+
+func TestEncryptedPlainFiles(t *testing.T) {
+	ctx := context.Background()
+	s := newTestEncrypted(t, 1)
+
+	// Files stored before encryption was enabled are read as they are
+	for _, content := range []string{"", "legacy", strings.Repeat("y", encHeaderSize+10)} {
+		plain := s.Storage.(*fsStorage).getFullPath("alice", "/old.txt")
+		require.NoError(t, os.MkdirAll(path.Dir(plain), 0755))
+		require.NoError(t, os.WriteFile(plain, []byte(content), 0644))
+		assert.Equal(t, content, readAll(t, s, "alice", "/old.txt"))
+	}
+
+	// and copied as they are
+	meta, err := s.CopyFile(ctx, "alice", "/old.txt", "/new.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(encHeaderSize+10), meta.Size)
+}
+
+func TestEncryptedScan(t *testing.T) {
+	ctx := context.Background()
+	s := newTestEncrypted(t, 1)
+	_, err := s.PutFile(ctx, "alice", "/docs/a.txt", strings.NewReader("hello"))
+	require.NoError(t, err)
+	meta, err := s.CopyFile(ctx, "alice", "/docs/a.txt", "/docs/b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), meta.Size)
+
+	sizes := map[string]int64{}
+	require.NoError(t, s.Scan(ctx, "alice", func(meta *FileMeta) error {
+		if !meta.IsDir {
+			sizes[meta.Path] = meta.Size
+		}
+		return nil
+	}))
+	assert.Equal(t, map[string]int64{"/docs/a.txt": 5, "/docs/b.txt": 5}, sizes)
+}
+
+func TestPlainSize(t *testing.T) {
+	assert.Equal(t, int64(-1), plainSize(0))
+	assert.Equal(t, int64(-1), plainSize(int64(encHeaderSize+gcmTagSize-1)))
+	assert.Equal(t, int64(0), plainSize(int64(encHeaderSize+gcmTagSize)))
+	assert.Equal(t, int64(segmentSize), plainSize(int64(encHeaderSize+sealedSegmentSize)))
+	assert.Equal(t, int64(segmentSize+1), plainSize(int64(encHeaderSize+sealedSegmentSize+1+gcmTagSize)))
+}
@@ -2,6 +2,7 @@ package stor
 
 import (
 	"context"
+	"errors"
 	"io"
 	"io/fs"
 	"log"
@@ -9,6 +10,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // fsStorage implements Storage based on the local filesystem
@@ -29,11 +31,7 @@ func (s *fsStorage) PutFile(ctx context.Context, repo, name string, data io.Read
 		return nil, err
 	}
 
-	if _, err := s.putFile(ctx, fullPath, data); err != nil {
-		return nil, err
-	}
-
-	st, err := os.Stat(fullPath)
+	st, err := s.putFile(ctx, fullPath, data)
 	if err != nil {
 		return nil, err
 	}
@@ -46,14 +44,67 @@ func (s *fsStorage) PutFile(ctx context.Context, repo, name string, data io.Read
 	}, nil
 }
 
-func (s *fsStorage) putFile(ctx context.Context, fullPath string, data io.Reader) (int64, error) {
-	file, err := os.Create(fullPath)
+// staleTempAge is how old a temporary file left by putFile gets before Scan takes it for the leftover
+// of a crashed write, rather than one still going on, and removes it
+const staleTempAge = 24 * time.Hour
+
+// tempPrefix starts the names putFile writes aside before renaming them into place. It is reserved, so
+// that files of users are never taken for temporary ones.
+const tempPrefix = ".filehub-tmp-"
+
+// isTempName reports whether a file name is one putFile writes aside before renaming it into place
+func isTempName(name string) bool {
+	return strings.HasPrefix(name, tempPrefix)
+}
+
+// putFile writes a file aside and renames it into place, so that readers only ever see the previous or
+// the complete new content, and a failed write never leaves a partial file behind. The content is flushed
+// to disk before the rename, so that a crash cannot leave the new name holding truncated content. It
+// returns the stat of the content written, unaffected by concurrent writes to the same file.
+func (s *fsStorage) putFile(ctx context.Context, fullPath string, data io.Reader) (fs.FileInfo, error) {
+	dir := path.Dir(fullPath)
+	file, err := os.CreateTemp(dir, tempPrefix+"*")
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	defer file.Close()
+	defer os.Remove(file.Name())
 
-	return io.Copy(file, data)
+	_, err = io.Copy(file, data)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	var st fs.FileInfo
+	if err == nil {
+		st, err = file.Stat()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(file.Name(), fullPath); err != nil {
+		return nil, err
+	}
+	syncDir(dir)
+	return st, nil
+}
+
+// syncDir flushes a directory to disk, so that a file renamed into it stays there after a crash. Failures
+// are only logged, as the content is in place and some filesystems cannot sync directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err == nil {
+		err = d.Sync()
+		d.Close()
+	}
+	if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		log.Printf("Failed to sync directory %s: %s", dir, err)
+	}
 }
 
 func (s *fsStorage) DeleteFile(ctx context.Context, repo, name string) error {
@@ -66,6 +117,14 @@ func (s *fsStorage) OpenFile(ctx context.Context, repo, name string) (io.ReadClo
 	return os.Open(fullPath)
 }
 
+func (s *fsStorage) OpenFileRange(ctx context.Context, repo, name string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(s.getFullPath(repo, name))
+	if err != nil {
+		return nil, err
+	}
+	return openRange(file, offset, length)
+}
+
 func (s *fsStorage) CopyFile(ctx context.Context, repo, srcName, destName string) (*FileMeta, error) {
 	srcPath := s.getFullPath(repo, srcName)
 
@@ -78,6 +137,42 @@ func (s *fsStorage) CopyFile(ctx context.Context, repo, srcName, destName string
 	return s.PutFile(ctx, repo, destName, input)
 }
 
+// LinkFile replaces destName with a hard link to srcName, so both names share one copy of the content
+func (s *fsStorage) LinkFile(ctx context.Context, srcRepo, srcName, destRepo, destName string) error {
+	srcPath := s.getFullPath(srcRepo, srcName)
+	destPath := s.getFullPath(destRepo, destName)
+
+	tmpPath := destPath + ".link"
+	if err := os.Link(srcPath, tmpPath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// RenameRepo moves the directory of a repository, failing if name is already taken
+func (s *fsStorage) RenameRepo(ctx context.Context, repo, name string) error {
+	destPath := s.getFullPath(name, "")
+	if _, err := os.Lstat(destPath); err == nil {
+		return fs.ErrExist
+	}
+
+	err := os.Rename(s.getFullPath(repo, ""), destPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // nothing stored yet
+	}
+	return err
+}
+
+// SetModTime changes the modification time of a file, so that rescans keep the time the client preserved
+func (s *fsStorage) SetModTime(ctx context.Context, repo, name string, modTime time.Time) error {
+	return os.Chtimes(s.getFullPath(repo, name), time.Time{}, modTime)
+}
+
 func (s *fsStorage) Scan(ctx context.Context, repo string, visit func(*FileMeta) error) error {
 	rootDir := s.getFullPath(repo, "")
 
@@ -87,6 +182,11 @@ func (s *fsStorage) Scan(ctx context.Context, repo string, visit func(*FileMeta)
 			return filepath.SkipDir
 		}
 
+		if !d.IsDir() && isTempName(d.Name()) {
+			removeStaleTemp(path, d)
+			return nil
+		}
+
 		meta := &FileMeta{
 			Name:  d.Name(),
 			Path:  strings.TrimPrefix(path, rootDir),
@@ -107,6 +207,24 @@ func (s *fsStorage) Scan(ctx context.Context, repo string, visit func(*FileMeta)
 	})
 }
 
+// removeStaleTemp removes a temporary file left behind by a write that crashed long enough ago
+func removeStaleTemp(name string, d fs.DirEntry) {
+	info, err := d.Info()
+	if err != nil || time.Since(info.ModTime()) < staleTempAge {
+		return
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to remove stale temporary file %s: %s", name, err)
+	} else {
+		log.Printf("Removed temporary file %s left by an interrupted write", name)
+	}
+}
+
+// Capabilities reports that files are opened seekable, and copied through the server
+func (s *fsStorage) Capabilities() Capabilities {
+	return Capabilities{RangeReads: true, ServerSideCopy: false}
+}
+
 func (s *fsStorage) GetContentType(ctx context.Context, repo, name string) (string, error) {
 	return getContentType(path.Ext(name)), nil
 }
@@ -0,0 +1,578 @@
+package stor
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+)
+
+const (
+	gcsEndpoint = "https://storage.googleapis.com"
+	gcsTokenURI = "https://oauth2.googleapis.com/token"
+	gcsScope    = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// gcsChunkSize is the size of the chunks larger files are uploaded in, a multiple of 256 KiB
+// except in tests
+var gcsChunkSize = 8 << 20
+
+var (
+	gcClient *gcsClient // Shared Google Cloud Storage client instance
+)
+
+// gcsCredentials is the part of a service account key read
+type gcsCredentials struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// gcsClient sends requests to the JSON API of Google Cloud Storage, authorized with access tokens
+// of a service account. Only the few operations the storage needs are implemented.
+type gcsClient struct {
+	endpoint string // URL of the service, without trailing slash
+	email    string
+	key      *rsa.PrivateKey // nil to send requests unauthorized, as to an emulator
+	tokenURI string
+	http     *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newGCSClient(cfg *config.GCSConfig) (*gcsClient, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = gcsEndpoint
+	}
+	client := &gcsClient{endpoint: strings.TrimSuffix(endpoint, "/"), http: &http.Client{}}
+
+	file := cfg.CredentialsFile
+	if file == "" {
+		file = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if file == "" {
+		return client, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	var creds gcsCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("invalid credentials: %w", err)
+	}
+	if creds.ClientEmail == "" || creds.PrivateKey == "" {
+		return nil, fmt.Errorf("%s is not a service account key", file)
+	}
+
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return nil, errors.New("invalid private key in credentials")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key in credentials: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key in credentials is not an RSA key")
+	}
+
+	client.email, client.key, client.tokenURI = creds.ClientEmail, key, creds.TokenURI
+	if client.tokenURI == "" {
+		client.tokenURI = gcsTokenURI
+	}
+	return client, nil
+}
+
+// gcsError is an error answered by the service
+type gcsError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *gcsError) Error() string {
+	return fmt.Sprintf("google cloud storage: %d %s", e.StatusCode, e.Message)
+}
+
+// Is makes missing objects match fs.ErrNotExist, as missing files do
+func (e *gcsError) Is(target error) bool {
+	return target == fs.ErrNotExist && e.StatusCode == http.StatusNotFound
+}
+
+// assertion returns the token signed with the service account key that is exchanged for an access token
+func (c *gcsClient) assertion(now time.Time) (string, error) {
+	claims, err := json.Marshal(map[string]any{
+		"iss":   c.email,
+		"scope": gcsScope,
+		"aud":   c.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// accessToken returns an access token of the service account, getting a new one shortly before the last expires
+func (c *gcsClient) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Until(c.expires) > time.Minute {
+		return c.token, nil
+	}
+
+	assertion, err := c.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get an access token: %s", resp.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid access token: %w", err)
+	}
+	c.token, c.expires = token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn)*time.Second)
+	return c.token, nil
+}
+
+// objectURL returns the URL of an object in the JSON API
+func (c *gcsClient) objectURL(bucket, name string) string {
+	return c.endpoint + "/storage/v1/b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(name)
+}
+
+// uploadURL returns the URL objects are uploaded to
+func (c *gcsClient) uploadURL(bucket string, query url.Values) string {
+	return c.endpoint + "/upload/storage/v1/b/" + url.PathEscape(bucket) + "/o?" + query.Encode()
+}
+
+// do sends a request, returning a gcsError for any status but a successful one or the 308
+// answered for a chunk of a resumable upload. body is read length bytes, and may be nil.
+func (c *gcsClient) do(ctx context.Context, method, target string, header http.Header, body io.Reader, length int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.ContentLength = length
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	if c.key != nil {
+		token, err := c.accessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices && resp.StatusCode != http.StatusPermanentRedirect {
+		defer resp.Body.Close()
+		var answer struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&answer)
+		return nil, &gcsError{StatusCode: resp.StatusCode, Message: answer.Error.Message}
+	}
+	return resp, nil
+}
+
+// call sends a request and decodes the JSON answered into out
+func (c *gcsClient) call(ctx context.Context, method, target string, header http.Header, body io.Reader, length int64, out any) error {
+	resp, err := c.do(ctx, method, target, header, body, length)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid answer from google cloud storage: %w", err)
+	}
+	return nil
+}
+
+// getGCSClient returns the shared client, or an error if Google Cloud Storage is not configured
+func getGCSClient() (*gcsClient, error) {
+	if gcClient == nil {
+		return nil, errors.New("google cloud storage not configured")
+	}
+	return gcClient, nil
+}
+
+// gcsResource is the part of an object resource read
+type gcsResource struct {
+	Name        string    `json:"name"`
+	Size        string    `json:"size"` // decimal, as it may exceed what JSON numbers hold
+	Updated     time.Time `json:"updated"`
+	ContentType string    `json:"contentType"`
+	Generation  string    `json:"generation"`
+}
+
+func (r *gcsResource) size() int64 {
+	size, _ := strconv.ParseInt(r.Size, 10, 64)
+	return size
+}
+
+// meta describes the object as the file at name
+func (r *gcsResource) meta(name string) *FileMeta {
+	meta := newFileMeta(name, r.Updated)
+	meta.Size = r.size()
+	return meta
+}
+
+// gcsStorage implements Storage with the objects of a Google Cloud Storage bucket,
+// named after the repository and file path below an optional prefix
+type gcsStorage struct {
+	bucket string
+	prefix string
+}
+
+func newGCSStorage(u *url.URL) *gcsStorage {
+	return &gcsStorage{bucket: u.Host, prefix: strings.Trim(u.Path, "/")}
+}
+
+// objectName converts a file path to the name of its object
+func (s *gcsStorage) objectName(repo, name string) string {
+	return strings.TrimPrefix(path.Join(s.prefix, repo, path.Clean("/"+name)), "/")
+}
+
+// PutFile uploads a file in a single request if it fits in a chunk, or else through a resumable upload
+func (s *gcsStorage) PutFile(ctx context.Context, repo, name string, data io.Reader) (*FileMeta, error) {
+	client, err := getGCSClient()
+	if err != nil {
+		return nil, err
+	}
+
+	object := s.objectName(repo, name)
+	contentType := getContentType(path.Ext(name))
+
+	buf := make([]byte, gcsChunkSize)
+	n, err := io.ReadFull(data, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		var res gcsResource
+		target := client.uploadURL(s.bucket, url.Values{"uploadType": {"media"}, "name": {object}})
+		if err := client.call(ctx, http.MethodPost, target, http.Header{"Content-Type": {contentType}}, bytes.NewReader(buf[:n]), int64(n), &res); err != nil {
+			return nil, err
+		}
+		return res.meta(name), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	target := client.uploadURL(s.bucket, url.Values{"uploadType": {"resumable"}, "name": {object}})
+	resp, err := client.do(ctx, http.MethodPost, target, http.Header{"X-Upload-Content-Type": {contentType}}, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return nil, fmt.Errorf("no upload session started for %s", object)
+	}
+
+	// Only once a chunk comes short is the size known and the upload completed
+	var offset int64
+	for {
+		end := offset + int64(n)
+		header := http.Header{}
+		if n < len(buf) {
+			var res gcsResource
+			header.Set("Content-Range", gcsContentRange(offset, end, strconv.FormatInt(end, 10)))
+			if err := client.call(ctx, http.MethodPut, session, header, bytes.NewReader(buf[:n]), int64(n), &res); err != nil {
+				return nil, err
+			}
+			return res.meta(name), nil
+		}
+
+		header.Set("Content-Range", gcsContentRange(offset, end, "*"))
+		resp, err := client.do(ctx, http.MethodPut, session, header, bytes.NewReader(buf[:n]), int64(n))
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		if persisted := resp.Header.Get("Range"); persisted != fmt.Sprintf("bytes=0-%d", end-1) {
+			return nil, fmt.Errorf("upload of %s stopped at %q instead of %d bytes", object, persisted, end)
+		}
+
+		offset = end
+		n, err = io.ReadFull(data, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+	}
+}
+
+// gcsContentRange returns the Content-Range of the bytes from offset to end of a resumable upload
+func gcsContentRange(offset, end int64, total string) string {
+	if offset == end {
+		return "bytes */" + total
+	}
+	return fmt.Sprintf("bytes %d-%d/%s", offset, end-1, total)
+}
+
+// DeleteFile deletes the object of a file, succeeding if there is none
+func (s *gcsStorage) DeleteFile(ctx context.Context, repo, name string) error {
+	client, err := getGCSClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.do(ctx, http.MethodDelete, client.objectURL(s.bucket, s.objectName(repo, name)), nil, nil, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// resource returns the resource describing an object
+func (s *gcsStorage) resource(ctx context.Context, client *gcsClient, object string) (*gcsResource, error) {
+	var res gcsResource
+	if err := client.call(ctx, http.MethodGet, client.objectURL(s.bucket, object), nil, nil, 0, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// OpenFile opens a file for reading. The reader can seek, so that files are served in ranges.
+func (s *gcsStorage) OpenFile(ctx context.Context, repo, name string) (io.ReadCloser, error) {
+	client, err := getGCSClient()
+	if err != nil {
+		return nil, err
+	}
+
+	object := s.objectName(repo, name)
+	res, err := s.resource(ctx, client, object)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"alt": {"media"}, "ifGenerationMatch": {res.Generation}}
+	return &gcsObject{ctx: ctx, client: client, target: client.objectURL(s.bucket, object) + "?" + query.Encode(), size: res.size()}, nil
+}
+
+// OpenFileRange opens a range of an object, read from its offset on with a ranged request
+func (s *gcsStorage) OpenFileRange(ctx context.Context, repo, name string, offset, length int64) (io.ReadCloser, error) {
+	object, err := s.OpenFile(ctx, repo, name)
+	if err != nil {
+		return nil, err
+	}
+	return openRange(object, offset, length)
+}
+
+// CopyFile copies an object on the service side, rewriting it in as many requests as the service needs
+func (s *gcsStorage) CopyFile(ctx context.Context, repo, srcName, destName string) (*FileMeta, error) {
+	client, err := getGCSClient()
+	if err != nil {
+		return nil, err
+	}
+
+	target := client.objectURL(s.bucket, s.objectName(repo, srcName)) +
+		"/rewriteTo/b/" + url.PathEscape(s.bucket) + "/o/" + url.PathEscape(s.objectName(repo, destName))
+	query := url.Values{}
+	for {
+		var result struct {
+			Done         bool         `json:"done"`
+			RewriteToken string       `json:"rewriteToken"`
+			Resource     *gcsResource `json:"resource"`
+		}
+		if err := client.call(ctx, http.MethodPost, target+"?"+query.Encode(), nil, nil, 0, &result); err != nil {
+			return nil, err
+		}
+
+		if result.Done {
+			if result.Resource == nil {
+				return nil, fmt.Errorf("copy of %s to %s returned no object", srcName, destName)
+			}
+			return result.Resource.meta(destName), nil
+		}
+		query.Set("rewriteToken", result.RewriteToken)
+	}
+}
+
+// Scan lists the objects of a repository. Folders are implied by the names of objects, each visited once
+// before the first object in it.
+func (s *gcsStorage) Scan(ctx context.Context, repo string, visit func(*FileMeta) error) error {
+	client, err := getGCSClient()
+	if err != nil {
+		return err
+	}
+
+	prefix := s.objectName(repo, "") + "/"
+	seen := map[string]bool{}
+	query := url.Values{"prefix": {prefix}}
+	for {
+		var list struct {
+			Items         []*gcsResource `json:"items"`
+			NextPageToken string         `json:"nextPageToken"`
+		}
+		target := client.endpoint + "/storage/v1/b/" + url.PathEscape(s.bucket) + "/o?" + query.Encode()
+		if err := client.call(ctx, http.MethodGet, target, nil, nil, 0, &list); err != nil {
+			return err
+		}
+
+		for _, res := range list.Items {
+			name := "/" + strings.TrimPrefix(res.Name, prefix)
+			var dirs []string
+			for dir := path.Dir(name); dir != "/" && !seen[dir]; dir = path.Dir(dir) {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+			for i := len(dirs) - 1; i >= 0; i-- {
+				if err := visit(newDirMeta(dirs[i], res.Updated)); err != nil {
+					return err
+				}
+			}
+
+			if err := visit(res.meta(name)); err != nil {
+				return err
+			}
+		}
+
+		if list.NextPageToken == "" {
+			return nil
+		}
+		query.Set("pageToken", list.NextPageToken)
+	}
+}
+
+// Capabilities reports that objects are read by range and rewritten within Cloud Storage
+func (s *gcsStorage) Capabilities() Capabilities {
+	return Capabilities{RangeReads: true, ServerSideCopy: true}
+}
+
+func (s *gcsStorage) GetContentType(ctx context.Context, repo, name string) (string, error) {
+	client, err := getGCSClient()
+	if err != nil {
+		return "", err
+	}
+
+	res, err := s.resource(ctx, client, s.objectName(repo, name))
+	if err != nil {
+		return "", err
+	}
+	return res.ContentType, nil
+}
+
+// gcsObject reads an object from its offset on, sending a new ranged request after a seek.
+// Reads fail if the object is replaced meanwhile, as its generation no longer matches.
+type gcsObject struct {
+	ctx    context.Context
+	client *gcsClient
+	target string // URL of the object's media at the generation opened
+	size   int64
+	offset int64
+	body   io.ReadCloser // response read from offset, nil before the first read and after a seek
+}
+
+func (o *gcsObject) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", o.offset)}}
+		resp, err := o.client.do(o.ctx, http.MethodGet, o.target, header, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+		o.body = resp.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	if err == io.EOF && o.offset < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *gcsObject) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if offset != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *gcsObject) Close() error {
+	if o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	return nil
+}
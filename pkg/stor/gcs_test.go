@@ -0,0 +1,393 @@
+package stor
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGCS serves the JSON API operations the storage uses from memory, handing out
+// access tokens only for assertions signed with the service account key
+type fakeGCS struct {
+	t        *testing.T
+	url      string
+	key      *rsa.PublicKey
+	mu       sync.Mutex
+	objects  map[string][]byte
+	types    map[string]string
+	gens     map[string]int
+	sessions map[string]*bytes.Buffer
+	names    map[string]string // object name of each upload session
+	tokens   int               // access tokens handed out
+}
+
+func newFakeGCS(t *testing.T) *fakeGCS {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	f := &fakeGCS{
+		t:        t,
+		key:      &key.PublicKey,
+		objects:  map[string][]byte{},
+		types:    map[string]string{},
+		gens:     map[string]int{},
+		sessions: map[string]*bytes.Buffer{},
+		names:    map[string]string{},
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	f.url = server.URL
+
+	creds, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "hub@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    server.URL + "/token",
+	})
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "key.json")
+	require.NoError(t, os.WriteFile(file, creds, 0o600))
+
+	client, err := newGCSClient(&config.GCSConfig{CredentialsFile: file, Endpoint: server.URL})
+	require.NoError(t, err)
+
+	saved := gcClient
+	gcClient = client
+	t.Cleanup(func() { gcClient = saved })
+	return f
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		f.token(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Object names are kept with the bucket
+	query := r.URL.Query()
+	escaped := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(escaped, "/session/"):
+		f.upload(w, r, strings.TrimPrefix(escaped, "/session/"))
+	case strings.HasPrefix(escaped, "/upload/storage/v1/b/"):
+		bucket, _ := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(escaped, "/upload/storage/v1/b/"), "/o"))
+		name := bucket + "/" + query.Get("name")
+		if query.Get("uploadType") == "resumable" {
+			id := strconv.Itoa(len(f.sessions))
+			f.sessions[id], f.names[id] = &bytes.Buffer{}, name
+			f.types[name] = r.Header.Get("X-Upload-Content-Type")
+			w.Header().Set("Location", f.url+"/session/"+id)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		json.NewEncoder(w).Encode(f.store(name, data, r.Header.Get("Content-Type")))
+	case strings.Contains(escaped, "/rewriteTo/"):
+		parts := strings.Split(escaped, "/")
+		src, dest := f.objectName(parts[4], parts[6]), f.objectName(parts[9], parts[11])
+		data, ok := f.objects[src]
+		if !ok {
+			f.fail(w, http.StatusNotFound)
+			return
+		}
+		if query.Get("rewriteToken") == "" {
+			json.NewEncoder(w).Encode(map[string]any{"done": false, "rewriteToken": "more"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"done": true, "resource": f.store(dest, slices.Clone(data), f.types[src])})
+	case strings.HasSuffix(escaped, "/o") && r.Method == http.MethodGet:
+		f.list(w, strings.TrimSuffix(strings.TrimPrefix(escaped, "/storage/v1/b/"), "/o"), query)
+	default:
+		parts := strings.Split(escaped, "/")
+		name := f.objectName(parts[4], parts[6])
+		data, ok := f.objects[name]
+		if !ok {
+			f.fail(w, http.StatusNotFound)
+			return
+		}
+		switch {
+		case r.Method == http.MethodDelete:
+			delete(f.objects, name)
+			w.WriteHeader(http.StatusNoContent)
+		case query.Get("alt") == "media":
+			if query.Get("ifGenerationMatch") != strconv.Itoa(f.gens[name]) {
+				f.fail(w, http.StatusPreconditionFailed)
+				return
+			}
+			start := 0
+			if rng := r.Header.Get("Range"); rng != "" {
+				start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			}
+			w.Write(data[start:])
+		default:
+			json.NewEncoder(w).Encode(f.resource(name))
+		}
+	}
+}
+
+// token checks the signature of an assertion before handing out an access token
+func (f *fakeGCS) token(w http.ResponseWriter, r *http.Request) {
+	require.NoError(f.t, r.ParseForm())
+	assert.Equal(f.t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.PostForm.Get("grant_type"))
+
+	parts := strings.Split(r.PostForm.Get("assertion"), ".")
+	require.Len(f.t, parts, 3)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(f.t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(f.key, crypto.SHA256, digest[:], signature); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(f.t, err)
+	assert.Contains(f.t, string(claims), `"iss":"hub@project.iam.gserviceaccount.com"`)
+	assert.Contains(f.t, string(claims), `"aud":"`+f.url+`/token"`)
+
+	f.tokens++
+	json.NewEncoder(w).Encode(map[string]any{"access_token": "token", "expires_in": 3600})
+}
+
+// upload takes a chunk of a resumable upload
+func (f *fakeGCS) upload(w http.ResponseWriter, r *http.Request, id string) {
+	session := f.sessions[id]
+	data, _ := io.ReadAll(r.Body)
+	contentRange := r.Header.Get("Content-Range")
+	if !strings.HasPrefix(contentRange, "bytes */") {
+		assert.True(f.t, strings.HasPrefix(contentRange, fmt.Sprintf("bytes %d-%d/", session.Len(), session.Len()+len(data)-1)), contentRange)
+	}
+	session.Write(data)
+
+	if strings.HasSuffix(contentRange, "/*") {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", session.Len()-1))
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+	assert.True(f.t, strings.HasSuffix(contentRange, "/"+strconv.Itoa(session.Len())), contentRange)
+	json.NewEncoder(w).Encode(f.store(f.names[id], session.Bytes(), f.types[f.names[id]]))
+}
+
+func (f *fakeGCS) objectName(bucket, name string) string {
+	bucket, _ = url.PathUnescape(bucket)
+	name, _ = url.PathUnescape(name)
+	return bucket + "/" + name
+}
+
+func (f *fakeGCS) resource(name string) map[string]any {
+	_, object, _ := strings.Cut(name, "/")
+	return map[string]any{
+		"name":        object,
+		"size":        strconv.Itoa(len(f.objects[name])),
+		"updated":     "2026-10-12T08:00:00.000Z",
+		"contentType": f.types[name],
+		"generation":  strconv.Itoa(f.gens[name]),
+	}
+}
+
+// store writes a new generation of an object, returning its resource
+func (f *fakeGCS) store(name string, data []byte, contentType string) map[string]any {
+	f.objects[name], f.types[name] = data, contentType
+	f.gens[name]++
+	return f.resource(name)
+}
+
+func (f *fakeGCS) fail(w http.ResponseWriter, status int) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": status, "message": http.StatusText(status)}})
+}
+
+// list answers two objects per page
+func (f *fakeGCS) list(w http.ResponseWriter, bucket string, query url.Values) {
+	prefix := bucket + "/" + query.Get("prefix")
+	var names []string
+	for name := range f.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	start, _ := strconv.Atoi(query.Get("pageToken"))
+	end := min(start+2, len(names))
+	items := []map[string]any{}
+	for _, name := range names[start:end] {
+		items = append(items, f.resource(name))
+	}
+	list := map[string]any{"items": items}
+	if end < len(names) {
+		list["nextPageToken"] = strconv.Itoa(end)
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+func TestGCSObjectName(t *testing.T) {
+	assert.Equal(t, "alice/docs/a.txt", newGCSStorage(&url.URL{Host: "files"}).objectName("alice", "/docs/a.txt"))
+	assert.Equal(t, "alice", newGCSStorage(&url.URL{Host: "files"}).objectName("alice", ""))
+	assert.Equal(t, "hub/alice/a.txt", newGCSStorage(&url.URL{Host: "files", Path: "/hub/"}).objectName("alice", "a.txt"))
+}
+
+func TestGCSContentRange(t *testing.T) {
+	assert.Equal(t, "bytes 0-3/*", gcsContentRange(0, 4, "*"))
+	assert.Equal(t, "bytes 4-9/10", gcsContentRange(4, 10, "10"))
+	assert.Equal(t, "bytes */8", gcsContentRange(8, 8, "8"))
+}
+
+func TestGCSStorage(t *testing.T) {
+	fake := newFakeGCS(t)
+	ctx := context.Background()
+	storage := newGCSStorage(&url.URL{Host: "files", Path: "/hub"})
+
+	t.Run("Put small file", func(t *testing.T) {
+		meta, err := storage.PutFile(ctx, "alice", "/docs/a.txt", strings.NewReader("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), meta.Size)
+		assert.Equal(t, "a.txt", meta.Name)
+		assert.Equal(t, time.Date(2026, 10, 12, 8, 0, 0, 0, time.UTC), meta.ModTime)
+		assert.Equal(t, []byte("hello"), fake.objects["files/hub/alice/docs/a.txt"])
+
+		ct, err := storage.GetContentType(ctx, "alice", "/docs/a.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain", ct)
+	})
+
+	t.Run("Put file in chunks", func(t *testing.T) {
+		saved := gcsChunkSize
+		gcsChunkSize = 4
+		defer func() { gcsChunkSize = saved }()
+
+		content := "0123456789"
+		meta, err := storage.PutFile(ctx, "alice", "/big.bin", strings.NewReader(content))
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(content)), meta.Size)
+		assert.Equal(t, []byte(content), fake.objects["files/hub/alice/big.bin"])
+
+		// A size in whole chunks completes the upload with an empty one
+		meta, err = storage.PutFile(ctx, "alice", "/even.bin", strings.NewReader("01234567"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(8), meta.Size)
+		assert.Equal(t, []byte("01234567"), fake.objects["files/hub/alice/even.bin"])
+	})
+
+	t.Run("Open and seek", func(t *testing.T) {
+		reader, err := storage.OpenFile(ctx, "alice", "/big.bin")
+		require.NoError(t, err)
+		defer reader.Close()
+
+		buf := make([]byte, 3)
+		_, err = io.ReadFull(reader, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "012", string(buf))
+
+		seeker := reader.(io.Seeker)
+		_, err = seeker.Seek(-2, io.SeekEnd)
+		require.NoError(t, err)
+		rest, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "89", string(rest))
+	})
+
+	t.Run("Read fails after the object changed", func(t *testing.T) {
+		reader, err := storage.OpenFile(ctx, "alice", "/docs/a.txt")
+		require.NoError(t, err)
+		defer reader.Close()
+
+		_, err = storage.PutFile(ctx, "alice", "/docs/a.txt", strings.NewReader("changed"))
+		require.NoError(t, err)
+		_, err = io.ReadAll(reader)
+		assert.Error(t, err)
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		meta, err := storage.CopyFile(ctx, "alice", "/docs/a.txt", "/docs/b.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len("changed")), meta.Size)
+		assert.Equal(t, "b.txt", meta.Name)
+		assert.Equal(t, []byte("changed"), fake.objects["files/hub/alice/docs/b.txt"])
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		_, err := storage.PutFile(ctx, "alice", "/docs/deep/c.txt", bytes.NewReader(nil))
+		require.NoError(t, err)
+		_, err = storage.PutFile(ctx, "bob", "/other.txt", strings.NewReader("x"))
+		require.NoError(t, err)
+
+		var visited []string
+		err = storage.Scan(ctx, "alice", func(meta *FileMeta) error {
+			visited = append(visited, fmt.Sprintf("%s:%t:%d", meta.Path, meta.IsDir, meta.Size))
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"/big.bin:false:10",
+			"/docs:true:0",
+			"/docs/a.txt:false:7",
+			"/docs/b.txt:false:7",
+			"/docs/deep:true:0",
+			"/docs/deep/c.txt:false:0",
+			"/even.bin:false:8",
+		}, visited)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, storage.DeleteFile(ctx, "alice", "/docs/b.txt"))
+		assert.NotContains(t, fake.objects, "files/hub/alice/docs/b.txt")
+		assert.NoError(t, storage.DeleteFile(ctx, "alice", "/docs/b.txt"))
+
+		_, err := storage.OpenFile(ctx, "alice", "/docs/b.txt")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	// The access token is reused until it nears expiry
+	assert.Equal(t, 1, fake.tokens)
+}
+
+func TestGCSNotConfigured(t *testing.T) {
+	saved := gcClient
+	gcClient = nil
+	defer func() { gcClient = saved }()
+
+	_, err := newGCSStorage(&url.URL{Host: "files"}).OpenFile(context.Background(), "alice", "a.txt")
+	assert.Error(t, err)
+}
+
+func TestGCSInvalidCredentials(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "key.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"type":"authorized_user"}`), 0o600))
+
+	_, err := newGCSClient(&config.GCSConfig{CredentialsFile: file})
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package stor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+var (
+	// ErrImmutable is returned overwriting, moving or deleting a file marked immutable
+	ErrImmutable = errors.New("file is immutable")
+	// ErrImmutableDir is returned marking a directory immutable, which only files can be
+	ErrImmutableDir = errors.New("only files can be immutable")
+)
+
+// SetImmutable marks a file immutable, or clears the mark so that it can be changed again
+func SetImmutable(ctx context.Context, res *model.Resource, immutable bool) (*model.FileObject, error) {
+	file, err := db.GetFile(ctx, res.Repo.ID, res.Path)
+	if err != nil {
+		return nil, err
+	}
+	if file.IsDir {
+		return nil, ErrImmutableDir
+	}
+
+	if err := db.SetFileImmutable(ctx, res.Repo.ID, file.Path, immutable); err != nil {
+		return nil, err
+	}
+	file.Immutable = immutable
+	return file, nil
+}
+
+// CheckMutable returns ErrImmutable if the file at a path is immutable, or ErrReserved for a path the server
+// keeps for itself. Nothing being there is no error.
+func CheckMutable(ctx context.Context, res *model.Resource) error {
+	if err := checkReserved(res.Path); err != nil {
+		return err
+	}
+
+	file, err := db.GetFile(ctx, res.Repo.ID, res.Path)
+	if IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return checkFileMutable(file)
+}
+
+// CheckTreeMutable returns ErrImmutable if a file, or any file below a directory, is immutable
+func CheckTreeMutable(ctx context.Context, res *model.Resource) error {
+	if err := CheckMutable(ctx, res); err != nil {
+		return err
+	}
+
+	found, err := db.FindImmutableUnder(ctx, res.Repo.ID, res.Path)
+	if err != nil {
+		return err
+	}
+	if found != "" {
+		return fmt.Errorf("%w: %s", ErrImmutable, found)
+	}
+	return nil
+}
+
+func checkFileMutable(file *model.FileObject) error {
+	if file.Immutable {
+		return fmt.Errorf("%w: %s", ErrImmutable, file.Path)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package stor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	storageOps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "filehub_storage_operations_total",
+		Help: "Storage operations by backend, repository, operation and result.",
+	}, []string{"backend", "repo", "operation", "result"})
+
+	storageSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "filehub_storage_operation_duration_seconds",
+		Help:    "Time spent in the storage backend per operation, excluding waits for the client.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"backend", "repo", "operation"})
+
+	storageBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "filehub_storage_bytes_total",
+		Help: "Bytes read from and written to storage by backend, repository and direction.",
+	}, []string{"backend", "repo", "direction"})
+)
+
+// slowThreshold is how long a storage operation may take before it is logged, 0 to log none
+var slowThreshold time.Duration
+
+// errModTimeUnsupported is returned when the storage keeps no modification times of its own
+var errModTimeUnsupported = errors.New("storage does not keep modification times")
+
+// instrumented counts and times the operations of a storage backend, logging slow ones.
+// Only the time spent in the backend is measured: waits for the uploading client or for
+// the caller scanning files are left out.
+type instrumented struct {
+	Storage
+	backend string
+}
+
+func (s *instrumented) observe(op, repo, name string, start time.Time, waited time.Duration, err error) {
+	elapsed := time.Since(start) - waited
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	storageOps.WithLabelValues(s.backend, repo, op, result).Inc()
+	storageSeconds.WithLabelValues(s.backend, repo, op).Observe(elapsed.Seconds())
+	if slowThreshold > 0 && elapsed > slowThreshold {
+		log.Printf("Slow storage operation: %s of %s/%s took %s on %s", op, repo, name, elapsed.Round(time.Millisecond), s.backend)
+	}
+}
+
+func (s *instrumented) PutFile(ctx context.Context, repo, name string, data io.Reader) (*FileMeta, error) {
+	start := time.Now()
+	source := &timedReader{r: data}
+	meta, err := s.Storage.PutFile(ctx, repo, name, source)
+	s.observe("put", repo, name, start, source.waited, err)
+	storageBytes.WithLabelValues(s.backend, repo, "written").Add(float64(source.n))
+	return meta, err
+}
+
+func (s *instrumented) OpenFile(ctx context.Context, repo, name string) (io.ReadCloser, error) {
+	start := time.Now()
+	reader, err := s.Storage.OpenFile(ctx, repo, name)
+	s.observe("open", repo, name, start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+
+	read := storageBytes.WithLabelValues(s.backend, repo, "read")
+	if file, ok := reader.(*os.File); ok {
+		return &meteredFile{File: file, counter: read}, nil
+	}
+	if seeker, ok := reader.(io.ReadSeekCloser); ok {
+		return &meteredSeeker{ReadSeekCloser: seeker, counter: read}, nil
+	}
+	return &meteredReader{ReadCloser: reader, counter: read}, nil
+}
+
+func (s *instrumented) OpenFileRange(ctx context.Context, repo, name string, offset, length int64) (io.ReadCloser, error) {
+	start := time.Now()
+	reader, err := s.Storage.OpenFileRange(ctx, repo, name, offset, length)
+	s.observe("open_range", repo, name, start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredReader{ReadCloser: reader, counter: storageBytes.WithLabelValues(s.backend, repo, "read")}, nil
+}
+
+func (s *instrumented) DeleteFile(ctx context.Context, repo, name string) error {
+	start := time.Now()
+	err := s.Storage.DeleteFile(ctx, repo, name)
+	s.observe("delete", repo, name, start, 0, err)
+	return err
+}
+
+func (s *instrumented) CopyFile(ctx context.Context, repo, srcName, destName string) (*FileMeta, error) {
+	start := time.Now()
+	meta, err := s.Storage.CopyFile(ctx, repo, srcName, destName)
+	s.observe("copy", repo, srcName, start, 0, err)
+	return meta, err
+}
+
+func (s *instrumented) Scan(ctx context.Context, repo string, visit func(*FileMeta) error) error {
+	start := time.Now()
+	var waited time.Duration
+	err := s.Storage.Scan(ctx, repo, func(meta *FileMeta) error {
+		visited := time.Now()
+		defer func() { waited += time.Since(visited) }()
+		return visit(meta)
+	})
+	s.observe("scan", repo, "", start, waited, err)
+	return err
+}
+
+func (s *instrumented) GetContentType(ctx context.Context, repo, name string) (string, error) {
+	start := time.Now()
+	ct, err := s.Storage.GetContentType(ctx, repo, name)
+	s.observe("content_type", repo, name, start, 0, err)
+	return ct, err
+}
+
+func (s *instrumented) LinkFile(ctx context.Context, srcRepo, srcName, destRepo, destName string) error {
+	linker, ok := s.Storage.(Linker)
+	if !ok {
+		return ErrLinkUnsupported
+	}
+
+	start := time.Now()
+	err := linker.LinkFile(ctx, srcRepo, srcName, destRepo, destName)
+	s.observe("link", destRepo, destName, start, 0, err)
+	return err
+}
+
+func (s *instrumented) SetModTime(ctx context.Context, repo, name string, modTime time.Time) error {
+	setter, ok := s.Storage.(ModTimeSetter)
+	if !ok {
+		return errModTimeUnsupported
+	}
+
+	start := time.Now()
+	err := setter.SetModTime(ctx, repo, name, modTime)
+	s.observe("set_mod_time", repo, name, start, 0, err)
+	return err
+}
+
+func (s *instrumented) RenameRepo(ctx context.Context, repo, name string) error {
+	renamer, ok := s.Storage.(Renamer)
+	if !ok {
+		return ErrRenameUnsupported
+	}
+
+	start := time.Now()
+	err := renamer.RenameRepo(ctx, repo, name)
+	s.observe("rename_repo", repo, "", start, 0, err)
+	return err
+}
+
+func (s *instrumented) PresignFile(ctx context.Context, method, repo, name string, expires time.Duration) (*PresignedRequest, error) {
+	presigner, ok := s.Storage.(Presigner)
+	if !ok {
+		return nil, ErrPresignUnsupported
+	}
+	return presigner.PresignFile(ctx, method, repo, name, expires)
+}
+
+func (s *instrumented) StatFile(ctx context.Context, repo, name string) (*FileMeta, error) {
+	presigner, ok := s.Storage.(Presigner)
+	if !ok {
+		return nil, ErrPresignUnsupported
+	}
+
+	start := time.Now()
+	meta, err := presigner.StatFile(ctx, repo, name)
+	s.observe("stat", repo, name, start, 0, err)
+	return meta, err
+}
+
+// timedReader counts the bytes read from a client and the time spent waiting for them
+type timedReader struct {
+	r      io.Reader
+	n      int64
+	waited time.Duration
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.r.Read(p)
+	r.waited += time.Since(start)
+	r.n += int64(n)
+	return n, err
+}
+
+// meteredReader counts the bytes read from storage
+type meteredReader struct {
+	io.ReadCloser
+	counter prometheus.Counter
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.Add(float64(n))
+	return n, err
+}
+
+// meteredSeeker counts the bytes read from storage, keeping the reader seekable for range requests
+type meteredSeeker struct {
+	io.ReadSeekCloser
+	counter prometheus.Counter
+}
+
+func (r *meteredSeeker) Read(p []byte) (int, error) {
+	n, err := r.ReadSeekCloser.Read(p)
+	r.counter.Add(float64(n))
+	return n, err
+}
+
+// meteredFile counts the bytes read from a local file by how far its offset moved, as the kernel
+// copies them with sendfile without a Read. The file itself stays reachable for sendfile.
+type meteredFile struct {
+	*os.File
+	counter prometheus.Counter
+	base    int64 // offset since which bytes were counted
+}
+
+// account counts the bytes read since the offset was last looked at
+func (f *meteredFile) account() {
+	if offset, err := f.File.Seek(0, io.SeekCurrent); err == nil {
+		if offset > f.base {
+			f.counter.Add(float64(offset - f.base))
+		}
+		f.base = offset
+	}
+}
+
+func (f *meteredFile) Seek(offset int64, whence int) (int64, error) {
+	f.account()
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.base = pos
+	}
+	return pos, err
+}
+
+func (f *meteredFile) Close() error {
+	f.account()
+	return f.File.Close()
+}
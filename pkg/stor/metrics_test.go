@@ -0,0 +1,63 @@
+package stor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumented(t *testing.T) {
+	ctx := context.Background()
+	storage := &instrumented{&fsStorage{rootDir: t.TempDir()}, "fs"}
+	written := storageBytes.WithLabelValues("fs", "metrics", "written")
+	read := storageBytes.WithLabelValues("fs", "metrics", "read")
+	puts := storageOps.WithLabelValues("fs", "metrics", "put", "ok")
+	failed := storageOps.WithLabelValues("fs", "metrics", "open", "error")
+
+	_, err := storage.PutFile(ctx, "metrics", "/a.txt", strings.NewReader("hello world"))
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(puts))
+	assert.Equal(t, 11.0, testutil.ToFloat64(written))
+
+	reader, err := storage.OpenFile(ctx, "metrics", "/a.txt")
+	require.NoError(t, err)
+	seeker, ok := reader.(io.ReadSeeker)
+	require.True(t, ok, "local files stay seekable")
+	_, err = seeker.Seek(6, io.SeekStart)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, seeker)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, "world", buf.String())
+	assert.Equal(t, 5.0, testutil.ToFloat64(read))
+
+	_, err = storage.OpenFile(ctx, "metrics", "/missing.txt")
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(failed))
+}
+
+func TestInstrumentedOptional(t *testing.T) {
+	ctx := context.Background()
+	storage := &instrumented{&s3Storage{bucket: "bucket"}, "s3"}
+
+	assert.ErrorIs(t, storage.LinkFile(ctx, "a", "/x", "a", "/y"), ErrLinkUnsupported)
+	assert.ErrorIs(t, storage.RenameRepo(ctx, "a", "b"), ErrRenameUnsupported)
+	assert.ErrorIs(t, storage.SetModTime(ctx, "a", "/x", time.Now()), errModTimeUnsupported)
+}
+
+func TestTimedReader(t *testing.T) {
+	r := &timedReader{r: strings.NewReader("data")}
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	assert.Equal(t, int64(4), r.n)
+	assert.Positive(t, r.waited)
+}
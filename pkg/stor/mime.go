@@ -0,0 +1,77 @@
+package stor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// sniffLen is how much content http.DetectContentType looks at
+const sniffLen = 512
+
+// SniffContentType returns the content type of a file from the start of its content, or from its name
+// when the content only tells that it is text or binary. Files uploaded without a type, such as through
+// WebDAV, would otherwise all be application/octet-stream.
+func SniffContentType(name string, head []byte) string {
+	byName := getContentType(path.Ext(name))
+	if len(head) == 0 {
+		return byName
+	}
+
+	sniffed := http.DetectContentType(head)
+	if byName != "application/octet-stream" && (sniffed == "application/octet-stream" || strings.HasPrefix(sniffed, "text/plain")) {
+		return byName
+	}
+	return sniffed
+}
+
+// headBuffer keeps the first sniffLen bytes written to it
+type headBuffer struct {
+	data []byte
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if n := sniffLen - len(b.data); n > 0 {
+		b.data = append(b.data, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// sniffFile returns the content type of a file from the start of its content, read through the storage
+func sniffFile(ctx context.Context, storage Storage, repo *model.Repository, name string) (string, error) {
+	reader, err := storage.OpenFile(ctx, repo.Name, name)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	return SniffContentType(name, head[:n]), nil
+}
+
+// sniffScanned records the content type of a file a scan recorded when none is recorded yet. Failing to
+// read the file is logged and leaves the type to the backfill.
+func sniffScanned(ctx context.Context, storage Storage, repo *model.Repository, file *model.FileObject) error {
+	if file.IsDir || file.MimeType != nil {
+		return nil
+	}
+
+	mimeType, err := sniffFile(ctx, storage, repo, file.Path)
+	if err != nil {
+		log.Printf("Failed to detect the content type of %s/%s: %s", repo.Name, file.Path, err)
+		return nil
+	}
+	_, err = db.SetContentType(ctx, file.ID, mimeType)
+	return err
+}
@@ -0,0 +1,56 @@
+package stor
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSniffContentType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	// The content tells what the name doesn't
+	assert.Equal(t, "image/png", SniffContentType("/upload.bin", png))
+	assert.Equal(t, "image/png", SniffContentType("/noext", png))
+	assert.Equal(t, "application/pdf", SniffContentType("/scan", []byte("%PDF-1.7\n")))
+	assert.Equal(t, "text/plain; charset=utf-8", SniffContentType("/notes", []byte("hello")))
+
+	// and where it only tells text or binary, a known extension is more precise
+	assert.Equal(t, "application/json", SniffContentType("/a.json", []byte(`{"a": 1}`)))
+	assert.Equal(t, "text/css", SniffContentType("/a.css", []byte("body { margin: 0 }")))
+	assert.Equal(t, "application/octet-stream", SniffContentType("/a.dat", []byte{0, 1, 2, 3}))
+
+	// Empty files only have a name
+	assert.Equal(t, "text/plain", SniffContentType("/empty.txt", nil))
+	assert.Equal(t, "application/octet-stream", SniffContentType("/empty", nil))
+}
+
+func TestHeadBuffer(t *testing.T) {
+	var head headBuffer
+	for range 3 {
+		n, err := head.Write(bytes.Repeat([]byte("x"), 300))
+		require.NoError(t, err)
+		assert.Equal(t, 300, n)
+	}
+	assert.Len(t, head.data, sniffLen)
+}
+
+func TestSniffFile(t *testing.T) {
+	ctx := context.Background()
+	s := &fsStorage{rootDir: t.TempDir()}
+	repo := &model.Repository{Name: "alice"}
+
+	_, err := s.PutFile(ctx, "alice", "/page", strings.NewReader("<!DOCTYPE html><html></html>"))
+	require.NoError(t, err)
+	ct, err := sniffFile(ctx, s, repo, "/page")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; charset=utf-8", ct)
+
+	_, err = sniffFile(ctx, s, repo, "/missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package stor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// ErrPresignUnsupported is returned when the files of a repository cannot be reached through presigned
+// requests: presigning is off, the backend has no URLs of its own, or the server encrypts the content
+var ErrPresignUnsupported = errors.New("storage does not support presigned requests")
+
+// presignExpiry is how long presigned requests are valid, 0 when none are handed out
+var presignExpiry time.Duration
+
+// PresignedRequest is a request a client sends to the storage backend itself, sparing the server the bytes
+type PresignedRequest struct {
+	Method    string      `json:"method"`
+	URL       string      `json:"url"`
+	Header    http.Header `json:"headers,omitempty"` // signed along, so they must be sent as they are
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+// Presigner is implemented by storage backends that hand out requests reaching files without the server
+type Presigner interface {
+	// PresignFile returns a request reading name with GET, or writing it with PUT, valid for expires
+	PresignFile(ctx context.Context, method, repo, name string, expires time.Duration) (*PresignedRequest, error)
+	// StatFile returns the meta of a file as stored, such as after a presigned write
+	StatFile(ctx context.Context, repo, name string) (*FileMeta, error)
+}
+
+// PresignFile returns a request reading (GET) or writing (PUT) a file in its storage backend directly
+func PresignFile(ctx context.Context, resource *model.Resource, method string) (*PresignedRequest, error) {
+	if presignExpiry <= 0 {
+		return nil, ErrPresignUnsupported
+	}
+
+	storage, err := getStorage(resource.Repo)
+	if err != nil {
+		return nil, err
+	}
+	presigner, ok := storage.(Presigner)
+	if !ok {
+		return nil, ErrPresignUnsupported
+	}
+	return presigner.PresignFile(ctx, method, resource.Repo.Name, resource.Path, presignExpiry)
+}
+
+// StatPresigned returns the meta of a file written through a presigned request
+func StatPresigned(ctx context.Context, resource *model.Resource) (*FileMeta, error) {
+	storage, err := getStorage(resource.Repo)
+	if err != nil {
+		return nil, err
+	}
+	presigner, ok := storage.(Presigner)
+	if !ok {
+		return nil, ErrPresignUnsupported
+	}
+	return presigner.StatFile(ctx, resource.Repo.Name, resource.Path)
+}
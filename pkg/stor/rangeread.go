@@ -0,0 +1,56 @@
+package stor
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// ErrInvalidRange is returned reading a range of a file that starts before the file does
+var ErrInvalidRange = errors.New("invalid range")
+
+// OpenFileRange opens length bytes of a file from offset on, or the rest of the file when length is negative.
+// Ranges past the end of the file are cut short, and read nothing when they start there.
+func OpenFileRange(ctx context.Context, resource *model.Resource, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, ErrInvalidRange
+	}
+
+	storage, err := getStorage(resource.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.OpenFileRange(ctx, resource.Repo.Name, resource.Path, offset, length)
+}
+
+// openRange returns the range of a file opened whole. Seekable readers seek to the offset, which
+// backends reading remote objects do without a request; others skip the bytes before it.
+func openRange(reader io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	var err error
+	if seeker, ok := reader.(io.Seeker); ok {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	} else if _, err = io.CopyN(io.Discard, reader, offset); errors.Is(err, io.EOF) {
+		err = nil
+	}
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	return limitReadCloser(reader, length), nil
+}
+
+// limitReadCloser returns a reader stopping after length bytes, the reader itself when length is negative
+func limitReadCloser(reader io.ReadCloser, length int64) io.ReadCloser {
+	if length < 0 {
+		return reader
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(reader, length), reader}
+}
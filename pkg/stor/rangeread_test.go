@@ -0,0 +1,50 @@
+package stor
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readRange reads a range of /file.txt of alice
+func readRange(t *testing.T, s Storage, offset, length int64) string {
+	reader, err := s.OpenFileRange(context.Background(), "alice", "/file.txt", offset, length)
+	require.NoError(t, err)
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestOpenFileRange(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeS3(t, 1<<20)
+	dedup, _ := newTestDedup(t)
+	content := strings.Repeat("0123456789", 2*segmentSize/10)
+
+	for name, s := range map[string]Storage{
+		"fs":        &fsStorage{rootDir: t.TempDir()},
+		"dedup":     dedup,
+		"s3":        &s3Storage{bucket: "files"},
+		"encrypted": newTestEncrypted(t, 1),
+		"metered":   &instrumented{&fsStorage{rootDir: t.TempDir()}, "fs"},
+	} {
+		_, err := s.PutFile(ctx, "alice", "/file.txt", strings.NewReader(content))
+		require.NoError(t, err, name)
+
+		assert.Equal(t, "3456", readRange(t, s, 3, 4), name)
+		assert.Equal(t, content[segmentSize-2:segmentSize+3], readRange(t, s, segmentSize-2, 5), name)
+		assert.Equal(t, content[len(content)-5:], readRange(t, s, int64(len(content))-5, -1), name)
+		assert.Equal(t, content[len(content)-5:], readRange(t, s, int64(len(content))-5, 100), name, "cut short at the end")
+		assert.Empty(t, readRange(t, s, int64(len(content)), 10), name)
+		assert.Empty(t, readRange(t, s, 3, 0), name)
+
+		_, err = s.OpenFileRange(ctx, "alice", "/missing.txt", 0, 10)
+		assert.Error(t, err, name)
+	}
+	assert.NotEmpty(t, f.objects)
+}
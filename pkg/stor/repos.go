@@ -2,6 +2,8 @@ package stor
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/cgang/file-hub/pkg/db"
 	"github.com/cgang/file-hub/pkg/model"
@@ -30,15 +32,53 @@ func CreateHomeRepo(ctx context.Context, user *model.User, rootDir string) error
 		return err
 	}
 
-	return nil
+	return db.InitVersion(ctx, repo.ID)
 }
 
+// GetRepository retrieves a repository by name, or by a former name during the grace period after a rename
 func GetRepository(ctx context.Context, name string) (*model.Repository, error) {
 	// TODO add caching layer here
-	return db.GetRepositoryByName(ctx, name)
+	repo, err := db.GetRepositoryByName(ctx, name)
+	if IsNotFound(err) {
+		return db.GetRepositoryByAlias(ctx, name, time.Now())
+	}
+	return repo, err
 }
 
-// ForUser creates a Storage instance for the given user based on their HomeDir
+// GetHomeRepo returns the home repository of a user: the one named after them, or the first one created
+// for them when they were renamed without their home repository
 func GetHomeRepo(ctx context.Context, user *model.User) (*model.Repository, error) {
-	return db.GetRepositoryByName(ctx, user.Username)
+	repos, err := db.ListRepositories(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var home *model.Repository
+	for _, repo := range repos {
+		if repo.Name == user.Username {
+			return repo, nil
+		}
+		if home == nil || repo.ID < home.ID {
+			home = repo
+		}
+	}
+	if home == nil {
+		return nil, sql.ErrNoRows
+	}
+	return home, nil
+}
+
+// RenameRepo moves the stored files of a repository to a new name. The caller renames the repository
+// itself, moving the files back if that fails.
+func RenameRepo(ctx context.Context, repo *model.Repository, name string) error {
+	storage, err := getStorage(repo)
+	if err != nil {
+		return err
+	}
+
+	renamer, ok := storage.(Renamer)
+	if !ok {
+		return ErrRenameUnsupported
+	}
+	return renamer.RenameRepo(ctx, repo.Name, name)
 }
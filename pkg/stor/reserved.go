@@ -0,0 +1,26 @@
+package stor
+
+import (
+	"errors"
+	"fmt"
+	"path"
+)
+
+// ErrReserved is returned writing to a folder the server keeps for itself, such as the trash folder
+var ErrReserved = errors.New("path is reserved by the server")
+
+// IsReserved reports whether a repository path lies inside a folder the server keeps for itself, which
+// clients cannot write to: what they put there would be mixed up with trashed or shared content. Names
+// of the temporary files of writes in progress are reserved too, as those left behind get removed.
+func IsReserved(p string) bool {
+	p = path.Clean("/" + p)
+	return isTrashPath(p) || isSnapshotPath(p) || isTempName(path.Base(p))
+}
+
+// checkReserved returns ErrReserved for a path inside a reserved folder
+func checkReserved(p string) error {
+	if IsReserved(p) {
+		return fmt.Errorf("%w: %s", ErrReserved, p)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package stor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsReserved(t *testing.T) {
+	for _, p := range []string{"/.trash", "/.trash/1-a.txt", ".snapshots/3-1/0", "/a/../.trash/x", "/docs/.filehub-tmp-123"} {
+		assert.True(t, IsReserved(p), p)
+		assert.ErrorIs(t, checkReserved(p), ErrReserved, p)
+	}
+	for _, p := range []string{"", "/", "/.trashed", "/docs/.trash", "/.snapshots-old", "/.notes.tmp"} {
+		assert.False(t, IsReserved(p), p)
+		assert.NoError(t, checkReserved(p), p)
+	}
+}
@@ -0,0 +1,231 @@
+package stor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	maxIdleHandles    = 64               // idle S3 reads kept open overall
+	maxIdlePerObject  = 8                // idle S3 reads kept open for a single object
+	handleIdleTimeout = 30 * time.Second // idle S3 reads are closed after this long
+	maxHandleSkip     = 256 * 1024       // most bytes skipped to reuse an idle read positioned before the offset wanted
+)
+
+// s3Handle is an open read of an S3 object, positioned at offset
+type s3Handle struct {
+	key    string
+	etag   string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+	timer  *time.Timer // closes the handle once idle for too long
+}
+
+// handlePool keeps the reads of S3 objects left open by closed readers. A reader continuing where another
+// stopped, such as the next segment of a download fetched in ranges, reads on instead of sending a new request.
+type handlePool struct {
+	mu    sync.Mutex
+	idle  map[string][]*s3Handle // by object key
+	count int
+}
+
+var s3Handles = newHandlePool()
+
+func newHandlePool() *handlePool {
+	return &handlePool{idle: make(map[string][]*s3Handle)}
+}
+
+// stat returns the size and ETag of an object known from its idle reads
+func (p *handlePool) stat(key string) (int64, string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if handles := p.idle[key]; len(handles) > 0 {
+		return handles[0].size, handles[0].etag, true
+	}
+	return 0, "", false
+}
+
+// take removes the idle read of an object version closest before offset from the pool, nil if none is close enough
+func (p *handlePool) take(key, etag string, offset int64) *s3Handle {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best := -1
+	handles := p.idle[key]
+	for i, h := range handles {
+		if h.etag == etag && h.offset <= offset && offset-h.offset <= maxHandleSkip &&
+			(best < 0 || h.offset > handles[best].offset) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+
+	h := handles[best]
+	p.remove(h)
+	h.timer.Stop()
+	return h
+}
+
+// put returns a read to the pool, or closes it if it is done or the pool is full
+func (p *handlePool) put(h *s3Handle) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if h.offset >= h.size || p.count >= maxIdleHandles || len(p.idle[h.key]) >= maxIdlePerObject {
+		h.body.Close()
+		return
+	}
+
+	p.idle[h.key] = append(p.idle[h.key], h)
+	p.count++
+	h.timer = time.AfterFunc(handleIdleTimeout, func() { p.expire(h) })
+}
+
+// expire closes a read idle for too long
+func (p *handlePool) expire(h *s3Handle) {
+	p.mu.Lock()
+	found := p.remove(h)
+	p.mu.Unlock()
+
+	if found {
+		h.body.Close()
+	}
+}
+
+// drop closes the idle reads of an object, which is being changed
+func (p *handlePool) drop(key string) {
+	p.mu.Lock()
+	handles := p.idle[key]
+	delete(p.idle, key)
+	p.count -= len(handles)
+	p.mu.Unlock()
+
+	for _, h := range handles {
+		h.timer.Stop()
+		h.body.Close()
+	}
+}
+
+// remove takes a read out of the pool, reporting whether it was there. The caller holds the lock.
+func (p *handlePool) remove(h *s3Handle) bool {
+	handles := p.idle[h.key]
+	for i := range handles {
+		if handles[i] == h {
+			if len(handles) == 1 {
+				delete(p.idle, h.key)
+			} else {
+				p.idle[h.key] = append(handles[:i:i], handles[i+1:]...)
+			}
+			p.count--
+			return true
+		}
+	}
+	return false
+}
+
+// s3Object reads an S3 object from any offset. Reads are only requested when data is read,
+// from the offset reached, and left open in the pool when the object is closed.
+type s3Object struct {
+	ctx    context.Context
+	bucket string
+	key    string
+	size   int64
+	etag   string
+	offset int64
+	handle *s3Handle // current read, nil if none is open
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.handle != nil && o.handle.offset != o.offset {
+		s3Handles.put(o.handle) // left for another reader after a seek
+		o.handle = nil
+	}
+	if o.handle == nil {
+		h, err := o.open()
+		if err != nil {
+			return 0, err
+		}
+		o.handle = h
+	}
+
+	n, err := o.handle.body.Read(p)
+	o.handle.offset += int64(n)
+	o.offset += int64(n)
+	if errors.Is(err, io.EOF) && o.offset < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		o.handle.body.Close()
+		o.handle = nil
+	}
+	return n, err
+}
+
+// open continues an idle read of the object near the current offset, or requests a new one
+func (o *s3Object) open() (*s3Handle, error) {
+	if h := s3Handles.take(o.key, o.etag, o.offset); h != nil {
+		skip := o.offset - h.offset
+		if _, err := io.CopyN(io.Discard, h.body, skip); err == nil {
+			h.offset = o.offset
+			return h, nil
+		}
+		h.body.Close()
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(o.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-", o.offset)),
+	}
+	if o.etag != "" {
+		input.IfMatch = aws.String(o.etag) // fail rather than mix versions when the object changed
+	}
+
+	// Reads outlive the request opening them when they are pooled
+	output, err := s3Client.GetObject(context.WithoutCancel(o.ctx), input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s at %d: %w", o.key, o.offset, err)
+	}
+	return &s3Handle{key: o.key, etag: o.etag, size: o.size, offset: o.offset, body: output.Body}, nil
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.handle != nil {
+		s3Handles.put(o.handle)
+		o.handle = nil
+	}
+	return nil
+}
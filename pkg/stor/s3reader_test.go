@@ -0,0 +1,122 @@
+package stor
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBody is the body of an S3 read over content from an offset
+type fakeBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *fakeBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func newFakeHandle(key, content string, offset int64) (*s3Handle, *fakeBody) {
+	body := &fakeBody{Reader: strings.NewReader(content[offset:])}
+	return &s3Handle{key: key, etag: `"v1"`, size: int64(len(content)), offset: offset, body: body}, body
+}
+
+func TestHandlePool(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+
+	t.Run("Take closest read before offset", func(t *testing.T) {
+		pool := newHandlePool()
+		h1, _ := newFakeHandle("a", content, 100)
+		h2, _ := newFakeHandle("a", content, 300)
+		pool.put(h1)
+		pool.put(h2)
+
+		size, etag, ok := pool.stat("a")
+		assert.True(t, ok)
+		assert.Equal(t, int64(len(content)), size)
+		assert.Equal(t, `"v1"`, etag)
+
+		assert.Nil(t, pool.take("a", `"v1"`, 50), "no read before the offset")
+		assert.Nil(t, pool.take("a", `"v2"`, 300), "read of another version")
+		assert.Same(t, h2, pool.take("a", `"v1"`, 400))
+		assert.Same(t, h1, pool.take("a", `"v1"`, 100))
+		assert.Zero(t, pool.count)
+
+		_, _, ok = pool.stat("a")
+		assert.False(t, ok)
+	})
+
+	t.Run("Finished reads are closed", func(t *testing.T) {
+		pool := newHandlePool()
+		h, body := newFakeHandle("a", content, int64(len(content)))
+		pool.put(h)
+		assert.True(t, body.closed)
+		assert.Zero(t, pool.count)
+	})
+
+	t.Run("Pool is bounded per object", func(t *testing.T) {
+		pool := newHandlePool()
+		var last *fakeBody
+		for i := range maxIdlePerObject + 1 {
+			var h *s3Handle
+			h, last = newFakeHandle("a", content, int64(i))
+			pool.put(h)
+		}
+		assert.True(t, last.closed)
+		assert.Equal(t, maxIdlePerObject, pool.count)
+	})
+
+	t.Run("Drop closes idle reads", func(t *testing.T) {
+		pool := newHandlePool()
+		h, body := newFakeHandle("a", content, 10)
+		pool.put(h)
+		pool.drop("a")
+		assert.True(t, body.closed)
+		assert.Zero(t, pool.count)
+	})
+
+	t.Run("Expire closes idle reads", func(t *testing.T) {
+		pool := newHandlePool()
+		h, body := newFakeHandle("a", content, 10)
+		pool.put(h)
+		pool.expire(h)
+		assert.True(t, body.closed)
+		assert.Nil(t, pool.take("a", `"v1"`, 10))
+	})
+}
+
+func TestS3ObjectReusesReads(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	saved := s3Handles
+	s3Handles = newHandlePool()
+	t.Cleanup(func() { s3Handles = saved })
+
+	// A segment ending at 500 leaves its read open
+	h, body := newFakeHandle("obj", content, 500)
+	s3Handles.put(h)
+
+	// The next segment continues it, after skipping a few bytes
+	obj := &s3Object{key: "obj", size: int64(len(content)), etag: `"v1"`}
+	pos, err := obj.Seek(510, io.SeekStart)
+	require.NoError(t, err)
+	assert.Equal(t, int64(510), pos)
+
+	segment := make([]byte, 90)
+	_, err = io.ReadFull(obj, segment)
+	require.NoError(t, err)
+	assert.Equal(t, content[510:600], string(segment))
+
+	// and leaves it for the segment after
+	require.NoError(t, obj.Close())
+	assert.False(t, body.closed)
+	assert.Same(t, h, s3Handles.take("obj", `"v1"`, 600))
+	assert.Equal(t, int64(600), h.offset)
+
+	size, err := obj.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+}
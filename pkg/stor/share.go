@@ -2,39 +2,31 @@ package stor
 
 import (
 	"context"
-	"errors"
+	"time"
 
 	"github.com/cgang/file-hub/pkg/db"
 	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/notify"
 )
 
-type Permission int
-
-const (
-	PermissionUnknown Permission = iota
-	PermissionRead
-	PermissionWrite
-	PermissionDelete
-)
-
-func CheckPermission(ctx context.Context, userID int, resource *model.Resource, perm Permission) error {
-	if userID == resource.Repo.OwnerID {
-		return nil // Owner has all permissions
-	}
-
-	share, err := db.GetShareForObject(ctx, userID, resource)
+// PurgeExpiredShares removes the shares expired before now and tells their owners,
+// returning how many were removed
+func PurgeExpiredShares(ctx context.Context, now time.Time) (int, error) {
+	shares, err := db.PurgeExpiredShares(ctx, now)
 	if err != nil {
-		return err
-	}
-
-	if share == nil {
-		return errors.New("object not shared with user")
+		return 0, err
 	}
 
-	if perm == PermissionRead {
-		return nil // Read permission granted
+	for _, share := range shares {
+		repoName, userName := "", ""
+		if repo, err := db.GetRepositoryByID(ctx, share.RepoID); err == nil {
+			repoName = repo.Name
+		}
+		if user, err := db.GetUserByID(ctx, share.UserID); err == nil {
+			userName = user.Username
+		}
+		notify.Send(ctx, share.OwnerID, model.NotifyShareExpired, "notify.share_expired",
+			share.Path, repoName, userName)
 	}
-
-	// TODO handle write and delete permissions based on share settings
-	return errors.New("permission denied")
+	return len(shares), nil
 }
@@ -0,0 +1,56 @@
+package stor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// SnapshotDir is the storage folder holding the content of files shared by snapshot links, copied when
+// the links are published. Like the trash it is not part of the repository tree and is skipped when scanning.
+const SnapshotDir = "/.snapshots"
+
+// isSnapshotPath reports whether a storage path lies inside the snapshot folder
+func isSnapshotPath(name string) bool {
+	return name == SnapshotDir || strings.HasPrefix(name, SnapshotDir+"/")
+}
+
+// CopyToSnapshot copies the content of a file of a repository to a storage name inside its snapshot folder
+func CopyToSnapshot(ctx context.Context, repo *model.Repository, filePath, storedName string) error {
+	if !isSnapshotPath(storedName) {
+		return fmt.Errorf("%s is not inside the snapshot folder", storedName)
+	}
+
+	storage, err := getStorage(repo)
+	if err != nil {
+		return err
+	}
+
+	if _, err := storage.CopyFile(ctx, repo.Name, filePath, storedName); err != nil {
+		return fmt.Errorf("failed to copy %s to snapshot: %w", filePath, err)
+	}
+	return nil
+}
+
+// DeleteSnapshotFiles removes the stored content of snapshot files, logging what cannot be removed
+func DeleteSnapshotFiles(ctx context.Context, repo *model.Repository, files []*model.SnapshotFile) {
+	storage, err := getStorage(repo)
+	if err != nil {
+		log.Printf("Failed to remove snapshot files of %s: %s", repo.Name, err)
+		return
+	}
+
+	for _, file := range files {
+		if file.StoredName == "" {
+			continue
+		}
+		if err := storage.DeleteFile(ctx, repo.Name, file.StoredName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to remove snapshot file %s of %s: %s", file.StoredName, repo.Name, err)
+		}
+	}
+}
@@ -6,24 +6,27 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
-	"net/url"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/cgang/file-hub/pkg/config"
 	"github.com/cgang/file-hub/pkg/db"
 	"github.com/cgang/file-hub/pkg/model"
 )
 
 type FileMeta struct {
-	Name    string
-	Path    string
-	IsDir   bool
-	Size    int64
-	ModTime time.Time
+	Name        string
+	Path        string
+	IsDir       bool
+	Size        int64
+	ModTime     time.Time
+	Checksum    string // hex SHA-256 of the content, when the backend knows it
+	ContentType string // sniffed from the content, when written through the server
 }
 
 func newFileMeta(fullname string, mt time.Time) *FileMeta {
@@ -44,7 +47,7 @@ func newDirMeta(fullname string, mt time.Time) *FileMeta {
 }
 
 func (m *FileMeta) toObject(repoID, ownerID, parentID int) *model.FileObject {
-	return &model.FileObject{
+	object := &model.FileObject{
 		RepoID:   repoID,
 		OwnerID:  ownerID,
 		ParentID: parentID,
@@ -54,6 +57,13 @@ func (m *FileMeta) toObject(repoID, ownerID, parentID int) *model.FileObject {
 		ModTime:  m.ModTime,
 		IsDir:    m.IsDir,
 	}
+	if m.Checksum != "" {
+		object.Checksum = &m.Checksum
+	}
+	if m.ContentType != "" {
+		object.MimeType = &m.ContentType
+	}
+	return object
 }
 
 var (
@@ -63,8 +73,49 @@ var (
 func Init(ctx context.Context, cfg *config.Config) {
 	if cfg.S3 != nil {
 		s3Client = newS3Client(cfg.S3)
+		if size := cfg.S3.PartSize; size != 0 {
+			if size < minS3PartSize {
+				log.Fatalf("Invalid S3 part size %d, at least %d bytes", size, minS3PartSize)
+			}
+			s3PartSize = int(size)
+		}
+		defaults, err := newS3ObjectOptions(cfg.S3.SSE, cfg.S3.SSEKMSKeyID, cfg.S3.StorageClass)
+		if err != nil {
+			log.Fatalf("Invalid S3 configuration: %s", err)
+		}
+		s3Defaults = defaults
+		presignExpiry = cfg.S3.PresignExpiry
+	}
+	if cfg.Azure != nil {
+		client, err := newAzureClient(cfg.Azure)
+		if err != nil {
+			log.Fatalf("Invalid Azure storage configuration: %s", err)
+		}
+		azClient = client
+	}
+	if cfg.GCS != nil {
+		client, err := newGCSClient(cfg.GCS)
+		if err != nil {
+			log.Fatalf("Invalid Google Cloud Storage configuration: %s", err)
+		}
+		gcClient = client
 	}
 	rootDirs = cfg.RootDir
+	slowThreshold = cfg.Storage.SlowThreshold
+	dedup = cfg.Storage.Dedup
+	if cfg.Storage.Encryption.Enabled() {
+		keys, err := cfg.Storage.Encryption.MasterKeys()
+		if err == nil {
+			encryption, err = newKeyring(keys)
+		}
+		if err != nil {
+			log.Fatalf("Invalid storage encryption configuration: %s", err)
+		}
+	}
+	maxTreeDepth = cfg.Storage.MaxTreeDepth
+	maxTreeEntries = cfg.Storage.MaxTreeEntries
+	maxFileSize = cfg.Storage.MaxFileSize
+	coldRoots = cfg.Storage.ColdRoots
 }
 
 // IsNotFound return true if err is something not found.
@@ -109,22 +160,24 @@ func GetFileInfo(ctx context.Context, resource *model.Resource) (*model.FileObje
 		return nil, err
 	}
 
-	if file.IsDir || file.MimeType != nil {
-		return file, nil
-	}
+	guessContentType(file)
+	return file, nil
+}
 
-	storage, err := getStorage(resource.Repo)
+// GetFileInfos returns the files of a repository at the given paths by path, looked up in one query.
+// Paths without a file are left out.
+func GetFileInfos(ctx context.Context, repo *model.Repository, paths []string) (map[string]*model.FileObject, error) {
+	files, err := db.GetFilesByPaths(ctx, repo.ID, paths)
 	if err != nil {
 		return nil, err
 	}
 
-	if ct, err := storage.GetContentType(ctx, resource.Repo.Name, resource.Path); err == nil {
-		file.MimeType = &ct
-	} else {
-		log.Printf("Failed to get content type for %s: %s", resource.Path, err)
+	found := make(map[string]*model.FileObject, len(files))
+	for _, file := range files {
+		guessContentType(file)
+		found[file.Path] = file
 	}
-
-	return file, nil
+	return found, nil
 }
 
 // ListDir lists the contents of a directory
@@ -133,51 +186,132 @@ func ListDir(ctx context.Context, repo *model.Repository, parent *model.FileObje
 		return nil, nil // return nil for non directory files
 	}
 
-	storage, err := getStorage(repo)
-	if err != nil {
-		return nil, err
-	}
-
 	objects, err := db.GetChildFiles(ctx, parent.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	var changed []*model.FileObject
 	for _, obj := range objects {
-		if obj.MimeType != nil {
-			continue
-		}
+		guessContentType(obj)
+	}
+	return objects, nil
+}
 
-		if ct, err := storage.GetContentType(ctx, repo.Name, obj.Path); err == nil {
-			obj.MimeType = aws.String(ct)
-		} else {
-			obj.MimeType = aws.String(getContentType(path.Ext(obj.Name)))
-		}
-		changed = append(changed, obj)
+// StreamDir calls visit for the children of a directory in name order without loading the whole listing.
+// Children before offset are skipped and at most limit are visited; a zero limit visits all the rest.
+func StreamDir(ctx context.Context, repo *model.Repository, parent *model.FileObject, offset, limit int, visit func(*model.FileObject) error) error {
+	if !parent.IsDir {
+		return nil
 	}
 
-	if len(changed) > 0 {
-		if err := db.UpdateContentType(ctx, changed); err != nil {
-			log.Printf("Failed to update content type: %s", err)
-		}
+	return db.StreamChildFiles(ctx, parent.ID, model.ListOrder{}, offset, limit, func(obj *model.FileObject) error {
+		guessContentType(obj)
+		return visit(obj)
+	})
+}
+
+// ContentType returns the content type of a file, as recorded or else as its storage reports it,
+// guessed from its name when the storage cannot tell
+func ContentType(ctx context.Context, repo *model.Repository, obj *model.FileObject) (string, error) {
+	if obj.MimeType != nil {
+		return *obj.MimeType, nil
 	}
 
-	return objects, nil
+	storage, err := getStorage(repo)
+	if err != nil {
+		return "", err
+	}
+	if ct, err := storage.GetContentType(ctx, repo.Name, obj.Path); err == nil {
+		return ct, nil
+	}
+	return getContentType(path.Ext(obj.Name)), nil
 }
 
-// CreateDir creates a directory entry in the database
-func CreateDir(ctx context.Context, resource *model.Resource) error {
+// DetectContentType returns the content type of a file sniffed from the start of its content, failing while
+// the backend is unavailable rather than guessing
+func DetectContentType(ctx context.Context, repo *model.Repository, obj *model.FileObject) (string, error) {
+	storage, err := getStorage(repo)
+	if err != nil {
+		return "", err
+	}
+	return sniffFile(ctx, storage, repo, obj.Path)
+}
+
+// guessContentType fills in the content type of a file recorded without one from its name. Types are
+// detected once as files are written, and lookups and listings never ask the storage backend, so that they
+// stay cheap and keep working while it is unavailable; the backfill records types for older files.
+func guessContentType(obj *model.FileObject) {
+	if obj.IsDir || obj.MimeType != nil {
+		return
+	}
+	ct := getContentType(path.Ext(obj.Name))
+	obj.MimeType = &ct
+}
+
+// ErrParentNotFound is returned when a directory is created below a parent that does not exist
+var ErrParentNotFound = errors.New("parent directory does not exist")
+
+// CreateDir creates a directory entry in the database, linked to its parent, and returns it.
+// Missing parent directories are created too if created is set, which is called with each of them,
+// parents first, so the caller can record them; otherwise ErrParentNotFound is returned.
+func CreateDir(ctx context.Context, resource *model.Resource, created func(dir *model.FileObject)) (*model.FileObject, error) {
+	dirPath := path.Join("/", resource.Path)
+	if dirPath == "/" {
+		return nil, fmt.Errorf("cannot create repository root")
+	}
+	if err := checkReserved(dirPath); err != nil {
+		return nil, err
+	}
+	if err := checkTreeDepth(dirPath); err != nil {
+		return nil, err
+	}
+
+	parent, err := ResolveParent(ctx, resource.Repo, dirPath, created)
+	if err != nil {
+		return nil, err
+	}
+
 	object := &model.FileObject{
-		RepoID:  resource.Repo.ID,
-		OwnerID: resource.Repo.OwnerID,
-		Name:    path.Base(resource.Path),
-		Path:    path.Clean(resource.Path),
-		Size:    0,
-		IsDir:   true,
+		RepoID:   resource.Repo.ID,
+		OwnerID:  resource.Repo.OwnerID,
+		ParentID: parent.ID,
+		Name:     path.Base(dirPath),
+		Path:     dirPath,
+		Size:     0,
+		ModTime:  time.Now(),
+		IsDir:    true,
 	}
 
-	return db.CreateFile(ctx, object)
+	if err := db.CreateFile(ctx, object); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// ResolveParent returns the directory a file of the repository belongs in. A missing parent is created,
+// with its own missing parents, if created is set, which is called with each directory created;
+// otherwise ErrParentNotFound is returned.
+func ResolveParent(ctx context.Context, repo *model.Repository, filePath string, created func(dir *model.FileObject)) (*model.FileObject, error) {
+	parentPath := path.Dir(path.Join("/", filePath))
+	if parentPath == "/" {
+		parentPath = "" // repository root
+	}
+
+	parent, err := db.GetFile(ctx, repo.ID, parentPath)
+	if IsNotFound(err) && created != nil && parentPath != "" {
+		if parent, err = CreateDir(ctx, &model.Resource{Repo: repo, Path: parentPath}, created); err == nil {
+			created(parent)
+		}
+	} else if IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %s", ErrParentNotFound, parentPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !parent.IsDir {
+		return nil, fmt.Errorf("%w: %s is a file", ErrParentNotFound, parentPath)
+	}
+	return parent, nil
 }
 
 // Storage defines the interface for file storage backends
@@ -186,6 +320,8 @@ type Storage interface {
 	PutFile(ctx context.Context, repo, name string, data io.Reader) (*FileMeta, error)
 	// OpenFile opens a file for reading from the storage backend
 	OpenFile(ctx context.Context, repo, name string) (io.ReadCloser, error)
+	// OpenFileRange opens length bytes of a file from offset on, or the rest of it when length is negative
+	OpenFileRange(ctx context.Context, repo, name string, offset, length int64) (io.ReadCloser, error)
 	// DeleteFile deletes a file from the storage backend
 	DeleteFile(ctx context.Context, repo, name string) error
 	// CopyFile copies a file within the storage backend
@@ -196,36 +332,38 @@ type Storage interface {
 	GetContentType(ctx context.Context, repo, name string) (string, error)
 }
 
-// getStorage returns the appropriate Storage implementation based on the repository's Root URL
-func getStorage(repo *model.Repository) (Storage, error) {
-	u, err := url.Parse(repo.Root)
-	if err != nil {
-		return nil, err
-	}
+// Linker is implemented by storage backends that can store one copy of content under several names
+type Linker interface {
+	// LinkFile replaces destName with a reference to the content of srcName
+	LinkFile(ctx context.Context, srcRepo, srcName, destRepo, destName string) error
+}
 
-	switch u.Scheme {
-	case "s3":
-		return &s3Storage{u.Host}, nil
-	case "file", "":
-		return &fsStorage{u.Path}, nil
-	default:
-		return nil, errors.New("unsupported storage scheme: " + u.Scheme)
-	}
+// ModTimeSetter is implemented by storage backends that can change the modification time of a file
+type ModTimeSetter interface {
+	// SetModTime changes the modification time of name
+	SetModTime(ctx context.Context, repo, name string, modTime time.Time) error
 }
 
-// PutFile uploads a file to the appropriate storage backend
-func PutFile(ctx context.Context, res *model.Resource, dataReader io.Reader) error {
-	storage, err := getStorage(res.Repo)
-	if err != nil {
-		return err
-	}
+// Renamer is implemented by storage backends that can move all files of a repository to another name
+type Renamer interface {
+	// RenameRepo moves the files stored under repo to name
+	RenameRepo(ctx context.Context, repo, name string) error
+}
+
+// ErrRenameUnsupported is returned when the storage cannot rename a repository
+var ErrRenameUnsupported = errors.New("storage does not support renaming repositories")
+
+// ErrLinkUnsupported is returned when the storage cannot share content between the files
+var ErrLinkUnsupported = errors.New("storage does not support dedup references")
 
-	meta, err := storage.PutFile(ctx, res.Repo.Name, res.Path, dataReader)
+// PutFile uploads a file to the appropriate storage backend, streaming it under the configured size limit,
+// and returns the SHA-256 of its content, recorded with the file
+func PutFile(ctx context.Context, res *model.Resource, dataReader io.Reader) (string, error) {
+	meta, err := PutStream(ctx, res, dataReader, 0, nil)
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	return updateFileMeta(ctx, res.Repo, meta)
+	return meta.Checksum, nil
 }
 
 // OpenFile opens a file for reading from the appropriate storage backend
@@ -238,20 +376,56 @@ func OpenFile(ctx context.Context, resource *model.Resource) (io.ReadCloser, err
 	return storage.OpenFile(ctx, resource.Repo.Name, resource.Path)
 }
 
-// DeleteFile deletes a file from the appropriate storage backend
+// DeleteFile deletes a file, or an empty directory, from the appropriate storage backend and from the database.
+// Directories may only exist in the database, so content already gone from storage is not an error; content
+// without a record is removed too, though the missing record is still reported.
 func DeleteFile(ctx context.Context, resource *model.Resource) error {
+	file, findErr := db.GetFile(ctx, resource.Repo.ID, resource.Path)
+	if findErr == nil {
+		if err := checkFileMutable(file); err != nil {
+			return err
+		}
+	}
+
 	storage, err := getStorage(resource.Repo)
 	if err != nil {
 		return err
 	}
 
-	return storage.DeleteFile(ctx, resource.Repo.Name, resource.Path)
+	if err := storage.DeleteFile(ctx, resource.Repo.Name, resource.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if findErr != nil {
+		return findErr
+	}
+	return db.DeleteFileByPath(ctx, resource.Repo.ID, resource.Path)
+}
+
+// DeleteTree deletes a file, or a directory with everything below it, children first.
+// Nothing is deleted if any of it is immutable.
+func DeleteTree(ctx context.Context, resource *model.Resource) error {
+	file, err := db.GetFile(ctx, resource.Repo.ID, resource.Path)
+	if err != nil {
+		return err
+	}
+	if err := CheckTreeMutable(ctx, resource); err != nil {
+		return err
+	}
+
+	return WalkTree(ctx, file, true, func(item *model.FileObject) error {
+		return DeleteFile(ctx, &model.Resource{Repo: resource.Repo, Path: item.Path})
+	})
 }
 
-// CopyFile copies a file within the same repository in the appropriate storage backend
+// CopyFile copies a file in the appropriate storage backend. Files copied to another repository,
+// such as a folder shared with the user, are read and written through the server.
 func CopyFile(ctx context.Context, srcResource *model.Resource, destResource *model.Resource) error {
+	if err := CheckMutable(ctx, destResource); err != nil {
+		return err
+	}
 	if srcResource.Repo.ID != destResource.Repo.ID {
-		return errors.New("cross-repository copy not supported yet")
+		return copyAcross(ctx, srcResource, destResource)
 	}
 
 	storage, err := getStorage(srcResource.Repo)
@@ -267,23 +441,155 @@ func CopyFile(ctx context.Context, srcResource *model.Resource, destResource *mo
 	return updateFileMeta(ctx, destResource.Repo, meta)
 }
 
-// MoveFile moves a file within the same repository in the appropriate storage backend
-func MoveFile(ctx context.Context, srcResource *model.Resource, destResource *model.Resource) error {
-	if srcResource.Repo.ID != destResource.Repo.ID {
-		return errors.New("cross-repository move not supported yet")
+// copyWorkers is how many files of a tree are copied at once
+const copyWorkers = 4
+
+// ErrCopyIntoItself is returned when a directory is copied to a path below itself
+var ErrCopyIntoItself = errors.New("cannot copy a directory into itself")
+
+// CopyTree copies a directory of a repository with everything below it to dest, merging into a directory
+// already there. Directories are recorded first, then files are copied by several workers, keeping their
+// checksum and content type. Items failing are reported to done with their error and the others copied all
+// the same; those below a directory that failed fail too. done is called for each item, never concurrently.
+// Like CopyFile and MoveFile, it fails with ErrCrossRepoDir for a dest in another repository.
+func CopyTree(ctx context.Context, src *model.Resource, dest *model.Resource, done func(item *model.FileObject, err error)) (*model.FileObject, error) {
+	if src.Repo.ID != dest.Repo.ID {
+		return nil, ErrCrossRepoDir
+	}
+	srcPath, destPath := path.Join("/", src.Path), path.Join("/", dest.Path)
+	if destPath == srcPath || strings.HasPrefix(destPath, srcPath+"/") {
+		return nil, ErrCopyIntoItself
 	}
 
-	storage, err := getStorage(srcResource.Repo)
+	storage, err := getStorage(src.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := db.ListFilesUnder(ctx, src.Repo.ID, srcPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTreeSize(len(items)+1, srcPath); err != nil {
+		return nil, err
+	}
+
+	root, err := copyDir(ctx, dest.Repo, destPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Directories come before their content, so their copies exist by the time it is copied
+	dirIDs := map[string]int{srcPath: root.ID}
+	var files []*model.FileObject
+	for _, item := range items {
+		if !item.IsDir {
+			files = append(files, item)
+			continue
+		}
+		if _, ok := dirIDs[path.Dir(item.Path)]; !ok {
+			done(item, fmt.Errorf("%w: %s was not copied", ErrParentNotFound, path.Dir(item.Path)))
+			continue
+		}
+
+		dir, err := copyDir(ctx, dest.Repo, destPath+strings.TrimPrefix(item.Path, srcPath))
+		if err == nil {
+			dirIDs[item.Path] = dir.ID
+		}
+		done(item, err)
+	}
+
+	var mu sync.Mutex
+	queue := make(chan *model.FileObject)
+	var wg sync.WaitGroup
+	for range min(copyWorkers, len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range queue {
+				var err error
+				if parentID, ok := dirIDs[path.Dir(file.Path)]; ok {
+					err = copyObject(ctx, storage, dest.Repo, file, destPath+strings.TrimPrefix(file.Path, srcPath), parentID)
+				} else {
+					err = fmt.Errorf("%w: %s was not copied", ErrParentNotFound, path.Dir(file.Path))
+				}
+
+				mu.Lock()
+				done(file, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- file
+	}
+	close(queue)
+	wg.Wait()
+
+	return root, ctx.Err()
+}
+
+// copyDir records a directory copied to dirPath, or returns the one already there
+func copyDir(ctx context.Context, repo *model.Repository, dirPath string) (*model.FileObject, error) {
+	existing, err := db.GetFile(ctx, repo.ID, dirPath)
+	if err == nil {
+		if !existing.IsDir {
+			return nil, fmt.Errorf("%s exists and is a file", dirPath)
+		}
+		return existing, nil
+	}
+	if !IsNotFound(err) {
+		return nil, err
+	}
+	return CreateDir(ctx, &model.Resource{Repo: repo, Path: dirPath}, nil)
+}
+
+// copyObject copies the content of a file to destPath and records the copy with the checksum
+// and content type of the original
+func copyObject(ctx context.Context, storage Storage, repo *model.Repository, file *model.FileObject, destPath string, parentID int) error {
+	if err := CheckMutable(ctx, &model.Resource{Repo: repo, Path: destPath}); err != nil {
+		return err
+	}
+
+	meta, err := storage.CopyFile(ctx, repo.Name, file.Path, destPath)
 	if err != nil {
 		return err
 	}
 
-	meta, err := storage.CopyFile(ctx, srcResource.Repo.Name, srcResource.Path, destResource.Path)
+	object := meta.toObject(repo.ID, repo.OwnerID, parentID)
+	if object.Checksum == nil && meta.Size == file.Size {
+		object.Checksum = file.Checksum
+	}
+	object.MimeType = file.MimeType
+	return db.UpsertFile(ctx, object)
+}
+
+// MoveFile moves a file in the appropriate storage backend, across repositories like CopyFile
+func MoveFile(ctx context.Context, srcResource *model.Resource, destResource *model.Resource) error {
+	if err := CheckMutable(ctx, srcResource); err != nil {
+		return err
+	}
+	if err := CheckMutable(ctx, destResource); err != nil {
+		return err
+	}
+
+	storage, err := getStorage(srcResource.Repo)
 	if err != nil {
 		return err
 	}
 
-	if err = updateFileMeta(ctx, destResource.Repo, meta); err != nil {
+	if srcResource.Repo.ID != destResource.Repo.ID {
+		err = copyAcross(ctx, srcResource, destResource)
+	} else {
+		var meta *FileMeta
+		if meta, err = storage.CopyFile(ctx, srcResource.Repo.Name, srcResource.Path, destResource.Path); err == nil {
+			err = updateFileMeta(ctx, destResource.Repo, meta)
+		}
+	}
+	if err != nil {
 		return err
 	}
 
@@ -294,26 +600,283 @@ func MoveFile(ctx context.Context, srcResource *model.Resource, destResource *mo
 	return db.DeleteFileByPath(ctx, srcResource.Repo.ID, srcResource.Path)
 }
 
+var (
+	// ErrRenameConflict is returned renaming a file to a name taken in its directory
+	ErrRenameConflict = errors.New("a file with the new name already exists")
+	// ErrRenameDir is returned renaming a directory, which is moved instead
+	ErrRenameDir = errors.New("only files can be renamed")
+)
+
+// RenameFile gives a file another path in the same directory, keeping its checksum, content type and
+// modification time. It fails with ErrRenameConflict if something exists at destPath, which the database
+// decides as it records the new path, before any content is written there.
+func RenameFile(ctx context.Context, res *model.Resource, destPath string) error {
+	file, err := db.GetFile(ctx, res.Repo.ID, res.Path)
+	if err != nil {
+		return err
+	}
+	if file.IsDir {
+		return ErrRenameDir
+	}
+	if err := checkFileMutable(file); err != nil {
+		return err
+	}
+	if err := checkReserved(destPath); err != nil {
+		return err
+	}
+
+	storage, err := getStorage(res.Repo)
+	if err != nil {
+		return err
+	}
+
+	renamed := &model.FileObject{
+		RepoID:       res.Repo.ID,
+		OwnerID:      file.OwnerID,
+		ParentID:     file.ParentID,
+		Name:         path.Base(destPath),
+		Path:         destPath,
+		Size:         file.Size,
+		Checksum:     file.Checksum,
+		MimeType:     file.MimeType,
+		ModTime:      file.ModTime,
+		Flags:        file.Flags,
+		ClassifiedAt: file.ClassifiedAt,
+	}
+	ok, err := db.RenameFile(ctx, res.Path, renamed, func() error {
+		_, err := storage.CopyFile(ctx, res.Repo.Name, res.Path, destPath)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrRenameConflict, destPath)
+	}
+
+	if setter, ok := storage.(ModTimeSetter); ok {
+		err := setter.SetModTime(ctx, res.Repo.Name, destPath, file.ModTime)
+		if err != nil && !errors.Is(err, errModTimeUnsupported) {
+			log.Printf("Failed to set modification time of %s: %s", destPath, err)
+		}
+	}
+
+	return storage.DeleteFile(ctx, res.Repo.Name, res.Path)
+}
+
+// ErrCrossRepoDir is returned copying or moving a directory to another repository
+var ErrCrossRepoDir = errors.New("directories cannot be copied or moved to another repository")
+
+// copyAcross copies a file to another repository and records the copy, owned by the owner of that
+// repository, with the checksum and content type of the original
+func copyAcross(ctx context.Context, src *model.Resource, dest *model.Resource) error {
+	file, err := db.GetFile(ctx, src.Repo.ID, src.Path)
+	if err != nil {
+		return err
+	}
+	if file.IsDir {
+		return ErrCrossRepoDir
+	}
+
+	parent, err := ResolveParent(ctx, dest.Repo, dest.Path, nil)
+	if err != nil {
+		return err
+	}
+
+	from, err := getStorage(src.Repo)
+	if err != nil {
+		return err
+	}
+	to, err := getStorage(dest.Repo)
+	if err != nil {
+		return err
+	}
+
+	meta, err := transferFile(ctx, from, src, to, dest)
+	if err != nil {
+		return err
+	}
+
+	object := meta.toObject(dest.Repo.ID, dest.Repo.OwnerID, parent.ID)
+	if object.Checksum == nil && meta.Size == file.Size {
+		object.Checksum = file.Checksum
+	}
+	object.MimeType = file.MimeType
+	return db.UpsertFile(ctx, object)
+}
+
+// transferFile copies the content of a file to a repository that may be stored elsewhere, through the server
+func transferFile(ctx context.Context, from Storage, src *model.Resource, to Storage, dest *model.Resource) (*FileMeta, error) {
+	reader, err := from.OpenFile(ctx, src.Repo.Name, src.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return to.PutFile(ctx, dest.Repo.Name, dest.Path, reader)
+}
+
+// SetModTime records the modification time a client gave for a file.
+// Backends that keep their own times are updated too, so a rescan doesn't undo it.
+func SetModTime(ctx context.Context, resource *model.Resource, modTime time.Time) error {
+	storage, err := getStorage(resource.Repo)
+	if err != nil {
+		return err
+	}
+
+	if setter, ok := storage.(ModTimeSetter); ok {
+		err := setter.SetModTime(ctx, resource.Repo.Name, resource.Path, modTime)
+		if err != nil && !errors.Is(err, errModTimeUnsupported) {
+			log.Printf("Failed to set modification time of %s: %s", resource, err)
+		}
+	}
+
+	return db.UpdateModTime(ctx, resource.Repo.ID, resource.Path, modTime)
+}
+
+// LinkFile replaces the destination file with a dedup reference to the source file's content.
+// Both repositories must live in the same storage location.
+func LinkFile(ctx context.Context, srcResource *model.Resource, destResource *model.Resource) error {
+	if srcResource.Repo.Root != destResource.Repo.Root {
+		return ErrLinkUnsupported
+	}
+	if err := CheckMutable(ctx, destResource); err != nil {
+		return err
+	}
+
+	storage, err := getStorage(srcResource.Repo)
+	if err != nil {
+		return err
+	}
+
+	linker, ok := storage.(Linker)
+	if !ok {
+		return ErrLinkUnsupported
+	}
+
+	return linker.LinkFile(ctx, srcResource.Repo.Name, srcResource.Path, destResource.Repo.Name, destResource.Path)
+}
+
+// Scanned files are recorded scanBatchSize at a time, by scanWorkers at once
+const (
+	scanBatchSize = 500
+	scanWorkers   = 4
+)
+
 // ScanFiles scan existing files from storage location, and update metadata accordingly.
+// Directories are recorded as they are found, so that what they hold can refer to them. Files are
+// recorded in batches by several workers, which also sniff the content type of those without one.
+// The first failure stops the scan.
 func ScanFiles(ctx context.Context, repo *model.Repository) error {
 	storage, err := getStorage(repo)
 	if err != nil {
 		return err
 	}
 
-	return storage.Scan(ctx, repo.Name, func(fm *FileMeta) error {
+	root, err := db.GetFile(ctx, repo.ID, "")
+	if err != nil {
+		return fmt.Errorf("get root failed: %s", err)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	queue := make(chan []*model.FileObject)
+	var wg sync.WaitGroup
+	for range scanWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range queue {
+				if err := recordScanned(ctx, storage, repo, batch); err != nil {
+					cancel(err)
+				}
+			}
+		}()
+	}
+
+	dirIDs := map[string]int{"": root.ID}
+	var batch []*model.FileObject
+	flush := func() error {
+		select {
+		case queue <- batch:
+			batch = nil
+			return nil
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+
+	err = storage.Scan(ctx, repo.Name, func(fm *FileMeta) error {
+		if ctx.Err() != nil {
+			return context.Cause(ctx) // a worker failed
+		}
 		if fm.Path == "" {
 			return nil // skip repository root
 		}
-		return updateFileMeta(ctx, repo, fm)
+		if isTrashPath(fm.Path) || isSnapshotPath(fm.Path) {
+			return nil // trashed and snapshot files are tracked separately
+		}
+
+		parentID, ok := dirIDs[parentPath(fm.Path)]
+		if !ok {
+			parent, err := db.GetFile(ctx, repo.ID, parentPath(fm.Path))
+			if err != nil {
+				return fmt.Errorf("get %s failed: %s", parentPath(fm.Path), err)
+			}
+			parentID = parent.ID
+		}
+
+		object := fm.toObject(repo.ID, repo.OwnerID, parentID)
+		if fm.IsDir {
+			if err := db.UpsertFiles(ctx, []*model.FileObject{object}); err != nil {
+				return err
+			}
+			dirIDs[strings.TrimSuffix(fm.Path, "/")] = object.ID
+			return nil
+		}
+
+		if batch = append(batch, object); len(batch) >= scanBatchSize {
+			return flush()
+		}
+		return nil
 	})
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+	close(queue)
+	wg.Wait()
+
+	if err == nil {
+		err = context.Cause(ctx)
+	}
+	return err
 }
 
-func updateFileMeta(ctx context.Context, repo *model.Repository, fm *FileMeta) error {
-	dir := path.Dir(fm.Path)
+// recordScanned records a batch of scanned files, then sniffs the content type of those without one
+func recordScanned(ctx context.Context, storage Storage, repo *model.Repository, files []*model.FileObject) error {
+	if err := db.UpsertFiles(ctx, files); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := sniffScanned(ctx, storage, repo, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parentPath returns the path of the directory holding a file, empty for the repository root
+func parentPath(p string) string {
+	dir := path.Dir(p)
 	if dir == "." || dir == "/" {
 		dir = ""
 	}
+	return dir
+}
+
+func updateFileMeta(ctx context.Context, repo *model.Repository, fm *FileMeta) error {
+	dir := parentPath(fm.Path)
 	parent, err := db.GetFile(ctx, repo.ID, dir)
 	if err != nil {
 		return fmt.Errorf("get %s failed: %s", dir, err)
@@ -4,15 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/cgang/file-hub/pkg/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFileMeta(t *testing.T) {
@@ -121,6 +125,60 @@ func TestFsStorage(t *testing.T) {
 		assert.Equal(t, "/data/repo", storage.getFullPath("repo", "/"))
 		assert.Equal(t, "/data/repo", storage.getFullPath("repo", ""))
 	})
+
+	t.Run("LinkFile shares content between names", func(t *testing.T) {
+		storage := &fsStorage{rootDir: t.TempDir()}
+		ctx := context.Background()
+
+		_, err := storage.PutFile(ctx, "repo", "/a.txt", strings.NewReader("same"))
+		assert.NoError(t, err)
+		_, err = storage.PutFile(ctx, "other", "/b.txt", strings.NewReader("same"))
+		assert.NoError(t, err)
+
+		assert.NoError(t, storage.LinkFile(ctx, "repo", "/a.txt", "other", "/b.txt"))
+
+		a, err := os.Stat(storage.getFullPath("repo", "/a.txt"))
+		assert.NoError(t, err)
+		b, err := os.Stat(storage.getFullPath("other", "/b.txt"))
+		assert.NoError(t, err)
+		assert.True(t, os.SameFile(a, b))
+	})
+
+	t.Run("SetModTime changes file time", func(t *testing.T) {
+		storage := &fsStorage{rootDir: t.TempDir()}
+		ctx := context.Background()
+
+		_, err := storage.PutFile(ctx, "repo", "/old.txt", strings.NewReader("data"))
+		assert.NoError(t, err)
+
+		modTime := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+		assert.NoError(t, storage.SetModTime(ctx, "repo", "/old.txt", modTime))
+
+		st, err := os.Stat(storage.getFullPath("repo", "/old.txt"))
+		assert.NoError(t, err)
+		assert.True(t, modTime.Equal(st.ModTime()))
+	})
+
+	t.Run("RenameRepo moves the repository directory", func(t *testing.T) {
+		storage := &fsStorage{rootDir: t.TempDir()}
+		ctx := context.Background()
+
+		_, err := storage.PutFile(ctx, "alice", "/docs/a.txt", strings.NewReader("data"))
+		assert.NoError(t, err)
+		_, err = storage.PutFile(ctx, "bob", "/b.txt", strings.NewReader("data"))
+		assert.NoError(t, err)
+
+		assert.ErrorIs(t, storage.RenameRepo(ctx, "alice", "bob"), fs.ErrExist)
+		assert.NoError(t, storage.RenameRepo(ctx, "alice", "alicia"))
+
+		_, err = os.Stat(storage.getFullPath("alicia", "/docs/a.txt"))
+		assert.NoError(t, err)
+		_, err = os.Stat(storage.getFullPath("alice", ""))
+		assert.True(t, os.IsNotExist(err))
+
+		// Nothing stored yet
+		assert.NoError(t, storage.RenameRepo(ctx, "empty", "other"))
+	})
 }
 
 func TestIsConfiguredRoot(t *testing.T) {
@@ -275,6 +333,10 @@ func TestStorageInterface(t *testing.T) {
 	t.Run("s3Storage implements Storage interface", func(t *testing.T) {
 		var _ Storage = (*s3Storage)(nil)
 	})
+
+	t.Run("fsStorage implements Linker interface", func(t *testing.T) {
+		var _ Linker = (*fsStorage)(nil)
+	})
 }
 
 func TestPathOperations(t *testing.T) {
@@ -449,64 +511,38 @@ func TestCreateDir(t *testing.T) {
 		assert.NotNil(t, resource.Repo)
 		assert.Equal(t, "/new/directory", resource.Path)
 	})
-}
 
-// TestMoveFile tests the MoveFile function
-func TestMoveFile(t *testing.T) {
-	t.Run("MoveFile rejects cross-repository move", func(t *testing.T) {
-		ctx := context.Background()
-		srcResource := &model.Resource{
-			Repo: &model.Repository{
-				ID:      1,
-				OwnerID: 1,
-				Name:    "repo1",
-				Root:    "/tmp/repo1",
-			},
-			Path: "/file.txt",
-		}
-		destResource := &model.Resource{
-			Repo: &model.Repository{
-				ID:      2,
-				OwnerID: 1,
-				Name:    "repo2",
-				Root:    "/tmp/repo2",
-			},
-			Path: "/file.txt",
-		}
-
-		err := MoveFile(ctx, srcResource, destResource)
+	t.Run("CreateDir rejects repository root", func(t *testing.T) {
+		resource := &model.Resource{Repo: &model.Repository{ID: 1, Name: "test"}, Path: "/"}
+		dir, err := CreateDir(context.Background(), resource, nil)
+		assert.Nil(t, dir)
 		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "cross-repository move not supported")
 	})
 }
 
-// TestCopyFile tests the CopyFile function
-func TestCopyFile(t *testing.T) {
-	t.Run("CopyFile rejects cross-repository copy", func(t *testing.T) {
-		ctx := context.Background()
-		srcResource := &model.Resource{
-			Repo: &model.Repository{
-				ID:      1,
-				OwnerID: 1,
-				Name:    "repo1",
-				Root:    "/tmp/repo1",
-			},
-			Path: "/file.txt",
-		}
-		destResource := &model.Resource{
-			Repo: &model.Repository{
-				ID:      2,
-				OwnerID: 1,
-				Name:    "repo2",
-				Root:    "/tmp/repo2",
-			},
-			Path: "/file.txt",
-		}
-
-		err := CopyFile(ctx, srcResource, destResource)
-		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "cross-repository copy not supported")
-	})
+// TestTransferFile tests copying content between repositories stored apart
+func TestTransferFile(t *testing.T) {
+	ctx := context.Background()
+	from := &fsStorage{rootDir: t.TempDir()}
+	to := &fsStorage{rootDir: t.TempDir()}
+	src := &model.Resource{Repo: &model.Repository{ID: 1, Name: "alice"}, Path: "/report.txt"}
+	dest := &model.Resource{Repo: &model.Repository{ID: 2, Name: "bob"}, Path: "/shared/report.txt"}
+
+	_, err := from.PutFile(ctx, "alice", "/report.txt", strings.NewReader("quarterly"))
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(to.getFullPath("bob", "/shared"), 0755))
+
+	meta, err := transferFile(ctx, from, src, to, dest)
+	require.NoError(t, err)
+	assert.Equal(t, "/shared/report.txt", meta.Path)
+	assert.Equal(t, int64(9), meta.Size)
+
+	content, err := os.ReadFile(to.getFullPath("bob", "/shared/report.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "quarterly", string(content))
+
+	_, err = transferFile(ctx, from, &model.Resource{Repo: src.Repo, Path: "/missing.txt"}, to, dest)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
 }
 
 // TestGetStorage tests the getStorage function
@@ -904,6 +940,68 @@ func TestFsStorageMore(t *testing.T) {
 	})
 }
 
+func TestPutFileReplacesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	storage := &fsStorage{rootDir: dir}
+	fullPath := filepath.Join(dir, "file.txt")
+
+	_, err := storage.putFile(context.Background(), fullPath, strings.NewReader("first"))
+	assert.NoError(t, err)
+
+	// A reader keeps the content it opened while the file is replaced
+	reader, err := os.Open(fullPath)
+	require.NoError(t, err)
+	defer reader.Close()
+	st, err := storage.putFile(context.Background(), fullPath, strings.NewReader("second"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), st.Size())
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+
+	failing := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(fmt.Errorf("read failed")))
+	_, err = storage.putFile(context.Background(), fullPath, failing)
+	assert.Error(t, err)
+
+	data, err = os.ReadFile(fullPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary file should remain")
+}
+
+func TestScanSkipsTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	storage := &fsStorage{rootDir: dir}
+	_, err := storage.PutFile(context.Background(), "alice", "/a.txt", strings.NewReader("content"))
+	require.NoError(t, err)
+
+	// Temporary files of writes going on are left alone, those of crashed writes removed
+	current := filepath.Join(dir, "alice", tempPrefix+"123")
+	stale := filepath.Join(dir, "alice", tempPrefix+"456")
+	require.NoError(t, os.WriteFile(current, []byte("part"), 0644))
+	require.NoError(t, os.WriteFile(stale, []byte("part"), 0644))
+	old := time.Now().Add(-2 * staleTempAge)
+	require.NoError(t, os.Chtimes(stale, old, old))
+
+	// Files of the user are kept and listed, however much they look like temporary ones
+	notes := filepath.Join(dir, "alice", ".notes.tmp")
+	require.NoError(t, os.WriteFile(notes, []byte("mine"), 0644))
+	require.NoError(t, os.Chtimes(notes, old, old))
+
+	var found []string
+	require.NoError(t, storage.Scan(context.Background(), "alice", func(meta *FileMeta) error {
+		found = append(found, meta.Path)
+		return nil
+	}))
+	assert.Equal(t, []string{"", "/.notes.tmp", "/a.txt"}, found)
+	assert.FileExists(t, current)
+	assert.FileExists(t, notes)
+	assert.NoFileExists(t, stale)
+}
+
 // TestIsConfiguredRootMore tests more isConfiguredRoot scenarios
 func TestIsConfiguredRootMore(t *testing.T) {
 	t.Run("isConfiguredRoot with path variations", func(t *testing.T) {
@@ -946,3 +1044,43 @@ func TestStorageInterfaceImplementation(t *testing.T) {
 		assert.NotNil(t, storage.GetContentType)
 	})
 }
+
+func TestCopyTreeIntoItself(t *testing.T) {
+	repo := &model.Repository{ID: 1}
+	for _, dest := range []string{"/photos", "/photos/", "/photos/2024"} {
+		_, err := CopyTree(context.Background(), &model.Resource{Repo: repo, Path: "/photos"}, &model.Resource{Repo: repo, Path: dest}, nil)
+		assert.ErrorIs(t, err, ErrCopyIntoItself, dest)
+	}
+}
+
+func TestCopyTreeAcrossRepositories(t *testing.T) {
+	src := &model.Resource{Repo: &model.Repository{ID: 1}, Path: "/photos"}
+	dest := &model.Resource{Repo: &model.Repository{ID: 2}, Path: "/photos"}
+	_, err := CopyTree(context.Background(), src, dest, nil)
+	assert.ErrorIs(t, err, ErrCrossRepoDir)
+}
+
+func TestGuessContentType(t *testing.T) {
+	// Files without a type are given one from their name, without asking the storage
+	file := &model.FileObject{Name: "photo.png", Path: "/photo.png"}
+	guessContentType(file)
+	require.NotNil(t, file.MimeType)
+	assert.Equal(t, "image/png", *file.MimeType)
+
+	// Recorded types are kept, and directories are given none
+	recorded := "text/markdown"
+	file = &model.FileObject{Name: "notes.txt", MimeType: &recorded}
+	guessContentType(file)
+	assert.Equal(t, "text/markdown", *file.MimeType)
+
+	dir := &model.FileObject{Name: "docs", IsDir: true}
+	guessContentType(dir)
+	assert.Nil(t, dir.MimeType)
+}
+
+func TestParentPath(t *testing.T) {
+	assert.Equal(t, "", parentPath("/file.txt"))
+	assert.Equal(t, "/dir", parentPath("/dir/file.txt"))
+	assert.Equal(t, "/dir/sub", parentPath("/dir/sub/file.txt"))
+	assert.Equal(t, "", parentPath("file.txt"))
+}
@@ -0,0 +1,117 @@
+package stor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// ErrTooLarge is returned storing content over the size limit of the write
+var ErrTooLarge = errors.New("file too large")
+
+// maxFileSize is the most bytes any file written through PutStream may hold, 0 for no limit
+var maxFileSize int64
+
+// CheckFileSize returns ErrTooLarge for a file size over storage.max_file_size, so that an upload can
+// be refused before its content is sent
+func CheckFileSize(size int64) error {
+	if maxFileSize > 0 && size > maxFileSize {
+		return fmt.Errorf("%w: %d bytes, at most %d allowed", ErrTooLarge, size, maxFileSize)
+	}
+	return nil
+}
+
+// MaxFileSize returns the most bytes a file may hold, 0 for no limit
+func MaxFileSize() int64 {
+	return maxFileSize
+}
+
+// VerifyFunc checks the SHA-256 of content streamed to a backend before the backend keeps it
+type VerifyFunc func(checksum string) error
+
+// PutStream stores the content read from data at res without holding it in memory, hashing it on the way,
+// and records the file with its checksum. The write fails, and the backend discards it leaving any previous
+// content in place, once more than limit bytes or storage.max_file_size arrive, whichever is smaller, or
+// when verify rejects the checksum at the end. A limit of 0 applies only the configured one.
+func PutStream(ctx context.Context, res *model.Resource, data io.Reader, limit int64, verify VerifyFunc) (*FileMeta, error) {
+	if err := CheckMutable(ctx, res); err != nil {
+		return nil, err
+	}
+
+	storage, err := getStorage(res.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 || (maxFileSize > 0 && maxFileSize < limit) {
+		limit = maxFileSize
+	}
+	source := newCheckedReader(data, limit, verify)
+	meta, err := storage.PutFile(ctx, res.Repo.Name, res.Path, source)
+	if err != nil {
+		return nil, err
+	}
+	if source.done {
+		meta.Checksum = source.checksum
+		meta.ContentType = SniffContentType(res.Path, source.head.data)
+	}
+
+	if err := updateFileMeta(ctx, res.Repo, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// checkedReader hashes content as a backend reads it, keeping its start to sniff the content type. Going over the limit, or a checksum rejected at
+// the end, is returned in place of io.EOF, so that the backend fails the write rather than keep it.
+type checkedReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	head     headBuffer
+	n        int64
+	limit    int64 // 0 for no limit
+	verify   VerifyFunc
+	done     bool // the whole content was read and accepted
+	checksum string
+	err      error
+}
+
+func newCheckedReader(r io.Reader, limit int64, verify VerifyFunc) *checkedReader {
+	return &checkedReader{r: r, hash: sha256.New(), limit: limit, verify: verify}
+}
+
+func (r *checkedReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.r.Read(p)
+	r.hash.Write(p[:n])
+	r.head.Write(p[:n])
+	r.n += int64(n)
+	if r.limit > 0 && r.n > r.limit {
+		r.err = fmt.Errorf("%w: more than %d bytes", ErrTooLarge, r.limit)
+		return n, r.err
+	}
+
+	if err == io.EOF {
+		checksum := hex.EncodeToString(r.hash.Sum(nil))
+		if r.verify != nil {
+			if verr := r.verify(checksum); verr != nil {
+				r.err = verr
+				return n, verr
+			}
+		}
+		r.done, r.checksum = true, checksum
+	}
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
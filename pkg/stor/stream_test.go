@@ -0,0 +1,80 @@
+package stor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckedReader(t *testing.T) {
+	ctx := context.Background()
+	s := &fsStorage{rootDir: t.TempDir()}
+	_, err := s.PutFile(ctx, "alice", "/a.txt", strings.NewReader("old"))
+	require.NoError(t, err)
+
+	// A rejected checksum fails the write, and the previous content stays
+	rejected := errors.New("rejected")
+	source := newCheckedReader(strings.NewReader("new"), 0, func(checksum string) error {
+		assert.Equal(t, sha256Hex("new"), checksum)
+		return rejected
+	})
+	_, err = s.PutFile(ctx, "alice", "/a.txt", source)
+	assert.ErrorIs(t, err, rejected)
+	assert.False(t, source.done)
+	assert.Equal(t, "old", readAll(t, s, "alice", "/a.txt"))
+
+	// So does content over the limit
+	source = newCheckedReader(strings.NewReader("too long"), 4, nil)
+	_, err = s.PutFile(ctx, "alice", "/a.txt", source)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	assert.Equal(t, "old", readAll(t, s, "alice", "/a.txt"))
+
+	// Content within the limit is stored with its checksum
+	source = newCheckedReader(strings.NewReader("new"), 3, func(string) error { return nil })
+	_, err = s.PutFile(ctx, "alice", "/a.txt", source)
+	require.NoError(t, err)
+	assert.True(t, source.done)
+	assert.Equal(t, sha256Hex("new"), source.checksum)
+	assert.Equal(t, "new", string(source.head.data))
+	assert.Equal(t, "new", readAll(t, s, "alice", "/a.txt"))
+}
+
+func TestCheckedReaderS3(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeS3(t, 4)
+	s := &s3Storage{bucket: "files"}
+	_, err := s.PutFile(ctx, "alice", "/file.txt", strings.NewReader("old"))
+	require.NoError(t, err)
+
+	// Neither content sent in one request nor a multipart upload is kept once rejected
+	rejected := errors.New("rejected")
+	for _, content := range []string{"ab", "abcdefghij"} {
+		source := newCheckedReader(strings.NewReader(content), 0, func(string) error { return rejected })
+		_, err = s.PutFile(ctx, "alice", "/file.txt", source)
+		assert.ErrorIs(t, err, rejected, content)
+		assert.Equal(t, "old", f.object(s, "alice", "/file.txt"), content)
+	}
+	assert.Equal(t, 1, f.aborted)
+
+	_, err = s.PutFile(ctx, "alice", "/file.txt", newCheckedReader(strings.NewReader("abcdefghij"), 6, nil))
+	assert.ErrorIs(t, err, ErrTooLarge)
+	assert.Equal(t, 2, f.aborted)
+	assert.Empty(t, f.uploads)
+	assert.Equal(t, "old", f.object(s, "alice", "/file.txt"))
+}
+
+func TestCheckFileSize(t *testing.T) {
+	saved := maxFileSize
+	t.Cleanup(func() { maxFileSize = saved })
+
+	maxFileSize = 0
+	assert.NoError(t, CheckFileSize(1<<40))
+
+	maxFileSize = 100
+	assert.NoError(t, CheckFileSize(100))
+	assert.ErrorIs(t, CheckFileSize(101), ErrTooLarge)
+}
@@ -0,0 +1,368 @@
+package stor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// ErrNotColdRoot is returned for a cold storage root missing from storage.cold_roots
+var ErrNotColdRoot = errors.New("not a configured cold storage root")
+
+// accessResolution is how old the recorded access of a file gets before opening it records a new one
+const accessResolution = 24 * time.Hour
+
+// coldRoots are the storage roots the files of repositories may move to, set by Init. Repositories only
+// get a tieredStorage with some configured, so that lookups are spared otherwise.
+var coldRoots []string
+
+// IsColdRoot reports whether files may be moved to a storage root, as listed in storage.cold_roots
+func IsColdRoot(root string) bool {
+	return root != "" && slices.Contains(coldRoots, root)
+}
+
+// tierLocks serialize moving the content of a file between the repository and cold storage with writes
+// to it, so that neither loses the other's content
+var tierLocks [64]sync.Mutex
+
+func tierLock(repoID int, p string) *sync.Mutex {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d:%s", repoID, p)
+	return &tierLocks[h.Sum32()%uint32(len(tierLocks))]
+}
+
+// coldName names a repository in cold storage by its ID, which renaming or transferring it keeps
+func coldName(repo *model.Repository) string {
+	return strconv.Itoa(repo.ID)
+}
+
+// MoveToCold moves the content of a file nobody opened or wrote since cutoff to the cold storage root of
+// its repository, where its record points from then on. The content is checked against the file's checksum
+// on the way, so files are only moved once hashed. The result reports whether the file was moved; files
+// opened, written or moved meanwhile are left alone.
+func MoveToCold(ctx context.Context, repo *model.Repository, file *model.FileObject, cutoff time.Time) (bool, error) {
+	if !IsColdRoot(repo.ColdRoot) {
+		return false, fmt.Errorf("%w: %s", ErrNotColdRoot, repo.ColdRoot)
+	}
+
+	lock := tierLock(repo.ID, file.Path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	current, err := db.GetFile(ctx, repo.ID, file.Path)
+	if IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if current.IsDir || current.ColdRoot != "" || current.Checksum == nil || !current.LastAccess().Before(cutoff) {
+		return false, nil
+	}
+
+	hot, err := openStorage(repo)
+	if err != nil {
+		return false, err
+	}
+	cold, err := openStorage(&model.Repository{Root: repo.ColdRoot})
+	if err != nil {
+		return false, err
+	}
+
+	if err := copyVerified(ctx, hot, repo.Name, cold, coldName(repo), current); err != nil {
+		return false, err
+	}
+	if err := db.SetFileCold(ctx, current.ID, repo.ColdRoot); err != nil {
+		if err := cold.DeleteFile(ctx, coldName(repo), current.Path); err != nil {
+			log.Printf("Failed to remove cold copy of %s in %s: %s", current.Path, repo.Name, err)
+		}
+		return false, err
+	}
+
+	if err := hot.DeleteFile(ctx, repo.Name, current.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to remove %s from %s after moving it to cold storage: %s", current.Path, repo.Name, err)
+	}
+	return true, nil
+}
+
+// copyVerified copies the content of a file from one storage to another, which discards it unless it
+// matches the file's checksum
+func copyVerified(ctx context.Context, from Storage, fromRepo string, to Storage, toRepo string, file *model.FileObject) error {
+	reader, err := from.OpenFile(ctx, fromRepo, file.Path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var source io.Reader = reader
+	if file.Checksum != nil {
+		source = newCheckedReader(reader, 0, func(checksum string) error {
+			if checksum != *file.Checksum {
+				return fmt.Errorf("content of %s does not match its checksum", file.Path)
+			}
+			return nil
+		})
+	}
+
+	_, err = to.PutFile(ctx, toRepo, file.Path, source)
+	return err
+}
+
+// tieredStorage is the storage of a repository whose files may have moved to cold storage. Their content
+// is fetched back into the repository before it is read or copied, and removed from cold storage when the
+// file is written or deleted. Opening a file records the access, which keeps it from moving for a while.
+type tieredStorage struct {
+	Storage
+	repo *model.Repository
+}
+
+// lookup returns the record of a file, nil when it has none
+func (t *tieredStorage) lookup(ctx context.Context, name string) (*model.FileObject, error) {
+	file, err := db.GetFile(ctx, t.repo.ID, name)
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	return file, err
+}
+
+// recall fetches a file back from cold storage, returning its record, nil when it has none
+func (t *tieredStorage) recall(ctx context.Context, name string) (*model.FileObject, error) {
+	file, err := t.lookup(ctx, name)
+	if err != nil || file == nil || file.ColdRoot == "" {
+		return file, err
+	}
+
+	lock := tierLock(t.repo.ID, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Another reader may have fetched it meanwhile
+	if file, err = t.lookup(ctx, name); err != nil || file == nil || file.ColdRoot == "" {
+		return file, err
+	}
+
+	if err := t.fetch(ctx, file); err != nil {
+		return nil, fmt.Errorf("failed to fetch %s back from cold storage: %w", name, err)
+	}
+	return file, nil
+}
+
+// fetch moves the content of a file back from cold storage, with its modification time
+func (t *tieredStorage) fetch(ctx context.Context, file *model.FileObject) error {
+	cold, err := openStorage(&model.Repository{Root: file.ColdRoot})
+	if err != nil {
+		return err
+	}
+	if err := copyVerified(ctx, cold, coldName(t.repo), t.Storage, t.repo.Name, file); err != nil {
+		return err
+	}
+
+	if setter, ok := t.Storage.(ModTimeSetter); ok {
+		err := setter.SetModTime(ctx, t.repo.Name, file.Path, file.ModTime)
+		if err != nil && !errors.Is(err, errModTimeUnsupported) {
+			log.Printf("Failed to set modification time of %s in %s: %s", file.Path, t.repo.Name, err)
+		}
+	}
+
+	coldRoot := file.ColdRoot
+	if err := db.SetFileCold(ctx, file.ID, ""); err != nil {
+		return err
+	}
+	file.ColdRoot = ""
+	t.touch(ctx, file)
+
+	if err := cold.DeleteFile(ctx, coldName(t.repo), file.Path); err != nil {
+		log.Printf("Failed to remove cold copy of %s in %s from %s: %s", file.Path, t.repo.Name, coldRoot, err)
+	}
+	return nil
+}
+
+// dropCold removes the cold copy of a file whose content was replaced, and points its record back at
+// the repository. The caller holds the tier lock of the file.
+func (t *tieredStorage) dropCold(ctx context.Context, name string) {
+	file, err := t.lookup(ctx, name)
+	if err != nil || file == nil || file.ColdRoot == "" {
+		return
+	}
+
+	if err := db.SetFileCold(ctx, file.ID, ""); err != nil {
+		log.Printf("Failed to record %s in %s back from cold storage: %s", name, t.repo.Name, err)
+		return
+	}
+	t.deleteCold(ctx, file)
+}
+
+func (t *tieredStorage) deleteCold(ctx context.Context, file *model.FileObject) {
+	cold, err := openStorage(&model.Repository{Root: file.ColdRoot})
+	if err == nil {
+		err = cold.DeleteFile(ctx, coldName(t.repo), file.Path)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to remove cold copy of %s in %s from %s: %s", file.Path, t.repo.Name, file.ColdRoot, err)
+	}
+}
+
+// touch records that a file was opened, unless it was recently
+func (t *tieredStorage) touch(ctx context.Context, file *model.FileObject) {
+	now := time.Now()
+	if file == nil || (file.AccessedAt != nil && now.Sub(*file.AccessedAt) < accessResolution) {
+		return
+	}
+	if err := db.TouchFile(ctx, file.ID, now); err != nil {
+		log.Printf("Failed to record access of %s in %s: %s", file.Path, t.repo.Name, err)
+	}
+}
+
+func (t *tieredStorage) PutFile(ctx context.Context, repo, name string, data io.Reader) (*FileMeta, error) {
+	lock := tierLock(t.repo.ID, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := t.Storage.PutFile(ctx, repo, name, data)
+	if err == nil {
+		t.dropCold(ctx, name)
+	}
+	return meta, err
+}
+
+func (t *tieredStorage) OpenFile(ctx context.Context, repo, name string) (io.ReadCloser, error) {
+	file, err := t.recall(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	t.touch(ctx, file)
+	return t.Storage.OpenFile(ctx, repo, name)
+}
+
+func (t *tieredStorage) OpenFileRange(ctx context.Context, repo, name string, offset, length int64) (io.ReadCloser, error) {
+	file, err := t.recall(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	t.touch(ctx, file)
+	return t.Storage.OpenFileRange(ctx, repo, name, offset, length)
+}
+
+func (t *tieredStorage) DeleteFile(ctx context.Context, repo, name string) error {
+	lock := tierLock(t.repo.ID, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	file, err := t.lookup(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	err = t.Storage.DeleteFile(ctx, repo, name)
+	if file != nil && file.ColdRoot != "" {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil // only the cold copy was left
+		}
+		if err == nil {
+			t.deleteCold(ctx, file)
+		}
+	}
+	return err
+}
+
+func (t *tieredStorage) CopyFile(ctx context.Context, repo, srcName, destName string) (*FileMeta, error) {
+	if _, err := t.recall(ctx, srcName); err != nil {
+		return nil, err
+	}
+
+	lock := tierLock(t.repo.ID, destName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := t.Storage.CopyFile(ctx, repo, srcName, destName)
+	if err == nil {
+		t.dropCold(ctx, destName)
+	}
+	return meta, err
+}
+
+func (t *tieredStorage) GetContentType(ctx context.Context, repo, name string) (string, error) {
+	file, err := t.lookup(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if file == nil || file.ColdRoot == "" {
+		return t.Storage.GetContentType(ctx, repo, name)
+	}
+
+	cold, err := openStorage(&model.Repository{Root: file.ColdRoot})
+	if err != nil {
+		return "", err
+	}
+	return cold.GetContentType(ctx, coldName(t.repo), name)
+}
+
+func (t *tieredStorage) LinkFile(ctx context.Context, srcRepo, srcName, destRepo, destName string) error {
+	linker, ok := t.Storage.(Linker)
+	if !ok {
+		return ErrLinkUnsupported
+	}
+	if _, err := t.recall(ctx, srcName); err != nil {
+		return err
+	}
+	return linker.LinkFile(ctx, srcRepo, srcName, destRepo, destName)
+}
+
+func (t *tieredStorage) SetModTime(ctx context.Context, repo, name string, modTime time.Time) error {
+	setter, ok := t.Storage.(ModTimeSetter)
+	if !ok {
+		return errModTimeUnsupported
+	}
+
+	// The time of a file in cold storage is set when it is fetched back
+	if file, err := t.lookup(ctx, name); err != nil {
+		return err
+	} else if file != nil && file.ColdRoot != "" {
+		return nil
+	}
+	return setter.SetModTime(ctx, repo, name, modTime)
+}
+
+func (t *tieredStorage) RenameRepo(ctx context.Context, repo, name string) error {
+	renamer, ok := t.Storage.(Renamer)
+	if !ok {
+		return ErrRenameUnsupported
+	}
+	return renamer.RenameRepo(ctx, repo, name)
+}
+
+func (t *tieredStorage) PresignFile(ctx context.Context, method, repo, name string, expires time.Duration) (*PresignedRequest, error) {
+	presigner, ok := t.Storage.(Presigner)
+	if !ok {
+		return nil, ErrPresignUnsupported
+	}
+
+	// Clients reach the repository's own storage, so the file is fetched back for a read, and for
+	// a write so that its record no longer points at the cold copy
+	file, err := t.recall(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if method != "PUT" {
+		t.touch(ctx, file)
+	}
+	return presigner.PresignFile(ctx, method, repo, name, expires)
+}
+
+func (t *tieredStorage) StatFile(ctx context.Context, repo, name string) (*FileMeta, error) {
+	presigner, ok := t.Storage.(Presigner)
+	if !ok {
+		return nil, ErrPresignUnsupported
+	}
+	return presigner.StatFile(ctx, repo, name)
+}
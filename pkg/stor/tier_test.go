@@ -0,0 +1,62 @@
+package stor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieredStorage(t *testing.T) {
+	saved := coldRoots
+	t.Cleanup(func() { coldRoots = saved })
+
+	hot := t.TempDir()
+	coldRoots = nil
+	storage, err := getStorage(&model.Repository{Root: hot})
+	require.NoError(t, err)
+	assert.IsType(t, &instrumented{}, storage)
+
+	// With cold storage configured, repositories get one aware of files moved there, with the same capabilities
+	coldRoots = []string{"s3://archive/files"}
+	repo := &model.Repository{ID: 7, Name: "alice", Root: hot}
+	storage, err = getStorage(repo)
+	require.NoError(t, err)
+	assert.Equal(t, repo, storage.(*tieredStorage).repo)
+	caps, err := GetCapabilities(repo)
+	require.NoError(t, err)
+	assert.Equal(t, Capabilities{RangeReads: true, Links: true, ModTimes: true, RenameRepo: true}, caps)
+
+	assert.True(t, IsColdRoot("s3://archive/files"))
+	assert.False(t, IsColdRoot(hot))
+	assert.False(t, IsColdRoot(""))
+
+	_, err = MoveToCold(context.Background(), &model.Repository{Root: hot, ColdRoot: "/tmp/elsewhere"}, &model.FileObject{}, repo.CreatedAt)
+	assert.ErrorIs(t, err, ErrNotColdRoot)
+
+	// Cold copies are kept by repository ID, which survives renames
+	assert.Equal(t, "7", coldName(repo))
+	assert.Same(t, tierLock(7, "/a.txt"), tierLock(7, "/a.txt"))
+}
+
+func TestCopyVerified(t *testing.T) {
+	ctx := context.Background()
+	hot := &fsStorage{rootDir: t.TempDir()}
+	cold := &fsStorage{rootDir: t.TempDir()}
+	_, err := hot.PutFile(ctx, "alice", "/a.txt", strings.NewReader("content"))
+	require.NoError(t, err)
+
+	// Content not matching the checksum of the file is not kept
+	stale := sha256Hex("stale")
+	err = copyVerified(ctx, hot, "alice", cold, "7", &model.FileObject{Path: "/a.txt", Checksum: &stale})
+	assert.ErrorContains(t, err, "does not match its checksum")
+	_, err = cold.OpenFile(ctx, "7", "/a.txt")
+	assert.Error(t, err)
+
+	checksum := sha256Hex("content")
+	require.NoError(t, copyVerified(ctx, hot, "alice", cold, "7", &model.FileObject{Path: "/a.txt", Checksum: &checksum}))
+	assert.Equal(t, "content", readAll(t, cold, "7", "/a.txt"))
+}
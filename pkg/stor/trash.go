@@ -0,0 +1,144 @@
+package stor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// TrashDir is the storage folder holding trashed files of a repository.
+// It is not part of the repository tree and is skipped when scanning.
+const TrashDir = "/.trash"
+
+// ErrRestoreConflict is returned when a trashed file cannot be restored because its path is taken
+var ErrRestoreConflict = errors.New("a file already exists at the original path")
+
+// isTrashPath reports whether a storage path lies inside the trash folder
+func isTrashPath(name string) bool {
+	return name == TrashDir || strings.HasPrefix(name, TrashDir+"/")
+}
+
+// TrashFile moves a file to its repository's trash.
+// userID is nil when the server removes the file, e.g. when it expires.
+func TrashFile(ctx context.Context, res *model.Resource, userID *int, reason string) (*model.TrashItem, error) {
+	file, err := db.GetFile(ctx, res.Repo.ID, res.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.IsDir {
+		return nil, errors.New("directories cannot be trashed")
+	}
+	if err := checkFileMutable(file); err != nil {
+		return nil, err
+	}
+
+	storage, err := getStorage(res.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &model.TrashItem{
+		RepoID:     res.Repo.ID,
+		Path:       file.Path,
+		StoredName: path.Join(TrashDir, fmt.Sprintf("%d-%s", time.Now().UnixNano(), file.Name)),
+		Size:       file.Size,
+		DeletedBy:  userID,
+		Reason:     reason,
+	}
+
+	if _, err := storage.CopyFile(ctx, res.Repo.Name, file.Path, item.StoredName); err != nil {
+		return nil, fmt.Errorf("failed to copy %s to trash: %w", file.Path, err)
+	}
+
+	if err := db.CreateTrashItem(ctx, item); err != nil {
+		_ = storage.DeleteFile(ctx, res.Repo.Name, item.StoredName)
+		return nil, err
+	}
+
+	if err := storage.DeleteFile(ctx, res.Repo.Name, file.Path); err != nil {
+		return nil, err
+	}
+
+	if err := db.DeleteFileByPath(ctx, res.Repo.ID, file.Path); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// ListTrash returns the trashed files of a repository
+func ListTrash(ctx context.Context, repo *model.Repository) ([]*model.TrashItem, error) {
+	return db.ListTrashItems(ctx, repo.ID)
+}
+
+// RestoreTrash moves a trashed file back to its original path
+func RestoreTrash(ctx context.Context, repo *model.Repository, id int64) (*model.TrashItem, error) {
+	item, err := db.GetTrashItem(ctx, repo.ID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.GetFile(ctx, repo.ID, item.Path); err == nil {
+		return nil, ErrRestoreConflict
+	}
+
+	storage, err := getStorage(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := storage.CopyFile(ctx, repo.Name, item.StoredName, item.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore %s: %w", item.Path, err)
+	}
+
+	if err := updateFileMeta(ctx, repo, meta); err != nil {
+		return nil, err
+	}
+
+	if err := storage.DeleteFile(ctx, repo.Name, item.StoredName); err != nil {
+		return nil, err
+	}
+
+	return item, db.DeleteTrashItem(ctx, item.ID)
+}
+
+// PurgeTrash permanently deletes files trashed before the given time, returning how many were purged
+func PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	items, err := db.ListTrashItemsBefore(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, item := range items {
+		repo, err := db.GetRepositoryByID(ctx, item.RepoID)
+		if err != nil {
+			return purged, err
+		}
+
+		storage, err := getStorage(repo)
+		if err != nil {
+			return purged, err
+		}
+
+		if err := storage.DeleteFile(ctx, repo.Name, item.StoredName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return purged, fmt.Errorf("failed to purge %s: %w", item.StoredName, err)
+		}
+
+		if err := db.DeleteTrashItem(ctx, item.ID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,110 @@
+package stor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+var (
+	// ErrTreeTooDeep is returned for directories nested deeper than the configured limit
+	ErrTreeTooDeep = errors.New("directory tree too deep")
+	// ErrTreeTooLarge is returned for directory trees of more entries than the configured limit
+	ErrTreeTooLarge = errors.New("directory tree has too many entries")
+	// ErrTreeCycle is returned when the parent IDs of a directory tree lead back to a directory already
+	// walked, as left behind by a broken import
+	ErrTreeCycle = errors.New("directory tree contains a cycle")
+)
+
+var (
+	maxTreeDepth   = 256     // deepest nesting walked or created, 0 for no limit
+	maxTreeEntries = 1000000 // most entries walked at once, 0 for no limit
+)
+
+// treeFrame is a file on the stack of WalkTree
+type treeFrame struct {
+	file     *model.FileObject
+	depth    int
+	expanded bool // whether its children were pushed
+}
+
+// WalkTree calls visit for root and, for a directory, everything below it, children in name order.
+// Directories are visited after their content if childrenFirst is set, before it otherwise.
+// The tree is walked with a stack of its own rather than by recursion, and fails with ErrTreeTooDeep,
+// ErrTreeTooLarge or ErrTreeCycle before going past the limits or around a cycle.
+func WalkTree(ctx context.Context, root *model.FileObject, childrenFirst bool, visit func(*model.FileObject) error) error {
+	stack := []*treeFrame{{file: root}}
+	seen := map[int]bool{root.ID: true}
+	entries := 1
+
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		frame := stack[len(stack)-1]
+		if !frame.file.IsDir || frame.expanded {
+			stack = stack[:len(stack)-1]
+			if !frame.file.IsDir || childrenFirst {
+				if err := visit(frame.file); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		frame.expanded = true
+		if !childrenFirst {
+			if err := visit(frame.file); err != nil {
+				return err
+			}
+		}
+
+		children, err := db.GetChildFiles(ctx, frame.file.ID)
+		if err != nil {
+			return err
+		}
+		if len(children) == 0 {
+			continue
+		}
+		if maxTreeDepth > 0 && frame.depth >= maxTreeDepth {
+			return fmt.Errorf("%w: %s is nested more than %d levels deep", ErrTreeTooDeep, children[0].Path, maxTreeDepth)
+		}
+		entries += len(children)
+		if err := checkTreeSize(entries, root.Path); err != nil {
+			return err
+		}
+
+		// Pushed last to first, so that they come off the stack in name order
+		for i := len(children) - 1; i >= 0; i-- {
+			child := children[i]
+			if seen[child.ID] {
+				return fmt.Errorf("%w: %s is its own ancestor", ErrTreeCycle, child.Path)
+			}
+			seen[child.ID] = true
+			stack = append(stack, &treeFrame{file: child, depth: frame.depth + 1})
+		}
+	}
+	return nil
+}
+
+// checkTreeSize fails with ErrTreeTooLarge if entries exceed the configured limit
+func checkTreeSize(entries int, dir string) error {
+	if maxTreeEntries > 0 && entries > maxTreeEntries {
+		return fmt.Errorf("%w: more than %d below %s", ErrTreeTooLarge, maxTreeEntries, dir)
+	}
+	return nil
+}
+
+// checkTreeDepth fails with ErrTreeTooDeep if a path is nested deeper than the configured limit
+func checkTreeDepth(p string) error {
+	if depth := strings.Count(path.Clean("/"+p), "/"); maxTreeDepth > 0 && depth > maxTreeDepth {
+		return fmt.Errorf("%w: %s is nested more than %d levels deep", ErrTreeTooDeep, p, maxTreeDepth)
+	}
+	return nil
+}
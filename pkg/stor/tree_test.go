@@ -0,0 +1,42 @@
+package stor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// limitTree sets the tree limits for a test
+func limitTree(t *testing.T, depth, entries int) {
+	oldDepth, oldEntries := maxTreeDepth, maxTreeEntries
+	maxTreeDepth, maxTreeEntries = depth, entries
+	t.Cleanup(func() { maxTreeDepth, maxTreeEntries = oldDepth, oldEntries })
+}
+
+func TestCheckTreeDepth(t *testing.T) {
+	limitTree(t, 3, 0)
+	assert.NoError(t, checkTreeDepth("/a/b/c"))
+	assert.NoError(t, checkTreeDepth("a/b/c/"))
+	assert.ErrorIs(t, checkTreeDepth("/a/b/c/d"), ErrTreeTooDeep)
+
+	limitTree(t, 0, 0)
+	assert.NoError(t, checkTreeDepth(strings.Repeat("/a", 10000)))
+}
+
+func TestCheckTreeSize(t *testing.T) {
+	limitTree(t, 0, 10)
+	assert.NoError(t, checkTreeSize(10, "/photos"))
+	assert.ErrorIs(t, checkTreeSize(11, "/photos"), ErrTreeTooLarge)
+
+	limitTree(t, 0, 0)
+	assert.NoError(t, checkTreeSize(1<<30, "/photos"))
+}
+
+func TestCreateDirTooDeep(t *testing.T) {
+	limitTree(t, 2, 0)
+	_, err := CreateDir(context.Background(), &model.Resource{Repo: &model.Repository{ID: 1}, Path: "/a/b/c"}, nil)
+	assert.ErrorIs(t, err, ErrTreeTooDeep)
+}
@@ -0,0 +1,133 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+)
+
+const (
+	// maxAutorenames is how many numbered names are tried before giving up
+	maxAutorenames = 10000
+	// autorenameBatch is how many numbered names are looked up at once
+	autorenameBatch = 50
+)
+
+// ErrNoFreeName is returned when every numbered name tried for an autorenamed write is taken
+var ErrNoFreeName = errors.New("no free name found")
+
+// numberedStem matches a name already numbered, such as "report (2)"
+var numberedStem = regexp.MustCompile(`^(.+) \((\d+)\)$`)
+
+// renameLocks serialize writes picking a free name in the same directory, so that two of them
+// cannot pick the same one
+var renameLocks [64]sync.Mutex
+
+func renameLock(repoID int, p string) *sync.Mutex {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d:%s", repoID, path.Dir(p))
+	return &renameLocks[h.Sum32()%uint32(len(renameLocks))]
+}
+
+// splitName splits a file name into the stem that is numbered on a conflict, its extension, and the number
+// to try first: 1, or the one after the number the name already ends with. Compound extensions such as
+// ".tar.gz" stay whole, and names like ".bashrc" have no extension.
+func splitName(name string) (stem, ext string, next int) {
+	stem, next = name, 1
+	if e := path.Ext(name); e != name {
+		stem, ext = strings.TrimSuffix(name, e), e
+		if inner := path.Ext(stem); strings.EqualFold(inner, ".tar") && inner != stem {
+			stem, ext = strings.TrimSuffix(stem, inner), inner+ext
+		}
+	}
+
+	if m := numberedStem.FindStringSubmatch(stem); m != nil {
+		if n, err := strconv.Atoi(m[2]); err == nil && n < maxAutorenames {
+			stem, next = m[1], n+1
+		}
+	}
+	return stem, ext, next
+}
+
+// freePath returns p if nothing exists there, or else the first numbered name in the same directory
+// nothing exists at, such as "report (1).txt" for "report.txt". The caller holds the rename lock of p.
+func freePath(ctx context.Context, repoID int, p string) (string, error) {
+	taken, err := db.GetFilesByPaths(ctx, repoID, []string{p})
+	if err != nil {
+		return "", err
+	}
+	if len(taken) == 0 {
+		return p, nil
+	}
+
+	dir, name := path.Split(p)
+	stem, ext, next := splitName(name)
+	for first := next; first < next+maxAutorenames; first += autorenameBatch {
+		candidates := make([]string, autorenameBatch)
+		for i := range candidates {
+			candidates[i] = fmt.Sprintf("%s%s (%d)%s", dir, stem, first+i, ext)
+		}
+
+		taken, err := db.GetFilesByPaths(ctx, repoID, candidates)
+		if err != nil {
+			return "", err
+		}
+
+		used := make(map[string]bool, len(taken))
+		for _, file := range taken {
+			used[file.Path] = true
+		}
+		for _, candidate := range candidates {
+			if !used[candidate] {
+				return candidate, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w for %s", ErrNoFreeName, p)
+}
+
+// WriteFree calls write with p, or with the first free numbered name next to it if p is taken, and returns
+// the path written. It holds the rename lock of p meanwhile, so that writes picking free names in the same
+// directory, such as autorenamed uploads, cannot pick the same one.
+func WriteFree(ctx context.Context, repoID int, p string, write func(p string) error) (string, error) {
+	lock := renameLock(repoID, p)
+	lock.Lock()
+	defer lock.Unlock()
+
+	p, err := freePath(ctx, repoID, p)
+	if err != nil {
+		return "", err
+	}
+	if err := write(p); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// lockFreePath takes the rename lock of p once the user may write to its directory, and returns the first
+// free name for p there, so that nobody probes names or holds the lock of a directory they cannot write to.
+// The caller calls unlock once the file is written.
+func lockFreePath(ctx context.Context, repo *model.Repository, p string, userID int) (string, func(), error) {
+	if err := authorize(ctx, repo, path.Dir(p), userID, perm.Write); err != nil {
+		return "", nil, err
+	}
+
+	lock := renameLock(repo.ID, p)
+	lock.Lock()
+	free, err := freePath(ctx, repo.ID, p)
+	if err != nil {
+		lock.Unlock()
+		return "", nil, err
+	}
+	return free, lock.Unlock, nil
+}
@@ -0,0 +1,67 @@
+package sync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitName(t *testing.T) {
+	tests := []struct {
+		name string
+		stem string
+		ext  string
+		next int
+	}{
+		{"report.txt", "report", ".txt", 1},
+		{"report", "report", "", 1},
+		{".bashrc", ".bashrc", "", 1},
+		{"archive.tar.gz", "archive", ".tar.gz", 1},
+		{"photo.final.jpg", "photo.final", ".jpg", 1},
+		{"report (1).txt", "report", ".txt", 2},
+		{"report (12)", "report", "", 13},
+		{"(1).txt", "(1)", ".txt", 1},
+		{"report(1).txt", "report(1)", ".txt", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stem, ext, next := splitName(tt.name)
+			assert.Equal(t, tt.stem, stem)
+			assert.Equal(t, tt.ext, ext)
+			assert.Equal(t, tt.next, next)
+		})
+	}
+}
+
+func TestRenameLock(t *testing.T) {
+	assert.Same(t, renameLock(1, "/docs/a.txt"), renameLock(1, "/docs/b.txt"))
+}
+
+func TestLockFreePathDenied(t *testing.T) {
+	repo := &model.Repository{ID: 1, Name: "docs"}
+	_, _, err := lockFreePath(context.Background(), repo, "/docs/a.txt", 0)
+	assert.ErrorIs(t, err, perm.ErrDenied)
+
+	lock := renameLock(repo.ID, "/docs/a.txt")
+	assert.True(t, lock.TryLock(), "the lock is not taken for a user who may not write")
+	lock.Unlock()
+}
+
+func TestRenameTarget(t *testing.T) {
+	target, err := renameTarget("/docs/draft.txt", "report.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "/docs/report.txt", target)
+
+	target, err = renameTarget("/draft.txt", ".report")
+	assert.NoError(t, err)
+	assert.Equal(t, "/.report", target)
+
+	for _, name := range []string{"", ".", "..", "sub/report.txt", `sub\report.txt`, "report\x00.txt"} {
+		_, err := renameTarget("/docs/draft.txt", name)
+		assert.ErrorIs(t, err, ErrInvalidName, name)
+	}
+}
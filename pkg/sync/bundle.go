@@ -0,0 +1,619 @@
+package sync
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/users"
+)
+
+// A bundle carries the changes of a repository to another file-hub instance without a network between them.
+// It is a tar archive holding bundleManifest first, then one blob per distinct content, named by its SHA-256.
+const (
+	BundleFormat      = 1
+	BundleContentType = "application/x-tar"
+
+	bundleManifest = "manifest.json"
+	bundleBlobDir  = "blobs/"
+)
+
+var (
+	// MaxBundleChanges caps the changes one bundle covers; a bundle cut short says so in More
+	MaxBundleChanges = 10000
+	// MaxBundleSize caps the size of a bundle accepted on import, and so the content spooled while it is verified
+	MaxBundleSize int64 = 16 << 30
+	// maxManifestSize caps the size of a bundle manifest read on import
+	maxManifestSize int64 = 64 << 20
+)
+
+var (
+	// ErrInvalidBundle is returned for a bundle that is malformed or lacks content it refers to
+	ErrInvalidBundle = errors.New("invalid bundle")
+	// ErrBundleVersion is returned for a bundle exported since another version than the repository is at
+	ErrBundleVersion = errors.New("bundle does not apply to the repository version")
+)
+
+// BundleManifest describes what a bundle changes
+type BundleManifest struct {
+	Format      int           `json:"format"`
+	Repo        string        `json:"repo"`
+	FromVersion string        `json:"from_version"` // version the bundle applies to
+	ToVersion   string        `json:"to_version"`   // version of the repository once applied
+	More        bool          `json:"more"`         // changes after ToVersion did not fit, export again since it
+	CreatedAt   time.Time     `json:"created_at"`
+	Entries     []BundleEntry `json:"entries"`
+}
+
+// BundleEntry is the state of a path changed since FromVersion: removed, a directory or a file with content
+type BundleEntry struct {
+	Path     string    `json:"path"`
+	Deleted  bool      `json:"deleted,omitempty"`
+	IsDir    bool      `json:"is_dir,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Checksum string    `json:"checksum,omitempty"` // SHA-256 of the content, naming its blob
+	ModTime  time.Time `json:"mod_time,omitzero"`
+}
+
+// Bundle is an export prepared by PrepareBundle, written with Write
+type Bundle struct {
+	Manifest *BundleManifest
+	repo     *model.Repository
+}
+
+// PrepareBundle collects the changes of a repository since a version, the start if empty, and the
+// current state of the paths they touched. The content itself is read when the bundle is written.
+func (s *Service) PrepareBundle(ctx context.Context, repo *model.Repository, since string) (*Bundle, error) {
+	current, err := db.GetCurrentVersion(ctx, repo.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &BundleManifest{
+		Format:      BundleFormat,
+		Repo:        repo.Name,
+		FromVersion: since,
+		ToVersion:   current.CurrentVersion,
+		CreatedAt:   time.Now(),
+	}
+	if manifest.FromVersion == "" {
+		manifest.FromVersion = model.ZeroVersion
+	}
+
+	changes, more, err := bundleChanges(ctx, repo.ID, since)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(changes); n > 0 {
+		manifest.ToVersion, manifest.More = changes[n-1].Version, more
+	}
+
+	// Directories moved or copied bring their content along without a change of its own
+	touched := map[string]bool{}
+	subtrees := map[string]bool{}
+	for _, change := range changes {
+		touched[change.Path] = true
+		if change.OldPath != nil {
+			touched[*change.OldPath] = true
+		}
+		if change.Operation == "move" || change.Operation == "copy" {
+			subtrees[change.Path] = true
+		}
+	}
+
+	seen := map[string]bool{}
+	add := func(file *model.FileObject) error {
+		if seen[file.Path] {
+			return nil
+		}
+		seen[file.Path] = true
+
+		entry, err := bundleEntry(ctx, repo, file)
+		if err != nil {
+			return err
+		}
+		manifest.Entries = append(manifest.Entries, *entry)
+		return nil
+	}
+
+	for p := range touched {
+		file, err := db.GetFile(ctx, repo.ID, p)
+		if stor.IsNotFound(err) {
+			manifest.Entries = append(manifest.Entries, BundleEntry{Path: p, Deleted: true})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !subtrees[p] {
+			err = add(file)
+		} else {
+			err = stor.WalkTree(ctx, file, false, add)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sortBundleEntries(manifest.Entries)
+	return &Bundle{Manifest: manifest, repo: repo}, nil
+}
+
+// bundleChanges returns up to MaxBundleChanges changes since a version, reporting whether more follow.
+// Changes sharing a version are never split between bundles, so that the next one can start after it.
+func bundleChanges(ctx context.Context, repoID int, since string) ([]*model.ChangeLog, bool, error) {
+	page, err := db.GetChangesSince(ctx, repoID, since, model.ChangeFilter{}, MaxBundleChanges+1)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var changes []*model.ChangeLog
+	for len(page) > 0 {
+		for _, change := range page {
+			if len(changes) >= MaxBundleChanges && change.Version != changes[len(changes)-1].Version {
+				return changes, true, nil
+			}
+			changes = append(changes, change)
+		}
+
+		if page, err = db.ListChangesAfter(ctx, repoID, changes[len(changes)-1].ID, model.ChangeFilter{}, MaxBundleChanges+1); err != nil {
+			return nil, false, err
+		}
+	}
+	return changes, false, nil
+}
+
+// bundleEntry describes the current state of a file, hashing its content if no checksum was recorded
+func bundleEntry(ctx context.Context, repo *model.Repository, file *model.FileObject) (*BundleEntry, error) {
+	entry := &BundleEntry{Path: file.Path, IsDir: file.IsDir, ModTime: file.ModTime}
+	if file.IsDir {
+		return entry, nil
+	}
+
+	entry.Size = file.Size
+	if file.Checksum != nil && *file.Checksum != "" {
+		entry.Checksum = *file.Checksum
+		return entry, nil
+	}
+
+	reader, err := stor.OpenFile(ctx, &model.Resource{Repo: repo, Path: file.Path})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", file.Path, err)
+	}
+	defer reader.Close()
+
+	if entry.Checksum, err = calculateSHA256Reader(reader); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file.Path, err)
+	}
+	return entry, nil
+}
+
+// sortBundleEntries orders entries the way they are applied: removals first, children before their
+// parents, then directories and files, parents before their children
+func sortBundleEntries(entries []BundleEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Deleted != b.Deleted {
+			return a.Deleted
+		}
+		if a.Deleted {
+			return a.Path > b.Path
+		}
+		return a.Path < b.Path
+	})
+}
+
+// Write writes the bundle to w, the manifest first and then the content of its files
+func (b *Bundle) Write(ctx context.Context, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	manifest, err := json.Marshal(b.Manifest)
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: bundleManifest, Mode: 0644, Size: int64(len(manifest)), ModTime: b.Manifest.CreatedAt}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+
+	written := map[string]bool{}
+	for _, entry := range b.Manifest.Entries {
+		if entry.Deleted || entry.IsDir || written[entry.Checksum] {
+			continue
+		}
+		written[entry.Checksum] = true
+
+		if err := b.writeBlob(ctx, tw, entry); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (b *Bundle) writeBlob(ctx context.Context, tw *tar.Writer, entry BundleEntry) error {
+	reader, err := stor.OpenFile(ctx, &model.Resource{Repo: b.repo, Path: entry.Path})
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", entry.Path, err)
+	}
+	defer reader.Close()
+
+	header := &tar.Header{Name: bundleBlobDir + entry.Checksum, Mode: 0644, Size: entry.Size, ModTime: entry.ModTime}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	// A file changed since it was listed no longer fits its header; the import then rejects its checksum
+	if _, err := io.CopyN(tw, reader, entry.Size); err != nil {
+		return fmt.Errorf("failed to read %s: %w", entry.Path, err)
+	}
+	return nil
+}
+
+// ImportResult tells what applying a bundle changed
+type ImportResult struct {
+	Version   string `json:"version"`   // repository version after the import
+	Updated   int    `json:"updated"`   // files and directories created or replaced
+	Removed   int    `json:"removed"`   // files and directories removed
+	Unchanged int    `json:"unchanged"` // entries already in the state the bundle holds
+}
+
+// importLock serializes imports, so that two bundles cannot both apply to the same version
+var importLock sync.Mutex
+
+// ImportBundle applies a bundle written by Bundle.Write to a repository at the version it was exported since.
+// A bundle holding a file over storage.max_file_size, or more content than the quota of the repository owner
+// has room for, is refused before its content is read. All content is verified against its checksum before
+// anything is changed. The changes are recorded
+// with the version of the bundle, which the repository is at afterwards. An import that fails part way
+// leaves the version alone, so that the same bundle can be imported again.
+func (s *Service) ImportBundle(ctx context.Context, repo *model.Repository, r io.Reader, userID int) (*ImportResult, error) {
+	if err := authorize(ctx, repo, "", userID, perm.Write); err != nil {
+		return nil, err
+	}
+
+	importLock.Lock()
+	defer importLock.Unlock()
+
+	tr := tar.NewReader(r)
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := db.GetCurrentVersion(ctx, repo.ID)
+	if err != nil {
+		return nil, err
+	}
+	if current.CurrentVersion != manifest.FromVersion {
+		return nil, fmt.Errorf("%w: repository is at %s, the bundle applies to %s", ErrBundleVersion, current.CurrentVersion, manifest.FromVersion)
+	}
+	if err := checkBundleFits(ctx, repo, manifest); err != nil {
+		return nil, err
+	}
+
+	blobDir, err := os.MkdirTemp("", "bundle-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(blobDir)
+
+	if err := readBlobs(tr, manifest, blobDir); err != nil {
+		return nil, err
+	}
+
+	imp := &bundleImport{svc: s, repo: repo, userID: userID, version: manifest.ToVersion, blobDir: blobDir}
+	result := &ImportResult{Version: manifest.ToVersion}
+	for _, entry := range manifest.Entries {
+		if err := imp.apply(ctx, entry, result); err != nil {
+			return nil, fmt.Errorf("failed to apply %s: %w", entry.Path, err)
+		}
+	}
+
+	if err := db.UpdateVersion(ctx, repo.ID, manifest.ToVersion, "{}"); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// readManifest reads and checks the manifest, which comes first in a bundle
+func readManifest(tr *tar.Reader) (*BundleManifest, error) {
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+	}
+	if header.Name != bundleManifest {
+		return nil, fmt.Errorf("%w: %s does not come first", ErrInvalidBundle, bundleManifest)
+	}
+	if header.Size > maxManifestSize {
+		return nil, fmt.Errorf("%w: manifest too large", ErrInvalidBundle)
+	}
+
+	manifest := &BundleManifest{}
+	if err := json.NewDecoder(io.LimitReader(tr, maxManifestSize)).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+	}
+	if err := manifest.validate(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func (m *BundleManifest) validate() error {
+	if m.Format != BundleFormat {
+		return fmt.Errorf("%w: unsupported format %d", ErrInvalidBundle, m.Format)
+	}
+	if m.FromVersion == "" || m.ToVersion == "" || m.ToVersion < m.FromVersion {
+		return fmt.Errorf("%w: versions %q to %q", ErrInvalidBundle, m.FromVersion, m.ToVersion)
+	}
+
+	for _, entry := range m.Entries {
+		if entry.Path == "/" || path.Clean("/"+entry.Path) != entry.Path {
+			return fmt.Errorf("%w: path %q", ErrInvalidBundle, entry.Path)
+		}
+		if !entry.Deleted && !entry.IsDir && (!isSHA256(entry.Checksum) || entry.Size < 0) {
+			return fmt.Errorf("%w: content of %s", ErrInvalidBundle, entry.Path)
+		}
+	}
+	return nil
+}
+
+// contentSize returns the bytes of distinct content a bundle holds, refusing a file over storage.max_file_size
+// and content over MaxBundleSize
+func (m *BundleManifest) contentSize() (int64, error) {
+	var total int64
+	seen := map[string]bool{}
+	for _, entry := range m.Entries {
+		if entry.Deleted || entry.IsDir || seen[entry.Checksum] {
+			continue
+		}
+		seen[entry.Checksum] = true
+
+		if err := stor.CheckFileSize(entry.Size); err != nil {
+			return 0, fmt.Errorf("%s: %w", entry.Path, err)
+		}
+		if entry.Size > MaxBundleSize-total {
+			return 0, fmt.Errorf("%w: bundle content over %d bytes", stor.ErrTooLarge, MaxBundleSize)
+		}
+		total += entry.Size
+	}
+	return total, nil
+}
+
+// checkBundleFits refuses a bundle too large to spool, or that needs more storage than the quota of the
+// repository owner has left, counting files it replaces as freed
+func checkBundleFits(ctx context.Context, repo *model.Repository, manifest *BundleManifest) error {
+	if _, err := manifest.contentSize(); err != nil {
+		return err
+	}
+
+	var needed int64
+	for _, entry := range manifest.Entries {
+		if entry.Deleted || entry.IsDir {
+			continue
+		}
+		existing, err := db.GetFile(ctx, repo.ID, entry.Path)
+		if err != nil && !stor.IsNotFound(err) {
+			return err
+		}
+		needed += entry.Size - replacedSize(existing)
+	}
+
+	_, available, err := users.Usage(ctx, repo.OwnerID)
+	if err != nil {
+		return err
+	}
+	if needed > available {
+		return fmt.Errorf("%w: %d bytes needed, %d available", ErrQuotaExceeded, needed, available)
+	}
+	return nil
+}
+
+func isSHA256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+// readBlobs stores the content of a bundle in dir, verifying each blob against the checksum naming it.
+// Every file of the manifest must have its blob, and no other blob is accepted.
+func readBlobs(tr *tar.Reader, manifest *BundleManifest, dir string) error {
+	sizes := map[string]int64{}
+	for _, entry := range manifest.Entries {
+		if !entry.Deleted && !entry.IsDir {
+			sizes[entry.Checksum] = entry.Size
+		}
+	}
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+		}
+
+		checksum, ok := strings.CutPrefix(header.Name, bundleBlobDir)
+		size, wanted := sizes[checksum]
+		if !ok || !wanted {
+			return fmt.Errorf("%w: unexpected %s", ErrInvalidBundle, header.Name)
+		}
+		if header.Size != size {
+			return fmt.Errorf("%w: %s holds %d bytes, expected %d", ErrInvalidBundle, header.Name, header.Size, size)
+		}
+
+		if err := readBlob(tr, checksum, filepath.Join(dir, checksum)); err != nil {
+			return err
+		}
+		delete(sizes, checksum)
+	}
+
+	for checksum := range sizes {
+		return fmt.Errorf("%w: missing %s%s", ErrInvalidBundle, bundleBlobDir, checksum)
+	}
+	return nil
+}
+
+func readBlob(r io.Reader, checksum, name string) error {
+	out, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to store blob: %w", err)
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), r); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != checksum {
+		return &ChecksumMismatchError{Expected: checksum, Actual: actual}
+	}
+	return out.Close()
+}
+
+// bundleImport applies the entries of a bundle, recording each change with the bundle's version
+type bundleImport struct {
+	svc     *Service
+	repo    *model.Repository
+	userID  int
+	version string
+	blobDir string
+}
+
+func (imp *bundleImport) apply(ctx context.Context, entry BundleEntry, result *ImportResult) error {
+	existing, err := db.GetFile(ctx, imp.repo.ID, entry.Path)
+	if stor.IsNotFound(err) {
+		existing = nil
+	} else if err != nil {
+		return err
+	}
+
+	switch {
+	case entry.Deleted && existing == nil,
+		entry.IsDir && existing != nil && existing.IsDir,
+		!entry.Deleted && !entry.IsDir && existing != nil && !existing.IsDir && existing.Checksum != nil && *existing.Checksum == entry.Checksum:
+		result.Unchanged++
+		return nil
+	}
+
+	// A directory replacing a file, or the other way round, goes in place of what was there
+	if existing != nil && (entry.Deleted || entry.IsDir != existing.IsDir) {
+		removed := &MutationResult{version: imp.version}
+		if err := imp.svc.delete(ctx, imp.repo, entry.Path, true, imp.userID, removed); err != nil {
+			return err
+		}
+		result.Removed += removed.Affected
+		existing = nil
+	}
+
+	switch {
+	case entry.Deleted:
+		return nil
+	case entry.IsDir:
+		var parents []*model.FileObject
+		_, err := stor.CreateDir(ctx, &model.Resource{Repo: imp.repo, Path: entry.Path}, collectDirs(&parents))
+		if err := imp.recordParents(ctx, parents, result); err != nil {
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		return imp.record(ctx, "create", entry.Path, result)
+	}
+
+	if err := imp.putFile(ctx, entry, result); err != nil {
+		return err
+	}
+	if existing != nil {
+		return imp.record(ctx, "modify", entry.Path, result)
+	}
+	return imp.record(ctx, "create", entry.Path, result)
+}
+
+func (imp *bundleImport) putFile(ctx context.Context, entry BundleEntry, result *ImportResult) error {
+	var parents []*model.FileObject
+	parent, err := stor.ResolveParent(ctx, imp.repo, entry.Path, collectDirs(&parents))
+	if err := imp.recordParents(ctx, parents, result); err != nil {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	blob, err := os.Open(filepath.Join(imp.blobDir, entry.Checksum))
+	if err != nil {
+		return fmt.Errorf("failed to open blob: %w", err)
+	}
+	defer blob.Close()
+
+	resource := &model.Resource{Repo: imp.repo, Path: entry.Path}
+	if _, err := stor.PutFile(ctx, resource, blob); err != nil {
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+
+	checksum := entry.Checksum
+	file := &model.FileObject{
+		RepoID:   imp.repo.ID,
+		OwnerID:  imp.userID,
+		ParentID: parent.ID,
+		Path:     entry.Path,
+		Name:     path.Base(entry.Path),
+		Size:     entry.Size,
+		ModTime:  storedModTime(entry.ModTime),
+		Checksum: &checksum,
+	}
+	if err := db.UpsertFile(ctx, file); err != nil {
+		return fmt.Errorf("failed to update database: %w", err)
+	}
+
+	if !entry.ModTime.IsZero() {
+		if err := stor.SetModTime(ctx, resource, entry.ModTime); err != nil {
+			return fmt.Errorf("failed to set modification time: %w", err)
+		}
+	}
+	return nil
+}
+
+// recordParents records the directories created as parents of an entry the bundle did not hold itself
+func (imp *bundleImport) recordParents(ctx context.Context, dirs []*model.FileObject, result *ImportResult) error {
+	for _, dir := range dirs {
+		if err := imp.record(ctx, "create", dir.Path, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (imp *bundleImport) record(ctx context.Context, operation, p string, result *ImportResult) error {
+	change := &model.ChangeLog{
+		RepoID:    imp.repo.ID,
+		Operation: operation,
+		Path:      p,
+		UserID:    imp.userID,
+		Version:   imp.version,
+	}
+	// The bundle holds what outlived the window on the other server already
+	if err := logChange(ctx, change); err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+	result.Updated++
+	return nil
+}
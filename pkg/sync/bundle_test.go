@@ -0,0 +1,173 @@
+package sync
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tarBundle builds a bundle from a manifest and blobs given by name
+func tarBundle(t *testing.T, manifest *BundleManifest, blobs map[string]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: bundleManifest, Mode: 0644, Size: int64(len(data))}))
+	_, err = tw.Write(data)
+	require.NoError(t, err)
+
+	for name, content := range blobs {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err = tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return &buf
+}
+
+func TestSortBundleEntries(t *testing.T) {
+	entries := []BundleEntry{
+		{Path: "/docs/a.txt"},
+		{Path: "/old", Deleted: true},
+		{Path: "/docs", IsDir: true},
+		{Path: "/old/b.txt", Deleted: true},
+		{Path: "/docs b.txt"},
+	}
+	sortBundleEntries(entries)
+
+	var paths []string
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	assert.Equal(t, []string{"/old/b.txt", "/old", "/docs", "/docs b.txt", "/docs/a.txt"}, paths)
+}
+
+func TestReadBundle(t *testing.T) {
+	content := "hello"
+	checksum := calculateSHA256([]byte(content))
+	manifest := func() *BundleManifest {
+		return &BundleManifest{
+			Format:      BundleFormat,
+			FromVersion: "v1-0",
+			ToVersion:   "v2-0",
+			Entries: []BundleEntry{
+				{Path: "/gone", Deleted: true},
+				{Path: "/docs", IsDir: true},
+				{Path: "/docs/a.txt", Size: int64(len(content)), Checksum: checksum},
+				{Path: "/docs/copy.txt", Size: int64(len(content)), Checksum: checksum},
+			},
+		}
+	}
+
+	read := func(buf *bytes.Buffer) error {
+		tr := tar.NewReader(buf)
+		m, err := readManifest(tr)
+		if err != nil {
+			return err
+		}
+		return readBlobs(tr, m, t.TempDir())
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		dir := t.TempDir()
+		tr := tar.NewReader(tarBundle(t, manifest(), map[string]string{bundleBlobDir + checksum: content}))
+		m, err := readManifest(tr)
+		require.NoError(t, err)
+		assert.Equal(t, "v2-0", m.ToVersion)
+		require.NoError(t, readBlobs(tr, m, dir))
+
+		data, err := os.ReadFile(filepath.Join(dir, checksum))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(data))
+	})
+
+	t.Run("Checksum mismatch", func(t *testing.T) {
+		err := read(tarBundle(t, manifest(), map[string]string{bundleBlobDir + checksum: "jello"}))
+		var mismatch *ChecksumMismatchError
+		require.ErrorAs(t, err, &mismatch)
+		assert.Equal(t, checksum, mismatch.Expected)
+	})
+
+	t.Run("Missing blob", func(t *testing.T) {
+		err := read(tarBundle(t, manifest(), nil))
+		assert.ErrorIs(t, err, ErrInvalidBundle)
+	})
+
+	t.Run("Unexpected blob", func(t *testing.T) {
+		other := calculateSHA256([]byte("other"))
+		err := read(tarBundle(t, manifest(), map[string]string{bundleBlobDir + other: "other"}))
+		assert.ErrorIs(t, err, ErrInvalidBundle)
+	})
+
+	t.Run("Path outside the repository", func(t *testing.T) {
+		m := manifest()
+		m.Entries = append(m.Entries, BundleEntry{Path: "/docs/../../etc", IsDir: true})
+		err := read(tarBundle(t, m, map[string]string{bundleBlobDir + checksum: content}))
+		assert.ErrorIs(t, err, ErrInvalidBundle)
+	})
+
+	t.Run("Versions out of order", func(t *testing.T) {
+		m := manifest()
+		m.FromVersion, m.ToVersion = m.ToVersion, m.FromVersion
+		err := read(tarBundle(t, m, map[string]string{bundleBlobDir + checksum: content}))
+		assert.ErrorIs(t, err, ErrInvalidBundle)
+	})
+
+	t.Run("Manifest not first", func(t *testing.T) {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: bundleBlobDir + checksum, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+
+		assert.ErrorIs(t, read(&buf), ErrInvalidBundle)
+	})
+}
+
+func TestWriteBundleWithoutContent(t *testing.T) {
+	bundle := &Bundle{Manifest: &BundleManifest{
+		Format:      BundleFormat,
+		FromVersion: "v1-0",
+		ToVersion:   "v2-0",
+		Entries:     []BundleEntry{{Path: "/gone", Deleted: true}, {Path: "/docs", IsDir: true}},
+	}}
+
+	var buf bytes.Buffer
+	require.NoError(t, bundle.Write(context.Background(), &buf))
+
+	tr := tar.NewReader(&buf)
+	m, err := readManifest(tr)
+	require.NoError(t, err)
+	assert.Equal(t, bundle.Manifest.Entries, m.Entries)
+	assert.NoError(t, readBlobs(tr, m, t.TempDir()))
+}
+
+func TestBundleContentSize(t *testing.T) {
+	defer func(size int64) { MaxBundleSize = size }(MaxBundleSize)
+	MaxBundleSize = 10
+
+	manifest := &BundleManifest{Entries: []BundleEntry{
+		{Path: "/gone", Deleted: true},
+		{Path: "/docs", IsDir: true},
+		{Path: "/docs/a.txt", Size: 4, Checksum: "a"},
+		{Path: "/docs/copy.txt", Size: 4, Checksum: "a"},
+		{Path: "/docs/b.txt", Size: 6, Checksum: "b"},
+	}}
+	size, err := manifest.contentSize()
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), size, "content shared by files is counted once")
+
+	manifest.Entries = append(manifest.Entries, BundleEntry{Path: "/docs/c.txt", Size: 1, Checksum: "c"})
+	_, err = manifest.contentSize()
+	assert.ErrorIs(t, err, stor.ErrTooLarge)
+}
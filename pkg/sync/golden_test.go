@@ -0,0 +1,134 @@
+package sync
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var updateGolden = flag.Bool("update", false, "record wire encoding for new golden fixtures")
+
+const goldenDir = "testdata/golden"
+
+// goldenFixture is a message recorded by an earlier release of the protocol.
+// Wire holds the frozen binary encoding; JSON holds what it must still decode to.
+type goldenFixture struct {
+	Message string          `json:"message"`
+	Wire    string          `json:"wire,omitempty"`
+	JSON    json.RawMessage `json:"json"`
+}
+
+func loadGoldenFixtures(t *testing.T) map[string]*goldenFixture {
+	files, err := filepath.Glob(filepath.Join(goldenDir, "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	fixtures := make(map[string]*goldenFixture)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+
+		var fixture goldenFixture
+		require.NoError(t, json.Unmarshal(data, &fixture), file)
+		fixtures[file] = &fixture
+	}
+	return fixtures
+}
+
+func newGoldenMessage(t *testing.T, name string) proto.Message {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name))
+	require.NoError(t, err, "message %s no longer exists", name)
+	return mt.New().Interface()
+}
+
+// TestGoldenFixtures checks that messages encoded by earlier releases still
+// decode to the same values, in both the binary and the JSON encoding.
+func TestGoldenFixtures(t *testing.T) {
+	for file, fixture := range loadGoldenFixtures(t) {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			fromJSON := newGoldenMessage(t, fixture.Message)
+			require.NoError(t, protojson.Unmarshal(fixture.JSON, fromJSON))
+
+			if fixture.Wire == "" {
+				if !*updateGolden {
+					t.Fatalf("fixture has no wire encoding, run go test -update to record it")
+				}
+				wire, err := proto.MarshalOptions{Deterministic: true}.Marshal(fromJSON)
+				require.NoError(t, err)
+				fixture.Wire = base64.StdEncoding.EncodeToString(wire)
+
+				data, err := json.MarshalIndent(fixture, "", "  ")
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(file, append(data, '\n'), 0644))
+			}
+
+			wire, err := base64.StdEncoding.DecodeString(fixture.Wire)
+			require.NoError(t, err)
+
+			fromWire := newGoldenMessage(t, fixture.Message)
+			require.NoError(t, proto.Unmarshal(wire, fromWire))
+			assert.True(t, proto.Equal(fromJSON, fromWire), "wire and JSON encodings differ:\n%v\n%v", fromJSON, fromWire)
+
+			// Nothing in the fixture may be dropped as an unknown field
+			assert.Empty(t, fromWire.ProtoReflect().GetUnknown())
+		})
+	}
+}
+
+// TestDeprecatedFieldsCovered enforces the deprecation policy: a field marked
+// deprecated must keep working until it is removed, so a golden fixture must
+// exercise it.
+func TestDeprecatedFieldsCovered(t *testing.T) {
+	covered := make(map[protoreflect.FullName]bool)
+	for _, fixture := range loadGoldenFixtures(t) {
+		msg := newGoldenMessage(t, fixture.Message)
+		require.NoError(t, protojson.Unmarshal(fixture.JSON, msg))
+		collectPopulatedFields(msg.ProtoReflect(), covered)
+	}
+
+	var check func(protoreflect.MessageDescriptors)
+	check = func(mds protoreflect.MessageDescriptors) {
+		for i := 0; i < mds.Len(); i++ {
+			md := mds.Get(i)
+			fields := md.Fields()
+			for j := 0; j < fields.Len(); j++ {
+				fd := fields.Get(j)
+				opts, _ := fd.Options().(*descriptorpb.FieldOptions)
+				if opts.GetDeprecated() && !covered[fd.FullName()] {
+					t.Errorf("deprecated field %s has no golden fixture", fd.FullName())
+				}
+			}
+			check(md.Messages())
+		}
+	}
+	check(File_pkg_sync_sync_proto.Messages())
+}
+
+func collectPopulatedFields(m protoreflect.Message, seen map[protoreflect.FullName]bool) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		seen[fd.FullName()] = true
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				collectPopulatedFields(list.Get(i).Message(), seen)
+			}
+		case fd.IsMap():
+			// the sync protocol has no map fields
+		case fd.Message() != nil:
+			collectPopulatedFields(v.Message(), seen)
+		}
+		return true
+	})
+}
@@ -3,15 +3,20 @@ package sync
 import (
 	"context"
 	"encoding/base64"
+	"errors"
+	"net"
 	"strings"
 	"sync"
 
 	"github.com/cgang/file-hub/pkg/db"
 	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/netacl"
+	"github.com/cgang/file-hub/pkg/users"
 	"github.com/cgang/file-hub/pkg/web/session"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -61,10 +66,15 @@ func AuthInterceptor() grpc.UnaryServerInterceptor {
 		newCtx = context.WithValue(newCtx, UsernameContextKey, user.Username)
 
 		// Extract repo name from request if available
-		if repoName := extractRepoName(req); repoName != "" {
+		repoName := extractRepoName(req)
+		if repoName != "" {
 			newCtx = context.WithValue(newCtx, RepoNameContextKey, repoName)
 		}
 
+		if err := checkNetwork(ctx, user.Username, repoName); err != nil {
+			return nil, err
+		}
+
 		// Call the handler with the authenticated context
 		return handler(newCtx, req)
 	}
@@ -93,7 +103,7 @@ func authenticateFromMetadata(ctx context.Context, md metadata.MD) (*model.User,
 	// Try Authorization header (Basic auth)
 	authHeaders := md.Get("authorization")
 	if len(authHeaders) > 0 {
-		user, err := authenticateFromAuthHeader(authHeaders[0])
+		user, err := authenticateFromAuthHeader(ctx, authHeaders[0])
 		if err == nil {
 			return user, nil
 		}
@@ -131,7 +141,7 @@ func authenticateFromCookie(cookie string) (*model.User, error) {
 }
 
 // authenticateFromAuthHeader authenticates user from Authorization header
-func authenticateFromAuthHeader(authHeader string) (*model.User, error) {
+func authenticateFromAuthHeader(ctx context.Context, authHeader string) (*model.User, error) {
 	// Parse "Basic <credentials>"
 	parts := strings.SplitN(authHeader, " ", 2)
 	if len(parts) != 2 || !strings.EqualFold(parts[0], "Basic") {
@@ -154,19 +164,33 @@ func authenticateFromAuthHeader(authHeader string) (*model.User, error) {
 	username := creds[:colonIdx]
 	password := creds[colonIdx+1:]
 
-	// Validate credentials against database
-	user, err := db.GetUserByUsername(context.Background(), username)
-	if err != nil {
-		return nil, status.Errorf(codes.Unauthenticated, "invalid credentials: %v", err)
+	// Validate credentials against the stored HA1 hash
+	user, err := users.Authenticate(ctx, username, password, peerAddr(ctx))
+	if errors.Is(err, users.ErrLockedOut) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	} else if errors.Is(err, users.ErrAppPasswordRequired) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
 	}
 
-	// Note: In production, verify password hash here
-	// For now, we just check if user exists
-	_ = password // Use password in production with proper hash verification
-
 	return user, nil
 }
 
+// peerAddr returns the client IP of the gRPC peer, or an empty string if unknown
+func peerAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
 // authenticateFromSessionToken authenticates user from session token
 func authenticateFromSessionToken(token string) (*model.User, error) {
 	store := getSessionStore()
@@ -208,6 +232,10 @@ func StreamAuthInterceptor() grpc.StreamServerInterceptor {
 			return status.Error(codes.Unauthenticated, err.Error())
 		}
 
+		if err := checkNetwork(ctx, user.Username, ""); err != nil {
+			return err
+		}
+
 		// Add user info to context
 		newCtx := context.WithValue(ctx, UserIDContextKey, user.ID)
 		newCtx = context.WithValue(newCtx, UsernameContextKey, user.Username)
@@ -216,6 +244,7 @@ func StreamAuthInterceptor() grpc.StreamServerInterceptor {
 		wrapped := &wrappedStream{
 			ServerStream: ss,
 			ctx:          newCtx,
+			username:     user.Username,
 		}
 
 		return handler(srv, wrapped)
@@ -225,9 +254,40 @@ func StreamAuthInterceptor() grpc.StreamServerInterceptor {
 // wrappedStream wraps a gRPC stream to use a different context
 type wrappedStream struct {
 	grpc.ServerStream
-	ctx context.Context
+	ctx      context.Context
+	username string
 }
 
 func (w *wrappedStream) Context() context.Context {
 	return w.ctx
 }
+
+// RecvMsg enforces repository network restrictions on each received request
+func (w *wrappedStream) RecvMsg(m any) error {
+	if err := w.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	if repoName := extractRepoName(m); repoName != "" {
+		return checkNetwork(w.ctx, w.username, repoName)
+	}
+	return nil
+}
+
+// checkNetwork enforces the global and, if named, the repository network access lists
+func checkNetwork(ctx context.Context, username, repoName string) error {
+	addr := peerAddr(ctx)
+
+	err := netacl.CheckGlobal(ctx, addr, username)
+	if err == nil && repoName != "" {
+		// Unknown repositories are reported by the handler itself
+		if repo, lookupErr := db.GetRepositoryByName(ctx, repoName); lookupErr == nil {
+			err = netacl.CheckRepo(ctx, repo, addr, username)
+		}
+	}
+
+	if err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
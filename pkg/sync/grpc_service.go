@@ -1,17 +1,27 @@
 package sync
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"time"
 
 	"github.com/cgang/file-hub/pkg/db"
 	"github.com/cgang/file-hub/pkg/model"
 	"github.com/uptrace/bun"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
+// Page sizes of ListDirectoryStream
+const (
+	DefaultStreamPageSize = 500
+	MaxStreamPageSize     = 5000
+)
+
 // GRPCService implements the gRPC SyncService server
 type GRPCService struct {
 	UnimplementedSyncServiceServer
@@ -27,7 +37,7 @@ func NewGRPCService(database *bun.DB) *GRPCService {
 
 // getRepositoryFromContext extracts repository from context using user ID
 func (g *GRPCService) getRepositoryFromContext(ctx context.Context, repoName string) (*model.Repository, error) {
-	userID, ok := ctx.Value("userID").(int)
+	userID, ok := ctx.Value(UserIDContextKey).(int)
 	if !ok {
 		return nil, status.Error(codes.Unauthenticated, "user not authenticated")
 	}
@@ -47,7 +57,7 @@ func (g *GRPCService) UploadFile(ctx context.Context, req *UploadFileRequest) (*
 		return &UploadFileResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
 
-	etag, _, _, err := g.service.UploadFile(ctx, repo, req.Path, req.Content, req.MimeType, 0)
+	path, etag, _, _, err := g.service.UploadFile(ctx, repo, req.Path, bytes.NewReader(req.Content), req.MimeType, req.Etag, unixModTime(req.ModTime), req.Autorename, 0)
 	if err != nil {
 		return &UploadFileResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
@@ -59,6 +69,7 @@ func (g *GRPCService) UploadFile(ctx context.Context, req *UploadFileRequest) (*
 		Success: true,
 		Etag:    etag,
 		Version: versionNum,
+		Path:    path,
 	}, nil
 }
 
@@ -69,15 +80,17 @@ func (g *GRPCService) BeginUpload(ctx context.Context, req *BeginUploadRequest)
 		return &BeginUploadResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
 
-	uploadID, uploadedChunks, err := g.service.BeginUpload(ctx, repo, req.Path, req.TotalSize, 0)
+	session, uploadedChunks, err := g.service.BeginUpload(ctx, repo, req.Path, req.TotalSize, req.ChunkSize, unixModTime(req.ModTime), 0)
 	if err != nil {
 		return &BeginUploadResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
 
 	return &BeginUploadResponse{
 		Success:        true,
-		UploadId:       uploadID,
+		UploadId:       session.UploadID,
 		UploadedChunks: int32SliceToInt32(uploadedChunks),
+		ChunkSize:      session.ChunkSize,
+		TotalChunks:    int32(session.TotalChunks),
 	}, nil
 }
 
@@ -96,12 +109,12 @@ func (g *GRPCService) UploadChunk(ctx context.Context, req *UploadChunkRequest)
 
 // FinalizeUpload implements the FinalizeUpload RPC
 func (g *GRPCService) FinalizeUpload(ctx context.Context, req *FinalizeUploadRequest) (*FinalizeUploadResponse, error) {
-	userID, ok := ctx.Value("userID").(int)
+	userID, ok := ctx.Value(UserIDContextKey).(int)
 	if !ok {
 		return &FinalizeUploadResponse{Success: false, ErrorMessage: "user not authenticated"}, nil
 	}
 
-	repoName, ok := ctx.Value("repoName").(string)
+	repoName, ok := ctx.Value(RepoNameContextKey).(string)
 	if !ok {
 		return &FinalizeUploadResponse{Success: false, ErrorMessage: "repository name not found in context"}, nil
 	}
@@ -111,7 +124,7 @@ func (g *GRPCService) FinalizeUpload(ctx context.Context, req *FinalizeUploadReq
 		return &FinalizeUploadResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
 
-	etag, _, err := g.service.FinalizeUpload(ctx, req.UploadId, repo, userID)
+	path, etag, _, err := g.service.FinalizeUpload(ctx, req.UploadId, repo, req.ExpectedEtag, time.Time{}, req.Autorename, userID)
 	if err != nil {
 		return &FinalizeUploadResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
@@ -119,6 +132,7 @@ func (g *GRPCService) FinalizeUpload(ctx context.Context, req *FinalizeUploadReq
 	return &FinalizeUploadResponse{
 		Success: true,
 		Etag:    etag,
+		Path:    path,
 	}, nil
 }
 
@@ -143,7 +157,18 @@ func (g *GRPCService) DownloadFile(req *DownloadFileRequest, stream grpc.ServerS
 		return status.Errorf(codes.NotFound, "repository not found: %v", err)
 	}
 
-	file, reader, err := g.service.DownloadFile(ctx, repo, req.Path, req.IfNoneMatch, 0)
+	if req.Offset < 0 || req.Length < 0 {
+		return status.Error(codes.InvalidArgument, "offset and length must not be negative")
+	}
+
+	// Resumed downloads read only the rest of the file
+	var file *model.FileObject
+	var reader io.ReadCloser
+	if req.Offset > 0 || req.Length > 0 {
+		file, reader, err = g.service.DownloadFileRange(ctx, repo, req.Path, req.IfNoneMatch, req.Offset, req.Length, repo.OwnerID)
+	} else {
+		file, reader, err = g.service.DownloadFile(ctx, repo, req.Path, req.IfNoneMatch, repo.OwnerID)
+	}
 	if err != nil {
 		return status.Errorf(codes.Internal, "failed to download file: %v", err)
 	}
@@ -235,7 +260,12 @@ func (g *GRPCService) ListDirectory(ctx context.Context, req *ListDirectoryReque
 		limit = 100
 	}
 
-	items, total, err := g.service.ListDirectory(ctx, repo, req.Path, offset, limit, 0)
+	order, err := ParseListOrder(req.Sort, req.DirsFirst)
+	if err != nil {
+		return &ListDirectoryResponse{ErrorMessage: err.Error()}, nil
+	}
+
+	items, total, err := g.service.ListDirectory(ctx, repo, req.Path, order, offset, limit, 0)
 	if err != nil {
 		return &ListDirectoryResponse{ErrorMessage: err.Error()}, nil
 	}
@@ -254,6 +284,47 @@ func (g *GRPCService) ListDirectory(ctx context.Context, req *ListDirectoryReque
 	}, nil
 }
 
+// ListDirectoryStream implements the ListDirectoryStream RPC.
+// Entries are read from a DB cursor and sent in pages of req.Limit entries.
+func (g *GRPCService) ListDirectoryStream(req *ListDirectoryRequest, stream grpc.ServerStreamingServer[ListDirectoryStreamResponse]) error {
+	ctx := stream.Context()
+
+	repo, err := g.getRepositoryFromContext(ctx, req.Repo)
+	if err != nil {
+		return err
+	}
+
+	pageSize := int(req.Limit)
+	if pageSize <= 0 || pageSize > MaxStreamPageSize {
+		pageSize = DefaultStreamPageSize
+	}
+
+	order, err := ParseListOrder(req.Sort, req.DirsFirst)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	page := make([]*FileInfo, 0, pageSize)
+	err = g.service.ListDirectoryStream(ctx, repo, req.Path, order, int(req.Offset), 0, repo.OwnerID, func(file *model.FileObject) error {
+		if len(page) == pageSize {
+			if err := stream.Send(&ListDirectoryStreamResponse{Items: page, HasMore: true}); err != nil {
+				return err
+			}
+			page = make([]*FileInfo, 0, pageSize)
+		}
+		page = append(page, fileToProto(file))
+		return nil
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Errorf(codes.Internal, "failed to list directory: %v", err)
+	}
+
+	return stream.Send(&ListDirectoryStreamResponse{Items: page, HasMore: false})
+}
+
 // ListChanges implements the ListChanges RPC
 func (g *GRPCService) ListChanges(ctx context.Context, req *ListChangesRequest) (*ListChangesResponse, error) {
 	repo, err := g.getRepositoryFromContext(ctx, req.Repo)
@@ -266,33 +337,63 @@ func (g *GRPCService) ListChanges(ctx context.Context, req *ListChangesRequest)
 		maxChanges = 100
 	}
 
-	changes, err := g.service.ListChanges(ctx, repo.ID, req.SinceVersion, maxChanges)
+	page, err := g.service.ListChanges(ctx, repo.ID, req.SinceVersion, req.ContinuationToken, model.ChangeFilter{
+		PathPrefix: req.Path,
+		Operations: req.Operations,
+	}, maxChanges)
 	if err != nil {
 		return &ListChangesResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
 
+	// Authors are looked up at once, keyed by path as the categories below keep no change of their own
+	var authors map[string]*ChangeAuthor
+	if Expands(req.Expand, ExpandUser) {
+		if err := g.service.FillAuthors(ctx, page.Changes); err != nil {
+			return &ListChangesResponse{Success: false, ErrorMessage: err.Error()}, nil
+		}
+		authors = make(map[string]*ChangeAuthor)
+		for _, change := range page.Changes {
+			if change.User != nil {
+				authors[change.Path] = &ChangeAuthor{
+					UserId:      int32(change.User.ID),
+					Username:    change.User.Username,
+					DisplayName: change.User.DisplayName,
+				}
+			}
+		}
+	}
+
 	// Categorize changes
 	created := make([]*FileInfo, 0)
 	modified := make([]*FileInfo, 0)
 	deleted := make([]string, 0)
 	renamed := make([]*RenameOperation, 0)
 
-	for _, change := range changes {
+	// Created and modified files are looked up at once, those gone since are left out
+	var paths []string
+	for _, change := range page.Changes {
+		if change.Operation == "create" || change.Operation == "modify" {
+			paths = append(paths, change.Path)
+		}
+	}
+	files, err := g.service.GetFileInfos(ctx, repo, paths, 0)
+	if err != nil {
+		return &ListChangesResponse{Success: false, ErrorMessage: err.Error()}, nil
+	}
+
+	for _, change := range page.Changes {
 		switch change.Operation {
 		case "create":
-			// Get file info for created items
-			file, err := g.service.GetFileInfo(ctx, repo, change.Path, 0)
-			if err == nil {
+			if file, ok := files[change.Path]; ok {
 				created = append(created, fileToProto(file))
 			}
 		case "modify":
-			file, err := g.service.GetFileInfo(ctx, repo, change.Path, 0)
-			if err == nil {
+			if file, ok := files[change.Path]; ok {
 				modified = append(modified, fileToProto(file))
 			}
 		case "delete":
 			deleted = append(deleted, change.Path)
-		case "move":
+		case "move", "rename":
 			if change.OldPath != nil {
 				renamed = append(renamed, &RenameOperation{
 					OldPath: *change.OldPath,
@@ -302,7 +403,15 @@ func (g *GRPCService) ListChanges(ctx context.Context, req *ListChangesRequest)
 		}
 	}
 
-	currentVersion, _ := g.service.GetCurrentVersion(ctx, repo.ID)
+	if req.ContinuationToken == "" {
+		userID, _ := ctx.Value(UserIDContextKey).(int)
+		TrackClient(ctx, repo, userID, deviceIDFromContext(ctx), req.SinceVersion)
+	}
+
+	currentVersion, err := g.service.GetCurrentVersion(ctx, repo.ID)
+	if err != nil {
+		return &ListChangesResponse{Success: false, ErrorMessage: err.Error()}, nil
+	}
 
 	return &ListChangesResponse{
 		Success:       true,
@@ -311,10 +420,24 @@ func (g *GRPCService) ListChanges(ctx context.Context, req *ListChangesRequest)
 		Modified:      modified,
 		Deleted:       deleted,
 		Renamed:       renamed,
-		HasMore:       len(changes) >= maxChanges,
+		HasMore:       page.HasMore,
+		ContinuationToken: page.NextCursor,
+		Authors:           authors,
 	}, nil
 }
 
+// DeviceIDMetadata names the device of a sync client, so that how far behind it is gets tracked
+const DeviceIDMetadata = "x-device-id"
+
+// deviceIDFromContext returns the device a client named in the metadata of its call, empty if none
+func deviceIDFromContext(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get(DeviceIDMetadata); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
 // GetCurrentVersion implements the GetCurrentVersion RPC
 func (g *GRPCService) GetCurrentVersion(ctx context.Context, req *GetCurrentVersionRequest) (*GetCurrentVersionResponse, error) {
 	repo, err := g.getRepositoryFromContext(ctx, req.Repo)
@@ -341,12 +464,14 @@ func (g *GRPCService) CreateDirectory(ctx context.Context, req *CreateDirectoryR
 		return &CreateDirectoryResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
 
-	if err := g.service.CreateDirectory(ctx, repo, req.Path, 0); err != nil {
+	dir, err := g.service.CreateDirectory(ctx, repo, req.Path, req.Parents, 0)
+	if err != nil {
 		return &CreateDirectoryResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
 
 	return &CreateDirectoryResponse{
-		Success: true,
+		Success:   true,
+		Directory: fileToProto(dir),
 	}, nil
 }
 
@@ -357,12 +482,16 @@ func (g *GRPCService) Delete(ctx context.Context, req *DeleteRequest) (*DeleteRe
 		return &DeleteResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
 
-	if err := g.service.Delete(ctx, repo, req.Path, req.Recursive, 0); err != nil {
+	result, err := g.service.Delete(ctx, repo, req.Path, req.Recursive, 0)
+	if err != nil {
 		return &DeleteResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
 
 	return &DeleteResponse{
-		Success: true,
+		Success:      true,
+		Affected:     int32(result.Affected),
+		Version:      result.Version,
+		RemovedPaths: result.Removed,
 	}, nil
 }
 
@@ -373,12 +502,36 @@ func (g *GRPCService) Move(ctx context.Context, req *MoveRequest) (*MoveResponse
 		return &MoveResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
 
-	if err := g.service.Move(ctx, repo, req.SourcePath, req.DestinationPath, 0); err != nil {
+	result, err := g.service.Move(ctx, repo, req.SourcePath, req.DestinationPath, 0)
+	if err != nil {
 		return &MoveResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
 
 	return &MoveResponse{
-		Success: true,
+		Success:  true,
+		Affected: int32(result.Affected),
+		Version:  result.Version,
+		Target:   fileToProto(result.Target),
+	}, nil
+}
+
+// Rename implements the Rename RPC
+func (g *GRPCService) Rename(ctx context.Context, req *RenameRequest) (*RenameResponse, error) {
+	repo, err := g.getRepositoryFromContext(ctx, req.Repo)
+	if err != nil {
+		return &RenameResponse{Success: false, ErrorMessage: err.Error()}, nil
+	}
+
+	result, err := g.service.Rename(ctx, repo, req.Path, req.NewName, 0)
+	if err != nil {
+		return &RenameResponse{Success: false, ErrorMessage: err.Error()}, nil
+	}
+
+	return &RenameResponse{
+		Success:  true,
+		Affected: int32(result.Affected),
+		Version:  result.Version,
+		Target:   fileToProto(result.Target),
 	}, nil
 }
 
@@ -389,13 +542,25 @@ func (g *GRPCService) Copy(ctx context.Context, req *CopyRequest) (*CopyResponse
 		return &CopyResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
 
-	if err := g.service.Copy(ctx, repo, req.SourcePath, req.DestinationPath, 0); err != nil {
+	result, err := g.service.Copy(ctx, repo, req.SourcePath, req.DestinationPath, req.Autorename, 0)
+	if err != nil {
 		return &CopyResponse{Success: false, ErrorMessage: err.Error()}, nil
 	}
 
-	return &CopyResponse{
-		Success: true,
-	}, nil
+	resp := &CopyResponse{
+		Success:  true,
+		Affected: int32(result.Affected),
+		Version:  result.Version,
+		Target:   fileToProto(result.Target),
+	}
+	for _, failed := range result.Failed {
+		resp.FailedPaths = append(resp.FailedPaths, failed.Path)
+	}
+	if len(resp.FailedPaths) > 0 {
+		resp.Success = false
+		resp.ErrorMessage = fmt.Sprintf("%d items could not be copied", len(resp.FailedPaths))
+	}
+	return resp, nil
 }
 
 // GetSyncStatus implements the GetSyncStatus RPC
@@ -479,3 +644,11 @@ func int32SliceToInt32(slice []int) []int32 {
 	}
 	return result
 }
+
+// unixModTime converts a modification time in Unix seconds, where 0 means none given
+func unixModTime(secs int64) time.Time {
+	if secs == 0 {
+		return time.Time{}
+	}
+	return time.Unix(secs, 0)
+}
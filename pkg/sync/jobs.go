@@ -0,0 +1,225 @@
+package sync
+
+import (
+	"context"
+	"log"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/google/uuid"
+)
+
+// JobRetention is how long finished jobs can still be looked up
+const JobRetention = time.Hour
+
+// jobThreshold is the most items a delete, move or copy changes before it runs as a job
+var jobThreshold = 1000
+
+// JobStatus is the state of a job
+type JobStatus string
+
+const (
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// Job is a delete, move or copy of too many items to finish within a request, or another long operation
+// such as a reindex, continuing in the background.
+// Jobs are kept in memory and their progress is not persisted, as copies and moves cannot be replayed from a
+// count of items done: one interrupted by a restart is lost, and deleting the same path again finishes it.
+type Job struct {
+	ID         string          `json:"id"`
+	Operation  string          `json:"operation"` // delete, move, copy or reindex
+	Path       string          `json:"path"`
+	Status     JobStatus       `json:"status"`
+	Total      int             `json:"total"`            // items found when the job started, or as a reindex goes
+	Progress   int             `json:"progress"`         // items done so far, only counted by deletes and copies
+	Result     *MutationResult `json:"result,omitempty"` // once done
+	Error      string          `json:"error,omitempty"`  // once failed
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+
+	userID int
+	done   chan struct{} // closed when the job finishes
+}
+
+var (
+	jobsMu sync.Mutex
+	jobs   = make(map[string]*Job)
+)
+
+// runJob runs an operation on path right away when it changes at most jobThreshold items,
+// otherwise as a job. Exactly one of the result and the job is returned. The caller authorizes
+// the operation first, so that nothing is counted or started for a user who may not take it.
+func runJob(ctx context.Context, repo *model.Repository, operation, path string, userID int,
+	run func(ctx context.Context, result *MutationResult) error) (*MutationResult, *Job, error) {
+	total, err := db.CountFilesUnder(ctx, repo.ID, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if total <= jobThreshold {
+		result := &MutationResult{}
+		if err := run(ctx, result); err != nil {
+			return nil, nil, err
+		}
+		return result, nil, nil
+	}
+
+	job, snapshot := newJob(operation, path, userID, total)
+	result := &MutationResult{progress: func() {
+		jobsMu.Lock()
+		job.Progress++
+		jobsMu.Unlock()
+	}}
+	goJob(ctx, job, result, func(ctx context.Context) error {
+		return run(ctx, result)
+	})
+	return nil, snapshot, nil
+}
+
+// StartJob runs an operation other than a delete, move or copy as a job of a user. run reports how many
+// of how many items it did as it goes, counted as affected in the result of the job.
+func StartJob(ctx context.Context, operation, path string, userID int, run func(ctx context.Context, progress func(done, total int)) error) *Job {
+	job, snapshot := newJob(operation, path, userID, 0)
+	result := &MutationResult{}
+	goJob(ctx, job, result, func(ctx context.Context) error {
+		return run(ctx, func(done, total int) {
+			jobsMu.Lock()
+			job.Progress, job.Total, result.Affected = done, total, done
+			jobsMu.Unlock()
+		})
+	})
+	return snapshot
+}
+
+// newJob registers a running job, returning it and a snapshot of it to hand out
+func newJob(operation, path string, userID, total int) (*Job, *Job) {
+	job := &Job{
+		ID:        uuid.NewString(),
+		Operation: operation,
+		Path:      path,
+		Status:    JobRunning,
+		Total:     total,
+		StartedAt: time.Now(),
+		userID:    userID,
+		done:      make(chan struct{}),
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	pruneJobs(job.StartedAt)
+	jobs[job.ID] = job
+	snapshot := *job
+	return job, &snapshot
+}
+
+// goJob runs a job in the background, result becoming its result once run returns
+func goJob(ctx context.Context, job *Job, result *MutationResult, run func(ctx context.Context) error) {
+	go func() {
+		// The job outlives the request starting it
+		err := run(context.WithoutCancel(ctx))
+
+		jobsMu.Lock()
+		defer jobsMu.Unlock()
+		now := time.Now()
+		job.FinishedAt = &now
+		if err != nil {
+			log.Printf("Job %s to %s %s failed: %s", job.ID, job.Operation, job.Path, err)
+			job.Status, job.Error = JobFailed, err.Error()
+		} else {
+			job.Status = JobDone
+		}
+		result.progress = nil
+		job.Result = result // partial after a failure
+		close(job.done)
+	}()
+}
+
+// pruneJobs forgets jobs finished for longer than JobRetention. The caller holds jobsMu.
+func pruneJobs(now time.Time) {
+	for id, job := range jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > JobRetention {
+			delete(jobs, id)
+		}
+	}
+}
+
+// GetJob returns the state of a job started by a user, waiting up to wait for it to finish
+func GetJob(ctx context.Context, id string, userID int, wait time.Duration) (*Job, bool) {
+	jobsMu.Lock()
+	job, ok := jobs[id]
+	jobsMu.Unlock()
+	if !ok || job.userID != userID {
+		return nil, false
+	}
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-job.done:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	snapshot := *job
+	return &snapshot, true
+}
+
+// DeleteOrStartJob deletes path like Delete, as a job when it deletes more than the job threshold
+func (s *Service) DeleteOrStartJob(ctx context.Context, repo *model.Repository, path string, recursive bool, userID int) (*MutationResult, *Job, error) {
+	if !recursive {
+		result, err := s.Delete(ctx, repo, path, false, userID)
+		return result, nil, err
+	}
+	if err := authorize(ctx, repo, path, userID, perm.Delete); err != nil {
+		return nil, nil, err
+	}
+	return runJob(ctx, repo, "delete", path, userID, func(ctx context.Context, result *MutationResult) error {
+		return s.delete(ctx, repo, path, true, userID, result)
+	})
+}
+
+// MoveOrStartJob moves like Move, as a job when it moves more than the job threshold
+func (s *Service) MoveOrStartJob(ctx context.Context, repo *model.Repository, sourcePath, destPath string, userID int) (*MutationResult, *Job, error) {
+	if err := authorize(ctx, repo, sourcePath, userID, perm.Delete); err != nil {
+		return nil, nil, err
+	}
+	if err := authorize(ctx, repo, destPath, userID, perm.Write); err != nil {
+		return nil, nil, err
+	}
+	return runJob(ctx, repo, "move", sourcePath, userID, func(ctx context.Context, result *MutationResult) error {
+		moved, err := s.Move(ctx, repo, sourcePath, destPath, userID)
+		if err == nil {
+			*result = *moved
+		}
+		return err
+	})
+}
+
+// CopyOrStartJob copies like Copy, as a job when it copies more than the job threshold
+func (s *Service) CopyOrStartJob(ctx context.Context, repo *model.Repository, sourcePath, destPath string, autorename bool, userID int) (*MutationResult, *Job, error) {
+	if err := authorize(ctx, repo, sourcePath, userID, perm.Read); err != nil {
+		return nil, nil, err
+	}
+	// An autorenamed copy may land on another name in the same directory
+	target := destPath
+	if autorename {
+		target = path.Dir(destPath)
+	}
+	if err := authorize(ctx, repo, target, userID, perm.Write); err != nil {
+		return nil, nil, err
+	}
+	return runJob(ctx, repo, "copy", sourcePath, userID, func(ctx context.Context, result *MutationResult) error {
+		return s.copy(ctx, repo, sourcePath, destPath, autorename, userID, result)
+	})
+}
@@ -0,0 +1,98 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetJob(t *testing.T) {
+	job := &Job{ID: "job-1", Operation: "delete", Path: "/big", Status: JobRunning, userID: 7, done: make(chan struct{})}
+	jobsMu.Lock()
+	jobs[job.ID] = job
+	jobsMu.Unlock()
+	defer func() {
+		jobsMu.Lock()
+		delete(jobs, job.ID)
+		jobsMu.Unlock()
+	}()
+
+	_, ok := GetJob(context.Background(), "job-1", 8, 0)
+	assert.False(t, ok, "jobs are only shown to the user starting them")
+
+	got, ok := GetJob(context.Background(), "job-1", 7, 10*time.Millisecond)
+	assert.True(t, ok)
+	assert.Equal(t, JobRunning, got.Status)
+
+	go func() {
+		jobsMu.Lock()
+		now := time.Now()
+		job.Status, job.FinishedAt, job.Result = JobDone, &now, &MutationResult{Affected: 3}
+		jobsMu.Unlock()
+		close(job.done)
+	}()
+	got, ok = GetJob(context.Background(), "job-1", 7, time.Minute)
+	assert.True(t, ok)
+	assert.Equal(t, JobDone, got.Status)
+	assert.Equal(t, 3, got.Result.Affected)
+
+	jobsMu.Lock()
+	pruneJobs(time.Now().Add(JobRetention + time.Second))
+	jobsMu.Unlock()
+	_, ok = GetJob(context.Background(), "job-1", 7, 0)
+	assert.False(t, ok)
+}
+
+func TestStartJob(t *testing.T) {
+	ctx := context.Background()
+	proceed := make(chan struct{})
+	failed := errors.New("storage unavailable")
+
+	started := StartJob(ctx, "reindex", "/", 7, func(ctx context.Context, progress func(done, total int)) error {
+		progress(2, 5)
+		<-proceed
+		progress(3, 5)
+		return failed
+	})
+	assert.Equal(t, JobRunning, started.Status)
+	assert.Equal(t, "reindex", started.Operation)
+
+	assert.Eventually(t, func() bool {
+		job, _ := GetJob(ctx, started.ID, 7, 0)
+		return job.Progress == 2
+	}, time.Second, time.Millisecond)
+
+	close(proceed)
+	job, ok := GetJob(ctx, started.ID, 7, time.Minute)
+	require.True(t, ok)
+	assert.Equal(t, JobFailed, job.Status)
+	assert.Equal(t, failed.Error(), job.Error)
+	assert.Equal(t, 3, job.Progress)
+	assert.Equal(t, 5, job.Total)
+	assert.Equal(t, 3, job.Result.Affected, "partial after a failure")
+}
+
+func TestJobsAuthorizeFirst(t *testing.T) {
+	ctx := context.Background()
+	s := &Service{}
+	repo := &model.Repository{ID: 1, Name: "docs"}
+
+	// Without a database, anything past authorization would fail otherwise
+	_, job, err := s.DeleteOrStartJob(ctx, repo, "/big", true, 0)
+	assert.ErrorIs(t, err, perm.ErrDenied)
+	assert.Nil(t, job)
+
+	_, job, err = s.MoveOrStartJob(ctx, repo, "/big", "/moved", 0)
+	assert.ErrorIs(t, err, perm.ErrDenied)
+	assert.Nil(t, job)
+
+	_, job, err = s.CopyOrStartJob(ctx, repo, "/big", "/copy", true, 0)
+	assert.ErrorIs(t, err, perm.ErrDenied)
+	assert.Nil(t, job)
+}
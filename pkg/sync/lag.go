@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// MaxDeviceIDLength is the longest device ID a client may report, longer ones are ignored
+const MaxDeviceIDLength = 128
+
+var (
+	clientLagSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "filehub_sync_client_lag_seconds",
+		Help:    "How long the oldest change a sync client has not acknowledged has waited, by repository, when the client lists changes.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"repo"})
+
+	devicePendingChanges = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "filehub_sync_device_pending_changes",
+		Help: "Changes a device has not acknowledged, by repository and device, as of its last report.",
+	}, []string{"repo", "device"})
+
+	deviceLagSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "filehub_sync_device_lag_seconds",
+		Help: "How long the oldest change a device has not acknowledged has waited, by repository and device, as of its last report.",
+	}, []string{"repo", "device"})
+)
+
+// ClientLag is how far a device syncing a repository is behind its current version
+type ClientLag struct {
+	*model.SyncClient
+	RepoName       string  `json:"repo"`
+	PendingChanges int     `json:"pending_changes"`
+	LagSeconds     float64 `json:"lag_seconds"` // age of the oldest change not acknowledged, 0 when up to date
+}
+
+// clientLag returns the lag of a client from the changes it has not acknowledged
+func clientLag(client *model.SyncClient, repoName string, pending int, oldest, now time.Time) *ClientLag {
+	lag := &ClientLag{SyncClient: client, RepoName: repoName, PendingChanges: pending}
+	if pending > 0 && !oldest.IsZero() {
+		lag.LagSeconds = max(now.Sub(oldest), 0).Seconds()
+	}
+	return lag
+}
+
+// TrackClient records the version a device listing the changes of a repository since that version has,
+// and how far behind it is. Nothing is tracked for clients not naming their device. Failures are only
+// logged, as they must not keep the client from syncing.
+func TrackClient(ctx context.Context, repo *model.Repository, userID int, deviceID, version string) {
+	if deviceID == "" || len(deviceID) > MaxDeviceIDLength {
+		return
+	}
+	if version == "" {
+		version = model.ZeroVersion
+	}
+
+	client := &model.SyncClient{RepoID: repo.ID, UserID: userID, DeviceID: deviceID, AckedVersion: version}
+	if err := db.RecordSyncClient(ctx, client); err != nil {
+		log.Printf("Failed to track sync client %s of %s: %s", deviceID, repo.Name, err)
+		return
+	}
+
+	pending, oldest, err := db.PendingChanges(ctx, repo.ID, version)
+	if err != nil {
+		log.Printf("Failed to get lag of sync client %s of %s: %s", deviceID, repo.Name, err)
+		return
+	}
+	lag := clientLag(client, repo.Name, pending, oldest, time.Now())
+	clientLagSeconds.WithLabelValues(repo.Name).Observe(lag.LagSeconds)
+	setDeviceLag(lag)
+}
+
+func setDeviceLag(lag *ClientLag) {
+	devicePendingChanges.WithLabelValues(lag.RepoName, lag.DeviceID).Set(float64(lag.PendingChanges))
+	deviceLagSeconds.WithLabelValues(lag.RepoName, lag.DeviceID).Set(lag.LagSeconds)
+}
+
+// ClientLags returns how far behind each device syncing a repository is, or those of every repository
+// when repoID is 0, the furthest behind first. Devices that stopped reporting keep falling behind.
+func ClientLags(ctx context.Context, repoID int) ([]*ClientLag, error) {
+	clients, err := db.ListSyncClients(ctx, repoID)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := map[int]string{}
+	now := time.Now()
+	lags := make([]*ClientLag, 0, len(clients))
+	for _, client := range clients {
+		name, ok := repos[client.RepoID]
+		if !ok {
+			repo, err := db.GetRepositoryByID(ctx, client.RepoID)
+			if err != nil {
+				return nil, err
+			}
+			name, repos[client.RepoID] = repo.Name, repo.Name
+		}
+
+		pending, oldest, err := db.PendingChanges(ctx, client.RepoID, client.AckedVersion)
+		if err != nil {
+			return nil, err
+		}
+		lag := clientLag(client, name, pending, oldest, now)
+		setDeviceLag(lag)
+		lags = append(lags, lag)
+	}
+
+	slices.SortStableFunc(lags, func(a, b *ClientLag) int {
+		return cmp.Or(cmp.Compare(b.LagSeconds, a.LagSeconds), cmp.Compare(b.PendingChanges, a.PendingChanges))
+	})
+	return lags, nil
+}
@@ -0,0 +1,32 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestClientLag(t *testing.T) {
+	now := time.Now()
+	client := &model.SyncClient{DeviceID: "laptop", AckedVersion: "v10-0"}
+
+	lag := clientLag(client, "alice", 3, now.Add(-time.Minute), now)
+	assert.Equal(t, 3, lag.PendingChanges)
+	assert.Equal(t, 60.0, lag.LagSeconds)
+	assert.Equal(t, "laptop", lag.DeviceID)
+
+	// Up to date clients have no lag, nor do changes stamped ahead of the clock
+	assert.Zero(t, clientLag(client, "alice", 0, time.Time{}, now).LagSeconds)
+	assert.Zero(t, clientLag(client, "alice", 1, now.Add(time.Second), now).LagSeconds)
+}
+
+func TestDeviceIDFromContext(t *testing.T) {
+	assert.Empty(t, deviceIDFromContext(context.Background()))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(DeviceIDMetadata, "phone"))
+	assert.Equal(t, "phone", deviceIDFromContext(ctx))
+}
@@ -0,0 +1,16 @@
+package sync
+
+import "google.golang.org/grpc"
+
+// legacyServiceName is the name of the service before the protocol was versioned as filehub.sync.v1.
+// Only the package name changed, which messages do not carry on the wire, so clients still calling
+// the old method paths are served by the same handlers.
+const legacyServiceName = "filehub.sync.SyncService"
+
+// RegisterLegacySyncServiceServer registers srv under the unversioned service name as well, for clients
+// built before the rename. It is kept until the v1 paths have been released for a deprecation period.
+func RegisterLegacySyncServiceServer(s grpc.ServiceRegistrar, srv SyncServiceServer) {
+	desc := SyncService_ServiceDesc
+	desc.ServiceName = legacyServiceName
+	s.RegisterService(&desc, srv)
+}
@@ -0,0 +1,175 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/cgang/file-hub/pkg/bandwidth"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/users"
+)
+
+// Ways of uploading a file that Preflight recommends
+const (
+	UploadSimple  = "simple"  // one request to /upload
+	UploadChunked = "chunked" // an upload session, /upload/begin
+	UploadInstant = "instant" // a copy of a file of the repository holding the same content, nothing to send
+	UploadNone    = "none"    // the file already holds the content
+)
+
+var (
+	// ErrQuotaExceeded is returned for an upload that does not fit in the quota of the repository owner
+	ErrQuotaExceeded = errors.New("upload exceeds the storage quota")
+	// ErrIsDirectory is returned for an upload to the path of a directory
+	ErrIsDirectory = errors.New("a directory exists at the path")
+)
+
+// PreflightResult tells a client, before it sends any content, whether an upload can succeed and how to
+// send it
+type PreflightResult struct {
+	Allowed        bool    `json:"allowed"`
+	Mode           string  `json:"mode,omitempty"`          // set when allowed
+	Source         string  `json:"source,omitempty"`        // the file to copy for an instant upload
+	ChunkSize      int64   `json:"chunk_size,omitempty"`    // for a chunked upload
+	MaxFileSize    int64   `json:"max_file_size,omitempty"` // 0 for no limit
+	AvailableBytes int64   `json:"available_bytes"`         // left in the quota of the repository owner
+	Problems       []error `json:"-"`                       // why the upload would fail, all of them
+}
+
+// Preflight checks everything an upload of size bytes to path would be refused for, without sending the
+// content: the name, permissions, immutability, size limits, the storage quota of the repository owner,
+// the transfer cap of the user and the parent directory. A non-empty checksum is looked up in the
+// repository, so that content the server already holds is copied rather than uploaded. Only failures to
+// look things up are returned as errors; reasons to refuse the upload are listed in Problems.
+func (s *Service) Preflight(ctx context.Context, repo *model.Repository, filePath string, size int64, checksum string, userID int) (*PreflightResult, error) {
+	filePath = path.Join("/", filePath)
+	result := &PreflightResult{MaxFileSize: stor.MaxFileSize()}
+	refuse := func(err error) {
+		result.Problems = append(result.Problems, err)
+	}
+
+	if filePath == "/" {
+		refuse(fmt.Errorf("%w: %q", ErrInvalidName, filePath))
+	} else if err := checkName(path.Base(filePath)); err != nil {
+		refuse(err)
+	}
+	if err := stor.CheckFileSize(size); err != nil {
+		refuse(err)
+	}
+	if err := authorize(ctx, repo, filePath, userID, perm.Write); err != nil {
+		if !errors.Is(err, perm.ErrDenied) && !errors.Is(err, perm.ErrViewOnly) && !errors.Is(err, perm.ErrReadOnly) {
+			return nil, err
+		}
+		refuse(err)
+	}
+
+	existing, err := db.GetFile(ctx, repo.ID, filePath)
+	if err != nil && !stor.IsNotFound(err) {
+		return nil, err
+	}
+	if existing != nil && existing.IsDir {
+		refuse(fmt.Errorf("%w: %s", ErrIsDirectory, filePath))
+	} else if existing != nil {
+		if err := stor.CheckMutable(ctx, &model.Resource{Repo: repo, Path: filePath}); err != nil {
+			if !errors.Is(err, stor.ErrImmutable) {
+				return nil, err
+			}
+			refuse(err)
+		}
+	}
+
+	if !createParents {
+		if _, err := stor.ResolveParent(ctx, repo, filePath, nil); errors.Is(err, stor.ErrParentNotFound) {
+			refuse(err)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	_, available, err := users.Usage(ctx, repo.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	result.AvailableBytes = available
+	if needed := size - replacedSize(existing); needed > available {
+		refuse(fmt.Errorf("%w: %d bytes needed, %d available", ErrQuotaExceeded, needed, available))
+	}
+
+	source := ""
+	if checksum != "" && !hasContent(existing, checksum) {
+		if source, err = findContent(ctx, repo, checksum, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	// The transfer cap only matters when content is sent
+	mode := uploadMode(size, checksum, existing, source)
+	if mode == UploadSimple || mode == UploadChunked {
+		if err := bandwidth.Check(ctx, userID); errors.Is(err, bandwidth.ErrCapExceeded) {
+			refuse(err)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(result.Problems) > 0 {
+		return result, nil
+	}
+
+	result.Allowed, result.Mode = true, mode
+	switch mode {
+	case UploadInstant:
+		result.Source = source
+	case UploadChunked:
+		result.ChunkSize = NegotiateChunkSize(0)
+	}
+	return result, nil
+}
+
+// uploadMode returns how to upload size bytes with a checksum over existing, nil if there is no file yet,
+// when source holds the same content, empty if none does
+func uploadMode(size int64, checksum string, existing *model.FileObject, source string) string {
+	switch {
+	case checksum != "" && hasContent(existing, checksum):
+		return UploadNone
+	case source != "":
+		return UploadInstant
+	case size <= MaxSimpleUploadSize:
+		return UploadSimple
+	default:
+		return UploadChunked
+	}
+}
+
+// hasContent reports whether a file is stored with the given checksum
+func hasContent(file *model.FileObject, checksum string) bool {
+	return file != nil && !file.IsDir && file.Checksum != nil && *file.Checksum == checksum
+}
+
+// replacedSize returns the bytes freed by replacing a file, none when there is no file
+func replacedSize(file *model.FileObject) int64 {
+	if file == nil || file.IsDir {
+		return 0
+	}
+	return file.Size
+}
+
+// findContent returns the path of a file of the repository with the given checksum that the user may read,
+// empty if there is none
+func findContent(ctx context.Context, repo *model.Repository, checksum string, userID int) (string, error) {
+	files, err := db.GetFilesByChecksum(ctx, []int{repo.ID}, []string{checksum})
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		if err := authorize(ctx, repo, file.Path, userID, perm.Read); err == nil {
+			return file.Path, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,37 @@
+package sync
+
+import (
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadMode(t *testing.T) {
+	checksum := "abc"
+	other := "def"
+	file := &model.FileObject{Path: "/a.txt", Size: 5, Checksum: &checksum}
+
+	assert.Equal(t, UploadNone, uploadMode(5, checksum, file, ""))
+	assert.Equal(t, UploadInstant, uploadMode(5, other, file, "/b.txt"))
+	assert.Equal(t, UploadSimple, uploadMode(MaxSimpleUploadSize, other, file, ""))
+	assert.Equal(t, UploadChunked, uploadMode(MaxSimpleUploadSize+1, "", nil, ""))
+
+	// Without a checksum the content of the existing file cannot be compared
+	assert.Equal(t, UploadSimple, uploadMode(5, "", file, ""))
+	// Nor can a directory hold content
+	assert.Equal(t, UploadSimple, uploadMode(5, checksum, &model.FileObject{IsDir: true, Checksum: &checksum}, ""))
+}
+
+func TestReplacedSize(t *testing.T) {
+	assert.Equal(t, int64(0), replacedSize(nil))
+	assert.Equal(t, int64(0), replacedSize(&model.FileObject{IsDir: true, Size: 4096}))
+	assert.Equal(t, int64(7), replacedSize(&model.FileObject{Size: 7}))
+}
+
+func TestCheckName(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "a/b", "a\\b", "a\x00b"} {
+		assert.ErrorIs(t, checkName(name), ErrInvalidName, name)
+	}
+	assert.NoError(t, checkName("report (1).txt"))
+}
@@ -0,0 +1,124 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/stor"
+)
+
+// PresignDownload returns a request fetching a file from its storage backend directly, or nil when the
+// file matches ifNoneMatch. stor.ErrPresignUnsupported is returned for files served by the server only.
+func (s *Service) PresignDownload(ctx context.Context, repo *model.Repository, path string, ifNoneMatch string, userID int) (*model.FileObject, *stor.PresignedRequest, error) {
+	if err := authorize(ctx, repo, path, userID, perm.Read); err != nil {
+		return nil, nil, err
+	}
+
+	resource := &model.Resource{Repo: repo, Path: path}
+	file, err := stor.GetFileInfo(ctx, resource)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ifNoneMatch != "" && file.Checksum != nil && *file.Checksum == ifNoneMatch {
+		return file, nil, nil
+	}
+
+	req, err := stor.PresignFile(ctx, resource, http.MethodGet)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, req, nil
+}
+
+// PresignUpload returns a request storing the content of a file in its storage backend directly. The file
+// only shows up once the upload is committed with CommitPresignedUpload.
+func (s *Service) PresignUpload(ctx context.Context, repo *model.Repository, path string, userID int) (*stor.PresignedRequest, error) {
+	if err := authorize(ctx, repo, path, userID, perm.Write); err != nil {
+		return nil, err
+	}
+
+	resource := &model.Resource{Repo: repo, Path: path}
+	if err := stor.CheckMutable(ctx, resource); err != nil {
+		return nil, err
+	}
+	return stor.PresignFile(ctx, resource, http.MethodPut)
+}
+
+// CommitPresignedUpload records a file stored through a presigned request, returning it with the new
+// repository version. The server never saw the content, so its start is read back to detect the content
+// type, and the file is left for the checksum backfill to hash.
+func (s *Service) CommitPresignedUpload(ctx context.Context, repo *model.Repository, path string, modTime time.Time, userID int) (*model.FileObject, string, error) {
+	modTime, err := CheckModTime(modTime, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := authorize(ctx, repo, path, userID, perm.Write); err != nil {
+		return nil, "", err
+	}
+
+	resource := &model.Resource{Repo: repo, Path: path}
+	parent, err := ResolveParent(ctx, repo, path, createParents, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	meta, err := stor.StatPresigned(ctx, resource)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, "", fmt.Errorf("%w: nothing was stored at %s", ErrUploadIncomplete, path)
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	if modTime.IsZero() {
+		modTime = meta.ModTime
+	}
+	fileObj := &model.FileObject{
+		RepoID:   repo.ID,
+		OwnerID:  userID,
+		ParentID: parent.ID,
+		Path:     path,
+		Name:     filepath.Base(path),
+		IsDir:    false,
+		Size:     meta.Size,
+		ModTime:  storedModTime(modTime),
+	}
+	// Detect the content type once here, so that listings need not ask the backend for it
+	if mimeType, err := stor.DetectContentType(ctx, repo, fileObj); err == nil {
+		fileObj.MimeType = &mimeType
+	} else {
+		log.Printf("Failed to detect the content type of %s/%s: %s", repo.Name, path, err)
+	}
+	if err := db.UpsertFile(ctx, fileObj); err != nil {
+		return nil, "", fmt.Errorf("failed to update database: %w", err)
+	}
+
+	version := generateVersion()
+	change := &model.ChangeLog{
+		RepoID:    repo.ID,
+		Operation: "create",
+		Path:      path,
+		UserID:    userID,
+		Version:   version,
+	}
+
+	if err := recordChange(ctx, change); err != nil {
+		return nil, "", fmt.Errorf("failed to record change: %w", err)
+	}
+
+	if err := db.UpdateVersion(ctx, repo.ID, version, "{}"); err != nil {
+		return nil, "", fmt.Errorf("failed to update repository version: %w", err)
+	}
+
+	return fileObj, version, nil
+}
@@ -1,30 +1,151 @@
 package sync
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log"
+	"math"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/cgang/file-hub/pkg/classify"
+	"github.com/cgang/file-hub/pkg/config"
 	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/hooks"
 	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/search"
 	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/watch"
 	"github.com/google/uuid"
 	"github.com/uptrace/bun"
 )
 
 const (
 	MaxSimpleUploadSize = 10 * 1024 * 1024 // 10MB
-	ChunkSize           = 1024 * 1024      // 1MB chunks
+	ChunkSize           = 1024 * 1024      // 1MB chunks, unless the client asks for another size
 	MaxConnectionTime   = 24 * time.Hour
 	ChunkTempDir        = "chunks"
 )
 
+// createParents makes uploads create the missing parent directories of a file instead of failing
+var createParents = true
+
+var (
+	sessionLifetime    = MaxConnectionTime  // lifetime of a new upload session, and the extension of a keepalive
+	maxSessionLifetime = 7 * 24 * time.Hour // age past which keepalives no longer extend a session
+	idleTimeout        = time.Hour          // sessions idle for longer expire early, 0 to disable
+	retryGrace         = 24 * time.Hour     // how long a session that failed to be stored is kept for a retry
+
+	minChunkSize int64 = 256 * 1024       // smallest chunk size a client may ask for
+	maxChunkSize int64 = 64 * 1024 * 1024 // largest chunk size a client may ask for
+
+	segmentSize int64 = 8 * 1024 * 1024 // size of the ranges clients should fetch in parallel downloads
+	maxSegments       = 4               // most ranges clients should fetch at once
+
+	defaultOrder model.ListOrder // order of listings whose client did not ask for one
+)
+
+// ErrUploadExpired is returned when an upload session expired or was idle for too long
+var ErrUploadExpired = errors.New("upload session has expired")
+
+// ErrInvalidChunk is returned for a chunk outside the upload or not of the negotiated size
+var ErrInvalidChunk = errors.New("invalid chunk")
+
+// ErrUploadIncomplete is returned when finalizing an upload whose chunks did not all arrive
+var ErrUploadIncomplete = errors.New("upload incomplete")
+
+// Init applies the sync settings of the configuration
+func Init(cfg *config.Config) {
+	createParents = cfg.Sync.CreateParents
+
+	if cfg.Sync.SessionLifetime > 0 {
+		sessionLifetime = cfg.Sync.SessionLifetime
+	}
+	maxSessionLifetime = max(cfg.Sync.MaxSessionLifetime, sessionLifetime)
+	idleTimeout = max(cfg.Sync.IdleTimeout, 0)
+
+	if cfg.Sync.MinChunkSize > 0 {
+		minChunkSize = cfg.Sync.MinChunkSize
+	}
+	maxChunkSize = max(cfg.Sync.MaxChunkSize, minChunkSize)
+
+	if cfg.Sync.SegmentSize > 0 {
+		segmentSize = cfg.Sync.SegmentSize
+	}
+	if cfg.Sync.MaxSegments > 0 {
+		maxSegments = cfg.Sync.MaxSegments
+	}
+	if cfg.Sync.JobThreshold > 0 {
+		jobThreshold = cfg.Sync.JobThreshold
+	}
+	if cfg.Sync.RetryGrace > 0 {
+		retryGrace = cfg.Sync.RetryGrace
+	}
+	initTransient(cfg.Sync.TransientPatterns, cfg.Sync.TransientWindow)
+
+	defaultOrder = model.ListOrder{DirsFirst: cfg.Sync.ListDirsFirst}
+	if model.ValidSort(cfg.Sync.ListSort) {
+		defaultOrder.Sort = cfg.Sync.ListSort
+	} else if cfg.Sync.ListSort != "" {
+		log.Printf("Ignoring invalid default sort order %q", cfg.Sync.ListSort)
+	}
+}
+
+// MaxChunkSize returns the largest chunk size a client may ask for
+func MaxChunkSize() int64 {
+	return maxChunkSize
+}
+
+// Capabilities tells clients what the sync API supports and the sizes they should use
+type Capabilities struct {
+	MaxSimpleUploadSize int64 `json:"max_simple_upload_size"`
+	ChunkSize           int64 `json:"chunk_size"` // used when a chunked upload asks for no size
+	MinChunkSize        int64 `json:"min_chunk_size"`
+	MaxChunkSize        int64 `json:"max_chunk_size"`
+	RangeDownloads      bool  `json:"range_downloads"` // downloads serve Range requests
+	SegmentSize         int64 `json:"segment_size"`    // recommended size of the ranges of a parallel download
+	MaxSegments         int   `json:"max_segments"`    // recommended most ranges fetched at once
+}
+
+// GetCapabilities returns the capabilities of the sync API
+func GetCapabilities() *Capabilities {
+	return &Capabilities{
+		MaxSimpleUploadSize: MaxSimpleUploadSize,
+		ChunkSize:           ChunkSize,
+		MinChunkSize:        minChunkSize,
+		MaxChunkSize:        maxChunkSize,
+		RangeDownloads:      true,
+		SegmentSize:         segmentSize,
+		MaxSegments:         maxSegments,
+	}
+}
+
+// NegotiateChunkSize returns the chunk size of an upload for the size a client asked for,
+// brought within the configured bounds. Clients that don't ask get ChunkSize.
+func NegotiateChunkSize(requested int64) int64 {
+	if requested <= 0 {
+		requested = ChunkSize
+	}
+	return min(max(requested, minChunkSize), maxChunkSize)
+}
+
+// IdleTimeout returns how long an upload session may go without a chunk or keepalive, 0 if unlimited
+func IdleTimeout() time.Duration {
+	return idleTimeout
+}
+
 type Service struct {
 	db        *bun.DB
 	chunkTempDir string
@@ -44,6 +165,15 @@ func NewService(database *bun.DB) *Service {
 	}
 }
 
+// authorize checks that a user may take an action on a path. No user, such as for an unauthenticated
+// request, is never granted anything.
+func authorize(ctx context.Context, repo *model.Repository, path string, userID int, action perm.Action) error {
+	if userID == 0 {
+		return perm.ErrDenied
+	}
+	return perm.Evaluate(ctx, perm.User(userID), &model.Resource{Repo: repo, Path: path}, action)
+}
+
 // getChunkTempPath returns the temporary file path for a chunk
 func (s *Service) getChunkTempPath(uploadID string, chunkIndex int) string {
 	if s.chunkTempDir == "" {
@@ -57,6 +187,200 @@ func generateVersion() string {
 	return fmt.Sprintf("v%d-%d", now.Unix(), now.Nanosecond())
 }
 
+// recordChange logs a change and hands it to those following the repository, unless it is one of
+// a transient file held back or dropped
+func recordChange(ctx context.Context, change *model.ChangeLog) error {
+	// Changes held back are logged later, outside of the request that made them
+	if change.OperationID == "" {
+		change.OperationID = db.OperationID(ctx)
+	}
+	if holdTransient(change) {
+		return nil
+	}
+	return logChange(ctx, change)
+}
+
+// logChange writes a change to the change log and tells those following changes
+func logChange(ctx context.Context, change *model.ChangeLog) error {
+	if err := db.RecordChange(ctx, change); err != nil {
+		return err
+	}
+	hooks.Fire(change)
+	watch.Fire(change)
+	if change.Operation != "delete" {
+		search.Wake()
+		classify.Wake()
+	}
+	return nil
+}
+
+// RecordChange logs a change made outside the sync service and bumps the repository version,
+// so that clients pick it up on their next sync
+func RecordChange(ctx context.Context, repoID int, operation, path string, userID int) error {
+	version := generateVersion()
+	change := &model.ChangeLog{
+		RepoID:    repoID,
+		Operation: operation,
+		Path:      path,
+		UserID:    userID,
+		Version:   version,
+	}
+
+	if err := recordChange(ctx, change); err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+
+	if err := db.UpdateVersion(ctx, repoID, version, "{}"); err != nil {
+		return fmt.Errorf("failed to update repository version: %w", err)
+	}
+
+	return nil
+}
+
+// RecordMove logs a move made outside the sync service and bumps the repository version
+func RecordMove(ctx context.Context, repoID int, oldPath, path string, userID int) error {
+	version := generateVersion()
+	change := &model.ChangeLog{
+		RepoID:    repoID,
+		Operation: "move",
+		Path:      path,
+		OldPath:   &oldPath,
+		UserID:    userID,
+		Version:   version,
+	}
+
+	if err := recordChange(ctx, change); err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+
+	if err := db.UpdateVersion(ctx, repoID, version, "{}"); err != nil {
+		return fmt.Errorf("failed to update repository version: %w", err)
+	}
+
+	return nil
+}
+
+// ResolveParent returns the directory a file of the repository belongs in. Missing directories are created
+// if create is set, with a change recorded for each so that clients see them appear.
+func ResolveParent(ctx context.Context, repo *model.Repository, filePath string, create bool, userID int) (*model.FileObject, error) {
+	if !create {
+		return stor.ResolveParent(ctx, repo, filePath, nil)
+	}
+
+	var created []*model.FileObject
+	parent, err := stor.ResolveParent(ctx, repo, filePath, collectDirs(&created))
+	// Directories created before a failure stay, so they are recorded all the same
+	if err := recordCreated(ctx, repo.ID, created, userID); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parent, nil
+}
+
+// collectDirs returns a function appending each directory it is called with to dirs
+func collectDirs(dirs *[]*model.FileObject) func(dir *model.FileObject) {
+	return func(dir *model.FileObject) {
+		*dirs = append(*dirs, dir)
+	}
+}
+
+// recordCreated logs the creation of directories made as parents of another item and bumps the repository version
+func recordCreated(ctx context.Context, repoID int, dirs []*model.FileObject, userID int) error {
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	var version string
+	for _, dir := range dirs {
+		version = generateVersion()
+		change := &model.ChangeLog{
+			RepoID:    repoID,
+			Operation: "create",
+			Path:      dir.Path,
+			UserID:    userID,
+			Version:   version,
+		}
+		if err := recordChange(ctx, change); err != nil {
+			return fmt.Errorf("failed to record change: %w", err)
+		}
+	}
+
+	if err := db.UpdateVersion(ctx, repoID, version, "{}"); err != nil {
+		return fmt.Errorf("failed to update repository version: %w", err)
+	}
+	return nil
+}
+
+// ChecksumMismatchError is returned when uploaded content does not match the checksum sent by the client
+type ChecksumMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected %s, computed %s", e.Expected, e.Actual)
+}
+
+// verifyChecksum compares a client supplied SHA-256 with the computed one, skipping the check when none was supplied
+func verifyChecksum(expected, actual string) error {
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	if expected == "" || expected == actual {
+		return nil
+	}
+	return &ChecksumMismatchError{Expected: expected, Actual: actual}
+}
+
+// ModTimeHeader carries the modification time of an upload in Unix seconds, as WebDAV sync clients send it.
+// Downloads return the stored time in it.
+const ModTimeHeader = "X-OC-Mtime"
+
+// MaxClockSkew is how far ahead of the server a client clock may run; later modification times are rejected
+const MaxClockSkew = 10 * time.Minute
+
+// ErrInvalidModTime is returned for a client modification time that is malformed or out of range
+var ErrInvalidModTime = errors.New("invalid modification time")
+
+// ParseModTime parses a modification time in Unix seconds, with an optional fraction, and checks it.
+// An empty value gives the zero time, which stands for the server time.
+func ParseModTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	secs, err := strconv.ParseFloat(value, 64)
+	if err != nil || secs <= 0 || secs > float64(now.Add(MaxClockSkew).Unix()+1) {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidModTime, value)
+	}
+
+	whole, frac := math.Modf(secs)
+	return CheckModTime(time.Unix(int64(whole), int64(frac*1e9)), now)
+}
+
+// CheckModTime validates a client modification time. Times slightly ahead of now, within MaxClockSkew,
+// come from a client clock running fast and are taken as now. The zero time passes unchanged.
+func CheckModTime(modTime, now time.Time) (time.Time, error) {
+	switch {
+	case modTime.IsZero():
+		return modTime, nil
+	case modTime.Unix() <= 0 || modTime.After(now.Add(MaxClockSkew)):
+		return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidModTime, modTime.UTC().Format(time.RFC3339))
+	case modTime.After(now):
+		return now, nil
+	}
+	return modTime, nil
+}
+
+// storedModTime returns the modification time to store for an upload, the server time unless the client gave one
+func storedModTime(modTime time.Time) time.Time {
+	if modTime.IsZero() {
+		return time.Now()
+	}
+	return modTime
+}
+
 func calculateSHA256(data []byte) string {
 	hash := sha256.Sum256(data)
 	return hex.EncodeToString(hash[:])
@@ -74,11 +398,100 @@ func (s *Service) GetCurrentVersion(ctx context.Context, repoID int) (*model.Rep
 	return db.GetCurrentVersion(ctx, repoID)
 }
 
-func (s *Service) ListChanges(ctx context.Context, repoID int, sinceVersion string, maxChanges int) ([]*model.ChangeLog, error) {
+var (
+	// ErrInvalidCursor is returned for a change cursor not issued by ListChanges
+	ErrInvalidCursor = errors.New("invalid change cursor")
+	// ErrInvalidFilter is returned for a change filter naming an unknown operation
+	ErrInvalidFilter = errors.New("invalid change filter")
+)
+
+// ChangePage is a page of the change log, in the order changes were recorded
+type ChangePage struct {
+	Changes    []*model.ChangeLog
+	NextCursor string // resumes after the last change of the page, empty if the page and cursor are empty
+	HasMore    bool   // more changes follow the page
+}
+
+// ListChanges returns the changes of a repository matching filter recorded after cursor, or after sinceVersion
+// when starting without one. Pages are ordered by the change sequence, which never changes, so following
+// NextCursor visits every change exactly once even while new ones are recorded.
+func (s *Service) ListChanges(ctx context.Context, repoID int, sinceVersion, cursor string, filter model.ChangeFilter, maxChanges int) (*ChangePage, error) {
 	if maxChanges <= 0 || maxChanges > 1000 {
 		maxChanges = 100
 	}
-	return db.GetChangesSince(ctx, repoID, sinceVersion, maxChanges)
+	for _, op := range filter.Operations {
+		if !slices.Contains(hooks.Operations, op) {
+			return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidFilter, op)
+		}
+	}
+	if filter.PathPrefix != "" {
+		filter.PathPrefix = path.Clean("/" + filter.PathPrefix)
+	}
+
+	// One more change than asked tells whether another page follows
+	var changes []*model.ChangeLog
+	var err error
+	if cursor != "" {
+		afterID, perr := strconv.Atoi(cursor)
+		if perr != nil || afterID < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, cursor)
+		}
+		changes, err = db.ListChangesAfter(ctx, repoID, afterID, filter, maxChanges+1)
+	} else {
+		changes, err = db.GetChangesSince(ctx, repoID, sinceVersion, filter, maxChanges+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ChangePage{Changes: changes, NextCursor: cursor}
+	if len(changes) > maxChanges {
+		page.Changes, page.HasMore = changes[:maxChanges], true
+	}
+	if n := len(page.Changes); n > 0 {
+		page.NextCursor = strconv.Itoa(page.Changes[n-1].ID)
+	}
+	return page, nil
+}
+
+// ExpandUser asks for the author of each change along with a change listing
+const ExpandUser = "user"
+
+// Expands reports whether the expansions asked for, each possibly a comma separated list, include what
+func Expands(expand []string, what string) bool {
+	for _, values := range expand {
+		for value := range strings.SplitSeq(values, ",") {
+			if strings.TrimSpace(value) == what {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FillAuthors sets who made each change, looking all of them up at once.
+// Changes by users since deleted are left without one.
+func (s *Service) FillAuthors(ctx context.Context, changes []*model.ChangeLog) error {
+	var ids []int
+	for _, change := range changes {
+		if !slices.Contains(ids, change.UserID) {
+			ids = append(ids, change.UserID)
+		}
+	}
+
+	users, err := db.GetUsersByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	authors := make(map[int]*model.ChangeAuthor, len(users))
+	for _, user := range users {
+		authors[user.ID] = model.NewChangeAuthor(user)
+	}
+	for _, change := range changes {
+		change.User = authors[change.UserID]
+	}
+	return nil
 }
 
 func (s *Service) GetFileInfo(ctx context.Context, repo *model.Repository, path string, userID int) (*model.FileObject, error) {
@@ -89,13 +502,84 @@ func (s *Service) GetFileInfo(ctx context.Context, repo *model.Repository, path
 	return stor.GetFileInfo(ctx, resource)
 }
 
-func (s *Service) ListDirectory(ctx context.Context, repo *model.Repository, path string, offset, limit int, userID int) ([]*model.FileObject, int64, error) {
+// GetFileInfos returns the files at many paths of a repository by path, looked up at once.
+// Paths without a file are left out.
+func (s *Service) GetFileInfos(ctx context.Context, repo *model.Repository, paths []string, userID int) (map[string]*model.FileObject, error) {
+	return stor.GetFileInfos(ctx, repo, paths)
+}
+
+// MaxStatPaths caps the paths one Stat call looks up
+const MaxStatPaths = 1000
+
+// StatEntry is the state of one path looked up by Stat
+type StatEntry struct {
+	Path    string     `json:"path"`
+	Exists  bool       `json:"exists"`
+	IsDir   bool       `json:"is_dir,omitempty"`
+	Etag    string     `json:"etag,omitempty"`
+	Size    int64      `json:"size,omitempty"`
+	ModTime *time.Time `json:"mod_time,omitempty"`
+}
+
+// Stat looks up many paths of a repository at once, returning one entry per path in the same order
+func (s *Service) Stat(ctx context.Context, repo *model.Repository, paths []string, userID int) ([]*StatEntry, error) {
+	if len(paths) > MaxStatPaths {
+		return nil, fmt.Errorf("at most %d paths can be looked up at once", MaxStatPaths)
+	}
+
+	found, err := stor.GetFileInfos(ctx, repo, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*StatEntry, len(paths))
+	for i, path := range paths {
+		entries[i] = newStatEntry(path, found[path])
+	}
+	return entries, nil
+}
+
+func newStatEntry(path string, file *model.FileObject) *StatEntry {
+	entry := &StatEntry{Path: path}
+	if file == nil {
+		return entry
+	}
+
+	entry.Exists = true
+	entry.IsDir = file.IsDir
+	entry.Size = file.Size
+	entry.ModTime = &file.ModTime
+	if file.Checksum != nil {
+		entry.Etag = *file.Checksum
+	}
+	return entry
+}
+
+// ErrInvalidSort is returned for a listing in an order that does not exist
+var ErrInvalidSort = errors.New("invalid sort order")
+
+// ParseListOrder returns the order a client asked a listing in, the server default where it did not say
+func ParseListOrder(sort string, dirsFirst *bool) (model.ListOrder, error) {
+	order := defaultOrder
+	if sort != "" {
+		if !model.ValidSort(sort) {
+			return order, fmt.Errorf("%w: %s", ErrInvalidSort, sort)
+		}
+		order.Sort = sort
+	}
+	if dirsFirst != nil {
+		order.DirsFirst = *dirsFirst
+	}
+	return order, nil
+}
+
+func (s *Service) ListDirectory(ctx context.Context, repo *model.Repository, path string, order model.ListOrder, offset, limit int, userID int) ([]*model.FileObject, int64, error) {
 	parent, err := db.GetFile(ctx, repo.ID, path)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	files, err := db.GetChildFiles(ctx, parent.ID)
+	files, err := db.ListChildFiles(ctx, parent.ID, order)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -114,55 +598,233 @@ func (s *Service) ListDirectory(ctx context.Context, repo *model.Repository, pat
 	return result, total, nil
 }
 
-func (s *Service) CreateDirectory(ctx context.Context, repo *model.Repository, path string, userID int) error {
+// ListDirectoryStream calls visit for each entry of a directory without loading the whole listing.
+// A zero limit streams every entry after offset.
+func (s *Service) ListDirectoryStream(ctx context.Context, repo *model.Repository, path string, order model.ListOrder, offset, limit int, userID int, visit func(*model.FileObject) error) error {
+	parent, err := db.GetFile(ctx, repo.ID, path)
+	if err != nil {
+		return err
+	}
+
+	return db.StreamChildFiles(ctx, parent.ID, order, offset, limit, visit)
+}
+
+const (
+	// MaxListBatchPaths caps the directories one ListBatch call lists
+	MaxListBatchPaths = 100
+	// MaxListBatchEntries caps the entries one ListBatch call returns over all directories
+	MaxListBatchEntries = 10000
+)
+
+// DirListing is one directory listed by ListBatch
+type DirListing struct {
+	Path        string              `json:"path"`
+	Exists      bool                `json:"exists"`                 // whether the path is an existing directory
+	Etag        string              `json:"etag,omitempty"`         // changes whenever an entry is added, removed or changed
+	NotModified bool                `json:"not_modified,omitempty"` // the client knows the etag already, items are left out
+	Omitted     bool                `json:"omitted,omitempty"`      // did not fit into the entry limit, list it again
+	Items       []*model.FileObject `json:"items,omitempty"`
+}
+
+// ListBatch lists many directories of a repository at once, returning one listing per path in the same order.
+// Directories whose etag is given in known are only listed if changed since. Once the entries returned
+// would exceed MaxListBatchEntries, that directory and the ones after it are omitted.
+func (s *Service) ListBatch(ctx context.Context, repo *model.Repository, paths []string, known map[string]string, order model.ListOrder, userID int) ([]*DirListing, error) {
+	if len(paths) > MaxListBatchPaths {
+		return nil, fmt.Errorf("at most %d directories can be listed at once", MaxListBatchPaths)
+	}
+
+	dirs, err := db.GetFilesByPaths(ctx, repo.ID, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]*model.FileObject, len(dirs))
+	for _, dir := range dirs {
+		found[dir.Path] = dir
+	}
+
+	listings := make([]*DirListing, len(paths))
+	remaining := MaxListBatchEntries
+	for i, path := range paths {
+		listing := &DirListing{Path: path}
+		listings[i] = listing
+
+		dir := found[path]
+		if dir == nil || !dir.IsDir {
+			continue
+		}
+		listing.Exists = true
+		if remaining < 0 {
+			listing.Omitted = true
+			continue
+		}
+
+		// One entry more than what is left tells that the directory does not fit
+		var items []*model.FileObject
+		err := db.StreamChildFiles(ctx, dir.ID, order, 0, remaining+1, func(file *model.FileObject) error {
+			items = append(items, file)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(items) > remaining {
+			listing.Omitted = true
+			remaining = -1
+			continue
+		}
+
+		listing.Etag = directoryEtag(items)
+		if etag, ok := known[path]; ok && etag == listing.Etag {
+			listing.NotModified = true
+			continue
+		}
+		listing.Items = items
+		remaining -= len(items)
+	}
+	return listings, nil
+}
+
+// directoryEtag derives the etag of a directory from the name, kind, size, modification time and
+// content of its entries, taken in name order whatever the order of the listing
+func directoryEtag(items []*model.FileObject) string {
+	sorted := slices.SortedFunc(slices.Values(items), func(a, b *model.FileObject) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	hash := sha256.New()
+	for _, item := range sorted {
+		checksum := ""
+		if item.Checksum != nil {
+			checksum = *item.Checksum
+		}
+		fmt.Fprintf(hash, "%s\x00%t\x00%d\x00%d\x00%s\n", item.Name, item.IsDir, item.Size, item.ModTime.UnixNano(), checksum)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// CreateDirectory creates a directory, and its missing parents if parents is set, and returns it
+func (s *Service) CreateDirectory(ctx context.Context, repo *model.Repository, path string, parents bool, userID int) (*model.FileObject, error) {
+	if err := authorize(ctx, repo, path, userID, perm.Write); err != nil {
+		return nil, err
+	}
+
 	resource := &model.Resource{
 		Repo: repo,
 		Path: path,
 	}
 
-	if err := stor.CreateDir(ctx, resource); err != nil {
-		return err
+	var created []*model.FileObject
+	var parentCreated func(dir *model.FileObject)
+	if parents {
+		parentCreated = collectDirs(&created)
+	}
+	dir, err := stor.CreateDir(ctx, resource, parentCreated)
+	if err := recordCreated(ctx, repo.ID, created, userID); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	version := generateVersion()
 	change := &model.ChangeLog{
 		RepoID:    repo.ID,
 		Operation: "create",
-		Path:      path,
+		Path:      dir.Path,
 		UserID:    userID,
 		Version:   version,
 	}
 
-	if err := db.RecordChange(ctx, change); err != nil {
-		return fmt.Errorf("failed to record change: %w", err)
+	if err := recordChange(ctx, change); err != nil {
+		return nil, fmt.Errorf("failed to record change: %w", err)
 	}
 
 	if err := db.UpdateVersion(ctx, repo.ID, version, "{}"); err != nil {
-		return fmt.Errorf("failed to update repository version: %w", err)
+		return nil, fmt.Errorf("failed to update repository version: %w", err)
 	}
 
-	return nil
+	return dir, nil
+}
+
+// MaxRemovedPaths caps the paths a recursive delete reports, and the failures a recursive copy reports;
+// Affected still counts all of them
+const MaxRemovedPaths = 1000
+
+// MutationResult describes what a delete, move or copy changed,
+// so that clients can update their local state without listing again
+type MutationResult struct {
+	Affected int               `json:"affected"`          // files and directories changed
+	Version  string            `json:"version"`           // repository version after the change
+	Removed  []string          `json:"removed,omitempty"` // paths removed by a delete, children first
+	Target   *model.FileObject `json:"target,omitempty"`  // resulting object of a move or copy
+	Failed   []*FailedItem     `json:"failed,omitempty"`  // items of a directory copy left out, the rest being copied
+
+	progress func() // called for each item removed or copied, by jobs reporting progress
+	version  string // recorded for each item removed instead of a new one, by bundle imports
+}
+
+// FailedItem is an item a directory copy could not copy
+type FailedItem struct {
+	Path  string `json:"path"` // of the original
+	Error string `json:"error"`
 }
 
-func (s *Service) Delete(ctx context.Context, repo *model.Repository, path string, recursive bool, userID int) error {
+func (r *MutationResult) removed(path, version string) {
+	if r.progress != nil {
+		r.progress()
+	}
+	r.Affected++
+	r.Version = version
+	if len(r.Removed) < MaxRemovedPaths {
+		r.Removed = append(r.Removed, path)
+	}
+}
+
+// copied counts an item of a directory copy, done or failed
+func (r *MutationResult) copied(path string, err error) {
+	if r.progress != nil {
+		r.progress()
+	}
+	if err == nil {
+		r.Affected++
+	} else if len(r.Failed) < MaxRemovedPaths {
+		r.Failed = append(r.Failed, &FailedItem{Path: path, Error: err.Error()})
+	}
+}
+
+func (s *Service) Delete(ctx context.Context, repo *model.Repository, path string, recursive bool, userID int) (*MutationResult, error) {
+	result := &MutationResult{}
+	if err := s.delete(ctx, repo, path, recursive, userID, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *Service) delete(ctx context.Context, repo *model.Repository, path string, recursive bool, userID int, result *MutationResult) error {
+	if err := authorize(ctx, repo, path, userID, perm.Delete); err != nil {
+		return err
+	}
+
 	file, err := db.GetFile(ctx, repo.ID, path)
 	if err != nil {
 		return err
 	}
 
-	if file.IsDir && recursive {
-		children, err := db.GetChildFiles(ctx, file.ID)
-		if err != nil {
-			return err
-		}
-
-		for _, child := range children {
-			if deleteErr := s.Delete(ctx, repo, child.Path, true, userID); deleteErr != nil {
-				return deleteErr
-			}
-		}
+	if !file.IsDir || !recursive {
+		return s.deleteOne(ctx, repo, path, userID, result)
+	}
+	if err := stor.CheckTreeMutable(ctx, &model.Resource{Repo: repo, Path: file.Path}); err != nil {
+		return err
 	}
+	return stor.WalkTree(ctx, file, true, func(item *model.FileObject) error {
+		return s.deleteOne(ctx, repo, item.Path, userID, result)
+	})
+}
 
+// deleteOne deletes a file or an empty directory and records the change
+func (s *Service) deleteOne(ctx context.Context, repo *model.Repository, path string, userID int, result *MutationResult) error {
 	resource := &model.Resource{
 		Repo: repo,
 		Path: path,
@@ -172,7 +834,10 @@ func (s *Service) Delete(ctx context.Context, repo *model.Repository, path strin
 		return err
 	}
 
-	version := generateVersion()
+	version := result.version
+	if version == "" {
+		version = generateVersion()
+	}
 	change := &model.ChangeLog{
 		RepoID:    repo.ID,
 		Operation: "delete",
@@ -181,18 +846,28 @@ func (s *Service) Delete(ctx context.Context, repo *model.Repository, path strin
 		Version:   version,
 	}
 
-	if err := db.RecordChange(ctx, change); err != nil {
+	if err := recordChange(ctx, change); err != nil {
 		return fmt.Errorf("failed to record change: %w", err)
 	}
 
-	if err := db.UpdateVersion(ctx, repo.ID, version, "{}"); err != nil {
-		return fmt.Errorf("failed to update repository version: %w", err)
+	if result.version == "" {
+		if err := db.UpdateVersion(ctx, repo.ID, version, "{}"); err != nil {
+			return fmt.Errorf("failed to update repository version: %w", err)
+		}
 	}
 
+	result.removed(path, version)
 	return nil
 }
 
-func (s *Service) Move(ctx context.Context, repo *model.Repository, sourcePath, destPath string, userID int) error {
+func (s *Service) Move(ctx context.Context, repo *model.Repository, sourcePath, destPath string, userID int) (*MutationResult, error) {
+	if err := authorize(ctx, repo, sourcePath, userID, perm.Delete); err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, repo, destPath, userID, perm.Write); err != nil {
+		return nil, err
+	}
+
 	srcResource := &model.Resource{
 		Repo: repo,
 		Path: sourcePath,
@@ -204,7 +879,7 @@ func (s *Service) Move(ctx context.Context, repo *model.Repository, sourcePath,
 	}
 
 	if err := stor.MoveFile(ctx, srcResource, destResource); err != nil {
-		return err
+		return nil, err
 	}
 
 	version := generateVersion()
@@ -217,18 +892,107 @@ func (s *Service) Move(ctx context.Context, repo *model.Repository, sourcePath,
 		Version:   version,
 	}
 
-	if err := db.RecordChange(ctx, change); err != nil {
-		return fmt.Errorf("failed to record change: %w", err)
+	if err := recordChange(ctx, change); err != nil {
+		return nil, fmt.Errorf("failed to record change: %w", err)
 	}
 
 	if err := db.UpdateVersion(ctx, repo.ID, version, "{}"); err != nil {
-		return fmt.Errorf("failed to update repository version: %w", err)
+		return nil, fmt.Errorf("failed to update repository version: %w", err)
 	}
 
+	return s.targetResult(ctx, repo, destPath, version)
+}
+
+// ErrInvalidName is returned renaming a file to a name that is empty, reserved or holds a path separator
+var ErrInvalidName = errors.New("invalid file name")
+
+// checkName returns ErrInvalidName for a file name that is empty, reserved or holds a path separator
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
 	return nil
 }
 
-func (s *Service) Copy(ctx context.Context, repo *model.Repository, sourcePath, destPath string, userID int) error {
+// renameTarget returns the path of the file at p given the new name, in the same directory
+func renameTarget(p, name string) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+	return path.Join(path.Dir(p), name), nil
+}
+
+// Rename gives the file at filePath a new name in the same directory, keeping its checksum, content type
+// and modification time, and records a rename. It fails with stor.ErrRenameConflict if the name is taken,
+// as the database decides; the lock keeps autorenamed writes of this server from picking the name meanwhile.
+func (s *Service) Rename(ctx context.Context, repo *model.Repository, filePath, name string, userID int) (*MutationResult, error) {
+	destPath, err := renameTarget(filePath, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, repo, filePath, userID, perm.Delete); err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, repo, destPath, userID, perm.Write); err != nil {
+		return nil, err
+	}
+
+	lock := renameLock(repo.ID, destPath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := stor.RenameFile(ctx, &model.Resource{Repo: repo, Path: filePath}, destPath); err != nil {
+		return nil, err
+	}
+
+	version := generateVersion()
+	change := &model.ChangeLog{
+		RepoID:    repo.ID,
+		Operation: "rename",
+		Path:      destPath,
+		OldPath:   &filePath,
+		UserID:    userID,
+		Version:   version,
+	}
+
+	if err := recordChange(ctx, change); err != nil {
+		return nil, fmt.Errorf("failed to record change: %w", err)
+	}
+
+	if err := db.UpdateVersion(ctx, repo.ID, version, "{}"); err != nil {
+		return nil, fmt.Errorf("failed to update repository version: %w", err)
+	}
+
+	return s.targetResult(ctx, repo, destPath, version)
+}
+
+// Copy copies sourcePath to destPath, a directory with everything below it. Items of a directory that fail
+// to copy are listed in the result while the others are copied. With autorename, anything existing at destPath
+// is kept and the copy made under the first free numbered name instead, returned as the target.
+func (s *Service) Copy(ctx context.Context, repo *model.Repository, sourcePath, destPath string, autorename bool, userID int) (*MutationResult, error) {
+	result := &MutationResult{}
+	if err := s.copy(ctx, repo, sourcePath, destPath, autorename, userID, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *Service) copy(ctx context.Context, repo *model.Repository, sourcePath, destPath string, autorename bool, userID int, result *MutationResult) error {
+	if err := authorize(ctx, repo, sourcePath, userID, perm.Read); err != nil {
+		return err
+	}
+	if autorename {
+		free, unlock, err := lockFreePath(ctx, repo, destPath, userID)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		destPath = free
+	}
+	if err := authorize(ctx, repo, destPath, userID, perm.Write); err != nil {
+		return err
+	}
+
 	srcResource := &model.Resource{
 		Repo: repo,
 		Path: sourcePath,
@@ -239,20 +1003,37 @@ func (s *Service) Copy(ctx context.Context, repo *model.Repository, sourcePath,
 		Path: destPath,
 	}
 
-	if err := stor.CopyFile(ctx, srcResource, destResource); err != nil {
+	source, err := db.GetFile(ctx, repo.ID, sourcePath)
+	if err != nil && !stor.IsNotFound(err) {
+		return err
+	}
+
+	if source != nil && source.IsDir {
+		_, err = stor.CopyTree(ctx, srcResource, destResource, func(item *model.FileObject, err error) {
+			if err != nil {
+				log.Printf("Failed to copy %s to %s: %s", item.Path, destPath, err)
+			}
+			result.copied(item.Path, err)
+		})
+	} else {
+		err = stor.CopyFile(ctx, srcResource, destResource)
+	}
+	if err != nil {
 		return err
 	}
+	result.Affected++
 
 	version := generateVersion()
 	change := &model.ChangeLog{
 		RepoID:    repo.ID,
 		Operation: "copy",
 		Path:      destPath,
+		OldPath:   &sourcePath,
 		UserID:    userID,
 		Version:   version,
 	}
 
-	if err := db.RecordChange(ctx, change); err != nil {
+	if err := recordChange(ctx, change); err != nil {
 		return fmt.Errorf("failed to record change: %w", err)
 	}
 
@@ -260,46 +1041,98 @@ func (s *Service) Copy(ctx context.Context, repo *model.Repository, sourcePath,
 		return fmt.Errorf("failed to update repository version: %w", err)
 	}
 
+	target, err := stor.GetFileInfo(ctx, destResource)
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %w", destPath, err)
+	}
+	result.Version, result.Target = version, target
 	return nil
 }
 
-func (s *Service) UploadFile(ctx context.Context, repo *model.Repository, path string, data []byte, mimeType string, userID int) (string, string, int64, error) {
-	if int64(len(data)) > MaxSimpleUploadSize {
-		return "", "", 0, fmt.Errorf("file too large for simple upload, use chunked upload")
+// targetResult returns the result of a move or rename to destPath, counting everything now at and below it
+func (s *Service) targetResult(ctx context.Context, repo *model.Repository, destPath, version string) (*MutationResult, error) {
+	target, err := stor.GetFileInfo(ctx, &model.Resource{Repo: repo, Path: destPath})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", destPath, err)
 	}
+	affected := 1
+	if target.IsDir {
+		if affected, err = db.CountFilesUnder(ctx, repo.ID, destPath); err != nil {
+			return nil, err
+		}
+	}
+	return &MutationResult{Affected: affected, Version: version, Target: target}, nil
+}
 
-	checksum := calculateSHA256(data)
+// UploadFile stores a small file in one request, streaming data to storage, and returns the path it was stored
+// at with its checksum, the new repository version and its size. Content over MaxSimpleUploadSize fails with
+// stor.ErrTooLarge, and a non-empty expectedChecksum is compared with its SHA-256, before the backend keeps it.
+// With autorename, a file existing at path is kept and the upload stored under the first free numbered name
+// instead, such as "report (1).txt".
+func (s *Service) UploadFile(ctx context.Context, repo *model.Repository, path string, data io.Reader, mimeType string, expectedChecksum string, modTime time.Time, autorename bool, userID int) (string, string, string, int64, error) {
+	modTime, err := CheckModTime(modTime, time.Now())
+	if err != nil {
+		return "", "", "", 0, err
+	}
+
+	if autorename {
+		free, unlock, err := lockFreePath(ctx, repo, path, userID)
+		if err != nil {
+			return "", "", "", 0, err
+		}
+		defer unlock()
+		path = free
+	}
+
+	if err := authorize(ctx, repo, path, userID, perm.Write); err != nil {
+		return "", "", "", 0, err
+	}
 
 	resource := &model.Resource{
 		Repo: repo,
 		Path: path,
 	}
 
-	// Write file content to storage
-	if err := stor.PutFile(ctx, resource, io.NopCloser(bytes.NewReader(data))); err != nil {
-		return "", "", 0, fmt.Errorf("failed to store file: %w", err)
+	parent, err := ResolveParent(ctx, repo, path, createParents, userID)
+	if err != nil {
+		return "", "", "", 0, err
 	}
 
-	// Get file info after storing
-	fileInfo, err := stor.GetFileInfo(ctx, resource)
+	// Write file content to storage
+	meta, err := stor.PutStream(ctx, resource, data, MaxSimpleUploadSize, func(checksum string) error {
+		return verifyChecksum(expectedChecksum, checksum)
+	})
 	if err != nil {
-		return "", "", 0, fmt.Errorf("failed to get file info: %w", err)
+		return "", "", "", 0, fmt.Errorf("failed to store file: %w", err)
+	}
+	checksum := meta.Checksum
+	// Clients often send no type or a generic one, the content tells better
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = meta.ContentType
 	}
 
 	// Update database with file metadata
 	fileObj := &model.FileObject{
 		RepoID:    repo.ID,
+		OwnerID:   userID,
+		ParentID:  parent.ID,
 		Path:      path,
 		Name:      filepath.Base(path),
 		IsDir:     false,
-		Size:      fileInfo.Size,
-		ModTime:   time.Now(),
+		Size:      meta.Size,
+		ModTime:   storedModTime(modTime),
 		Checksum:  &checksum,
 		MimeType:  &mimeType,
 	}
 
 	if err := db.UpsertFile(ctx, fileObj); err != nil {
-		return "", "", 0, fmt.Errorf("failed to update database: %w", err)
+		return "", "", "", 0, fmt.Errorf("failed to update database: %w", err)
+	}
+
+	if !modTime.IsZero() {
+		if err := stor.SetModTime(ctx, resource, modTime); err != nil {
+			return "", "", "", 0, fmt.Errorf("failed to set modification time: %w", err)
+		}
 	}
 
 	version := generateVersion()
@@ -311,23 +1144,42 @@ func (s *Service) UploadFile(ctx context.Context, repo *model.Repository, path s
 		Version:   version,
 	}
 
-	if err := db.RecordChange(ctx, change); err != nil {
-		return "", "", 0, fmt.Errorf("failed to record change: %w", err)
+	if err := recordChange(ctx, change); err != nil {
+		return "", "", "", 0, fmt.Errorf("failed to record change: %w", err)
 	}
 
 	if err := db.UpdateVersion(ctx, repo.ID, version, "{}"); err != nil {
-		return "", "", 0, fmt.Errorf("failed to update repository version: %w", err)
+		return "", "", "", 0, fmt.Errorf("failed to update repository version: %w", err)
 	}
 
-	return checksum, version, fileInfo.Size, nil
+	return path, checksum, version, meta.Size, nil
 }
 
 func (s *Service) DownloadFile(ctx context.Context, repo *model.Repository, path string, ifNoneMatch string, userID int) (*model.FileObject, io.ReadCloser, error) {
+	return s.download(ctx, repo, path, ifNoneMatch, userID, stor.OpenFile)
+}
+
+// DownloadFileRange is DownloadFile for length bytes of the file from offset on, or the rest of it when
+// length is not positive, such as for a resumed download. The reader cannot seek.
+func (s *Service) DownloadFileRange(ctx context.Context, repo *model.Repository, path string, ifNoneMatch string, offset, length int64, userID int) (*model.FileObject, io.ReadCloser, error) {
+	if length <= 0 {
+		length = -1
+	}
+	return s.download(ctx, repo, path, ifNoneMatch, userID, func(ctx context.Context, resource *model.Resource) (io.ReadCloser, error) {
+		return stor.OpenFileRange(ctx, resource, offset, length)
+	})
+}
+
+func (s *Service) download(ctx context.Context, repo *model.Repository, path string, ifNoneMatch string, userID int, open func(context.Context, *model.Resource) (io.ReadCloser, error)) (*model.FileObject, io.ReadCloser, error) {
 	resource := &model.Resource{
 		Repo: repo,
 		Path: path,
 	}
 
+	if err := authorize(ctx, repo, path, userID, perm.Read); err != nil {
+		return nil, nil, err
+	}
+
 	file, err := stor.GetFileInfo(ctx, resource)
 	if err != nil {
 		return nil, nil, err
@@ -337,7 +1189,7 @@ func (s *Service) DownloadFile(ctx context.Context, repo *model.Repository, path
 		return nil, nil, nil
 	}
 
-	reader, err := stor.OpenFile(ctx, resource)
+	reader, err := open(ctx, resource)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -345,9 +1197,27 @@ func (s *Service) DownloadFile(ctx context.Context, repo *model.Repository, path
 	return file, reader, nil
 }
 
-func (s *Service) BeginUpload(ctx context.Context, repo *model.Repository, path string, totalSize int64, userID int) (string, []int, error) {
+// BeginUpload starts a chunked upload, returning the new session and the chunks already uploaded.
+// The session's chunk size is the requested one within the configured bounds, see NegotiateChunkSize.
+func (s *Service) BeginUpload(ctx context.Context, repo *model.Repository, path string, totalSize, chunkSize int64, modTime time.Time, userID int) (*model.UploadSession, []int, error) {
+	if err := stor.CheckFileSize(totalSize); err != nil {
+		return nil, nil, err
+	}
+
+	// The file itself is checked once stored, when the name it is stored under is known
+	if err := authorize(ctx, repo, filepath.Dir(path), userID, perm.Write); err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	modTime, err := CheckModTime(modTime, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	uploadID := uuid.New().String()
-	totalChunks := int((totalSize + ChunkSize - 1) / ChunkSize)
+	chunkSize = NegotiateChunkSize(chunkSize)
+	totalChunks := int((totalSize + chunkSize - 1) / chunkSize)
 
 	session := &model.UploadSession{
 		UploadID:       uploadID,
@@ -357,35 +1227,122 @@ func (s *Service) BeginUpload(ctx context.Context, repo *model.Repository, path
 		UserID:         userID,
 		ChunksUploaded: 0,
 		TotalChunks:    totalChunks,
-		ExpiresAt:      time.Now().Add(MaxConnectionTime),
-		Status:         "active",
+		ChunkSize:      chunkSize,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(sessionLifetime),
+		LastActivityAt: now,
+		Status:         model.UploadActive,
+	}
+	if !modTime.IsZero() {
+		session.ModTime = &modTime
 	}
 
 	if err := db.CreateUploadSession(ctx, session); err != nil {
-		return "", nil, fmt.Errorf("failed to create upload session: %w", err)
+		return nil, nil, fmt.Errorf("failed to create upload session: %w", err)
 	}
 
-	return uploadID, []int{}, nil
+	return session, []int{}, nil
 }
 
-func (s *Service) UploadChunk(ctx context.Context, uploadID string, chunkIndex int, data []byte) error {
+// activeSession loads an upload session that can still receive chunks and be finalized:
+// an active one, or one waiting for a retry after it failed to be stored
+func activeSession(ctx context.Context, uploadID string, now time.Time) (*model.UploadSession, error) {
 	session, err := db.GetUploadSession(ctx, uploadID)
 	if err != nil {
-		return fmt.Errorf("upload session not found: %w", err)
+		return nil, fmt.Errorf("upload session not found: %w", err)
+	}
+
+	if session.Status != model.UploadActive && session.Status != model.UploadFailed {
+		return nil, fmt.Errorf("upload session is not active")
+	}
+
+	if now.After(session.Deadline(idleTimeout)) {
+		return nil, ErrUploadExpired
+	}
+	return session, nil
+}
+
+// extendedExpiry returns the expiry of a session after a keepalive at now, never earlier than the current one
+// nor past the maximum session age
+func extendedExpiry(session *model.UploadSession, now time.Time) time.Time {
+	expiresAt := now.Add(sessionLifetime)
+	if limit := session.CreatedAt.Add(maxSessionLifetime); expiresAt.After(limit) {
+		expiresAt = limit
+	}
+	if expiresAt.Before(session.ExpiresAt) {
+		return session.ExpiresAt
+	}
+	return expiresAt
+}
+
+// KeepAlive extends an upload session that is still in use, returning when it now expires
+// unless another chunk or keepalive arrives first
+func (s *Service) KeepAlive(ctx context.Context, uploadID string, userID int) (time.Time, error) {
+	now := time.Now()
+	session, err := activeSession(ctx, uploadID, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if userID != 0 && session.UserID != userID {
+		return time.Time{}, fmt.Errorf("upload session not found: %w", sql.ErrNoRows)
+	}
+
+	session.ExpiresAt = extendedExpiry(session, now)
+	session.LastActivityAt = now
+	if err := db.ExtendUploadSession(ctx, uploadID, session.ExpiresAt, now); err != nil {
+		return time.Time{}, err
 	}
+	return session.Deadline(idleTimeout), nil
+}
 
-	if session.Status != "active" {
-		return fmt.Errorf("upload session is not active")
+// CleanupExpiredUploads removes the upload sessions that expired or went idle before now, and their chunks
+func CleanupExpiredUploads(ctx context.Context, now time.Time) error {
+	var idleSince time.Time
+	if idleTimeout > 0 {
+		idleSince = now.Add(-idleTimeout)
 	}
 
-	if time.Now().After(session.ExpiresAt) {
-		return fmt.Errorf("upload session has expired")
+	uploadIDs, err := db.CleanupExpiredUploadSessions(ctx, now, idleSince)
+	if err != nil {
+		return err
+	}
+
+	tempDir := filepath.Join(os.TempDir(), ChunkTempDir)
+	for _, uploadID := range uploadIDs {
+		chunks, _ := filepath.Glob(filepath.Join(tempDir, uploadID+"_*"))
+		for _, chunk := range chunks {
+			os.Remove(chunk)
+		}
+	}
+	return nil
+}
+
+// checkChunk verifies that a chunk belongs to the upload and has the negotiated size
+func checkChunk(session *model.UploadSession, chunkIndex int, size int64) error {
+	expected := session.ChunkLength(chunkIndex)
+	if expected < 0 {
+		return fmt.Errorf("%w: index %d outside the %d chunks of the upload", ErrInvalidChunk, chunkIndex, session.TotalChunks)
+	}
+	if size != expected {
+		return fmt.Errorf("%w: chunk %d has %d bytes, expected %d", ErrInvalidChunk, chunkIndex, size, expected)
+	}
+	return nil
+}
+
+func (s *Service) UploadChunk(ctx context.Context, uploadID string, chunkIndex int, data []byte) error {
+	session, err := activeSession(ctx, uploadID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if err := checkChunk(session, chunkIndex, int64(len(data))); err != nil {
+		return err
 	}
 
 	// Store chunk data temporarily
 	chunkPath := s.getChunkTempPath(uploadID, chunkIndex)
 	if chunkPath != "" {
-		if err := os.WriteFile(chunkPath, data, 0644); err != nil {
+		if err := writeChunk(chunkPath, data); err != nil {
 			return fmt.Errorf("failed to store chunk data: %w", err)
 		}
 	}
@@ -394,12 +1351,12 @@ func (s *Service) UploadChunk(ctx context.Context, uploadID string, chunkIndex i
 	chunk := &model.UploadChunk{
 		UploadID:   uploadID,
 		ChunkIndex: chunkIndex,
-		Offset:     int64(chunkIndex) * ChunkSize,
+		Offset:     int64(chunkIndex) * session.ChunkSize,
 		Size:       int64(len(data)),
 		Checksum:   &checksum,
 	}
 
-	if err := db.CreateUploadChunk(ctx, chunk); err != nil {
+	if err := db.UpsertUploadChunk(ctx, chunk); err != nil {
 		// Clean up stored chunk on error
 		if chunkPath != "" {
 			os.Remove(chunkPath)
@@ -407,71 +1364,273 @@ func (s *Service) UploadChunk(ctx context.Context, uploadID string, chunkIndex i
 		return fmt.Errorf("failed to store chunk: %w", err)
 	}
 
-	if err := db.IncrementUploadedChunks(ctx, uploadID); err != nil {
+	if err := db.UpdateUploadedChunks(ctx, uploadID); err != nil {
 		return fmt.Errorf("failed to update session: %w", err)
 	}
 
 	return nil
 }
 
-func (s *Service) FinalizeUpload(ctx context.Context, uploadID string, repo *model.Repository, userID int) (string, int64, error) {
-	session, err := db.GetUploadSession(ctx, uploadID)
+// writeChunk stores the data of a chunk at path. The data is written aside and renamed into place,
+// so that a chunk sent twice at once never leaves a mix of both behind.
+func writeChunk(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
 	if err != nil {
-		return "", 0, fmt.Errorf("upload session not found: %w", err)
+		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	chunks, err := db.GetUploadedChunks(ctx, uploadID)
-	if err != nil {
-		return "", 0, fmt.Errorf("failed to get uploaded chunks: %w", err)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ChunkProblem is a chunk of an upload that has to be sent again
+type ChunkProblem struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"` // missing, size, offset or data
+}
+
+// IncompleteUploadError is returned when finalizing an upload whose chunks are missing, don't fit together
+// or don't hold the data received for them. It lists the chunks to upload again.
+type IncompleteUploadError struct {
+	Chunks       []ChunkProblem
+	Size         int64 // bytes held by the chunks that are fine
+	ExpectedSize int64
+}
+
+func (e *IncompleteUploadError) Error() string {
+	indices := make([]int, len(e.Chunks))
+	for i, chunk := range e.Chunks {
+		indices[i] = chunk.Index
 	}
+	return fmt.Sprintf("upload incomplete: %d of %d bytes received, chunks %v need to be uploaded again", e.Size, e.ExpectedSize, indices)
+}
 
-	if len(chunks) != session.TotalChunks {
-		return "", 0, fmt.Errorf("not all chunks uploaded: %d/%d", len(chunks), session.TotalChunks)
+func (e *IncompleteUploadError) Unwrap() error {
+	return ErrUploadIncomplete
+}
+
+// assembleChunks returns a reader joining the chunks of an upload in order, read by index one at a time, so
+// that the upload is never held in memory whole. It checks the recorded chunks rather than the session's count:
+// every chunk must be present at the offset where the previous one ends, with the negotiated size, and its
+// stored data must match the checksum taken when it arrived. All chunks failing these checks are reported
+// together in an IncompleteUploadError before anything is read.
+func assembleChunks(session *model.UploadSession, chunks []*model.UploadChunk, read func(index int) ([]byte, error)) (io.Reader, error) {
+	recorded := make(map[int]*model.UploadChunk, len(chunks))
+	for _, chunk := range chunks {
+		recorded[chunk.ChunkIndex] = chunk
 	}
 
-	// Verify all chunks are present and assemble file
-	var assembledData bytes.Buffer
+	failed := &IncompleteUploadError{ExpectedSize: session.TotalSize}
+	var offset int64
 	for i := 0; i < session.TotalChunks; i++ {
-		chunkPath := s.getChunkTempPath(uploadID, i)
-		if chunkPath != "" {
-			data, err := os.ReadFile(chunkPath)
-			if err != nil {
-				return "", 0, fmt.Errorf("failed to read chunk %d: %w", i, err)
+		length := session.ChunkLength(i)
+		chunk, ok := recorded[i]
+		switch {
+		case !ok:
+			failed.Chunks = append(failed.Chunks, ChunkProblem{Index: i, Reason: "missing"})
+		case chunk.Size != length:
+			failed.Chunks = append(failed.Chunks, ChunkProblem{Index: i, Reason: "size"})
+		case chunk.Offset != offset:
+			failed.Chunks = append(failed.Chunks, ChunkProblem{Index: i, Reason: "offset"})
+		default:
+			data, err := read(i)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("failed to read chunk %d: %w", i, err)
+			}
+			if err != nil || int64(len(data)) != length || (chunk.Checksum != nil && calculateSHA256(data) != *chunk.Checksum) {
+				failed.Chunks = append(failed.Chunks, ChunkProblem{Index: i, Reason: "data"})
+				break
 			}
-			assembledData.Write(data)
-		} else {
-			// Chunk not found in temp storage
-			return "", 0, fmt.Errorf("chunk %d not found", i)
+			failed.Size += length
+		}
+		offset += length
+	}
+
+	if len(failed.Chunks) > 0 {
+		return nil, failed
+	}
+	if failed.Size != session.TotalSize {
+		return nil, fmt.Errorf("%w: chunks hold %d bytes, expected %d", ErrUploadIncomplete, failed.Size, session.TotalSize)
+	}
+	return &chunkReader{count: session.TotalChunks, read: read}, nil
+}
+
+// chunkReader reads the chunks of an upload in order, loading the next one once the previous is used up
+type chunkReader struct {
+	count int
+	next  int
+	buf   []byte
+	read  func(index int) ([]byte, error)
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.next == r.count {
+			return 0, io.EOF
 		}
+		data, err := r.read(r.next)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read chunk %d: %w", r.next, err)
+		}
+		r.buf = data
+		r.next++
 	}
 
-	// Calculate final checksum
-	finalData := assembledData.Bytes()
-	checksum := calculateSHA256(finalData)
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// FinalizeFailedError is returned when a complete upload could not be stored for reasons on the server's side.
+// Its chunks are kept until RetryUntil, so that finalizing it again may succeed.
+type FinalizeFailedError struct {
+	RetryUntil time.Time
+	Err        error
+}
+
+func (e *FinalizeFailedError) Error() string {
+	return fmt.Sprintf("failed to store upload, retry until %s: %s", e.RetryUntil.Format(time.RFC3339), e.Err)
+}
+
+func (e *FinalizeFailedError) Unwrap() error {
+	return e.Err
+}
+
+// storeUpload streams the assembled content of an upload to storage and records the file with its checksum.
+// A non-empty expectedChecksum is compared with it before the backend keeps the content.
+func storeUpload(ctx context.Context, resource *model.Resource, file *model.FileObject, data io.Reader, expectedChecksum string, modTime time.Time) error {
+	meta, err := stor.PutStream(ctx, resource, data, 0, func(checksum string) error {
+		return verifyChecksum(expectedChecksum, checksum)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store assembled file: %w", err)
+	}
+	file.Checksum = &meta.Checksum
+
+	if err := db.UpsertFile(ctx, file); err != nil {
+		return fmt.Errorf("failed to update database: %w", err)
+	}
+
+	if !modTime.IsZero() {
+		if err := stor.SetModTime(ctx, resource, modTime); err != nil {
+			return fmt.Errorf("failed to set modification time: %w", err)
+		}
+	}
+	return nil
+}
+
+// failUpload undoes what storeUpload got to do for a new file, so that no file without its record or
+// content shows up, and marks the session failed, keeping its chunks for a retry
+func failUpload(ctx context.Context, session *model.UploadSession, resource *model.Resource, existed bool, cause error) error {
+	log.Printf("Failed to store upload %s to %s: %s", session.UploadID, resource, cause)
+
+	if !existed {
+		if err := stor.DeleteFile(ctx, resource); err != nil && !stor.IsNotFound(err) {
+			log.Printf("Failed to remove partly stored upload %s: %s", resource, err)
+		}
+	}
+
+	retryUntil := time.Now().Add(retryGrace)
+	if retryUntil.Before(session.ExpiresAt) {
+		retryUntil = session.ExpiresAt
+	}
+	if err := db.FailUploadSession(ctx, session.UploadID, retryUntil); err != nil {
+		log.Printf("Failed to mark upload %s failed: %s", session.UploadID, err)
+	}
+	return &FinalizeFailedError{RetryUntil: retryUntil, Err: cause}
+}
+
+// FinalizeUpload assembles the chunks of an upload and stores the file, returning the path it was stored at
+// with its checksum and size. A non-empty expectedChecksum is compared with the SHA-256 of the assembled file
+// before it is stored; on mismatch the chunks are kept so that the client can retry or cancel the upload. With
+// autorename, a file existing at the path of the upload is kept and the upload stored under the first free
+// numbered name instead.
+func (s *Service) FinalizeUpload(ctx context.Context, uploadID string, repo *model.Repository, expectedChecksum string, modTime time.Time, autorename bool, userID int) (string, string, int64, error) {
+	session, err := activeSession(ctx, uploadID, time.Now())
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	// A time given at finalization replaces the one given when the upload began
+	if modTime, err = CheckModTime(modTime, time.Now()); err != nil {
+		return "", "", 0, err
+	}
+	if modTime.IsZero() && session.ModTime != nil {
+		modTime = *session.ModTime
+	}
+
+	chunks, err := db.GetUploadedChunks(ctx, uploadID)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to get uploaded chunks: %w", err)
+	}
+
+	content, err := assembleChunks(session, chunks, func(index int) ([]byte, error) {
+		chunkPath := s.getChunkTempPath(uploadID, index)
+		if chunkPath == "" {
+			return nil, errors.New("no chunk storage configured")
+		}
+		return os.ReadFile(chunkPath)
+	})
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	target := session.Path
+	if autorename {
+		free, unlock, err := lockFreePath(ctx, repo, target, userID)
+		if err != nil {
+			return "", "", 0, err
+		}
+		defer unlock()
+		target = free
+	}
 
 	// Write assembled file to storage
 	resource := &model.Resource{
 		Repo: repo,
-		Path: session.Path,
+		Path: target,
+	}
+	if err := authorize(ctx, repo, target, userID, perm.Write); err != nil {
+		return "", "", 0, err
 	}
 
-	if err := stor.PutFile(ctx, resource, io.NopCloser(bytes.NewReader(finalData))); err != nil {
-		return "", 0, fmt.Errorf("failed to store assembled file: %w", err)
+	parent, err := ResolveParent(ctx, repo, target, createParents, userID)
+	if err != nil {
+		return "", "", 0, err
 	}
 
-	// Update database with file metadata
+	// A file the upload failed to replace is left alone, a new one removed again
+	_, err = db.GetFile(ctx, repo.ID, target)
+	existed := err == nil
+
 	fileObj := &model.FileObject{
 		RepoID:   repo.ID,
-		Path:     session.Path,
-		Name:     filepath.Base(session.Path),
+		OwnerID:  session.UserID,
+		ParentID: parent.ID,
+		Path:     target,
+		Name:     filepath.Base(target),
 		IsDir:    false,
 		Size:     session.TotalSize,
-		ModTime:  time.Now(),
-		Checksum: &checksum,
+		ModTime:  storedModTime(modTime),
+	}
+	if err := storeUpload(ctx, resource, fileObj, content, expectedChecksum, modTime); err != nil {
+		// Content not matching the checksum was not stored, the chunks stay for a retry or cancel
+		var mismatch *ChecksumMismatchError
+		if errors.As(err, &mismatch) {
+			return "", "", 0, err
+		}
+		return "", "", 0, failUpload(ctx, session, resource, existed, err)
 	}
 
-	if err := db.UpsertFile(ctx, fileObj); err != nil {
-		return "", 0, fmt.Errorf("failed to update database: %w", err)
+	if err := db.UpdateUploadSessionStatus(ctx, uploadID, model.UploadCompleted); err != nil {
+		return "", "", 0, fmt.Errorf("failed to update session status: %w", err)
 	}
 
 	// Clean up temporary chunk files
@@ -482,30 +1641,25 @@ func (s *Service) FinalizeUpload(ctx context.Context, uploadID string, repo *mod
 		}
 	}
 
-	// Update session status
-	if err := db.UpdateUploadSessionStatus(ctx, uploadID, "completed"); err != nil {
-		return "", 0, fmt.Errorf("failed to update session status: %w", err)
-	}
-
 	// Record change in change log
 	version := generateVersion()
 	change := &model.ChangeLog{
 		RepoID:    session.RepoID,
 		Operation: "create",
-		Path:      session.Path,
+		Path:      target,
 		UserID:    session.UserID,
 		Version:   version,
 	}
 
-	if err := db.RecordChange(ctx, change); err != nil {
-		return "", 0, fmt.Errorf("failed to record change: %w", err)
+	if err := recordChange(ctx, change); err != nil {
+		return "", "", 0, fmt.Errorf("failed to record change: %w", err)
 	}
 
 	if err := db.UpdateVersion(ctx, session.RepoID, version, "{}"); err != nil {
-		return "", 0, fmt.Errorf("failed to update repository version: %w", err)
+		return "", "", 0, fmt.Errorf("failed to update repository version: %w", err)
 	}
 
-	return checksum, session.TotalSize, nil
+	return target, *fileObj.Checksum, session.TotalSize, nil
 }
 
 func (s *Service) CancelUpload(ctx context.Context, uploadID string) error {
@@ -521,7 +1675,7 @@ func (s *Service) CancelUpload(ctx context.Context, uploadID string) error {
 		}
 	}
 
-	if err := db.UpdateUploadSessionStatus(ctx, uploadID, "cancelled"); err != nil {
+	if err := db.UpdateUploadSessionStatus(ctx, uploadID, model.UploadCancelled); err != nil {
 		return fmt.Errorf("failed to cancel upload: %w", err)
 	}
 
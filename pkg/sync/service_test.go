@@ -1,14 +1,23 @@
 package sync
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGenerateVersion(t *testing.T) {
@@ -30,6 +39,10 @@ func TestGenerateVersion(t *testing.T) {
 		parts := strings.Split(version, "-")
 		assert.Len(t, parts, 2, "Version should have 2 parts")
 	})
+
+	t.Run("Zero version sorts first", func(t *testing.T) {
+		assert.Less(t, model.ZeroVersion, generateVersion())
+	})
 }
 
 func TestCalculateSHA256(t *testing.T) {
@@ -253,6 +266,30 @@ func TestIntegrityVerification(t *testing.T) {
 	})
 }
 
+func TestVerifyChecksum(t *testing.T) {
+	data := []byte("uploaded content")
+	actual := calculateSHA256(data)
+
+	t.Run("No expected checksum", func(t *testing.T) {
+		assert.NoError(t, verifyChecksum("", actual))
+	})
+
+	t.Run("Matching checksum in any case", func(t *testing.T) {
+		assert.NoError(t, verifyChecksum(actual, actual))
+		assert.NoError(t, verifyChecksum(" "+strings.ToUpper(actual)+" ", actual))
+	})
+
+	t.Run("Mismatch reports both hashes", func(t *testing.T) {
+		expected := calculateSHA256([]byte("other content"))
+		err := verifyChecksum(expected, actual)
+
+		var mismatch *ChecksumMismatchError
+		require.ErrorAs(t, err, &mismatch)
+		assert.Equal(t, expected, mismatch.Expected)
+		assert.Equal(t, actual, mismatch.Actual)
+	})
+}
+
 func TestChunkUploadSequence(t *testing.T) {
 	t.Run("Upload sequence validation", func(t *testing.T) {
 		totalSize := int64(3.5 * 1024 * 1024)
@@ -927,3 +964,282 @@ func TestSyncConstants(t *testing.T) {
 		assert.Equal(t, 24*time.Hour, MaxConnectionTime)
 	})
 }
+
+func TestMutationResultRemoved(t *testing.T) {
+	result := &MutationResult{}
+	for i := 0; i < MaxRemovedPaths+5; i++ {
+		result.removed(fmt.Sprintf("/dir/%d", i), fmt.Sprintf("v%d-0", i))
+	}
+
+	assert.Equal(t, MaxRemovedPaths+5, result.Affected)
+	assert.Len(t, result.Removed, MaxRemovedPaths)
+	assert.Equal(t, "/dir/0", result.Removed[0])
+	assert.Equal(t, fmt.Sprintf("v%d-0", MaxRemovedPaths+4), result.Version)
+}
+
+func TestCollectDirs(t *testing.T) {
+	var dirs []*model.FileObject
+	created := collectDirs(&dirs)
+	created(&model.FileObject{Path: "/a"})
+	created(&model.FileObject{Path: "/a/b"})
+
+	require.Len(t, dirs, 2)
+	assert.Equal(t, "/a", dirs[0].Path)
+	assert.Equal(t, "/a/b", dirs[1].Path)
+	assert.NoError(t, recordCreated(context.Background(), 1, nil, 1), "nothing to record")
+}
+
+func TestMutationResultCopied(t *testing.T) {
+	progress := 0
+	result := &MutationResult{progress: func() { progress++ }}
+	result.copied("/photos/a.jpg", nil)
+	result.copied("/photos/b.jpg", errors.New("disk full"))
+	for i := 0; i < MaxRemovedPaths+5; i++ {
+		result.copied(fmt.Sprintf("/photos/%d.jpg", i), errors.New("disk full"))
+	}
+
+	assert.Equal(t, 1, result.Affected)
+	assert.Equal(t, MaxRemovedPaths+7, progress)
+	assert.Len(t, result.Failed, MaxRemovedPaths)
+	assert.Equal(t, &FailedItem{Path: "/photos/b.jpg", Error: "disk full"}, result.Failed[0])
+}
+
+func TestStat(t *testing.T) {
+	modTime := time.Now()
+	checksum := "abc123"
+	entry := newStatEntry("/a.txt", &model.FileObject{Path: "/a.txt", Size: 42, ModTime: modTime, Checksum: &checksum})
+	assert.True(t, entry.Exists)
+	assert.Equal(t, "abc123", entry.Etag)
+	assert.Equal(t, int64(42), entry.Size)
+	assert.Equal(t, modTime, *entry.ModTime)
+
+	entry = newStatEntry("/missing.txt", nil)
+	assert.Equal(t, &StatEntry{Path: "/missing.txt"}, entry)
+
+	_, err := (&Service{}).Stat(context.Background(), &model.Repository{}, make([]string, MaxStatPaths+1), 0)
+	assert.Error(t, err)
+}
+
+func TestDirectoryEtag(t *testing.T) {
+	modTime := time.Now()
+	checksum, changed := "abc123", "def456"
+	items := []*model.FileObject{
+		{Name: "a.txt", Size: 42, ModTime: modTime, Checksum: &checksum},
+		{Name: "photos", IsDir: true, ModTime: modTime},
+	}
+
+	etag := directoryEtag(items)
+	assert.Len(t, etag, 64)
+	assert.Equal(t, etag, directoryEtag(items))
+	assert.NotEqual(t, etag, directoryEtag(items[:1]))
+	assert.NotEqual(t, directoryEtag(nil), directoryEtag([]*model.FileObject{{Name: ""}}))
+
+	assert.Equal(t, etag, directoryEtag([]*model.FileObject{items[1], items[0]}), "etag does not depend on the order")
+
+	items[0].Checksum = &changed
+	assert.NotEqual(t, etag, directoryEtag(items))
+
+	_, err := (&Service{}).ListBatch(context.Background(), &model.Repository{}, make([]string, MaxListBatchPaths+1), nil, model.ListOrder{}, 0)
+	assert.Error(t, err)
+}
+
+func TestParseListOrder(t *testing.T) {
+	yes := true
+
+	order, err := ParseListOrder("", nil)
+	require.NoError(t, err)
+	assert.Equal(t, defaultOrder, order)
+
+	order, err = ParseListOrder(model.SortNatural, &yes)
+	require.NoError(t, err)
+	assert.Equal(t, model.ListOrder{Sort: model.SortNatural, DirsFirst: true}, order)
+
+	_, err = ParseListOrder("random", nil)
+	assert.ErrorIs(t, err, ErrInvalidSort)
+}
+
+func TestParseModTime(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	modTime, err := ParseModTime("", now)
+	require.NoError(t, err)
+	assert.True(t, modTime.IsZero())
+
+	modTime, err = ParseModTime("1600000000", now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1600000000), modTime.Unix())
+
+	modTime, err = ParseModTime("1600000000.5", now)
+	require.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, modTime.Sub(time.Unix(1600000000, 0)))
+
+	// A client clock running a little fast is tolerated
+	modTime, err = ParseModTime("1700000060", now)
+	require.NoError(t, err)
+	assert.Equal(t, now, modTime)
+
+	for _, value := range []string{"yesterday", "0", "-5", "1700003600"} {
+		_, err := ParseModTime(value, now)
+		assert.ErrorIs(t, err, ErrInvalidModTime, value)
+	}
+}
+
+func TestExtendedExpiry(t *testing.T) {
+	now := time.Now()
+
+	session := &model.UploadSession{CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)}
+	assert.Equal(t, now.Add(sessionLifetime), extendedExpiry(session, now))
+
+	// Keepalives stop extending once the session reaches its maximum age
+	session = &model.UploadSession{CreatedAt: now.Add(-maxSessionLifetime + time.Hour), ExpiresAt: now.Add(time.Minute)}
+	assert.Equal(t, session.CreatedAt.Add(maxSessionLifetime), extendedExpiry(session, now))
+
+	// and never shorten it
+	session = &model.UploadSession{CreatedAt: now, ExpiresAt: now.Add(sessionLifetime + time.Hour)}
+	assert.Equal(t, session.ExpiresAt, extendedExpiry(session, now))
+}
+
+func TestNegotiateChunkSize(t *testing.T) {
+	assert.Equal(t, int64(ChunkSize), NegotiateChunkSize(0))
+	assert.Equal(t, int64(8*1024*1024), NegotiateChunkSize(8*1024*1024))
+	assert.Equal(t, minChunkSize, NegotiateChunkSize(1))
+	assert.Equal(t, maxChunkSize, NegotiateChunkSize(1<<40))
+}
+
+func TestCheckChunk(t *testing.T) {
+	session := &model.UploadSession{TotalSize: 2500, ChunkSize: 1000, TotalChunks: 3}
+
+	assert.NoError(t, checkChunk(session, 0, 1000))
+	assert.NoError(t, checkChunk(session, 2, 500))
+	assert.ErrorIs(t, checkChunk(session, 1, 999), ErrInvalidChunk)
+	assert.ErrorIs(t, checkChunk(session, 2, 1000), ErrInvalidChunk)
+	assert.ErrorIs(t, checkChunk(session, 3, 0), ErrInvalidChunk)
+}
+
+func TestAssembleChunks(t *testing.T) {
+	session := &model.UploadSession{TotalSize: 25, ChunkSize: 10, TotalChunks: 3}
+	stored := map[int][]byte{0: []byte("aaaaaaaaaa"), 1: []byte("bbbbbbbbbb"), 2: []byte("ccccc")}
+	read := func(index int) ([]byte, error) {
+		data, ok := stored[index]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return data, nil
+	}
+	chunk := func(index int) *model.UploadChunk {
+		checksum := calculateSHA256(stored[index])
+		return &model.UploadChunk{ChunkIndex: index, Offset: int64(index) * 10, Size: int64(len(stored[index])), Checksum: &checksum}
+	}
+
+	content, err := assembleChunks(session, []*model.UploadChunk{chunk(0), chunk(1), chunk(2)}, read)
+	require.NoError(t, err)
+	data, err := io.ReadAll(content)
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaaaaaaabbbbbbbbbbccccc", string(data))
+
+	t.Run("Reports every chunk to send again", func(t *testing.T) {
+		shifted := chunk(1)
+		shifted.Offset = 12
+		damaged := chunk(2)
+		other := calculateSHA256([]byte("ddddd"))
+		damaged.Checksum = &other
+
+		_, err := assembleChunks(session, []*model.UploadChunk{shifted, damaged}, read)
+		var incomplete *IncompleteUploadError
+		require.ErrorAs(t, err, &incomplete)
+		assert.ErrorIs(t, err, ErrUploadIncomplete)
+		assert.Equal(t, []ChunkProblem{{0, "missing"}, {1, "offset"}, {2, "data"}}, incomplete.Chunks)
+		assert.Equal(t, int64(0), incomplete.Size)
+		assert.Equal(t, int64(25), incomplete.ExpectedSize)
+	})
+
+	t.Run("Chunk of the wrong size", func(t *testing.T) {
+		short := chunk(0)
+		short.Size = 9
+
+		_, err := assembleChunks(session, []*model.UploadChunk{short, chunk(1), chunk(2)}, read)
+		var incomplete *IncompleteUploadError
+		require.ErrorAs(t, err, &incomplete)
+		assert.Equal(t, []ChunkProblem{{0, "size"}}, incomplete.Chunks)
+		assert.Equal(t, int64(15), incomplete.Size)
+	})
+
+	t.Run("Stored data lost", func(t *testing.T) {
+		lost := &model.UploadSession{TotalSize: 35, ChunkSize: 10, TotalChunks: 4}
+		last := &model.UploadChunk{ChunkIndex: 3, Offset: 30, Size: 5}
+
+		_, err := assembleChunks(lost, []*model.UploadChunk{chunk(0), chunk(1), chunk(2), last}, read)
+		var incomplete *IncompleteUploadError
+		require.ErrorAs(t, err, &incomplete)
+		assert.Equal(t, []ChunkProblem{{2, "size"}, {3, "data"}}, incomplete.Chunks)
+	})
+}
+
+// BenchmarkAssembleChunks measures verifying and joining the chunks of a 64 MiB upload at finalize
+func BenchmarkAssembleChunks(b *testing.B) {
+	const chunkSize = 1 << 20
+	session := &model.UploadSession{TotalSize: 64 * chunkSize, ChunkSize: chunkSize, TotalChunks: 64}
+	data := bytes.Repeat([]byte("x"), chunkSize)
+	checksum := calculateSHA256(data)
+	chunks := make([]*model.UploadChunk, session.TotalChunks)
+	for i := range chunks {
+		chunks[i] = &model.UploadChunk{ChunkIndex: i, Offset: int64(i) * chunkSize, Size: chunkSize, Checksum: &checksum}
+	}
+	read := func(int) ([]byte, error) { return data, nil }
+
+	b.SetBytes(session.TotalSize)
+	for i := 0; i < b.N; i++ {
+		content, err := assembleChunks(session, chunks, read)
+		if err == nil {
+			_, err = io.Copy(io.Discard, content)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWriteChunk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload_0")
+
+	require.NoError(t, writeChunk(path, []byte("first")))
+	require.NoError(t, writeChunk(path, []byte("again")))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "again", string(data))
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestListChangesInvalidCursor(t *testing.T) {
+	service := NewService(nil)
+
+	for _, cursor := range []string{"abc", "-1", "1.5"} {
+		_, err := service.ListChanges(context.Background(), 1, "", cursor, model.ChangeFilter{}, 10)
+		assert.ErrorIs(t, err, ErrInvalidCursor, cursor)
+	}
+}
+
+func TestListChangesInvalidFilter(t *testing.T) {
+	service := NewService(nil)
+
+	_, err := service.ListChanges(context.Background(), 1, "", "", model.ChangeFilter{Operations: []string{"create", "truncate"}}, 10)
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+}
+
+func TestExpands(t *testing.T) {
+	assert.True(t, Expands([]string{"user"}, ExpandUser))
+	assert.True(t, Expands([]string{"file, user"}, ExpandUser))
+	assert.True(t, Expands([]string{"file", "user"}, ExpandUser))
+	assert.False(t, Expands([]string{"users"}, ExpandUser))
+	assert.False(t, Expands(nil, ExpandUser))
+}
+
+func TestAuthorizeWithoutUser(t *testing.T) {
+	repo := &model.Repository{ID: 1, OwnerID: 7}
+	assert.ErrorIs(t, authorize(context.Background(), repo, "/a.txt", 0, perm.Read), perm.ErrDenied)
+	assert.ErrorIs(t, authorize(context.Background(), repo, "/a.txt", 0, perm.Write), perm.ErrDenied)
+}
@@ -0,0 +1,131 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+)
+
+// MaxTextSize is the largest text file saved with SaveText
+const MaxTextSize = 1024 * 1024
+
+var (
+	// ErrNotText is returned when content saved as text is not valid UTF-8
+	ErrNotText = errors.New("content is not UTF-8 text")
+	// ErrTextTooLarge is returned when a text file, saved or replaced, is larger than MaxTextSize
+	ErrTextTooLarge = errors.New("text file too large, use an upload")
+)
+
+// ConflictError is returned when a text is saved over another version than the one it was edited from
+type ConflictError struct {
+	ETag    string // of the current version
+	Content string // of the current version
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("file changed since it was read, its etag is now %s", e.ETag)
+}
+
+// textLocks serialize saves of the same file, so that two editors cannot both save over the same version
+var textLocks [64]sync.Mutex
+
+func textLock(repoID int, path string) *sync.Mutex {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d:%s", repoID, path)
+	return &textLocks[h.Sum32()%uint32(len(textLocks))]
+}
+
+// SaveText replaces the content of a text file if it is still at baseETag, the ETag of the version
+// the new content was edited from. Otherwise a ConflictError with the current version is returned.
+// It returns the stored file and the new repository version.
+func SaveText(ctx context.Context, repo *model.Repository, path, baseETag string, content []byte, userID int) (*model.FileObject, string, error) {
+	if len(content) > MaxTextSize {
+		return nil, "", ErrTextTooLarge
+	}
+	if !utf8.Valid(content) {
+		return nil, "", ErrNotText
+	}
+
+	resource := &model.Resource{Repo: repo, Path: path}
+	lock := textLock(repo.ID, path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	file, err := stor.GetFileInfo(ctx, resource)
+	if err != nil {
+		return nil, "", err
+	}
+	if file.IsDir {
+		return nil, "", ErrNotText
+	}
+
+	// Compare with the stored content rather than the recorded checksum, which files written
+	// over WebDAV may lack
+	current, err := readText(ctx, resource)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag := calculateSHA256(current); !strings.EqualFold(strings.Trim(baseETag, `"`), etag) {
+		return nil, "", &ConflictError{ETag: etag, Content: string(current)}
+	}
+
+	meta, err := stor.PutStream(ctx, resource, bytes.NewReader(content), 0, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to store file: %w", err)
+	}
+
+	// Record the type detected from the new content rather than one guessed from the name for the lookup
+	file.Size = int64(len(content))
+	file.ModTime = time.Now()
+	file.Checksum = &meta.Checksum
+	file.MimeType = &meta.ContentType
+	if err := db.UpsertFile(ctx, file); err != nil {
+		return nil, "", fmt.Errorf("failed to update database: %w", err)
+	}
+
+	version := generateVersion()
+	change := &model.ChangeLog{
+		RepoID:    repo.ID,
+		Operation: "modify",
+		Path:      path,
+		UserID:    userID,
+		Version:   version,
+	}
+	if err := recordChange(ctx, change); err != nil {
+		return nil, "", fmt.Errorf("failed to record change: %w", err)
+	}
+	if err := db.UpdateVersion(ctx, repo.ID, version, "{}"); err != nil {
+		return nil, "", fmt.Errorf("failed to update repository version: %w", err)
+	}
+
+	return file, version, nil
+}
+
+// readText reads the content of a file no larger than MaxTextSize
+func readText(ctx context.Context, resource *model.Resource) ([]byte, error) {
+	reader, err := stor.OpenFile(ctx, resource)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(io.LimitReader(reader, MaxTextSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > MaxTextSize {
+		return nil, ErrTextTooLarge
+	}
+	return content, nil
+}
@@ -0,0 +1,20 @@
+package sync
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveTextValidation(t *testing.T) {
+	repo := &model.Repository{ID: 1, Name: "notes"}
+
+	_, _, err := SaveText(context.Background(), repo, "/a.md", "etag", []byte(strings.Repeat("a", MaxTextSize+1)), 1)
+	assert.ErrorIs(t, err, ErrTextTooLarge)
+
+	_, _, err = SaveText(context.Background(), repo, "/a.md", "etag", []byte{0xff, 0xfe}, 1)
+	assert.ErrorIs(t, err, ErrNotText)
+}
@@ -0,0 +1,171 @@
+package sync
+
+import (
+	"context"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// Transient files, such as the lock and temporary files office suites create while a document is open,
+// come and go within seconds. The creation of one is held back for the transient window, and when the
+// file is deleted before it is logged neither change is, sparing sync clients the churn. The files
+// themselves are stored as usual.
+var (
+	transientPatterns []string      // globs matched against file names, lower case
+	transientWindow   time.Duration // how long a creation is held back, 0 to log every change at once
+
+	pendingLock sync.Mutex
+	pending     = map[pendingKey]*pendingChange{}
+)
+
+type pendingKey struct {
+	repoID int
+	path   string
+}
+
+// pendingChange is the creation of a transient file, logged when its timer fires
+type pendingChange struct {
+	change *model.ChangeLog
+	timer  *time.Timer
+}
+
+// initTransient applies the transient file settings, dropping changes held back under earlier ones
+func initTransient(patterns []string, window time.Duration) {
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+
+	transientPatterns = transientPatterns[:0]
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Printf("Ignoring invalid transient file pattern %q: %s", pattern, err)
+			continue
+		}
+		transientPatterns = append(transientPatterns, strings.ToLower(pattern))
+	}
+	transientWindow = max(window, 0)
+
+	for key, p := range pending {
+		p.timer.Stop()
+		delete(pending, key)
+	}
+}
+
+// isTransient reports whether a file is named like a transient file
+func isTransient(p string) bool {
+	name := strings.ToLower(path.Base(p))
+	for _, pattern := range transientPatterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// holdTransient reports whether a change is held back or dropped rather than logged now.
+// A move of a file whose creation is held back turns into the creation of its new path.
+func holdTransient(change *model.ChangeLog) bool {
+	if transientWindow <= 0 || len(transientPatterns) == 0 {
+		return false
+	}
+
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+
+	key := pendingKey{change.RepoID, change.Path}
+	switch change.Operation {
+	case "create", "copy":
+		if _, ok := pending[key]; ok {
+			return true
+		}
+		if !isTransient(change.Path) {
+			return false
+		}
+		pending[key] = &pendingChange{change: change, timer: time.AfterFunc(transientWindow, func() { flushTransient(key) })}
+		return true
+
+	case "modify":
+		_, ok := pending[key]
+		return ok
+
+	case "delete":
+		// Files under a deleted directory go with it
+		_, held := pending[key]
+		for k, p := range pending {
+			if k.repoID == key.repoID && (k.path == key.path || strings.HasPrefix(k.path, key.path+"/")) {
+				p.timer.Stop()
+				delete(pending, k)
+			}
+		}
+		return held
+
+	case "move", "rename":
+		if change.OldPath == nil {
+			return false
+		}
+		_, held := pending[pendingKey{change.RepoID, *change.OldPath}]
+		movePending(change.RepoID, *change.OldPath, change.Path)
+		if !held || isTransient(change.Path) {
+			return held
+		}
+
+		// Saving a document by renaming a temporary file to its name is what clients need to see
+		pending[key].timer.Stop()
+		delete(pending, key)
+		change.Operation, change.OldPath = "create", nil
+		return false
+	}
+	return false
+}
+
+// movePending moves the changes held back for a file or the files under a directory to its new path,
+// so that they end up under the name moved to
+func movePending(repoID int, from, to string) {
+	for key, p := range pending {
+		if key.repoID != repoID {
+			continue
+		}
+
+		var moved string
+		if key.path == from {
+			moved = to
+		} else if rest, ok := strings.CutPrefix(key.path, from+"/"); ok {
+			moved = to + "/" + rest
+		} else {
+			continue
+		}
+
+		p.timer.Stop()
+		delete(pending, key)
+		newKey := pendingKey{repoID, moved}
+		p.change.Path = moved
+		p.timer = time.AfterFunc(transientWindow, func() { flushTransient(newKey) })
+		pending[newKey] = p
+	}
+}
+
+// flushTransient logs the creation of a transient file that outlived the window, with a version of its own
+func flushTransient(key pendingKey) {
+	pendingLock.Lock()
+	p, ok := pending[key]
+	delete(pending, key)
+	pendingLock.Unlock()
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	p.change.Version = generateVersion()
+	if err := logChange(ctx, p.change); err != nil {
+		log.Printf("Failed to record held back change of %s: %s", key.path, err)
+		return
+	}
+	if err := db.UpdateVersion(ctx, key.repoID, p.change.Version, "{}"); err != nil {
+		log.Printf("Failed to update repository version: %s", err)
+	}
+}
@@ -0,0 +1,105 @@
+package sync
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// holdFor enables holding back transient files for a test, with a window long enough never to flush
+func holdFor(t *testing.T, patterns ...string) {
+	initTransient(patterns, time.Hour)
+	t.Cleanup(func() { initTransient(nil, 0) })
+}
+
+func transientChange(operation, p string) *model.ChangeLog {
+	return &model.ChangeLog{RepoID: 1, Operation: operation, Path: p}
+}
+
+func TestIsTransient(t *testing.T) {
+	holdFor(t, "~$*", ".~lock.*#", "*.TMP", "[")
+
+	assert.True(t, isTransient("/docs/~$report.docx"))
+	assert.True(t, isTransient("/docs/.~lock.report.odt#"))
+	assert.True(t, isTransient("/docs/~WRD0001.tmp"))
+	assert.False(t, isTransient("/docs/report.docx"))
+	assert.False(t, isTransient("/~$docs/report.docx"))
+	assert.Equal(t, []string{"~$*", ".~lock.*#", "*.tmp"}, transientPatterns)
+}
+
+func TestHoldTransient(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		initTransient([]string{"*.tmp"}, 0)
+		assert.False(t, holdTransient(transientChange("create", "/a.tmp")))
+	})
+
+	t.Run("Created and deleted", func(t *testing.T) {
+		holdFor(t, "~$*")
+		assert.True(t, holdTransient(transientChange("create", "/~$report.docx")))
+		assert.True(t, holdTransient(transientChange("modify", "/~$report.docx")))
+		assert.True(t, holdTransient(transientChange("delete", "/~$report.docx")))
+		assert.Empty(t, pending)
+
+		assert.False(t, holdTransient(transientChange("delete", "/~$report.docx")))
+	})
+
+	t.Run("Other files", func(t *testing.T) {
+		holdFor(t, "~$*")
+		assert.False(t, holdTransient(transientChange("create", "/report.docx")))
+		assert.False(t, holdTransient(transientChange("modify", "/~$report.docx")))
+		assert.Empty(t, pending)
+	})
+
+	t.Run("Saved through a temporary file", func(t *testing.T) {
+		holdFor(t, "*.tmp")
+		assert.True(t, holdTransient(transientChange("create", "/docs/~WRD0001.tmp")))
+
+		move := transientChange("move", "/docs/report.docx")
+		old := "/docs/~WRD0001.tmp"
+		move.OldPath = &old
+		assert.False(t, holdTransient(move))
+		assert.Equal(t, "create", move.Operation)
+		assert.Nil(t, move.OldPath)
+		assert.Empty(t, pending)
+	})
+
+	t.Run("Directory deleted", func(t *testing.T) {
+		holdFor(t, "*.tmp")
+		assert.True(t, holdTransient(transientChange("create", "/docs/a.tmp")))
+		assert.True(t, holdTransient(transientChange("create", "/docs.tmp")))
+		assert.False(t, holdTransient(transientChange("delete", "/docs")))
+		assert.Equal(t, []pendingKey{{1, "/docs.tmp"}}, slices.Collect(maps.Keys(pending)))
+	})
+
+	t.Run("Renamed to another transient name", func(t *testing.T) {
+		holdFor(t, "*.tmp")
+		assert.True(t, holdTransient(transientChange("create", "/a.tmp")))
+
+		move := transientChange("move", "/b.tmp")
+		old := "/a.tmp"
+		move.OldPath = &old
+		assert.True(t, holdTransient(move))
+		assert.Contains(t, pending, pendingKey{1, "/b.tmp"})
+		assert.True(t, holdTransient(transientChange("delete", "/b.tmp")))
+	})
+
+	t.Run("Directory moved", func(t *testing.T) {
+		holdFor(t, "*.tmp")
+		assert.True(t, holdTransient(transientChange("create", "/docs/a.tmp")))
+
+		move := transientChange("move", "/archive")
+		old := "/docs"
+		move.OldPath = &old
+		assert.False(t, holdTransient(move))
+		assert.Equal(t, "move", move.Operation)
+
+		p, ok := pending[pendingKey{1, "/archive/a.tmp"}]
+		if assert.True(t, ok) {
+			assert.Equal(t, "/archive/a.tmp", p.change.Path)
+		}
+	})
+}
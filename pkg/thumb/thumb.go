@@ -0,0 +1,67 @@
+// Package thumb generates image thumbnails.
+package thumb
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// DefaultSize is the default bounding box of generated thumbnails
+const DefaultSize = 256
+
+// MaxSourceBytes is the largest source image thumbnails are generated for
+const MaxSourceBytes = 32 << 20
+
+// ContentType is the MIME type of generated thumbnails
+const ContentType = "image/jpeg"
+
+// Supported reports whether thumbnails can be generated for a MIME type
+func Supported(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	default:
+		return false
+	}
+}
+
+// Generate decodes an image and writes a JPEG thumbnail fitting in a size x size box.
+// Images already smaller than the box are re-encoded without scaling.
+func Generate(w io.Writer, r io.Reader, size int) error {
+	src, _, err := image.Decode(io.LimitReader(r, MaxSourceBytes))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	return jpeg.Encode(w, scale(src, size), &jpeg.Options{Quality: 80})
+}
+
+// scale shrinks an image to fit in a size x size box using nearest neighbour sampling
+func scale(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= size && sh <= size {
+		return src
+	}
+
+	dw, dh := size, size
+	if sw > sh {
+		dh = max(sh*size/sw, 1)
+	} else {
+		dw = max(sw*size/sh, 1)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		sy := b.Min.Y + y*sh/dh
+		for x := range dw {
+			sx := b.Min.X + x*sw/dw
+			dst.Set(x, y, src.At(sx, sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,63 @@
+package thumb
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodePNG(t *testing.T, w, h int) *bytes.Buffer {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return &buf
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		wantW, wantH  int
+	}{
+		{"Landscape", 800, 400, 256, 128},
+		{"Portrait", 300, 600, 128, 256},
+		{"Small image kept", 100, 50, 100, 50},
+		{"Extreme aspect ratio", 2000, 2, 256, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			require.NoError(t, Generate(&out, encodePNG(t, tt.width, tt.height), DefaultSize))
+
+			img, err := jpeg.Decode(&out)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantW, img.Bounds().Dx())
+			assert.Equal(t, tt.wantH, img.Bounds().Dy())
+		})
+	}
+
+	t.Run("Invalid image", func(t *testing.T) {
+		var out bytes.Buffer
+		assert.Error(t, Generate(&out, bytes.NewBufferString("not an image"), DefaultSize))
+	})
+}
+
+func TestSupported(t *testing.T) {
+	assert.True(t, Supported("image/png"))
+	assert.True(t, Supported("image/jpeg"))
+	assert.False(t, Supported("image/svg+xml"))
+	assert.False(t, Supported("application/pdf"))
+}
@@ -0,0 +1,123 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/audit"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+var (
+	// ErrAppPasswordRequired is returned when a user with two-factor login authenticates a client with their
+	// account password, which only logging in with a one-time code accepts
+	ErrAppPasswordRequired = errors.New("two-factor login is enabled, use an app password")
+	// ErrInvalidAppPasswordName is returned for an app password without a name, or with a very long one
+	ErrInvalidAppPasswordName = errors.New("invalid app password name")
+)
+
+// appPasswordAlphabet leaves out letters easily mistaken for each other when typing a password in
+const appPasswordAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// generateAppPassword returns a random password of four groups of four characters, about 79 bits
+func generateAppPassword() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for i, r := range raw {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(appPasswordAlphabet[int(r)%len(appPasswordAlphabet)])
+	}
+	return b.String(), nil
+}
+
+// CreateAppPassword generates a password for one client of the user. The password is returned only here;
+// the server keeps its hash alone.
+func CreateAppPassword(ctx context.Context, user *model.User, name, remoteAddr string) (string, *model.AppPassword, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 {
+		return "", nil, ErrInvalidAppPasswordName
+	}
+
+	password, err := generateAppPassword()
+	if err != nil {
+		return "", nil, err
+	}
+
+	app := &model.AppPassword{
+		UserID: user.ID,
+		Name:   name,
+		HA1:    calculateHA1(user.Username, password),
+	}
+	if err := db.CreateAppPassword(ctx, app); err != nil {
+		return "", nil, err
+	}
+
+	audit.Record(ctx, &model.AuditEntry{
+		Action:     model.AuditAppPasswordAdded,
+		UserID:     &user.ID,
+		Username:   user.Username,
+		RemoteAddr: remoteAddr,
+		Detail:     name,
+	})
+	return password, app, nil
+}
+
+// ListAppPasswords returns the app passwords of the user, without their hashes
+func ListAppPasswords(ctx context.Context, user *model.User) ([]*model.AppPassword, error) {
+	return db.ListAppPasswords(ctx, user.ID)
+}
+
+// RevokeAppPassword deletes an app password of the user; the client using it can no longer authenticate
+func RevokeAppPassword(ctx context.Context, user *model.User, id int, remoteAddr string) error {
+	if err := db.DeleteAppPassword(ctx, user.ID, id); err != nil {
+		return err
+	}
+
+	audit.Record(ctx, &model.AuditEntry{
+		Action:     model.AuditAppPasswordRevoked,
+		UserID:     &user.ID,
+		Username:   user.Username,
+		RemoteAddr: remoteAddr,
+	})
+	return nil
+}
+
+// matchCredentials checks the credentials of Basic or Digest authentication, with match telling whether they
+// were derived from an HA1 hash. They may be the user's account password or one of their app passwords, but
+// with two-factor login only app passwords are accepted, as no one-time code can be asked for.
+func matchCredentials(ctx context.Context, user *model.User, remoteAddr string, match func(ha1 string) bool) error {
+	if !user.HasTOTP() && match(user.HA1) {
+		return nil
+	}
+
+	passwords, err := db.ListAppPasswords(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	for _, app := range passwords {
+		if match(app.HA1) {
+			if err := db.TouchAppPassword(ctx, app.ID, time.Now()); err != nil {
+				log.Printf("Failed to record use of app password %d: %s", app.ID, err)
+			}
+			return nil
+		}
+	}
+
+	if user.HasTOTP() && match(user.HA1) {
+		return ErrAppPasswordRequired
+	}
+
+	recordLoginFailure(ctx, user, user.Username, remoteAddr)
+	return errors.New("invalid credentials")
+}
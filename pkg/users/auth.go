@@ -3,6 +3,7 @@ package users
 import (
 	"context"
 	"crypto/md5"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -23,11 +24,53 @@ func calculateHA1(username, password string) string {
 	return ComputeMD5("%s:%s:%s", username, userRealm, password)
 }
 
-// Authenticate validates a user's credentials for basic authentication
-func Authenticate(ctx context.Context, username, password string) (*model.User, error) {
+// compareHA1 reports whether the stored and provided HA1 hashes match.
+// The comparison runs in constant time to avoid leaking hash prefixes through timing.
+func compareHA1(stored, provided string) bool {
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(provided)) == 1
+}
+
+// compareResponse reports whether a digest response matches the expected value in constant time.
+func compareResponse(expected, provided string) bool {
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(provided)) == 1
+}
+
+// Authenticate validates a user's credentials for basic authentication: their password, or an app password
+// once they enabled two-factor login. remoteAddr is the client address used for lockout tracking, it may be empty.
+func Authenticate(ctx context.Context, username, password, remoteAddr string) (*model.User, error) {
+	if err := checkLockout(ctx, username, remoteAddr); err != nil {
+		return nil, err
+	}
+
+	user, err := db.GetUserByUsername(ctx, username)
+	if err != nil {
+		recordLoginFailure(ctx, nil, username, remoteAddr)
+		return nil, errors.New("invalid credentials")
+	}
+
+	providedHA1 := calculateHA1(username, password)
+	err = matchCredentials(ctx, user, remoteAddr, func(ha1 string) bool {
+		return compareHA1(ha1, providedHA1)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	loginSucceeded(ctx, user)
+	return user, nil
+}
+
+// checkPassword validates a user's password, counting a failure against the lockout,
+// but leaves resetting the counter to the caller once the login is complete
+func checkPassword(ctx context.Context, username, password, remoteAddr string) (*model.User, error) {
+	if err := checkLockout(ctx, username, remoteAddr); err != nil {
+		return nil, err
+	}
+
 	// Get user by username
 	user, err := db.GetUserByUsername(ctx, username)
 	if err != nil {
+		recordLoginFailure(ctx, nil, username, remoteAddr)
 		return nil, errors.New("invalid credentials")
 	}
 
@@ -35,15 +78,20 @@ func Authenticate(ctx context.Context, username, password string) (*model.User,
 	providedHA1 := calculateHA1(username, password)
 
 	// Compare hashes using constant time comparison
-	if user.HA1 != providedHA1 {
+	if !compareHA1(user.HA1, providedHA1) {
+		recordLoginFailure(ctx, user, username, remoteAddr)
 		return nil, errors.New("invalid credentials")
 	}
 
-	updateLastLogin(context.Background(), user)
-
 	return user, nil
 }
 
+// loginSucceeded resets the failure counter and records the time of a completed login
+func loginSucceeded(ctx context.Context, user *model.User) {
+	resetLoginFailures(ctx, user)
+	updateLastLogin(context.Background(), user)
+}
+
 func updateLastLogin(ctx context.Context, user *model.User) {
 	// Update last login time
 	now := time.Now()
@@ -57,27 +105,34 @@ func updateLastLogin(ctx context.Context, user *model.User) {
 	}
 }
 
-// ValidateDigest validates a user's credentials for digest authentication
-func ValidateDigest(ctx context.Context, username, uri, nonce, nc, cnonce, qop, response, method string) (*model.User, error) {
+// ValidateDigest validates a user's credentials for digest authentication, which like Basic ones are those of
+// an app password once they enabled two-factor login
+func ValidateDigest(ctx context.Context, username, uri, nonce, nc, cnonce, qop, response, method, remoteAddr string) (*model.User, error) {
+	if err := checkLockout(ctx, username, remoteAddr); err != nil {
+		return nil, err
+	}
+
 	// Get user by username
 	user, err := db.GetUserByUsername(ctx, username)
 	if err != nil {
 		log.Printf("Failed to get user: %s", err)
+		recordLoginFailure(ctx, nil, username, remoteAddr)
 		return nil, errors.New("invalid credentials")
 	}
 
 	// Calculate HA2
 	ha2 := ComputeMD5("%s:%s", method, uri)
 
-	// Calculate the expected response using the stored HA1
-	expectedResponse := ComputeMD5("%s:%s:%s:%s:%s:%s", user.HA1, nonce, nc, cnonce, qop, ha2)
-	// Compare responses using constant time comparison
-	if response != expectedResponse {
-		log.Printf("Invalid response, expected: %s, got: %s", expectedResponse, response)
-		return nil, errors.New("invalid credentials")
+	// Calculate the expected response using a stored HA1, comparing in constant time
+	err = matchCredentials(ctx, user, remoteAddr, func(ha1 string) bool {
+		expectedResponse := ComputeMD5("%s:%s:%s:%s:%s:%s", ha1, nonce, nc, cnonce, qop, ha2)
+		return compareResponse(expectedResponse, response)
+	})
+	if err != nil {
+		log.Printf("Invalid digest response for user %s: %s", username, err)
+		return nil, err
 	}
 
-	updateLastLogin(context.Background(), user)
-
+	loginSucceeded(ctx, user)
 	return user, nil
 }
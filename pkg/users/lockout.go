@@ -0,0 +1,187 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/audit"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// ErrLockedOut is returned when too many failed logins locked the account or source address
+var ErrLockedOut = errors.New("too many failed login attempts, try again later")
+
+// UserStatus combines a user account with its current lockout state
+type UserStatus struct {
+	*model.User
+	FailedLogins int        `json:"failed_logins"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+}
+
+func lockoutEnabled() bool {
+	return lockoutPolicy.MaxUserFailures > 0 || lockoutPolicy.MaxAddrFailures > 0
+}
+
+// checkLockout returns ErrLockedOut if either the username or the source address is locked
+func checkLockout(ctx context.Context, username, remoteAddr string) error {
+	if !lockoutEnabled() {
+		return nil
+	}
+
+	now := time.Now()
+	for _, s := range []struct{ kind, subject string }{
+		{model.LoginSubjectUser, username},
+		{model.LoginSubjectAddr, remoteAddr},
+	} {
+		if s.subject == "" {
+			continue
+		}
+
+		failure, err := db.GetLoginFailure(ctx, s.kind, s.subject)
+		if err != nil {
+			log.Printf("Failed to check lockout for %s %s: %s", s.kind, s.subject, err)
+			continue
+		}
+		if failure != nil && failure.IsLocked(now) {
+			return ErrLockedOut
+		}
+	}
+
+	return nil
+}
+
+// recordLoginFailure counts a failed login against the user (if known) and the source address,
+// locking either once its threshold is reached
+func recordLoginFailure(ctx context.Context, user *model.User, username, remoteAddr string) {
+	entry := &model.AuditEntry{
+		Action:     model.AuditLoginFailed,
+		Username:   username,
+		RemoteAddr: remoteAddr,
+	}
+	if user != nil {
+		entry.UserID = &user.ID
+	}
+	audit.Record(ctx, entry)
+
+	if !lockoutEnabled() {
+		return
+	}
+
+	// Only count against existing accounts so unknown names don't fill the table
+	if user != nil && lockoutPolicy.MaxUserFailures > 0 {
+		failure, err := db.RecordLoginFailure(ctx, model.LoginSubjectUser, user.Username,
+			lockoutPolicy.Window, lockoutPolicy.MaxUserFailures, lockoutPolicy.CoolDown)
+		if err != nil {
+			log.Printf("Failed to record login failure for user %s: %s", user.Username, err)
+		} else if failure.Failures == lockoutPolicy.MaxUserFailures {
+			audit.Record(ctx, &model.AuditEntry{
+				Action:     model.AuditAccountLocked,
+				UserID:     &user.ID,
+				Username:   user.Username,
+				RemoteAddr: remoteAddr,
+				Detail:     fmt.Sprintf("locked until %s", failure.LockedUntil.Format(time.RFC3339)),
+			})
+		}
+	}
+
+	if remoteAddr != "" && lockoutPolicy.MaxAddrFailures > 0 {
+		failure, err := db.RecordLoginFailure(ctx, model.LoginSubjectAddr, remoteAddr,
+			lockoutPolicy.Window, lockoutPolicy.MaxAddrFailures, lockoutPolicy.CoolDown)
+		if err != nil {
+			log.Printf("Failed to record login failure for address %s: %s", remoteAddr, err)
+		} else if failure.Failures == lockoutPolicy.MaxAddrFailures {
+			audit.Record(ctx, &model.AuditEntry{
+				Action:     model.AuditAddressLocked,
+				RemoteAddr: remoteAddr,
+				Detail:     fmt.Sprintf("locked until %s", failure.LockedUntil.Format(time.RFC3339)),
+			})
+		}
+	}
+}
+
+// resetLoginFailures clears the user's failure counter after a successful login.
+// The address counter is kept so one valid account cannot mask guessing against others.
+func resetLoginFailures(ctx context.Context, user *model.User) {
+	if !lockoutEnabled() {
+		return
+	}
+
+	if err := db.ClearLoginFailure(ctx, model.LoginSubjectUser, user.Username); err != nil {
+		log.Printf("Failed to reset login failures for user %s: %s", user.Username, err)
+	}
+}
+
+// Unlock clears the failure counter and lock of a user account
+func Unlock(ctx context.Context, id int, actor *model.User) (*model.User, error) {
+	user, err := db.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.ClearLoginFailure(ctx, model.LoginSubjectUser, user.Username); err != nil {
+		return nil, err
+	}
+
+	audit.Record(ctx, &model.AuditEntry{
+		Action:   model.AuditAccountUnlocked,
+		UserID:   &user.ID,
+		Username: user.Username,
+		ActorID:  &actor.ID,
+	})
+	return user, nil
+}
+
+// UnlockAddress clears the failure counter and lock of a source address
+func UnlockAddress(ctx context.Context, remoteAddr string, actor *model.User) error {
+	if err := db.ClearLoginFailure(ctx, model.LoginSubjectAddr, remoteAddr); err != nil {
+		return err
+	}
+
+	audit.Record(ctx, &model.AuditEntry{
+		Action:     model.AuditAddressUnlocked,
+		ActorID:    &actor.ID,
+		RemoteAddr: remoteAddr,
+	})
+	return nil
+}
+
+// ListLockedAddresses returns source addresses with recent failed logins
+func ListLockedAddresses(ctx context.Context) ([]*model.LoginFailure, error) {
+	return db.ListLoginFailures(ctx, model.LoginSubjectAddr)
+}
+
+// List returns all users together with their lockout state
+func List(ctx context.Context) ([]*UserStatus, error) {
+	all, err := db.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	failures, err := db.ListLoginFailures(ctx, model.LoginSubjectUser)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*model.LoginFailure, len(failures))
+	for _, f := range failures {
+		byName[f.Subject] = f
+	}
+
+	now := time.Now()
+	result := make([]*UserStatus, len(all))
+	for i, user := range all {
+		status := &UserStatus{User: user}
+		if f, ok := byName[user.Username]; ok {
+			status.FailedLogins = f.Failures
+			if f.IsLocked(now) {
+				status.LockedUntil = f.LockedUntil
+			}
+		}
+		result[i] = status
+	}
+	return result, nil
+}
@@ -0,0 +1,102 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/cgang/file-hub/pkg/db"
+)
+
+// MaxPreferencesSize is how many bytes the encoded preferences of a user may take
+const MaxPreferencesSize = 64 << 10
+
+var (
+	// ErrInvalidPreferences is returned updating preferences with anything but a JSON object
+	ErrInvalidPreferences = errors.New("preferences must be a JSON object")
+	// ErrPreferencesTooLarge is returned when preferences would grow beyond MaxPreferencesSize
+	ErrPreferencesTooLarge = errors.New("preferences too large")
+)
+
+// Preferences holds the settings clients keep on the server, such as the default sort, the theme or
+// what new sync clients select. The server does not interpret them.
+type Preferences map[string]any
+
+// GetPreferences returns the preferences of a user
+func GetPreferences(ctx context.Context, userID int) (Preferences, error) {
+	data, err := db.GetUserPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return decodePreferences(data)
+}
+
+// PatchPreferences updates the preferences of a user with a JSON merge patch (RFC 7396): the settings
+// in the patch replace those of the same name, objects are merged key by key, and null removes a setting.
+// Settings left out of the patch stay as they are.
+func PatchPreferences(ctx context.Context, userID int, patch []byte) (Preferences, error) {
+	changes, err := decodePreferences(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefs Preferences
+	_, err = db.UpdateUserPreferences(ctx, userID, func(current []byte) ([]byte, error) {
+		if prefs, err = decodePreferences(current); err != nil {
+			return nil, err
+		}
+		prefs = mergePatch(prefs, changes)
+
+		data, err := json.Marshal(prefs)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > MaxPreferencesSize {
+			return nil, fmt.Errorf("%w: %d bytes, at most %d", ErrPreferencesTooLarge, len(data), MaxPreferencesSize)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// decodePreferences parses a JSON object, keeping numbers as they were written
+func decodePreferences(data []byte) (Preferences, error) {
+	prefs := Preferences{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return prefs, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&prefs); err != nil || prefs == nil {
+		return nil, ErrInvalidPreferences
+	}
+	if decoder.More() {
+		return nil, ErrInvalidPreferences
+	}
+	return prefs, nil
+}
+
+// mergePatch applies a JSON merge patch to target, which it modifies
+func mergePatch(target, patch map[string]any) map[string]any {
+	if target == nil {
+		target = map[string]any{}
+	}
+	for key, value := range patch {
+		switch value := value.(type) {
+		case nil:
+			delete(target, key)
+		case map[string]any:
+			current, _ := target[key].(map[string]any)
+			target[key] = mergePatch(current, value)
+		default:
+			target[key] = value
+		}
+	}
+	return target
+}
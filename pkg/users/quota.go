@@ -0,0 +1,22 @@
+package users
+
+import (
+	"context"
+
+	"github.com/cgang/file-hub/pkg/db"
+)
+
+// Usage returns the bytes stored in the repositories of a user and how many are left in their quota
+func Usage(ctx context.Context, userID int) (used, available int64, err error) {
+	quota, err := db.GetUserQuota(ctx, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	used, err = db.GetUserStorageUsage(ctx, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return used, max(quota.TotalQuotaBytes-used, 0), nil
+}
@@ -0,0 +1,108 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/audit"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+)
+
+var (
+	// ErrInvalidUsername is returned for a username that cannot name a user and their home repository
+	ErrInvalidUsername = errors.New("invalid username")
+	// ErrUsernameTaken is returned when renaming a user to the name of another user or repository
+	ErrUsernameTaken = errors.New("username already taken")
+	// ErrWrongPassword is returned when renaming oneself without the current password
+	ErrWrongPassword = errors.New("wrong password")
+)
+
+// RenameRequest describes a username change. The HA1 hash digest authentication uses is derived from
+// the username, so a password is always required: the current one when users rename themselves,
+// a new one when an administrator renames someone else.
+type RenameRequest struct {
+	Username   string `json:"username" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+	RenameHome bool   `json:"rename_home"` // give the home repository the new name too
+}
+
+// validUsername reports whether name can be used as a username and as the name of a home repository
+func validUsername(name string) bool {
+	if name == "" || name == "." || name == ".." || len(name) > 255 {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\:")
+}
+
+// Rename changes the username of a user. With reset, the password of the request becomes the new password
+// of the user, otherwise it must be the current one. A renamed home repository keeps answering to its former
+// name, for WebDAV clients and links still using it, for the rename grace period.
+func Rename(ctx context.Context, user *model.User, req *RenameRequest, reset bool, actor *model.User, remoteAddr string) (*model.User, error) {
+	if !validUsername(req.Username) {
+		return nil, ErrInvalidUsername
+	}
+	if req.Username == user.Username {
+		return user, nil
+	}
+	if !reset && !compareHA1(user.HA1, calculateHA1(user.Username, req.Password)) {
+		return nil, ErrWrongPassword
+	}
+
+	if _, err := db.GetUserByUsername(ctx, req.Username); err == nil {
+		return nil, ErrUsernameTaken
+	}
+
+	var home *model.Repository
+	if req.RenameHome {
+		repo, err := db.GetRepositoryByNameAndOwner(ctx, user.Username, user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get home repository: %w", err)
+		}
+		if _, err := db.GetRepositoryByName(ctx, req.Username); err == nil {
+			return nil, ErrUsernameTaken
+		}
+		if err := stor.RenameRepo(ctx, repo, req.Username); err != nil {
+			return nil, fmt.Errorf("failed to move home repository: %w", err)
+		}
+		home = repo
+	}
+
+	ha1 := calculateHA1(req.Username, req.Password)
+	if err := db.RenameUser(ctx, user.ID, req.Username, ha1, home, time.Now().Add(renameGrace)); err != nil {
+		if home != nil {
+			moved := *home
+			moved.Name = req.Username
+			if err := stor.RenameRepo(ctx, &moved, home.Name); err != nil {
+				log.Printf("Failed to move home repository %s back from %s: %s", home.Name, req.Username, err)
+			}
+		}
+		return nil, err
+	}
+
+	detail := fmt.Sprintf("renamed from %s", user.Username)
+	if home != nil {
+		detail += ", home repository included"
+	}
+	if reset {
+		detail += ", password reset"
+	}
+	audit.Record(ctx, &model.AuditEntry{
+		Action:     model.AuditUserRenamed,
+		UserID:     &user.ID,
+		Username:   req.Username,
+		ActorID:    &actor.ID,
+		RemoteAddr: remoteAddr,
+		Detail:     detail,
+	})
+
+	renamed := *user
+	renamed.Username = req.Username
+	renamed.HA1 = ha1
+	return &renamed, nil
+}
@@ -0,0 +1,142 @@
+package users
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/audit"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+)
+
+// One-time codes follow RFC 6238 with the parameters authenticator apps assume by default
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	totpSkew   = 1 // steps accepted before and after the current one, for clocks slightly off
+)
+
+var (
+	// ErrTOTPRequired is returned when the password was right but the user also needs a one-time code
+	ErrTOTPRequired = errors.New("one-time code required")
+	// ErrInvalidTOTP is returned for a one-time code that does not match the user's secret
+	ErrInvalidTOTP = errors.New("invalid one-time code")
+	// ErrTOTPEnabled is returned when enabling one-time codes again; they are disabled first
+	ErrTOTPEnabled = errors.New("one-time codes are already enabled")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new random secret for an authenticator app, base32 encoded
+func GenerateTOTPSecret() (string, error) {
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(key), nil
+}
+
+// TOTPURL returns the otpauth URL authenticator apps read from a QR code to add the secret
+func TOTPURL(secret, username string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", "File Hub")
+	return (&url.URL{Scheme: "otpauth", Host: "totp", Path: "/File Hub:" + username, RawQuery: query.Encode()}).String()
+}
+
+// totpCode computes the code of a time step (RFC 4226 5.3)
+func totpCode(key []byte, step uint64) string {
+	mac := hmac.New(sha1.New, key)
+	_ = binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// ValidateTOTP reports whether code is the one-time code of secret at the given time
+func ValidateTOTP(secret, code string, now time.Time) bool {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(key) < 10 || len(code) != totpDigits {
+		return false
+	}
+
+	step := now.Unix() / int64(totpPeriod/time.Second)
+	for i := -totpSkew; i <= totpSkew; i++ {
+		expected := totpCode(key, uint64(step+int64(i)))
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Login validates a user's password and, if they enabled it, their one-time code.
+// A wrong code counts as a failed login like a wrong password does.
+func Login(ctx context.Context, username, password, code, remoteAddr string) (*model.User, error) {
+	user, err := checkPassword(ctx, username, password, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.HasTOTP() {
+		if code == "" {
+			return nil, ErrTOTPRequired
+		}
+		if !ValidateTOTP(user.TOTPSecret, code, time.Now()) {
+			recordLoginFailure(ctx, user, username, remoteAddr)
+			return nil, ErrInvalidTOTP
+		}
+	}
+
+	loginSucceeded(ctx, user)
+	return user, nil
+}
+
+// EnableTOTP turns on one-time codes for the user with a secret from GenerateTOTPSecret.
+// The code proves their authenticator app was set up with it.
+func EnableTOTP(ctx context.Context, user *model.User, secret, code string) error {
+	if user.HasTOTP() {
+		return ErrTOTPEnabled
+	}
+	if !ValidateTOTP(secret, code, time.Now()) {
+		return ErrInvalidTOTP
+	}
+
+	secret = strings.ToUpper(secret)
+	if err := db.UpdateUser(ctx, user.ID, &db.UserUpdate{TOTPSecret: &secret}); err != nil {
+		return err
+	}
+
+	audit.Record(ctx, &model.AuditEntry{Action: model.AuditTOTPEnabled, UserID: &user.ID, Username: user.Username})
+	return nil
+}
+
+// DisableTOTP turns off one-time codes for the user, who confirms with a current code
+func DisableTOTP(ctx context.Context, user *model.User, code string) error {
+	if !user.HasTOTP() {
+		return nil
+	}
+	if !ValidateTOTP(user.TOTPSecret, code, time.Now()) {
+		return ErrInvalidTOTP
+	}
+
+	none := ""
+	if err := db.UpdateUser(ctx, user.ID, &db.UserUpdate{TOTPSecret: &none}); err != nil {
+		return err
+	}
+
+	audit.Record(ctx, &model.AuditEntry{Action: model.AuditTOTPDisabled, UserID: &user.ID, Username: user.Username})
+	return nil
+}
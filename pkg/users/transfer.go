@@ -0,0 +1,61 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cgang/file-hub/pkg/audit"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+)
+
+// ErrTransferQuota is returned when a repository doesn't fit in the quota of the user it is transferred to
+var ErrTransferQuota = errors.New("repository exceeds the quota of the new owner")
+
+// TransferResult describes a repository transfer
+type TransferResult struct {
+	Repository *model.Repository `json:"repository"`
+	FromUserID int               `json:"from_user_id"`
+	Files      int               `json:"files"` // file rows given to the new owner
+	Bytes      int64             `json:"bytes"` // storage now counted against the quota of the new owner
+}
+
+// TransferRepository gives a repository to another user, such as the manager of someone leaving.
+// The repository must fit in the quota of the new owner unless force is set.
+// Transferring a repository to its owner again finishes a transfer that was interrupted.
+func TransferRepository(ctx context.Context, repo *model.Repository, to *model.User, force bool, actor *model.User, remoteAddr string) (*TransferResult, error) {
+	bytes, err := db.GetRepositoryStorageUsage(ctx, repo.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !perm.IsOwner(perm.User(to.ID), repo) && !force {
+		_, available, err := Usage(ctx, to.ID)
+		if err != nil {
+			return nil, err
+		}
+		if bytes > available {
+			return nil, fmt.Errorf("%w: %d bytes needed, %d available", ErrTransferQuota, bytes, available)
+		}
+	}
+
+	from := repo.OwnerID
+	files, err := db.TransferRepository(ctx, repo.ID, from, to.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	audit.Record(ctx, &model.AuditEntry{
+		Action:     model.AuditRepoTransferred,
+		UserID:     &to.ID,
+		Username:   to.Username,
+		ActorID:    &actor.ID,
+		RemoteAddr: remoteAddr,
+		Detail:     fmt.Sprintf("repository %s from user %d, %d files, %d bytes", repo.Name, from, files, bytes),
+	})
+
+	repo.OwnerID = to.ID
+	return &TransferResult{Repository: repo, FromUserID: from, Files: files, Bytes: bytes}, nil
+}
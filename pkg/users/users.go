@@ -6,17 +6,22 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/cgang/file-hub/pkg/config"
 	"github.com/cgang/file-hub/pkg/db"
 	"github.com/cgang/file-hub/pkg/model"
 )
 
 var (
-	userRealm string
+	userRealm     string
+	lockoutPolicy config.LockoutConfig
+	renameGrace   time.Duration
 )
 
-// NewService creates a new user service
-func Init(ctx context.Context, realm string) {
-	userRealm = realm
+// Init configures the realm and lockout policy used for authentication, and the grace period of renames
+func Init(ctx context.Context, cfg *config.Config) {
+	userRealm = cfg.Realm
+	lockoutPolicy = cfg.Security.Lockout
+	renameGrace = cfg.Security.RenameGrace
 }
 
 func HasAnyUser(ctx context.Context) (bool, error) {
@@ -44,6 +49,9 @@ type UpdateUserRequest struct {
 	LastLogin *time.Time `json:"last_login,omitempty"`
 	IsActive  *bool      `json:"is_active,omitempty"`
 	IsAdmin   *bool      `json:"is_admin,omitempty"`
+	Locale    *string    `json:"locale,omitempty"`
+	// Version is the version of the user the changes were made from, see db.UpdateUser
+	Version *int `json:"version,omitempty"`
 }
 
 // Create creates a new user with the provided details
@@ -101,6 +109,8 @@ func Update(ctx context.Context, id int, req *UpdateUserRequest) error {
 		LastLogin: req.LastLogin,
 		IsActive:  req.IsActive,
 		IsAdmin:   req.IsAdmin,
+		Locale:    req.Locale,
+		Version:   req.Version,
 	}
 
 	return db.UpdateUser(ctx, id, dbUpdate)
@@ -0,0 +1,50 @@
+package watch
+
+import (
+	"log"
+	"sync"
+)
+
+// streamBuffer is how many events may wait for a slow event stream before new ones are dropped
+const streamBuffer = 64
+
+var (
+	streamsMu sync.Mutex
+	streams   = map[int]map[chan *Event]bool{} // open event streams of each user
+)
+
+// Listen opens an event stream receiving the changes of the user's subscriptions with the websocket
+// channel. stop closes it.
+func Listen(userID int) (events <-chan *Event, stop func()) {
+	ch := make(chan *Event, streamBuffer)
+
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+	if streams[userID] == nil {
+		streams[userID] = map[chan *Event]bool{}
+	}
+	streams[userID][ch] = true
+
+	return ch, func() {
+		streamsMu.Lock()
+		defer streamsMu.Unlock()
+		delete(streams[userID], ch)
+		if len(streams[userID]) == 0 {
+			delete(streams, userID)
+		}
+	}
+}
+
+// publish hands an event to the open event streams of a user, dropping it for streams that are behind
+func publish(userID int, event *Event) {
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+
+	for ch := range streams[userID] {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Event stream of user %d is behind, dropping %s of %v", userID, event.Operation, event.Paths)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Package watch lets users subscribe to the changes of files and folders they can view, delivered
+// through the channels they choose: summarized in their activity digest, posted to a webhook, or pushed
+// to their open event streams. Changes are matched to subscriptions on the server, and access is checked
+// again on every delivery, so that nothing is sent once a share ends.
+package watch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/egress"
+	"github.com/cgang/file-hub/pkg/hooks"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+)
+
+const (
+	// EventChange is the event delivered for a change to a watched path
+	EventChange = "change"
+
+	// MaxSubscriptions is the most subscriptions a user may have
+	MaxSubscriptions = 100
+
+	// queueSize is how many changes may wait for delivery before new ones are dropped
+	queueSize = 1000
+)
+
+var (
+	// ErrInvalid is returned when a subscription is malformed
+	ErrInvalid = errors.New("invalid subscription")
+	// ErrForbidden is returned subscribing to a path the user has no access to
+	ErrForbidden = errors.New("path is not accessible to the user")
+)
+
+// queue holds changes waiting for delivery, nil until Start is called
+var queue chan *model.ChangeLog
+
+// Start delivers queued changes until ctx is done
+func Start(ctx context.Context) {
+	queue = make(chan *model.ChangeLog, queueSize)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case change := <-queue:
+				deliver(ctx, change)
+			}
+		}
+	}()
+}
+
+// Fire queues a recorded change for delivery to the subscriptions covering it.
+// Changes are dropped, with a log message, when the queue is full.
+func Fire(change *model.ChangeLog) {
+	if queue == nil {
+		return
+	}
+
+	select {
+	case queue <- change:
+	default:
+		log.Printf("Subscription queue full, dropping %s of %s", change.Operation, change.Path)
+	}
+}
+
+// Request describes a subscription to create
+type Request struct {
+	Path       string   `json:"path"`
+	Channels   []string `json:"channels"`
+	WebhookURL string   `json:"webhook_url,omitempty"`
+	Secret     string   `json:"secret,omitempty"` // generated for the webhook channel when empty
+}
+
+func (req *Request) validate() error {
+	if len(req.Channels) == 0 {
+		return fmt.Errorf("%w: at least one channel is required", ErrInvalid)
+	}
+	for i, channel := range req.Channels {
+		if !slices.Contains(model.Channels, channel) {
+			return fmt.Errorf("%w: unknown channel %q", ErrInvalid, channel)
+		}
+		if slices.Contains(req.Channels[:i], channel) {
+			return fmt.Errorf("%w: channel %q given twice", ErrInvalid, channel)
+		}
+	}
+
+	if !slices.Contains(req.Channels, model.ChannelWebhook) {
+		if req.WebhookURL != "" {
+			return fmt.Errorf("%w: webhook_url is only used by the webhook channel", ErrInvalid)
+		}
+		return nil
+	}
+	if err := egress.CheckURL(req.WebhookURL); err != nil {
+		return fmt.Errorf("%w: webhook_url must be an absolute http or https URL of a public host: %s", ErrInvalid, err)
+	}
+	return nil
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Subscribe subscribes the user to a file or folder of a repository they can view, replacing the channels
+// of an earlier subscription to it. The returned subscription carries the webhook secret, which is not shown again.
+func Subscribe(ctx context.Context, user *model.User, repo *model.Repository, req *Request) (*model.Subscription, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	p := model.CleanPath(req.Path)
+	res := &model.Resource{Repo: repo, Path: path.Clean("/" + p)}
+	if err := perm.Evaluate(ctx, perm.User(user.ID), res, perm.View); err != nil {
+		return nil, ErrForbidden
+	}
+	if p != "" {
+		if _, err := db.GetFile(ctx, repo.ID, p); err != nil {
+			return nil, fmt.Errorf("%w: %s not found", ErrInvalid, p)
+		}
+	}
+
+	existing, err := db.ListSubscriptions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= MaxSubscriptions && !slices.ContainsFunc(existing, func(sub *model.Subscription) bool {
+		return sub.RepoID == repo.ID && sub.Path == p
+	}) {
+		return nil, fmt.Errorf("%w: a user can have at most %d subscriptions", ErrInvalid, MaxSubscriptions)
+	}
+
+	sub := &model.Subscription{
+		UserID:     user.ID,
+		RepoID:     repo.ID,
+		Repo:       repo.Name,
+		Path:       p,
+		Channels:   req.Channels,
+		WebhookURL: req.WebhookURL,
+	}
+	if sub.HasChannel(model.ChannelWebhook) {
+		sub.Secret = req.Secret
+		if sub.Secret == "" {
+			if sub.Secret, err = generateSecret(); err != nil {
+				return nil, fmt.Errorf("failed to generate secret: %w", err)
+			}
+		}
+	}
+
+	if err := db.SaveSubscription(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Unsubscribe removes a subscription of the user
+func Unsubscribe(ctx context.Context, user *model.User, id int) error {
+	return db.DeleteSubscription(ctx, user.ID, id)
+}
+
+// List returns the subscriptions of the user, with the names of their repositories
+func List(ctx context.Context, user *model.User) ([]*model.Subscription, error) {
+	subs, err := db.ListSubscriptions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[int]string)
+	for _, sub := range subs {
+		name, ok := names[sub.RepoID]
+		if !ok {
+			if repo, err := db.GetRepositoryByID(ctx, sub.RepoID); err == nil {
+				name = repo.Name
+			}
+			names[sub.RepoID] = name
+		}
+		sub.Repo = name
+	}
+	return subs, nil
+}
+
+// Event is the JSON body of a change delivered to a webhook or an event stream
+type Event struct {
+	Event          string      `json:"event"`
+	SubscriptionID int         `json:"subscription_id"`
+	Repo           string      `json:"repo"`
+	Path           string      `json:"path"` // the watched path
+	Operation      string      `json:"operation"`
+	Paths          []string    `json:"paths"` // the changed path, preceded by the old path of a move
+	Version        string      `json:"version"`
+	Actor          hooks.Actor `json:"actor"`
+	Timestamp      time.Time   `json:"timestamp"`
+}
+
+// changedPaths returns the paths touched by a change
+func changedPaths(change *model.ChangeLog) []string {
+	if change.OldPath != nil {
+		return []string{*change.OldPath, change.Path}
+	}
+	return []string{change.Path}
+}
+
+// deliver sends a change to the webhooks and event streams of the subscriptions covering it.
+// Changes users made themselves are left out, as they are from digests.
+func deliver(ctx context.Context, change *model.ChangeLog) {
+	subs, err := db.ListRepoSubscriptions(ctx, change.RepoID)
+	if err != nil {
+		log.Printf("Failed to list subscriptions of repository %d: %s", change.RepoID, err)
+		return
+	}
+
+	paths := changedPaths(change)
+	var matched []*model.Subscription
+	for _, sub := range subs {
+		if sub.UserID != change.UserID && sub.Covers(paths...) &&
+			(sub.HasChannel(model.ChannelWebhook) || sub.HasChannel(model.ChannelWebSocket)) {
+			matched = append(matched, sub)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	repo, err := db.GetRepositoryByID(ctx, change.RepoID)
+	if err != nil {
+		log.Printf("Failed to get repository %d: %s", change.RepoID, err)
+		return
+	}
+	actor := hooks.Actor{ID: change.UserID}
+	if user, err := db.GetUserByID(ctx, change.UserID); err == nil {
+		actor.Username = user.Username
+	}
+
+	for _, sub := range matched {
+		res := &model.Resource{Repo: repo, Path: path.Clean("/" + sub.Path)}
+		if perm.Evaluate(ctx, perm.User(sub.UserID), res, perm.View) != nil {
+			continue
+		}
+
+		event := &Event{
+			Event:          EventChange,
+			SubscriptionID: sub.ID,
+			Repo:           repo.Name,
+			Path:           sub.Path,
+			Operation:      change.Operation,
+			Paths:          paths,
+			Version:        change.Version,
+			Actor:          actor,
+			Timestamp:      change.Timestamp,
+		}
+		if sub.HasChannel(model.ChannelWebSocket) {
+			publish(sub.UserID, event)
+		}
+		if sub.HasChannel(model.ChannelWebhook) {
+			if _, err := hooks.Send(ctx, sub.WebhookURL, sub.Secret, EventChange, event); err != nil {
+				log.Printf("Failed to deliver subscription %d: %s", sub.ID, err)
+			}
+		}
+	}
+}
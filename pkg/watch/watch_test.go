@@ -0,0 +1,54 @@
+package watch
+
+import (
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	for _, req := range []*Request{
+		{Channels: []string{model.ChannelDigest}},
+		{Channels: []string{model.ChannelWebSocket, model.ChannelDigest}},
+		{Channels: []string{model.ChannelWebhook}, WebhookURL: "https://ci.example.com/hook"},
+	} {
+		assert.NoError(t, req.validate(), req)
+	}
+
+	for _, req := range []*Request{
+		{},
+		{Channels: []string{"sms"}},
+		{Channels: []string{model.ChannelDigest, model.ChannelDigest}},
+		{Channels: []string{model.ChannelWebhook}},
+		{Channels: []string{model.ChannelWebhook}, WebhookURL: "ftp://ci.example.com/hook"},
+		{Channels: []string{model.ChannelDigest}, WebhookURL: "https://ci.example.com/hook"},
+	} {
+		assert.ErrorIs(t, req.validate(), ErrInvalid, req)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	events, stop := Listen(1)
+	other, stopOther := Listen(2)
+	defer stopOther()
+
+	event := &Event{Event: EventChange, Operation: "create", Paths: []string{"/docs/a.txt"}}
+	publish(1, event)
+	assert.Same(t, event, <-events)
+	assert.Empty(t, other)
+
+	// A stream that is behind drops events instead of holding up delivery
+	for range streamBuffer + 1 {
+		publish(1, event)
+	}
+	assert.Len(t, events, streamBuffer)
+
+	stop()
+	assert.NotContains(t, streams, 1)
+	assert.NotPanics(t, func() { publish(1, event) })
+}
+
+func TestFireWithoutStart(t *testing.T) {
+	assert.NotPanics(t, func() { Fire(&model.ChangeLog{Path: "/a.txt"}) })
+}
@@ -0,0 +1,130 @@
+package watermark
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+)
+
+// PDFType is the MIME type of PDF documents, rendered a page at a time
+const PDFType = "application/pdf"
+
+// maxErrorBytes is how much of a failed command's error output is reported
+const maxErrorBytes = 1024
+
+// ErrNoPage is returned rendering a page a document does not have
+var ErrNoPage = errors.New("no such page")
+
+// viewConfig holds the rendering settings, set by Init
+var viewConfig config.ViewConfig
+
+// Init sets up the rendering of PDF documents from the configuration
+func Init(cfg *config.Config) {
+	viewConfig = cfg.View
+}
+
+// ParsePage returns the page number asked for by a query parameter, the first page when it is empty
+func ParsePage(s string) (int, error) {
+	if s == "" {
+		return 1, nil
+	}
+	page, err := strconv.Atoi(s)
+	if err != nil || page < 1 {
+		return 0, fmt.Errorf("invalid page %q", s)
+	}
+	return page, nil
+}
+
+// pdfCommand returns the command rendering a page of a PDF document, nil if PDF rendering is disabled
+func pdfCommand(page int) []string {
+	if len(viewConfig.PDFCommand) == 0 {
+		return nil
+	}
+
+	command := make([]string, len(viewConfig.PDFCommand))
+	for i, arg := range viewConfig.PDFCommand {
+		command[i] = strings.ReplaceAll(arg, "{page}", strconv.Itoa(page))
+	}
+	return command
+}
+
+// Render writes a page of a file with the text lines tiled across it as JPEG. Images have a single page;
+// PDF documents are rendered by the configured command first.
+func Render(ctx context.Context, w io.Writer, r io.Reader, mimeType string, page int, lines []string) error {
+	if mimeType != PDFType {
+		if page != 1 {
+			return ErrNoPage
+		}
+		return Apply(w, r, lines)
+	}
+
+	command := pdfCommand(page)
+	if command == nil {
+		return fmt.Errorf("no rendering command for %s", mimeType)
+	}
+
+	image, err := renderPDF(ctx, command, r)
+	if err != nil {
+		return err
+	}
+	return Apply(w, image, lines)
+}
+
+// renderPDF pipes a PDF document to a rendering command and returns the image it writes, bounded by the
+// configured timeout. A command succeeding without output is taken as asked for a page past the end.
+func renderPDF(ctx context.Context, command []string, document io.Reader) (io.Reader, error) {
+	if viewConfig.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, viewConfig.Timeout)
+		defer cancel()
+	}
+
+	stdout := &limitedBuffer{limit: MaxSourceBytes}
+	stderr := &limitedBuffer{limit: maxErrorBytes}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = io.LimitReader(document, MaxSourceBytes)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second // don't wait on children of a killed command holding its output open
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s failed: %w: %s", command[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s failed: %w", command[0], err)
+	}
+
+	if stdout.Len() == 0 {
+		return nil, ErrNoPage
+	}
+	if stdout.over {
+		return nil, fmt.Errorf("%s wrote more than %d bytes", command[0], MaxSourceBytes)
+	}
+	return &stdout.Buffer, nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+	over  bool // more than limit bytes were written
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	room := b.limit - b.Len()
+	if room < len(p) {
+		b.over = true
+	}
+	if room > 0 {
+		b.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,81 @@
+package watermark
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setCommand sets the PDF rendering command for a test
+func setCommand(t *testing.T, command ...string) {
+	old := viewConfig
+	viewConfig = config.ViewConfig{PDFCommand: command}
+	t.Cleanup(func() { viewConfig = old })
+}
+
+func TestParsePage(t *testing.T) {
+	page, err := ParsePage("")
+	require.NoError(t, err)
+	assert.Equal(t, 1, page)
+
+	page, err = ParsePage("3")
+	require.NoError(t, err)
+	assert.Equal(t, 3, page)
+
+	for _, s := range []string{"0", "-1", "two"} {
+		_, err := ParsePage(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestPDFCommand(t *testing.T) {
+	setCommand(t)
+	assert.Nil(t, pdfCommand(1))
+
+	setCommand(t, "pdftoppm", "-f", "{page}", "-l", "{page}", "-")
+	assert.Equal(t, []string{"pdftoppm", "-f", "2", "-l", "2", "-"}, pdfCommand(2))
+}
+
+func TestRenderPDF(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("cat not available")
+	}
+
+	// cat stands in for the rendering command, writing a page image whatever document it is given
+	var page bytes.Buffer
+	require.NoError(t, png.Encode(&page, image.NewRGBA(image.Rect(0, 0, 200, 100))))
+	pagePath := filepath.Join(t.TempDir(), "page.png")
+	require.NoError(t, os.WriteFile(pagePath, page.Bytes(), 0o644))
+
+	setCommand(t, "cat", pagePath)
+	var out bytes.Buffer
+	require.NoError(t, Render(context.Background(), &out, bytes.NewBufferString("%PDF-1.4"), PDFType, 1, []string{"viewer"}))
+	img, err := jpeg.Decode(&out)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 200, 100), img.Bounds())
+
+	t.Run("No output", func(t *testing.T) {
+		setCommand(t, "cat", os.DevNull)
+		err := Render(context.Background(), &out, bytes.NewBufferString("%PDF-1.4"), PDFType, 5, nil)
+		assert.ErrorIs(t, err, ErrNoPage)
+	})
+
+	t.Run("Failing command", func(t *testing.T) {
+		setCommand(t, "cat", filepath.Join(t.TempDir(), "missing"))
+		assert.Error(t, Render(context.Background(), &out, bytes.NewBufferString("%PDF-1.4"), PDFType, 1, nil))
+	})
+
+	t.Run("Images have one page", func(t *testing.T) {
+		assert.ErrorIs(t, Render(context.Background(), &out, bytes.NewReader(page.Bytes()), "image/png", 2, nil), ErrNoPage)
+	})
+}
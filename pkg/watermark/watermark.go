@@ -0,0 +1,114 @@
+// Package watermark renders images and PDF documents with a visible, viewer specific watermark.
+package watermark
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // register decoders
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"time"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// MaxSourceBytes is the largest source image that will be rendered
+const MaxSourceBytes = 32 << 20
+
+// ContentType is the MIME type of rendered images
+const ContentType = "image/jpeg"
+
+// spacing between repeated watermark blocks, in pixels
+const (
+	tileWidth  = 240
+	tileHeight = 80
+)
+
+var ink = color.NRGBA{R: 255, G: 0, B: 0, A: 96}
+
+// Supported reports whether files of the MIME type can be rendered with a watermark
+func Supported(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	case PDFType:
+		return len(viewConfig.PDFCommand) > 0
+	default:
+		return false
+	}
+}
+
+// Lines returns the watermark text identifying a viewer at a point in time
+func Lines(viewer string, now time.Time) []string {
+	return []string{viewer, now.UTC().Format("2006-01-02 15:04:05 UTC")}
+}
+
+// Apply decodes an image, tiles the text lines across it and writes the result as JPEG
+func Apply(w io.Writer, r io.Reader, lines []string) error {
+	src, _, err := image.Decode(io.LimitReader(r, MaxSourceBytes))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+
+	stamp(dst, lines)
+	return jpeg.Encode(w, dst, &jpeg.Options{Quality: 85})
+}
+
+// stamp draws the lines repeatedly over the whole image, offsetting alternate rows
+// so the mark cannot be cropped away. The text is scaled up on large images.
+func stamp(dst *image.RGBA, lines []string) {
+	size := dst.Bounds().Size()
+	scale := max(1, size.X/800)
+
+	mask := textMask(lines)
+	if scale > 1 {
+		mb := mask.Bounds()
+		scaled := image.NewAlpha(image.Rect(0, 0, mb.Dx()*scale, mb.Dy()*scale))
+		xdraw.NearestNeighbor.Scale(scaled, scaled.Bounds(), mask, mb, draw.Src, nil)
+		mask = scaled
+	}
+
+	src := image.NewUniform(ink)
+	mb := mask.Bounds()
+	tw, th := tileWidth*scale, tileHeight*scale
+	for row, y := 0, 0; y < size.Y; row, y = row+1, y+th {
+		offset := (row % 2) * tw / 2
+		for x := -offset; x < size.X; x += tw {
+			r := image.Rect(x, y, x+mb.Dx(), y+mb.Dy())
+			draw.DrawMask(dst, r, src, image.Point{}, mask, image.Point{}, draw.Over)
+		}
+	}
+}
+
+// textMask renders the lines into an alpha mask
+func textMask(lines []string) *image.Alpha {
+	face := basicfont.Face7x13
+	lineHeight := face.Metrics().Height.Ceil()
+
+	width := 0
+	for _, line := range lines {
+		width = max(width, font.MeasureString(face, line).Ceil())
+	}
+
+	mask := image.NewAlpha(image.Rect(0, 0, width, lineHeight*len(lines)))
+	d := &font.Drawer{
+		Dst:  mask,
+		Src:  image.Opaque,
+		Face: face,
+	}
+	for i, line := range lines {
+		d.Dot = fixed.P(0, (i+1)*lineHeight-face.Descent)
+		d.DrawString(line)
+	}
+	return mask
+}
@@ -0,0 +1,63 @@
+package watermark
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApply(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	for y := range 300 {
+		for x := range 400 {
+			src.Set(x, y, color.White)
+		}
+	}
+
+	var in bytes.Buffer
+	require.NoError(t, png.Encode(&in, src))
+
+	var out bytes.Buffer
+	require.NoError(t, Apply(&out, &in, []string{"viewer@example.com", "2025-01-02 03:04:05 UTC"}))
+
+	img, err := jpeg.Decode(&out)
+	require.NoError(t, err)
+	assert.Equal(t, src.Bounds(), img.Bounds())
+
+	// The watermark must have changed some pixels from plain white
+	marked := 0
+	for y := range 300 {
+		for x := range 400 {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if r > 0xf000 && (g < 0xe000 || b < 0xe000) {
+				marked++
+			}
+		}
+	}
+	assert.Greater(t, marked, 100)
+
+	t.Run("Invalid image", func(t *testing.T) {
+		assert.Error(t, Apply(&out, bytes.NewBufferString("not an image"), nil))
+	})
+}
+
+func TestLines(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.FixedZone("X", 3600))
+	assert.Equal(t, []string{"viewer@example.com", "2025-01-02 02:04:05 UTC"}, Lines("viewer@example.com", now))
+}
+
+func TestSupported(t *testing.T) {
+	assert.True(t, Supported("image/png"))
+	assert.False(t, Supported("application/pdf"))
+
+	setCommand(t, "pdftoppm", "-f", "{page}", "-")
+	assert.True(t, Supported("application/pdf"))
+	assert.False(t, Supported("text/plain"))
+}
@@ -0,0 +1,535 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/cgang/file-hub/pkg/audit"
+	"github.com/cgang/file-hub/pkg/backfill"
+	"github.com/cgang/file-hub/pkg/bandwidth"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/lifecycle"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/netacl"
+	"github.com/cgang/file-hub/pkg/reindex"
+	"github.com/cgang/file-hub/pkg/skeleton"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/sync"
+	"github.com/cgang/file-hub/pkg/users"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerAdmin(r *gin.RouterGroup) {
+	r.Use(auth.RequireAdmin)
+	r.GET("/users", ListUsers)
+	r.PATCH("/users/:id", UpdateUser)
+	r.POST("/users/:id/unlock", UnlockUser)
+	r.POST("/users/:id/rename", RenameUser)
+	r.PUT("/users/:id/transfer_cap", SetTransferCap)
+	r.GET("/lockouts", ListLockouts)
+	r.DELETE("/lockouts/:addr", UnlockAddress)
+	r.GET("/audit", ListAudit)
+	r.GET("/operations/:id", GetOperation)
+	r.GET("/stats", GetStats)
+	r.GET("/usage", ListTransferUsage)
+	r.GET("/sync/clients", ListSyncClients)
+	r.PUT("/repos/:repo/network", UpdateRepoNetwork)
+	r.PUT("/repos/:repo/lifecycle", UpdateRepoLifecycle)
+	r.POST("/repos/:repo/transfer", TransferRepo)
+	r.POST("/repos/:repo/reindex", ReindexRepo)
+	r.POST("/template/apply", ApplyTemplate)
+}
+
+// ListUsers returns all users with their lockout state
+func ListUsers(c *gin.Context) {
+	list, err := users.List(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": list})
+}
+
+// UserEditRequest carries the account settings an administrator changes, and the version of the user
+// they were changed from
+type UserEditRequest struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	IsActive  *bool   `json:"is_active"`
+	IsAdmin   *bool   `json:"is_admin"`
+	Locale    *string `json:"locale"`
+	Version   *int    `json:"version" binding:"required"`
+}
+
+// UpdateUser changes the account settings of a user. Changes made from a version of the user that is no
+// longer current are refused with 409, the current user in the details, so that they are not lost silently.
+func UpdateUser(c *gin.Context) {
+	admin, _ := auth.GetAuthenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req UserEditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	err = users.Update(c, id, &users.UpdateUserRequest{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		IsActive:  req.IsActive,
+		IsAdmin:   req.IsAdmin,
+		Locale:    req.Locale,
+		Version:   req.Version,
+	})
+	if errors.Is(err, db.ErrUserChanged) {
+		e := apierr.Map(err)
+		if current, _ := db.GetUsersByIDs(c, []int{id}); len(current) == 1 {
+			e = e.WithDetails(map[string]any{"user": current[0]})
+		}
+		apierr.Send(c, e)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	updated, err := db.GetUsersByIDs(c, []int{id})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	} else if len(updated) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	audit.Record(c, &model.AuditEntry{
+		Action:     model.AuditUserUpdated,
+		ActorID:    &admin.ID,
+		UserID:     &id,
+		Username:   updated[0].Username,
+		RemoteAddr: c.ClientIP(),
+		Detail:     fmt.Sprintf("version %d", updated[0].Version),
+	})
+
+	c.JSON(http.StatusOK, updated[0])
+}
+
+// UnlockUser clears the failed login lockout of a user
+func UnlockUser(c *gin.Context) {
+	admin, _ := auth.GetAuthenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := users.Unlock(c, id, admin)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User " + user.Username + " unlocked"})
+}
+
+// RenameUser changes the username of a user, resetting their password
+func RenameUser(c *gin.Context) {
+	admin, _ := auth.GetAuthenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req users.RenameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	user, err := users.Get(c, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	renamed, err := users.Rename(c, user, &req, true, admin, c.ClientIP())
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	auth.DestroyUserSessions(renamed.ID)
+	c.JSON(http.StatusOK, renamed)
+}
+
+// ListLockouts returns source addresses with recent failed logins
+func ListLockouts(c *gin.Context) {
+	list, err := users.ListLockedAddresses(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list lockouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lockouts": list})
+}
+
+// UnlockAddress clears the failed login lockout of a source address
+func UnlockAddress(c *gin.Context) {
+	admin, _ := auth.GetAuthenticatedUser(c)
+
+	if err := users.UnlockAddress(c, c.Param("addr"), admin); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock address"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Address " + c.Param("addr") + " unlocked"})
+}
+
+// TransferCapRequest carries the monthly transfer cap of a user
+type TransferCapRequest struct {
+	CapBytes *int64 `json:"cap_bytes"` // 0 for no cap, null for bandwidth.monthly_cap
+}
+
+// SetTransferCap gives a user a monthly transfer cap of their own
+func SetTransferCap(c *gin.Context) {
+	admin, _ := auth.GetAuthenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req TransferCapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := bandwidth.SetCap(c, id, req.CapBytes); stor.IsNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	} else if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	detail := "default transfer cap"
+	if req.CapBytes != nil {
+		detail = fmt.Sprintf("transfer cap %d bytes", *req.CapBytes)
+	}
+	audit.Record(c, &model.AuditEntry{
+		Action:     model.AuditUserUpdated,
+		ActorID:    &admin.ID,
+		UserID:     &id,
+		RemoteAddr: c.ClientIP(),
+		Detail:     detail,
+	})
+
+	usage, err := bandwidth.Usage(c, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfer usage"})
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
+
+// ListTransferUsage reports the users who transferred the most in a month, the current one unless
+// the month query parameter gives another as YYYY-MM
+func ListTransferUsage(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	usage, err := bandwidth.List(c, c.Query("month"), limit)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"usage": usage})
+}
+
+// ListAudit returns audit log entries, newest first
+func ListAudit(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	entries, err := audit.List(c, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// GetOperation returns the changes and audit log entries recorded by the request with an operation ID,
+// as returned in its X-Operation-ID header and logged by the server
+func GetOperation(c *gin.Context) {
+	op, err := audit.GetOperation(c, c.Param("id"))
+	if stor.IsNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Operation not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get operation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, op)
+}
+
+// GetStats reports the progress of background work, such as computing missing checksums
+func GetStats(c *gin.Context) {
+	checksums, err := backfill.Status(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"checksums": checksums})
+}
+
+// ListSyncClients reports how far behind each device syncing repositories is, the furthest behind first,
+// so that stuck clients are spotted. The repo query parameter limits it to one repository.
+func ListSyncClients(c *gin.Context) {
+	repoID := 0
+	if name := c.Query("repo"); name != "" {
+		repo, err := stor.GetRepository(c, name)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+			return
+		}
+		repoID = repo.ID
+	}
+
+	clients, err := sync.ClientLags(c, repoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sync clients"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"clients": clients})
+}
+
+// RepoNetworkRequest carries the network access lists of a repository
+type RepoNetworkRequest struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// UpdateRepoNetwork replaces the network access lists of a repository
+func UpdateRepoNetwork(c *gin.Context) {
+	admin, _ := auth.GetAuthenticatedUser(c)
+
+	var req RepoNetworkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if _, err := netacl.Parse(req.Allow, req.Deny); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	repo, err := stor.GetRepository(c, c.Param("repo"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	if err := db.UpdateRepositoryNetwork(c, repo.ID, req.Allow, req.Deny); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update repository network"})
+		return
+	}
+
+	audit.Record(c, &model.AuditEntry{
+		Action:     model.AuditNetworkUpdated,
+		ActorID:    &admin.ID,
+		RemoteAddr: c.ClientIP(),
+		Detail:     fmt.Sprintf("repository %s allow=%v deny=%v", repo.Name, req.Allow, req.Deny),
+	})
+
+	repo.AllowNets, repo.DenyNets = req.Allow, req.Deny
+	c.JSON(http.StatusOK, repo)
+}
+
+// RepoLifecycleRequest carries the lifecycle policy of a repository
+type RepoLifecycleRequest struct {
+	ColdRoot  string `json:"cold_root"`  // one of storage.cold_roots, empty to remove the policy
+	AfterDays int    `json:"after_days"` // days a file goes unopened before it is moved
+}
+
+// UpdateRepoLifecycle sets the lifecycle policy of a repository
+func UpdateRepoLifecycle(c *gin.Context) {
+	admin, _ := auth.GetAuthenticatedUser(c)
+
+	var req RepoLifecycleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	repo, err := stor.GetRepository(c, c.Param("repo"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	if err := lifecycle.Set(c, repo, req.ColdRoot, req.AfterDays); err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	audit.Record(c, &model.AuditEntry{
+		Action:     model.AuditLifecycleSet,
+		ActorID:    &admin.ID,
+		RemoteAddr: c.ClientIP(),
+		Detail:     fmt.Sprintf("repository %s cold_root=%s after_days=%d", repo.Name, repo.ColdRoot, repo.ColdAfterDays),
+	})
+
+	c.JSON(http.StatusOK, repo)
+}
+
+// ReindexRepo starts rebuilding the search index and content types of a repository, replying with the job
+// to follow in the jobs API
+func ReindexRepo(c *gin.Context) {
+	admin, _ := auth.GetAuthenticatedUser(c)
+
+	repo, err := stor.GetRepository(c, c.Param("repo"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	job, err := reindex.Start(c, repo, admin.ID)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	audit.Record(c, &model.AuditEntry{
+		Action:     model.AuditRepoReindexed,
+		ActorID:    &admin.ID,
+		RemoteAddr: c.ClientIP(),
+		Detail:     fmt.Sprintf("repository %s job %s", repo.Name, job.ID),
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{"job": job, "status_url": "/api/sync/jobs/" + job.ID})
+}
+
+// TransferRepoRequest names the user a repository is given to
+type TransferRepoRequest struct {
+	To    string `json:"to" binding:"required"` // username of the new owner
+	Force bool   `json:"force"`                 // transfer even if the repository exceeds the quota of the new owner
+}
+
+// TransferRepo gives a repository to another user
+func TransferRepo(c *gin.Context) {
+	admin, _ := auth.GetAuthenticatedUser(c)
+
+	var req TransferRepoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	repo, err := stor.GetRepository(c, c.Param("repo"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	to, err := users.GetByUsername(c, req.To)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	result, err := users.TransferRepository(c, repo, to, req.Force, admin, c.ClientIP())
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ApplyTemplateRequest names the users whose home repositories get the template
+type ApplyTemplateRequest struct {
+	UserIDs []int `json:"user_ids"` // all active users if empty
+}
+
+// ApplyTemplate adds the folders and files of the template repository missing from home repositories
+func ApplyTemplate(c *gin.Context) {
+	admin, _ := auth.GetAuthenticatedUser(c)
+
+	var req ApplyTemplateRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+	}
+
+	var results []*skeleton.Result
+	if len(req.UserIDs) == 0 {
+		var err error
+		if results, err = skeleton.ApplyAll(c); err != nil {
+			apierr.Send(c, err)
+			return
+		}
+	}
+	for _, id := range req.UserIDs {
+		user, err := users.Get(c, id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("User %d not found", id)})
+			return
+		}
+
+		result, err := skeleton.ApplyUser(c, user)
+		if err != nil {
+			apierr.Send(c, err)
+			return
+		}
+		results = append(results, result)
+	}
+
+	created := 0
+	for _, result := range results {
+		created += result.Created
+	}
+	audit.Record(c, &model.AuditEntry{
+		Action:     model.AuditTemplateApplied,
+		ActorID:    &admin.ID,
+		RemoteAddr: c.ClientIP(),
+		Detail:     fmt.Sprintf("%d repositories, %d items created", len(results), created),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
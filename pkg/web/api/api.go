@@ -14,12 +14,42 @@ func Register(r *gin.RouterGroup) {
 	r.GET("/roots", auth.Roots)
 
 	r.POST("/setup", auth.Setup)
+	r.POST("/auth/login", auth.Login)
+	r.POST("/auth/logout", auth.Logout)
+	// Former paths of the above, kept for older clients
 	r.POST("/login", auth.Login)
 	r.POST("/logout", auth.Logout)
 
+	// Routes read by media tags, which cannot send credentials, also accept download tokens
+	registerPreview(r.Group("/preview", auth.AuthenticateDownload))
+	registerThumb(r.Group("/thumb", auth.AuthenticateDownload))
+
 	r.Use(auth.Authenticate)
+	r.GET("/auth/whoami", auth.Whoami)
+	r.GET("/auth/sessions", auth.ListSessions)
+	r.DELETE("/auth/sessions/:id", auth.RevokeSession)
 	r.GET("/hello", Hello)
 	r.POST("/scan_files", ScanFiles)
+
+	registerFiles(r.Group("/files"))
+	registerLinks(r.Group("/links"))
+	registerNotifications(r.Group("/notifications"))
+	registerView(r.Group("/view"))
+	registerReadme(r.Group("/readme"))
+	registerExpiry(r.Group("/expiry"))
+	registerOrganize(r.Group("/organize"))
+	registerSearch(r.Group("/search"))
+	registerTokens(r.Group("/tokens"))
+	registerWebhooks(r.Group("/webhooks"))
+	registerSubscriptions(r.Group("/subscriptions"))
+	registerRepos(r.Group("/repos"))
+	registerFederation(r.Group("/federation"))
+	registerFederated(r.Group("/federated"))
+	registerTrash(r.Group("/trash"))
+	registerTools(r.Group("/tools"))
+	registerProfile(r.Group("/profile"))
+	registerMe(r.Group("/me"))
+	registerAdmin(r.Group("/admin"))
 }
 
 func Hello(c *gin.Context) {
@@ -29,9 +59,14 @@ func Hello(c *gin.Context) {
 		return
 	}
 
+	home := user.Username
+	if repo, err := stor.GetHomeRepo(c, user); err == nil {
+		home = repo.Name // differs after a rename that kept the home repository
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Hello, " + user.Username,
-		"dav":     fmt.Sprintf("/dav/%s", user.Username),
+		"dav":     fmt.Sprintf("/dav/%s", home),
 	})
 }
 
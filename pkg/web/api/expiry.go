@@ -0,0 +1,129 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/expiry"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultUpcomingDays is the window of the upcoming expirations listing
+	DefaultUpcomingDays = 7
+	// MaxUpcoming is the most upcoming expirations returned at once
+	MaxUpcoming = 1000
+)
+
+func registerExpiry(r *gin.RouterGroup) {
+	r.GET("", ListExpiryRules)
+	r.PUT("", SetExpiryRule)
+	r.DELETE("", DeleteExpiryRule)
+	r.GET("/upcoming", ListUpcomingExpiry)
+}
+
+// getOwnedRepo returns the named repository of the user, or the home repository when name is empty
+func getOwnedRepo(c *gin.Context, user *model.User, name string) (*model.Repository, bool) {
+	var repo *model.Repository
+	var err error
+	if name == "" {
+		repo, err = stor.GetHomeRepo(c, user)
+	} else {
+		repo, err = db.GetRepositoryByNameAndOwner(c, name, user.ID)
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return nil, false
+	}
+	return repo, true
+}
+
+// ListExpiryRules returns the expiry rules of a repository
+func ListExpiryRules(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	repo, ok := getOwnedRepo(c, user, c.Query("repo"))
+	if !ok {
+		return
+	}
+
+	rules, err := expiry.List(c, repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list expiry rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// SetExpiryRule creates or updates the expiry rule of a folder
+func SetExpiryRule(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Repo       string `json:"repo"`
+		Path       string `json:"path"`
+		MaxAgeDays int    `json:"max_age_days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	repo, ok := getOwnedRepo(c, user, req.Repo)
+	if !ok {
+		return
+	}
+
+	rule, err := expiry.Set(c, user, repo, req.Path, req.MaxAgeDays)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rule": rule})
+}
+
+// DeleteExpiryRule removes the expiry rule of a folder
+func DeleteExpiryRule(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	repo, ok := getOwnedRepo(c, user, c.Query("repo"))
+	if !ok {
+		return
+	}
+
+	if err := expiry.Delete(c, user, repo, c.Query("path")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expiry rule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Expiry rule deleted"})
+}
+
+// ListUpcomingExpiry returns files of a repository that expire within the given number of days
+func ListUpcomingExpiry(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	repo, ok := getOwnedRepo(c, user, c.Query("repo"))
+	if !ok {
+		return
+	}
+
+	days, err := strconv.Atoi(c.DefaultQuery("days", strconv.Itoa(DefaultUpcomingDays)))
+	if err != nil || days < 0 || days > expiry.MaxAgeDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid days"})
+		return
+	}
+
+	files, err := expiry.Upcoming(c, repo, time.Now().AddDate(0, 0, days), MaxUpcoming)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list upcoming expirations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": files})
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/cgang/file-hub/pkg/federation"
+	"github.com/cgang/file-hub/pkg/sanitize"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/cgang/file-hub/pkg/web/serve"
+	"github.com/gin-gonic/gin"
+)
+
+func registerFederation(r *gin.RouterGroup) {
+	r.GET("/shares", ListFederatedShares)
+	r.POST("/shares", CreateFederatedShare)
+	r.DELETE("/shares/:id", DeleteFederatedShare)
+	r.GET("/incoming", ListIncomingShares)
+	r.POST("/incoming/:id/accept", AcceptIncomingShare)
+	r.DELETE("/incoming/:id", DeclineIncomingShare)
+}
+
+func registerFederated(r *gin.RouterGroup) {
+	r.GET("", ListMounts)
+	r.GET("/:name", GetFederatedFile)
+	r.GET("/:name/*path", GetFederatedFile)
+}
+
+// CreateFederatedShare shares one of the user's folders with a user of another server
+func CreateFederatedShare(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req federation.ShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	share, err := federation.Share(c, user, &req)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"share": share})
+}
+
+// ListFederatedShares returns the folders the user shared with users of other servers
+func ListFederatedShares(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	list, err := federation.List(c, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list federated shares"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shares": list})
+}
+
+// DeleteFederatedShare ends one of the user's federated shares
+func DeleteFederatedShare(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+
+	if err := federation.Unshare(c, user, id); err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share removed"})
+}
+
+// ListIncomingShares returns the folders users of other servers shared with the user
+func ListIncomingShares(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	list, err := federation.Incoming(c, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list incoming shares"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shares": list})
+}
+
+// AcceptIncomingShare accepts a folder shared with the user, mounting it under /federated
+func AcceptIncomingShare(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+
+	share, err := federation.Accept(c, user, id)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"share": share, "url": "/api/federated/" + share.Name})
+}
+
+// DeclineIncomingShare declines a folder shared with the user, or leaves it if accepted before
+func DeclineIncomingShare(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+
+	if err := federation.Decline(c, user, id); err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share declined"})
+}
+
+// ListMounts returns the accepted shares of other servers, the top level of the /federated namespace
+func ListMounts(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	mounts, err := federation.Mounts(c, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list federated shares"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shares": mounts})
+}
+
+// relayedHeaders are the response headers of the remote server passed on to the client
+var relayedHeaders = []string{"Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"}
+
+// GetFederatedFile reads a path of a mounted share through its server: directories as a listing,
+// files as their content
+func GetFederatedFile(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	resp, err := federation.Open(c.Request.Context(), user, c.Param("name"), c.Param("path"), c.Request.Header)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, key := range relayedHeaders {
+		if value := resp.Header.Get(key); value != "" {
+			c.Header(key, value)
+		}
+	}
+
+	// Content of another server gets the same protection as content stored here
+	contentType := resp.Header.Get("Content-Type")
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); sanitize.Active(mediaType) {
+		c.Header("Content-Security-Policy", serve.SandboxPolicy)
+	}
+
+	c.Status(resp.StatusCode)
+	if _, err := io.Copy(c.Writer, resp.Body); err != nil {
+		log.Printf("Failed to relay %s of federated share %s: %s", c.Param("path"), c.Param("name"), err)
+	}
+}
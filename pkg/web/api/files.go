@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/cgang/file-hub/pkg/bandwidth"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/netacl"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/sync"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerFiles(r *gin.RouterGroup) {
+	r.PUT("/content", netacl.RepoFilter, bandwidth.Limit, SaveContent)
+	r.PUT("/immutable", netacl.RepoFilter, SetImmutable)
+}
+
+// SaveContent saves the text of a file edited in the browser, if the file is still at the version
+// it was edited from. Saving over another version fails with a conflict carrying the current content.
+func SaveContent(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Repo     string  `json:"repo"`
+		Path     string  `json:"path"`
+		BaseETag string  `json:"base_etag"`
+		Content  *string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Repo == "" || req.Path == "" || req.Content == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if req.BaseETag == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "base_etag is required"})
+		return
+	}
+
+	repo, err := stor.GetRepository(c, req.Repo)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	res := &model.Resource{Repo: repo, Path: req.Path}
+	if err := perm.Evaluate(c, perm.User(user.ID), res, perm.Write); err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	file, version, err := sync.SaveText(c, repo, req.Path, req.BaseETag, []byte(*req.Content), user.ID)
+	if stor.IsNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	} else if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"etag": *file.Checksum, "version": version, "size": file.Size})
+}
+
+// SetImmutable marks a file immutable, so that it cannot be overwritten, moved or deleted, or clears the mark
+func SetImmutable(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Repo      string `json:"repo"`
+		Path      string `json:"path"`
+		Immutable *bool  `json:"immutable"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Repo == "" || req.Path == "" || req.Immutable == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	repo, err := stor.GetRepository(c, req.Repo)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	res := &model.Resource{Repo: repo, Path: req.Path}
+	if err := perm.Evaluate(c, perm.User(user.ID), res, perm.Manage); err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	file, err := stor.SetImmutable(c, res, *req.Immutable)
+	if stor.IsNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	} else if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, file)
+}
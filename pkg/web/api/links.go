@@ -0,0 +1,168 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cgang/file-hub/pkg/links"
+	"github.com/cgang/file-hub/pkg/qr"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerLinks(r *gin.RouterGroup) {
+	r.GET("", ListLinks)
+	r.POST("", CreateLink)
+	r.DELETE("/:id", RevokeLink)
+	r.POST("/:id/publish", PublishLink)
+	r.POST("/:id/short", ShortenLink)
+	r.GET("/:id/qr", LinkQRCode)
+}
+
+const (
+	// DefaultQRScale is the size in pixels of a QR code module unless the client asks another
+	DefaultQRScale = 8
+	// MaxQRScale is the largest module size a client may ask for
+	MaxQRScale = 32
+)
+
+// CreateLink creates a share link for one of the user's folders
+func CreateLink(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req links.CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	link, err := links.Create(c, user, &req)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	resp := gin.H{
+		"link": link,
+		"url":  "/s/" + link.Token,
+	}
+	if short := links.ShortURL(c.Request, link); short != "" {
+		resp["short_url"] = short
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// ListLinks returns the share links created by the user
+func ListLinks(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	list, err := links.List(c, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list links"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"links": list})
+}
+
+// RevokeLink deletes one of the user's share links
+func RevokeLink(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
+		return
+	}
+
+	if err := links.Revoke(c, user, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Link revoked"})
+}
+
+// PublishLink moves a snapshot link to the current content of its folder
+func PublishLink(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
+		return
+	}
+
+	link, err := links.Publish(c, user, id)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"link": link})
+}
+
+// ShortenLink gives one of the user's links a short URL redirecting to it
+func ShortenLink(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
+		return
+	}
+
+	link, err := links.Shorten(c, user, id)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"link":      link,
+		"short_url": links.ShortURL(c.Request, link),
+	})
+}
+
+// LinkQRCode returns a PNG QR code of one of the user's links, of its short URL if it has one
+func LinkQRCode(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
+		return
+	}
+
+	scale, err := strconv.Atoi(c.DefaultQuery("scale", strconv.Itoa(DefaultQRScale)))
+	if err != nil || scale < 1 || scale > MaxQRScale {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scale"})
+		return
+	}
+
+	link, err := links.GetOwned(c, user, id)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	target := links.ShortURL(c.Request, link)
+	if target == "" {
+		target = links.URL(c.Request, link)
+	}
+
+	code, err := qr.Encode(target)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode QR code"})
+		return
+	}
+	data, err := code.PNG(scale)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render QR code"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-cache")
+	c.Data(http.StatusOK, "image/png", data)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/cgang/file-hub/pkg/bandwidth"
+	"github.com/cgang/file-hub/pkg/users"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerMe(r *gin.RouterGroup) {
+	r.GET("/preferences", GetPreferences)
+	r.PATCH("/preferences", PatchPreferences)
+	r.GET("/usage", GetUsage)
+}
+
+// GetUsage returns the storage the user takes up and the bytes they transferred this month, with the
+// months before
+func GetUsage(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	used, available, err := users.Usage(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get storage usage"})
+		return
+	}
+
+	transfer, err := bandwidth.Usage(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfer usage"})
+		return
+	}
+
+	history, err := bandwidth.History(c, user.ID, bandwidth.MaxHistory)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfer usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"storage":  gin.H{"used_bytes": used, "available_bytes": available},
+		"transfer": transfer,
+		"history":  history,
+	})
+}
+
+// GetPreferences returns the settings the user's clients keep on the server
+func GetPreferences(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	prefs, err := users.GetPreferences(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// PatchPreferences merges a JSON merge patch into the user's preferences, returning all of them
+func PatchPreferences(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	patch, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, users.MaxPreferencesSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierr.Send(c, users.ErrPreferencesTooLarge)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	prefs, err := users.PatchPreferences(c, user.ID, patch)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
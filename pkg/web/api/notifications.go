@@ -0,0 +1,153 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cgang/file-hub/pkg/notify"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerNotifications(r *gin.RouterGroup) {
+	r.GET("", ListNotifications)
+	r.POST("/read", MarkNotificationsRead)
+	r.GET("/routes", ListNotifyRoutes)
+	r.POST("/routes", CreateNotifyRoute)
+	r.PATCH("/routes/:id", UpdateNotifyRoute)
+	r.DELETE("/routes/:id", DeleteNotifyRoute)
+}
+
+// ListNotifications returns the user's notifications, newest first
+func ListNotifications(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	unread := c.Query("unread") == "true"
+
+	list, err := notify.List(c, user.ID, unread, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": list})
+}
+
+// MarkNotificationsRead marks the given notifications as read
+func MarkNotificationsRead(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := notify.MarkRead(c, user.ID, req.IDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notifications updated"})
+}
+
+// ListNotifyRoutes returns the user's notification routes, with the outcome of their latest delivery,
+// and the transports routes can be created for
+func ListNotifyRoutes(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	routes, err := notify.ListRoutes(c, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list notification routes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"routes": routes, "transports": notify.Transports()})
+}
+
+// CreateNotifyRoute adds a route delivering the user's notifications through a transport
+func CreateNotifyRoute(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req notify.RouteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	route, err := notify.CreateRoute(c, user, &req)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"route": route})
+}
+
+// notifyRouteID parses the notification route ID of the request path
+func notifyRouteID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// sendNotifyRouteError replies with the status of a failed notification route update
+func sendNotifyRouteError(c *gin.Context, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+		return
+	}
+	apierr.Send(c, err)
+}
+
+// UpdateNotifyRoute pauses or resumes the deliveries through a notification route
+func UpdateNotifyRoute(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	id, ok := notifyRouteID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Active *bool `json:"active"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Active == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := notify.SetRouteActive(c, user, id, *req.Active); err != nil {
+		sendNotifyRouteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": id, "active": *req.Active})
+}
+
+// DeleteNotifyRoute removes a notification route of the user
+func DeleteNotifyRoute(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	id, ok := notifyRouteID(c)
+	if !ok {
+		return
+	}
+
+	if err := notify.DeleteRoute(c, user, id); err != nil {
+		sendNotifyRouteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Route deleted"})
+}
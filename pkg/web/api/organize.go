@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/cgang/file-hub/pkg/organize"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerOrganize(r *gin.RouterGroup) {
+	r.GET("", ListOrganizeRules)
+	r.PUT("", SetOrganizeRule)
+	r.DELETE("", DeleteOrganizeRule)
+}
+
+// ListOrganizeRules returns the inbox organize rules of a repository
+func ListOrganizeRules(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	repo, ok := getOwnedRepo(c, user, c.Query("repo"))
+	if !ok {
+		return
+	}
+
+	rules, err := organize.List(c, repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list organize rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// SetOrganizeRule creates or updates the organize rule of an inbox folder
+func SetOrganizeRule(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Repo string `json:"repo"`
+		organize.Request
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	repo, ok := getOwnedRepo(c, user, req.Repo)
+	if !ok {
+		return
+	}
+
+	rule, err := organize.Set(c, user, repo, &req.Request)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rule": rule})
+}
+
+// DeleteOrganizeRule removes the organize rule of an inbox folder
+func DeleteOrganizeRule(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	repo, ok := getOwnedRepo(c, user, c.Query("repo"))
+	if !ok {
+		return
+	}
+
+	if err := organize.Delete(c, user, repo, c.Query("path")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organize rule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Organize rule deleted"})
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/cgang/file-hub/pkg/bandwidth"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/netacl"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/sanitize"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/cgang/file-hub/pkg/web/serve"
+	"github.com/gin-gonic/gin"
+)
+
+func registerPreview(r *gin.RouterGroup) {
+	r.GET("/:repo/*path", netacl.RepoFilter, bandwidth.Limit, PreviewFile)
+}
+
+// PreviewFile shows a file in the browser. HTML and SVG documents are sanitized first, and every
+// response is sandboxed, so user content never runs with the application's origin.
+// Preview URLs mirror the repository layout, so relative links between previewed files keep working.
+func PreviewFile(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	repo, err := stor.GetRepository(c, c.Param("repo"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	res := &model.Resource{Repo: repo, Path: strings.TrimSuffix(c.Param("path"), "/")}
+	if err := perm.Evaluate(c, perm.User(user.ID), res, perm.Read); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	file, err := stor.GetFileInfo(c, res)
+	if err != nil || file.IsDir {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if !auth.CheckDownloadGrant(c, repo, file.Path) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Download token is not valid for this file"})
+		return
+	}
+
+	reader, err := stor.OpenFile(c, res)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		return
+	}
+	defer reader.Close()
+
+	c.Header("Content-Security-Policy", serve.SandboxPolicy)
+	c.Header("Cache-Control", "private, no-cache")
+	if !sanitize.Supported(file.ContentType()) {
+		serve.File(c, file, reader)
+		return
+	}
+	if file.Size > sanitize.MaxSourceBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large to preview"})
+		return
+	}
+
+	// Links from the repository root are kept under the preview of the repository
+	root := strings.TrimSuffix(c.Request.URL.Path, c.Param("path"))
+	rewrite := func(link string) string {
+		if strings.HasPrefix(link, "/") && !strings.HasPrefix(link, "//") {
+			return root + link
+		}
+		return link
+	}
+
+	var buf bytes.Buffer
+	if err := sanitize.Document(&buf, reader, file.ContentType(), rewrite); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to sanitize file"})
+		return
+	}
+
+	contentType := file.ContentType()
+	if contentType != "image/svg+xml" {
+		contentType = "text/html; charset=utf-8"
+	}
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
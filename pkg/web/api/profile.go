@@ -0,0 +1,343 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/digest"
+	"github.com/cgang/file-hub/pkg/i18n"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/users"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerProfile(r *gin.RouterGroup) {
+	r.GET("/locale", GetLocale)
+	r.PUT("/locale", SetLocale)
+	r.PUT("/username", SetUsername)
+	r.POST("/totp", NewTOTPSecret)
+	r.PUT("/totp", EnableTOTP)
+	r.DELETE("/totp", DisableTOTP)
+	r.GET("/app-passwords", ListAppPasswords)
+	r.POST("/app-passwords", CreateAppPassword)
+	r.DELETE("/app-passwords/:id", RevokeAppPassword)
+	r.GET("/digest", GetDigest)
+	r.PUT("/digest", SetDigest)
+	r.POST("/follows", FollowFolder)
+	r.DELETE("/follows", UnfollowFolder)
+}
+
+// GetLocale returns the user's preferred locale and the supported ones
+func GetLocale(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	current, err := users.Get(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"locale":    current.Locale,
+		"supported": i18n.Supported(),
+	})
+}
+
+// SetLocale changes the locale used for the user's notifications.
+// An empty locale resets it to the default.
+func SetLocale(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Locale string `json:"locale"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	locale := i18n.Match(req.Locale)
+	if locale == "" && req.Locale != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported locale"})
+		return
+	}
+
+	if err := users.Update(c, user.ID, &users.UpdateUserRequest{Locale: &locale}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update locale"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"locale": locale})
+}
+
+// SetUsername renames the user, who confirms with their current password.
+// All their sessions end, so they log in again with the new name.
+func SetUsername(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req users.RenameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	current, err := users.Get(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	renamed, err := users.Rename(c, current, &req, false, current, c.ClientIP())
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	auth.DestroyUserSessions(renamed.ID)
+	c.JSON(http.StatusOK, renamed)
+}
+
+// NewTOTPSecret generates a secret for the user's authenticator app. Nothing changes until
+// the user enables it with a code the app generated from it.
+func NewTOTPSecret(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	secret, err := users.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"url":    users.TOTPURL(secret, user.Username),
+	})
+}
+
+// EnableTOTP turns on two-factor login with a secret from NewTOTPSecret
+func EnableTOTP(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Secret string `json:"secret" binding:"required"`
+		Code   string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	current, err := users.Get(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	if err := users.EnableTOTP(c, current, req.Secret, req.Code); err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"totp_enabled": true})
+}
+
+// DisableTOTP turns off two-factor login, confirmed with a current code
+func DisableTOTP(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	current, err := users.Get(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	if err := users.DisableTOTP(c, current, req.Code); err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"totp_enabled": false})
+}
+
+// ListAppPasswords returns the app passwords of the user, with when each was last used
+func ListAppPasswords(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	passwords, err := users.ListAppPasswords(c, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list app passwords"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"app_passwords": passwords})
+}
+
+// CreateAppPassword generates a password for a WebDAV or sync client, returned only in this response.
+// It needs a session, so that a client authenticated by an app password cannot create more of them.
+func CreateAppPassword(c *gin.Context) {
+	if _, ok := auth.GetSessionUser(c); !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "App passwords can only be created after logging in"})
+		return
+	}
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	current, err := users.Get(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	password, app, err := users.CreateAppPassword(c, current, req.Name, c.ClientIP())
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"app_password": app, "password": password})
+}
+
+// RevokeAppPassword deletes an app password, so the client using it can no longer authenticate
+func RevokeAppPassword(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid app password ID"})
+		return
+	}
+
+	if err := users.RevokeAppPassword(c, user, id, c.ClientIP()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "App password not found"})
+			return
+		}
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "App password revoked"})
+}
+
+// GetDigest returns how often the user receives an activity digest and the folders they follow
+func GetDigest(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	current, err := users.Get(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	follows, err := digest.List(c, current)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list followed folders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"digest":  current.Digest,
+		"follows": follows,
+	})
+}
+
+// SetDigest changes how often the user receives an activity digest: daily, weekly or never when empty
+func SetDigest(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Digest string `json:"digest"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := digest.SetSchedule(c, user, req.Digest, time.Now()); err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"digest": req.Digest})
+}
+
+// getFollowedRepo returns the named repository, or the user's home repository when name is empty.
+// Access to it is checked by the digest package.
+func getFollowedRepo(c *gin.Context, user *model.User, name string) (*model.Repository, bool) {
+	var repo *model.Repository
+	var err error
+	if name == "" {
+		repo, err = stor.GetHomeRepo(c, user)
+	} else {
+		repo, err = stor.GetRepository(c, name)
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return nil, false
+	}
+	return repo, true
+}
+
+// FollowFolder adds a folder the user owns or that is shared with them to their activity digest
+func FollowFolder(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Repo string `json:"repo"`
+		Path string `json:"path"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	repo, ok := getFollowedRepo(c, user, req.Repo)
+	if !ok {
+		return
+	}
+
+	follow, err := digest.Follow(c, user, repo, req.Path)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"follow": follow})
+}
+
+// UnfollowFolder removes a folder from the user's activity digest
+func UnfollowFolder(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	repo, ok := getFollowedRepo(c, user, c.Query("repo"))
+	if !ok {
+		return
+	}
+
+	if err := digest.Unfollow(c, user, repo, c.Query("path")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Followed folder not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Folder unfollowed"})
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/readme"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerReadme(r *gin.RouterGroup) {
+	r.GET("", GetReadme)
+}
+
+// GetReadme returns the readme of a folder rendered as sanitized HTML. Relative links and images
+// point at the preview of the files they name.
+func GetReadme(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	repo, err := stor.GetRepository(c, c.Query("repo"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	dir := path.Join("/", c.Query("path"))
+	if err := perm.Evaluate(c, perm.User(user.ID), &model.Resource{Repo: repo, Path: dir}, perm.Read); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	rendered, err := readme.Render(c, repo, dir, readmeLinks(repo, dir))
+	switch {
+	case errors.Is(err, readme.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder has no readme"})
+	case errors.Is(err, readme.ErrTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Readme too large to render"})
+	case err != nil:
+		log.Printf("Failed to render readme of %s: %s", dir, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render readme"})
+	default:
+		c.Header("Cache-Control", "private, no-cache")
+		c.Header("ETag", `"`+rendered.ETag+`"`)
+		c.JSON(http.StatusOK, rendered)
+	}
+}
+
+// readmeLinks points links of a readme to files of the repository at their preview
+func readmeLinks(repo *model.Repository, dir string) func(string) string {
+	return func(link string) string {
+		u, err := url.Parse(link)
+		if err != nil {
+			return ""
+		}
+		if u.Scheme != "" || u.Host != "" || u.Path == "" {
+			return link // another site, or a fragment of the readme itself
+		}
+
+		if !path.IsAbs(u.Path) {
+			u.Path = path.Join(dir, u.Path)
+		}
+		u.Path = "/api/preview/" + repo.Name + path.Clean(u.Path)
+		u.RawPath = ""
+		return u.String()
+	}
+}
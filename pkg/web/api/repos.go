@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/netacl"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ManifestVersionHeader carries the repository version a manifest was started at, to pass as since next time
+	ManifestVersionHeader = "X-Manifest-Version"
+	// manifestFlushEvery is how many manifest entries are buffered before flushing to the client
+	manifestFlushEvery = 500
+)
+
+func registerRepos(r *gin.RouterGroup) {
+	r.GET("/:repo/manifest", netacl.RepoFilter, GetManifest)
+}
+
+// ManifestEntry is a line of an integrity manifest
+type ManifestEntry struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	Checksum string    `json:"checksum"` // hex SHA-256, empty until computed
+}
+
+// manifestWriter writes manifest entries in one of the supported formats
+type manifestWriter interface {
+	begin() error
+	write(entry *ManifestEntry) error
+	fail(msg string) error // ends a manifest that could not be completed
+	flush()
+}
+
+type jsonlManifest struct {
+	w   gin.ResponseWriter
+	enc *json.Encoder
+}
+
+func (m *jsonlManifest) begin() error {
+	return nil
+}
+
+func (m *jsonlManifest) write(entry *ManifestEntry) error {
+	return m.enc.Encode(entry)
+}
+
+func (m *jsonlManifest) fail(msg string) error {
+	return m.enc.Encode(gin.H{"error": msg})
+}
+
+func (m *jsonlManifest) flush() {
+	m.w.Flush()
+}
+
+type csvManifest struct {
+	w   gin.ResponseWriter
+	csv *csv.Writer
+}
+
+func (m *csvManifest) begin() error {
+	return m.csv.Write([]string{"path", "size", "mtime", "checksum"})
+}
+
+func (m *csvManifest) write(entry *ManifestEntry) error {
+	return m.csv.Write([]string{
+		entry.Path,
+		strconv.FormatInt(entry.Size, 10),
+		entry.ModTime.UTC().Format(time.RFC3339),
+		entry.Checksum,
+	})
+}
+
+func (m *csvManifest) fail(msg string) error {
+	return m.csv.Write([]string{"error", msg})
+}
+
+func (m *csvManifest) flush() {
+	m.csv.Flush()
+	m.w.Flush()
+}
+
+// GetManifest streams the path, size, modification time and checksum of every file of a repository,
+// or of those changed since a version, for comparing a mirror against it. Owners and administrators
+// may read it, as CSV or as newline delimited JSON. A manifest failing midway ends with an error line.
+func GetManifest(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	repo, err := stor.GetRepository(c, c.Param("repo"))
+	if err != nil || (!perm.IsOwner(perm.User(user.ID), repo) && !user.IsAdmin) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	var mw manifestWriter
+	var contentType string
+	format := c.DefaultQuery("format", "jsonl")
+	switch format {
+	case "jsonl":
+		mw, contentType = &jsonlManifest{w: c.Writer, enc: json.NewEncoder(c.Writer)}, "application/x-ndjson"
+	case "csv":
+		mw, contentType = &csvManifest{w: c.Writer, csv: csv.NewWriter(c.Writer)}, "text/csv; charset=utf-8"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, use csv or jsonl"})
+		return
+	}
+
+	// Files changed while streaming are caught by the next manifest since this version
+	version, err := db.GetCurrentVersion(c, repo.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get repository version"})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-manifest.%s"`, repo.Name, format))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header(ManifestVersionHeader, version.CurrentVersion)
+	c.Status(http.StatusOK)
+
+	written := 0
+	err = mw.begin()
+	if err == nil {
+		err = db.StreamRepoFiles(c, repo.ID, c.Query("since"), func(file *model.FileObject) error {
+			entry := &ManifestEntry{Path: file.Path, Size: file.Size, ModTime: file.ModTime}
+			if file.Checksum != nil {
+				entry.Checksum = *file.Checksum
+			}
+			if err := mw.write(entry); err != nil {
+				return err
+			}
+
+			if written++; written%manifestFlushEvery == 0 {
+				mw.flush()
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to stream manifest of %s: %s", repo.Name, err)
+		_ = mw.fail("Failed to list repository files")
+	}
+	mw.flush()
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cgang/file-hub/pkg/search"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerSearch(r *gin.RouterGroup) {
+	r.GET("", Search)
+	r.PUT("/ocr", SetOCR)
+}
+
+// Search returns the files of a repository matching a query by name or extracted text
+func Search(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	repo, ok := getOwnedRepo(c, user, c.Query("repo"))
+	if !ok {
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(search.MaxResults)))
+	hits, err := search.Search(c, repo, c.Query("q"), limit)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": hits})
+}
+
+// SetOCR enables or disables text extraction for a repository
+func SetOCR(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Repo    string `json:"repo"`
+		Enabled *bool  `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	repo, ok := getOwnedRepo(c, user, req.Repo)
+	if !ok {
+		return
+	}
+
+	if err := search.SetOCR(c, user, repo, *req.Enabled); err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"repo": repo.Name, "ocr": repo.OCR})
+}
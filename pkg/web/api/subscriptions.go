@@ -0,0 +1,160 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/watch"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+const (
+	// streamPingInterval is how often idle event streams are pinged, so that proxies keep them open
+	streamPingInterval = 30 * time.Second
+	// streamWriteTimeout bounds sending an event or ping to a client
+	streamWriteTimeout = 10 * time.Second
+)
+
+func registerSubscriptions(r *gin.RouterGroup) {
+	r.GET("", ListSubscriptions)
+	r.POST("", Subscribe)
+	r.DELETE("/:id", Unsubscribe)
+	r.GET("/stream", StreamSubscriptions)
+}
+
+// ListSubscriptions returns the user's subscriptions, without their webhook secrets
+func ListSubscriptions(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	subs, err := watch.List(c, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list subscriptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscriptions": subs})
+}
+
+// Subscribe subscribes the user to a file or folder they own or that is shared with them.
+// For the webhook channel, the response carries the signing secret, which is not shown again.
+func Subscribe(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Repo string `json:"repo"`
+		watch.Request
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	repo, ok := getFollowedRepo(c, user, req.Repo)
+	if !ok {
+		return
+	}
+
+	sub, err := watch.Subscribe(c, user, repo, &req.Request)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	resp := gin.H{"subscription": sub}
+	if sub.Secret != "" {
+		resp["secret"] = sub.Secret
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Unsubscribe removes a subscription of the user
+func Unsubscribe(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+		return
+	}
+
+	if err := watch.Unsubscribe(c, user, id); errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete subscription"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Subscription deleted"})
+}
+
+// StreamSubscriptions upgrades to a WebSocket sending the changes of the user's subscriptions with
+// the websocket channel as JSON messages, until the client goes away
+func StreamSubscriptions(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	// Accept answers requests it refuses itself, including those from other origins
+	conn, err := websocket.Accept(upgradeWriter{c.Writer}, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close(websocket.StatusInternalError, "")
+
+	events, stop := watch.Listen(user.ID)
+	defer stop()
+
+	// Nothing is read from the client, but reading handles its pings and close
+	ctx := conn.CloseRead(c.Request.Context())
+	ticker := time.NewTicker(streamPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			if err := writeTimeout(ctx, func(ctx context.Context) error { return wsjson.Write(ctx, conn, event) }); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := writeTimeout(ctx, conn.Ping); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// upgradeWriter lets websocket.Accept take over the connection under gin, which refuses to hijack
+// a connection once the response is written. The switch of protocols is written through gin, so that
+// gin writes nothing more, and the connection is hijacked from the server's own writer.
+type upgradeWriter struct {
+	gin.ResponseWriter
+}
+
+func (w upgradeWriter) WriteHeader(code int) {
+	w.ResponseWriter.WriteHeader(code)
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if inner, ok := w.ResponseWriter.(interface{ Unwrap() http.ResponseWriter }); ok {
+		return http.NewResponseController(inner.Unwrap()).Hijack()
+	}
+	return w.ResponseWriter.Hijack()
+}
+
+// writeTimeout sends something to a WebSocket client, giving up after streamWriteTimeout
+func writeTimeout(ctx context.Context, write func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, streamWriteTimeout)
+	defer cancel()
+	return write(ctx)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/cgang/file-hub/pkg/bandwidth"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/netacl"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/thumb"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerThumb(r *gin.RouterGroup) {
+	r.GET("/:repo/*path", netacl.RepoFilter, bandwidth.Limit, Thumbnail)
+}
+
+// Thumbnail returns a JPEG thumbnail of an image
+func Thumbnail(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	repo, err := stor.GetRepository(c, c.Param("repo"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	res := &model.Resource{Repo: repo, Path: strings.TrimSuffix(c.Param("path"), "/")}
+	if err := perm.Evaluate(c, perm.User(user.ID), res, perm.Read); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	file, err := stor.GetFileInfo(c, res)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if !auth.CheckDownloadGrant(c, repo, file.Path) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Download token is not valid for this file"})
+		return
+	}
+
+	if file.IsDir || !thumb.Supported(file.ContentType()) || file.Size > thumb.MaxSourceBytes {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No thumbnail available"})
+		return
+	}
+
+	reader, err := stor.OpenFile(c, res)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		return
+	}
+	defer reader.Close()
+
+	var buf bytes.Buffer
+	if err := thumb.Generate(&buf, reader, thumb.DefaultSize); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to generate thumbnail"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Data(http.StatusOK, thumb.ContentType, buf.Bytes())
+}
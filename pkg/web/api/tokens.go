@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/thumb"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerTokens(r *gin.RouterGroup) {
+	r.POST("/download", IssueDownloadToken)
+}
+
+// DownloadTokenResponse carries a download token and URLs of the file that already include it
+type DownloadTokenResponse struct {
+	Token        string    `json:"token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	DownloadURL  string    `json:"download_url,omitempty"` // only for repositories of the user
+	PreviewURL   string    `json:"preview_url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+}
+
+// IssueDownloadToken issues a short-lived token for reading one file, for use in media tags which
+// cannot send credentials
+func IssueDownloadToken(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Repo string `json:"repo"`
+		Path string `json:"path"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Repo == "" || req.Path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	repo, err := stor.GetRepository(c, req.Repo)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	res := &model.Resource{Repo: repo, Path: req.Path}
+	if err := perm.Evaluate(c, perm.User(user.ID), res, perm.Read); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	file, err := stor.GetFileInfo(c, res)
+	if err != nil || file.IsDir {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	token, expires := auth.IssueDownloadToken(c, user, repo, file.Path)
+	query := url.Values{auth.DownloadTokenParam: {token}}.Encode()
+	resp := &DownloadTokenResponse{
+		Token:      token,
+		ExpiresAt:  expires,
+		PreviewURL: "/api/preview/" + url.PathEscape(repo.Name) + (&url.URL{Path: file.Path}).EscapedPath() + "?" + query,
+	}
+	if perm.IsOwner(perm.User(user.ID), repo) {
+		resp.DownloadURL = "/api/sync/download?" + url.Values{
+			"repo":                  {repo.Name},
+			"path":                  {file.Path},
+			auth.DownloadTokenParam: {token},
+		}.Encode()
+	}
+	if thumb.Supported(file.ContentType()) && file.Size <= thumb.MaxSourceBytes {
+		resp.ThumbnailURL = "/api/thumb/" + url.PathEscape(repo.Name) + (&url.URL{Path: file.Path}).EscapedPath() + "?" + query
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
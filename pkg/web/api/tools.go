@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/dupes"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerTools(r *gin.RouterGroup) {
+	r.GET("/duplicates", FindDuplicates)
+	r.POST("/duplicates", ResolveDuplicates)
+}
+
+// getToolRepos returns the named repository of the user, or all of the user's repositories when name is empty
+func getToolRepos(c *gin.Context, user *model.User, name string) ([]*model.Repository, bool) {
+	if name != "" {
+		repo, ok := getOwnedRepo(c, user, name)
+		if !ok {
+			return nil, false
+		}
+		return []*model.Repository{repo}, true
+	}
+
+	repos, err := db.ListRepositories(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list repositories"})
+		return nil, false
+	}
+	return repos, true
+}
+
+// FindDuplicates reports groups of files with identical content in a repository or across the user's repositories
+func FindDuplicates(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	repos, ok := getToolRepos(c, user, c.Query("repo"))
+	if !ok {
+		return
+	}
+
+	minSize, err := strconv.ParseInt(c.DefaultQuery("min_size", "1"), 10, 64)
+	if err != nil || minSize < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_size"})
+		return
+	}
+
+	report, err := dupes.Find(c, repos, minSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find duplicates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ResolveDuplicates deletes selected copies of duplicated content or replaces them with dedup references
+func ResolveDuplicates(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req dupes.ResolveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	repos, ok := getToolRepos(c, user, "")
+	if !ok {
+		return
+	}
+
+	results, err := dupes.Resolve(c, user, repos, &req)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
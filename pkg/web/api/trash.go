@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/sync"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerTrash(r *gin.RouterGroup) {
+	r.GET("", ListTrash)
+	r.POST("/:id/restore", RestoreTrash)
+}
+
+// ListTrash returns the trashed files of a repository
+func ListTrash(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	repo, ok := getOwnedRepo(c, user, c.Query("repo"))
+	if !ok {
+		return
+	}
+
+	items, err := stor.ListTrash(c, repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// RestoreTrash moves a trashed file back to its original path
+func RestoreTrash(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	repo, ok := getOwnedRepo(c, user, c.Query("repo"))
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trash item ID"})
+		return
+	}
+
+	item, err := stor.RestoreTrash(c, repo, id)
+	if err != nil {
+		if errors.Is(err, stor.ErrRestoreConflict) {
+			apierr.Send(c, err)
+		} else {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trash item not found"})
+		}
+		return
+	}
+
+	if err := sync.RecordChange(c, repo.ID, "create", item.Path, user.ID); err != nil {
+		log.Printf("Failed to record restore of %s: %s", item.Path, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"item": item})
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/bandwidth"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/netacl"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/watermark"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerView(r *gin.RouterGroup) {
+	r.GET("/:repo/*path", netacl.RepoFilter, bandwidth.Limit, ViewFile)
+}
+
+// ViewFile renders a file with a watermark naming the user.
+// It is the only way to see the content of a file shared view-only.
+func ViewFile(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	repo, err := stor.GetRepository(c, c.Param("repo"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	res := &model.Resource{Repo: repo, Path: strings.TrimSuffix(c.Param("path"), "/")}
+	if err := perm.Evaluate(c, perm.User(user.ID), res, perm.View); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	file, err := stor.GetFileInfo(c, res)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	if file.IsDir || !watermark.Supported(file.ContentType()) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "No rendering available for this file"})
+		return
+	}
+	if file.Size > watermark.MaxSourceBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large to render"})
+		return
+	}
+	page, err := watermark.ParsePage(c.Query("page"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return
+	}
+
+	reader, err := stor.OpenFile(c, res)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		return
+	}
+	defer reader.Close()
+
+	viewer := user.Email
+	if viewer == "" {
+		viewer = user.Username
+	}
+
+	var buf bytes.Buffer
+	err = watermark.Render(c, &buf, reader, file.ContentType(), page, watermark.Lines(viewer, time.Now()))
+	if errors.Is(err, watermark.ErrNoPage) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+		return
+	} else if err != nil {
+		log.Printf("Failed to render %s: %s", file.Path, err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to render file"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, watermark.ContentType, buf.Bytes())
+}
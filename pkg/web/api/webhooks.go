@@ -0,0 +1,135 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cgang/file-hub/pkg/hooks"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerWebhooks(r *gin.RouterGroup) {
+	r.GET("", ListWebhooks)
+	r.POST("", CreateWebhook)
+	r.PATCH("/:id", UpdateWebhook)
+	r.DELETE("/:id", DeleteWebhook)
+}
+
+// ListWebhooks returns the webhooks of a repository, without their secrets
+func ListWebhooks(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	repo, ok := getOwnedRepo(c, user, c.Query("repo"))
+	if !ok {
+		return
+	}
+
+	list, err := hooks.List(c, user, repo)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": list})
+}
+
+// CreateWebhook adds a webhook to a repository.
+// The response carries the signing secret, which is not shown again.
+func CreateWebhook(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+
+	var req struct {
+		Repo string `json:"repo"`
+		hooks.Request
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	repo, ok := getOwnedRepo(c, user, req.Repo)
+	if !ok {
+		return
+	}
+
+	hook, err := hooks.Create(c, user, repo, &req.Request)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"webhook": hook, "secret": hook.Secret})
+}
+
+// webhookID parses the webhook ID of the request path
+func webhookID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// sendWebhookError replies with the status of a failed webhook update
+func sendWebhookError(c *gin.Context, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	apierr.Send(c, err)
+}
+
+// UpdateWebhook pauses or resumes the deliveries of a webhook
+func UpdateWebhook(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Repo   string `json:"repo"`
+		Active *bool  `json:"active"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Active == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	repo, ok := getOwnedRepo(c, user, req.Repo)
+	if !ok {
+		return
+	}
+
+	if err := hooks.SetActive(c, user, repo, id, *req.Active); err != nil {
+		sendWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": id, "active": *req.Active})
+}
+
+// DeleteWebhook removes a webhook from a repository
+func DeleteWebhook(c *gin.Context) {
+	user, _ := auth.GetAuthenticatedUser(c)
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	repo, ok := getOwnedRepo(c, user, c.Query("repo"))
+	if !ok {
+		return
+	}
+
+	if err := hooks.Delete(c, user, repo, id); err != nil {
+		sendWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
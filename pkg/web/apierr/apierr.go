@@ -0,0 +1,279 @@
+// Package apierr gives REST error responses stable, machine-readable codes.
+// See docs/client/ERRORS.md for the catalog.
+package apierr
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cgang/file-hub/pkg/bandwidth"
+	"github.com/cgang/file-hub/pkg/classify"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/digest"
+	"github.com/cgang/file-hub/pkg/dupes"
+	"github.com/cgang/file-hub/pkg/expiry"
+	"github.com/cgang/file-hub/pkg/federation"
+	"github.com/cgang/file-hub/pkg/hooks"
+	"github.com/cgang/file-hub/pkg/lifecycle"
+	"github.com/cgang/file-hub/pkg/links"
+	"github.com/cgang/file-hub/pkg/notify"
+	"github.com/cgang/file-hub/pkg/organize"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/reindex"
+	"github.com/cgang/file-hub/pkg/search"
+	"github.com/cgang/file-hub/pkg/skeleton"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/sync"
+	"github.com/cgang/file-hub/pkg/users"
+	"github.com/cgang/file-hub/pkg/watch"
+	"github.com/gin-gonic/gin"
+)
+
+// Code identifies a kind of error. Codes are part of the API and never change meaning.
+type Code string
+
+const (
+	CodeBadRequest         Code = "FILEHUB_BAD_REQUEST"
+	CodeUnauthorized       Code = "FILEHUB_UNAUTHORIZED"
+	CodePasswordRequired   Code = "FILEHUB_PASSWORD_REQUIRED"
+	CodeTOTPRequired       Code = "FILEHUB_TOTP_REQUIRED"
+	CodeEmailRequired      Code = "FILEHUB_EMAIL_REQUIRED"
+	CodeForbidden          Code = "FILEHUB_FORBIDDEN"
+	CodeViewOnly           Code = "FILEHUB_VIEW_ONLY"
+	CodeReadOnly           Code = "FILEHUB_READ_ONLY"
+	CodeNotFound           Code = "FILEHUB_NOT_FOUND"
+	CodeMethodNotAllowed   Code = "FILEHUB_METHOD_NOT_ALLOWED"
+	CodeConflict           Code = "FILEHUB_CONFLICT"
+	CodeGone               Code = "FILEHUB_GONE"
+	CodePreconditionFailed Code = "FILEHUB_PRECONDITION_FAILED"
+	CodeTooLarge           Code = "FILEHUB_TOO_LARGE"
+	CodeUnsupportedType    Code = "FILEHUB_UNSUPPORTED_TYPE"
+	CodeChecksumMismatch   Code = "FILEHUB_CHECKSUM_MISMATCH"
+	CodeUploadIncomplete   Code = "FILEHUB_UPLOAD_INCOMPLETE"
+	CodeUploadFailed       Code = "FILEHUB_UPLOAD_FAILED"
+	CodeLocked             Code = "FILEHUB_LOCKED"
+	CodeRateLimited        Code = "FILEHUB_RATE_LIMITED"
+	CodeQuotaExceeded      Code = "FILEHUB_QUOTA_EXCEEDED"
+	CodeInternal           Code = "FILEHUB_INTERNAL"
+	CodeUnavailable        Code = "FILEHUB_UNAVAILABLE"
+)
+
+// statusCodes is the code used for an error response that did not name one
+var statusCodes = map[int]Code{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusGone:                  CodeGone,
+	http.StatusPreconditionFailed:    CodePreconditionFailed,
+	http.StatusRequestEntityTooLarge: CodeTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedType,
+	http.StatusLocked:                CodeLocked,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+	http.StatusInsufficientStorage:   CodeQuotaExceeded,
+}
+
+// CodeForStatus returns the default code of an HTTP error status
+func CodeForStatus(status int) Code {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+// Response is the body of every REST error response
+type Response struct {
+	Error   string         `json:"error"`
+	Code    Code           `json:"code,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Error is an error with the status, code and details to report it with
+type Error struct {
+	Status  int
+	Code    Code
+	Message string
+	Details map[string]any
+	Err     error // underlying cause, not shown to clients
+}
+
+// New creates an error reported with the given status and code
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WithDetails returns a copy of the error carrying extra fields for clients
+func (e *Error) WithDetails(details map[string]any) *Error {
+	dup := *e
+	dup.Details = details
+	return &dup
+}
+
+// Map converts any error into the Error it is reported as.
+// Known domain errors get their own status and code; anything else is an internal error
+// whose message is not exposed.
+func Map(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var mismatch *sync.ChecksumMismatchError
+	if errors.As(err, &mismatch) {
+		return &Error{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    CodeChecksumMismatch,
+			Message: "Checksum mismatch, the upload was not stored",
+			Details: map[string]any{"expected": mismatch.Expected, "actual": mismatch.Actual},
+			Err:     err,
+		}
+	}
+
+	var incomplete *sync.IncompleteUploadError
+	if errors.As(err, &incomplete) {
+		return &Error{
+			Status:  http.StatusConflict,
+			Code:    CodeUploadIncomplete,
+			Message: err.Error(),
+			Details: map[string]any{"chunks": incomplete.Chunks, "size": incomplete.Size, "expected_size": incomplete.ExpectedSize},
+			Err:     err,
+		}
+	}
+
+	var failed *sync.FinalizeFailedError
+	if errors.As(err, &failed) {
+		return &Error{
+			Status:  http.StatusServiceUnavailable,
+			Code:    CodeUploadFailed,
+			Message: "The upload could not be stored, finalize it again to retry",
+			Details: map[string]any{"retry_until": failed.RetryUntil},
+			Err:     err,
+		}
+	}
+
+	var conflict *sync.ConflictError
+	if errors.As(err, &conflict) {
+		return &Error{
+			Status:  http.StatusConflict,
+			Code:    CodeConflict,
+			Message: "File changed since it was read, the content was not saved",
+			Details: map[string]any{"etag": conflict.ETag, "content": conflict.Content},
+			Err:     err,
+		}
+	}
+
+	for _, known := range knownErrors {
+		if errors.Is(err, known.target) {
+			return &Error{Status: known.status, Code: known.code, Message: err.Error(), Err: err}
+		}
+	}
+
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Internal server error", Err: err}
+}
+
+var knownErrors = []struct {
+	target error
+	status int
+	code   Code
+}{
+	{links.ErrNotFound, http.StatusNotFound, CodeNotFound},
+	{links.ErrInvalid, http.StatusBadRequest, CodeBadRequest},
+	{links.ErrEmailRequired, http.StatusBadRequest, CodeEmailRequired},
+	{links.ErrForbidden, http.StatusForbidden, CodeForbidden},
+	{links.ErrTooLarge, http.StatusRequestEntityTooLarge, CodeTooLarge},
+	{links.ErrTypeNotAllowed, http.StatusUnsupportedMediaType, CodeUnsupportedType},
+	{links.ErrPasswordRequired, http.StatusUnauthorized, CodePasswordRequired},
+	{links.ErrWrongPassword, http.StatusUnauthorized, CodePasswordRequired},
+	{links.ErrTooManyAttempts, http.StatusTooManyRequests, CodeRateLimited},
+	{links.ErrSnapshotGone, http.StatusGone, CodeGone},
+	{digest.ErrInvalid, http.StatusBadRequest, CodeBadRequest},
+	{digest.ErrForbidden, http.StatusForbidden, CodeForbidden},
+	{skeleton.ErrNoTemplate, http.StatusConflict, CodeConflict},
+	{expiry.ErrInvalid, http.StatusBadRequest, CodeBadRequest},
+	{expiry.ErrForbidden, http.StatusForbidden, CodeForbidden},
+	{lifecycle.ErrInvalid, http.StatusBadRequest, CodeBadRequest},
+	{bandwidth.ErrInvalid, http.StatusBadRequest, CodeBadRequest},
+	{bandwidth.ErrCapExceeded, http.StatusTooManyRequests, CodeRateLimited},
+	{organize.ErrInvalid, http.StatusBadRequest, CodeBadRequest},
+	{search.ErrInvalid, http.StatusBadRequest, CodeBadRequest},
+	{classify.ErrBlocked, http.StatusForbidden, CodeForbidden},
+	{classify.ErrPending, http.StatusServiceUnavailable, CodeUnavailable},
+	{search.ErrForbidden, http.StatusForbidden, CodeForbidden},
+	{organize.ErrForbidden, http.StatusForbidden, CodeForbidden},
+	{dupes.ErrInvalid, http.StatusBadRequest, CodeBadRequest},
+	{hooks.ErrInvalid, http.StatusBadRequest, CodeBadRequest},
+	{federation.ErrDisabled, http.StatusNotFound, CodeNotFound},
+	{federation.ErrInvalid, http.StatusBadRequest, CodeBadRequest},
+	{federation.ErrNotFound, http.StatusNotFound, CodeNotFound},
+	{federation.ErrForbidden, http.StatusForbidden, CodeForbidden},
+	{federation.ErrUntrusted, http.StatusForbidden, CodeForbidden},
+	{federation.ErrRemote, http.StatusBadGateway, CodeUnavailable},
+	{hooks.ErrForbidden, http.StatusForbidden, CodeForbidden},
+	{watch.ErrInvalid, http.StatusBadRequest, CodeBadRequest},
+	{notify.ErrInvalid, http.StatusBadRequest, CodeBadRequest},
+	{watch.ErrForbidden, http.StatusForbidden, CodeForbidden},
+	{perm.ErrDenied, http.StatusForbidden, CodeForbidden},
+	{perm.ErrViewOnly, http.StatusForbidden, CodeViewOnly},
+	{perm.ErrReadOnly, http.StatusForbidden, CodeReadOnly},
+	{stor.ErrRestoreConflict, http.StatusConflict, CodeConflict},
+	{stor.ErrParentNotFound, http.StatusConflict, CodeConflict},
+	{stor.ErrRenameConflict, http.StatusConflict, CodeConflict},
+	{stor.ErrRenameDir, http.StatusBadRequest, CodeBadRequest},
+	{stor.ErrImmutable, http.StatusLocked, CodeLocked},
+	{stor.ErrImmutableDir, http.StatusBadRequest, CodeBadRequest},
+	{stor.ErrReserved, http.StatusForbidden, CodeForbidden},
+	{stor.ErrRenameUnsupported, http.StatusBadRequest, CodeBadRequest},
+	{stor.ErrPresignUnsupported, http.StatusBadRequest, CodeBadRequest},
+	{stor.ErrTooLarge, http.StatusRequestEntityTooLarge, CodeTooLarge},
+	{stor.ErrTreeTooDeep, http.StatusRequestEntityTooLarge, CodeTooLarge},
+	{stor.ErrTreeTooLarge, http.StatusRequestEntityTooLarge, CodeTooLarge},
+	{stor.ErrTreeCycle, http.StatusInternalServerError, CodeInternal},
+	{reindex.ErrRunning, http.StatusConflict, CodeConflict},
+	{sync.ErrInvalidModTime, http.StatusBadRequest, CodeBadRequest},
+	{sync.ErrUploadExpired, http.StatusGone, CodeGone},
+	{sync.ErrInvalidChunk, http.StatusBadRequest, CodeBadRequest},
+	{sync.ErrUploadIncomplete, http.StatusConflict, CodeUploadIncomplete},
+	{sync.ErrInvalidCursor, http.StatusBadRequest, CodeBadRequest},
+	{sync.ErrInvalidFilter, http.StatusBadRequest, CodeBadRequest},
+	{sync.ErrInvalidName, http.StatusBadRequest, CodeBadRequest},
+	{sync.ErrInvalidBundle, http.StatusBadRequest, CodeBadRequest},
+	{sync.ErrBundleVersion, http.StatusConflict, CodeConflict},
+	{sync.ErrNotText, http.StatusUnsupportedMediaType, CodeUnsupportedType},
+	{sync.ErrTextTooLarge, http.StatusRequestEntityTooLarge, CodeTooLarge},
+	{sync.ErrQuotaExceeded, http.StatusInsufficientStorage, CodeQuotaExceeded},
+	{sync.ErrIsDirectory, http.StatusConflict, CodeConflict},
+	{users.ErrLockedOut, http.StatusTooManyRequests, CodeRateLimited},
+	{users.ErrTransferQuota, http.StatusInsufficientStorage, CodeQuotaExceeded},
+	{users.ErrInvalidUsername, http.StatusBadRequest, CodeBadRequest},
+	{users.ErrUsernameTaken, http.StatusConflict, CodeConflict},
+	{db.ErrUserChanged, http.StatusConflict, CodeConflict},
+	{users.ErrWrongPassword, http.StatusUnauthorized, CodePasswordRequired},
+	{users.ErrTOTPRequired, http.StatusUnauthorized, CodeTOTPRequired},
+	{users.ErrInvalidTOTP, http.StatusUnauthorized, CodeTOTPRequired},
+	{users.ErrTOTPEnabled, http.StatusConflict, CodeConflict},
+	{users.ErrInvalidAppPasswordName, http.StatusBadRequest, CodeBadRequest},
+	{users.ErrInvalidPreferences, http.StatusBadRequest, CodeBadRequest},
+	{users.ErrPreferencesTooLarge, http.StatusRequestEntityTooLarge, CodeTooLarge},
+}
+
+// Send aborts the request with the error response for err
+func Send(c *gin.Context, err error) {
+	e := Map(err)
+	_ = c.Error(err)
+	c.AbortWithStatusJSON(e.Status, Response{Error: e.Message, Code: e.Code, Details: e.Details})
+}
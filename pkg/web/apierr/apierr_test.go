@@ -0,0 +1,160 @@
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/i18n"
+	"github.com/cgang/file-hub/pkg/links"
+	"github.com/cgang/file-hub/pkg/sync"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMap(t *testing.T) {
+	e := Map(fmt.Errorf("lookup: %w", links.ErrNotFound))
+	assert.Equal(t, http.StatusNotFound, e.Status)
+	assert.Equal(t, CodeNotFound, e.Code)
+
+	e = Map(&sync.ChecksumMismatchError{Expected: "aa", Actual: "bb"})
+	assert.Equal(t, http.StatusUnprocessableEntity, e.Status)
+	assert.Equal(t, CodeChecksumMismatch, e.Code)
+	assert.Equal(t, map[string]any{"expected": "aa", "actual": "bb"}, e.Details)
+
+	chunks := []sync.ChunkProblem{{Index: 1, Reason: "missing"}}
+	e = Map(fmt.Errorf("finalize: %w", &sync.IncompleteUploadError{Chunks: chunks, Size: 10, ExpectedSize: 20}))
+	assert.Equal(t, http.StatusConflict, e.Status)
+	assert.Equal(t, CodeUploadIncomplete, e.Code)
+	assert.Equal(t, map[string]any{"chunks": chunks, "size": int64(10), "expected_size": int64(20)}, e.Details)
+
+	retryUntil := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	e = Map(&sync.FinalizeFailedError{RetryUntil: retryUntil, Err: errors.New("disk full")})
+	assert.Equal(t, http.StatusServiceUnavailable, e.Status)
+	assert.Equal(t, CodeUploadFailed, e.Code)
+	assert.NotContains(t, e.Message, "disk full")
+	assert.Equal(t, map[string]any{"retry_until": retryUntil}, e.Details)
+
+	e = Map(fmt.Errorf("save: %w", &sync.ConflictError{ETag: "cc", Content: "latest"}))
+	assert.Equal(t, http.StatusConflict, e.Status)
+	assert.Equal(t, CodeConflict, e.Code)
+	assert.Equal(t, map[string]any{"etag": "cc", "content": "latest"}, e.Details)
+
+	e = Map(fmt.Errorf("import: %w", sync.ErrBundleVersion))
+	assert.Equal(t, http.StatusConflict, e.Status)
+	assert.Equal(t, CodeConflict, e.Code)
+
+	e = Map(errors.New("connection refused"))
+	assert.Equal(t, http.StatusInternalServerError, e.Status)
+	assert.Equal(t, CodeInternal, e.Code)
+	assert.NotContains(t, e.Message, "connection refused")
+
+	custom := New(http.StatusInsufficientStorage, CodeQuotaExceeded, "Quota exceeded").WithDetails(map[string]any{"limit": 10})
+	assert.Same(t, custom, Map(fmt.Errorf("upload: %w", custom)))
+}
+
+func TestCodeForStatus(t *testing.T) {
+	assert.Equal(t, CodeConflict, CodeForStatus(http.StatusConflict))
+	assert.Equal(t, CodeBadRequest, CodeForStatus(http.StatusTeapot))
+	assert.Equal(t, CodeInternal, CodeForStatus(http.StatusBadGateway))
+}
+
+func serve(handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Middleware())
+	engine.GET("/", handler)
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return body
+}
+
+func TestMiddlewareAddsCode(t *testing.T) {
+	w := serve(func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+	})
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, map[string]any{"error": "Repository not found", "code": "FILEHUB_NOT_FOUND"}, decode(t, w))
+}
+
+func TestMiddlewareKeepsExplicitCode(t *testing.T) {
+	w := serve(func(c *gin.Context) {
+		Send(c, &sync.ChecksumMismatchError{Expected: "aa", Actual: "bb"})
+	})
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	body := decode(t, w)
+	assert.Equal(t, "FILEHUB_CHECKSUM_MISMATCH", body["code"])
+	assert.Equal(t, map[string]any{"expected": "aa", "actual": "bb"}, body["details"])
+}
+
+func TestMiddlewareReportsUnwrittenErrors(t *testing.T) {
+	w := serve(func(c *gin.Context) {
+		_ = c.Error(links.ErrWrongPassword)
+	})
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "FILEHUB_PASSWORD_REQUIRED", decode(t, w)["code"])
+}
+
+func TestMiddlewarePassesOtherResponses(t *testing.T) {
+	w := serve(func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"error": "not an error response"})
+	})
+	assert.Equal(t, map[string]any{"error": "not an error response"}, decode(t, w))
+
+	w = serve(func(c *gin.Context) {
+		c.String(http.StatusNotFound, "404 page not found")
+	})
+	assert.Equal(t, "404 page not found", w.Body.String())
+}
+
+func TestMiddlewareTranslates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Middleware())
+	engine.GET("/", func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	assert.Equal(t, "zh", w.Header().Get("Content-Language"))
+	assert.Equal(t, map[string]any{"error": "未找到请求的内容", "code": "FILEHUB_NOT_FOUND", "detail": "Repository not found"}, decode(t, w))
+
+	// A message the catalog has already is not repeated
+	engine.GET("/generic", func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The requested item was not found"})
+	})
+	req = httptest.NewRequest(http.MethodGet, "/generic", nil)
+	req.Header.Set("Accept-Language", "zh")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, map[string]any{"error": "未找到请求的内容", "code": "FILEHUB_NOT_FOUND"}, decode(t, w))
+}
+
+func TestCatalogCoversCodes(t *testing.T) {
+	codes := []Code{CodeChecksumMismatch, CodeUploadIncomplete, CodeUploadFailed, CodePasswordRequired, CodeTOTPRequired, CodeEmailRequired, CodeViewOnly}
+	for _, code := range statusCodes {
+		codes = append(codes, code)
+	}
+	for _, code := range codes {
+		assert.True(t, i18n.Has("error."+string(code)), "no message for %s", code)
+	}
+}
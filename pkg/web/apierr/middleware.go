@@ -0,0 +1,131 @@
+package apierr
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/cgang/file-hub/pkg/i18n"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware makes every JSON error response carry a code.
+// Handlers that write {"error": "..."} themselves get the default code of the status added,
+// and errors attached with c.Error but never written are reported through Map.
+// Clients asking for another language with Accept-Language get the message of the code
+// in that language instead, with the original message as the detail.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &codeWriter{ResponseWriter: c.Writer, locale: i18n.Negotiate(c.GetHeader("Accept-Language"))}
+		c.Writer = w
+
+		c.Next()
+
+		if len(c.Errors) > 0 && !w.Written() {
+			e := Map(c.Errors.Last().Err)
+			c.JSON(e.Status, Response{Error: e.Message, Code: e.Code, Details: e.Details})
+		}
+		w.finish()
+	}
+}
+
+// codeWriter holds back JSON error bodies so a code can be added before they are sent
+type codeWriter struct {
+	gin.ResponseWriter
+	body   *bytes.Buffer
+	locale string
+}
+
+func (w *codeWriter) capture() bool {
+	if w.body == nil && !w.ResponseWriter.Written() && w.Status() >= 400 &&
+		strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.body = new(bytes.Buffer)
+	}
+	return w.body != nil
+}
+
+func (w *codeWriter) Write(data []byte) (int, error) {
+	if w.capture() {
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *codeWriter) WriteString(s string) (int, error) {
+	if w.capture() {
+		return w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *codeWriter) Written() bool {
+	return w.body != nil || w.ResponseWriter.Written()
+}
+
+func (w *codeWriter) Size() int {
+	if w.body != nil {
+		return w.body.Len()
+	}
+	return w.ResponseWriter.Size()
+}
+
+func (w *codeWriter) Flush() {
+	if w.body == nil {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *codeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish sends the held back body, with a code added if it is an error object without one
+// and the message translated
+func (w *codeWriter) finish() {
+	if w.body == nil {
+		return
+	}
+
+	data := w.body.Bytes()
+	if updated, ok := w.rewrite(data); ok {
+		data = updated
+	}
+
+	w.body = nil
+	_, _ = w.ResponseWriter.Write(data)
+}
+
+func (w *codeWriter) rewrite(data []byte) ([]byte, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || fields["error"] == nil {
+		return nil, false
+	}
+
+	changed := false
+	code := CodeForStatus(w.Status())
+	if fields["code"] == nil {
+		fields["code"], _ = json.Marshal(code)
+		changed = true
+	} else if err := json.Unmarshal(fields["code"], &code); err != nil {
+		return nil, false
+	}
+
+	// The catalog only has a generic message for each code; a more specific one is kept as the detail
+	key := "error." + string(code)
+	if w.locale != i18n.Default && i18n.Has(key) {
+		var message string
+		if err := json.Unmarshal(fields["error"], &message); err == nil && message != i18n.Sprintf(i18n.Default, key) {
+			fields["detail"] = fields["error"]
+		}
+		fields["error"], _ = json.Marshal(i18n.Sprintf(w.locale, key))
+		w.Header().Set("Content-Language", w.locale)
+		changed = true
+	}
+
+	if !changed {
+		return nil, false
+	}
+	updated, err := json.Marshal(fields)
+	return updated, err == nil
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"errors"
 	"log"
 	"net/http"
@@ -14,11 +15,14 @@ import (
 
 const (
 	SessionCookieName = "filehub_session"
+	// CSRFHeaderName is the header carrying the session's CSRF token on requests changing state
+	CSRFHeaderName = "X-CSRF-Token"
 )
 
 var (
 	nonceStore   = NewNonceStore()
 	sessionStore = session.NewStore()
+	binding      session.Binding
 	userRealm    string
 	availRoots   []string
 )
@@ -26,13 +30,24 @@ var (
 func Init(cfg *config.Config) {
 	userRealm = cfg.Realm
 	availRoots = cfg.RootDir
+	binding = session.Binding{
+		IPv4Prefix: cfg.Security.Session.BindIPv4Prefix,
+		IPv6Prefix: cfg.Security.Session.BindIPv6Prefix,
+	}
+	initCookies(cfg)
 }
 
 // Authenticate handles authentication with support for sessions
 func Authenticate(c *gin.Context) {
-	if user, ok := GetSessionUser(c); ok {
+	if sess, ok := getSession(c); ok {
+		if !checkCSRF(c, sess) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Missing or invalid CSRF token"})
+			c.Abort()
+			return
+		}
+
 		// Valid session found, set user in context and continue
-		c.Set("user", user)
+		c.Set("user", sess.User)
 		c.Next()
 		return
 	}
@@ -63,7 +78,7 @@ func Authenticate(c *gin.Context) {
 		return
 	}
 
-	log.Printf("Authentication provided: %s %s", kind, creds)
+	log.Printf("Authentication provided: %s", kind)
 	switch kind {
 	case "Basic":
 		handleBasicAuth(c, creds, userRealm)
@@ -87,16 +102,59 @@ func GetAuthenticatedUser(c *gin.Context) (*model.User, bool) {
 	return u, ok
 }
 
-// CreateSession creates a new session for the user and sets a cookie
-func CreateSession(c *gin.Context, user *model.User) error {
-	session, err := sessionStore.Create(user)
+// RequireAdmin rejects requests from users without administrator privileges
+func RequireAdmin(c *gin.Context) {
+	user, ok := GetAuthenticatedUser(c)
+	if !ok || !user.IsAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator privileges required"})
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+// checkCSRF reports whether a request in a session may go ahead. Browsers send the session cookie
+// along with cross-site requests too, so requests that change state must also present the token
+// only the session's own pages were given.
+func checkCSRF(c *gin.Context, sess *session.Session) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return true
+	}
+
+	token := c.GetHeader(CSRFHeaderName)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRFToken)) == 1
+}
+
+// CheckSessionCSRF reports whether a request may use the session its cookie names, whatever its method,
+// for protocols like gRPC-Web that send every call as a POST. Requests without a valid session pass, as
+// their cookie grants them nothing.
+func CheckSessionCSRF(c *gin.Context) bool {
+	sess, ok := getSession(c)
+	if !ok {
+		return true
+	}
+
+	token := c.GetHeader(CSRFHeaderName)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRFToken)) == 1
+}
+
+// CreateSession creates a new session for the user and sets a cookie.
+// A session the client already had ends, so a session ID planted before login is never promoted.
+func CreateSession(c *gin.Context, user *model.User) (*session.Session, error) {
+	if sessionID, err := c.Cookie(SessionCookieName); err == nil {
+		sessionStore.Destroy(sessionID)
+	}
+
+	sess, err := sessionStore.Create(user, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Set cookie with session ID
-	c.SetCookie(SessionCookieName, session.ID, 24*3600, "/", "", false, true)
-	return nil
+	setCookie(c, SessionCookieName, sess.ID, 24*3600)
+	return sess, nil
 }
 
 // DestroySession destroys the current session
@@ -107,11 +165,26 @@ func DestroySession(c *gin.Context) {
 	}
 
 	// Clear the session cookie
-	c.SetCookie(SessionCookieName, "", -1, "/", "", false, true)
+	setCookie(c, SessionCookieName, "", -1)
+}
+
+// DestroyUserSessions logs a user out everywhere, such as after they were renamed
+func DestroyUserSessions(userID int) {
+	sessionStore.DestroyUser(userID)
 }
 
 // GetSessionUser retrieves user information using a session ID
 func GetSessionUser(c *gin.Context) (*model.User, bool) {
+	sess, ok := getSession(c)
+	if !ok {
+		return nil, false
+	}
+
+	return sess.User, true
+}
+
+// getSession retrieves the session named by the request's cookie, if the client may use it
+func getSession(c *gin.Context) (*session.Session, bool) {
 	sessionID, err := c.Cookie(SessionCookieName)
 	if err != nil {
 		if !errors.Is(err, http.ErrNoCookie) {
@@ -124,6 +197,11 @@ func GetSessionUser(c *gin.Context) (*model.User, bool) {
 	if !ok {
 		return nil, false
 	}
+	if addr := c.ClientIP(); !binding.Allows(sess.RemoteAddr, addr) {
+		log.Printf("Session of user %s created from %s used from %s", sess.User.Username, sess.RemoteAddr, addr)
+		return nil, false
+	}
 
-	return sess.User, true
+	sessionStore.Touch(sessionID)
+	return sess, true
 }
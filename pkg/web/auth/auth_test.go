@@ -22,7 +22,7 @@ func TestSessionMiddleware(t *testing.T) {
 	}
 
 	// Create a session
-	session, _ := sessionStore.Create(user)
+	session, _ := sessionStore.Create(user, "", "")
 
 	// Create a test router with session middleware
 	router := gin.New()
@@ -61,7 +61,7 @@ func TestLogoutHandler(t *testing.T) {
 	}
 
 	// Create a session
-	session, _ := sessionStore.Create(user)
+	session, _ := sessionStore.Create(user, "", "")
 
 	// Create a test router with logout handler
 	router := gin.New()
@@ -83,3 +83,102 @@ func TestLogoutHandler(t *testing.T) {
 	_, ok := sessionStore.Get(session.ID)
 	assert.False(t, ok)
 }
+
+func TestRequireAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func(user *model.User) *gin.Engine {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			if user != nil {
+				c.Set("user", user)
+			}
+		}, RequireAdmin)
+		router.GET("/admin", func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		return router
+	}
+
+	tests := []struct {
+		name string
+		user *model.User
+		code int
+	}{
+		{"no user", nil, http.StatusForbidden},
+		{"regular user", &model.User{ID: 1, Username: "user"}, http.StatusForbidden},
+		{"admin user", &model.User{ID: 2, Username: "admin", IsAdmin: true}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/admin", nil)
+			newRouter(tt.user).ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestSessionCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	user := &model.User{ID: 1, Username: "testuser", Email: "test@example.com"}
+	session, _ := sessionStore.Create(user, "", "")
+
+	router := gin.New()
+	router.Use(Authenticate)
+	handler := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/files", handler)
+	router.Handle("PROPFIND", "/files", handler)
+	router.POST("/files", handler)
+	router.DELETE("/files", handler)
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		code   int
+	}{
+		{"GET without token", http.MethodGet, "", http.StatusOK},
+		{"PROPFIND without token", "PROPFIND", "", http.StatusOK},
+		{"POST without token", http.MethodPost, "", http.StatusForbidden},
+		{"POST with wrong token", http.MethodPost, "wrong", http.StatusForbidden},
+		{"POST with token", http.MethodPost, session.CSRFToken, http.StatusOK},
+		{"DELETE with token", http.MethodDelete, session.CSRFToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, "/files", nil)
+			req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: session.ID})
+			if tt.token != "" {
+				req.Header.Set(CSRFHeaderName, tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestCreateSessionReplacesPrevious(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	user := &model.User{ID: 1, Username: "testuser", Email: "test@example.com"}
+	previous, _ := sessionStore.Create(user, "", "")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodPost, "/api/auth/login", nil)
+	c.Request.AddCookie(&http.Cookie{Name: SessionCookieName, Value: previous.ID})
+
+	sess, err := CreateSession(c, user)
+	assert.NoError(t, err)
+	assert.NotEqual(t, previous.ID, sess.ID)
+
+	_, ok := sessionStore.Get(previous.ID)
+	assert.False(t, ok)
+	assert.Contains(t, w.Header().Get("Set-Cookie"), SessionCookieName+"="+sess.ID)
+}
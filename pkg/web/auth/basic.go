@@ -2,6 +2,7 @@ package auth
 
 import (
 	"encoding/base64"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -39,10 +40,14 @@ func handleBasicAuth(c *gin.Context, creds string, realm string) {
 	}
 
 	// Authenticate the user using our user service
-	user, err := users.Authenticate(c, username, password)
-	if err != nil {
+	user, err := users.Authenticate(c, username, password, c.ClientIP())
+	if errors.Is(err, users.ErrLockedOut) {
+		c.String(http.StatusTooManyRequests, err.Error())
+		c.Abort()
+		return
+	} else if err != nil {
 		c.Header("WWW-Authenticate", `Basic realm="`+realm+`"`)
-		c.String(http.StatusUnauthorized, "Invalid username or password")
+		c.String(http.StatusUnauthorized, credentialsError(err))
 		c.Abort()
 		return
 	}
@@ -51,3 +56,12 @@ func handleBasicAuth(c *gin.Context, creds string, realm string) {
 	c.Set("user", user)
 	c.Next()
 }
+
+// credentialsError returns the message for credentials that were rejected, telling users with
+// two-factor login who gave their account password to create an app password
+func credentialsError(err error) string {
+	if errors.Is(err, users.ErrAppPasswordRequired) {
+		return "Two-factor login is enabled; use an app password"
+	}
+	return "Invalid username or password"
+}
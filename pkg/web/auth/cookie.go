@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/cgang/file-hub/pkg/netacl"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	cookieSecure   *bool // nil to mark cookies Secure on HTTPS requests only
+	cookieSameSite = http.SameSiteLaxMode
+	trustedProxies *netacl.List // proxies whose X-Forwarded-Proto is believed, nil for none
+)
+
+var sameSiteModes = map[string]http.SameSite{
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// initCookies applies security.cookies, ignoring invalid values
+func initCookies(cfg *config.Config) {
+	cookieSecure = cfg.Security.Cookies.Secure
+	cookieSameSite = http.SameSiteLaxMode
+	if value := cfg.Security.Cookies.SameSite; value != "" {
+		if mode, ok := sameSiteModes[strings.ToLower(value)]; ok {
+			cookieSameSite = mode
+		} else {
+			log.Printf("Ignoring invalid cookie SameSite mode %q", value)
+		}
+	}
+
+	trustedProxies = nil
+	if len(cfg.Web.TrustedProxies) > 0 {
+		list, err := netacl.Parse(cfg.Web.TrustedProxies, nil)
+		if err != nil {
+			log.Printf("Ignoring invalid trusted proxies for cookies: %s", err)
+		} else {
+			trustedProxies = list
+		}
+	}
+}
+
+// secureRequest reports whether a request came over HTTPS, to the server or to a trusted proxy
+// terminating TLS in front of it
+func secureRequest(c *gin.Context) bool {
+	if c.Request.TLS != nil {
+		return true
+	}
+	return trustedProxies != nil && c.GetHeader("X-Forwarded-Proto") == "https" && trustedProxies.Allows(c.RemoteIP())
+}
+
+// setCookie sets an HttpOnly cookie on the whole site with the configured Secure and SameSite attributes.
+// Browsers drop SameSite=None cookies that are not Secure, so those always are.
+func setCookie(c *gin.Context, name, value string, maxAge int) {
+	secure := secureRequest(c)
+	if cookieSecure != nil {
+		secure = *cookieSecure
+	}
+	if cookieSameSite == http.SameSiteNoneMode {
+		secure = true
+	}
+
+	c.SetSameSite(cookieSameSite)
+	c.SetCookie(name, value, maxAge, "/", "", secure, true)
+}
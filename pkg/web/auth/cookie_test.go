@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Cleanup(func() { initCookies(&config.Config{}) })
+
+	set := func(remote string, prepare func(*http.Request)) *http.Cookie {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = remote
+		if prepare != nil {
+			prepare(req)
+		}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+		setCookie(c, SessionCookieName, "id", 60)
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		return cookies[0]
+	}
+	forwarded := func(req *http.Request) { req.Header.Set("X-Forwarded-Proto", "https") }
+
+	cfg := &config.Config{}
+	cfg.Web.TrustedProxies = []string{"10.0.0.1"}
+	initCookies(cfg)
+
+	// Secure follows the request by default, with SameSite=Lax
+	cookie := set("192.0.2.1:1234", nil)
+	assert.False(t, cookie.Secure)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	assert.True(t, set("192.0.2.1:1234", func(req *http.Request) { req.TLS = &tls.ConnectionState{} }).Secure)
+
+	// X-Forwarded-Proto only counts from a trusted proxy
+	assert.True(t, set("10.0.0.1:1234", forwarded).Secure)
+	assert.False(t, set("192.0.2.1:1234", forwarded).Secure)
+
+	// Configured values win, except that SameSite=None needs Secure
+	never, always := false, true
+	cfg.Security.Cookies = config.CookieConfig{Secure: &never, SameSite: "Strict"}
+	initCookies(cfg)
+	cookie = set("10.0.0.1:1234", forwarded)
+	assert.False(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+
+	cfg.Security.Cookies = config.CookieConfig{Secure: &always}
+	initCookies(cfg)
+	assert.True(t, set("192.0.2.1:1234", nil).Secure)
+
+	cfg.Security.Cookies = config.CookieConfig{Secure: &never, SameSite: "none"}
+	initCookies(cfg)
+	cookie = set("192.0.2.1:1234", nil)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteNoneMode, cookie.SameSite)
+
+	// Invalid modes fall back to Lax
+	cfg.Security.Cookies = config.CookieConfig{SameSite: "sometimes"}
+	initCookies(cfg)
+	assert.Equal(t, http.SameSiteLaxMode, set("192.0.2.1:1234", nil).SameSite)
+}
@@ -3,7 +3,9 @@ package auth
 import (
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -150,7 +152,7 @@ func validateDigestResponse(digest *DigestResponse, storedHA1, method string) bo
 	expectedResponse := calculateResponse(storedHA1, digest.Nonce, digest.NC, digest.CNonce, digest.QoP, ha2)
 
 	// Compare the responses using constant time comparison
-	return expectedResponse == digest.Response
+	return subtle.ConstantTimeCompare([]byte(expectedResponse), []byte(digest.Response)) == 1
 }
 
 // generateWWWAuthenticateHeader generates the WWW-Authenticate header for digest auth
@@ -185,19 +187,24 @@ func handleDigestAuth(c *gin.Context, creds string, nonceStore *NonceStore, real
 		digest.QoP,
 		digest.Response,
 		c.Request.Method,
+		c.ClientIP(),
 	)
-	if err != nil {
+	if errors.Is(err, users.ErrLockedOut) {
+		c.String(http.StatusTooManyRequests, err.Error())
+		c.Abort()
+		return
+	} else if err != nil {
 		log.Printf("Failed to validate digest credentials: %s", err)
 		// Create a new challenge
-		challenge, err := createDigestChallenge(realm)
-		if err != nil {
+		challenge, cerr := createDigestChallenge(realm)
+		if cerr != nil {
 			c.String(http.StatusInternalServerError, "Failed to create auth challenge")
 			c.Abort()
 			return
 		}
 
 		c.Header("WWW-Authenticate", generateWWWAuthenticateHeader(challenge))
-		c.String(http.StatusUnauthorized, "Invalid username or password")
+		c.String(http.StatusUnauthorized, credentialsError(err))
 		c.Abort()
 		return
 	}
@@ -141,3 +141,34 @@ func TestCalculateHA2(t *testing.T) {
 		t.Errorf("Expected HA2 '%s', got '%s'", expected, ha2)
 	}
 }
+
+func TestValidateDigestResponse(t *testing.T) {
+	digest := &DigestResponse{
+		Nonce:  "abc123",
+		URI:    "/dav/testuser/",
+		QoP:    "auth",
+		NC:     "00000001",
+		CNonce: "def456",
+	}
+
+	ha1 := calculateHA1("testuser", "test", "password")
+	digest.Response = calculateResponse(ha1, digest.Nonce, digest.NC, digest.CNonce, digest.QoP, calculateHA2("GET", digest.URI))
+
+	if !validateDigestResponse(digest, ha1, "GET") {
+		t.Error("Expected digest response with correct password to be valid")
+	}
+
+	wrongHA1 := calculateHA1("testuser", "test", "wrong")
+	if validateDigestResponse(digest, wrongHA1, "GET") {
+		t.Error("Expected digest response to be rejected for a different password")
+	}
+
+	if validateDigestResponse(digest, ha1, "PUT") {
+		t.Error("Expected digest response to be rejected for a different method")
+	}
+
+	digest.Response = ""
+	if validateDigestResponse(digest, ha1, "GET") {
+		t.Error("Expected empty digest response to be rejected")
+	}
+}
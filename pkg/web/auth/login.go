@@ -1,9 +1,13 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/cgang/file-hub/pkg/model"
 	"github.com/cgang/file-hub/pkg/users"
+	"github.com/cgang/file-hub/pkg/web/apierr"
 	"github.com/gin-gonic/gin"
 )
 
@@ -30,7 +34,23 @@ func CheckStatus(c *gin.Context) {
 	}
 }
 
-// Login handles user login requests
+// LoginRequest holds the credentials of a login
+type LoginRequest struct {
+	Username string `json:"username" form:"username"`
+	Password string `json:"password" form:"password"`
+	// TOTP is the one-time code, needed only by users who enabled two-factor login
+	TOTP string `json:"totp,omitempty" form:"totp"`
+}
+
+// LoginResponse describes the session a login started
+type LoginResponse struct {
+	Message   string      `json:"message"`
+	User      *model.User `json:"user"`
+	CSRFToken string      `json:"csrf_token"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+// Login handles user login requests, starting a session whose cookie is set on the response
 func Login(c *gin.Context) {
 	// Check if database is empty, redirect to setup page if it is
 	if yes, err := users.HasAnyUser(c); err != nil {
@@ -41,35 +61,85 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Username string `json:"username" form:"username"`
-		Password string `json:"password" form:"password"`
-	}
-
+	var req LoginRequest
 	if err := c.Bind(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
 		return
 	}
 
-	user, err := users.Authenticate(c, req.Username, req.Password)
-	if err != nil {
+	user, err := users.Login(c, req.Username, req.Password, req.TOTP, c.ClientIP())
+	if errors.Is(err, users.ErrLockedOut) || errors.Is(err, users.ErrTOTPRequired) || errors.Is(err, users.ErrInvalidTOTP) {
+		apierr.Send(c, err)
+		return
+	} else if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
 
 	// Create a session for the user
-	if err := CreateSession(c, user); err != nil {
+	sess, err := CreateSession(c, user)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Login successful",
+	c.JSON(http.StatusOK, &LoginResponse{
+		Message:   "Login successful",
+		User:      user,
+		CSRFToken: sess.CSRFToken,
+		ExpiresAt: sess.ExpiresAt,
 	})
 }
 
-// Logout handles user logout requests
+// Logout handles user logout requests, ending the session on the server and clearing its cookie.
+// Download tokens issued in the session stop working with it.
 func Logout(c *gin.Context) {
 	DestroySession(c)
 	c.JSON(http.StatusOK, gin.H{"message": "Logout successful"})
 }
+
+// CapabilityAdmin is held by administrators, who may use the /api/admin endpoints
+const CapabilityAdmin = "admin"
+
+// WhoamiResponse describes the authenticated user and what they may do
+type WhoamiResponse struct {
+	User        *model.User `json:"user"`
+	TOTPEnabled bool        `json:"totp_enabled"`
+	// CSRFToken is the token of the current session, empty for requests with an Authorization header
+	CSRFToken    string            `json:"csrf_token,omitempty"`
+	Capabilities []string          `json:"capabilities"`
+	Preferences  users.Preferences `json:"preferences"` // settings kept for clients, as under /api/me/preferences
+}
+
+// Whoami returns the authenticated user, so clients can restore their state after a reload
+func Whoami(c *gin.Context) {
+	user, _ := GetAuthenticatedUser(c)
+
+	// The user is read again, since a session holds the details of the time of login
+	current, err := users.Get(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	prefs, err := users.GetPreferences(c, current.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get preferences"})
+		return
+	}
+
+	resp := &WhoamiResponse{
+		User:         current,
+		Preferences:  prefs,
+		TOTPEnabled:  current.HasTOTP(),
+		Capabilities: []string{},
+	}
+	if sess, ok := getSession(c); ok && sess.User.ID == current.ID {
+		resp.CSRFToken = sess.CSRFToken
+	}
+	if current.IsAdmin {
+		resp.Capabilities = append(resp.Capabilities, CapabilityAdmin)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/web/session"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionInfo describes a session of the user, named by its handle rather than its secret ID
+type SessionInfo struct {
+	ID           string    `json:"id"`
+	Current      bool      `json:"current"`
+	RemoteAddr   string    `json:"remote_addr,omitempty"`
+	UserAgent    string    `json:"user_agent,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActivity time.Time `json:"last_activity"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// ListSessions returns the sessions of the authenticated user, so they can spot and end ones they
+// do not recognize
+func ListSessions(c *gin.Context) {
+	user, _ := GetAuthenticatedUser(c)
+
+	current := ""
+	if sessionID, err := c.Cookie(SessionCookieName); err == nil {
+		current = session.Handle(sessionID)
+	}
+
+	list := []SessionInfo{}
+	for _, sess := range sessionStore.List(user.ID) {
+		handle := session.Handle(sess.ID)
+		list = append(list, SessionInfo{
+			ID:           handle,
+			Current:      handle == current,
+			RemoteAddr:   sess.RemoteAddr,
+			UserAgent:    sess.UserAgent,
+			CreatedAt:    sess.CreatedAt,
+			LastActivity: sess.LastActivity,
+			ExpiresAt:    sess.ExpiresAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": list})
+}
+
+// RevokeSession ends one of the authenticated user's sessions
+func RevokeSession(c *gin.Context) {
+	user, _ := GetAuthenticatedUser(c)
+
+	sess, ok := sessionStore.GetByHandle(c.Param("id"))
+	if !ok || sess.User.ID != user.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	sessionStore.Destroy(sess.ID)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/web/session"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionsAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	user := &model.User{ID: 301, Username: "sessionuser"}
+	current, _ := sessionStore.Create(user, "192.0.2.1", "browser")
+	other, _ := sessionStore.Create(user, "192.0.2.2", "phone")
+	foreign, _ := sessionStore.Create(&model.User{ID: 302, Username: "otheruser"}, "", "")
+
+	router := gin.New()
+	router.Use(Authenticate)
+	router.GET("/sessions", ListSessions)
+	router.DELETE("/sessions/:id", RevokeSession)
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: current.ID})
+		req.Header.Set(CSRFHeaderName, current.CSRFToken)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("List", func(t *testing.T) {
+		w := send(http.MethodGet, "/sessions")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Sessions []SessionInfo `json:"sessions"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Sessions, 2)
+		assert.Equal(t, session.Handle(current.ID), resp.Sessions[0].ID)
+		assert.True(t, resp.Sessions[0].Current)
+		assert.Equal(t, "browser", resp.Sessions[0].UserAgent)
+		assert.Equal(t, "phone", resp.Sessions[1].UserAgent)
+		assert.False(t, resp.Sessions[1].Current)
+		assert.NotContains(t, w.Body.String(), current.ID)
+	})
+
+	t.Run("Revoke another user's session", func(t *testing.T) {
+		w := send(http.MethodDelete, "/sessions/"+session.Handle(foreign.ID))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		_, ok := sessionStore.Get(foreign.ID)
+		assert.True(t, ok)
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		w := send(http.MethodDelete, "/sessions/"+session.Handle(other.ID))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		_, ok := sessionStore.Get(other.ID)
+		assert.False(t, ok)
+	})
+}
+
+func TestSessionBinding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	original := binding
+	defer func() { binding = original }()
+	binding = session.Binding{IPv4Prefix: 24}
+
+	user := &model.User{ID: 303, Username: "bounduser"}
+	sess, _ := sessionStore.Create(user, "192.0.2.1", "")
+
+	router := gin.New()
+	router.Use(Authenticate)
+	router.GET("/protected", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for addr, code := range map[string]int{
+		"192.0.2.77":   http.StatusOK,
+		"198.51.100.1": http.StatusUnauthorized,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "/protected", nil)
+		req.RemoteAddr = addr + ":40000"
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: sess.ID})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code, addr)
+	}
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"net/http"
 
+	"github.com/cgang/file-hub/pkg/skeleton"
 	"github.com/cgang/file-hub/pkg/stor"
 	"github.com/cgang/file-hub/pkg/users"
 	"github.com/gin-gonic/gin"
@@ -53,7 +54,7 @@ func Setup(c *gin.Context) {
 		return
 	}
 
-	if err := stor.CreateHomeRepo(c, user, req.Root); err != nil {
+	if err := skeleton.Provision(c, user, req.Root); err != nil {
 		c.String(http.StatusInternalServerError, "Failed to create home repository for %s: %s", req.Username, err)
 		return
 	}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/users"
+	"github.com/cgang/file-hub/pkg/web/session"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DownloadTokenParam is the query parameter carrying a download token
+	DownloadTokenParam = "dl_token"
+	// DownloadTokenTTL is how long a download token stays valid
+	DownloadTokenTTL = 5 * time.Minute
+
+	downloadGrantKey = "download_grant"
+)
+
+var (
+	errInvalidToken = errors.New("invalid download token")
+
+	// downloadKey signs download tokens; tokens do not survive a restart, like sessions
+	downloadKey = newDownloadKey()
+)
+
+func newDownloadKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("failed to generate download key: %s", err))
+	}
+	return key
+}
+
+// DownloadGrant is what a download token allows: reading one file of a repository
+type DownloadGrant struct {
+	UserID    int
+	RepoID    int
+	Path      string
+	ExpiresAt time.Time
+	handle    string // handle of the session the token was issued in, empty without a session
+}
+
+func (g *DownloadGrant) sign() string {
+	mac := hmac.New(sha256.New, downloadKey)
+	fmt.Fprintf(mac, "%d:%d:%d:%s:%s", g.ExpiresAt.Unix(), g.UserID, g.RepoID, g.handle, g.Path)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IssueDownloadToken signs a token letting media tags, which cannot send credentials, read one file
+// for a few minutes. A token issued in a session is revoked when the session ends.
+func IssueDownloadToken(c *gin.Context, user *model.User, repo *model.Repository, path string) (string, time.Time) {
+	grant := &DownloadGrant{
+		UserID:    user.ID,
+		RepoID:    repo.ID,
+		Path:      path,
+		ExpiresAt: time.Now().Add(DownloadTokenTTL).Truncate(time.Second),
+	}
+	if sessionID, err := c.Cookie(SessionCookieName); err == nil {
+		if sess, ok := sessionStore.Get(sessionID); ok && sess.User.ID == user.ID {
+			grant.handle = session.Handle(sessionID)
+		}
+	}
+
+	token := strings.Join([]string{
+		strconv.FormatInt(grant.ExpiresAt.Unix(), 10),
+		strconv.Itoa(grant.UserID),
+		strconv.Itoa(grant.RepoID),
+		base64.RawURLEncoding.EncodeToString([]byte(grant.Path)),
+		grant.handle,
+		grant.sign(),
+	}, ".")
+	return token, grant.ExpiresAt
+}
+
+// parseDownloadToken verifies the signature and expiry of a download token
+func parseDownloadToken(token string, now time.Time) (*DownloadGrant, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 6 {
+		return nil, errInvalidToken
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return nil, errInvalidToken
+	}
+	userID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	repoID, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	path, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	grant := &DownloadGrant{
+		UserID:    userID,
+		RepoID:    repoID,
+		Path:      string(path),
+		ExpiresAt: time.Unix(expires, 0),
+		handle:    parts[4],
+	}
+	if !hmac.Equal([]byte(parts[5]), []byte(grant.sign())) {
+		return nil, errInvalidToken
+	}
+	return grant, nil
+}
+
+// AuthenticateDownload authenticates requests carrying a download token, and any other request like Authenticate.
+// Handlers of routes using it must check the file served with CheckDownloadGrant.
+func AuthenticateDownload(c *gin.Context) {
+	token := c.Query(DownloadTokenParam)
+	if token == "" {
+		Authenticate(c)
+		return
+	}
+
+	grant, err := parseDownloadToken(token, time.Now())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired download token"})
+		c.Abort()
+		return
+	}
+
+	var user *model.User
+	if grant.handle != "" {
+		sess, ok := sessionStore.GetByHandle(grant.handle)
+		if !ok || sess.User.ID != grant.UserID {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired download token"})
+			c.Abort()
+			return
+		}
+		user = sess.User
+	} else if user, err = users.Get(c, grant.UserID); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired download token"})
+		c.Abort()
+		return
+	}
+
+	c.Set("user", user)
+	c.Set(downloadGrantKey, grant)
+	c.Next()
+}
+
+// CheckDownloadGrant reports whether a request may read a file: requests authenticated by
+// a download token only the file named by the token, others any file.
+func CheckDownloadGrant(c *gin.Context, repo *model.Repository, path string) bool {
+	value, exists := c.Get(downloadGrantKey)
+	if !exists {
+		return true
+	}
+
+	grant := value.(*DownloadGrant)
+	return grant.RepoID == repo.ID && grant.Path == path
+}
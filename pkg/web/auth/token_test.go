@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	user := &model.User{ID: 7, Username: "tokenuser"}
+	repo := &model.Repository{ID: 3, Name: "photos"}
+	sess, err := sessionStore.Create(user, "", "")
+	require.NoError(t, err)
+
+	// Issue a token within the session
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/tokens/download", nil)
+	c.Request.AddCookie(&http.Cookie{Name: SessionCookieName, Value: sess.ID})
+	token, expires := IssueDownloadToken(c, user, repo, "/album/cat.jpg")
+	assert.WithinDuration(t, time.Now().Add(DownloadTokenTTL), expires, 2*time.Second)
+	assert.NotContains(t, token, sess.ID)
+
+	router := gin.New()
+	router.GET("/file", AuthenticateDownload, func(c *gin.Context) {
+		path := c.Query("path")
+		if !CheckDownloadGrant(c, repo, path) {
+			c.Status(http.StatusForbidden)
+			return
+		}
+		user, _ := GetAuthenticatedUser(c)
+		c.String(http.StatusOK, user.Username)
+	})
+	get := func(token, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		query := url.Values{DownloadTokenParam: {token}, "path": {path}}
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/file?"+query.Encode(), nil))
+		return w
+	}
+
+	t.Run("Valid token", func(t *testing.T) {
+		w := get(token, "/album/cat.jpg")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "tokenuser", w.Body.String())
+	})
+
+	t.Run("Other file", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, get(token, "/album/dog.jpg").Code)
+	})
+
+	t.Run("Tampered token", func(t *testing.T) {
+		parts := strings.Split(token, ".")
+		parts[3] = base64.RawURLEncoding.EncodeToString([]byte("/album/dog.jpg"))
+		assert.Equal(t, http.StatusUnauthorized, get(strings.Join(parts, "."), "/album/dog.jpg").Code)
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		_, err := parseDownloadToken(token, expires)
+		assert.ErrorIs(t, err, errInvalidToken)
+	})
+
+	t.Run("Revoked with the session", func(t *testing.T) {
+		sessionStore.Destroy(sess.ID)
+		assert.Equal(t, http.StatusUnauthorized, get(token, "/album/cat.jpg").Code)
+	})
+
+	t.Run("Without token", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/file?path=/album/cat.jpg", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+	})
+}
@@ -0,0 +1,88 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/bandwidth"
+	"github.com/cgang/file-hub/pkg/sync"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// checkTransfer refuses calls of users over their monthly transfer cap, and charges the gRPC-Web
+// request a call arrived in, whose bytes the HTTP server counts, to the user
+func checkTransfer(ctx context.Context) (userID int, err error) {
+	userID, _ = ctx.Value(sync.UserIDContextKey).(int)
+	bandwidth.ChargeTo(ctx, userID)
+	if err := bandwidth.Check(ctx, userID); errors.Is(err, bandwidth.ErrCapExceeded) {
+		return userID, status.Error(codes.ResourceExhausted, err.Error())
+	} else if err != nil {
+		return userID, status.Error(codes.Internal, "failed to check transfer usage")
+	}
+	return userID, nil
+}
+
+// messageSize returns the encoded size of a gRPC message
+func messageSize(m any) int64 {
+	if msg, ok := m.(proto.Message); ok {
+		return int64(proto.Size(msg))
+	}
+	return 0
+}
+
+// meterTransferUnary counts the bytes of the calls of native gRPC clients towards the transfer usage of
+// the user, after refusing calls of users over their cap
+func meterTransferUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	userID, err := checkTransfer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := handler(ctx, req)
+	if !bandwidth.Metered(ctx) {
+		bandwidth.Record(userID, messageSize(req), messageSize(resp), time.Now())
+	}
+	return resp, err
+}
+
+// meterTransferStream is meterTransferUnary for streams
+func meterTransferStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	userID, err := checkTransfer(ss.Context())
+	if err != nil {
+		return err
+	}
+	if bandwidth.Metered(ss.Context()) {
+		return handler(srv, ss)
+	}
+
+	counted := &countingStream{ServerStream: ss}
+	err = handler(srv, counted)
+	bandwidth.Record(userID, counted.received, counted.sent, time.Now())
+	return err
+}
+
+// countingStream counts the bytes of the messages of a stream
+type countingStream struct {
+	grpc.ServerStream
+	received, sent int64
+}
+
+func (s *countingStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received += messageSize(m)
+	}
+	return err
+}
+
+func (s *countingStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent += messageSize(m)
+	}
+	return err
+}
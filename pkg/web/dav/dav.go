@@ -1,29 +1,52 @@
 package dav
 
 import (
-	"bytes"
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/cgang/file-hub/pkg/bandwidth"
+	"github.com/cgang/file-hub/pkg/config"
 	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/netacl"
+	"github.com/cgang/file-hub/pkg/perm"
 	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/sync"
+	"github.com/cgang/file-hub/pkg/users"
 	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/cgang/file-hub/pkg/web/serve"
 	"github.com/gin-gonic/gin"
 )
 
 const (
 	davNamespace = "DAV:"
+	// hubNamespace holds the properties of our own, such as the immutable flag
+	hubNamespace = "urn:cgang:file-hub"
 )
 
+var (
+	// maxEntries caps the children listed by one Depth: 1 PROPFIND, zero means no limit
+	maxEntries = 10000
+	// basePath is the prefix a reverse proxy serves the application under, "" if none
+	basePath = ""
+	// mountPath is where the WebDAV routes are registered on the engine
+	mountPath = "/dav"
+)
+
+// Init applies the WebDAV settings of the configuration
+func Init(cfg *config.Config) {
+	maxEntries = cfg.Web.DAVMaxEntries
+	basePath = strings.TrimSuffix(path.Join("/", cfg.Web.BasePath), "/")
+}
+
 // PropfindRequest represents the XML request body for PROPFIND
 type PropfindRequest struct {
 	XMLName  xml.Name      `xml:"DAV: propfind"`
@@ -41,6 +64,11 @@ type PropfindProp struct {
 	LastModified  *struct{} `xml:"DAV: getlastmodified,omitempty"`
 	CreationDate  *struct{} `xml:"DAV: creationdate,omitempty"`
 	ETag          *struct{} `xml:"DAV: getetag,omitempty"`
+	// RFC 4331 quota properties, only returned when requested by name
+	QuotaAvailable *struct{} `xml:"DAV: quota-available-bytes,omitempty"`
+	QuotaUsed      *struct{} `xml:"DAV: quota-used-bytes,omitempty"`
+	// Whether a file is pinned against overwrites, moves and deletes
+	Immutable *struct{} `xml:"urn:cgang:file-hub immutable,omitempty"`
 	// Add more properties as needed
 }
 
@@ -67,12 +95,14 @@ func getAuthenticatedUser(c *gin.Context) (*model.User, error) {
 
 // Register configures the WebDAV routes
 func Register(v1 *gin.RouterGroup) {
+	mountPath = v1.BasePath()
+
 	// Note: Authentication should be handled by a middleware in the calling code
 	v1.Use(setDavHeaders)
 
 	v1.OPTIONS("/:repo/*path", handleOptions)
 
-	v1.Use(auth.Authenticate)
+	v1.Use(auth.Authenticate, netacl.RepoFilter, bandwidth.Limit)
 
 	v1.PUT("/:repo/*path", handlePut)
 	v1.DELETE("/:repo/*path", handleDelete)
@@ -85,13 +115,16 @@ func Register(v1 *gin.RouterGroup) {
 }
 
 type Prop struct {
-	DisplayName  string        `xml:"D:displayname"`
-	ResourceType *ResourceType `xml:"D:resourcetype,omitempty"`
-	ContentType  string        `xml:"D:getcontenttype"`
-	Length       string        `xml:"D:getcontentlength,omitempty"`
-	LastModified string        `xml:"D:getlastmodified"`
-	CreationDate string        `xml:"D:creationdate,omitempty"`
-	ETag         string        `xml:"D:getetag,omitempty"`
+	DisplayName    string        `xml:"D:displayname"`
+	ResourceType   *ResourceType `xml:"D:resourcetype,omitempty"`
+	ContentType    string        `xml:"D:getcontenttype"`
+	Length         string        `xml:"D:getcontentlength,omitempty"`
+	LastModified   string        `xml:"D:getlastmodified"`
+	CreationDate   string        `xml:"D:creationdate,omitempty"`
+	ETag           string        `xml:"D:getetag,omitempty"`
+	QuotaAvailable string        `xml:"D:quota-available-bytes,omitempty"`
+	QuotaUsed      string        `xml:"D:quota-used-bytes,omitempty"`
+	Immutable      string        `xml:"H:immutable,omitempty"`
 }
 
 type ResourceType struct {
@@ -102,12 +135,6 @@ func CollectionType() *ResourceType {
 	return &ResourceType{XmlData: "<D:collection/>"}
 }
 
-type Multistatus struct {
-	XMLName  xml.Name   `xml:"D:multistatus"`
-	DavNS    string     `xml:"xmlns:D,attr"`
-	Response []Response `xml:"D:response"`
-}
-
 type Response struct {
 	Href     string   `xml:"D:href"`
 	Propstat Propstat `xml:"D:propstat"`
@@ -118,10 +145,32 @@ type Propstat struct {
 	Status string `xml:"D:status"`
 }
 
+// StatusResponse reports a status for a resource as a whole instead of its properties
+type StatusResponse struct {
+	Href        string         `xml:"D:href"`
+	Status      string         `xml:"D:status"`
+	Error       *ResponseError `xml:"D:error,omitempty"`
+	Description string         `xml:"D:responsedescription,omitempty"`
+}
+
+// ResponseError holds the precondition a StatusResponse failed
+type ResponseError struct {
+	NumberOfMatchesWithinLimits *struct{} `xml:"D:number-of-matches-within-limits,omitempty"`
+}
+
+func statusResponse(href string, status int, cause *ResponseError, description string) StatusResponse {
+	return StatusResponse{
+		Href:        escapeHref(href),
+		Status:      fmt.Sprintf("HTTP/1.1 %d %s", status, http.StatusText(status)),
+		Error:       cause,
+		Description: description,
+	}
+}
+
 // ErrorBody is used for WebDAV error responses
 type ErrorBody struct {
 	XMLName xml.Name `xml:"D:error"`
-	DavNS   string   `xml:"xmlns:dav,attr"`
+	DavNS   string   `xml:"xmlns:D,attr"`
 	Message string   `xml:",innerxml"`
 }
 
@@ -148,31 +197,55 @@ func getResource(c *gin.Context) (*model.Resource, error) {
 	}, nil
 }
 
-// getResourceByUrl parses a URL and returns the corresponding Resource
+// getResourceByUrl parses a URL, such as a Destination header, and returns the corresponding Resource
 func getResourceByUrl(ctx context.Context, urlStr string) (*model.Resource, error) {
-	u, err := url.Parse(urlStr)
+	name, filePath, err := parseHref(urlStr)
 	if err != nil {
-		return nil, fmt.Errorf("invalid URL: %w", err)
+		return nil, err
 	}
 
-	// Extract the path and remove the leading slash
-	path := strings.TrimPrefix(u.Path, "/")
-
-	// Split the path to get repo and file path
-	parts := strings.SplitN(path, "/", 2)
-	if len(parts) < 2 {
-		return nil, fmt.Errorf("invalid path: %s", path)
+	r, err := stor.GetRepository(ctx, name)
+	if err != nil {
+		return nil, err
 	}
 
-	base := parts[0]
-	name := parts[1]
+	return &model.Resource{Repo: r, Path: filePath}, nil
+}
+
+// resourceHref returns the unescaped URL path clients reach a file of a repository at
+func resourceHref(repo, filePath string) string {
+	return basePath + path.Join(mountPath, repo, filePath)
+}
 
-	r, err := stor.GetRepository(ctx, base)
+// escapeHref escapes each segment of a URL path, keeping the separators
+func escapeHref(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// parseHref splits an absolute URL or path produced by resourceHref into the repository name and file path.
+// The base path is optional since a proxy may have removed it.
+func parseHref(urlStr string) (repo, filePath string, err error) {
+	u, err := url.Parse(urlStr)
 	if err != nil {
-		return nil, err
+		return "", "", fmt.Errorf("invalid URL: %w", err)
+	}
+
+	rest, ok := strings.CutPrefix(u.Path, basePath+mountPath+"/")
+	if !ok {
+		if rest, ok = strings.CutPrefix(u.Path, mountPath+"/"); !ok {
+			return "", "", fmt.Errorf("invalid path: %s", u.Path)
+		}
 	}
 
-	return &model.Resource{Repo: r, Path: name}, nil
+	repo, filePath, _ = strings.Cut(rest, "/")
+	if repo == "" {
+		return "", "", fmt.Errorf("invalid path: %s", u.Path)
+	}
+	return repo, strings.TrimSuffix(path.Join("/", filePath), "/"), nil
 }
 
 // handlePropfind handles PROPFIND requests
@@ -184,47 +257,42 @@ func handlePropfind(c *gin.Context) {
 		return
 	}
 
-	resource, err := getResource(c)
-	if err != nil {
+	// A missing Depth means infinity (RFC 4918 9.1), which is refused since listings
+	// of whole repositories are too expensive; clients walk the tree with Depth: 1 instead
+	depth := c.GetHeader("Depth")
+	switch strings.ToLower(depth) {
+	case "0", "1":
+	case "", "infinity":
+		sendError(c, http.StatusForbidden, "<D:propfind-finite-depth/>")
+		return
+	default:
+		sendError(c, http.StatusBadRequest, "Invalid Depth header")
 		return
 	}
 
-	// Parse the PROPFIND request using c.Bind
-	propfindReq := &PropfindRequest{}
-	if c.Request.ContentLength > 0 {
-		if err := c.Bind(propfindReq); err != nil {
-			sendError(c, http.StatusBadRequest, "Failed to parse XML: %v", err)
-			return
-		}
-	} else {
-		// If no body, default to allprop as per RFC
-		propfindReq.AllProp = &struct{}{}
+	// Parse the PROPFIND request whatever its content type, many clients send none
+	propfindReq, err := parsePropfind(c.Request.Body)
+	if errors.Is(err, ErrXMLTooLarge) {
+		sendError(c, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	} else if err != nil {
+		sendError(c, http.StatusBadRequest, "Failed to parse XML")
+		return
 	}
 
-	// Handle Depth header
-	depth := c.GetHeader("Depth")
-
-	// We only support Depth: 0 and Depth: 1
-	switch depth {
-	case "0", "1":
-		// Valid depth values, continue processing
-	default:
-		sendError(c, http.StatusForbidden, "Depth %s is not supported", depth)
+	resource, err := getResource(c)
+	if err != nil {
 		return
 	}
 
 	// Log request
 	log.Printf("Handling PROPFIND request for %s with depth %s", resource, depth)
 
-	if err := stor.CheckPermission(c, user.ID, resource, stor.PermissionRead); err != nil {
-		log.Printf("Permission denied for %s: %v", resource, err)
-		sendError(c, http.StatusForbidden, "Permission denied")
+	if err := perm.Evaluate(c, perm.User(user.ID), resource, perm.View); err != nil {
+		sendPermissionError(c, resource, err)
 		return
 	}
 
-	// Build response
-	ms := &Multistatus{DavNS: davNamespace}
-
 	// Get file info using storage abstraction
 	file, err := stor.GetFileInfo(c, resource)
 	if err != nil {
@@ -238,39 +306,161 @@ func handlePropfind(c *gin.Context) {
 		return
 	}
 
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		sendError(c, http.StatusBadRequest, "Invalid offset")
+		return
+	}
+
+	quota := getQuota(c, resource.Repo, propfindReq)
+
+	// From here on the body is streamed, so errors can only be reported inside the multistatus
+	ms, err := newMultistatusWriter(c)
+	if err != nil {
+		log.Printf("Failed to write PROPFIND response for %s: %s", resource, err)
+		return
+	}
+	defer ms.close()
+
+	// Answer under the name requested, which may be a former name of a renamed repository;
+	// clients match the hrefs against the URL they asked for
+	repoName := c.Param("repo")
+
 	// Add the file/directory itself
-	ms.Response = append(ms.Response, CreateResponse(c.Request.URL.Path, file, propfindReq))
+	self := resourceHref(repoName, resource.Path)
+	if err := ms.add(quota.apply(CreateResponse(self, file, propfindReq), file)); err != nil {
+		return
+	}
 
 	// If depth is 1 and it's a directory, list its contents
 	if depth == "1" && file.IsDir {
-		files, err := stor.ListDir(c, resource.Repo, file)
-		if err != nil {
-			log.Printf("Error reading directory %s: %v", resource, err)
-			sendError(c, http.StatusInternalServerError, "Failed to read directory: %v", err)
-			return
+		count := 0
+		limit := 0
+		if maxEntries > 0 {
+			limit = maxEntries + 1 // one more to tell whether the listing was cut short
 		}
 
-		for _, entry := range files {
-			// Construct proper href for each entry
-			entryHref := path.Join(c.Request.URL.Path, path.Base(entry.Path))
-			if entry.IsDir && !strings.HasSuffix(entryHref, "/") {
-				entryHref += "/"
+		err := stor.StreamDir(c, resource.Repo, file, offset, limit, func(entry *model.FileObject) error {
+			if count++; maxEntries > 0 && count > maxEntries {
+				return nil
 			}
-			ms.Response = append(ms.Response, CreateResponse(entryHref, entry, propfindReq))
+
+			entryHref := resourceHref(repoName, path.Join(resource.Path, path.Base(entry.Path)))
+			return ms.add(quota.apply(CreateResponse(entryHref, entry, propfindReq), entry))
+		})
+
+		switch {
+		case ms.err != nil:
+			// the client is gone, nothing more can be sent
+		case err != nil:
+			log.Printf("Error reading directory %s: %v", resource, err)
+			_ = ms.add(statusResponse(self+"/", http.StatusInternalServerError, nil, "Failed to read directory"))
+		case maxEntries > 0 && count > maxEntries:
+			log.Printf("PROPFIND listing of %s truncated at %d entries", resource, maxEntries)
+			next := offset + maxEntries
+			_ = ms.add(statusResponse(self+"/", http.StatusInsufficientStorage,
+				&ResponseError{NumberOfMatchesWithinLimits: &struct{}{}},
+				fmt.Sprintf("Listing truncated after %d entries, continue with ?offset=%d", maxEntries, next)))
 		}
 	}
+}
+
+// quotaProps holds the RFC 4331 quota properties of the collections in a repository
+type quotaProps struct {
+	req       *PropfindProp
+	used      int64
+	available int64
+}
+
+// getQuota looks up the quota of the repository owner if the request asks for it, nil otherwise
+func getQuota(ctx context.Context, repo *model.Repository, req *PropfindRequest) *quotaProps {
+	if req.Prop == nil || (req.Prop.QuotaAvailable == nil && req.Prop.QuotaUsed == nil) {
+		return nil
+	}
 
-	XML(c, http.StatusMultiStatus, ms)
+	used, available, err := users.Usage(ctx, repo.OwnerID)
+	if err != nil {
+		log.Printf("Failed to get quota of user %d: %s", repo.OwnerID, err)
+		return nil
+	}
+	return &quotaProps{req: req.Prop, used: used, available: available}
 }
 
-func XML(c *gin.Context, code int, body any) {
-	var buf bytes.Buffer
-	fmt.Fprint(&buf, xml.Header)
-	if err := xml.NewEncoder(&buf).Encode(body); err != nil {
-		log.Printf("Failed to encode XML: %s", err)
+// apply adds the requested quota properties to the response of a collection
+func (q *quotaProps) apply(resp Response, file *model.FileObject) Response {
+	if q == nil || !file.IsDir {
+		return resp
+	}
+
+	if q.req.QuotaAvailable != nil {
+		resp.Propstat.Prop.QuotaAvailable = strconv.FormatInt(q.available, 10)
 	}
+	if q.req.QuotaUsed != nil {
+		resp.Propstat.Prop.QuotaUsed = strconv.FormatInt(q.used, 10)
+	}
+	return resp
+}
 
-	c.Data(code, "text/xml; charset=utf-8", buf.Bytes())
+// multistatusWriter streams a 207 Multi-Status body one response at a time,
+// so listing a huge collection never holds the whole document in memory
+type multistatusWriter struct {
+	w   gin.ResponseWriter
+	enc *xml.Encoder
+	err error // first write error, after which nothing more is sent
+}
+
+var responseElement = xml.StartElement{Name: xml.Name{Local: "D:response"}}
+
+func multistatusElement() xml.StartElement {
+	return xml.StartElement{
+		Name: xml.Name{Local: "D:multistatus"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "xmlns:D"}, Value: davNamespace},
+			{Name: xml.Name{Local: "xmlns:H"}, Value: hubNamespace},
+		},
+	}
+}
+
+// newMultistatusWriter sends the status, headers and the opening multistatus element
+func newMultistatusWriter(c *gin.Context) (*multistatusWriter, error) {
+	c.Header("Content-Type", "text/xml; charset=utf-8")
+	c.Status(http.StatusMultiStatus)
+
+	ms := &multistatusWriter{w: c.Writer, enc: xml.NewEncoder(c.Writer)}
+	if _, err := c.Writer.WriteString(xml.Header); err != nil {
+		return nil, err
+	}
+	if err := ms.enc.EncodeToken(multistatusElement()); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// add encodes one response element and sends it on
+func (ms *multistatusWriter) add(resp any) error {
+	if ms.err != nil {
+		return ms.err
+	}
+
+	if ms.err = ms.enc.EncodeElement(resp, responseElement); ms.err != nil {
+		log.Printf("Failed to write multistatus response: %s", ms.err)
+	}
+	return ms.err
+}
+
+// close ends the multistatus element and flushes what is buffered
+func (ms *multistatusWriter) close() {
+	if ms.err != nil {
+		return
+	}
+
+	if err := ms.enc.EncodeToken(multistatusElement().End()); err != nil {
+		log.Printf("Failed to write multistatus response: %s", err)
+		return
+	}
+	if err := ms.enc.Flush(); err == nil {
+		ms.w.Flush()
+	}
 }
 
 // CreateResponse creates a WebDAV response with proper properties (exported for testing)
@@ -279,6 +469,9 @@ func CreateResponse(href string, file *model.FileObject, req *PropfindRequest) R
 	if name == "." || name == "/" {
 		name = ""
 	}
+	if file.IsDir && !strings.HasSuffix(href, "/") {
+		href += "/"
+	}
 
 	prop := Prop{}
 
@@ -298,16 +491,13 @@ func CreateResponse(href string, file *model.FileObject, req *PropfindRequest) R
 			// For directories, specify the resourcetype as collection
 			prop.ResourceType = CollectionType()
 			prop.ContentType = "httpd/unix-directory"
-			if !strings.HasSuffix(href, "/") {
-				href = href + "/"
-			}
 		} else {
 			// For files, leave resourcetype empty and specify content type and length
 			prop.ResourceType = nil
 			prop.ContentType = file.ContentType()
 			prop.Length = fmt.Sprintf("%d", file.Size)
-			// Generate a simple etag based on modtime and size
-			prop.ETag = fmt.Sprintf("%x-%x", file.ModTime.Unix(), file.Size)
+			prop.ETag = file.ETag()
+			prop.Immutable = immutableProp(file)
 		}
 	} else {
 		// Specific properties requested
@@ -338,14 +528,15 @@ func CreateResponse(href string, file *model.FileObject, req *PropfindRequest) R
 			prop.Length = fmt.Sprintf("%d", file.Size)
 		}
 		if req.Prop.ETag != nil && !file.IsDir {
-			prop.ETag = fmt.Sprintf("%x-%x", file.ModTime.Unix(), file.Size)
+			prop.ETag = file.ETag()
+		}
+		if req.Prop.Immutable != nil && !file.IsDir {
+			prop.Immutable = immutableProp(file)
 		}
 	}
 
-	u := &url.URL{Path: href}
-
 	return Response{
-		Href: u.EscapedPath(),
+		Href: escapeHref(href),
 		Propstat: Propstat{
 			Prop:   prop,
 			Status: "HTTP/1.1 200 OK",
@@ -353,6 +544,14 @@ func CreateResponse(href string, file *model.FileObject, req *PropfindRequest) R
 	}
 }
 
+// immutableProp is the value of the immutable property of a file
+func immutableProp(file *model.FileObject) string {
+	if file.Immutable {
+		return "1"
+	}
+	return "0"
+}
+
 // handlePut handles PUT requests
 func handlePut(c *gin.Context) {
 	// Get authenticated user
@@ -367,18 +566,71 @@ func handlePut(c *gin.Context) {
 		return
 	}
 
-	if err := stor.CheckPermission(c, user.ID, resource, stor.PermissionWrite); err != nil {
-		sendError(c, http.StatusForbidden, "Permission denied")
+	if err := perm.Evaluate(c, perm.User(user.ID), resource, perm.Write); err != nil {
+		sendPermissionError(c, resource, err)
+		return
+	}
+
+	existing, err := stor.GetFileInfo(c, resource)
+	if err != nil && !stor.IsNotFound(err) {
+		sendError(c, http.StatusInternalServerError, "Error accessing file: %v", err)
+		return
+	}
+	if existing != nil && existing.IsDir {
+		sendError(c, http.StatusMethodNotAllowed, "Cannot PUT to a collection")
+		return
+	}
+	if existing != nil && existing.Immutable {
+		sendError(c, http.StatusLocked, "File is immutable")
+		return
+	}
+
+	// RFC 4918 9.7.1: the parent collection must exist, it is not created implicitly
+	if _, err := stor.ResolveParent(c, resource.Repo, resource.Path, nil); errors.Is(err, stor.ErrParentNotFound) {
+		sendError(c, http.StatusConflict, "Parent collection does not exist")
+		return
+	} else if err != nil {
+		sendError(c, http.StatusInternalServerError, "Error accessing parent: %v", err)
+		return
+	}
+
+	modTime, err := sync.ParseModTime(c.GetHeader(sync.ModTimeHeader), time.Now())
+	if err != nil {
+		sendError(c, http.StatusBadRequest, "Invalid %s header", sync.ModTimeHeader)
 		return
 	}
 
 	// Write file using storage abstraction
-	if err := stor.PutFile(c, resource, c.Request.Body); err != nil {
+	checksum, err := stor.PutFile(c, resource, c.Request.Body)
+	if errors.Is(err, stor.ErrImmutable) {
+		sendError(c, http.StatusLocked, "File is immutable")
+		return
+	} else if errors.Is(err, stor.ErrReserved) {
+		sendError(c, http.StatusForbidden, "%v", err)
+		return
+	} else if errors.Is(err, stor.ErrTooLarge) {
+		sendError(c, http.StatusRequestEntityTooLarge, "File too large")
+		return
+	} else if err != nil {
 		sendError(c, http.StatusInternalServerError, "Failed to write file: %v", err)
 		return
 	}
 
-	c.Status(http.StatusCreated)
+	if !modTime.IsZero() {
+		if err := stor.SetModTime(c, resource, modTime); err != nil {
+			sendError(c, http.StatusInternalServerError, "Failed to set modification time: %v", err)
+			return
+		}
+		c.Header("X-OC-MTime", "accepted")
+	}
+
+	// The tag of the content just written, as PROPFIND and GET report it from now on
+	c.Header("ETag", `"`+checksum+`"`)
+	if existing != nil {
+		c.Status(http.StatusNoContent)
+	} else {
+		c.Status(http.StatusCreated)
+	}
 }
 
 // handleDelete handles DELETE requests
@@ -395,13 +647,24 @@ func handleDelete(c *gin.Context) {
 		return
 	}
 
-	if err := stor.CheckPermission(c, user.ID, resource, stor.PermissionDelete); err != nil {
-		log.Printf("Permission denied for %s: %v", resource, err)
-		sendError(c, http.StatusForbidden, "Permission denied")
+	if err := perm.Evaluate(c, perm.User(user.ID), resource, perm.Delete); err != nil {
+		sendPermissionError(c, resource, err)
+		return
+	}
+
+	if resource.Path == "" {
+		sendError(c, http.StatusForbidden, "Cannot delete the repository root")
 		return
 	}
 
-	if err := stor.DeleteFile(c, resource); err != nil {
+	// RFC 4918 9.6.1: deleting a collection deletes everything below it
+	if err := stor.DeleteTree(c, resource); stor.IsNotFound(err) {
+		sendError(c, http.StatusNotFound, "File not found")
+		return
+	} else if errors.Is(err, stor.ErrImmutable) {
+		sendError(c, http.StatusLocked, "%v", err)
+		return
+	} else if err != nil {
 		sendError(c, http.StatusInternalServerError, "Failed to delete file: %v", err)
 		return
 	}
@@ -417,18 +680,38 @@ func handleMkcol(c *gin.Context) {
 		return
 	}
 
+	// RFC 4918 9.3: bodies are not defined for MKCOL
+	if c.Request.ContentLength != 0 {
+		sendError(c, http.StatusUnsupportedMediaType, "MKCOL does not accept a body")
+		return
+	}
+
 	resource, err := getResource(c)
 	if err != nil {
 		return
 	}
 
-	if err := stor.CheckPermission(c, user.ID, resource, stor.PermissionWrite); err != nil {
-		log.Printf("Permission denied for %s: %v", resource, err)
-		sendError(c, http.StatusForbidden, "Permission denied")
+	if err := perm.Evaluate(c, perm.User(user.ID), resource, perm.Write); err != nil {
+		sendPermissionError(c, resource, err)
 		return
 	}
 
-	if err := stor.CreateDir(c, resource); err != nil {
+	if _, err := stor.GetFileInfo(c, resource); err == nil {
+		sendError(c, http.StatusMethodNotAllowed, "Resource already exists")
+		return
+	} else if !stor.IsNotFound(err) {
+		sendError(c, http.StatusInternalServerError, "Error accessing file: %v", err)
+		return
+	}
+
+	if _, err := stor.CreateDir(c, resource, nil); errors.Is(err, stor.ErrParentNotFound) {
+		// RFC 4918 9.3.1: intermediate collections are never created implicitly
+		sendError(c, http.StatusConflict, "Parent collection does not exist")
+		return
+	} else if errors.Is(err, stor.ErrReserved) {
+		sendError(c, http.StatusForbidden, "%v", err)
+		return
+	} else if err != nil {
 		sendError(c, http.StatusInternalServerError, "Failed to create directory: %v", err)
 		return
 	}
@@ -444,47 +727,99 @@ func handleCopyMove(c *gin.Context) {
 		return
 	}
 
+	destination := c.Request.Header.Get("Destination")
+	if u, err := url.Parse(destination); err == nil && !sameServer(c, u.Host) {
+		// RFC 4918 9.8.5: copies to other servers are not made
+		sendError(c, http.StatusBadGateway, "Destination is on another server")
+		return
+	}
+
 	resource, err := getResource(c)
 	if err != nil {
 		return
 	}
 
-	// Parse destination path
-	destination := c.Request.Header.Get("Destination")
+	// Parse destination path, which may lie in another user's repository
 	destRes, err := getResourceByUrl(c.Request.Context(), destination)
 	if err != nil {
 		sendError(c, http.StatusBadRequest, "Invalid destination: %s", err)
 		return
 	}
 
-	if err := stor.CheckPermission(c, user.ID, resource, stor.PermissionRead); err != nil {
-		sendError(c, http.StatusForbidden, "Permission denied for source")
+	if err := netacl.CheckRepo(c, destRes.Repo, c.ClientIP(), user.Username); err != nil {
+		sendError(c, http.StatusForbidden, "Access to destination repository not allowed from this network")
 		return
 	}
 
-	if err := stor.CheckPermission(c, user.ID, destRes, stor.PermissionWrite); err != nil {
-		sendError(c, http.StatusForbidden, "Permission denied for destination")
+	// Handle COPY or MOVE, into another repository too, such as a folder shared writable with the user.
+	// Moving takes the source away, which needs the permission to delete it.
+	op, transfer, action := "copy", stor.CopyFile, perm.Read
+	if c.Request.Method == "MOVE" {
+		op, transfer, action = "move", stor.MoveFile, perm.Delete
+	}
+
+	if err := perm.Evaluate(c, perm.User(user.ID), resource, action); err != nil {
+		sendPermissionError(c, resource, err)
 		return
 	}
 
-	// Handle COPY or MOVE
-	if c.Request.Method == "COPY" {
-		// Copy file/directory using storage
-		if err := stor.CopyFile(c, resource, destRes); err != nil {
-			sendError(c, http.StatusInternalServerError, "Failed to copy file: %v", err)
-			return
-		}
-	} else {
-		// Move file/directory using storage
-		if err := stor.MoveFile(c, resource, destRes); err != nil {
-			sendError(c, http.StatusInternalServerError, "Failed to move file: %v", err)
+	// Collections stay within their repository, refused up front as stor would refuse them
+	if resource.Repo.ID != destRes.Repo.ID {
+		if info, err := stor.GetFileInfo(c, resource); err == nil && info.IsDir {
+			sendError(c, http.StatusForbidden, "%v", stor.ErrCrossRepoDir)
 			return
 		}
 	}
 
+	if err := perm.Evaluate(c, perm.User(user.ID), destRes, perm.Write); err != nil {
+		sendPermissionError(c, destRes, err)
+		return
+	}
+
+	if err := transfer(c, resource, destRes); err != nil {
+		sendCopyMoveError(c, op, err)
+		return
+	}
+
 	c.Status(http.StatusCreated)
 }
 
+// sendPermissionError reports why an action on a resource was refused: 423 for a locked file, 403 otherwise
+func sendPermissionError(c *gin.Context, resource *model.Resource, err error) {
+	log.Printf("Permission denied for %s: %v", resource, err)
+	switch {
+	case errors.Is(err, stor.ErrImmutable):
+		sendError(c, http.StatusLocked, "%v", err)
+	case errors.Is(err, perm.ErrReadOnly):
+		sendError(c, http.StatusForbidden, "Server is read-only")
+	default:
+		sendError(c, http.StatusForbidden, "Permission denied")
+	}
+}
+
+// sameServer reports whether a host named by a Destination header, if any, is this server as the client
+// reached it, possibly through a proxy
+func sameServer(c *gin.Context, host string) bool {
+	return host == "" || host == c.Request.Host || host == c.GetHeader("X-Forwarded-Host")
+}
+
+// sendCopyMoveError reports why a file could not be copied or moved
+func sendCopyMoveError(c *gin.Context, op string, err error) {
+	switch {
+	case errors.Is(err, stor.ErrImmutable):
+		sendError(c, http.StatusLocked, "%v", err)
+	case stor.IsNotFound(err):
+		sendError(c, http.StatusNotFound, "Source not found")
+	case errors.Is(err, stor.ErrParentNotFound):
+		// RFC 4918 9.8.5: intermediate collections of the destination are never created
+		sendError(c, http.StatusConflict, "Destination parent collection does not exist")
+	case errors.Is(err, stor.ErrCrossRepoDir), errors.Is(err, stor.ErrReserved):
+		sendError(c, http.StatusForbidden, "%v", err)
+	default:
+		sendError(c, http.StatusInternalServerError, "Failed to %s file: %v", op, err)
+	}
+}
+
 // handleGet handles GET requests
 func handleGet(c *gin.Context) {
 	// Get authenticated user
@@ -499,15 +834,14 @@ func handleGet(c *gin.Context) {
 		return
 	}
 
-	if err := stor.CheckPermission(c, user.ID, resource, stor.PermissionRead); err != nil {
-		log.Printf("Permission denied for %s: %v", resource, err)
-		sendError(c, http.StatusForbidden, "Permission denied")
+	if err := perm.Evaluate(c, perm.User(user.ID), resource, perm.Read); err != nil {
+		sendPermissionError(c, resource, err)
 		return
 	}
 
 	info, err := stor.GetFileInfo(c, resource)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if stor.IsNotFound(err) {
 			sendError(c, http.StatusNotFound, "File not found")
 			return
 		}
@@ -520,9 +854,6 @@ func handleGet(c *gin.Context) {
 		return
 	}
 
-	c.Header("Content-Type", info.ContentType())
-	c.Header("Content-Length", fmt.Sprintf("%d", info.Size))
-
 	file, err := stor.OpenFile(c, resource)
 	if err != nil {
 		sendError(c, http.StatusInternalServerError, "Error opening file: %v", err)
@@ -530,12 +861,12 @@ func handleGet(c *gin.Context) {
 	}
 	defer file.Close()
 
-	if _, err := io.Copy(c.Writer, file); err != nil {
-		log.Printf("Failed to copy file content: %s", err)
-	}
+	c.Header(sync.ModTimeHeader, strconv.FormatInt(info.ModTime.Unix(), 10))
+	c.Header("ETag", info.ETag())
+	serve.File(c, info, file)
 }
 
 func handleOptions(c *gin.Context) {
-	c.Header("Allow", "OPTIONS,GET,POST,PUT,DELETE,COPY,MOVE,PROPFIND,MKCOL,LOCK,UNLOCK")
+	c.Header("Allow", "OPTIONS,GET,PUT,DELETE,COPY,MOVE,PROPFIND,MKCOL")
 	c.Status(http.StatusOK)
 }
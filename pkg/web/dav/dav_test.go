@@ -1,7 +1,12 @@
 package dav
 
 import (
+	"context"
 	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -68,7 +73,15 @@ func TestCreateResponseAllProp(t *testing.T) {
 	assert.Empty(t, response.Propstat.Prop.ResourceType)
 	assert.Equal(t, "application/octet-stream", response.Propstat.Prop.ContentType)
 	assert.Equal(t, "1024", response.Propstat.Prop.Length)
+	assert.Equal(t, fmt.Sprintf(`"%x-400"`, modTime.Unix()), response.Propstat.Prop.ETag)
 	assert.Equal(t, "HTTP/1.1 200 OK", response.Propstat.Status)
+
+	// Files with a checksum are tagged by it, however often they are written with the same content
+	checksum := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	file.Checksum = &checksum
+	file.ModTime = modTime.Add(time.Hour)
+	response = CreateResponse("/test.txt", file, &PropfindRequest{Prop: &PropfindProp{ETag: &struct{}{}}})
+	assert.Equal(t, `"`+checksum+`"`, response.Propstat.Prop.ETag)
 }
 
 func TestCreateResponseSpecificProps(t *testing.T) {
@@ -139,3 +152,232 @@ func TestHandlePropfindWithEmptyBody(t *testing.T) {
 	// but we can at least verify the XML parsing works with an empty body
 	// For now, we've tested the individual components above
 }
+
+func TestQuotaProps(t *testing.T) {
+	propXML := `<D:propfind xmlns:D="DAV:"><D:prop><D:quota-available-bytes/><D:quota-used-bytes/></D:prop></D:propfind>`
+	req := &PropfindRequest{}
+	assert.NoError(t, xml.Unmarshal([]byte(propXML), req))
+	assert.NotNil(t, req.Prop.QuotaAvailable)
+	assert.NotNil(t, req.Prop.QuotaUsed)
+
+	quota := &quotaProps{req: req.Prop, used: 1024, available: 4096}
+	dir := &model.FileObject{Path: "/docs", IsDir: true}
+	resp := quota.apply(CreateResponse("/dav/repo/docs/", dir, req), dir)
+	assert.Equal(t, "4096", resp.Propstat.Prop.QuotaAvailable)
+	assert.Equal(t, "1024", resp.Propstat.Prop.QuotaUsed)
+
+	data, err := xml.Marshal(resp)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "<D:quota-available-bytes>4096</D:quota-available-bytes>")
+	assert.Contains(t, string(data), "<D:quota-used-bytes>1024</D:quota-used-bytes>")
+
+	// Only collections carry quota properties
+	file := &model.FileObject{Path: "/docs/a.txt", Size: 10}
+	resp = quota.apply(CreateResponse("/dav/repo/docs/a.txt", file, req), file)
+	assert.Empty(t, resp.Propstat.Prop.QuotaAvailable)
+	assert.Empty(t, resp.Propstat.Prop.QuotaUsed)
+
+	// Not looked up unless requested by name
+	assert.Nil(t, getQuota(context.Background(), &model.Repository{}, &PropfindRequest{AllProp: &struct{}{}}))
+}
+
+func TestImmutableProp(t *testing.T) {
+	propXML := `<D:propfind xmlns:D="DAV:" xmlns:H="urn:cgang:file-hub"><D:prop><H:immutable/></D:prop></D:propfind>`
+	req := &PropfindRequest{}
+	assert.NoError(t, xml.Unmarshal([]byte(propXML), req))
+	assert.NotNil(t, req.Prop.Immutable)
+
+	file := &model.FileObject{Path: "/ref.pdf", Size: 10, Immutable: true}
+	resp := CreateResponse("/dav/repo/ref.pdf", file, req)
+	assert.Equal(t, "1", resp.Propstat.Prop.Immutable)
+
+	data, err := xml.Marshal(resp)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "<H:immutable>1</H:immutable>")
+
+	file.Immutable = false
+	assert.Equal(t, "0", CreateResponse("/dav/repo/ref.pdf", file, req).Propstat.Prop.Immutable)
+
+	// Collections have no immutable flag
+	dir := &model.FileObject{Path: "/docs", IsDir: true}
+	assert.Empty(t, CreateResponse("/dav/repo/docs/", dir, req).Propstat.Prop.Immutable)
+	assert.Empty(t, CreateResponse("/dav/repo/docs/", dir, &PropfindRequest{AllProp: &struct{}{}}).Propstat.Prop.Immutable)
+}
+
+func TestMultistatusWriter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	ms, err := newMultistatusWriter(c)
+	assert.NoError(t, err)
+
+	req := &PropfindRequest{AllProp: &struct{}{}}
+	dir := &model.FileObject{Path: "/big dir", IsDir: true}
+	assert.NoError(t, ms.add(CreateResponse("/dav/repo/big dir/", dir, req)))
+	assert.NoError(t, ms.add(statusResponse("/dav/repo/big dir/", http.StatusInsufficientStorage,
+		&ResponseError{NumberOfMatchesWithinLimits: &struct{}{}}, "truncated")))
+	ms.close()
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Equal(t, "text/xml; charset=utf-8", w.Header().Get("Content-Type"))
+
+	var body struct {
+		Responses []struct {
+			Href        string    `xml:"DAV: href"`
+			Status      string    `xml:"DAV: status"`
+			PropStatus  string    `xml:"DAV: propstat>status"`
+			Error       *xml.Name `xml:"DAV: error>number-of-matches-within-limits"`
+			Description string    `xml:"DAV: responsedescription"`
+		} `xml:"DAV: response"`
+	}
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &body))
+	assert.Len(t, body.Responses, 2)
+	assert.Equal(t, "/dav/repo/big%20dir/", body.Responses[0].Href)
+	assert.Equal(t, "HTTP/1.1 200 OK", body.Responses[0].PropStatus)
+	assert.Equal(t, "HTTP/1.1 507 Insufficient Storage", body.Responses[1].Status)
+	assert.NotNil(t, body.Responses[1].Error)
+	assert.Equal(t, "truncated", body.Responses[1].Description)
+}
+
+func TestHrefRoundTrip(t *testing.T) {
+	defer func(base string) { basePath = base }(basePath)
+
+	names := []string{"plain.txt", "with space.txt", "hash#tag.md", "100%.csv", "日本語.txt", "a?b&c=d"}
+	for _, base := range []string{"", "/files"} {
+		basePath = base
+		for _, name := range names {
+			filePath := "/docs/" + name
+			file := &model.FileObject{Path: filePath}
+			resp := CreateResponse(resourceHref("my repo", filePath), file, &PropfindRequest{AllProp: &struct{}{}})
+
+			assert.True(t, strings.HasPrefix(resp.Href, base+"/dav/my%20repo/docs/"), resp.Href)
+			assert.NotContains(t, resp.Href, "#")
+			assert.NotContains(t, resp.Href, "?")
+
+			repo, parsed, err := parseHref("http://example.com" + resp.Href)
+			assert.NoError(t, err)
+			assert.Equal(t, "my repo", repo)
+			assert.Equal(t, filePath, parsed)
+		}
+	}
+}
+
+func TestParseHref(t *testing.T) {
+	defer func(base string) { basePath = base }(basePath)
+	basePath = "/files"
+
+	repo, filePath, err := parseHref("/dav/repo/a%20b/")
+	assert.NoError(t, err)
+	assert.Equal(t, "repo", repo)
+	assert.Equal(t, "/a b", filePath)
+
+	repo, filePath, err = parseHref("https://host/files/dav/repo")
+	assert.NoError(t, err)
+	assert.Equal(t, "repo", repo)
+	assert.Equal(t, "", filePath)
+
+	_, _, err = parseHref("/other/repo/a.txt")
+	assert.Error(t, err)
+}
+
+func TestCreateResponseCollectionHref(t *testing.T) {
+	dir := &model.FileObject{Path: "/docs", IsDir: true}
+	req := &PropfindRequest{Prop: &PropfindProp{DisplayName: &struct{}{}}}
+	assert.Equal(t, "/dav/repo/docs/", CreateResponse("/dav/repo/docs", dir, req).Href)
+}
+
+func TestPropfindDepth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := []struct {
+		depth  string
+		status int
+	}{
+		{"", http.StatusForbidden}, // infinity by default
+		{"infinity", http.StatusForbidden},
+		{"Infinity", http.StatusForbidden},
+		{"2", http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("PROPFIND", "/dav/repo/docs/", nil)
+		if tc.depth != "" {
+			c.Request.Header.Set("Depth", tc.depth)
+		}
+		c.Set("user", &model.User{ID: 1})
+
+		handlePropfind(c)
+		assert.Equal(t, tc.status, w.Code, "Depth: %q", tc.depth)
+		if tc.status == http.StatusForbidden {
+			assert.Contains(t, w.Body.String(), `<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`)
+		}
+	}
+}
+
+func TestMkcolWithBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("MKCOL", "/dav/repo/docs/", strings.NewReader("<foo/>"))
+	c.Request.Header.Set("Content-Type", "xzy-foo/bar-512")
+	c.Set("user", &model.User{ID: 1})
+
+	handleMkcol(c)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+// BenchmarkMultistatusWriter measures encoding a Depth: 1 listing of a large collection
+func BenchmarkMultistatusWriter(b *testing.B) {
+	gin.SetMode(gin.TestMode)
+	req := &PropfindRequest{AllProp: &struct{}{}}
+	modTime := time.Now()
+	entries := make([]*model.FileObject, 10000)
+	for i := range entries {
+		entries[i] = &model.FileObject{Path: fmt.Sprintf("/big/file %05d.txt", i), Size: int64(i), ModTime: modTime}
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ms, err := newMultistatusWriter(c)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, entry := range entries {
+			if err := ms.add(CreateResponse(resourceHref("repo", entry.Path), entry, req)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		ms.close()
+	}
+}
+
+func TestCopyMoveOtherServer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, method := range []string{"COPY", "MOVE"} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(method, "http://files.example.com/dav/alice/a.txt", nil)
+		c.Request.Header.Set("Destination", "http://other.example.com/dav/bob/a.txt")
+		c.Set("user", &model.User{ID: 1})
+
+		handleCopyMove(c)
+		assert.Equal(t, http.StatusBadGateway, w.Code, method)
+	}
+}
+
+func TestSameServer(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("MOVE", "http://127.0.0.1:8080/dav/alice/a.txt", nil)
+	assert.True(t, sameServer(c, ""))
+	assert.True(t, sameServer(c, "127.0.0.1:8080"))
+	assert.False(t, sameServer(c, "files.example.com"))
+
+	c.Request.Header.Set("X-Forwarded-Host", "files.example.com")
+	assert.True(t, sameServer(c, "files.example.com"))
+}
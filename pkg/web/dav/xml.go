@@ -0,0 +1,88 @@
+package dav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// maxXMLBodySize caps the size of a request body parsed as XML
+	maxXMLBodySize int64 = 1 << 20
+	// maxXMLDepth caps how deep elements of a request body may be nested
+	maxXMLDepth = 32
+	// maxXMLElements caps the number of elements in a request body
+	maxXMLElements = 10000
+)
+
+var (
+	// ErrXMLTooLarge is returned for a request body over maxXMLBodySize
+	ErrXMLTooLarge = errors.New("XML body too large")
+	// ErrXMLTooComplex is returned for a request body nested too deep or with too many elements
+	ErrXMLTooComplex = errors.New("XML body too complex")
+	// ErrXMLDoctype is returned for a request body with a document type declaration, which
+	// could declare entities; WebDAV bodies never need one
+	ErrXMLDoctype = errors.New("XML document type declarations are not allowed")
+)
+
+// readXML reads a request body of at most maxXMLBodySize bytes and checks it stays within the
+// nesting and element limits before it is decoded, so that a hostile body cannot exhaust memory
+func readXML(body io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, maxXMLBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxXMLBodySize {
+		return nil, ErrXMLTooLarge
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	depth, elements := 0, 0
+	for {
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if depth++; depth > maxXMLDepth {
+				return nil, fmt.Errorf("%w: nested deeper than %d", ErrXMLTooComplex, maxXMLDepth)
+			}
+			if elements++; elements > maxXMLElements {
+				return nil, fmt.Errorf("%w: more than %d elements", ErrXMLTooComplex, maxXMLElements)
+			}
+		case xml.EndElement:
+			depth--
+		case xml.Directive:
+			if bytes.HasPrefix(bytes.TrimSpace(t), []byte("DOCTYPE")) || bytes.Contains(t, []byte("ENTITY")) {
+				return nil, ErrXMLDoctype
+			}
+		}
+	}
+	return data, nil
+}
+
+// parsePropfind parses the body of a PROPFIND request within the XML limits.
+// An empty body asks for all properties (RFC 4918 9.1).
+func parsePropfind(body io.Reader) (*PropfindRequest, error) {
+	data, err := readXML(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &PropfindRequest{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		req.AllProp = &struct{}{}
+		return req, nil
+	}
+
+	if err := xml.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
@@ -0,0 +1,73 @@
+package dav
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePropfind(t *testing.T) {
+	t.Run("Empty body asks for all properties", func(t *testing.T) {
+		req, err := parsePropfind(strings.NewReader(""))
+		require.NoError(t, err)
+		assert.NotNil(t, req.AllProp)
+	})
+
+	t.Run("Named properties", func(t *testing.T) {
+		req, err := parsePropfind(strings.NewReader(`<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:getetag/></D:prop></D:propfind>`))
+		require.NoError(t, err)
+		require.NotNil(t, req.Prop)
+		assert.NotNil(t, req.Prop.ETag)
+	})
+
+	t.Run("Wrong root element", func(t *testing.T) {
+		_, err := parsePropfind(strings.NewReader(`<D:propfind xmlns:D="http://example.com/"><D:allprop/></D:propfind>`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Body too large", func(t *testing.T) {
+		body := `<D:propfind xmlns:D="DAV:"><D:allprop/>` + strings.Repeat(" ", int(maxXMLBodySize)) + `</D:propfind>`
+		_, err := parsePropfind(strings.NewReader(body))
+		assert.ErrorIs(t, err, ErrXMLTooLarge)
+	})
+
+	t.Run("Nested too deep", func(t *testing.T) {
+		body := `<D:propfind xmlns:D="DAV:"><D:prop>` + strings.Repeat("<x>", maxXMLDepth) + strings.Repeat("</x>", maxXMLDepth) + `</D:prop></D:propfind>`
+		_, err := parsePropfind(strings.NewReader(body))
+		assert.ErrorIs(t, err, ErrXMLTooComplex)
+	})
+
+	t.Run("Too many elements", func(t *testing.T) {
+		body := `<D:propfind xmlns:D="DAV:"><D:prop>` + strings.Repeat("<D:getetag/>", maxXMLElements) + `</D:prop></D:propfind>`
+		_, err := parsePropfind(strings.NewReader(body))
+		assert.ErrorIs(t, err, ErrXMLTooComplex)
+	})
+
+	t.Run("Entity declarations", func(t *testing.T) {
+		body := `<?xml version="1.0"?><!DOCTYPE propfind [<!ENTITY xxe SYSTEM "file:///etc/passwd">]>` +
+			`<D:propfind xmlns:D="DAV:"><D:prop><D:displayname>&xxe;</D:displayname></D:prop></D:propfind>`
+		_, err := parsePropfind(strings.NewReader(body))
+		assert.ErrorIs(t, err, ErrXMLDoctype)
+	})
+}
+
+func FuzzParsePropfind(f *testing.F) {
+	f.Add(``)
+	f.Add(`<D:propfind xmlns:D="DAV:"><D:allprop/></D:propfind>`)
+	f.Add(`<D:propfind xmlns:D="DAV:"><D:propname/></D:propfind>`)
+	f.Add(`<D:propfind xmlns:D="DAV:"><D:prop><D:displayname/><D:quota-used-bytes/></D:prop></D:propfind>`)
+	f.Add(`<!DOCTYPE x [<!ENTITY a "aaaa">]><D:propfind xmlns:D="DAV:">&a;</D:propfind>`)
+	f.Add(`<D:propfind xmlns:D="DAV:"><D:prop><a><b><c/></b></a></D:prop></D:propfind>`)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		req, err := parsePropfind(strings.NewReader(body))
+		if err != nil {
+			return
+		}
+		if req == nil {
+			t.Fatal("no request and no error")
+		}
+	})
+}
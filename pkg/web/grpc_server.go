@@ -2,6 +2,7 @@ package web
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
@@ -29,17 +30,47 @@ func StartGRPCServer(grpcPort int) error {
 	if err != nil {
 		return fmt.Errorf("failed to create gRPC listener: %w", err)
 	}
+	if serverTLS != nil {
+		// The server is shared with gRPC-Web, so TLS wraps the listener rather than the server
+		tc := serverTLS.Clone()
+		tc.NextProtos = []string{"h2"}
+		grpcListener = tls.NewListener(grpcListener, tc)
+	}
+
+	server, err := newGRPCServer()
+	if err != nil {
+		return err
+	}
+
+	// Start server in background
+	go func() {
+		log.Printf("Starting gRPC server on port %d", grpcPort)
+		if err := server.Serve(grpcListener); err != nil && err != grpc.ErrServerStopped {
+			log.Printf("gRPC server error: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// newGRPCServer returns the shared gRPC server, creating it on first use.
+// The same server backs both the native gRPC listener and the gRPC-Web
+// endpoint on the HTTP port, so they share the auth interceptors.
+func newGRPCServer() (*grpc.Server, error) {
+	if grpcServer != nil {
+		return grpcServer, nil
+	}
 
 	// Get database connection
 	database := db.GetDB()
 	if database == nil {
-		return fmt.Errorf("database connection not available")
+		return nil, fmt.Errorf("database connection not available")
 	}
 
 	// Create gRPC server with interceptors
 	grpcServer = grpc.NewServer(
-		grpc.UnaryInterceptor(sync.AuthInterceptor()),
-		grpc.StreamInterceptor(sync.StreamAuthInterceptor()),
+		grpc.ChainUnaryInterceptor(stampOperationUnary, countQueriesUnary, sync.AuthInterceptor(), meterTransferUnary),
+		grpc.ChainStreamInterceptor(stampOperationStream, countQueriesStream, sync.StreamAuthInterceptor(), meterTransferStream),
 		grpc.MaxRecvMsgSize(100*1024*1024), // 100MB max message size for uploads
 		grpc.MaxSendMsgSize(100*1024*1024), // 100MB max message size for downloads
 	)
@@ -47,16 +78,9 @@ func StartGRPCServer(grpcPort int) error {
 	// Register sync service
 	syncService := sync.NewGRPCService(database)
 	sync.RegisterSyncServiceServer(grpcServer, syncService)
+	sync.RegisterLegacySyncServiceServer(grpcServer, syncService)
 
-	// Start server in background
-	go func() {
-		log.Printf("Starting gRPC server on port %d", grpcPort)
-		if err := grpcServer.Serve(grpcListener); err != nil && err != grpc.ErrServerStopped {
-			log.Printf("gRPC server error: %v", err)
-		}
-	}()
-
-	return nil
+	return grpcServer, nil
 }
 
 // StopGRPCServer gracefully stops the gRPC server
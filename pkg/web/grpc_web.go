@@ -0,0 +1,97 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+)
+
+// registerGRPCWeb exposes the gRPC services on the HTTP engine using the
+// gRPC-Web protocol, so browser clients can call them without an HTTP/2
+// proxy. Requests go through the same gRPC server and interceptors as the
+// native gRPC port.
+//
+// Browsers send cookies along with cross-origin calls, so only origins listed
+// by name may call with them: other callers, such as those allowed by "*",
+// have their cookies dropped and are not told credentials are allowed, and
+// calls in a session must carry its CSRF token like other requests changing
+// state.
+func registerGRPCWeb(engine *gin.Engine, origins []string) error {
+	server, err := newGRPCServer()
+	if err != nil {
+		return err
+	}
+
+	wrapped := grpcweb.WrapServer(server, grpcweb.WithOriginFunc(corsOriginFunc(origins)))
+
+	handler := func(c *gin.Context) {
+		if !wrapped.IsGrpcWebRequest(c.Request) && !wrapped.IsAcceptableGrpcCorsRequest(c.Request) {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Expected a gRPC-Web request"})
+			return
+		}
+
+		w := http.ResponseWriter(c.Writer)
+		if !credentialed(c.Request, origins) {
+			c.Request.Header.Del("Cookie")
+			w = &uncredentialed{ResponseWriter: c.Writer}
+		} else if c.Request.Method != http.MethodOptions && !auth.CheckSessionCSRF(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Missing or invalid CSRF token"})
+			return
+		}
+		wrapped.ServeHTTP(w, c.Request)
+	}
+
+	for name := range server.GetServiceInfo() {
+		route := fmt.Sprintf("/%s/:method", name)
+		engine.POST(route, handler)
+		engine.OPTIONS(route, handler)
+	}
+
+	return nil
+}
+
+// corsOriginFunc returns a function allowing the given cross-origin callers.
+// A single "*" entry allows any origin, though without credentials.
+func corsOriginFunc(origins []string) func(string) bool {
+	return func(origin string) bool {
+		return slices.Contains(origins, "*") || slices.Contains(origins, origin)
+	}
+}
+
+// credentialed reports whether a request may call with the cookies of the
+// browser: it comes from this server's own pages, or from an origin listed by
+// name
+func credentialed(r *http.Request, origins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(origins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// uncredentialed keeps a response from allowing the caller to send credentials
+type uncredentialed struct {
+	http.ResponseWriter
+}
+
+func (w *uncredentialed) WriteHeader(code int) {
+	w.Header().Del("Access-Control-Allow-Credentials")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *uncredentialed) Write(b []byte) (int, error) {
+	w.Header().Del("Access-Control-Allow-Credentials")
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *uncredentialed) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,146 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/sync"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func setupGRPCWeb(t *testing.T, origins []string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	grpcServer = grpc.NewServer(
+		grpc.UnaryInterceptor(sync.AuthInterceptor()),
+		grpc.StreamInterceptor(sync.StreamAuthInterceptor()),
+	)
+	sync.RegisterSyncServiceServer(grpcServer, sync.NewGRPCService(nil))
+	sync.RegisterLegacySyncServiceServer(grpcServer, sync.NewGRPCService(nil))
+	t.Cleanup(func() { grpcServer = nil })
+
+	engine := gin.New()
+	require.NoError(t, registerGRPCWeb(engine, origins))
+	return engine
+}
+
+func TestGRPCWebSharesAuthInterceptor(t *testing.T) {
+	engine := setupGRPCWeb(t, nil)
+
+	// An empty frame: uncompressed flag plus zero length
+	body := strings.NewReader("\x00\x00\x00\x00\x00")
+	req := httptest.NewRequest(http.MethodPost, "/filehub.sync.v1.SyncService/GetCurrentVersion", body)
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "16", w.Header().Get("Grpc-Status")) // codes.Unauthenticated
+}
+
+func TestGRPCWebLegacyServiceName(t *testing.T) {
+	engine := setupGRPCWeb(t, nil)
+
+	// Clients built before the package was versioned call the unversioned paths
+	body := strings.NewReader("\x00\x00\x00\x00\x00")
+	req := httptest.NewRequest(http.MethodPost, "/filehub.sync.SyncService/GetCurrentVersion", body)
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "16", w.Header().Get("Grpc-Status")) // codes.Unauthenticated, so the method was found
+}
+
+func TestGRPCWebRejectsPlainRequests(t *testing.T) {
+	engine := setupGRPCWeb(t, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/filehub.sync.v1.SyncService/GetCurrentVersion", nil)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestGRPCWebCORS(t *testing.T) {
+	engine := setupGRPCWeb(t, []string{"https://app.example.com"})
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/filehub.sync.v1.SyncService/ListDirectory", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "x-grpc-web, content-type, authorization")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	w := preflight("https://app.example.com")
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+
+	w = preflight("https://evil.example.com")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestGRPCWebWildcardWithoutCredentials(t *testing.T) {
+	engine := setupGRPCWeb(t, []string{"*", "https://app.example.com"})
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/filehub.sync.v1.SyncService/ListDirectory", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "x-grpc-web, content-type, authorization")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	w := preflight("https://anywhere.example.net")
+	assert.Equal(t, "https://anywhere.example.net", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+
+	w = preflight("https://app.example.com")
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestGRPCWebSessionNeedsCSRFToken(t *testing.T) {
+	engine := setupGRPCWeb(t, []string{"*"})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/login", nil)
+	sess, err := auth.CreateSession(c, &model.User{ID: 1, Username: "alice"})
+	require.NoError(t, err)
+	cookie := w.Result().Cookies()[0]
+
+	call := func(origin, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/filehub.sync.v1.SyncService/GetCurrentVersion", strings.NewReader("\x00\x00\x00\x00\x00"))
+		req.Header.Set("Content-Type", "application/grpc-web+proto")
+		req.Header.Set("Origin", origin)
+		req.AddCookie(cookie)
+		if token != "" {
+			req.Header.Set(auth.CSRFHeaderName, token)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	// The server's own pages must send the token along with the cookie
+	assert.Equal(t, http.StatusForbidden, call("http://example.com", "").Code)
+	assert.Equal(t, http.StatusOK, call("http://example.com", sess.CSRFToken).Code)
+
+	// Other origins allowed by "*" call without cookies, so they need no token and get no session
+	w = call("https://evil.example.net", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "16", w.Header().Get("Grpc-Status")) // codes.Unauthenticated
+}
@@ -1,16 +1,32 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/cgang/file-hub/pkg/bandwidth"
 	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/expiry"
 	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/netacl"
+	"github.com/cgang/file-hub/pkg/perm"
+	"github.com/cgang/file-hub/pkg/readme"
+	"github.com/cgang/file-hub/pkg/stor"
 	"github.com/cgang/file-hub/pkg/sync"
+	"github.com/cgang/file-hub/pkg/web/apierr"
 	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/cgang/file-hub/pkg/web/serve"
 	"github.com/gin-gonic/gin"
 	"github.com/uptrace/bun"
 )
@@ -18,6 +34,25 @@ import (
 const (
 	DefaultLimit = 100
 	MaxLimit     = 1000
+
+	// ChecksumHeader carries the expected SHA-256 of an upload, as a header or trailer
+	ChecksumHeader = "X-Checksum-Sha256"
+	// DeviceIDHeader names the device of a sync client, so that how far behind it is gets tracked
+	DeviceIDHeader = "X-Device-ID"
+
+	// NDJSONContentType is the media type of streamed listings
+	NDJSONContentType = "application/x-ndjson"
+	// NDJSONFlushEvery is how many streamed entries are buffered before flushing to the client
+	NDJSONFlushEvery = 500
+
+	// MaxJobWait is the longest a job status request waits for the job to finish
+	MaxJobWait = time.Minute
+
+	// Headers of an exported bundle: the version it applies to, the version it brings the repository to
+	// and whether more changes follow, to be exported since that version
+	BundleFromHeader    = "X-Bundle-From"
+	BundleVersionHeader = "X-Bundle-Version"
+	BundleMoreHeader    = "X-Bundle-More"
 )
 
 type SyncHandler struct {
@@ -30,9 +65,8 @@ func NewSyncHandler(database *bun.DB) *SyncHandler {
 	}
 }
 
-type ErrorResponse struct {
-	Error string `json:"error"`
-}
+// ErrorResponse is the body of error responses, see apierr for the codes
+type ErrorResponse = apierr.Response
 
 type FileInfoResponse struct {
 	Exists  bool              `json:"exists"`
@@ -46,6 +80,7 @@ type ListDirectoryResponse struct {
 	Offset  int                 `json:"offset"`
 	Limit   int                 `json:"limit"`
 	HasMore bool                `json:"has_more"`
+	Readme  *readme.Info        `json:"readme,omitempty"`
 	Message string              `json:"message,omitempty"`
 }
 
@@ -56,13 +91,16 @@ type VersionResponse struct {
 }
 
 type ChangesResponse struct {
-	Version string             `json:"version"`
-	Changes []*model.ChangeLog `json:"changes"`
-	Changed int                `json:"changed"`
-	Message string             `json:"message,omitempty"`
+	Version    string             `json:"version"`
+	Changes    []*model.ChangeLog `json:"changes"`
+	Changed    int                `json:"changed"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+	HasMore    bool               `json:"has_more"`
+	Message    string             `json:"message,omitempty"`
 }
 
 type UploadResponse struct {
+	Path    string `json:"path"` // where the file was stored, another than asked for after an autorename
 	Etag    string `json:"etag"`
 	Version string `json:"version"`
 	Size    int64  `json:"size"`
@@ -70,19 +108,54 @@ type UploadResponse struct {
 }
 
 type BeginUploadResponse struct {
-	UploadID       string `json:"upload_id"`
-	TotalChunks    int    `json:"total_chunks"`
-	ChunkSize      int64  `json:"chunk_size"`
-	UploadedChunks []int  `json:"uploaded_chunks"`
-	Message        string `json:"message,omitempty"`
+	UploadID       string    `json:"upload_id"`
+	TotalChunks    int       `json:"total_chunks"`
+	ChunkSize      int64     `json:"chunk_size"`
+	UploadedChunks []int     `json:"uploaded_chunks"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	IdleTimeout    int       `json:"idle_timeout,omitempty"` // seconds without a chunk or keepalive before the session expires
+	Message        string    `json:"message,omitempty"`
+}
+
+// KeepAliveResponse tells when an upload session expires after a keepalive
+type KeepAliveResponse struct {
+	UploadID    string    `json:"upload_id"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	IdleTimeout int       `json:"idle_timeout,omitempty"`
 }
 
 type FinalizeUploadResponse struct {
+	Path    string `json:"path"` // where the file was stored, another than asked for after an autorename
 	Etag    string `json:"etag"`
 	Size    int64  `json:"size"`
 	Message string `json:"message,omitempty"`
 }
 
+// MutationResponse is the reply of delete, move and copy
+type MutationResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	*sync.MutationResult
+}
+
+// JobResponse is the reply of a delete, move or copy continuing in the background
+type JobResponse struct {
+	Success   bool      `json:"success"`
+	Message   string    `json:"message,omitempty"`
+	Job       *sync.Job `json:"job"`
+	StatusURL string    `json:"status_url"`
+}
+
+// sendJob replies that an operation continues as a job
+func sendJob(c *gin.Context, job *sync.Job) {
+	c.JSON(http.StatusAccepted, JobResponse{
+		Success:   true,
+		Message:   "Continuing in the background",
+		Job:       job,
+		StatusURL: "/api/sync/jobs/" + job.ID,
+	})
+}
+
 type SyncStatusResponse struct {
 	Status  string            `json:"status"`
 	Info    *model.FileObject `json:"info,omitempty"`
@@ -125,6 +198,117 @@ func (h *SyncHandler) GetFileInfo(c *gin.Context) {
 	})
 }
 
+// StatRequest lists the paths to look up in one round trip
+type StatRequest struct {
+	Paths []string `json:"paths" binding:"required"`
+}
+
+type StatResponse struct {
+	Items []*sync.StatEntry `json:"items"`
+}
+
+// Stat reports existence, etag, size and modification time of many files at once
+func (h *SyncHandler) Stat(c *gin.Context) {
+	user, ok := auth.GetAuthenticatedUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+		return
+	}
+
+	repoName := c.Query("repo")
+	if repoName == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "repo parameter is required"})
+		return
+	}
+
+	var req StatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request format"})
+		return
+	}
+	if len(req.Paths) > sync.MaxStatPaths {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("At most %d paths can be looked up at once", sync.MaxStatPaths)})
+		return
+	}
+
+	repo, err := db.GetRepositoryByNameAndOwner(c.Request.Context(), repoName, user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Repository not found"})
+		return
+	}
+
+	entries, err := h.svc.Stat(c.Request.Context(), repo, req.Paths, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to look up files"})
+		return
+	}
+
+	c.JSON(http.StatusOK, StatResponse{Items: entries})
+}
+
+// ListBatchRequest lists the directories to list in one round trip, with the etags of those the client
+// has listed before
+type ListBatchRequest struct {
+	Paths     []string          `json:"paths" binding:"required"`
+	Etags     map[string]string `json:"etags,omitempty"`
+	Sort      string            `json:"sort,omitempty"`       // name, natural, mod_time or size; the server default if empty
+	DirsFirst *bool             `json:"dirs_first,omitempty"` // list directories before files; the server default if absent
+}
+
+type ListBatchResponse struct {
+	Directories []*sync.DirListing `json:"directories"`
+}
+
+// ListBatch lists many directories at once, for clients filling their cache of a whole tree
+func (h *SyncHandler) ListBatch(c *gin.Context) {
+	user, ok := auth.GetAuthenticatedUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+		return
+	}
+
+	repoName := c.Query("repo")
+	if repoName == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "repo parameter is required"})
+		return
+	}
+
+	var req ListBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request format"})
+		return
+	}
+	if len(req.Paths) > sync.MaxListBatchPaths {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("At most %d directories can be listed at once", sync.MaxListBatchPaths)})
+		return
+	}
+	order, err := sync.ParseListOrder(req.Sort, req.DirsFirst)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid sort order"})
+		return
+	}
+
+	repo, err := db.GetRepositoryByNameAndOwner(c.Request.Context(), repoName, user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Repository not found"})
+		return
+	}
+
+	listings, err := h.svc.ListBatch(c.Request.Context(), repo, req.Paths, req.Etags, order, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to list directories"})
+		return
+	}
+
+	for _, listing := range listings {
+		if err := expiry.Annotate(c.Request.Context(), repo, listing.Items); err != nil {
+			log.Printf("Failed to annotate expiry of %s: %s", listing.Path, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, ListBatchResponse{Directories: listings})
+}
+
 func (h *SyncHandler) ListDirectory(c *gin.Context) {
 	user, ok := auth.GetAuthenticatedUser(c)
 	if !ok {
@@ -152,27 +336,204 @@ func (h *SyncHandler) ListDirectory(c *gin.Context) {
 		limit = DefaultLimit
 	}
 
+	order, ok := listOrder(c)
+	if !ok {
+		return
+	}
+
 	repo, err := db.GetRepositoryByNameAndOwner(c.Request.Context(), repoName, user.ID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Repository not found"})
 		return
 	}
 
-	items, total, err := h.svc.ListDirectory(c.Request.Context(), repo, path, offset, limit, user.ID)
+	if acceptsNDJSON(c) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+		if err != nil || limit < 0 {
+			limit = 0
+		}
+		h.streamDirectory(c, repo, path, order, offset, limit, user.ID)
+		return
+	}
+
+	items, total, err := h.svc.ListDirectory(c.Request.Context(), repo, path, order, offset, limit, user.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to list directory"})
 		return
 	}
 
+	if err := expiry.Annotate(c.Request.Context(), repo, items); err != nil {
+		log.Printf("Failed to annotate expiry of %s: %s", path, err)
+	}
+
 	hasMore := int64(offset+limit) < total
 
+	var info *readme.Info
+	if c.Query("readme") == "true" {
+		if info, err = readme.Describe(c.Request.Context(), repo, path); err != nil {
+			log.Printf("Failed to find readme of %s: %s", path, err)
+		}
+	}
+
 	c.JSON(http.StatusOK, ListDirectoryResponse{
 		Items:   items,
 		Total:   total,
 		Offset:  offset,
 		Limit:   limit,
 		HasMore: hasMore,
+		Readme:  info,
+	})
+}
+
+// expectedChecksum returns the SHA-256 the client expects for an upload, from the checksum header,
+// a trailer of the same name sent after a chunked body, or the "sha256" query parameter.
+// It must be called after the body has been read, since trailers arrive last.
+func expectedChecksum(c *gin.Context) (string, bool) {
+	expected := c.GetHeader(ChecksumHeader)
+	if expected == "" && c.Request.Trailer != nil {
+		expected = c.Request.Trailer.Get(ChecksumHeader)
+	}
+	if expected == "" {
+		expected = c.Query("sha256")
+	}
+
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	if expected == "" {
+		return "", true
+	}
+
+	if b, err := hex.DecodeString(expected); err != nil || len(b) != sha256.Size {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid " + ChecksumHeader + ", expected a hex encoded SHA-256"})
+		return "", false
+	}
+	return expected, true
+}
+
+// clientModTime returns the modification time the client gave for an upload, from the sync.ModTimeHeader header
+// or the "mtime" query parameter, in Unix seconds. The zero time means none was given.
+func clientModTime(c *gin.Context) (time.Time, bool) {
+	value := c.GetHeader(sync.ModTimeHeader)
+	if value == "" {
+		value = c.Query("mtime")
+	}
+
+	modTime, err := sync.ParseModTime(value, time.Now())
+	if err != nil {
+		apierr.Send(c, err)
+		return time.Time{}, false
+	}
+	if !modTime.IsZero() {
+		// ownCloud clients look for this to know the time was kept
+		c.Header("X-OC-MTime", "accepted")
+	}
+	return modTime, true
+}
+
+// sendUploadError replies with the status and code of an upload failure clients can act on:
+// 422 with both hashes in the details for a checksum mismatch, 409 when the parent directory is missing
+// or chunks need to be uploaded again, 400 for a modification time out of range, 423 over an immutable file,
+// 403 while the server is read-only or for a reserved path, and 503 when a finished upload failed to be stored and
+// can be finalized again
+func sendUploadError(c *gin.Context, err error) bool {
+	var mismatch *sync.ChecksumMismatchError
+	var failed *sync.FinalizeFailedError
+	if !errors.As(err, &mismatch) && !errors.Is(err, stor.ErrParentNotFound) && !errors.Is(err, sync.ErrInvalidModTime) &&
+		!errors.Is(err, sync.ErrUploadIncomplete) && !errors.As(err, &failed) && !errors.Is(err, stor.ErrImmutable) &&
+		!errors.Is(err, perm.ErrReadOnly) && !errors.Is(err, stor.ErrTooLarge) && !errors.Is(err, stor.ErrReserved) {
+		return false
+	}
+
+	apierr.Send(c, err)
+	return true
+}
+
+// listOrder returns the order of a listing from the "sort" and "dirs_first" query parameters,
+// replying with 400 if either is invalid
+func listOrder(c *gin.Context) (model.ListOrder, bool) {
+	var dirsFirst *bool
+	if value, ok := c.GetQuery("dirs_first"); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid dirs_first parameter"})
+			return model.ListOrder{}, false
+		}
+		dirsFirst = &b
+	}
+
+	order, err := sync.ParseListOrder(c.Query("sort"), dirsFirst)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid sort order"})
+		return model.ListOrder{}, false
+	}
+	return order, true
+}
+
+// sendTreeError replies with 413 when a directory tree goes past the configured depth or size,
+// with a 500 naming the cycle when its parent links loop, with 423 when it holds an immutable file,
+// and with 403 while the server is read-only or for a reserved path
+func sendTreeError(c *gin.Context, err error) bool {
+	if !errors.Is(err, stor.ErrTreeTooDeep) && !errors.Is(err, stor.ErrTreeTooLarge) && !errors.Is(err, stor.ErrTreeCycle) &&
+		!errors.Is(err, stor.ErrImmutable) && !errors.Is(err, perm.ErrReadOnly) && !errors.Is(err, stor.ErrReserved) {
+		return false
+	}
+
+	apierr.Send(c, err)
+	return true
+}
+
+// acceptsNDJSON reports whether the client asked for a newline delimited JSON stream
+func acceptsNDJSON(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), NDJSONContentType)
+}
+
+// streamDirectory writes directory entries as NDJSON, one object per line, straight from a DB cursor.
+// Errors after the first entry was sent are reported as a final {"error": ...} line.
+func (h *SyncHandler) streamDirectory(c *gin.Context, repo *model.Repository, path string, order model.ListOrder, offset, limit int, userID int) {
+	ctx := c.Request.Context()
+
+	rules, err := db.ListExpiryRules(ctx, repo.ID)
+	if err != nil {
+		log.Printf("Failed to load expiry rules of %s: %s", repo.Name, err)
+	}
+
+	enc := json.NewEncoder(c.Writer)
+	started := false
+	start := func() {
+		started = true
+		c.Header("Content-Type", NDJSONContentType)
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Status(http.StatusOK)
+	}
+
+	sent := 0
+	err = h.svc.ListDirectoryStream(ctx, repo, path, order, offset, limit, userID, func(file *model.FileObject) error {
+		if !started {
+			start()
+		}
+
+		file.ExpiresAt = model.EarliestExpiry(rules, file)
+		if err := enc.Encode(file); err != nil {
+			return err
+		}
+
+		if sent++; sent%NDJSONFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+		return nil
 	})
+
+	if err != nil && !started {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to list directory"})
+		return
+	}
+
+	if !started {
+		start() // empty directory
+	} else if err != nil {
+		log.Printf("Failed to stream directory %s: %s", path, err)
+		_ = enc.Encode(ErrorResponse{Error: "Failed to list directory"})
+	}
+	c.Writer.Flush()
 }
 
 func (h *SyncHandler) CreateDirectory(c *gin.Context) {
@@ -196,12 +557,19 @@ func (h *SyncHandler) CreateDirectory(c *gin.Context) {
 		return
 	}
 
-	if err := h.svc.CreateDirectory(c.Request.Context(), repo, path, user.ID); err != nil {
+	parents := c.Query("parents") == "true"
+	dir, err := h.svc.CreateDirectory(c.Request.Context(), repo, path, parents, user.ID)
+	if sendTreeError(c, err) {
+		return
+	} else if errors.Is(err, stor.ErrParentNotFound) {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Parent directory does not exist"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to create directory: %s", err)})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Directory created successfully"})
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Directory created successfully", "directory": dir})
 }
 
 func (h *SyncHandler) Delete(c *gin.Context) {
@@ -228,12 +596,21 @@ func (h *SyncHandler) Delete(c *gin.Context) {
 		return
 	}
 
-	if err := h.svc.Delete(c.Request.Context(), repo, path, recursive, user.ID); err != nil {
+	result, job, err := h.svc.DeleteOrStartJob(c.Request.Context(), repo, path, recursive, user.ID)
+	if sendTreeError(c, err) {
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to delete: %s", err)})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Deleted successfully"})
+	if job != nil {
+		sendJob(c, job)
+		return
+	}
+
+	c.JSON(http.StatusOK, MutationResponse{Success: true, Message: "Deleted successfully", MutationResult: result})
 }
 
 func (h *SyncHandler) Move(c *gin.Context) {
@@ -258,12 +635,53 @@ func (h *SyncHandler) Move(c *gin.Context) {
 		return
 	}
 
-	if err := h.svc.Move(c.Request.Context(), repo, sourcePath, destPath, user.ID); err != nil {
+	result, job, err := h.svc.MoveOrStartJob(c.Request.Context(), repo, sourcePath, destPath, user.ID)
+	if sendTreeError(c, err) {
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to move: %s", err)})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Moved successfully"})
+	if job != nil {
+		sendJob(c, job)
+		return
+	}
+
+	c.JSON(http.StatusOK, MutationResponse{Success: true, Message: "Moved successfully", MutationResult: result})
+}
+
+// Rename gives a file a new name in its directory, failing with a conflict if the name is taken
+func (h *SyncHandler) Rename(c *gin.Context) {
+	user, ok := auth.GetAuthenticatedUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+		return
+	}
+
+	repoName := c.Query("repo")
+	path := c.Query("path")
+	name := c.Query("name")
+
+	if repoName == "" || path == "" || name == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "repo, path, and name parameters are required"})
+		return
+	}
+
+	repo, err := db.GetRepositoryByNameAndOwner(c.Request.Context(), repoName, user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Repository not found"})
+		return
+	}
+
+	result, err := h.svc.Rename(c.Request.Context(), repo, path, name, user.ID)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, MutationResponse{Success: true, Message: "Renamed successfully", MutationResult: result})
 }
 
 func (h *SyncHandler) Copy(c *gin.Context) {
@@ -288,12 +706,25 @@ func (h *SyncHandler) Copy(c *gin.Context) {
 		return
 	}
 
-	if err := h.svc.Copy(c.Request.Context(), repo, sourcePath, destPath, user.ID); err != nil {
+	result, job, err := h.svc.CopyOrStartJob(c.Request.Context(), repo, sourcePath, destPath, c.Query("autorename") == "true", user.ID)
+	if sendTreeError(c, err) {
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to copy: %s", err)})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Copied successfully"})
+	if job != nil {
+		sendJob(c, job)
+		return
+	}
+
+	if len(result.Failed) > 0 {
+		c.JSON(http.StatusOK, MutationResponse{Success: false, Message: "Copied partially, some items failed", MutationResult: result})
+		return
+	}
+	c.JSON(http.StatusOK, MutationResponse{Success: true, Message: "Copied successfully", MutationResult: result})
 }
 
 func (h *SyncHandler) UploadFile(c *gin.Context) {
@@ -317,19 +748,26 @@ func (h *SyncHandler) UploadFile(c *gin.Context) {
 		return
 	}
 
-	data, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Failed to read file data"})
+	expected, ok := expectedChecksum(c)
+	if !ok {
+		return
+	}
+
+	modTime, ok := clientModTime(c)
+	if !ok {
 		return
 	}
 
-	etag, version, size, err := h.svc.UploadFile(c.Request.Context(), repo, path, data, c.GetHeader("Content-Type"), user.ID)
+	path, etag, version, size, err := h.svc.UploadFile(c.Request.Context(), repo, path, c.Request.Body, c.GetHeader("Content-Type"), expected, modTime, c.Query("autorename") == "true", user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to upload file: %s", err)})
+		if !sendUploadError(c, err) {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to upload file: %s", err)})
+		}
 		return
 	}
 
 	c.JSON(http.StatusOK, UploadResponse{
+		Path:    path,
 		Etag:    etag,
 		Version: version,
 		Size:    size,
@@ -358,6 +796,16 @@ func (h *SyncHandler) DownloadFile(c *gin.Context) {
 		return
 	}
 
+	if !auth.CheckDownloadGrant(c, repo, path) {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Download token is not valid for this file"})
+		return
+	}
+
+	// Clients asking for it are sent to the storage backend, when it serves the file itself
+	if c.Query("presign") == "true" && h.redirectDownload(c, repo, path, ifNoneMatch, user.ID) {
+		return
+	}
+
 	file, reader, err := h.svc.DownloadFile(c.Request.Context(), repo, path, ifNoneMatch, user.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to download file"})
@@ -370,14 +818,168 @@ func (h *SyncHandler) DownloadFile(c *gin.Context) {
 	}
 	defer reader.Close()
 
-	c.Header("Content-Type", file.ContentType())
-	c.Header("Content-Length", strconv.FormatInt(file.Size, 10))
 	if file.Checksum != nil {
 		c.Header("ETag", *file.Checksum)
 	}
-	c.Header("Last-Modified", file.ModTime.Format(http.TimeFormat))
+	c.Header(sync.ModTimeHeader, strconv.FormatInt(file.ModTime.Unix(), 10))
+
+	serve.File(c, file, reader)
+}
+
+// redirectDownload replies with a redirect to a presigned request for a file, or 304 if it matches ifNoneMatch.
+// It returns false, replying nothing, if the file must be served by the server.
+func (h *SyncHandler) redirectDownload(c *gin.Context, repo *model.Repository, path, ifNoneMatch string, userID int) bool {
+	file, req, err := h.svc.PresignDownload(c.Request.Context(), repo, path, ifNoneMatch, userID)
+	if errors.Is(err, stor.ErrPresignUnsupported) {
+		return false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to download file"})
+		return true
+	}
+
+	if req == nil {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	if file.Checksum != nil {
+		c.Header("ETag", *file.Checksum)
+	}
+	c.Header("Cache-Control", "no-store") // the URL expires
+	c.Redirect(http.StatusTemporaryRedirect, req.URL)
+	return true
+}
+
+// PresignUpload returns a request the client sends to the storage backend to upload a file, sparing the
+// server the content. The file is recorded once the client calls CommitPresignedUpload.
+func (h *SyncHandler) PresignUpload(c *gin.Context) {
+	user, ok := auth.GetAuthenticatedUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+		return
+	}
+
+	repoName := c.Query("repo")
+	path := c.Query("path")
+
+	if repoName == "" || path == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "repo and path parameters are required"})
+		return
+	}
+
+	repo, err := db.GetRepositoryByNameAndOwner(c.Request.Context(), repoName, user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Repository not found"})
+		return
+	}
+
+	req, err := h.svc.PresignUpload(c.Request.Context(), repo, path, user.ID)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// CommitPresignedUpload records a file the client uploaded with a request from PresignUpload
+func (h *SyncHandler) CommitPresignedUpload(c *gin.Context) {
+	user, ok := auth.GetAuthenticatedUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+		return
+	}
+
+	repoName := c.Query("repo")
+	path := c.Query("path")
+
+	if repoName == "" || path == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "repo and path parameters are required"})
+		return
+	}
+
+	repo, err := db.GetRepositoryByNameAndOwner(c.Request.Context(), repoName, user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Repository not found"})
+		return
+	}
+
+	modTime, ok := clientModTime(c)
+	if !ok {
+		return
+	}
+
+	file, version, err := h.svc.CommitPresignedUpload(c.Request.Context(), repo, path, modTime, user.ID)
+	if err != nil {
+		if !sendUploadError(c, err) {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to commit upload: %s", err)})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, UploadResponse{
+		Path:    file.Path,
+		Version: version,
+		Size:    file.Size,
+	})
+}
+
+// PreflightRequest describes an upload a client is about to make
+type PreflightRequest struct {
+	Path     string `json:"path" binding:"required"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum,omitempty"` // SHA-256 of the content, to find it on the server
+}
+
+// PreflightResponse tells whether an upload can succeed and how to send it, with every reason it would be
+// refused when it cannot
+type PreflightResponse struct {
+	*sync.PreflightResult
+	Problems []apierr.Response `json:"problems,omitempty"`
+}
+
+// PreflightUpload checks an upload before its content is sent, so that clients neither send a large file
+// only to have it refused nor send content the server already holds
+func (h *SyncHandler) PreflightUpload(c *gin.Context) {
+	user, ok := auth.GetAuthenticatedUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+		return
+	}
+
+	repoName := c.Query("repo")
+	if repoName == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "repo parameter is required"})
+		return
+	}
+
+	var req PreflightRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Size < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request format"})
+		return
+	}
+
+	repo, err := db.GetRepositoryByNameAndOwner(c.Request.Context(), repoName, user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Repository not found"})
+		return
+	}
+
+	result, err := h.svc.Preflight(c.Request.Context(), repo, req.Path, req.Size, strings.ToLower(req.Checksum), user.ID)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	resp := PreflightResponse{PreflightResult: result}
+	for _, problem := range result.Problems {
+		e := apierr.Map(problem)
+		resp.Problems = append(resp.Problems, apierr.Response{Error: e.Message, Code: e.Code, Details: e.Details})
+	}
+	c.JSON(http.StatusOK, resp)
+}
 
-	c.DataFromReader(http.StatusOK, file.Size, file.ContentType(), reader, nil)
+// GetCapabilities tells clients what the sync API supports, such as the segment size of parallel downloads
+func (h *SyncHandler) GetCapabilities(c *gin.Context) {
+	c.JSON(http.StatusOK, sync.GetCapabilities())
 }
 
 func (h *SyncHandler) GetCurrentVersion(c *gin.Context) {
@@ -439,18 +1041,48 @@ func (h *SyncHandler) ListChanges(c *gin.Context) {
 		return
 	}
 
-	changes, err := h.svc.ListChanges(c.Request.Context(), repo.ID, sinceVersion, maxChanges)
-	if err != nil {
+	filter := model.ChangeFilter{PathPrefix: c.Query("path_prefix")}
+	for _, ops := range c.QueryArray("operations") {
+		for op := range strings.SplitSeq(ops, ",") {
+			if op = strings.TrimSpace(op); op != "" {
+				filter.Operations = append(filter.Operations, op)
+			}
+		}
+	}
+
+	page, err := h.svc.ListChanges(c.Request.Context(), repo.ID, sinceVersion, c.Query("cursor"), filter, maxChanges)
+	if errors.Is(err, sync.ErrInvalidCursor) || errors.Is(err, sync.ErrInvalidFilter) {
+		apierr.Send(c, err)
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get changes"})
 		return
 	}
 
-	currentVersion, _ := h.svc.GetCurrentVersion(c.Request.Context(), repo.ID)
+	if sync.Expands(c.QueryArray("expand"), sync.ExpandUser) {
+		if err := h.svc.FillAuthors(c.Request.Context(), page.Changes); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get change authors"})
+			return
+		}
+	}
+
+	// Listing changes since a version acknowledges it; following pages do not move it
+	if c.Query("cursor") == "" {
+		sync.TrackClient(c.Request.Context(), repo, user.ID, c.GetHeader(DeviceIDHeader), sinceVersion)
+	}
+
+	currentVersion, err := h.svc.GetCurrentVersion(c.Request.Context(), repo.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get version"})
+		return
+	}
 
 	c.JSON(http.StatusOK, ChangesResponse{
-		Version: currentVersion.CurrentVersion,
-		Changes: changes,
-		Changed: len(changes),
+		Version:    currentVersion.CurrentVersion,
+		Changes:    page.Changes,
+		Changed:    len(page.Changes),
+		NextCursor: page.NextCursor,
+		HasMore:    page.HasMore,
 	})
 }
 
@@ -513,23 +1145,40 @@ func (h *SyncHandler) BeginUpload(c *gin.Context) {
 		return
 	}
 
+	var chunkSize int64
+	if s := c.Query("chunk_size"); s != "" {
+		if chunkSize, err = strconv.ParseInt(s, 10, 64); err != nil || chunkSize <= 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid chunk_size parameter"})
+			return
+		}
+	}
+
 	repo, err := db.GetRepositoryByNameAndOwner(c.Request.Context(), repoName, user.ID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Repository not found"})
 		return
 	}
 
-	uploadID, uploadedChunks, err := h.svc.BeginUpload(c.Request.Context(), repo, path, totalSize, user.ID)
+	modTime, ok := clientModTime(c)
+	if !ok {
+		return
+	}
+
+	session, uploadedChunks, err := h.svc.BeginUpload(c.Request.Context(), repo, path, totalSize, chunkSize, modTime, user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to begin upload: %s", err)})
+		if !sendUploadError(c, err) {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to begin upload: %s", err)})
+		}
 		return
 	}
 
 	c.JSON(http.StatusOK, BeginUploadResponse{
-		UploadID:       uploadID,
-		TotalChunks:    (int)(totalSize+1024*1024-1) / (1024 * 1024),
-		ChunkSize:      1024 * 1024,
+		UploadID:       session.UploadID,
+		TotalChunks:    session.TotalChunks,
+		ChunkSize:      session.ChunkSize,
 		UploadedChunks: uploadedChunks,
+		ExpiresAt:      session.Deadline(sync.IdleTimeout()),
+		IdleTimeout:    int(sync.IdleTimeout().Seconds()),
 	})
 }
 
@@ -554,13 +1203,17 @@ func (h *SyncHandler) UploadChunk(c *gin.Context) {
 		return
 	}
 
-	data, err := io.ReadAll(c.Request.Body)
+	// Read one byte more than any chunk may have, so that oversized chunks are rejected without buffering them
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, sync.MaxChunkSize()+1))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Failed to read chunk data"})
 		return
 	}
 
-	if err := h.svc.UploadChunk(c.Request.Context(), uploadID, chunkIndex, data); err != nil {
+	if err := h.svc.UploadChunk(c.Request.Context(), uploadID, chunkIndex, data); errors.Is(err, sync.ErrUploadExpired) || errors.Is(err, sync.ErrInvalidChunk) {
+		apierr.Send(c, err)
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to upload chunk: %s", err)})
 		return
 	}
@@ -589,18 +1242,64 @@ func (h *SyncHandler) FinalizeUpload(c *gin.Context) {
 		return
 	}
 
-	etag, size, err := h.svc.FinalizeUpload(c.Request.Context(), uploadID, repo, user.ID)
+	expected, ok := expectedChecksum(c)
+	if !ok {
+		return
+	}
+
+	modTime, ok := clientModTime(c)
+	if !ok {
+		return
+	}
+
+	path, etag, size, err := h.svc.FinalizeUpload(c.Request.Context(), uploadID, repo, expected, modTime, c.Query("autorename") == "true", user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to finalize upload: %s", err)})
+		if !sendUploadError(c, err) {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to finalize upload: %s", err)})
+		}
 		return
 	}
 
 	c.JSON(http.StatusOK, FinalizeUploadResponse{
+		Path: path,
 		Etag: etag,
 		Size: size,
 	})
 }
 
+// KeepAlive extends an upload session, so that a slow upload doesn't expire between chunks
+func (h *SyncHandler) KeepAlive(c *gin.Context) {
+	user, ok := auth.GetAuthenticatedUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+		return
+	}
+
+	uploadID := c.Query("upload_id")
+	if uploadID == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "upload_id parameter is required"})
+		return
+	}
+
+	expiresAt, err := h.svc.KeepAlive(c.Request.Context(), uploadID, user.ID)
+	if errors.Is(err, sync.ErrUploadExpired) {
+		apierr.Send(c, err)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Upload session not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to extend upload: %s", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, KeepAliveResponse{
+		UploadID:    uploadID,
+		ExpiresAt:   expiresAt,
+		IdleTimeout: int(sync.IdleTimeout().Seconds()),
+	})
+}
+
 func (h *SyncHandler) CancelUpload(c *gin.Context) {
 	_, ok := auth.GetAuthenticatedUser(c)
 	if !ok {
@@ -622,25 +1321,149 @@ func (h *SyncHandler) CancelUpload(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Upload cancelled successfully"})
 }
 
+// GetJob returns the state of a background job. With wait, such as wait=30s, it answers
+// as soon as the job finishes or after that long.
+func (h *SyncHandler) GetJob(c *gin.Context) {
+	user, ok := auth.GetAuthenticatedUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+		return
+	}
+
+	var wait time.Duration
+	if value := c.Query("wait"); value != "" {
+		var err error
+		if wait, err = time.ParseDuration(value); err != nil || wait < 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid wait duration"})
+			return
+		}
+		wait = min(wait, MaxJobWait)
+	}
+
+	job, ok := sync.GetJob(c.Request.Context(), c.Param("id"), user.ID, wait)
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// ExportBundle writes the changes of a repository since a version as a bundle, for importing them into
+// another instance without a network between the two. The bundle's versions are also sent as headers.
+func (h *SyncHandler) ExportBundle(c *gin.Context) {
+	user, ok := auth.GetAuthenticatedUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+		return
+	}
+
+	repoName := c.Query("repo")
+	if repoName == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "repo parameter is required"})
+		return
+	}
+
+	repo, err := db.GetRepositoryByNameAndOwner(c.Request.Context(), repoName, user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Repository not found"})
+		return
+	}
+
+	bundle, err := h.svc.PrepareBundle(c.Request.Context(), repo, c.Query("since"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to prepare bundle"})
+		return
+	}
+
+	manifest := bundle.Manifest
+	filename := fmt.Sprintf("%s-%s.tar", repo.Name, manifest.ToVersion)
+	c.Header("Content-Type", sync.BundleContentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Header(BundleFromHeader, manifest.FromVersion)
+	c.Header(BundleVersionHeader, manifest.ToVersion)
+	c.Header(BundleMoreHeader, strconv.FormatBool(manifest.More))
+	c.Status(http.StatusOK)
+
+	// The status is sent already, a failure can only cut the bundle short, which its import rejects
+	if err := bundle.Write(c.Request.Context(), c.Writer); err != nil {
+		log.Printf("Failed to write bundle of %s: %s", repo.Name, err)
+	}
+}
+
+// ImportBundle applies a bundle exported by another instance to a repository at the version it was exported since
+func (h *SyncHandler) ImportBundle(c *gin.Context) {
+	user, ok := auth.GetAuthenticatedUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+		return
+	}
+
+	repoName := c.Query("repo")
+	if repoName == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "repo parameter is required"})
+		return
+	}
+
+	repo, err := db.GetRepositoryByNameAndOwner(c.Request.Context(), repoName, user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Repository not found"})
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, sync.MaxBundleSize)
+	result, err := h.svc.ImportBundle(c.Request.Context(), repo, body, user.ID)
+	var mismatch *sync.ChecksumMismatchError
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		apierr.Send(c, fmt.Errorf("%w: bundle over %d bytes", stor.ErrTooLarge, tooLarge.Limit))
+		return
+	} else if errors.Is(err, sync.ErrInvalidBundle) || errors.Is(err, sync.ErrBundleVersion) || errors.As(err, &mismatch) ||
+		errors.Is(err, perm.ErrReadOnly) || errors.Is(err, stor.ErrTooLarge) || errors.Is(err, sync.ErrQuotaExceeded) {
+		apierr.Send(c, err)
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to import bundle: %s", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
 func RegisterSyncRoutes(router *gin.Engine, database *bun.DB) {
 	handler := NewSyncHandler(database)
 
 	api := router.Group("/api/sync")
 	{
+		// Downloads also accept download tokens, so they are registered before authentication is required
+		api.GET("/download", auth.AuthenticateDownload, netacl.RepoFilter, bandwidth.Limit, handler.DownloadFile)
+		api.HEAD("/download", auth.AuthenticateDownload, netacl.RepoFilter, bandwidth.Limit, handler.DownloadFile)
+
+		api.Use(auth.Authenticate, netacl.RepoFilter, bandwidth.Limit)
 		api.GET("/info", handler.GetFileInfo)
+		api.POST("/stat", handler.Stat)
 		api.GET("/list", handler.ListDirectory)
+		api.POST("/list-batch", handler.ListBatch)
 		api.POST("/mkdir", handler.CreateDirectory)
 		api.DELETE("/delete", handler.Delete)
 		api.POST("/move", handler.Move)
+		api.POST("/rename", handler.Rename)
 		api.POST("/copy", handler.Copy)
 		api.POST("/upload", handler.UploadFile)
-		api.GET("/download", handler.DownloadFile)
+		api.GET("/capabilities", handler.GetCapabilities)
 		api.GET("/version", handler.GetCurrentVersion)
 		api.GET("/changes", handler.ListChanges)
 		api.GET("/status", handler.GetSyncStatus)
 		api.POST("/upload/begin", handler.BeginUpload)
 		api.POST("/upload/chunk", handler.UploadChunk)
 		api.POST("/upload/finalize", handler.FinalizeUpload)
+		api.POST("/upload/keepalive", handler.KeepAlive)
+		api.POST("/upload/preflight", handler.PreflightUpload)
+		api.POST("/upload/presign", handler.PresignUpload)
+		api.POST("/upload/presign/commit", handler.CommitPresignedUpload)
 		api.DELETE("/upload/cancel", handler.CancelUpload)
+		api.GET("/jobs/:id", handler.GetJob)
+		api.GET("/bundle", handler.ExportBundle)
+		api.POST("/bundle", handler.ImportBundle)
 	}
 }
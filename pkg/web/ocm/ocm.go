@@ -0,0 +1,159 @@
+// Package ocm serves the endpoints other file-hub servers use to exchange federated shares with this one.
+package ocm
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/federation"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/netacl"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/serve"
+	"github.com/gin-gonic/gin"
+)
+
+// Register configures the server-to-server federation routes
+func Register(engine *gin.Engine) {
+	engine.GET("/.well-known/ocm", Discover)
+
+	r := engine.Group(federation.EndPoint)
+	r.POST("/shares", ReceiveShare)
+	r.GET("/shares/:id", DescribeShare)
+	r.POST("/notifications", ReceiveNotification)
+	r.GET("/files/*path", GetFile)
+	r.HEAD("/files/*path", GetFile)
+}
+
+// Entry is an item of a shared folder listed to another server
+type Entry struct {
+	Name     string    `json:"name"`
+	IsDir    bool      `json:"is_dir"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	ETag     string    `json:"etag,omitempty"`
+	MimeType string    `json:"mime_type,omitempty"`
+}
+
+// ListingResponse lists a directory of a shared folder
+type ListingResponse struct {
+	Path  string  `json:"path"`
+	Items []Entry `json:"items"`
+}
+
+// Discover describes the federation endpoints of this server
+func Discover(c *gin.Context) {
+	c.JSON(http.StatusOK, federation.Discover())
+}
+
+// ReceiveShare stores a share offered to a local user by another server
+func ReceiveShare(c *gin.Context) {
+	var offer federation.Offer
+	if err := c.ShouldBindJSON(&offer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	share, err := federation.Receive(c, &offer)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"recipientDisplayName": share.Name})
+}
+
+// DescribeShare answers the server a share was offered to confirming the offer, with the share's secret
+// as bearer token
+func DescribeShare(c *gin.Context) {
+	secret, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	offer, err := federation.Describe(c, c.Param("id"), secret)
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, offer)
+}
+
+// ReceiveNotification handles another server telling what happened to a share exchanged with it
+func ReceiveNotification(c *gin.Context) {
+	var notification federation.Notification
+	if err := c.ShouldBindJSON(&notification); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := federation.Notify(c, &notification); err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.Status(http.StatusCreated)
+}
+
+// GetFile answers another server reading a folder shared with one of its users: directories with
+// a listing, files with their content. The share's secret comes as bearer token.
+func GetFile(c *gin.Context) {
+	secret, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	share, res, err := federation.Resolve(c, secret, c.Param("path"))
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	if err := netacl.CheckRepo(c, res.Repo, c.ClientIP(), ""); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access from " + c.ClientIP() + " is not allowed"})
+		return
+	}
+
+	file, err := stor.GetFileInfo(c, res)
+	if stor.IsNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file"})
+		return
+	}
+
+	if file.IsDir {
+		listDir(c, share, res.Repo, file)
+		return
+	}
+
+	reader, err := stor.OpenFile(c, res)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		return
+	}
+	defer reader.Close()
+
+	if file.Checksum != nil {
+		c.Header("ETag", *file.Checksum)
+	}
+	serve.File(c, file, reader)
+}
+
+func listDir(c *gin.Context, share *model.FederatedShare, repo *model.Repository, dir *model.FileObject) {
+	children, err := stor.ListDir(c, repo, dir)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list directory"})
+		return
+	}
+
+	items := make([]Entry, 0, len(children))
+	for _, child := range children {
+		entry := Entry{Name: child.Name, IsDir: child.IsDir, Size: child.Size, ModTime: child.ModTime}
+		if child.Checksum != nil {
+			entry.ETag = *child.Checksum
+		}
+		if child.MimeType != nil && !child.IsDir {
+			entry.MimeType = *child.MimeType
+		}
+		items = append(items, entry)
+	}
+
+	c.JSON(http.StatusOK, ListingResponse{Path: "/" + strings.TrimPrefix(strings.TrimPrefix(dir.Path, share.Path), "/"), Items: items})
+}
@@ -0,0 +1,61 @@
+package web
+
+import (
+	"context"
+	"log"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/web/auth"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// OperationHeader is the response header carrying the operation ID of a request, which
+// GET /api/admin/operations/{id} resolves to the changes and audit log entries it recorded
+const OperationHeader = "X-Operation-ID"
+
+// stampOperation gives each request an operation ID, stamped on the change log and audit log entries it
+// records, and logs the requests that recorded any
+func stampOperation(c *gin.Context) {
+	ctx, op, _ := db.StartOperation(c.Request.Context())
+	c.Request = c.Request.WithContext(ctx)
+	c.Header(OperationHeader, op.ID)
+	c.Next()
+
+	if changes, audited := op.Changes.Load(), op.Audited.Load(); changes > 0 || audited > 0 {
+		by := "anonymous"
+		if user, ok := auth.GetAuthenticatedUser(c); ok {
+			by = user.Username
+		}
+		log.Printf("Operation %s: %s %s by %s returned %d, %d changes and %d audit entries recorded",
+			op.ID, c.Request.Method, c.Request.URL.Path, by, c.Writer.Status(), changes, audited)
+	}
+}
+
+// stampOperationUnary gives each gRPC call an operation ID, sent back in the x-operation-id header,
+// unless it has the one of the gRPC-Web request carrying it
+func stampOperationUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, op, started := db.StartOperation(ctx)
+	if started {
+		_ = grpc.SetHeader(ctx, metadata.Pairs(OperationHeader, op.ID))
+		defer logOperation(op, info.FullMethod)
+	}
+	return handler(ctx, req)
+}
+
+// stampOperationStream is stampOperationUnary for streams
+func stampOperationStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, op, started := db.StartOperation(ss.Context())
+	if started {
+		_ = ss.SetHeader(metadata.Pairs(OperationHeader, op.ID))
+		defer logOperation(op, info.FullMethod)
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+func logOperation(op *db.Operation, method string) {
+	if changes, audited := op.Changes.Load(), op.Audited.Load(); changes > 0 || audited > 0 {
+		log.Printf("Operation %s: %s, %d changes and %d audit entries recorded", op.ID, method, changes, audited)
+	}
+}
@@ -0,0 +1,35 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStampOperation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.ContextWithFallback = true
+	engine.Use(stampOperation)
+
+	var seen string
+	engine.POST("/change", func(c *gin.Context) {
+		seen = db.OperationID(c) // handlers pass the gin context on
+		c.Status(http.StatusNoContent)
+	})
+
+	ids := map[string]bool{}
+	for range 2 {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/change", nil))
+		id := w.Header().Get(OperationHeader)
+		assert.NotEmpty(t, id)
+		assert.Equal(t, id, seen)
+		ids[id] = true
+	}
+	assert.Len(t, ids, 2, "each request is an operation of its own")
+}
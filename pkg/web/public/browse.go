@@ -0,0 +1,355 @@
+package public
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/classify"
+	"github.com/cgang/file-hub/pkg/links"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/stor"
+	"github.com/cgang/file-hub/pkg/thumb"
+	"github.com/cgang/file-hub/pkg/watermark"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/cgang/file-hub/pkg/web/serve"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// AccessHeader carries the access token of a password protected or view-only link
+const AccessHeader = "X-Share-Access"
+
+// ItemMeta describes a shared file or directory
+type ItemMeta struct {
+	Name         string    `json:"name"`
+	Path         string    `json:"path"` // relative to the link
+	IsDir        bool      `json:"is_dir"`
+	Size         int64     `json:"size"`
+	MimeType     string    `json:"mime_type,omitempty"`
+	ModTime      time.Time `json:"mod_time"`
+	DownloadURL  string    `json:"download_url,omitempty"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	ViewURL      string    `json:"view_url,omitempty"` // watermarked rendering, view-only links
+}
+
+// MetaResponse describes a shared item and, for directories, a page of its children
+type MetaResponse struct {
+	Item      *ItemMeta   `json:"item"`
+	Items     []*ItemMeta `json:"items,omitempty"`
+	Total     int         `json:"total,omitempty"`
+	Offset    int         `json:"offset,omitempty"`
+	Limit     int         `json:"limit,omitempty"`
+	HasMore   bool        `json:"has_more,omitempty"`
+	ExpiresAt *time.Time  `json:"expires_at,omitempty"`
+}
+
+// AuthorizeResponse carries an access token for a password protected or view-only link
+type AuthorizeResponse struct {
+	Access    string    `json:"access"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func getAccess(c *gin.Context) string {
+	if access := c.GetHeader(AccessHeader); access != "" {
+		return access
+	}
+	return c.Query("access")
+}
+
+// readable is a shared item resolved through a read, view-only or snapshot link
+type readable struct {
+	link     *model.ShareLink
+	res      *model.Resource   // where the content is, the stored copy for snapshot links
+	file     *model.FileObject // as it was published for snapshot links
+	viewer   string            // email the access token was issued to, view-only links
+	snapshot bool              // resolved through a snapshot link
+}
+
+// getReadable loads a read, view-only or snapshot link, verifies the access token and resolves the requested item.
+// Snapshot links resolve the item as it was when published, from the copy kept then.
+func getReadable(c *gin.Context) (*readable, bool) {
+	link, repo, ok := getLink(c)
+	if !ok {
+		return nil, false
+	}
+
+	if link.Mode != model.LinkModeRead && link.Mode != model.LinkModeView && link.Mode != model.LinkModeSnapshot {
+		apierr.Send(c, links.ErrForbidden)
+		return nil, false
+	}
+
+	viewer, err := links.CheckAccess(link, getAccess(c), time.Now())
+	if err != nil {
+		apierr.Send(c, err)
+		return nil, false
+	}
+
+	pub := links.Resolve(link, c.Query("path"))
+	item := &readable{link: link, viewer: viewer, res: &model.Resource{Repo: repo, Path: pub}}
+	if link.Mode == model.LinkModeSnapshot {
+		snap, err := links.GetSnapshotFile(c, link, pub)
+		if err != nil {
+			apierr.Send(c, err)
+			return nil, false
+		}
+		item.snapshot = true
+		item.file = links.SnapshotObject(link, snap)
+		if !snap.IsDir {
+			item.res.Path = snap.StoredName
+		}
+	} else if item.file, err = stor.GetFileInfo(c, item.res); err != nil {
+		apierr.Send(c, links.ErrNotFound)
+		return nil, false
+	}
+
+	if !item.file.IsDir {
+		if err := classify.CheckShare(c, repo, pub); err != nil {
+			apierr.Send(c, err)
+			return nil, false
+		}
+	}
+	return item, true
+}
+
+// getOriginal is getReadable for endpoints that expose the original content, which view-only links never do
+func getOriginal(c *gin.Context) (*readable, bool) {
+	item, ok := getReadable(c)
+	if !ok {
+		return nil, false
+	}
+
+	if item.link.Mode == model.LinkModeView {
+		apierr.Send(c, links.ErrForbidden)
+		return nil, false
+	}
+	return item, true
+}
+
+// newItemMeta describes a file with URLs that carry the caller's access token
+func newItemMeta(c *gin.Context, link *model.ShareLink, file *model.FileObject) *ItemMeta {
+	rel := strings.TrimPrefix(file.Path, link.Path)
+	if rel == "" {
+		rel = "/"
+	}
+
+	item := &ItemMeta{
+		Name:    file.Name,
+		Path:    rel,
+		IsDir:   file.IsDir,
+		Size:    file.Size,
+		ModTime: file.ModTime,
+	}
+	if file.MimeType != nil {
+		item.MimeType = *file.MimeType
+	}
+
+	if !file.IsDir {
+		query := url.Values{"path": {rel}}
+		if access := getAccess(c); access != "" {
+			query.Set("access", access)
+		}
+
+		base := "/s/" + link.Token
+		if link.Mode == model.LinkModeView {
+			if watermark.Supported(item.MimeType) && file.Size <= watermark.MaxSourceBytes {
+				item.ViewURL = base + "/view?" + query.Encode()
+			}
+			return item
+		}
+
+		item.DownloadURL = base + "/download?" + query.Encode()
+		if thumb.Supported(item.MimeType) && file.Size <= thumb.MaxSourceBytes {
+			item.ThumbnailURL = base + "/thumb?" + query.Encode()
+		}
+	}
+	return item
+}
+
+// AuthorizeLink exchanges the password of a protected link for an access token, with 429 once too many
+// wrong passwords were given for the link or from the address.
+// View-only links also take the viewer's email, which is stamped onto rendered content.
+func AuthorizeLink(c *gin.Context) {
+	link, _, ok := getLink(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Password string `json:"password" form:"password"`
+		Email    string `json:"email" form:"email"`
+	}
+	if err := c.Bind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	access, expires, err := links.Unlock(c, link, req.Password, req.Email, c.ClientIP(), time.Now())
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, AuthorizeResponse{Access: access, ExpiresAt: expires})
+}
+
+// GetMeta returns metadata of a shared item, with a page of children for directories
+func GetMeta(c *gin.Context) {
+	item, ok := getReadable(c)
+	if !ok {
+		return
+	}
+	link, res, file := item.link, item.res, item.file
+
+	resp := &MetaResponse{
+		Item:      newItemMeta(c, link, file),
+		ExpiresAt: link.ExpiresAt,
+	}
+
+	if file.IsDir {
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(DefaultLimit)))
+		if offset < 0 {
+			offset = 0
+		}
+		if limit <= 0 || limit > MaxLimit {
+			limit = DefaultLimit
+		}
+
+		var children []*model.FileObject
+		var err error
+		if item.snapshot {
+			children, err = links.ListSnapshotDir(c, link, file.Path)
+		} else {
+			children, err = stor.ListDir(c, res.Repo, file)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list directory"})
+			return
+		}
+
+		resp.Total = len(children)
+		resp.Offset = offset
+		resp.Limit = limit
+		resp.Items = []*ItemMeta{}
+		for _, child := range children[min(offset, len(children)):min(offset+limit, len(children))] {
+			resp.Items = append(resp.Items, newItemMeta(c, link, child))
+		}
+		resp.HasMore = offset+limit < len(children)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Download streams a shared file as an attachment
+func Download(c *gin.Context) {
+	item, ok := getOriginal(c)
+	if !ok {
+		return
+	}
+	res, file := item.res, item.file
+
+	if file.IsDir {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot download a directory"})
+		return
+	}
+
+	reader, err := stor.OpenFile(c, res)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		return
+	}
+	defer reader.Close()
+
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	serve.File(c, file, reader)
+}
+
+// Thumbnail returns a JPEG thumbnail of a shared image
+func Thumbnail(c *gin.Context) {
+	item, ok := getOriginal(c)
+	if !ok {
+		return
+	}
+	res, file := item.res, item.file
+
+	if file.IsDir || !thumb.Supported(file.ContentType()) || file.Size > thumb.MaxSourceBytes {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No thumbnail available"})
+		return
+	}
+
+	reader, err := stor.OpenFile(c, res)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		return
+	}
+	defer reader.Close()
+
+	var buf bytes.Buffer
+	if err := thumb.Generate(&buf, reader, thumb.DefaultSize); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to generate thumbnail"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Data(http.StatusOK, thumb.ContentType, buf.Bytes())
+}
+
+// View renders a shared document with a watermark naming the viewer
+func View(c *gin.Context) {
+	item, ok := getReadable(c)
+	if !ok {
+		return
+	}
+
+	file := item.file
+	if file.IsDir || !watermark.Supported(file.ContentType()) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "No rendering available for this file"})
+		return
+	}
+	if file.Size > watermark.MaxSourceBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large to render"})
+		return
+	}
+	page, err := watermark.ParsePage(c.Query("page"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return
+	}
+
+	reader, err := stor.OpenFile(c, item.res)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		return
+	}
+	defer reader.Close()
+
+	viewer := item.viewer
+	if viewer == "" {
+		viewer = "anonymous"
+	}
+
+	var buf bytes.Buffer
+	err = watermark.Render(c, &buf, reader, file.ContentType(), page, watermark.Lines(viewer, time.Now()))
+	if errors.Is(err, watermark.ErrNoPage) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+		return
+	} else if err != nil {
+		log.Printf("Failed to render %s: %s", file.Path, err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to render file"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, watermark.ContentType, buf.Bytes())
+}
@@ -0,0 +1,178 @@
+// Package public serves share links to clients without an account.
+package public
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/bandwidth"
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/links"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/cgang/file-hub/pkg/netacl"
+	"github.com/cgang/file-hub/pkg/web/apierr"
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead is the allowance for multipart headers on top of a link's remaining bytes
+const multipartOverhead = 1 << 20
+
+// Register configures the public share link routes
+func Register(r *gin.RouterGroup) {
+	r.GET("/:token", GetLink)
+	r.POST("/:token/upload", DropFiles)
+	r.POST("/:token/auth", AuthorizeLink)
+	r.GET("/:token/meta", GetMeta)
+	r.GET("/:token/download", Download)
+	r.GET("/:token/thumb", Thumbnail)
+	r.GET("/:token/view", View)
+}
+
+// RegisterShort configures the redirects of short links to their share links
+func RegisterShort(r *gin.RouterGroup) {
+	r.GET("/:slug", RedirectShort)
+}
+
+// RedirectShort sends the holder of a short link to the full URL of its share link
+func RedirectShort(c *gin.Context) {
+	link, err := links.GetBySlug(c, c.Param("slug"))
+	if err != nil {
+		apierr.Send(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store") // revoked links must stop redirecting
+	c.Redirect(http.StatusFound, links.URL(c.Request, link))
+}
+
+// LinkInfoResponse describes what a link allows without revealing repository contents
+type LinkInfoResponse struct {
+	Mode           string     `json:"mode"`
+	Folder         string     `json:"folder"`
+	Protected      bool       `json:"protected"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	RemainingBytes int64      `json:"remaining_bytes"` // -1 when unlimited
+	AllowedTypes   []string   `json:"allowed_types,omitempty"`
+}
+
+// DropResponse lists the files stored by an upload
+type DropResponse struct {
+	Files []string `json:"files"`
+}
+
+// getLink loads the link named by the token and enforces its repository's network restrictions
+func getLink(c *gin.Context) (*model.ShareLink, *model.Repository, bool) {
+	link, err := links.Get(c, c.Param("token"))
+	if err != nil {
+		apierr.Send(c, err)
+		return nil, nil, false
+	}
+
+	repo, err := db.GetRepositoryByID(c, link.RepoID)
+	if err != nil {
+		apierr.Send(c, links.ErrNotFound)
+		return nil, nil, false
+	}
+
+	if err := netacl.CheckRepo(c, repo, c.ClientIP(), ""); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access from " + c.ClientIP() + " is not allowed"})
+		return nil, nil, false
+	}
+
+	// The owner of the link pays for what it transfers, and links stop serving while they are over their cap
+	bandwidth.ChargeTo(c, link.OwnerID)
+	if err := bandwidth.Check(c, link.OwnerID); err != nil {
+		bandwidth.Refuse(c, err)
+		return nil, nil, false
+	}
+
+	return link, repo, true
+}
+
+// GetLink describes a share link
+func GetLink(c *gin.Context) {
+	link, _, ok := getLink(c)
+	if !ok {
+		return
+	}
+
+	folder := path.Base(link.Path)
+	if link.Path == "" {
+		folder = "/"
+	}
+
+	c.JSON(http.StatusOK, LinkInfoResponse{
+		Mode:           link.Mode,
+		Folder:         folder,
+		Protected:      link.IsProtected(),
+		ExpiresAt:      link.ExpiresAt,
+		RemainingBytes: link.RemainingBytes(),
+		AllowedTypes:   link.AllowedTypes,
+	})
+}
+
+// checkUploadable refuses links that are not upload links, and uploads without a valid access token
+// through a password protected one
+func checkUploadable(c *gin.Context, link *model.ShareLink) bool {
+	if link.Mode != model.LinkModeUpload {
+		apierr.Send(c, links.ErrForbidden)
+		return false
+	}
+
+	if _, err := links.CheckAccess(link, getAccess(c), time.Now()); err != nil {
+		apierr.Send(c, err)
+		return false
+	}
+	return true
+}
+
+// DropFiles stores files uploaded through an upload link.
+// Files are sent as multipart form data in one or more "file" fields.
+func DropFiles(c *gin.Context) {
+	link, _, ok := getLink(c)
+	if !ok || !checkUploadable(c, link) {
+		return
+	}
+
+	if remaining := link.RemainingBytes(); remaining >= 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, remaining+multipartOverhead)
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			apierr.Send(c, links.ErrTooLarge)
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart form"})
+		}
+		return
+	}
+
+	files := form.File["file"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file provided"})
+		return
+	}
+
+	resp := &DropResponse{}
+	for _, fh := range files {
+		f, err := fh.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+			return
+		}
+
+		stored, err := links.Drop(c, link, fh.Filename, fh.Size, f)
+		f.Close()
+		if err != nil {
+			apierr.Send(c, err)
+			return
+		}
+		resp.Files = append(resp.Files, path.Base(stored))
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
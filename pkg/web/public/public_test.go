@@ -0,0 +1,59 @@
+package public
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/links"
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newUploadRouter(link *model.ShareLink) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/upload", func(c *gin.Context) {
+		if checkUploadable(c, link) {
+			c.Status(http.StatusCreated)
+		}
+	})
+	return r
+}
+
+func TestCheckUploadable(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	link := &model.ShareLink{Token: "drop", Mode: model.LinkModeUpload, PasswordHash: string(hash)}
+	r := newUploadRouter(link)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/upload", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "no access token")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/upload?access=1.2.3", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "forged access token")
+
+	access, _, err := links.Authorize(link, "secret", "", time.Now())
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	req.Header.Set(AccessHeader, access)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = httptest.NewRecorder()
+	newUploadRouter(&model.ShareLink{Token: "open", Mode: model.LinkModeUpload}).
+		ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/upload", nil))
+	assert.Equal(t, http.StatusCreated, w.Code, "links without a password need no token")
+
+	w = httptest.NewRecorder()
+	newUploadRouter(&model.ShareLink{Token: "read", Mode: model.LinkModeRead}).
+		ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/upload", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code, "not an upload link")
+}
@@ -0,0 +1,46 @@
+package web
+
+import (
+	"context"
+
+	"github.com/cgang/file-hub/pkg/db"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+)
+
+// countQueries records how many database queries each request runs, by route
+func countQueries(c *gin.Context) {
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched" // keeps unknown paths from adding labels
+	}
+
+	ctx, done := db.CountQueries(c.Request.Context(), route)
+	defer done()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+// countQueriesUnary records how many database queries each gRPC call runs, by method
+func countQueriesUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, done := db.CountQueries(ctx, info.FullMethod)
+	defer done()
+	return handler(ctx, req)
+}
+
+// countQueriesStream records how many database queries each gRPC stream runs, by method
+func countQueriesStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, done := db.CountQueries(ss.Context(), info.FullMethod)
+	defer done()
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// contextStream is a stream with a context of its own, such as one counting queries
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
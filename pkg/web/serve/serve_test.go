@@ -0,0 +1,102 @@
+package serve
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRouter(file *model.FileObject, open func() io.Reader) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/file", func(c *gin.Context) {
+		File(c, file, open())
+	})
+	return r
+}
+
+func TestFile(t *testing.T) {
+	content := "hello, world"
+	mimeType := "text/plain"
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	file := &model.FileObject{Name: "hello.txt", Size: int64(len(content)), ModTime: modTime, MimeType: &mimeType}
+
+	name := filepath.Join(t.TempDir(), "hello.txt")
+	require.NoError(t, os.WriteFile(name, []byte(content), 0644))
+
+	local := newRouter(file, func() io.Reader {
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		t.Cleanup(func() { f.Close() })
+		return f
+	})
+
+	t.Run("Full content from local file", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		local.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/file", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, content, w.Body.String())
+		assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+		assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+		assert.Empty(t, w.Header().Get("Content-Security-Policy"))
+	})
+
+	t.Run("Range request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/file", nil)
+		req.Header.Set("Range", "bytes=7-11")
+		w := httptest.NewRecorder()
+		local.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "world", w.Body.String())
+	})
+
+	t.Run("If-Modified-Since", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/file", nil)
+		req.Header.Set("If-Modified-Since", modTime.Format(http.TimeFormat))
+		w := httptest.NewRecorder()
+		local.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotModified, w.Code)
+	})
+
+	t.Run("Streams readers that cannot seek", func(t *testing.T) {
+		stream := newRouter(file, func() io.Reader {
+			return io.MultiReader(strings.NewReader(content))
+		})
+
+		w := httptest.NewRecorder()
+		stream.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/file", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, content, w.Body.String())
+		assert.Equal(t, modTime.Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+		assert.Equal(t, "none", w.Header().Get("Accept-Ranges"))
+	})
+}
+
+func TestFileSandbox(t *testing.T) {
+	content := "<script>alert(1)</script>"
+	mimeType := "text/html"
+	file := &model.FileObject{Name: "page.html", Size: int64(len(content)), MimeType: &mimeType}
+	r := newRouter(file, func() io.Reader { return strings.NewReader(content) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/file", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, SandboxPolicy, w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+}
@@ -7,13 +7,18 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/cgang/file-hub/pkg/bandwidth"
 	"github.com/cgang/file-hub/pkg/config"
 	"github.com/cgang/file-hub/pkg/db"
+	"github.com/cgang/file-hub/pkg/netacl"
 	"github.com/cgang/file-hub/pkg/users"
 	"github.com/cgang/file-hub/pkg/web/api"
+	"github.com/cgang/file-hub/pkg/web/apierr"
 	"github.com/cgang/file-hub/pkg/web/auth"
 	"github.com/cgang/file-hub/pkg/web/dav"
 	"github.com/cgang/file-hub/pkg/web/handlers"
+	"github.com/cgang/file-hub/pkg/web/ocm"
+	"github.com/cgang/file-hub/pkg/web/public"
 	"github.com/cgang/file-hub/web"
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
@@ -40,6 +45,12 @@ func defaultRoute(c *gin.Context) {
 
 func Start(ctx context.Context, cfg *config.Config) {
 	auth.Init(cfg)
+	dav.Init(cfg)
+
+	var err error
+	if serverTLS, err = newTLSConfig(&cfg.Web.TLS); err != nil {
+		log.Fatalf("Invalid TLS configuration: %s", err)
+	}
 
 	// Create a sub filesystem from the embedded files
 	uiFiles, err := web.StaticFiles()
@@ -47,7 +58,17 @@ func Start(ctx context.Context, cfg *config.Config) {
 		log.Fatalf("Failed to load static files: %v", err)
 	}
 
+	if err := netacl.Init(cfg); err != nil {
+		log.Fatalf("Invalid network access list: %s", err)
+	}
+
 	engine := gin.Default()
+	if err := engine.SetTrustedProxies(cfg.Web.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted proxies: %s", err)
+	}
+	// Handlers passing the gin context on see the operation ID and query counter of the request
+	engine.ContextWithFallback = true
+	engine.Use(apierr.Middleware(), stampOperation, countQueries, netacl.Filter, bandwidth.Meter, minBodyRate(cfg.Web.MinBodyRate, cfg.Web.BodyRateWindow, cfg.Web.ReadTimeout))
 
 	if cfg.Web.Metrics {
 		// Register Prometheus metrics endpoint
@@ -62,15 +83,37 @@ func Start(ctx context.Context, cfg *config.Config) {
 	api.Register(engine.Group("/api"))
 	dav.Register(engine.Group("/dav"))
 	handlers.RegisterSyncRoutes(engine, db.GetDB())
+	public.Register(engine.Group("/s"))
+	public.RegisterShort(engine.Group("/l"))
+	ocm.Register(engine)
+
+	if cfg.Web.GRPCWeb {
+		if err := registerGRPCWeb(engine, cfg.Web.CORSOrigins); err != nil {
+			log.Fatalf("Failed to enable gRPC-Web: %s", err)
+		}
+	}
 
 	engine.StaticFS("/ui", uiFiles)
 	engine.GET("/", defaultRoute)
 
 	addr := fmt.Sprintf(":%d", cfg.Web.Port)
 	log.Printf("Starting Web server at %s", addr)
-	server = &http.Server{Addr: addr, Handler: engine.Handler()}
+	server = &http.Server{
+		Addr:              addr,
+		Handler:           engine.Handler(),
+		ReadHeaderTimeout: cfg.Web.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Web.ReadTimeout,
+		WriteTimeout:      cfg.Web.WriteTimeout,
+		IdleTimeout:       cfg.Web.IdleTimeout,
+		TLSConfig:         serverTLS,
+	}
 	go func() {
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		serve := server.ListenAndServe
+		if serverTLS != nil {
+			// The certificate is in TLSConfig already
+			serve = func() error { return server.ListenAndServeTLS("", "") }
+		}
+		if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Panicf("Failed to start web server: %s", err)
 		}
 	}()
@@ -0,0 +1,41 @@
+package session
+
+import "net/netip"
+
+// Binding ties sessions to the network they were created from, so a stolen session cookie
+// does not work elsewhere. A zero prefix length leaves sessions of that address family unbound.
+type Binding struct {
+	IPv4Prefix int
+	IPv6Prefix int
+}
+
+// Allows reports whether a session created from origin may be used from addr.
+// Sessions without a recorded origin are not bound.
+func (b Binding) Allows(origin, addr string) bool {
+	from, err := netip.ParseAddr(origin)
+	if err != nil {
+		return true
+	}
+	from = from.Unmap()
+
+	bits := b.IPv6Prefix
+	if from.Is4() {
+		bits = b.IPv4Prefix
+	}
+	if bits <= 0 {
+		return true
+	}
+	bits = min(bits, from.BitLen())
+
+	to, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	to = to.Unmap()
+	if to.BitLen() != from.BitLen() {
+		return false
+	}
+
+	prefix, _ := from.Prefix(bits)
+	return prefix.Contains(to)
+}
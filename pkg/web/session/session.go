@@ -2,7 +2,9 @@ package session
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"sort"
 	"sync"
 	"time"
 
@@ -15,11 +17,22 @@ type Session struct {
 	User      *model.User
 	CreatedAt time.Time
 	ExpiresAt time.Time
+	// CSRFToken must accompany requests changing state, which a cross-site form or script cannot learn
+	CSRFToken string
+	// RemoteAddr and UserAgent describe the client that logged in
+	RemoteAddr string
+	UserAgent  string
+	// LastActivity is when the session was last used, to a minute; read it from List
+	LastActivity time.Time
 }
 
+// activityInterval is how stale LastActivity may get, sparing a write lock on every request
+const activityInterval = time.Minute
+
 // Store manages sessions in memory
 type Store struct {
 	sessions map[string]*Session
+	handles  map[string]string // session IDs by handle
 	mu       sync.RWMutex
 }
 
@@ -27,6 +40,7 @@ type Store struct {
 func NewStore() *Store {
 	store := &Store{
 		sessions: make(map[string]*Session),
+		handles:  make(map[string]string),
 	}
 
 	// Start a goroutine to clean up expired sessions periodically
@@ -45,25 +59,42 @@ func generateSessionID() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-// Create creates a new session for a user
-func (s *Store) Create(user *model.User) (*Session, error) {
+// Handle derives a public name of a session from its ID, which can be handed out without
+// revealing the ID itself
+func Handle(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Create creates a new session for a user logging in from the given client
+func (s *Store) Create(user *model.User, remoteAddr, userAgent string) (*Session, error) {
 	sessionID, err := generateSessionID()
 	if err != nil {
 		return nil, err
 	}
+	csrfToken, err := generateSessionID()
+	if err != nil {
+		return nil, err
+	}
 
 	// Set session expiry to 24 hours from now
 	expiresAt := time.Now().Add(24 * time.Hour)
 
+	now := time.Now()
 	session := &Session{
-		ID:        sessionID,
-		User:      user,
-		CreatedAt: time.Now(),
-		ExpiresAt: expiresAt,
+		ID:           sessionID,
+		User:         user,
+		CreatedAt:    now,
+		ExpiresAt:    expiresAt,
+		CSRFToken:    csrfToken,
+		RemoteAddr:   remoteAddr,
+		UserAgent:    userAgent,
+		LastActivity: now,
 	}
 
 	s.mu.Lock()
 	s.sessions[sessionID] = session
+	s.handles[Handle(sessionID)] = sessionID
 	s.mu.Unlock()
 
 	return session, nil
@@ -84,6 +115,7 @@ func (s *Store) Get(sessionID string) (*Session, bool) {
 		// Remove expired session
 		s.mu.Lock()
 		delete(s.sessions, sessionID)
+		delete(s.handles, Handle(sessionID))
 		s.mu.Unlock()
 		return nil, false
 	}
@@ -91,10 +123,70 @@ func (s *Store) Get(sessionID string) (*Session, bool) {
 	return session, true
 }
 
+// Touch records that a session was used just now
+func (s *Store) Touch(sessionID string) {
+	now := time.Now()
+
+	s.mu.RLock()
+	session, exists := s.sessions[sessionID]
+	stale := exists && now.Sub(session.LastActivity) >= activityInterval
+	s.mu.RUnlock()
+
+	if stale {
+		s.mu.Lock()
+		session.LastActivity = now
+		s.mu.Unlock()
+	}
+}
+
+// List returns copies of the sessions of a user, oldest first
+func (s *Store) List(userID int) []Session {
+	now := time.Now()
+	var list []Session
+
+	s.mu.RLock()
+	for _, session := range s.sessions {
+		if session.User.ID == userID && now.Before(session.ExpiresAt) {
+			list = append(list, *session)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// GetByHandle retrieves a session by its handle
+func (s *Store) GetByHandle(handle string) (*Session, bool) {
+	s.mu.RLock()
+	sessionID, exists := s.handles[handle]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, false
+	}
+	return s.Get(sessionID)
+}
+
 // Destroy removes a session
 func (s *Store) Destroy(sessionID string) {
 	s.mu.Lock()
 	delete(s.sessions, sessionID)
+	delete(s.handles, Handle(sessionID))
+	s.mu.Unlock()
+}
+
+// DestroyUser removes all sessions of a user, whose details they hold went stale
+func (s *Store) DestroyUser(userID int) {
+	s.mu.Lock()
+	for id, session := range s.sessions {
+		if session.User.ID == userID {
+			delete(s.sessions, id)
+			delete(s.handles, Handle(id))
+		}
+	}
 	s.mu.Unlock()
 }
 
@@ -124,6 +216,7 @@ func (s *Store) cleanupExpiredSessions() {
 		for id, session := range s.sessions {
 			if now.After(session.ExpiresAt) {
 				delete(s.sessions, id)
+				delete(s.handles, Handle(id))
 			}
 		}
 		s.mu.Unlock()
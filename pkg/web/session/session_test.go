@@ -21,11 +21,13 @@ func TestSessionStore(t *testing.T) {
 	}
 
 	// Test creating a session
-	session, err := store.Create(user)
+	session, err := store.Create(user, "", "")
 	assert.NoError(t, err)
 	assert.NotNil(t, session)
 	assert.NotEmpty(t, session.ID)
 	assert.Equal(t, user, session.User)
+	assert.Len(t, session.CSRFToken, 32)
+	assert.NotEqual(t, session.ID, session.CSRFToken)
 
 	// Test retrieving a session
 	retrievedSession, ok := store.Get(session.ID)
@@ -110,7 +112,7 @@ func TestSessionCreation(t *testing.T) {
 		store := NewStore()
 		user := &model.User{ID: 1, Username: "testuser"}
 
-		session, err := store.Create(user)
+		session, err := store.Create(user, "", "")
 		assert.NoError(t, err)
 
 		// Expiry should be approximately 24 hours from now
@@ -122,7 +124,7 @@ func TestSessionCreation(t *testing.T) {
 		store := NewStore()
 		user := &model.User{ID: 1, Username: "testuser"}
 
-		session, err := store.Create(user)
+		session, err := store.Create(user, "", "")
 		assert.NoError(t, err)
 
 		assert.True(t, session.CreatedAt.Before(session.ExpiresAt))
@@ -133,10 +135,10 @@ func TestSessionCreation(t *testing.T) {
 		store := NewStore()
 		user := &model.User{ID: 1, Username: "testuser"}
 
-		session1, err := store.Create(user)
+		session1, err := store.Create(user, "", "")
 		assert.NoError(t, err)
 
-		session2, err := store.Create(user)
+		session2, err := store.Create(user, "", "")
 		assert.NoError(t, err)
 
 		// Sessions should have different IDs
@@ -164,7 +166,7 @@ func TestSessionGet(t *testing.T) {
 		store := NewStore()
 		user := &model.User{ID: 1, Username: "testuser"}
 
-		session, _ := store.Create(user)
+		session, _ := store.Create(user, "", "")
 
 		// Manually expire the session
 		store.sessions[session.ID].ExpiresAt = time.Now().Add(-1 * time.Hour)
@@ -185,7 +187,7 @@ func TestSessionDestroy(t *testing.T) {
 		store := NewStore()
 		user := &model.User{ID: 1, Username: "testuser"}
 
-		session, _ := store.Create(user)
+		session, _ := store.Create(user, "", "")
 
 		// Verify session exists
 		_, ok := store.Get(session.ID)
@@ -206,6 +208,22 @@ func TestSessionDestroy(t *testing.T) {
 			store.Destroy("non-existent")
 		})
 	})
+
+	t.Run("Destroy sessions of a user", func(t *testing.T) {
+		store := NewStore()
+		first, _ := store.Create(&model.User{ID: 1, Username: "testuser"}, "", "")
+		second, _ := store.Create(&model.User{ID: 1, Username: "testuser"}, "", "")
+		other, _ := store.Create(&model.User{ID: 2, Username: "otheruser"}, "", "")
+
+		store.DestroyUser(1)
+
+		_, ok := store.Get(first.ID)
+		assert.False(t, ok)
+		_, ok = store.GetByHandle(Handle(second.ID))
+		assert.False(t, ok)
+		_, ok = store.Get(other.ID)
+		assert.True(t, ok)
+	})
 }
 
 func TestSessionExtend(t *testing.T) {
@@ -213,7 +231,7 @@ func TestSessionExtend(t *testing.T) {
 		store := NewStore()
 		user := &model.User{ID: 1, Username: "testuser"}
 
-		session, _ := store.Create(user)
+		session, _ := store.Create(user, "", "")
 		originalExpiry := session.ExpiresAt
 
 		// Wait a bit then extend
@@ -237,7 +255,7 @@ func TestSessionExtend(t *testing.T) {
 		store := NewStore()
 		user := &model.User{ID: 1, Username: "testuser"}
 
-		session, _ := store.Create(user)
+		session, _ := store.Create(user, "", "")
 
 		// Manually set a near expiry time
 		store.sessions[session.ID].ExpiresAt = time.Now().Add(1 * time.Minute)
@@ -264,7 +282,7 @@ func TestSessionConcurrentAccess(t *testing.T) {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				session, err := store.Create(user)
+				session, err := store.Create(user, "", "")
 				if err == nil {
 					sessions <- session
 				}
@@ -286,7 +304,7 @@ func TestSessionConcurrentAccess(t *testing.T) {
 		store := NewStore()
 		user := &model.User{ID: 1, Username: "testuser"}
 
-		session, _ := store.Create(user)
+		session, _ := store.Create(user, "", "")
 
 		var wg sync.WaitGroup
 
@@ -322,9 +340,9 @@ func TestSessionWithDifferentUsers(t *testing.T) {
 		user2 := &model.User{ID: 2, Username: "user2"}
 		user3 := &model.User{ID: 3, Username: "user3"}
 
-		session1, _ := store.Create(user1)
-		session2, _ := store.Create(user2)
-		session3, _ := store.Create(user3)
+		session1, _ := store.Create(user1, "", "")
+		session2, _ := store.Create(user2, "", "")
+		session3, _ := store.Create(user3, "", "")
 
 		// All sessions should be retrievable
 		r1, ok1 := store.Get(session1.ID)
@@ -346,7 +364,7 @@ func TestSessionProperties(t *testing.T) {
 		store := NewStore()
 		user := &model.User{ID: 1, Username: "testuser"}
 
-		session, _ := store.Create(user)
+		session, _ := store.Create(user, "", "")
 		assert.Len(t, session.ID, 32)
 	})
 
@@ -361,7 +379,7 @@ func TestSessionProperties(t *testing.T) {
 			IsAdmin:   true,
 		}
 
-		session, _ := store.Create(user)
+		session, _ := store.Create(user, "", "")
 		assert.Equal(t, user.ID, session.User.ID)
 		assert.Equal(t, user.Username, session.User.Username)
 		assert.Equal(t, user.IsAdmin, session.User.IsAdmin)
@@ -371,3 +389,79 @@ func TestSessionProperties(t *testing.T) {
 func stringPtr(s string) *string {
 	return &s
 }
+
+func TestSessionGetByHandle(t *testing.T) {
+	store := NewStore()
+	user := &model.User{ID: 1, Username: "testuser"}
+
+	session, err := store.Create(user, "", "")
+	assert.NoError(t, err)
+
+	handle := Handle(session.ID)
+	assert.NotEqual(t, session.ID, handle)
+
+	retrieved, ok := store.GetByHandle(handle)
+	assert.True(t, ok)
+	assert.Equal(t, session.ID, retrieved.ID)
+
+	store.Destroy(session.ID)
+	_, ok = store.GetByHandle(handle)
+	assert.False(t, ok)
+}
+
+func TestSessionList(t *testing.T) {
+	store := NewStore()
+	user := &model.User{ID: 1, Username: "testuser"}
+
+	first, _ := store.Create(user, "192.0.2.1", "client/1.0")
+	second, _ := store.Create(user, "192.0.2.2", "client/2.0")
+	_, _ = store.Create(&model.User{ID: 2, Username: "otheruser"}, "", "")
+
+	list := store.List(user.ID)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, first.ID, list[0].ID)
+		assert.Equal(t, "192.0.2.1", list[0].RemoteAddr)
+		assert.Equal(t, "client/1.0", list[0].UserAgent)
+		assert.Equal(t, second.ID, list[1].ID)
+	}
+
+	t.Run("Touch updates stale activity", func(t *testing.T) {
+		stale := time.Now().Add(-time.Hour)
+		store.mu.Lock()
+		first.LastActivity = stale
+		store.mu.Unlock()
+
+		store.Touch(first.ID)
+		assert.True(t, store.List(user.ID)[0].LastActivity.After(stale))
+	})
+}
+
+func TestBinding(t *testing.T) {
+	tests := []struct {
+		name    string
+		binding Binding
+		origin  string
+		addr    string
+		allowed bool
+	}{
+		{"unbound", Binding{}, "192.0.2.1", "198.51.100.1", true},
+		{"no origin", Binding{IPv4Prefix: 32}, "", "198.51.100.1", true},
+		{"same address", Binding{IPv4Prefix: 32}, "192.0.2.1", "192.0.2.1", true},
+		{"other address", Binding{IPv4Prefix: 32}, "192.0.2.1", "192.0.2.2", false},
+		{"same subnet", Binding{IPv4Prefix: 24}, "192.0.2.1", "192.0.2.200", true},
+		{"other subnet", Binding{IPv4Prefix: 24}, "192.0.2.1", "192.0.3.1", false},
+		{"mapped address", Binding{IPv4Prefix: 24}, "192.0.2.1", "::ffff:192.0.2.9", true},
+		{"other family", Binding{IPv4Prefix: 24, IPv6Prefix: 64}, "192.0.2.1", "2001:db8::1", false},
+		{"ipv6 unbound", Binding{IPv4Prefix: 24}, "2001:db8::1", "2001:db8:1::1", true},
+		{"ipv6 same subnet", Binding{IPv6Prefix: 64}, "2001:db8::1", "2001:db8::ffff", true},
+		{"ipv6 other subnet", Binding{IPv6Prefix: 64}, "2001:db8::1", "2001:db8:0:1::1", false},
+		{"prefix too long", Binding{IPv4Prefix: 64}, "192.0.2.1", "192.0.2.1", true},
+		{"invalid address", Binding{IPv4Prefix: 24}, "192.0.2.1", "unknown", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, tt.binding.Allows(tt.origin, tt.addr))
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package web
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrSlowBody is returned reading a request body that arrives below the minimum rate
+var ErrSlowBody = errors.New("request body arrives too slowly")
+
+// minBodyRate cuts off request bodies that do not bring rate bytes per second on average over each window,
+// so that clients trickling an upload cannot hold a connection and its temporary files for hours.
+// Deadlines never go beyond the read timeout of the server, if it has one.
+func minBodyRate(rate int64, window, readTimeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rate <= 0 || window <= 0 || c.Request.Body == nil || c.Request.Body == http.NoBody || c.Request.ContentLength == 0 {
+			c.Next()
+			return
+		}
+
+		body := &rateReader{
+			ReadCloser: c.Request.Body,
+			rc:         http.NewResponseController(c.Writer),
+			due:        max(int64(float64(rate)*window.Seconds()), 1),
+			window:     window,
+			client:     c.ClientIP(),
+		}
+		if readTimeout > 0 {
+			body.until = time.Now().Add(readTimeout)
+		}
+		if err := body.extend(); err != nil {
+			c.Next() // connection without deadlines, such as in tests
+			return
+		}
+
+		c.Request.Body = body
+		defer body.clear()
+		c.Next()
+	}
+}
+
+// rateReader reads a request body, moving the read deadline of the connection one window ahead
+// whenever a window's worth of bytes arrived
+type rateReader struct {
+	io.ReadCloser
+	rc     *http.ResponseController
+	due    int64 // bytes to arrive per window
+	window time.Duration
+	client string
+	until  time.Time // deadline set by the server, if any
+	got    int64     // bytes arrived since the deadline was moved
+	done   bool      // whether the deadline was set back to the server's
+}
+
+func (r *rateReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.got += int64(n)
+
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded) && (r.until.IsZero() || time.Now().Before(r.until)):
+		log.Printf("Aborted request body from %s arriving below the minimum rate", r.client)
+		r.done = true // the deadline that passed ends the connection too
+		return n, ErrSlowBody
+	case err != nil:
+		// Done reading, the handler may take its time to answer
+		r.clear()
+	case r.got >= r.due:
+		r.got = 0
+		if err := r.extend(); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+func (r *rateReader) extend() error {
+	deadline := time.Now().Add(r.window)
+	if !r.until.IsZero() && deadline.After(r.until) {
+		deadline = r.until
+	}
+	return r.rc.SetReadDeadline(deadline)
+}
+
+func (r *rateReader) clear() {
+	if !r.done {
+		r.done = true
+		r.rc.SetReadDeadline(r.until)
+	}
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowServer serves an upload endpoint reporting how much of the body it read, and the error that ended it
+func slowServer(t *testing.T, rate int64, window time.Duration) (string, <-chan error) {
+	gin.SetMode(gin.TestMode)
+	errs := make(chan error, 1)
+	engine := gin.New()
+	engine.Use(minBodyRate(rate, window, 0))
+	engine.POST("/upload", func(c *gin.Context) {
+		n, err := io.Copy(io.Discard, c.Request.Body)
+		errs <- err
+		c.String(http.StatusOK, "%d", n)
+	})
+
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://"), errs
+}
+
+func TestMinBodyRate(t *testing.T) {
+	t.Run("Fast enough", func(t *testing.T) {
+		addr, errs := slowServer(t, 100, 100*time.Millisecond)
+		resp, err := http.Post("http://"+addr+"/upload", "text/plain", strings.NewReader(strings.Repeat("x", 1000)))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "1000", string(body))
+		assert.NoError(t, <-errs)
+	})
+
+	t.Run("Trickling", func(t *testing.T) {
+		addr, errs := slowServer(t, 100, 100*time.Millisecond)
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = fmt.Fprintf(conn, "POST /upload HTTP/1.1\r\nHost: %s\r\nContent-Length: 1000\r\n\r\n", addr)
+		require.NoError(t, err)
+		for range 3 {
+			_, err = conn.Write([]byte("x"))
+			require.NoError(t, err)
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		select {
+		case err := <-errs:
+			assert.ErrorIs(t, err, ErrSlowBody)
+		case <-time.After(5 * time.Second):
+			t.Fatal("slow body was not cut off")
+		}
+
+		// The connection is closed rather than kept for another request
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, _ = http.ReadResponse(bufio.NewReader(conn), nil)
+		_, err = conn.Read(make([]byte, 1))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/cgang/file-hub/pkg/config"
+)
+
+// serverTLS is what the HTTP and gRPC listeners serve TLS with, nil to serve plain connections
+var serverTLS *tls.Config
+
+// tlsVersions are the minimum versions web.tls.min_version accepts; older ones are broken
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig loads the certificate and policy of the listeners, nil when TLS is not configured
+func newTLSConfig(cfg *config.TLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.MinVersion != "" {
+		version, ok := tlsVersions[strings.TrimSpace(cfg.MinVersion)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported minimum TLS version %q, use 1.2 or 1.3", cfg.MinVersion)
+		}
+		tc.MinVersion = version
+	}
+
+	if len(cfg.CipherSuites) > 0 {
+		if tc.CipherSuites, err = cipherSuites(cfg.CipherSuites); err != nil {
+			return nil, err
+		}
+	}
+	return tc, nil
+}
+
+// cipherSuites returns the IDs of cipher suites named as Go names them. Suites Go considers insecure are
+// refused. TLS 1.3 suites are not configurable and always enabled.
+func cipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	insecure := make(map[string]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		id, ok := known[name]
+		switch {
+		case insecure[name]:
+			return nil, fmt.Errorf("cipher suite %s is insecure", name)
+		case !ok:
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
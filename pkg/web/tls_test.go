@@ -0,0 +1,72 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cgang/file-hub/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a self-signed certificate and its key to dir
+func writeCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	tc, err := newTLSConfig(&config.TLSConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, tc, "plain connections without a certificate")
+
+	certFile, keyFile := writeCertificate(t, t.TempDir())
+	cfg := &config.TLSConfig{CertFile: certFile, KeyFile: keyFile}
+	tc, err = newTLSConfig(cfg)
+	require.NoError(t, err)
+	assert.Len(t, tc.Certificates, 1)
+	assert.Equal(t, uint16(tls.VersionTLS12), tc.MinVersion)
+	assert.Nil(t, tc.CipherSuites)
+
+	cfg.MinVersion = "1.3"
+	cfg.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}
+	tc, err = newTLSConfig(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), tc.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, tc.CipherSuites)
+
+	for _, bad := range []config.TLSConfig{
+		{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.0"},
+		{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_MADE_UP"}},
+		{CertFile: certFile},
+	} {
+		_, err := newTLSConfig(&bad)
+		assert.Error(t, err, bad)
+	}
+}
@@ -1,6 +1,9 @@
 -- File Hub Database Schema
 -- PostgreSQL schema for user management, repositories, file metadata, shares and quota management
 
+-- Collation listing names with numbers by their value, file2 before file10
+CREATE COLLATION IF NOT EXISTS "natural" (provider = icu, locale = 'und-u-kn-true');
+
 -- Users table for authentication and user information
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
@@ -12,6 +15,12 @@ CREATE TABLE users (
     last_login TIMESTAMP WITH TIME ZONE,
     is_active BOOLEAN DEFAULT TRUE,
     is_admin BOOLEAN DEFAULT FALSE,
+    locale VARCHAR(35) NOT NULL DEFAULT '',  -- preferred language of messages, empty for the default
+    totp_secret VARCHAR(64) NOT NULL DEFAULT '',  -- base32 secret for one-time codes, empty when not enabled
+    digest VARCHAR(16) NOT NULL DEFAULT '',  -- activity digest schedule: daily, weekly or empty for none
+    digest_sent_at TIMESTAMP WITH TIME ZONE,  -- end of the period the last digest covered
+    preferences JSONB NOT NULL DEFAULT '{}',  -- settings clients keep on the server, such as the default sort or theme
+    version INTEGER NOT NULL DEFAULT 0,  -- bumped by every change, for optimistic locking of updates
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
@@ -22,10 +31,22 @@ CREATE TABLE repositories (
     owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     name VARCHAR(255) NOT NULL,
     root TEXT NOT NULL,
+    allow_nets TEXT[],  -- CIDRs allowed to access the repository, empty allows all
+    deny_nets TEXT[],   -- CIDRs denied access to the repository
+    ocr BOOLEAN NOT NULL DEFAULT FALSE,  -- Extract the text of images and PDFs for search
+    cold_root TEXT,           -- Storage root files not opened for cold_after_days move to, NULL to keep them
+    cold_after_days INTEGER,  -- Days without access after which files move to cold_root
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Former names of renamed repositories, still resolving to them for a grace period
+CREATE TABLE repository_aliases (
+    name VARCHAR(255) PRIMARY KEY,
+    repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
 -- File metadata table to track files and directories in repositories
 CREATE TABLE files (
     id SERIAL PRIMARY KEY,
@@ -40,6 +61,11 @@ CREATE TABLE files (
     checksum VARCHAR(64),            -- SHA-256 hash of file content
     is_dir BOOLEAN NOT NULL DEFAULT FALSE,  -- True for directories, false for files
     deleted BOOLEAN NOT NULL DEFAULT FALSE,   -- Soft delete flag
+    flags TEXT[],                    -- Sensitive content found by the classifier, such as credit_card
+    classified_at TIMESTAMP WITH TIME ZONE,  -- When the content was last classified, NULL if never
+    immutable BOOLEAN NOT NULL DEFAULT FALSE,  -- Pinned: not overwritten, moved or deleted until cleared
+    cold_root TEXT,                          -- Storage root holding the content once moved to cold storage, NULL while in the repository
+    accessed_at TIMESTAMP WITH TIME ZONE,    -- When the content was last opened, to the day, NULL if never
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
@@ -50,7 +76,10 @@ CREATE TABLE shares (
     repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
     owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-    path TEXT NOT NULL  -- Path within the repository being shared
+    path TEXT NOT NULL,  -- Path within the repository being shared
+    view_only BOOLEAN NOT NULL DEFAULT FALSE,  -- Only watermarked views, original content is not served
+    writable BOOLEAN NOT NULL DEFAULT FALSE,   -- Files below the path may be written and removed too
+    expires_at TIMESTAMP WITH TIME ZONE  -- NULL for no expiry
 );
 
 -- Quota management for users
@@ -59,14 +88,236 @@ CREATE TABLE user_quota (
     user_id INTEGER NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
     total_quota_bytes BIGINT NOT NULL DEFAULT 10737418240, -- 10GB default
     used_bytes BIGINT NOT NULL DEFAULT 0,
+    transfer_cap_bytes BIGINT,  -- Bytes the user may transfer per month, 0 for no cap, NULL for bandwidth.monthly_cap
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Bytes each user uploaded and downloaded per calendar month
+CREATE TABLE transfer_usage (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    month CHAR(7) NOT NULL,  -- YYYY-MM, in UTC
+    uploaded_bytes BIGINT NOT NULL DEFAULT 0,
+    downloaded_bytes BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, month)
+);
+
+-- Token based share links, such as upload-only file drops
+CREATE TABLE share_links (
+    id SERIAL PRIMARY KEY,
+    token VARCHAR(64) UNIQUE NOT NULL,
+    repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    path TEXT NOT NULL,              -- Path within the repository the link points to
+    mode VARCHAR(16) NOT NULL CHECK (mode IN ('upload', 'read', 'view', 'snapshot')),
+    expires_at TIMESTAMP WITH TIME ZONE,  -- NULL for no expiry
+    max_bytes BIGINT,                -- Total upload limit, NULL for unlimited
+    used_bytes BIGINT NOT NULL DEFAULT 0,
+    allowed_types TEXT[],            -- MIME types or extensions accepted, empty allows all
+    password_hash VARCHAR(255),      -- bcrypt hash, NULL when not password protected
+    version VARCHAR(64),             -- Repository version a snapshot link is pinned to
+    published_at TIMESTAMP WITH TIME ZONE,  -- When a snapshot link was last published
+    slug VARCHAR(32) UNIQUE,         -- Short link redirecting to the link, NULL without one
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Files and folders of snapshot links as they were when published
+CREATE TABLE snapshot_files (
+    id BIGSERIAL PRIMARY KEY,
+    link_id INTEGER NOT NULL REFERENCES share_links(id) ON DELETE CASCADE,
+    path TEXT NOT NULL,              -- Path in the repository when published
+    parent TEXT NOT NULL,            -- Path of the folder holding it when published
+    stored_name TEXT,                -- Storage name inside the repository's snapshot folder, NULL for folders
+    is_dir BOOLEAN NOT NULL DEFAULT FALSE,
+    size BIGINT NOT NULL DEFAULT 0,
+    mime_type VARCHAR(255),
+    mod_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    UNIQUE (link_id, path)
+);
+
+-- Notifications delivered to users
+CREATE TABLE notifications (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(64) NOT NULL,
+    message TEXT NOT NULL,
+    read BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Transports a user's notifications are also delivered through, such as Slack or Telegram
+CREATE TABLE notify_routes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    transport VARCHAR(32) NOT NULL,  -- email, slack, matrix or telegram
+    target TEXT NOT NULL,            -- Address, webhook URL, room or chat ID delivered to
+    kinds TEXT[],                    -- Notification kinds, empty routes all
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_delivery_at TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    failures INTEGER NOT NULL DEFAULT 0  -- Deliveries failed in a row
+);
+
+-- Automatic expiry rules per folder
+CREATE TABLE expiry_rules (
+    id SERIAL PRIMARY KEY,
+    repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    path TEXT NOT NULL,  -- Folder the rule applies to, empty for the whole repository
+    max_age_days INTEGER NOT NULL CHECK (max_age_days > 0),
+    created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (repo_id, path)
+);
+
+-- Folders whose changes users follow in their activity digest
+CREATE TABLE follows (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    path TEXT NOT NULL,  -- Folder followed, empty for the whole repository
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, repo_id, path)
+);
+
+-- Files and folders whose changes users are told of through the channels they chose
+CREATE TABLE subscriptions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    path TEXT NOT NULL,  -- File or folder watched, empty for the whole repository
+    channels TEXT[] NOT NULL,  -- digest, webhook and/or websocket
+    webhook_url TEXT,  -- Receiver of the webhook channel
+    secret VARCHAR(128),  -- HMAC-SHA256 key signing webhook deliveries
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, repo_id, path)
+);
+
+-- Text extracted from files for full text search
+CREATE TABLE file_text (
+    file_id INTEGER PRIMARY KEY REFERENCES files(id) ON DELETE CASCADE,
+    repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    tsv TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', content)) STORED,
+    error TEXT,  -- Why extraction failed, the file is not retried until it changes
+    extracted_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Inbox folders whose photos and videos are filed into dated folders
+CREATE TABLE organize_rules (
+    id SERIAL PRIMARY KEY,
+    repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    path TEXT NOT NULL,  -- Inbox folder watched by the rule
+    target TEXT NOT NULL DEFAULT '',  -- Folder the layout is created under, empty for the repository root
+    layout TEXT NOT NULL DEFAULT '{year}/{month}',  -- Folder pattern of filed files
+    rename TEXT,  -- File name pattern, NULL keeps the name
+    created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (repo_id, path)
+);
+
+-- Deleted files kept until they are restored or purged
+CREATE TABLE trash (
+    id BIGSERIAL PRIMARY KEY,
+    repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    path TEXT NOT NULL,         -- Original path of the file
+    stored_name TEXT NOT NULL,  -- Storage name inside the repository's trash folder
+    size BIGINT NOT NULL DEFAULT 0,
+    deleted_by INTEGER REFERENCES users(id) ON DELETE SET NULL,  -- NULL when removed by the server
+    reason VARCHAR(32) NOT NULL CHECK (reason IN ('deleted', 'expired')),
+    deleted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Content of files in dedup storage roots, stored once by SHA-256
+CREATE TABLE blobs (
+    checksum VARCHAR(64) PRIMARY KEY,  -- SHA-256 of the content, naming the blob file
+    size BIGINT NOT NULL,
+    refs INTEGER NOT NULL CHECK (refs >= 0),  -- Stored paths holding the content, the blob is removed at 0
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Passwords generated for single clients of a user, used with Basic and Digest authentication
+CREATE TABLE app_passwords (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,  -- Client it was created for, chosen by the user
+    ha1_hash VARCHAR(255) NOT NULL,  -- HA1 hash of the username, realm and generated password
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used TIMESTAMP WITH TIME ZONE
+);
+
+-- Failed login counters per username and per source address
+CREATE TABLE login_failures (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('user', 'addr', 'link', 'link_addr')),
+    subject VARCHAR(255) NOT NULL,  -- Username, remote address or share link ID
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP WITH TIME ZONE,  -- NULL when not locked
+    UNIQUE (kind, subject)
+);
+
+-- Audit log of security relevant events
+CREATE TABLE audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    action VARCHAR(64) NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    username VARCHAR(255),
+    actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    remote_addr VARCHAR(64),
+    detail TEXT,
+    operation_id VARCHAR(36),  -- Request the event happened in, shared with its change_log entries
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Webhooks posting repository changes to external services
+CREATE TABLE webhooks (
+    id SERIAL PRIMARY KEY,
+    repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret VARCHAR(128) NOT NULL,  -- HMAC-SHA256 key signing deliveries
+    paths TEXT[],       -- Path globs, empty matches all paths
+    operations TEXT[],  -- Change log operations, empty matches all
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_delivery_at TIMESTAMP WITH TIME ZONE,
+    last_status INTEGER,  -- HTTP status of the last delivery, NULL when it failed before a response
+    last_error TEXT
+);
+
+CREATE TABLE federated_shares (
+    id SERIAL PRIMARY KEY,
+    repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    path TEXT NOT NULL,
+    share_with VARCHAR(255) NOT NULL,  -- Recipient as user@host
+    server TEXT NOT NULL,              -- Base URL of the recipient's server
+    secret VARCHAR(64) NOT NULL UNIQUE,  -- Presented by the recipient's server to access the folder
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE remote_shares (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,  -- Mounted as /federated/{name}
+    owner VARCHAR(255) NOT NULL,  -- Sharing user as user@host
+    server TEXT NOT NULL,
+    remote_id VARCHAR(64) NOT NULL,  -- ID of the share on its server
+    secret VARCHAR(64) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, name),
+    UNIQUE (server, remote_id)
+);
+
 -- Indexes for better query performance
 CREATE INDEX idx_users_username ON users (username);
 CREATE INDEX idx_users_email ON users (email);
 CREATE INDEX idx_repositories_owner_id ON repositories (owner_id);
 CREATE INDEX idx_repositories_name ON repositories (name);
+CREATE INDEX idx_repository_aliases_repo_id ON repository_aliases (repo_id);
 CREATE INDEX idx_files_owner_id ON files (owner_id);
 CREATE INDEX idx_files_repo_id ON files (repo_id);
 CREATE INDEX idx_files_path ON files (path);
@@ -75,13 +326,52 @@ CREATE UNIQUE INDEX idx_files_repo_id_path ON files (repo_id, path);
 CREATE INDEX idx_shares_user_id ON shares (user_id);
 CREATE INDEX idx_shares_repo_id ON shares (repo_id);
 CREATE INDEX idx_user_quota_user_id ON user_quota (user_id);
+CREATE INDEX idx_transfer_usage_month ON transfer_usage (month);
+CREATE INDEX idx_shares_expires_at ON shares (expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX idx_share_links_owner_id ON share_links (owner_id);
+CREATE INDEX idx_share_links_expires_at ON share_links (expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX idx_snapshot_files_parent ON snapshot_files (link_id, parent);
+CREATE INDEX idx_notifications_user_id ON notifications (user_id, read);
+CREATE INDEX idx_notify_routes_user_id ON notify_routes (user_id);
+CREATE INDEX idx_app_passwords_user_id ON app_passwords (user_id);
+CREATE INDEX idx_users_digest ON users (id) WHERE digest <> '';
+CREATE INDEX idx_files_repo_id_mod_time ON files (repo_id, mod_time) WHERE NOT is_dir;
+CREATE INDEX idx_files_unchecksummed ON files (id) WHERE checksum IS NULL AND NOT is_dir AND NOT deleted;
+CREATE INDEX idx_files_repo_id_accessed ON files (repo_id, GREATEST(accessed_at, updated_at)) WHERE cold_root IS NULL AND checksum IS NOT NULL AND NOT is_dir AND NOT deleted;
+CREATE INDEX idx_trash_repo_id ON trash (repo_id);
+CREATE INDEX idx_trash_deleted_at ON trash (deleted_at);
+CREATE INDEX idx_audit_log_user_id ON audit_log (user_id);
+CREATE INDEX idx_audit_log_created_at ON audit_log (created_at);
+CREATE INDEX idx_audit_log_operation_id ON audit_log (operation_id);
+CREATE INDEX idx_webhooks_repo_id ON webhooks (repo_id);
+CREATE INDEX idx_subscriptions_repo_id ON subscriptions (repo_id);
+CREATE INDEX idx_federated_shares_owner_id ON federated_shares (owner_id);
+CREATE INDEX idx_file_text_repo_id ON file_text (repo_id);
+CREATE INDEX idx_file_text_tsv ON file_text USING GIN (tsv);
 
 -- Comments for documentation
 COMMENT ON TABLE users IS 'User accounts and authentication information';
 COMMENT ON TABLE repositories IS 'File repositories owned by users';
+COMMENT ON TABLE repository_aliases IS 'Former names of renamed repositories';
 COMMENT ON TABLE files IS 'Metadata for files and directories stored in repositories';
 COMMENT ON TABLE shares IS 'Shared access to repository paths for specific users';
 COMMENT ON TABLE user_quota IS 'Storage quota management for users';
+COMMENT ON TABLE share_links IS 'Token based links granting access to repository paths';
+COMMENT ON TABLE notifications IS 'Notifications delivered to users';
+COMMENT ON TABLE transfer_usage IS 'Bytes each user uploaded and downloaded per month, for usage reports and transfer caps';
+COMMENT ON TABLE notify_routes IS 'Per user routes delivering notifications through email, Slack, Matrix or Telegram';
+COMMENT ON TABLE expiry_rules IS 'Per folder rules deleting files after a maximum age';
+COMMENT ON TABLE follows IS 'Folders whose changes users follow in their activity digest';
+COMMENT ON TABLE subscriptions IS 'Per path subscriptions delivering changes by digest, webhook or websocket';
+COMMENT ON TABLE file_text IS 'Text extracted from images and PDFs for full text search';
+COMMENT ON TABLE organize_rules IS 'Per folder rules filing photos and videos by the date they were taken';
+COMMENT ON TABLE trash IS 'Deleted files awaiting restore or purge';
+COMMENT ON TABLE blobs IS 'Deduplicated file content with the number of paths holding it';
+COMMENT ON TABLE login_failures IS 'Failed login counters and lockout state';
+COMMENT ON TABLE audit_log IS 'Audit trail of security relevant events';
+COMMENT ON TABLE webhooks IS 'Per repository webhooks notified of file changes';
+COMMENT ON TABLE federated_shares IS 'Folders shared with users of other file-hub servers';
+COMMENT ON TABLE remote_shares IS 'Folders of other file-hub servers shared with local users';
 
 -- Relations documentation
 /*
@@ -92,11 +382,29 @@ users table is the central identity table
   - files table references users via owner_id (many-to-one)
   - shares table references users via owner_id and user_id (many-to-many)
   - user_quota table references users via user_id (one-to-one)
+  - share_links table references users via owner_id (many-to-one)
+  - notifications table references users via user_id (many-to-one)
+  - notify_routes table references users via user_id (many-to-one)
+  - transfer_usage table references users via user_id (many-to-one)
+  - follows table references users via user_id (many-to-one)
+  - subscriptions table references users via user_id (many-to-one)
+  - audit_log table references users via user_id and actor_id (many-to-one)
 
 repositories table
+  - repository_aliases table references repositories via repo_id (many-to-one)
   - files table references repositories via repo_id (many-to-one)
   - shares table references repositories via repo_id (many-to-one)
+  - share_links table references repositories via repo_id (many-to-one)
+  - expiry_rules table references repositories via repo_id (many-to-one)
+  - follows table references repositories via repo_id (many-to-one)
+  - subscriptions table references repositories via repo_id (many-to-one)
+  - file_text table references repositories via repo_id (many-to-one)
+  - organize_rules table references repositories via repo_id (many-to-one)
+  - trash table references repositories via repo_id (many-to-one)
+  - webhooks table references repositories via repo_id (many-to-one)
 
 files table stores metadata about files and directories
   - parent_id references other files for hierarchical structure
+  - file_text table references files via file_id (one-to-one)
+  - checksum names the blobs row holding the content of files in dedup storage roots (many-to-one)
 */
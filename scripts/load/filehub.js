@@ -0,0 +1,101 @@
+// k6 load scenario for File Hub: PROPFIND on a large directory, concurrent chunked uploads
+// and change polling, with the target numbers of docs/PERFORMANCE.md as thresholds.
+//
+//   k6 run -e BASE_URL=http://localhost:8080 -e FILEHUB_USER=load -e FILEHUB_PASSWORD=secret scripts/load/filehub.js
+//
+// The user needs a repository, their home one unless FILEHUB_REPO is set. Files are written below /load.
+
+import http from 'k6/http';
+import { check, fail } from 'k6';
+import encoding from 'k6/encoding';
+
+const BASE_URL = __ENV.BASE_URL || 'http://localhost:8080';
+const USER = __ENV.FILEHUB_USER || 'load';
+const PASSWORD = __ENV.FILEHUB_PASSWORD || '';
+const REPO = __ENV.FILEHUB_REPO || USER;
+const DURATION = __ENV.DURATION || '1m';
+
+const DIR_ENTRIES = parseInt(__ENV.DIR_ENTRIES || '2000');
+const UPLOAD_SIZE = parseInt(__ENV.UPLOAD_SIZE || String(8 << 20));
+const CHUNK_SIZE = parseInt(__ENV.CHUNK_SIZE || String(1 << 20));
+
+const params = (name, extra = {}) => ({
+  headers: Object.assign({ Authorization: 'Basic ' + encoding.b64encode(`${USER}:${PASSWORD}`) }, extra),
+  tags: { name },
+});
+
+const dav = (path) => `${BASE_URL}/dav/${REPO}${path}`;
+const api = (path, query) =>
+  `${BASE_URL}/api/sync/${path}?` + Object.entries(query).map(([k, v]) => `${k}=${encodeURIComponent(v)}`).join('&');
+
+export const options = {
+  scenarios: {
+    propfind: { executor: 'constant-vus', exec: 'propfind', vus: 5, duration: DURATION },
+    uploads: { executor: 'constant-vus', exec: 'upload', vus: 10, duration: DURATION },
+    polling: { executor: 'constant-arrival-rate', exec: 'poll', rate: 50, timeUnit: '1s', duration: DURATION, preAllocatedVUs: 20 },
+  },
+  thresholds: {
+    'http_req_failed': ['rate<0.01'],
+    'http_req_duration{name:propfind}': ['p(95)<500'],
+    'http_req_duration{name:chunk}': ['p(95)<200'],
+    'http_req_duration{name:finalize}': ['p(95)<2000'],
+    'http_req_duration{name:changes}': ['p(95)<100'],
+  },
+};
+
+// setup fills /load/big with DIR_ENTRIES small files, unless an earlier run already did
+export function setup() {
+  http.request('MKCOL', dav('/load/'), null, params('setup'));
+  const res = http.request('MKCOL', dav('/load/big/'), null, params('setup'));
+  if (res.status === 405) {
+    return; // already there
+  }
+  if (res.status !== 201) {
+    fail(`MKCOL /load/big failed: ${res.status} ${res.body}`);
+  }
+
+  for (let start = 0; start < DIR_ENTRIES; start += 100) {
+    const batch = [];
+    for (let i = start; i < Math.min(start + 100, DIR_ENTRIES); i++) {
+      batch.push(['PUT', dav(`/load/big/file-${String(i).padStart(6, '0')}.txt`), `entry ${i}\n`, params('setup')]);
+    }
+    http.batch(batch);
+  }
+}
+
+export function propfind() {
+  const res = http.request('PROPFIND', dav('/load/big/'), null, params('propfind', { Depth: '1' }));
+  check(res, { 'propfind 207': (r) => r.status === 207 });
+}
+
+const chunk = new Uint8Array(CHUNK_SIZE).fill(120).buffer;
+
+export function upload() {
+  const path = `/load/upload-${__VU}-${__ITER}.bin`;
+  let res = http.post(api('upload/begin', { repo: REPO, path, total_size: UPLOAD_SIZE, chunk_size: CHUNK_SIZE }), null, params('begin'));
+  if (!check(res, { 'begin 200': (r) => r.status === 200 })) {
+    return;
+  }
+
+  const session = res.json();
+  for (let i = 0; i < session.total_chunks; i++) {
+    const size = Math.min(session.chunk_size, UPLOAD_SIZE - i * session.chunk_size);
+    res = http.post(api('upload/chunk', { upload_id: session.upload_id, chunk_index: i }), chunk.slice(0, size),
+      params('chunk', { 'Content-Type': 'application/octet-stream' }));
+    check(res, { 'chunk 200': (r) => r.status === 200 });
+  }
+
+  res = http.post(api('upload/finalize', { upload_id: session.upload_id, repo: REPO }), null, params('finalize'));
+  check(res, { 'finalize 200': (r) => r.status === 200 });
+}
+
+// each VU polls like a client: from the start of the change log, then following next_cursor
+let cursor = null;
+
+export function poll() {
+  const query = cursor ? { repo: REPO, cursor, limit: 100 } : { repo: REPO, since: '', limit: 100 };
+  const res = http.get(api('changes', query), params('changes'));
+  if (check(res, { 'changes 200': (r) => r.status === 200 })) {
+    cursor = res.json('next_cursor') || cursor;
+  }
+}
@@ -1,12 +1,13 @@
 CREATE TABLE change_log (
     id SERIAL PRIMARY KEY,
     repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
-    operation VARCHAR(20) NOT NULL CHECK (operation IN ('create', 'modify', 'delete', 'move', 'copy')),
+    operation VARCHAR(20) NOT NULL CHECK (operation IN ('create', 'modify', 'delete', 'move', 'rename', 'copy')),
     path TEXT NOT NULL,
     old_path TEXT,
     user_id INTEGER NOT NULL REFERENCES users(id),
     version VARCHAR(64) NOT NULL,
-    timestamp TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+    timestamp TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    operation_id VARCHAR(36)  -- Request that made the change, shared with its audit_log entries
 );
 
 CREATE TABLE repository_versions (
@@ -26,9 +27,12 @@ CREATE TABLE upload_sessions (
     user_id INTEGER NOT NULL REFERENCES users(id),
     chunks_uploaded INTEGER DEFAULT 0,
     total_chunks INTEGER NOT NULL,
+    chunk_size BIGINT NOT NULL DEFAULT 1048576,  -- Size of every chunk but the last, negotiated at the start
+    mod_time TIMESTAMP WITH TIME ZONE,  -- Modification time given by the client, NULL for server time
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     expires_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP + INTERVAL '1 day',
-    status VARCHAR(20) DEFAULT 'active' CHECK (status IN ('active', 'completed', 'cancelled'))
+    last_activity_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,  -- Last chunk or keepalive
+    status VARCHAR(20) DEFAULT 'active' CHECK (status IN ('active', 'completed', 'cancelled', 'failed'))  -- failed sessions keep their chunks for a retry
 );
 
 CREATE TABLE upload_chunks (
@@ -42,11 +46,24 @@ CREATE TABLE upload_chunks (
     UNIQUE(upload_id, chunk_index)
 );
 
+CREATE TABLE sync_clients (
+    id SERIAL PRIMARY KEY,
+    repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id VARCHAR(128) NOT NULL,  -- Chosen by the client, X-Device-ID
+    acked_version VARCHAR(64) NOT NULL,  -- Last version the device listed changes since
+    last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(repo_id, user_id, device_id)
+);
+
 CREATE INDEX idx_change_log_repo_id ON change_log(repo_id);
 CREATE INDEX idx_change_log_path ON change_log(path);
 CREATE INDEX idx_change_log_timestamp ON change_log(timestamp DESC);
 CREATE INDEX idx_change_log_version ON change_log(version);
 CREATE INDEX idx_change_log_repo_version ON change_log(repo_id, version);
+-- Change listings filtered by folder match path prefixes with LIKE
+CREATE INDEX idx_change_log_repo_path ON change_log(repo_id, path text_pattern_ops);
+CREATE INDEX idx_change_log_operation_id ON change_log(operation_id);
 
 CREATE INDEX idx_upload_sessions_upload_id ON upload_sessions(upload_id);
 CREATE INDEX idx_upload_sessions_repo_id ON upload_sessions(repo_id);
@@ -57,5 +74,6 @@ CREATE INDEX idx_upload_chunks_upload_id ON upload_chunks(upload_id);
 
 COMMENT ON TABLE change_log IS 'Tracks all file operations for sync protocol';
 COMMENT ON TABLE repository_versions IS 'Stores version state for each repository';
+COMMENT ON TABLE sync_clients IS 'Devices syncing each repository and how far they got';
 COMMENT ON TABLE upload_sessions IS 'Chunked upload session management';
 COMMENT ON TABLE upload_chunks IS 'Individual chunk data for resumable uploads';
@@ -31,6 +31,26 @@ export async function getDavPath() {
   return _davPath || fetchDavPath();
 }
 
+let _csrfToken = null;
+
+/**
+ * Get the CSRF token of the session, which requests changing state must send
+ * in the X-CSRF-Token header
+ * @returns {Promise<string>} The CSRF token
+ */
+export async function getCsrfToken() {
+  if (_csrfToken) return _csrfToken;
+
+  const response = await fetch('/api/auth/whoami');
+  if (!response.ok) {
+    throw new Error('Failed to fetch CSRF token');
+  }
+
+  const data = await response.json();
+  _csrfToken = data.csrf_token || '';
+  return _csrfToken;
+}
+
 /**
  * Create authorization header for WebDAV requests
  * @returns {Object} Headers object with Content-Type
@@ -165,7 +185,10 @@ export async function uploadFile(path, file) {
   const davPath = await getDavPath();
 const response = await fetch(`${davPath}${fullPath}`, {
     method: 'PUT',
-    headers: createRequestHeaders(),
+    headers: {
+      ...createRequestHeaders(),
+      'X-CSRF-Token': await getCsrfToken()
+    },
     body: file
   });
 